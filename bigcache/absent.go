@@ -0,0 +1,58 @@
+package bigcache
+
+import (
+	"bytes"
+	"errors"
+	"time"
+)
+
+// errPresent stops an update of an entry that is already stored
+var errPresent = errors.New("entry is present")
+
+// SetIfAbsent saves entry under the key only when there is no live entry under it yet, checked and written with the
+// shard locked so of concurrent callers exactly one stores its entry. It returns whether the entry was stored
+func (c *BigCache) SetIfAbsent(key string, entry []byte, duration time.Duration) (bool, error) {
+	expiryTimestamp := NO_EXPIRY
+	if duration != time.Duration(NO_EXPIRY) {
+		expiryTimestamp = uint64(c.clock.epoch()) + uint64(duration.Seconds())
+	}
+	return c.setIfAbsentAt(key, entry, expiryTimestamp)
+}
+
+// SetIfAbsentUntil is SetIfAbsent for an entry expiring at the given wall-clock time, which must be in the future
+func (c *BigCache) SetIfAbsentUntil(key string, entry []byte, expireAt time.Time) (bool, error) {
+	expiryTimestamp := expireAt.Unix()
+	if expiryTimestamp <= c.clock.epoch() {
+		return false, ErrExpiryInPast
+	}
+	return c.setIfAbsentAt(key, entry, uint64(expiryTimestamp))
+}
+
+func (c *BigCache) setIfAbsentAt(key string, entry []byte, expiryTimestamp uint64) (bool, error) {
+	if err := checkValueSize(key, entry, c.config.MaxValueSize); err != nil {
+		return false, err
+	}
+	c.makeRoom(entrySize(key, entry))
+	hashedKey := c.hash.Sum64(key)
+	shard := c.getShard(hashedKey)
+	_, err := shard.update(key, hashedKey, func(current []byte, expiry uint64, found bool) ([]byte, uint64, error) {
+		if found {
+			return nil, 0, errPresent
+		}
+		return entry, expiryTimestamp, nil
+	})
+	if err == errPresent {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// DeleteIfEqual removes the key only when its live entry is equal to entry, checked and removed with the shard
+// locked so a newer entry written meanwhile is never removed. It returns whether the key was removed
+func (c *BigCache) DeleteIfEqual(key string, entry []byte) (bool, error) {
+	hashedKey := c.hash.Sum64(key)
+	shard := c.getShard(hashedKey)
+	return shard.delIf(key, hashedKey, func(current []byte) bool {
+		return bytes.Equal(current, entry)
+	})
+}
@@ -0,0 +1,37 @@
+package bigcache
+
+import "time"
+
+// AppendBytes adds data to the end of the entry under the key, creating it if there is none, without the race of
+// a Get followed by a Set. a new entry expires after duration, an existing one keeps its expiry. it returns the
+// expiry timestamp of the entry
+func (c *BigCache) AppendBytes(key string, data []byte, duration time.Duration) (uint64, error) {
+	expiryTimestamp := NO_EXPIRY
+	if duration != time.Duration(NO_EXPIRY) {
+		expiryTimestamp = uint64(c.clock.epoch()) + uint64(duration.Seconds())
+	}
+	return c.appendBytesAt(key, data, expiryTimestamp)
+}
+
+// AppendBytesUntil is AppendBytes for a new entry expiring at the given wall-clock time, which must be in the future
+func (c *BigCache) AppendBytesUntil(key string, data []byte, expireAt time.Time) (uint64, error) {
+	expiryTimestamp := expireAt.Unix()
+	if expiryTimestamp <= c.clock.epoch() {
+		return 0, ErrExpiryInPast
+	}
+	return c.appendBytesAt(key, data, uint64(expiryTimestamp))
+}
+
+func (c *BigCache) appendBytesAt(key string, data []byte, expiryTimestamp uint64) (uint64, error) {
+	c.makeRoom(entrySize(key, data))
+	hashedKey := c.hash.Sum64(key)
+	shard := c.getShard(hashedKey)
+	return shard.update(key, hashedKey, func(entry []byte, expiry uint64, found bool) ([]byte, uint64, error) {
+		if !found {
+			expiry = expiryTimestamp
+		}
+		appended := make([]byte, len(entry)+len(data))
+		copy(appended[copy(appended, entry):], data)
+		return appended, expiry, nil
+	})
+}
@@ -1,61 +1,92 @@
 package bigcache
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	minimumEntriesInShard = 10 // Minimum number of entries in single shard
+	minimumEntriesInShard  = 10                     // Minimum number of entries in single shard
+	defaultCompactDuration = 100 * time.Millisecond // Time a background compaction is given when CompactDuration is not set
 )
 
+// ErrExpiryInPast is returned when an entry is given an absolute expiry time that has already passed
+var ErrExpiryInPast = errors.New("expiry time is not in the future")
+
+// ErrShardFull is returned when an entry does not fit in its shard, which reached HardMaxCacheSize, even after
+// evicting its oldest entries. use errors.Is to check for it, it may come wrapped in an EntryLostError
+var ErrShardFull = errors.New("shard is full, maximum size limit reached")
+
 // BigCache is fast, concurrent, evicting cache created to keep big number of entries without impact on performance.
 // It keeps entries on heap but omits GC for them. To achieve that operations on bytes arrays take place,
 // therefore entries (de)serialization in front of the cache will be needed in most use cases.
 type BigCache struct {
-	shards       []*cacheShard
+	table        atomic.Value // *shardTable, replaced as a whole by Reshard
 	lifeWindow   uint64
 	clock        clock
 	hash         Hasher
 	config       Config
-	shardMask    uint64
+	onRemove     onRemoveCallback
 	maxShardSize uint32
+	maxBytes     int        // HardMaxCacheSize in bytes, 0 for no limit
+	evictLock    sync.Mutex // held while making room so concurrent writes do not evict for one another
+	sequence     uint64     // latest write, the shards use it to order their writes when a limit is set
+	compactFrom  uint32     // shard the last compaction started with
+	reshardLock  sync.Mutex // held while resharding so only one runs at a time
+
+	done       chan struct{} // closed by Close to stop the background compaction
+	closeOnce  sync.Once
+	background sync.WaitGroup // the background compaction, waited for by Close
 }
 
 // NewBigCache initialize new instance of BigCache
 func NewBigCache(config Config) (*BigCache, error) {
-	return newBigCache(config, &systemClock{})
+	return newBigCache(config)
 }
 
-func newBigCache(config Config, clock clock) (*BigCache, error) {
+func newBigCache(config Config) (*BigCache, error) {
 
-	if !isPowerOfTwo(config.Shards) {
-		return nil, fmt.Errorf("Shards number must be power of two")
+	if errs := config.Validate(); len(errs) > 0 {
+		return nil, &ConfigError{Errors: errs}
 	}
 
 	if config.Hasher == nil {
 		config.Hasher = newDefaultHasher()
 	}
+	if config.Clock == nil {
+		config.Clock = SystemClock()
+	}
 
 	cache := &BigCache{
-		shards:       make([]*cacheShard, config.Shards),
 		lifeWindow:   uint64(config.LifeWindow.Seconds()),
-		clock:        clock,
+		clock:        clock{config.Clock},
 		hash:         config.Hasher,
 		config:       config,
-		shardMask:    uint64(config.Shards - 1),
 		maxShardSize: uint32(config.maximumShardSize()),
+		maxBytes:     convertMBToBytes(config.HardMaxCacheSize),
+		done:         make(chan struct{}),
 	}
 
-	var onRemove func(wrappedEntry []byte)
-	if config.OnRemove == nil {
-		onRemove = cache.notProvidedOnRemove
+	if config.OnRemoveWithReason != nil {
+		cache.onRemove = cache.providedOnRemoveWithReason
+	} else if config.OnRemove != nil {
+		cache.onRemove = cache.providedOnRemove
 	} else {
-		onRemove = cache.providedOnRemove
+		cache.onRemove = cache.notProvidedOnRemove
 	}
 
-	for i := 0; i < config.Shards; i++ {
-		cache.shards[i] = initNewShard(config, onRemove, clock, uint64(i+1))
+	table, err := cache.newShardTable(config, 1)
+	if err != nil {
+		return nil, err
+	}
+	cache.table.Store(table)
+
+	if config.CompactInterval > 0 {
+		cache.background.Add(1)
+		go cache.compactInBackground()
 	}
 
 	return cache, nil
@@ -68,13 +99,72 @@ func (c *BigCache) Get(key string) ([]byte, error) {
 	return shard.get(key, hashedKey)
 }
 
+// GetWithExpiry reads entry for the key along with the unix time it expires at, NO_EXPIRY if it never does
+func (c *BigCache) GetWithExpiry(key string) ([]byte, uint64, error) {
+	hashedKey := c.hash.Sum64(key)
+	shard := c.getShard(hashedKey)
+	return shard.getWithExpiry(key, hashedKey)
+}
+
+// AppendValue appends the entry for the key to dst and returns the extended buffer along with the unix time the entry
+// expires at, NO_EXPIRY if it never does. It is GetWithExpiry for callers reusing their buffers, no memory is
+// allocated when dst has room for the entry
+func (c *BigCache) AppendValue(dst []byte, key string) ([]byte, uint64, error) {
+	hashedKey := c.hash.Sum64(key)
+	shard := c.getShard(hashedKey)
+	if dst == nil { //nil is how the shard is told to allocate
+		dst = []byte{}
+	}
+	return shard.appendWithExpiry(dst, key, hashedKey)
+}
+
+// TTL returns the time left before the entry for the key expires, time.Duration(NO_EXPIRY) if it never does.
+// an entry whose expiry has passed but which is not evicted yet is not found
+func (c *BigCache) TTL(key string) (time.Duration, error) {
+	hashedKey := c.hash.Sum64(key)
+	expiry, err := c.getShard(hashedKey).getExpiry(key, hashedKey)
+	if err != nil {
+		return 0, err
+	}
+	if expiry == NO_EXPIRY {
+		return time.Duration(NO_EXPIRY), nil
+	}
+
+	now := uint64(c.clock.epoch())
+	if expiry <= now {
+		return 0, notFound(key)
+	}
+	return time.Duration(expiry-now) * time.Second, nil
+}
+
 // Set saves entry under the key
 func (c *BigCache) Set(key string, entry []byte, duration time.Duration) (uint64, error) {
+	if err := checkValueSize(key, entry, c.config.MaxValueSize); err != nil {
+		return 0, err
+	}
+	c.makeRoom(entrySize(key, entry))
 	hashedKey := c.hash.Sum64(key)
 	shard := c.getShard(hashedKey)
 	return shard.set(key, hashedKey, entry, duration)
 }
 
+// SetUntil saves entry under the key and expires it at the given wall-clock time.
+// The expiry has a one second resolution and must be in the future.
+func (c *BigCache) SetUntil(key string, entry []byte, expireAt time.Time) (uint64, error) {
+	expiryTimestamp := expireAt.Unix()
+	if expiryTimestamp <= c.clock.epoch() {
+		return 0, ErrExpiryInPast
+	}
+	if err := checkValueSize(key, entry, c.config.MaxValueSize); err != nil {
+		return 0, err
+	}
+
+	c.makeRoom(entrySize(key, entry))
+	hashedKey := c.hash.Sum64(key)
+	shard := c.getShard(hashedKey)
+	return shard.setAt(key, hashedKey, entry, uint64(expiryTimestamp), false)
+}
+
 // Delete removes the key
 func (c *BigCache) Delete(key string) error {
 	hashedKey := c.hash.Sum64(key)
@@ -84,25 +174,123 @@ func (c *BigCache) Delete(key string) error {
 
 // Reset empties all cache shards
 func (c *BigCache) Reset() error {
-	for _, shard := range c.shards {
-		shard.reset(c.config)
+	table := c.shardTable()
+	for _, shard := range table.all() {
+		shard.reset(table.config)
 	}
 	return nil
 }
 
+// Close stops the background compaction and the goroutines expiring the entries of the shards, waiting for them
+// to return, then unmaps and closes the files the shards are kept in when MmapDir is set, the entries are gone
+// afterwards and no more can be stored. Only the goroutines are stopped for a cache on the heap
+func (c *BigCache) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	c.background.Wait()
+
+	var err error
+	for _, shard := range c.shardTable().all() {
+		shard.ttlTable.stop()
+		if e := shard.close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
 // Len computes number of entries in cache
 func (c *BigCache) Len() int {
 	var len int
-	for _, shard := range c.shards {
+	for _, shard := range c.shardTable().all() {
 		len += shard.len()
 	}
 	return len
 }
 
+// LiveBytes returns the number of bytes held by the entries stored in the cache, headers included
+func (c *BigCache) LiveBytes() int {
+	var size int
+	for _, shard := range c.shardTable().all() {
+		size += shard.size()
+	}
+	return size
+}
+
+// Capacity returns the number of bytes allocated by the queues of all shards
+func (c *BigCache) Capacity() int {
+	var capacity int
+	for _, shard := range c.shardTable().all() {
+		capacity += shard.capacity()
+	}
+	return capacity
+}
+
+// Compact moves entries over the holes left by deleted ones, so the space they held is at the end of the
+// queues of the shards again, for at most maxDuration or until ctx is done. The shards are compacted in small
+// batches so reads and writes are only held up briefly, starting with a different shard every call. Returns the
+// bytes reclaimed and whether holes remain, so it can be called again in the next quiet period
+func (c *BigCache) Compact(ctx context.Context, maxDuration time.Duration) (int, bool) {
+	deadline := c.clock.Now().Add(maxDuration)
+	done := func() bool {
+		return ctx.Err() != nil || !c.clock.Now().Before(deadline)
+	}
+
+	reclaimed, more := 0, false
+	shards := c.shardTable().all()
+	first := int(atomic.AddUint32(&c.compactFrom, 1)) //start with another shard every time so none is left out
+	for x := range shards {
+		shard := shards[(first+x)%len(shards)]
+		if done() { //out of time, only find out if the shards left have holes
+			more = more || shard.fragmented() > 0
+			continue
+		}
+		n, m := shard.compact(done)
+		reclaimed += n
+		more = more || m
+	}
+	return reclaimed, more
+}
+
+// compactInBackground compacts the cache every CompactInterval for at most CompactDuration until Close
+func (c *BigCache) compactInBackground() {
+	defer c.background.Done()
+	duration := c.config.CompactDuration
+	if duration <= 0 {
+		duration = defaultCompactDuration
+	}
+
+	ticker := c.clock.NewTicker(c.config.CompactInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C():
+			c.Compact(context.Background(), duration)
+		}
+	}
+}
+
+// MemoryUsage estimates the memory held by the cache. Unlike Capacity it accounts for the hashmaps indexing the
+// entries and for the keys kept to expire them, which matter for caches of many small entries
+func (c *BigCache) MemoryUsage() MemoryUsage {
+	var m MemoryUsage
+	table := c.shardTable()
+	for _, shard := range table.all() {
+		tmp := shard.memory(table.config.initialShardSize())
+		m.Queues += tmp.Queues
+		m.Index += tmp.Index
+		m.TTL += tmp.TTL
+		m.Buffers += tmp.Buffers
+	}
+	m.Total = m.Queues + m.Index + m.TTL + m.Buffers
+	return m
+}
+
 // Stats returns cache's statistics
 func (c *BigCache) Stats() Stats {
 	var s Stats
-	for _, shard := range c.shards {
+	for _, shard := range c.shardTable().all() {
 		tmp := shard.getStats()
 		s.Hits += tmp.Hits
 		s.Misses += tmp.Misses
@@ -110,10 +298,29 @@ func (c *BigCache) Stats() Stats {
 		s.DelMisses += tmp.DelMisses
 		s.Collisions += tmp.Collisions
 		s.EvictCount += tmp.EvictCount
+		s.Expired += tmp.Expired
+		s.Evicted += tmp.Evicted
+		s.Deleted += tmp.Deleted
+		s.Overwritten += tmp.Overwritten
+		s.Cleared += tmp.Cleared
+		s.NoSpace += tmp.NoSpace
+		s.Reclaimed += tmp.Reclaimed
+		s.BytesUsed += tmp.BytesUsed
 	}
 	return s
 }
 
+// ShardStats returns the statistics of every shard, in the order of the shards, to tell whether some shards are
+// hit or filled much more than others
+func (c *BigCache) ShardStats() []ShardStats {
+	shards := c.shardTable().all()
+	stats := make([]ShardStats, len(shards))
+	for x, shard := range shards {
+		stats[x] = shard.shardStats()
+	}
+	return stats
+}
+
 // Iterator returns iterator function to iterate over EntryInfo's from whole cache.
 func (c *BigCache) Iterator() *EntryInfoIterator {
 	return newIterator(c)
@@ -129,12 +336,21 @@ func (c *BigCache) onEvict(oldestEntry []byte, currentTimestamp uint64, evict fu
 }
 
 func (c *BigCache) getShard(hashedKey uint64) (shard *cacheShard) {
-	return c.shards[hashedKey&c.shardMask]
+	table := c.shardTable()
+	shard = table.shards[hashedKey&table.mask]
+	if former := table.former; former != nil { // resharding, the entry is brought over before it is used
+		c.moveEntry(former.shards[hashedKey&former.mask], shard, hashedKey)
+	}
+	return shard
 }
 
-func (c *BigCache) providedOnRemove(wrappedEntry []byte) {
+func (c *BigCache) providedOnRemove(wrappedEntry []byte, reason RemoveReason) {
 	c.config.OnRemove(readKeyFromEntry(wrappedEntry), readEntry(wrappedEntry))
 }
 
-func (c *BigCache) notProvidedOnRemove(wrappedEntry []byte) {
+func (c *BigCache) providedOnRemoveWithReason(wrappedEntry []byte, reason RemoveReason) {
+	c.config.OnRemoveWithReason(readKeyFromEntry(wrappedEntry), readEntry(wrappedEntry), reason)
+}
+
+func (c *BigCache) notProvidedOnRemove(wrappedEntry []byte, reason RemoveReason) {
 }
@@ -0,0 +1,176 @@
+package bigcache
+
+import (
+	"sync/atomic"
+)
+
+// RemoveReason tells why an entry was removed from the cache
+type RemoveReason uint32
+
+const (
+	// Expired means the entry reached its expiry time
+	Expired RemoveReason = iota + 1
+	// NoSpace means the entry was evicted to make room for a new one within HardMaxCacheSize
+	NoSpace
+	// Deleted means the entry was deleted
+	Deleted
+)
+
+// number of stale records a shard keeps in the order of its writes before it drops them
+const minStaleRecords = 1024
+
+// writeRecord places an entry in the order of the writes across all shards
+type writeRecord struct {
+	seq  uint64
+	hash uint64
+}
+
+// String returns the name of the reason
+func (r RemoveReason) String() string {
+	switch r {
+	case Expired:
+		return "expired"
+	case NoSpace:
+		return "noSpace"
+	case Deleted:
+		return "deleted"
+	}
+	return "unknown"
+}
+
+// makeRoom evicts the oldest entries of the whole cache, whichever shard they are in, until size more bytes fit
+// within HardMaxCacheSize. Entries larger than the whole budget are left to fail to be stored
+func (c *BigCache) makeRoom(size int) {
+	if c.maxBytes == 0 || size > c.maxBytes {
+		return
+	}
+
+	c.evictLock.Lock()
+	defer c.evictLock.Unlock()
+
+	for c.LiveBytes()+size > c.maxBytes {
+		var oldest *cacheShard
+		var oldestSeq uint64
+		for _, shard := range c.shardTable().all() {
+			if seq, found := shard.oldestWrite(); found && (oldest == nil || seq < oldestSeq) {
+				oldest, oldestSeq = shard, seq
+			}
+		}
+		if oldest == nil { //nothing left to evict
+			return
+		}
+		oldest.evictOldest()
+	}
+}
+
+// the size an entry takes once stored, what makeRoom is asked for before a write
+func entrySize(key string, entry []byte) int {
+	return headersSizeInBytes + len(key) + len(entry)
+}
+
+// the size a list or set grows by at most when items are added to it, what makeRoom is asked for
+func itemsSize(key string, items [][]byte) int {
+	size := headersSizeInBytes + len(key)
+	for _, item := range items {
+		size += len(item) + 4 //the length the item is encoded with
+	}
+	return size
+}
+
+// the size a hash grows by at most when fields are set, what makeRoom is asked for
+func fieldsSize(key string, fields map[string][]byte) int {
+	size := headersSizeInBytes + len(key)
+	for name, value := range fields {
+		size += len(name) + len(value) + 8 //the lengths the field is encoded with
+	}
+	return size
+}
+
+// written records a write of the entry under hashedKey as the newest of the cache, called with the lock held
+func (s *cacheShard) written(hashedKey uint64) {
+	if s.writes == nil {
+		return
+	}
+
+	seq := atomic.AddUint64(s.sequence, 1)
+	s.writes[hashedKey] = seq
+	s.order = append(s.order, writeRecord{seq: seq, hash: hashedKey})
+	if stale := len(s.order) - len(s.writes); stale > minStaleRecords && stale > len(s.writes) {
+		s.dropStaleRecords()
+	}
+}
+
+// forget drops the write of the entry under hashedKey, called with the lock held
+func (s *cacheShard) forget(hashedKey uint64) {
+	if s.writes != nil {
+		delete(s.writes, hashedKey)
+	}
+}
+
+// dropStaleRecords keeps only the records of the latest writes of live entries, called with the lock held
+func (s *cacheShard) dropStaleRecords() {
+	live := s.order[:0]
+	for _, record := range s.order {
+		if s.writes[record.hash] == record.seq {
+			live = append(live, record)
+		}
+	}
+	for x := len(live); x < len(s.order); x++ { //let go of the records past the end
+		s.order[x] = writeRecord{}
+	}
+	s.order = live
+}
+
+// skipStale drops the records at the front of the order that are not the latest write of a live entry, called
+// with the lock held. false when no record is left
+func (s *cacheShard) skipStale() bool {
+	for len(s.order) > 0 && s.writes[s.order[0].hash] != s.order[0].seq {
+		s.order = s.order[1:]
+	}
+	return len(s.order) > 0
+}
+
+// oldestWrite returns the sequence of the oldest write of a live entry of the shard
+func (s *cacheShard) oldestWrite() (uint64, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.writes == nil || !s.skipStale() {
+		return 0, false
+	}
+	return s.order[0].seq, true
+}
+
+// evictOldest evicts the oldest entry of the shard to make room for a new one
+func (s *cacheShard) evictOldest() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.evictOldestLocked()
+}
+
+// evictOldestLocked is evictOldest called with the lock held. false when there is nothing to evict
+func (s *cacheShard) evictOldestLocked() bool {
+	if s.writes == nil || !s.skipStale() {
+		return false
+	}
+
+	hashedKey := s.order[0].hash
+	s.order = s.order[1:]
+	delete(s.writes, hashedKey)
+
+	itemIndex := s.hashmap[hashedKey]
+	wrappedEntry, err := s.entries.Get(int(itemIndex))
+	if itemIndex == 0 || err != nil {
+		return true
+	}
+
+	delete(s.hashmap, hashedKey)
+	s.removed(wrappedEntry)
+	s.onRemove(wrappedEntry, NoSpace)
+	s.removedFor(NoSpace)
+	s.ttlTable.remove(readTimestampFromEntry(wrappedEntry), readKeyFromEntry(wrappedEntry))
+	resetKeyFromEntry(wrappedEntry)
+	s.delete(itemIndex)
+	return true
+}
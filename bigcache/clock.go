@@ -1,14 +1,242 @@
 package bigcache
 
-import "time"
+import (
+	"sync"
+	"time"
+)
 
-type clock interface {
-	epoch() int64
+// Clock is the source of the time read by the cache, to expire entries, and of the timers it waits on. The system
+// clock is used unless Config.Clock is set, a ManualClock lets tests move time on at will
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// NewTimer creates a Timer sending the time on its channel once d has passed
+	NewTimer(d time.Duration) Timer
+	// AfterFunc creates a Timer calling f in its own goroutine once d has passed, its channel is nil
+	AfterFunc(d time.Duration, f func()) Timer
+	// NewTicker creates a Ticker sending the time on its channel every d, which must be greater than zero
+	NewTicker(d time.Duration) Ticker
+	// Sleep blocks until d has passed
+	Sleep(d time.Duration)
+}
+
+// Timer is a time.Timer created by a Clock
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is a time.Ticker created by a Clock
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+	Reset(d time.Duration)
+}
+
+// SystemClock returns the Clock of the wall clock of the system, the default
+func SystemClock() Clock {
+	return systemClock{}
+}
+
+// Since is time.Since read from clock
+func Since(clock Clock, t time.Time) time.Duration {
+	return clock.Now().Sub(t)
+}
+
+//clock reads a Clock as the unix timestamps entries expire at
+type clock struct {
+	Clock
+}
+
+func (c clock) epoch() int64 {
+	return c.Now().Unix()
 }
 
 type systemClock struct {
 }
 
-func (c systemClock) epoch() int64 {
-	return time.Now().Unix()
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return systemTimer{time.AfterFunc(d, f)}
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+func (systemClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+type systemTicker struct {
+	*time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// ManualClock is a Clock whose time only moves on when Advance is called, firing the timers and tickers due on the
+// way in the order they are due. It makes expiry and timeouts deterministic in tests
+type ManualClock struct {
+	lock   sync.Mutex
+	now    time.Time
+	timers map[*manualTimer]struct{} //the timers and tickers not yet fired or stopped
+}
+
+// NewManualClock creates a ManualClock reading now until advanced
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now, timers: make(map[*manualTimer]struct{})}
+}
+
+// Now returns the time the clock was advanced to
+func (c *ManualClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.now
+}
+
+// Advance moves the clock on by d, firing every timer and ticker due up to the new time
+func (c *ManualClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	target := c.now.Add(d)
+	for {
+		var next *manualTimer
+		for t := range c.timers {
+			if !t.when.After(target) && (next == nil || t.when.Before(next.when)) {
+				next = t
+			}
+		}
+		if next == nil {
+			break
+		}
+		if next.when.After(c.now) {
+			c.now = next.when
+		}
+		c.fire(next)
+	}
+	c.now = target
+}
+
+// NewTimer creates a Timer firing once the clock is advanced by d
+func (c *ManualClock) NewTimer(d time.Duration) Timer {
+	return c.add(&manualTimer{clock: c, c: make(chan time.Time, 1)}, d)
+}
+
+// AfterFunc creates a Timer calling f once the clock is advanced by d
+func (c *ManualClock) AfterFunc(d time.Duration, f func()) Timer {
+	return c.add(&manualTimer{clock: c, f: f}, d)
+}
+
+// NewTicker creates a Ticker firing every time the clock is advanced by d
+func (c *ManualClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	return manualTicker{c.add(&manualTimer{clock: c, c: make(chan time.Time, 1), period: d}, d)}
+}
+
+// Sleep blocks until the clock is advanced by d
+func (c *ManualClock) Sleep(d time.Duration) {
+	<-c.NewTimer(d).C()
+}
+
+//schedule a timer d from now, fired straight away when already due
+func (c *ManualClock) add(t *manualTimer, d time.Duration) *manualTimer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	t.when = c.now.Add(d)
+	c.timers[t] = struct{}{}
+	if d <= 0 {
+		c.fire(t)
+	}
+	return t
+}
+
+//fire a due timer, scheduling the next tick of tickers. called with the lock held
+func (c *ManualClock) fire(t *manualTimer) {
+	if t.period > 0 {
+		t.when = t.when.Add(t.period)
+	} else {
+		delete(c.timers, t)
+	}
+
+	if t.f != nil {
+		go t.f()
+		return
+	}
+	select { //dropped like the ticks of a time.Ticker when the last one was not received
+	case t.c <- c.now:
+	default:
+	}
+}
+
+type manualTimer struct {
+	clock  *ManualClock
+	c      chan time.Time
+	f      func()
+	when   time.Time
+	period time.Duration //of tickers, 0 for timers
+}
+
+func (t *manualTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *manualTimer) Stop() bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+
+	_, active := t.clock.timers[t]
+	delete(t.clock.timers, t)
+	return active
+}
+
+func (t *manualTimer) Reset(d time.Duration) bool {
+	t.clock.lock.Lock()
+	_, active := t.clock.timers[t]
+	delete(t.clock.timers, t)
+	t.clock.lock.Unlock()
+
+	t.clock.add(t, d)
+	return active
+}
+
+type manualTicker struct {
+	*manualTimer
+}
+
+func (t manualTicker) Stop() {
+	t.manualTimer.Stop()
+}
+
+func (t manualTicker) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("non-positive interval for Ticker.Reset")
+	}
+	t.clock.lock.Lock()
+	t.period = d
+	t.clock.lock.Unlock()
+
+	t.manualTimer.Reset(d)
 }
@@ -1,6 +1,10 @@
 package bigcache
 
-import "time"
+import (
+	"fmt"
+	"os"
+	"time"
+)
 
 // Config for BigCache
 type Config struct {
@@ -11,11 +15,34 @@ type Config struct {
 	// Interval between removing expired entries (clean up).
 	// If set to <= 0 then no action is performed. Setting to < 1 second is counterproductive — bigcache has a one second resolution.
 	CleanWindow time.Duration
+	// Interval between background compactions, moving entries over the holes left by deleted ones.
+	// If set to <= 0 then the cache is only compacted when Compact is called.
+	CompactInterval time.Duration
+	// Time a background compaction is given at most, shards not reached are compacted by the next one.
+	// Defaults to 100ms.
+	CompactDuration time.Duration
+	// Number of stripes the lock of every shard is split in, must be a power of two. Reads of a key only take the
+	// stripe of the key, so many cores reading the same shard do not contend on a single lock, while writes take
+	// every stripe and so get slower with more of them. Worth raising for read heavy loads on many cores.
+	// Default value is 0 which means a single lock per shard.
+	LockStripes int
 	// Max number of entries in life window. Used only to calculate initial size for cache shards.
 	// When proper value is set then additional memory allocation does not occur.
 	MaxEntriesInWindow int
 	// Max size of entry in bytes. Used only to calculate initial size for cache shards.
 	MaxEntrySize int
+	// MaxValueSize is the largest value in bytes the cache stores, writes of larger values and appends that would
+	// make a value larger fail with a ValueTooLargeError. Default value is 0 which means no limit.
+	MaxValueSize int
+	// IndexKeys keeps a trie of the keys of every shard, so CountPrefix and KeysWithPrefix only go over the keys
+	// with the prefix rather than over the whole cache, at the cost of memory and time on every write and removal.
+	// Default value is false which means the keys are not indexed and looking them up by prefix fails.
+	IndexKeys bool
+	// Directory the queues of the shards are kept in, one file per shard mapped to memory rather than on the heap,
+	// so the cache can outgrow the memory of the machine. The files are truncated when the cache is created, use
+	// a snapshot to keep the entries over a restart. Close must be called once the cache is no longer used.
+	// Default value is "" which means the queues are on the heap.
+	MmapDir string
 	// Verbose mode prints information about new memory allocation
 	Verbose bool
 	// Hasher used to map between string keys and unsigned 64bit integers, by default fnv64 hashing is used.
@@ -23,11 +50,26 @@ type Config struct {
 	// HardMaxCacheSize is a limit for cache size in MB. Cache will not allocate more memory than this limit.
 	// It can protect application from consuming all available memory on machine, therefore from running OOM Killer.
 	// Default value is 0 which means unlimited size. When the limit is higher than 0 and reached then
-	// the oldest entries are overridden for the new ones.
+	// the oldest entries of the whole cache, whichever shard they are in, are evicted for the new ones.
+	// Entries larger than the limit are not stored.
 	HardMaxCacheSize int
 	// OnRemove is a callback fired when the oldest entry is removed because of its expiration time or no space left
 	// for the new entry. Default value is nil which means no callback and it prevents from unwrapping the oldest entry.
 	OnRemove func(key string, entry []byte)
+	// OnRemoveWithReason is OnRemove along with the reason the entry was removed for. When set OnRemove is not called.
+	OnRemoveWithReason func(key string, entry []byte, reason RemoveReason)
+	// OnEvictionPass is called by every shard after its once a second pass expiring entries, with the time the pass
+	// took and the number of entries it expired. It is called from the goroutine of the shard and must not block.
+	OnEvictionPass func(took time.Duration, expired int)
+	// LockWaitBudget is how long a read or write of a key may wait for the lock of its shard before OnSlowLock is
+	// called. 0, the default, does not time the waits.
+	LockWaitBudget time.Duration
+	// OnSlowLock is called with the shard, the hash of the key and the time waited when a read or write of the key
+	// waited for the lock of its shard longer than LockWaitBudget. It is called with the lock held and must not block.
+	OnSlowLock func(shard uint64, hashedKey uint64, waited time.Duration)
+	// Clock is the source of the time entries expire by and of the timers of the cache.
+	// Defaults to the system clock, a ManualClock makes expiry deterministic in tests.
+	Clock Clock
 
 	// Logger is a logging interface and used in combination with `Verbose`
 	// Defaults to `DefaultLogger()`
@@ -50,6 +92,38 @@ func DefaultConfig() Config {
 	}
 }
 
+// Validate returns every problem of the config, none when NewBigCache can create a cache with it
+func (c Config) Validate() []error {
+	var errs []error
+	if c.Shards < 1 || !isPowerOfTwo(c.Shards) {
+		errs = append(errs, fmt.Errorf("Shards number must be power of two"))
+	}
+	if c.LockStripes < 0 || (c.LockStripes > 0 && !isPowerOfTwo(c.LockStripes)) {
+		errs = append(errs, fmt.Errorf("LockStripes number must be power of two"))
+	}
+	for _, field := range []struct {
+		name  string
+		value int64
+	}{
+		{"LifeWindow", int64(c.LifeWindow)},
+		{"MaxEntriesInWindow", int64(c.MaxEntriesInWindow)},
+		{"MaxEntrySize", int64(c.MaxEntrySize)},
+		{"MaxValueSize", int64(c.MaxValueSize)},
+		{"HardMaxCacheSize", int64(c.HardMaxCacheSize)},
+		{"LockWaitBudget", int64(c.LockWaitBudget)},
+	} {
+		if field.value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative, got %d", field.name, field.value))
+		}
+	}
+	if c.MmapDir != "" {
+		if info, err := os.Stat(c.MmapDir); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Errorf("MmapDir %q is not a directory", c.MmapDir))
+		}
+	}
+	return errs
+}
+
 // initialShardSize computes initial shard size
 func (c Config) initialShardSize() int {
 	return max(c.MaxEntriesInWindow/c.Shards, minimumEntriesInShard)
@@ -0,0 +1,24 @@
+package bigcache
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ConfigError is returned for a configuration with problems, listing every one of them rather than the first
+type ConfigError struct {
+	Errors []error
+}
+
+// Error returned when the configuration has problems.
+func (e *ConfigError) Error() string {
+	if len(e.Errors) == 1 {
+		return e.Errors[0].Error()
+	}
+
+	problems := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		problems = append(problems, err.Error())
+	}
+	return fmt.Sprintf("%d problems with the configuration: %s", len(e.Errors), strings.Join(problems, "; "))
+}
@@ -0,0 +1,143 @@
+package bigcache
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// ErrNotCounter is returned when the entry under a key is not a counter written by CounterAdd or CounterMerge
+var ErrNotCounter = errors.New("entry is not a counter")
+
+// counters are grow-only counters (CRDT G-Counter) that nodes add to without coordinating: every node adds to a
+// count of its own, merging copies keeps the larger count of each node and the value is the sum of the counts. a
+// counter is stored as a hash of the counts by id of the node, each an 8 byte little endian number
+const counterCountSize = 8
+
+// CounterAdd adds delta to the count of the node with id in the counter under the key, creating it if there is
+// none, and returns the counts of every node after. a new counter expires after duration, an existing one keeps
+// its expiry
+func (c *BigCache) CounterAdd(key, id string, delta uint64, duration time.Duration) (map[string]uint64, error) {
+	expiryTimestamp := NO_EXPIRY
+	if duration != time.Duration(NO_EXPIRY) {
+		expiryTimestamp = uint64(c.clock.epoch()) + uint64(duration.Seconds())
+	}
+	return c.updateCounter(key, map[string]uint64{id: delta}, expiryTimestamp, func(counts map[string]uint64) {
+		counts[id] += delta
+	})
+}
+
+// CounterMerge merges counts by id of the node into the counter under the key, creating it if there is none,
+// keeping the larger count of each node, and returns the counts of every node after. merging the same counts
+// again changes nothing. a new counter expires after duration, an existing one keeps its expiry
+func (c *BigCache) CounterMerge(key string, counts map[string]uint64, duration time.Duration) (map[string]uint64, error) {
+	expiryTimestamp := NO_EXPIRY
+	if duration != time.Duration(NO_EXPIRY) {
+		expiryTimestamp = uint64(c.clock.epoch()) + uint64(duration.Seconds())
+	}
+	return c.mergeCounterAt(key, counts, expiryTimestamp)
+}
+
+// CounterMergeUntil is CounterMerge for a new counter expiring at the given wall-clock time, which must be in the
+// future
+func (c *BigCache) CounterMergeUntil(key string, counts map[string]uint64, expireAt time.Time) (map[string]uint64, error) {
+	expiryTimestamp := expireAt.Unix()
+	if expiryTimestamp <= c.clock.epoch() {
+		return nil, ErrExpiryInPast
+	}
+	return c.mergeCounterAt(key, counts, uint64(expiryTimestamp))
+}
+
+func (c *BigCache) mergeCounterAt(key string, counts map[string]uint64, expiryTimestamp uint64) (map[string]uint64, error) {
+	return c.updateCounter(key, counts, expiryTimestamp, func(current map[string]uint64) {
+		for id, count := range counts {
+			if count > current[id] {
+				current[id] = count
+			}
+		}
+	})
+}
+
+// change the counts of the counter under the key with fn, the shard locked. counts are those fn changes, which the
+// counter grows by at most
+func (c *BigCache) updateCounter(key string, counts map[string]uint64, expiryTimestamp uint64,
+	fn func(current map[string]uint64)) (map[string]uint64, error) {
+	var updated map[string]uint64
+	c.makeRoom(countsSize(key, counts))
+	hashedKey := c.hash.Sum64(key)
+	shard := c.getShard(hashedKey)
+	_, err := shard.update(key, hashedKey, func(entry []byte, expiry uint64, found bool) ([]byte, uint64, error) {
+		if !found {
+			expiry = expiryTimestamp
+		}
+		current, err := CounterCounts(entry)
+		if err != nil {
+			return nil, 0, err
+		}
+		fn(current)
+		updated = current
+		return encodeCounter(current), expiry, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// CounterValue returns the value of the counter under the key
+func (c *BigCache) CounterValue(key string) (uint64, error) {
+	entry, err := c.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return CounterSum(entry)
+}
+
+// CounterCounts returns the counts by id of the node of an encoded counter, nil being the counter never added to
+func CounterCounts(counter []byte) (map[string]uint64, error) {
+	counts := make(map[string]uint64)
+	valid := true
+	err := walkHash(counter, func(id string, data []byte) bool {
+		if len(data) != counterCountSize {
+			valid = false
+			return false
+		}
+		counts[id] = binary.LittleEndian.Uint64(data)
+		return true
+	})
+	if err != nil || !valid {
+		return nil, ErrNotCounter
+	}
+	return counts, nil
+}
+
+// CounterSum returns the value of an encoded counter, the sum of the counts of every node
+func CounterSum(counter []byte) (uint64, error) {
+	counts, err := CounterCounts(counter)
+	if err != nil {
+		return 0, err
+	}
+	sum := uint64(0)
+	for _, count := range counts {
+		sum += count
+	}
+	return sum, nil
+}
+
+func encodeCounter(counts map[string]uint64) []byte {
+	fields := make(map[string][]byte, len(counts))
+	for id, count := range counts {
+		fields[id] = make([]byte, counterCountSize)
+		binary.LittleEndian.PutUint64(fields[id], count)
+	}
+	return encodeHash(fields)
+}
+
+// the size a counter grows by at most when counts are added or merged, what makeRoom is asked for
+func countsSize(key string, counts map[string]uint64) int {
+	size := headersSizeInBytes + len(key)
+	for id := range counts {
+		size += len(id) + hashRecordSize + counterCountSize
+	}
+	return size
+}
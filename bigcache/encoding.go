@@ -1,10 +1,6 @@
 package bigcache
 
-import (
-	"encoding/binary"
-	"reflect"
-	"unsafe"
-)
+import "encoding/binary"
 
 const (
 	timestampSizeInBytes = 8                                                       // Number of bytes used for timestamp
@@ -13,6 +9,13 @@ const (
 	headersSizeInBytes   = timestampSizeInBytes + hashSizeInBytes + keySizeInBytes // Number of bytes used for all headers
 )
 
+// the highest bit of the key size flags entries caching that a key was not found, which leaves the rest for
+// the size of the key
+const (
+	notFoundFlag = 0x8000
+	keySizeMask  = notFoundFlag - 1
+)
+
 func wrapEntry(timestamp uint64, hash uint64, key string, entry []byte, buffer *[]byte) []byte {
 	keyLength := len(key)
 	blobLength := len(entry) + headersSizeInBytes + keyLength
@@ -31,8 +34,23 @@ func wrapEntry(timestamp uint64, hash uint64, key string, entry []byte, buffer *
 	return blob[:blobLength]
 }
 
-func readEntry(data []byte) []byte {
+// markNotFound flags a wrapped entry as caching that its key was not found
+func markNotFound(data []byte) {
 	length := binary.LittleEndian.Uint16(data[timestampSizeInBytes+hashSizeInBytes:])
+	binary.LittleEndian.PutUint16(data[timestampSizeInBytes+hashSizeInBytes:], length|notFoundFlag)
+}
+
+// isNotFound reports whether a wrapped entry caches that its key was not found
+func isNotFound(data []byte) bool {
+	return binary.LittleEndian.Uint16(data[timestampSizeInBytes+hashSizeInBytes:])&notFoundFlag != 0
+}
+
+func readKeyLength(data []byte) uint16 {
+	return binary.LittleEndian.Uint16(data[timestampSizeInBytes+hashSizeInBytes:]) & keySizeMask
+}
+
+func readEntry(data []byte) []byte {
+	length := readKeyLength(data)
 
 	// copy on read
 	dst := make([]byte, len(data)-int(headersSizeInBytes+length))
@@ -45,20 +63,17 @@ func readTimestampFromEntry(data []byte) uint64 {
 	return binary.LittleEndian.Uint64(data)
 }
 
+// entryData returns the entry held by a wrapped entry without copying it
+func entryData(data []byte) []byte {
+	length := readKeyLength(data)
+	return data[headersSizeInBytes+length:]
+}
+
 func readKeyFromEntry(data []byte) string {
-	length := binary.LittleEndian.Uint16(data[timestampSizeInBytes+hashSizeInBytes:])
+	length := readKeyLength(data)
 
 	// copy on read
-	dst := make([]byte, length)
-	copy(dst, data[headersSizeInBytes:headersSizeInBytes+length])
-
-	return bytesToString(dst)
-}
-
-func bytesToString(b []byte) string {
-	bytesHeader := (*reflect.SliceHeader)(unsafe.Pointer(&b))
-	strHeader := reflect.StringHeader{Data: bytesHeader.Data, Len: bytesHeader.Len}
-	return *(*string)(unsafe.Pointer(&strHeader))
+	return string(data[headersSizeInBytes : headersSizeInBytes+length])
 }
 
 func readHashFromEntry(data []byte) uint64 {
@@ -0,0 +1,20 @@
+package bigcache
+
+import "fmt"
+
+// EntryLostError is returned when a new value could not be stored over an existing one after the existing one
+// was already removed, so the key is left with no value at all rather than the old one
+type EntryLostError struct {
+	Key string
+	Err error
+}
+
+// Error returned when the previous entry of the key was lost.
+func (e *EntryLostError) Error() string {
+	return fmt.Sprintf("entry %q lost while overwriting it: %v", e.Key, e.Err)
+}
+
+// Unwrap returns the error the new value could not be stored with.
+func (e *EntryLostError) Unwrap() error {
+	return e.Err
+}
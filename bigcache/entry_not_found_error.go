@@ -1,17 +1,39 @@
 package bigcache
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrEntryNotFound is matched by every error returned when no entry was found for a key, use errors.Is to check
+// for it
+var ErrEntryNotFound = errors.New("entry not found")
+
+// ErrCachedNotFound is matched by the errors returned when the key is cached as not found by SetNotFound, they
+// match ErrEntryNotFound as well
+var ErrCachedNotFound = errors.New("entry cached as not found")
 
 // EntryNotFoundError is an error type struct which is returned when entry was not found for provided key
 type EntryNotFoundError struct {
+	Key     string
+	Cached  bool // the key is cached as not found rather than missing
 	message string
 }
 
 func notFound(key string) error {
-	return &EntryNotFoundError{fmt.Sprintf("Entry %q not found", key)}
+	return &EntryNotFoundError{Key: key, message: fmt.Sprintf("Entry %q not found", key)}
+}
+
+func cachedNotFound(key string) error {
+	return &EntryNotFoundError{Key: key, Cached: true, message: fmt.Sprintf("Entry %q cached as not found", key)}
 }
 
 // Error returned when entry does not exist.
 func (e EntryNotFoundError) Error() string {
 	return e.message
 }
+
+// Is reports whether target is ErrEntryNotFound, or ErrCachedNotFound when the key is cached as not found.
+func (e EntryNotFoundError) Is(target error) bool {
+	return target == ErrEntryNotFound || (e.Cached && target == ErrCachedNotFound)
+}
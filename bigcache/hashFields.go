@@ -0,0 +1,228 @@
+package bigcache
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"time"
+)
+
+// ErrNotHash is returned when the entry under a key is not a hash written by HSet
+var ErrNotHash = errors.New("entry is not a hash")
+
+// ErrFieldNotFound is returned when the hash under a key has no such field
+var ErrFieldNotFound = errors.New("field not found")
+
+// errUnchanged stops an update which would not change the entry
+var errUnchanged = errors.New("entry is unchanged")
+
+// hashes are stored as a single entry, a count followed by the fields sorted by name, each a record of the
+// length of its name and of its value followed by the name and the value
+const (
+	hashCountSize  = 4
+	hashRecordSize = 8
+)
+
+// HSet sets fields of the hash under the key, creating it if there is none, and returns how many were not in it
+// already. the other fields are kept as they are. a new hash expires after duration, an existing one keeps its
+// expiry
+func (c *BigCache) HSet(key string, fields map[string][]byte, duration time.Duration) (int, error) {
+	expiryTimestamp := NO_EXPIRY
+	if duration != time.Duration(NO_EXPIRY) {
+		expiryTimestamp = uint64(c.clock.epoch()) + uint64(duration.Seconds())
+	}
+	return c.hSetAt(key, fields, expiryTimestamp)
+}
+
+// HSetUntil is HSet for a new hash expiring at the given wall-clock time, which must be in the future
+func (c *BigCache) HSetUntil(key string, fields map[string][]byte, expireAt time.Time) (int, error) {
+	expiryTimestamp := expireAt.Unix()
+	if expiryTimestamp <= c.clock.epoch() {
+		return 0, ErrExpiryInPast
+	}
+	return c.hSetAt(key, fields, uint64(expiryTimestamp))
+}
+
+func (c *BigCache) hSetAt(key string, fields map[string][]byte, expiryTimestamp uint64) (int, error) {
+	added := 0
+	c.makeRoom(fieldsSize(key, fields))
+	hashedKey := c.hash.Sum64(key)
+	shard := c.getShard(hashedKey)
+	_, err := shard.update(key, hashedKey, func(entry []byte, expiry uint64, found bool) ([]byte, uint64, error) {
+		if !found {
+			expiry = expiryTimestamp
+		}
+		hash, n, err := SetHashFields(entry, fields)
+		added = n
+		return hash, expiry, err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return added, nil
+}
+
+// HDel removes fields from the hash under the key and returns how many were in it. a hash left without fields
+// is kept, empty, until it expires or is deleted
+func (c *BigCache) HDel(key string, fields []string) (int, error) {
+	removed := 0
+	hashedKey := c.hash.Sum64(key)
+	shard := c.getShard(hashedKey)
+	_, err := shard.update(key, hashedKey, func(entry []byte, expiry uint64, found bool) ([]byte, uint64, error) {
+		if !found {
+			return nil, 0, errUnchanged
+		}
+		hash, n, err := DeleteHashFields(entry, fields)
+		if err == nil && n == 0 {
+			err = errUnchanged
+		}
+		removed = n
+		return hash, expiry, err
+	})
+	if err != nil && err != errUnchanged {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// HGet returns the value of a field of the hash under the key
+func (c *BigCache) HGet(key string, field string) ([]byte, error) {
+	entry, err := c.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return HashField(entry, field)
+}
+
+// HGetAll returns every field of the hash under the key
+func (c *BigCache) HGetAll(key string) (map[string][]byte, error) {
+	entry, err := c.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return HashFields(entry)
+}
+
+// SetHashFields sets fields of an encoded hash, nil being the empty hash, and returns the new hash along with how
+// many fields were not in it already
+func SetHashFields(hash []byte, fields map[string][]byte) ([]byte, int, error) {
+	current, err := HashFields(hash)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	added := 0
+	for name, value := range fields {
+		if _, ok := current[name]; !ok {
+			added++
+		}
+		current[name] = value
+	}
+	return encodeHash(current), added, nil
+}
+
+// DeleteHashFields removes fields from an encoded hash and returns the new hash along with how many fields were
+// in it
+func DeleteHashFields(hash []byte, fields []string) ([]byte, int, error) {
+	current, err := HashFields(hash)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	removed := 0
+	for _, name := range fields {
+		if _, ok := current[name]; ok {
+			delete(current, name)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return hash, 0, nil
+	}
+	return encodeHash(current), removed, nil
+}
+
+// HashField returns the value of a field of an encoded hash, without decoding the whole hash
+func HashField(hash []byte, field string) ([]byte, error) {
+	var value []byte
+	found := false
+	err := walkHash(hash, func(name string, data []byte) bool {
+		if name == field {
+			value, found = data, true
+		}
+		return !found && name < field //the fields are sorted so it is not past this one
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrFieldNotFound
+	}
+	return value, nil
+}
+
+// HashFields returns the fields of an encoded hash, nil being the empty hash
+func HashFields(hash []byte) (map[string][]byte, error) {
+	fields := make(map[string][]byte)
+	err := walkHash(hash, func(name string, data []byte) bool {
+		fields[name] = data
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// call fn with the fields of an encoded hash in the order of their names until it returns false, checking every
+// field is within the hash
+func walkHash(hash []byte, fn func(name string, data []byte) bool) error {
+	if len(hash) == 0 {
+		return nil
+	}
+	if len(hash) < hashCountSize {
+		return ErrNotHash
+	}
+	count := int(binary.LittleEndian.Uint32(hash))
+	rest := hash[hashCountSize:]
+	for i := 0; i < count; i++ {
+		if len(rest) < hashRecordSize {
+			return ErrNotHash
+		}
+		nameSize, valueSize := int(binary.LittleEndian.Uint32(rest)), int(binary.LittleEndian.Uint32(rest[4:]))
+		rest = rest[hashRecordSize:]
+		if nameSize < 0 || valueSize < 0 || nameSize+valueSize > len(rest) {
+			return ErrNotHash
+		}
+		if !fn(string(rest[:nameSize]), rest[nameSize:nameSize+valueSize]) {
+			return nil
+		}
+		rest = rest[nameSize+valueSize:]
+	}
+	if len(rest) > 0 {
+		return ErrNotHash
+	}
+	return nil
+}
+
+func encodeHash(fields map[string][]byte) []byte {
+	names := make([]string, 0, len(fields))
+	size := hashCountSize
+	for name, value := range fields {
+		names = append(names, name)
+		size += hashRecordSize + len(name) + len(value)
+	}
+	sort.Strings(names)
+
+	hash := make([]byte, size)
+	binary.LittleEndian.PutUint32(hash, uint32(len(names)))
+	offset := hashCountSize
+	for _, name := range names {
+		binary.LittleEndian.PutUint32(hash[offset:], uint32(len(name)))
+		binary.LittleEndian.PutUint32(hash[offset+4:], uint32(len(fields[name])))
+		offset += hashRecordSize
+		offset += copy(hash[offset:], name)
+		offset += copy(hash[offset:], fields[name])
+	}
+	return hash
+}
@@ -22,6 +22,7 @@ type EntryInfo struct {
 	hash      uint64
 	key       string
 	value     []byte
+	notFound  bool
 }
 
 // Key returns entry's underlying key
@@ -44,10 +45,16 @@ func (e EntryInfo) Value() []byte {
 	return e.value
 }
 
+// NotFound tells whether the entry caches that its key was not found, it has no value then
+func (e EntryInfo) NotFound() bool {
+	return e.notFound
+}
+
 // EntryInfoIterator allows to iterate over entries in the cache
 type EntryInfoIterator struct {
 	mutex         sync.Mutex
 	cache         *BigCache
+	shards        []*cacheShard
 	currentShard  int
 	currentIndex  int
 	elements      []uint32
@@ -68,8 +75,8 @@ func (it *EntryInfoIterator) SetNext() bool {
 		return true
 	}
 
-	for i := it.currentShard + 1; i < it.cache.config.Shards; i++ {
-		it.elements, it.elementsCount = it.cache.shards[i].copyKeys()
+	for i := it.currentShard + 1; i < len(it.shards); i++ {
+		it.elements, it.elementsCount = it.shards[i].copyKeys()
 
 		// Non empty shard - stick with it
 		if it.elementsCount > 0 {
@@ -85,10 +92,12 @@ func (it *EntryInfoIterator) SetNext() bool {
 }
 
 func newIterator(cache *BigCache) *EntryInfoIterator {
-	elements, count := cache.shards[0].copyKeys()
+	shards := cache.shardTable().all()
+	elements, count := shards[0].copyKeys()
 
 	return &EntryInfoIterator{
 		cache:         cache,
+		shards:        shards,
 		currentShard:  0,
 		currentIndex:  -1,
 		elements:      elements,
@@ -105,7 +114,7 @@ func (it *EntryInfoIterator) Value() (EntryInfo, error) {
 		return emptyEntryInfo, ErrInvalidIteratorState
 	}
 
-	entry, err := it.cache.shards[it.currentShard].getEntry(int(it.elements[it.currentIndex]))
+	entry, err := it.shards[it.currentShard].getEntry(int(it.elements[it.currentIndex]))
 
 	if err != nil {
 		it.mutex.Unlock()
@@ -118,5 +127,6 @@ func (it *EntryInfoIterator) Value() (EntryInfo, error) {
 		hash:      readHashFromEntry(entry),
 		key:       readKeyFromEntry(entry),
 		value:     readEntry(entry),
+		notFound:  isNotFound(entry),
 	}, nil
 }
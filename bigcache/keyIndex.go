@@ -0,0 +1,179 @@
+package bigcache
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+)
+
+// ErrNotIndexed is returned when keys are looked up by prefix in a cache created without IndexKeys
+var ErrNotIndexed = errors.New("keys are not indexed, set IndexKeys")
+
+// keyIndex is a trie of the keys of a shard. Every node counts the keys under it, so the keys with a prefix are
+// counted by walking the prefix and listed by walking only the nodes under it. Not found entries are left out
+type keyIndex struct {
+	root indexNode
+}
+
+type indexNode struct {
+	children []indexEdge // sorted by label so keys are listed in order
+	count    int         // keys ending at or under the node
+	key      bool        // a key ends at the node
+}
+
+type indexEdge struct {
+	label byte
+	node  *indexNode
+}
+
+func newKeyIndex() *keyIndex {
+	return &keyIndex{}
+}
+
+// child returns the node under the edge labelled b, nil if there is none, along with where the edge is or goes
+func (n *indexNode) child(b byte) (*indexNode, int) {
+	at := sort.Search(len(n.children), func(i int) bool { return n.children[i].label >= b })
+	if at < len(n.children) && n.children[at].label == b {
+		return n.children[at].node, at
+	}
+	return nil, at
+}
+
+// add indexes the key, nothing changes if it is indexed already
+func (x *keyIndex) add(key string) {
+	if x == nil || x.has(key) {
+		return
+	}
+	n := &x.root
+	n.count++
+	for i := 0; i < len(key); i++ {
+		next, at := n.child(key[i])
+		if next == nil {
+			next = &indexNode{}
+			n.children = append(n.children, indexEdge{})
+			copy(n.children[at+1:], n.children[at:])
+			n.children[at] = indexEdge{label: key[i], node: next}
+		}
+		n = next
+		n.count++
+	}
+	n.key = true
+}
+
+// remove drops the key from the index along with the nodes no other key goes through
+func (x *keyIndex) remove(key string) {
+	if x == nil || !x.has(key) {
+		return
+	}
+	n := &x.root
+	n.count--
+	for i := 0; i < len(key); i++ {
+		next, at := n.child(key[i])
+		if next.count == 1 { //only the key goes through it
+			n.children = append(n.children[:at], n.children[at+1:]...)
+			return
+		}
+		n = next
+		n.count--
+	}
+	n.key = false
+}
+
+func (x *keyIndex) has(key string) bool {
+	n := x.find(key)
+	return n != nil && n.key
+}
+
+// find returns the node the prefix leads to, nil if no key has the prefix
+func (x *keyIndex) find(prefix string) *indexNode {
+	n := &x.root
+	for i := 0; i < len(prefix) && n != nil; i++ {
+		n, _ = n.child(prefix[i])
+	}
+	return n
+}
+
+func (x *keyIndex) countPrefix(prefix string) int {
+	if n := x.find(prefix); n != nil {
+		return n.count
+	}
+	return 0
+}
+
+// keysWithPrefix appends to keys, in order, at most limit keys with the prefix that sort after the key after
+func (x *keyIndex) keysWithPrefix(prefix, after string, limit int, keys []string) []string {
+	n := x.find(prefix)
+	if n == nil || limit <= 0 {
+		return keys
+	}
+	if limit > math.MaxInt-len(keys) {
+		limit = math.MaxInt - len(keys)
+	}
+	limit += len(keys)
+	path := []byte(prefix)
+	var walk func(n *indexNode)
+	walk = func(n *indexNode) {
+		current := string(path)
+		if current < after && !strings.HasPrefix(after, current) { //every key under the node sorts before after
+			return
+		}
+		if n.key && current > after {
+			keys = append(keys, current)
+		}
+		for _, edge := range n.children {
+			if len(keys) >= limit {
+				return
+			}
+			path = append(path, edge.label)
+			walk(edge.node)
+			path = path[:len(path)-1]
+		}
+	}
+	walk(n)
+	return keys
+}
+
+// CountPrefix returns the number of keys with the prefix, read from the index of the keys so only the keys with
+// the prefix are gone over. Fails with ErrNotIndexed unless IndexKeys is set. Expired entries count until they are
+// removed, and entries being moved by Reshard may be missed
+func (c *BigCache) CountPrefix(prefix string) (int, error) {
+	if !c.config.IndexKeys {
+		return 0, ErrNotIndexed
+	}
+	count := 0
+	for _, shard := range c.shardTable().all() {
+		shard.lock.RLock()
+		count += shard.keys.countPrefix(prefix)
+		shard.lock.RUnlock()
+	}
+	return count, nil
+}
+
+// KeysWithPrefix returns in order at most limit keys with the prefix, all of them when limit is 0 or less.
+// Fails with ErrNotIndexed unless IndexKeys is set
+func (c *BigCache) KeysWithPrefix(prefix string, limit int) ([]string, error) {
+	return c.KeysWithPrefixAfter(prefix, "", limit)
+}
+
+// KeysWithPrefixAfter is KeysWithPrefix listing only the keys that sort after the key after, so the keys are
+// listed a page at a time by passing the last key of a page to get the next one
+func (c *BigCache) KeysWithPrefixAfter(prefix, after string, limit int) ([]string, error) {
+	if !c.config.IndexKeys {
+		return nil, ErrNotIndexed
+	}
+	if limit <= 0 {
+		limit = math.MaxInt
+	}
+	var keys []string
+	for _, shard := range c.shardTable().all() {
+		shard.lock.RLock()
+		keys = shard.keys.keysWithPrefix(prefix, after, limit, keys)
+		shard.lock.RUnlock()
+	}
+	sort.Strings(keys)
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys, nil
+}
@@ -0,0 +1,240 @@
+package bigcache
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// ErrNotList is returned when the entry under a key is not a list written by Append
+var ErrNotList = errors.New("entry is not a list")
+
+// ErrListEmpty is returned when popping an item from a list which has none
+var ErrListEmpty = errors.New("list is empty")
+
+// lists are stored as a single entry holding their items one after the other, each prefixed by its length
+const listItemHeaderSize = 4
+
+// Append adds items to the end of the list under the key, creating it if there is none, and drops the oldest
+// items beyond maxLen, no limit if maxLen is not above zero. a new list expires after duration, an existing
+// one keeps its expiry. it returns the expiry timestamp of the list
+func (c *BigCache) Append(key string, items [][]byte, maxLen int, duration time.Duration) (uint64, error) {
+	expiryTimestamp := NO_EXPIRY
+	if duration != time.Duration(NO_EXPIRY) {
+		expiryTimestamp = uint64(c.clock.epoch()) + uint64(duration.Seconds())
+	}
+	return c.appendAt(key, items, maxLen, expiryTimestamp)
+}
+
+// AppendUntil is Append for a new list expiring at the given wall-clock time, which must be in the future
+func (c *BigCache) AppendUntil(key string, items [][]byte, maxLen int, expireAt time.Time) (uint64, error) {
+	expiryTimestamp := expireAt.Unix()
+	if expiryTimestamp <= c.clock.epoch() {
+		return 0, ErrExpiryInPast
+	}
+	return c.appendAt(key, items, maxLen, uint64(expiryTimestamp))
+}
+
+func (c *BigCache) appendAt(key string, items [][]byte, maxLen int, expiryTimestamp uint64) (uint64, error) {
+	c.makeRoom(itemsSize(key, items))
+	hashedKey := c.hash.Sum64(key)
+	shard := c.getShard(hashedKey)
+	return shard.update(key, hashedKey, func(entry []byte, expiry uint64, found bool) ([]byte, uint64, error) {
+		if !found {
+			expiry = expiryTimestamp
+		}
+		list, err := AppendList(entry, items, maxLen)
+		return list, expiry, err
+	})
+}
+
+// LPush adds items to the head of the list under the key, creating it if there is none, each in turn so the last
+// of them ends up first, and drops the items at the tail beyond maxLen, no limit if maxLen is not above zero. a
+// new list expires after duration, an existing one keeps its expiry. it returns the expiry timestamp of the list
+func (c *BigCache) LPush(key string, items [][]byte, maxLen int, duration time.Duration) (uint64, error) {
+	expiryTimestamp := NO_EXPIRY
+	if duration != time.Duration(NO_EXPIRY) {
+		expiryTimestamp = uint64(c.clock.epoch()) + uint64(duration.Seconds())
+	}
+	return c.lPushAt(key, items, maxLen, expiryTimestamp)
+}
+
+// LPushUntil is LPush for a new list expiring at the given wall-clock time, which must be in the future
+func (c *BigCache) LPushUntil(key string, items [][]byte, maxLen int, expireAt time.Time) (uint64, error) {
+	expiryTimestamp := expireAt.Unix()
+	if expiryTimestamp <= c.clock.epoch() {
+		return 0, ErrExpiryInPast
+	}
+	return c.lPushAt(key, items, maxLen, uint64(expiryTimestamp))
+}
+
+func (c *BigCache) lPushAt(key string, items [][]byte, maxLen int, expiryTimestamp uint64) (uint64, error) {
+	c.makeRoom(itemsSize(key, items))
+	hashedKey := c.hash.Sum64(key)
+	shard := c.getShard(hashedKey)
+	return shard.update(key, hashedKey, func(entry []byte, expiry uint64, found bool) ([]byte, uint64, error) {
+		if !found {
+			expiry = expiryTimestamp
+		}
+		list, err := PushList(entry, items, maxLen)
+		return list, expiry, err
+	})
+}
+
+// RPop removes the last item of the list under the key and returns it, with the shard locked so of concurrent
+// callers each gets an item of its own. ErrListEmpty is returned when the list has no item, the error of a
+// missing key when there is no list
+func (c *BigCache) RPop(key string) ([]byte, error) {
+	var item []byte
+	hashedKey := c.hash.Sum64(key)
+	shard := c.getShard(hashedKey)
+	_, err := shard.update(key, hashedKey, func(entry []byte, expiry uint64, found bool) ([]byte, uint64, error) {
+		if !found {
+			return nil, 0, notFound(key)
+		}
+		rest, last, err := PopList(entry)
+		item = last
+		return rest, expiry, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// ReadRange returns the items of the list under the key from start to stop, both included. negative
+// indexes count from the end of the list, -1 being its last item, and the range is clipped to the list
+func (c *BigCache) ReadRange(key string, start, stop int) ([][]byte, error) {
+	entry, err := c.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return ListRange(entry, start, stop)
+}
+
+// AppendList adds items to the end of an encoded list, nil being the empty list, and drops the oldest items
+// beyond maxLen, no limit if maxLen is not above zero
+func AppendList(list []byte, items [][]byte, maxLen int) ([]byte, error) {
+	count, err := listLen(list)
+	if err != nil {
+		return nil, err
+	}
+
+	size := len(list)
+	for _, item := range items {
+		size += listItemHeaderSize + len(item)
+	}
+	appended := make([]byte, size)
+	offset := copy(appended, list)
+	for _, item := range items {
+		binary.LittleEndian.PutUint32(appended[offset:], uint32(len(item)))
+		offset += listItemHeaderSize + copy(appended[offset+listItemHeaderSize:], item)
+	}
+
+	count += len(items)
+	for maxLen > 0 && count > maxLen {
+		appended = appended[listItemHeaderSize+int(binary.LittleEndian.Uint32(appended)):]
+		count--
+	}
+	return appended, nil
+}
+
+// PushList adds items to the head of an encoded list, nil being the empty list, the last of them first, and drops
+// the items at the tail beyond maxLen, no limit if maxLen is not above zero
+func PushList(list []byte, items [][]byte, maxLen int) ([]byte, error) {
+	count, err := listLen(list)
+	if err != nil {
+		return nil, err
+	}
+
+	size := len(list)
+	for _, item := range items {
+		size += listItemHeaderSize + len(item)
+	}
+	pushed := make([]byte, size)
+	offset := 0
+	for x := len(items) - 1; x >= 0; x-- {
+		binary.LittleEndian.PutUint32(pushed[offset:], uint32(len(items[x])))
+		offset += listItemHeaderSize + copy(pushed[offset+listItemHeaderSize:], items[x])
+	}
+	copy(pushed[offset:], list)
+
+	count += len(items)
+	if maxLen > 0 && count > maxLen {
+		kept := pushed
+		for x := 0; x < maxLen; x++ {
+			kept = kept[listItemHeaderSize+int(binary.LittleEndian.Uint32(kept)):]
+		}
+		pushed = pushed[:len(pushed)-len(kept)]
+	}
+	return pushed, nil
+}
+
+// PopList removes the last item of an encoded list and returns the list left along with the item
+func PopList(list []byte) ([]byte, []byte, error) {
+	count, err := listLen(list)
+	if err != nil {
+		return nil, nil, err
+	}
+	if count == 0 {
+		return nil, nil, ErrListEmpty
+	}
+
+	rest := list
+	for x := 0; x < count-1; x++ {
+		rest = rest[listItemHeaderSize+int(binary.LittleEndian.Uint32(rest)):]
+	}
+	item := make([]byte, len(rest)-listItemHeaderSize)
+	copy(item, rest[listItemHeaderSize:])
+	return list[:len(list)-len(rest)], item, nil
+}
+
+// ListRange returns the items of an encoded list from start to stop, with the indexes of ReadRange
+func ListRange(list []byte, start, stop int) ([][]byte, error) {
+	count, err := listLen(list)
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 {
+		start += count
+	}
+	if stop < 0 {
+		stop += count
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= count {
+		stop = count - 1
+	}
+	if start > stop {
+		return [][]byte{}, nil
+	}
+
+	items := make([][]byte, 0, stop-start+1)
+	for index := 0; index <= stop; index++ {
+		size := int(binary.LittleEndian.Uint32(list))
+		if index >= start {
+			items = append(items, list[listItemHeaderSize:listItemHeaderSize+size])
+		}
+		list = list[listItemHeaderSize+size:]
+	}
+	return items, nil
+}
+
+// number of items in an encoded list, checking every item is within it
+func listLen(list []byte) (int, error) {
+	count := 0
+	for len(list) > 0 {
+		if len(list) < listItemHeaderSize {
+			return 0, ErrNotList
+		}
+		size := int(binary.LittleEndian.Uint32(list))
+		if len(list)-listItemHeaderSize < size {
+			return 0, ErrNotList
+		}
+		list = list[listItemHeaderSize+size:]
+		count++
+	}
+	return count, nil
+}
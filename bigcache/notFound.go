@@ -0,0 +1,33 @@
+package bigcache
+
+import "time"
+
+// SetNotFound caches that the key has no value, for duration, so lookups of a missing key are answered without
+// asking whatever the cache is in front of again. Get and the other reads of the key fail with an error matching
+// ErrCachedNotFound as well as ErrEntryNotFound, writes of the key replace it like any other entry
+func (c *BigCache) SetNotFound(key string, duration time.Duration) (uint64, error) {
+	expiryTimestamp := NO_EXPIRY
+	if duration != time.Duration(NO_EXPIRY) {
+		expiryTimestamp = uint64(c.clock.epoch()) + uint64(duration.Seconds())
+	}
+	return c.setNotFoundAt(key, expiryTimestamp)
+}
+
+// SetNotFoundUntil is SetNotFound expiring at the given wall-clock time, which must be in the future
+func (c *BigCache) SetNotFoundUntil(key string, expireAt time.Time) (uint64, error) {
+	expiryTimestamp := expireAt.Unix()
+	if expiryTimestamp <= c.clock.epoch() {
+		return 0, ErrExpiryInPast
+	}
+	return c.setNotFoundAt(key, uint64(expiryTimestamp))
+}
+
+func (c *BigCache) setNotFoundAt(key string, expiryTimestamp uint64) (uint64, error) {
+	if err := checkValueSize(key, nil, c.config.MaxValueSize); err != nil {
+		return 0, err
+	}
+	c.makeRoom(entrySize(key, nil))
+	hashedKey := c.hash.Sum64(key)
+	shard := c.getShard(hashedKey)
+	return shard.setAt(key, hashedKey, nil, expiryTimestamp, true)
+}
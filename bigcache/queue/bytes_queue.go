@@ -30,12 +30,16 @@ type BytesQueue struct {
 	verbose         bool
 	initialCapacity int
 	freelist        *freeList
+	mapped          *mappedFile // file the array is mapped from, nil when the array is on the heap
 }
 
 type queueError struct {
 	message string
 }
 
+// ErrFull is returned by Push when the queue reached its maximum size
+var ErrFull error = &queueError{"Full queue. Maximum size limit reached."}
+
 // NewBytesQueue initialize new bytes queue.
 // Initial capacity is used in bytes array allocation
 // When verbose flag is set then information about memory allocation are printed
@@ -54,6 +58,43 @@ func NewBytesQueue(initialCapacity int, maxCapacity int, verbose bool) *BytesQue
 	}
 }
 
+// NewMappedBytesQueue initialize new bytes queue kept in the file at path mapped to memory rather than on the heap,
+// so the queue can outgrow the memory of the machine. The file is created or truncated, and grows along with the
+// queue. Close must be called once the queue is no longer used
+func NewMappedBytesQueue(path string, initialCapacity int, maxCapacity int, verbose bool) (*BytesQueue, error) {
+	mapped, err := openMappedFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	array, err := mapped.mapArray(nil, initialCapacity)
+	if err != nil {
+		mapped.close(nil)
+		return nil, err
+	}
+
+	q := NewBytesQueue(0, maxCapacity, verbose)
+	q.array = array
+	q.capacity = initialCapacity
+	q.initialCapacity = initialCapacity
+	q.mapped = mapped
+	return q, nil
+}
+
+// Close unmaps and closes the file of a queue created by NewMappedBytesQueue, the queue is empty afterwards and
+// fails to grow. Nothing to do for a queue on the heap
+func (q *BytesQueue) Close() error {
+	if q.mapped == nil {
+		return nil
+	}
+
+	err := q.mapped.close(q.array)
+	q.array = nil
+	q.capacity = 0
+	q.Reset()
+	return err
+}
+
 // Reset removes all entries from queue
 func (q *BytesQueue) Reset() {
 	// Just reset indexes
@@ -61,6 +102,7 @@ func (q *BytesQueue) Reset() {
 	q.head = leftMarginIndex
 	q.rightMargin = leftMarginIndex
 	q.count = 0
+	q.freelist = newFreeList()
 }
 
 // Push copies entry at the end of queue and moves tail pointer. Allocates more space if needed.
@@ -84,9 +126,9 @@ func (q *BytesQueue) Push(data []byte) (int, error) {
 		if q.availableSpaceBeforeHead() >= dataLen+headerEntrySize {
 			q.tail = leftMarginIndex
 		} else if q.capacity+headerEntrySize+dataLen >= q.maxCapacity && q.maxCapacity > 0 {
-			return -1, &queueError{"Full queue. Maximum size limit reached."}
-		} else {
-			q.allocateAdditionalMemory(dataLen + headerEntrySize)
+			return -1, ErrFull
+		} else if err := q.allocateAdditionalMemory(dataLen + headerEntrySize); err != nil {
+			return -1, err
 		}
 	}
 
@@ -96,21 +138,33 @@ func (q *BytesQueue) Push(data []byte) (int, error) {
 	return index, nil
 }
 
-func (q *BytesQueue) allocateAdditionalMemory(minimum int) {
+func (q *BytesQueue) allocateAdditionalMemory(minimum int) error {
 	start := time.Now()
-	if q.capacity < minimum {
-		q.capacity += minimum
+	capacity := q.capacity
+	if capacity < minimum {
+		capacity += minimum
 	}
-	q.capacity = q.capacity * 2
-	if q.capacity > q.maxCapacity && q.maxCapacity > 0 {
-		q.capacity = q.maxCapacity
+	capacity = capacity * 2
+	if capacity > q.maxCapacity && q.maxCapacity > 0 {
+		capacity = q.maxCapacity
 	}
 
 	oldArray := q.array
-	q.array = make([]byte, q.capacity)
+	if q.mapped != nil { //the file grows and keeps the entries, no copy needed
+		array, err := q.mapped.mapArray(oldArray, capacity)
+		if err != nil {
+			return err
+		}
+		q.array = array
+	} else {
+		q.array = make([]byte, capacity)
+	}
+	q.capacity = capacity
 
 	if leftMarginIndex != q.rightMargin {
-		copy(q.array, oldArray[:q.rightMargin])
+		if q.mapped == nil {
+			copy(q.array, oldArray[:q.rightMargin])
+		}
 
 		if q.tail < q.head {
 			emptyBlobLen := q.head - q.tail - headerEntrySize
@@ -123,6 +177,7 @@ func (q *BytesQueue) allocateAdditionalMemory(minimum int) {
 	if q.verbose {
 		log.Printf("Allocated new queue in %s; Capacity: %d \n", time.Since(start), q.capacity)
 	}
+	return nil
 }
 
 func (q *BytesQueue) push(data []byte, len int) {
@@ -233,3 +288,40 @@ func (q *BytesQueue) Delete(index int) error {
 	q.count--
 	return nil
 }
+
+// Compact does a step of defragmentation. The live entry right after the lowest hole left by deleted entries
+// is moved to the start of the hole, so the hole moves up the queue and merges with the holes it meets, and
+// the holes that reach the tail are given back to the space after it. moved is called with the old and the new
+// index of the entry moved, entries for which pinned is true are not moved. Returns the bytes given back to the
+// space after the tail and whether compacting can go on, false once no hole is left or the entry to move is pinned
+func (q *BytesQueue) Compact(moved func(from, to int), pinned func(index int) bool) (int, bool) {
+	reclaimed := 0
+	for hole := q.freelist.endingBefore(q.tail); hole != nil; hole = q.freelist.endingBefore(q.tail) {
+		q.freelist.remove(hole)
+		q.tail = hole.parentIndex
+		q.rightMargin = q.tail
+		reclaimed += hole.actualSize
+	}
+
+	hole := q.freelist.lowest()
+	if hole == nil {
+		return reclaimed, false
+	}
+
+	from := hole.parentIndex + hole.actualSize
+	if pinned(from) {
+		return reclaimed, false
+	}
+	size := headerEntrySize + int(binary.LittleEndian.Uint32(q.array[from:from+headerEntrySize]))
+	q.freelist.remove(hole)
+	copy(q.array[hole.parentIndex:], q.array[from:from+size])
+	moved(from, hole.parentIndex)
+	q.freelist.add(hole.parentIndex+size, hole.actualSize)
+
+	return reclaimed, true
+}
+
+// Fragmented returns the number of bytes held by holes left by deleted entries
+func (q *BytesQueue) Fragmented() int {
+	return q.freelist.size()
+}
@@ -55,8 +55,7 @@ func (list *freeList) add(index, size int) error {
 		list.indexTree.Remove(v.parentIndex + v.actualSize - 1)
 		list.removeFromSizeList(v)
 
-		item.parentIndex = index
-		item.actualSize = size + v.actualSize
+		item.actualSize += v.actualSize //keeps the start of an adjacent entry merged on the 'left'
 	}
 
 	//store it into the indexTree. both its start and end position
@@ -112,7 +111,7 @@ func (list *freeList) removeFromSizeList(v *itemPos) {
 			copy(list.sizeList[6][pos:], list.sizeList[6][(pos+1):])
 			list.sizeList[6] = list.sizeList[6][:len(list.sizeList[6])-1]
 		}
-	case v.actualSize > 4096:
+	default:
 		pos = listPos(v, list.sizeList[7])
 		if pos != -1 {
 			copy(list.sizeList[7][pos:], list.sizeList[7][(pos+1):])
@@ -137,7 +136,7 @@ func (list *freeList) putIntoSizeList(item *itemPos) {
 		list.sizeList[5] = append(list.sizeList[5], item)
 	case item.actualSize < 4096:
 		list.sizeList[6] = append(list.sizeList[6], item)
-	case item.actualSize > 4096:
+	default:
 		list.sizeList[7] = append(list.sizeList[7], item)
 	}
 }
@@ -230,3 +229,38 @@ func (list *freeList) findInSizeList(idx int, size int, buckSize int) (int, bool
 
 	return -1, false
 }
+
+//the free item at the lowest index, nil if there is none
+func (list *freeList) lowest() *itemPos {
+	node := list.indexTree.Left()
+	if node == nil {
+		return nil
+	}
+	return node.Value.(*itemPos)
+}
+
+//the free item ending right before index, nil if there is none
+func (list *freeList) endingBefore(index int) *itemPos {
+	if d, found := list.indexTree.Get(index - 1); found {
+		return d.(*itemPos)
+	}
+	return nil
+}
+
+//take an item out of the freelist
+func (list *freeList) remove(item *itemPos) {
+	list.indexTree.Remove(item.parentIndex)
+	list.indexTree.Remove(item.parentIndex + item.actualSize - 1)
+	list.removeFromSizeList(item)
+}
+
+//size returns the number of free bytes tracked
+func (list *freeList) size() int {
+	total := 0
+	for _, arr := range list.sizeList {
+		for _, item := range arr {
+			total += item.actualSize
+		}
+	}
+	return total
+}
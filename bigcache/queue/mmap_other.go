@@ -0,0 +1,19 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package queue
+
+// mappedFile is the file a queue is kept in when it is mapped to memory, not supported on this platform
+type mappedFile struct{}
+
+func openMappedFile(path string) (*mappedFile, error) {
+	return nil, &queueError{"Memory-mapped queues are not supported on this platform."}
+}
+
+func (m *mappedFile) mapArray(array []byte, capacity int) ([]byte, error) {
+	return nil, &queueError{"Memory-mapped queues are not supported on this platform."}
+}
+
+func (m *mappedFile) close(array []byte) error {
+	return nil
+}
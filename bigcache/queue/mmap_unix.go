@@ -0,0 +1,60 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package queue
+
+import (
+	"os"
+	"syscall"
+)
+
+// mappedFile is the file a queue is kept in when it is mapped to memory
+type mappedFile struct {
+	file    *os.File
+	retired [][]byte // former mappings, kept until the file is closed since entries may still be read out of them
+}
+
+func openMappedFile(path string) (*mappedFile, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &mappedFile{file: file}, nil
+}
+
+// mapArray grows the file to capacity bytes and maps it to memory. array, the former mapping of the file, is kept
+// mapped until the file is closed, so entries read out of it stay valid. The bytes in the file are kept
+func (m *mappedFile) mapArray(array []byte, capacity int) ([]byte, error) {
+	if err := m.file.Truncate(int64(capacity)); err != nil {
+		return nil, err
+	}
+
+	mapped, err := syscall.Mmap(int(m.file.Fd()), 0, capacity, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, &queueError{"Failed to map queue file: " + err.Error()}
+	}
+
+	if array != nil {
+		m.retired = append(m.retired, array)
+	}
+	return mapped, nil
+}
+
+// close unmaps array, the mapping of the file, along with the former ones and closes the file
+func (m *mappedFile) close(array []byte) error {
+	var err error
+	for _, mapping := range append(m.retired, array) {
+		if mapping == nil {
+			continue
+		}
+		if e := syscall.Munmap(mapping); e != nil && err == nil {
+			err = e
+		}
+	}
+	m.retired = nil
+
+	if e := m.file.Close(); err == nil {
+		err = e
+	}
+	return err
+}
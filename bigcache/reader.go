@@ -0,0 +1,98 @@
+package bigcache
+
+import "bytes"
+
+// EntryReader reads an entry straight out of the queue of its shard, without copying it. The entry is pinned
+// while the reader is open: it is not moved by compaction and the space it takes is not reused, even if the
+// entry is deleted, replaced or evicted meanwhile. Close must be called once done reading
+type EntryReader struct {
+	*bytes.Reader
+	shard      *cacheShard
+	index      uint32
+	generation uint64
+	closed     bool
+}
+
+// GetReader returns a reader of the entry for the key which reads it out of the cache without copying it, for
+// entries too large to be copied on every read. Readers must be closed before the cache is, and before it is
+// reset when its queues are mapped to files
+func (c *BigCache) GetReader(key string) (*EntryReader, error) {
+	hashedKey := c.hash.Sum64(key)
+	shard := c.getShard(hashedKey)
+	return shard.getReader(key, hashedKey)
+}
+
+// Close unpins the entry, the reader reads nothing afterwards
+func (r *EntryReader) Close() error {
+	if r.closed {
+		return nil
+	}
+
+	r.closed = true
+	r.Reader = bytes.NewReader(nil)
+	r.shard.unpin(r.index, r.generation)
+	return nil
+}
+
+// getReader pins the entry for the key and returns a reader of it
+func (s *cacheShard) getReader(key string, hashedKey uint64) (*EntryReader, error) {
+	stripe := s.lock.rlockKey(hashedKey)
+	defer stripe.RUnlock()
+
+	itemIndex := s.hashmap[hashedKey]
+	if itemIndex == 0 {
+		stripe.miss()
+		return nil, notFound(key)
+	}
+
+	wrappedEntry, err := s.entries.Get(int(itemIndex))
+	if err != nil {
+		stripe.miss()
+		return nil, err
+	}
+	if readKeyFromEntry(wrappedEntry) != key {
+		s.collision()
+		return nil, notFound(key)
+	}
+	if isNotFound(wrappedEntry) {
+		stripe.hit()
+		return nil, cachedNotFound(key)
+	}
+
+	s.pinLock.Lock() //other readers may be pinning entries at the same time
+	s.pins[itemIndex]++
+	s.pinLock.Unlock()
+
+	stripe.hit()
+	return &EntryReader{
+		Reader:     bytes.NewReader(entryData(wrappedEntry)),
+		shard:      s,
+		index:      itemIndex,
+		generation: s.generation,
+	}, nil
+}
+
+// unpin the entry at index once a reader of it is closed, giving back the space it takes if it was removed while
+// pinned. readers from before the shard was reset have nothing to unpin
+func (s *cacheShard) unpin(index uint32, generation uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if generation != s.generation || s.pins[index] == 0 {
+		return
+	}
+	if s.pins[index]--; s.pins[index] > 0 {
+		return
+	}
+
+	delete(s.pins, index)
+	if s.released[index] {
+		delete(s.released, index)
+		s.entries.Delete(int(index))
+	}
+}
+
+// pinned tells whether a reader is open on the entry at index, called with the lock held
+func (s *cacheShard) pinned(index int) bool {
+	return s.pins[uint32(index)] > 0
+}
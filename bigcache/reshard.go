@@ -0,0 +1,244 @@
+package bigcache
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// number of entries moved while resharding before the progress is reported and the shards are let go of
+const reshardBatchSize = 256
+
+// ReshardProgress tells how far Reshard got
+type ReshardProgress struct {
+	// Moved is the number of entries Reshard moved to the new shards so far, reads and writes bring over those
+	// they use themselves
+	Moved int
+	// Remaining is the number of entries left in the former shards
+	Remaining int
+}
+
+// shardTable is the shards the keys are spread over, replaced as a whole when the cache is resharded
+type shardTable struct {
+	shards []*cacheShard
+	mask   uint64
+	config Config      // the config of the cache with Shards set to the number of shards of the table
+	former *shardTable // while resharding, the table the entries are still being moved out of
+}
+
+// shardTable returns the shards the keys are spread over
+func (c *BigCache) shardTable() *shardTable {
+	return c.table.Load().(*shardTable)
+}
+
+// all returns the shards of the table followed, while resharding, by those the entries are moved out of
+func (t *shardTable) all() []*cacheShard {
+	if t.former == nil {
+		return t.shards
+	}
+	return append(append(make([]*cacheShard, 0, len(t.shards)+len(t.former.shards)), t.shards...), t.former.shards...)
+}
+
+// newShardTable creates config.Shards empty shards, numbered from first so their queues are mapped to files of
+// their own
+func (c *BigCache) newShardTable(config Config, first uint64) (*shardTable, error) {
+	table := &shardTable{shards: make([]*cacheShard, config.Shards), mask: uint64(config.Shards - 1), config: config}
+	for i := range table.shards {
+		shard, err := initNewShard(config, c.onRemove, c.clock, first+uint64(i), &c.sequence)
+		if err != nil {
+			for _, created := range table.shards[:i] {
+				created.ttlTable.stop()
+				created.close()
+			}
+			return nil, err
+		}
+		table.shards[i] = shard
+	}
+	return table, nil
+}
+
+// Reshard spreads the entries over the given number of shards, which must be a power of two, without emptying
+// the cache. The new shards take over straight away: a read or write of a key brings its entry over first, while
+// the others are moved across a batch at a time so reads and writes are only held up briefly and no more than an
+// entry is copied at once. progress, when not nil, is called after every batch. Moved entries count as written
+// when they are moved for HardMaxCacheSize to evict the oldest first, and iterators and snapshots taken meanwhile
+// may miss entries being moved or see them twice
+func (c *BigCache) Reshard(shards int, progress func(ReshardProgress)) error {
+	if shards < 1 || !isPowerOfTwo(shards) {
+		return fmt.Errorf("Shards number must be power of two")
+	}
+
+	c.reshardLock.Lock()
+	defer c.reshardLock.Unlock()
+	return c.reshard(shards, progress)
+}
+
+// DoubleShards spreads the entries over twice the shards the cache has, as Reshard does. It returns once every
+// entry is moved, so a cache sized for a small workload is grown in the background by calling it from a goroutine
+// of its own
+func (c *BigCache) DoubleShards(progress func(ReshardProgress)) error {
+	c.reshardLock.Lock()
+	defer c.reshardLock.Unlock()
+	return c.reshard(len(c.shardTable().shards)*2, progress)
+}
+
+// reshard moves the entries to the given number of shards, reshardLock is held
+func (c *BigCache) reshard(shards int, progress func(ReshardProgress)) error {
+	former := c.shardTable()
+	if len(former.shards) == shards {
+		return nil
+	}
+
+	config := former.config
+	config.Shards = shards
+	table, err := c.newShardTable(config, former.shards[len(former.shards)-1].sharedNum+1)
+	if err != nil {
+		return err
+	}
+	table.former = former
+	c.table.Store(table)
+
+	moved := 0
+	for _, from := range former.shards {
+		moved += c.drain(from, table, func(n int) {
+			if progress != nil {
+				progress(ReshardProgress{Moved: moved + n, Remaining: formerLen(former)})
+			}
+		})
+	}
+
+	c.table.Store(&shardTable{shards: table.shards, mask: table.mask, config: config})
+	for _, from := range former.shards {
+		c.drain(from, table, nil) // writes that picked the former shard just before it was replaced
+		table.shards[0].addStats(from.getStats())
+		from.retire()
+	}
+	return nil
+}
+
+// Shards returns the number of shards the keys are spread over
+func (c *BigCache) Shards() int {
+	return len(c.shardTable().shards)
+}
+
+// drain moves every entry of the shard of a former table to table, a batch at a time. Returns the number moved
+func (c *BigCache) drain(from *cacheShard, table *shardTable, batched func(int)) int {
+	moved := 0
+	for {
+		hashes := from.someKeys(reshardBatchSize)
+		if len(hashes) == 0 {
+			return moved
+		}
+		for _, hashedKey := range hashes {
+			if c.moveEntry(from, table.shards[hashedKey&table.mask], hashedKey) {
+				moved++
+			}
+		}
+		if batched != nil {
+			batched(moved)
+		}
+	}
+}
+
+// number of entries left in the shards of a former table
+func formerLen(table *shardTable) int {
+	n := 0
+	for _, shard := range table.shards {
+		n += shard.len()
+	}
+	return n
+}
+
+// moveEntry moves the entry under hashedKey, if there is one, from the shard of the former table to its shard in
+// the new one. Both are locked, the new one first wherever entries are moved, so the entry is never missing from
+// both. false when there was nothing to move
+func (c *BigCache) moveEntry(from, to *cacheShard, hashedKey uint64) bool {
+	stripe := from.lock.rlockKey(hashedKey)
+	itemIndex := from.hashmap[hashedKey]
+	stripe.RUnlock()
+	if itemIndex == 0 { // moved already, or never there
+		return false
+	}
+
+	to.lock.Lock()
+	defer to.lock.Unlock()
+	from.lock.Lock()
+	defer from.lock.Unlock()
+
+	itemIndex = from.hashmap[hashedKey]
+	if itemIndex == 0 {
+		return false
+	}
+	wrappedEntry, err := from.entries.Get(int(itemIndex))
+	if err != nil {
+		delete(from.hashmap, hashedKey)
+		atomic.StoreInt64(&from.count, int64(len(from.hashmap)))
+		return false
+	}
+
+	key := readKeyFromEntry(wrappedEntry)
+	expiry := readTimestampFromEntry(wrappedEntry)
+	lost := false
+	if to.hashmap[hashedKey] != 0 { // written again meanwhile, by a write that picked the new shard first
+		to.overwritten()
+	} else if err := to.push(key, hashedKey, wrappedEntry[headersSizeInBytes+len(key):], expiry, isNotFound(wrappedEntry)); err != nil {
+		to.noSpace()
+		lost = true
+	} else if expiry != NO_EXPIRY {
+		to.ttlTable.put(expiry, key)
+	}
+
+	delete(from.hashmap, hashedKey)
+	from.removed(wrappedEntry)
+	from.forget(hashedKey)
+	if lost { // for want of room in the new shard
+		from.onRemove(wrappedEntry, NoSpace)
+		from.removedFor(NoSpace)
+	}
+	from.ttlTable.remove(expiry, key)
+	resetKeyFromEntry(wrappedEntry)
+	from.delete(itemIndex)
+	return !lost
+}
+
+// someKeys returns the hashes of up to n entries of the shard
+func (s *cacheShard) someKeys(n int) []uint64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	hashes := make([]uint64, 0, min(n, len(s.hashmap)))
+	for hashedKey := range s.hashmap {
+		if len(hashes) == n {
+			break
+		}
+		hashes = append(hashes, hashedKey)
+	}
+	return hashes
+}
+
+// addStats adds the counts of a shard that is no longer used to those of this one, so they are not lost
+func (s *cacheShard) addStats(stats Stats) {
+	for _, add := range []struct {
+		count *int64
+		n     int64
+	}{
+		{&s.stats.Hits, stats.Hits}, {&s.stats.Misses, stats.Misses}, {&s.stats.DelHits, stats.DelHits},
+		{&s.stats.DelMisses, stats.DelMisses}, {&s.stats.Collisions, stats.Collisions},
+		{&s.stats.EvictCount, stats.EvictCount}, {&s.stats.Expired, stats.Expired}, {&s.stats.Evicted, stats.Evicted},
+		{&s.stats.Deleted, stats.Deleted}, {&s.stats.Overwritten, stats.Overwritten}, {&s.stats.Cleared, stats.Cleared},
+		{&s.stats.NoSpace, stats.NoSpace}, {&s.stats.Reclaimed, stats.Reclaimed},
+	} {
+		atomic.AddInt64(add.count, add.n)
+	}
+}
+
+// retire stops expiring the entries of a shard that is no longer used and lets go of the file its queue is mapped
+// to, unless readers of its entries are still open
+func (s *cacheShard) retire() {
+	s.ttlTable.stop()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.pins) == 0 {
+		s.entries.Close()
+	}
+}
@@ -0,0 +1,216 @@
+package bigcache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"time"
+)
+
+// ErrNotSet is returned when the entry under a key is not a set written by SAdd
+var ErrNotSet = errors.New("entry is not a set")
+
+// sets are stored as a single entry, a count followed by an index of the members sorted by their hash then the
+// members themselves. an index record holds the hash of a member, its offset past the index and its length
+const (
+	setCountSize  = 4
+	setRecordSize = 16
+)
+
+// member of a set along with its hash
+type setMember struct {
+	hash uint64
+	data []byte
+}
+
+// SAdd adds members to the set under the key, creating it if there is none, and returns how many were not in
+// it already. a new set expires after duration, an existing one keeps its expiry
+func (c *BigCache) SAdd(key string, members [][]byte, duration time.Duration) (int, error) {
+	expiryTimestamp := NO_EXPIRY
+	if duration != time.Duration(NO_EXPIRY) {
+		expiryTimestamp = uint64(c.clock.epoch()) + uint64(duration.Seconds())
+	}
+	return c.sAddAt(key, members, expiryTimestamp)
+}
+
+// SAddUntil is SAdd for a new set expiring at the given wall-clock time, which must be in the future
+func (c *BigCache) SAddUntil(key string, members [][]byte, expireAt time.Time) (int, error) {
+	expiryTimestamp := expireAt.Unix()
+	if expiryTimestamp <= c.clock.epoch() {
+		return 0, ErrExpiryInPast
+	}
+	return c.sAddAt(key, members, uint64(expiryTimestamp))
+}
+
+func (c *BigCache) sAddAt(key string, members [][]byte, expiryTimestamp uint64) (int, error) {
+	added := 0
+	c.makeRoom(itemsSize(key, members))
+	hashedKey := c.hash.Sum64(key)
+	shard := c.getShard(hashedKey)
+	_, err := shard.update(key, hashedKey, func(entry []byte, expiry uint64, found bool) ([]byte, uint64, error) {
+		if !found {
+			expiry = expiryTimestamp
+		}
+		set, n, err := AddToSet(entry, members)
+		added = n
+		return set, expiry, err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return added, nil
+}
+
+// SIsMember tells whether member is in the set under the key
+func (c *BigCache) SIsMember(key string, member []byte) (bool, error) {
+	entry, err := c.Get(key)
+	if err != nil {
+		return false, err
+	}
+	return SetContains(entry, member)
+}
+
+// SMembers returns the members of the set under the key, in no particular order
+func (c *BigCache) SMembers(key string) ([][]byte, error) {
+	entry, err := c.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return SetMembers(entry)
+}
+
+// AddToSet adds members to an encoded set, nil being the empty set, and returns the new set along with how
+// many members were not in it already
+func AddToSet(set []byte, members [][]byte) ([]byte, int, error) {
+	current, err := decodeSet(set)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	added := 0
+	for _, data := range members {
+		hash := setHash(data)
+		pos := sort.Search(len(current), func(i int) bool { return current[i].hash >= hash })
+		if indexInSet(current, pos, hash, data) >= 0 {
+			continue
+		}
+		current = append(current, setMember{})
+		copy(current[pos+1:], current[pos:])
+		current[pos] = setMember{hash: hash, data: data}
+		added++
+	}
+	if added == 0 && set != nil {
+		return set, 0, nil
+	}
+	return encodeSet(current), added, nil
+}
+
+// SetContains tells whether member is in an encoded set, without decoding the whole set
+func SetContains(set []byte, member []byte) (bool, error) {
+	count, err := setLen(set)
+	if err != nil {
+		return false, err
+	}
+
+	hash := setHash(member)
+	pos := sort.Search(count, func(i int) bool { return setRecordHash(set, i) >= hash })
+	for ; pos < count && setRecordHash(set, pos) == hash; pos++ {
+		if bytes.Equal(setRecordData(set, count, pos), member) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SetMembers returns the members of an encoded set
+func SetMembers(set []byte) ([][]byte, error) {
+	current, err := decodeSet(set)
+	if err != nil {
+		return nil, err
+	}
+	members := make([][]byte, len(current))
+	for i, member := range current {
+		members[i] = member.data
+	}
+	return members, nil
+}
+
+// position of data among the members from pos on sharing its hash, -1 if it is not there
+func indexInSet(members []setMember, pos int, hash uint64, data []byte) int {
+	for ; pos < len(members) && members[pos].hash == hash; pos++ {
+		if bytes.Equal(members[pos].data, data) {
+			return pos
+		}
+	}
+	return -1
+}
+
+func setHash(member []byte) uint64 {
+	return fnv64a{}.Sum64(string(member))
+}
+
+func setRecordHash(set []byte, i int) uint64 {
+	return binary.LittleEndian.Uint64(set[setCountSize+i*setRecordSize:])
+}
+
+func setRecordData(set []byte, count, i int) []byte {
+	record := set[setCountSize+i*setRecordSize:]
+	offset := setCountSize + count*setRecordSize + int(binary.LittleEndian.Uint32(record[8:]))
+	return set[offset : offset+int(binary.LittleEndian.Uint32(record[12:]))]
+}
+
+// number of members of an encoded set, checking every member is within it. nil is the empty set
+func setLen(set []byte) (int, error) {
+	if len(set) == 0 {
+		return 0, nil
+	}
+	if len(set) < setCountSize {
+		return 0, ErrNotSet
+	}
+	count := int(binary.LittleEndian.Uint32(set))
+	dataSize := len(set) - setCountSize - count*setRecordSize
+	if dataSize < 0 {
+		return 0, ErrNotSet
+	}
+	for i := 0; i < count; i++ {
+		record := set[setCountSize+i*setRecordSize:]
+		offset, size := int(binary.LittleEndian.Uint32(record[8:])), int(binary.LittleEndian.Uint32(record[12:]))
+		if offset+size > dataSize || (i > 0 && setRecordHash(set, i-1) > setRecordHash(set, i)) {
+			return 0, ErrNotSet
+		}
+	}
+	return count, nil
+}
+
+func decodeSet(set []byte) ([]setMember, error) {
+	count, err := setLen(set)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]setMember, count)
+	for i := range members {
+		members[i] = setMember{hash: setRecordHash(set, i), data: setRecordData(set, count, i)}
+	}
+	return members, nil
+}
+
+func encodeSet(members []setMember) []byte {
+	size := setCountSize + len(members)*setRecordSize
+	for _, member := range members {
+		size += len(member.data)
+	}
+
+	set := make([]byte, size)
+	binary.LittleEndian.PutUint32(set, uint32(len(members)))
+	offset := 0
+	data := set[setCountSize+len(members)*setRecordSize:]
+	for i, member := range members {
+		record := set[setCountSize+i*setRecordSize:]
+		binary.LittleEndian.PutUint64(record, member.hash)
+		binary.LittleEndian.PutUint32(record[8:], uint32(offset))
+		binary.LittleEndian.PutUint32(record[12:], uint32(len(member.data)))
+		offset += copy(data[offset:], member.data)
+	}
+	return set
+}
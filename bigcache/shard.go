@@ -1,24 +1,40 @@
 package bigcache
 
 import (
+	"fmt"
+	"path/filepath"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/emirpasic/gods/sets/hashset"
 	"github.com/nggenius/ngbigcache/bigcache/queue"
 )
 
 //NO_EXPIRY means data placed into a shard will never expire
 const NO_EXPIRY uint64 = 0
 
+//estimated bytes taken by an entry of the hashmap of a shard, including the room maps keep spare
+const hashmapEntryBytes = 24
+
+//estimated bytes taken by a key in the ttl manager besides the key itself
+const ttlEntryBytes = 32
+
+//number of entries moved while compacting before the shard lock is let go of for a while
+const compactBatchSize = 64
+
 type cacheShard struct {
+	// kept in step with the shard under its lock and read atomically, so polling the size
+	// of the cache never contends with readers and writers of hot shards
+	count     int64 // live entries
+	liveBytes int64 // bytes held by live entries, headers included
+	allocated int64 // bytes allocated by the queue
+
 	sharedNum   uint64
 	hashmap     map[uint64]uint32
 	entries     queue.BytesQueue
-	lock        sync.RWMutex
+	lock        stripedLock // readers of a key only take its stripe, see LockStripes
 	entryBuffer []byte
-	onRemove    func(wrappedEntry []byte)
+	onRemove    onRemoveCallback
 
 	isVerbose  bool
 	logger     Logger
@@ -27,37 +43,102 @@ type cacheShard struct {
 
 	stats    Stats
 	ttlTable *ttlManager
+
+	// only kept when HardMaxCacheSize is set, to evict the oldest entries of the cache when it is full
+	writes   map[uint64]uint64 // sequence of the latest write of every live entry
+	order    []writeRecord     // writes in the order they were made, stale ones included until they are dropped
+	sequence *uint64           // shared by all shards so the writes of different shards can be ordered
+	maxBytes int               // bytes the queue may grow to
+
+	maxValueSize int // largest value stored, 0 for no limit
+
+	onEvictionPass func(time.Duration, int) // called after every pass expiring entries, nil if not set
+
+	keys *keyIndex // keys of the live entries by prefix, only kept when IndexKeys is set
+
+	lockWaitBudget time.Duration                       // waits for the lock at least this long are reported, 0 for not timed
+	onSlowLock     func(uint64, uint64, time.Duration) // called with the waits reported
+
+	// entries read by an EntryReader stay in place until it is closed
+	pins       map[uint32]int  // number of open readers of the entry at an index
+	released   map[uint32]bool // pinned entries removed from the shard, their space is given back once unpinned
+	pinLock    sync.Mutex      // held along with the read lock to pin, the write lock keeps pins from changing
+	generation uint64          // bumped on reset so readers from before it have nothing to unpin
 }
 
-type onRemoveCallback func(wrappedEntry []byte)
+type onRemoveCallback func(wrappedEntry []byte, reason RemoveReason)
 
 func (s *cacheShard) get(key string, hashedKey uint64) ([]byte, error) {
-	s.lock.RLock()
+	entry, _, err := s.getWithExpiry(key, hashedKey)
+	return entry, err
+}
+
+// getWithExpiry reads the entry along with its expiry timestamp (unix seconds)
+func (s *cacheShard) getWithExpiry(key string, hashedKey uint64) ([]byte, uint64, error) {
+	return s.appendWithExpiry(nil, key, hashedKey)
+}
+
+// appendWithExpiry appends the entry to dst, or copies it to a buffer of its own when dst is nil, and returns it
+// along with its expiry timestamp (unix seconds)
+func (s *cacheShard) appendWithExpiry(dst []byte, key string, hashedKey uint64) ([]byte, uint64, error) {
+	stripe := s.rlockKey(hashedKey)
 	itemIndex := s.hashmap[hashedKey]
 
 	if itemIndex == 0 {
-		s.lock.RUnlock()
-		s.miss()
-		return nil, notFound(key)
+		stripe.RUnlock()
+		stripe.miss()
+		return nil, 0, notFound(key)
 	}
 
 	wrappedEntry, err := s.entries.Get(int(itemIndex))
 	if err != nil {
-		s.lock.RUnlock()
-		s.miss()
-		return nil, err
+		stripe.RUnlock()
+		stripe.miss()
+		return nil, 0, err
 	}
 	if entryKey := readKeyFromEntry(wrappedEntry); key != entryKey {
 		if s.isVerbose {
 			s.logger.Printf("Collision detected. Both %q and %q have the same hash %x", key, entryKey, hashedKey)
 		}
-		s.lock.RUnlock()
+		stripe.RUnlock()
 		s.collision()
-		return nil, notFound(key)
+		return nil, 0, notFound(key)
 	}
-	s.lock.RUnlock()
-	s.hit()
-	return readEntry(wrappedEntry), nil
+	if isNotFound(wrappedEntry) {
+		expiry := readTimestampFromEntry(wrappedEntry)
+		stripe.RUnlock()
+		stripe.hit()
+		return nil, expiry, cachedNotFound(key)
+	}
+	var entry []byte
+	if dst == nil {
+		entry = readEntry(wrappedEntry)
+	} else {
+		entry = append(dst, entryData(wrappedEntry)...)
+	}
+	expiry := readTimestampFromEntry(wrappedEntry)
+	stripe.RUnlock()
+	stripe.hit()
+	return entry, expiry, nil
+}
+
+// getExpiry reads the expiry timestamp (unix seconds) of the entry without copying it or counting a hit
+func (s *cacheShard) getExpiry(key string, hashedKey uint64) (uint64, error) {
+	defer s.lock.rlockKey(hashedKey).RUnlock()
+
+	itemIndex := s.hashmap[hashedKey]
+	if itemIndex == 0 {
+		return 0, notFound(key)
+	}
+
+	wrappedEntry, err := s.entries.Get(int(itemIndex))
+	if err != nil {
+		return 0, err
+	}
+	if readKeyFromEntry(wrappedEntry) != key {
+		return 0, notFound(key)
+	}
+	return readTimestampFromEntry(wrappedEntry), nil
 }
 
 func (s *cacheShard) set(key string, hashedKey uint64, entry []byte, duration time.Duration) (uint64, error) {
@@ -68,38 +149,116 @@ func (s *cacheShard) set(key string, hashedKey uint64, entry []byte, duration ti
 		expiryTimestamp = NO_EXPIRY
 	}
 
-	s.lock.Lock()
+	return s.setAt(key, hashedKey, entry, expiryTimestamp, false)
+}
+
+// setAt saves the entry with an absolute expiry timestamp (unix seconds), NO_EXPIRY keeps it forever. notFound
+// flags it as caching that the key was not found
+func (s *cacheShard) setAt(key string, hashedKey uint64, entry []byte, expiryTimestamp uint64, notFound bool) (uint64, error) {
+	s.lockKey(hashedKey)
+	err := s.push(key, hashedKey, entry, expiryTimestamp, notFound)
+	s.lock.Unlock()
+	if err != nil {
+		s.noSpace()
+		return 0, err
+	}
+	if expiryTimestamp != NO_EXPIRY {
+		s.ttlTable.put(expiryTimestamp, key)
+	}
+	return expiryTimestamp, nil
+}
 
+// push replaces the entry under the key in the queue, called with the shard lock held
+func (s *cacheShard) push(key string, hashedKey uint64, entry []byte, expiryTimestamp uint64, notFound bool) error {
+	replaced := false
 	if previousIndex := s.hashmap[hashedKey]; previousIndex != 0 {
 		if previousEntry, err := s.entries.Get(int(previousIndex)); err == nil {
 			timestamp := readTimestampFromEntry(previousEntry)
 			s.ttlTable.remove(timestamp, key)
+			s.removed(previousEntry)
+			s.forget(hashedKey)
 			resetKeyFromEntry(previousEntry)
 			s.delete(previousIndex)
+			s.overwritten()
+			replaced = true
 		}
 	}
 
 	w := wrapEntry(expiryTimestamp, hashedKey, key, entry, &s.entryBuffer)
+	if notFound {
+		markNotFound(w)
+	}
 
-	var err error
-	if index, err := s.entries.Push(w); err == nil {
-		s.hashmap[hashedKey] = uint32(index)
-		s.lock.Unlock()
-		if duration != time.Duration(NO_EXPIRY) {
-			s.ttlTable.put(expiryTimestamp, key)
+	index, err := s.entries.Push(w)
+	for err != nil && len(w) < s.maxBytes && s.evictOldestLocked() { //the queue is full, make room in it
+		index, err = s.entries.Push(w)
+	}
+	if err == queue.ErrFull {
+		err = ErrShardFull
+	}
+	if err != nil {
+		if replaced { //the old value is gone already, do not let the caller think it is still there
+			delete(s.hashmap, hashedKey)
+			atomic.StoreInt64(&s.count, int64(len(s.hashmap)))
+			return &EntryLostError{Key: key, Err: err}
 		}
-		return expiryTimestamp, nil
+		return err
 	}
 
-	return 0, err
+	s.hashmap[hashedKey] = uint32(index)
+	s.added(w)
+	s.written(hashedKey)
+	return nil
 }
 
-func (s *cacheShard) evictDel(timeStamp uint64, set *hashset.Set) error {
+// update replaces the entry under the key with what fn makes of the current one, with the shard locked so no
+// other write comes in between. found is false when there is no live entry, fn then gets a nil entry
+func (s *cacheShard) update(key string, hashedKey uint64,
+	fn func(entry []byte, expiry uint64, found bool) ([]byte, uint64, error)) (uint64, error) {
+	s.lockKey(hashedKey)
+
+	var current []byte
+	var expiry uint64
+	found := false
+	if index := s.hashmap[hashedKey]; index != 0 {
+		if wrappedEntry, err := s.entries.Get(int(index)); err == nil && readKeyFromEntry(wrappedEntry) == key &&
+			!isNotFound(wrappedEntry) { //an update writes over a key cached as not found
+			expiry = readTimestampFromEntry(wrappedEntry)
+			if expiry == NO_EXPIRY || expiry > uint64(s.clock.epoch()) { //expired entries wait for the wheel
+				current, found = readEntry(wrappedEntry), true
+			}
+		}
+	}
+	if !found {
+		expiry = NO_EXPIRY
+	}
+
+	entry, expiryTimestamp, err := fn(current, expiry, found)
+	if err == nil {
+		err = checkValueSize(key, entry, s.maxValueSize) //appending may grow a value past the limit
+	}
+	if err != nil {
+		s.lock.Unlock()
+		return 0, err
+	}
+	err = s.push(key, hashedKey, entry, expiryTimestamp, false)
+	s.lock.Unlock()
+	if err != nil {
+		s.noSpace()
+		return 0, err
+	}
+	if expiryTimestamp != NO_EXPIRY {
+		s.ttlTable.put(expiryTimestamp, key)
+	}
+	return expiryTimestamp, nil
+}
+
+func (s *cacheShard) evictDel(expired wheelSlot) error {
 	s.lock.Lock()
 
-	for _, v := range set.Values() { // remove all data in the hashset for this eviction
-		s.stats.EvictCount++
-		keyHash := s.ttlTable.ShardHasher.Sum64(v.(string))
+	for key, timeStamp := range expired { // remove every key expiring in this second
+		atomic.AddInt64(&s.stats.EvictCount, 1)
+		keyHash := s.ttlTable.ShardHasher.Sum64(key)
 		itemIndex := s.hashmap[keyHash]
 
 		if itemIndex == 0 {
@@ -116,7 +275,10 @@ func (s *cacheShard) evictDel(timeStamp uint64, set *hashset.Set) error {
 		storedTimestamp := readTimestampFromEntry(wrappedEntry)
 		if storedTimestamp == timeStamp {
 			delete(s.hashmap, keyHash)
-			s.onRemove(wrappedEntry)
+			s.removed(wrappedEntry)
+			s.forget(keyHash)
+			s.onRemove(wrappedEntry, Expired)
+			s.removedFor(Expired)
 			resetKeyFromEntry(wrappedEntry)
 			s.delete(itemIndex)
 			s.delhit()
@@ -130,24 +292,42 @@ func (s *cacheShard) evictDel(timeStamp uint64, set *hashset.Set) error {
 }
 
 func (s *cacheShard) del(key string, hashedKey uint64) error {
-	s.lock.Lock()
+	_, err := s.delIf(key, hashedKey, nil)
+	return err
+}
+
+// delIf removes the entry under the key, when match is given only if it accepts the live entry held under the
+// key, and reports whether it was removed
+func (s *cacheShard) delIf(key string, hashedKey uint64, match func(entry []byte) bool) (bool, error) {
+	s.lockKey(hashedKey)
 	itemIndex := s.hashmap[hashedKey]
 
 	if itemIndex == 0 {
 		s.lock.Unlock()
 		s.delmiss()
-		return notFound(key)
+		return false, notFound(key)
 	}
 
 	wrappedEntry, err := s.entries.Get(int(itemIndex))
 	if err != nil {
 		s.lock.Unlock()
 		s.delmiss()
-		return err
+		return false, err
+	}
+	if match != nil {
+		expiry := readTimestampFromEntry(wrappedEntry)
+		if readKeyFromEntry(wrappedEntry) != key || isNotFound(wrappedEntry) ||
+			(expiry != NO_EXPIRY && expiry <= uint64(s.clock.epoch())) || !match(entryData(wrappedEntry)) {
+			s.lock.Unlock()
+			return false, nil
+		}
 	}
 
 	delete(s.hashmap, hashedKey)
-	s.onRemove(wrappedEntry)
+	s.removed(wrappedEntry)
+	s.forget(hashedKey)
+	s.onRemove(wrappedEntry, Deleted)
+	s.removedFor(Deleted)
 	resetKeyFromEntry(wrappedEntry)
 	s.delete(itemIndex)
 	s.lock.Unlock()
@@ -156,7 +336,7 @@ func (s *cacheShard) del(key string, hashedKey uint64) error {
 	s.ttlTable.remove(timestamp, key)
 
 	s.delhit()
-	return nil
+	return true, nil
 }
 
 func (s *cacheShard) onEvict(oldestEntry []byte, currentTimestamp uint64, evict func() error) bool {
@@ -207,7 +387,10 @@ func (s *cacheShard) removeOldestEntry() error {
 	if err == nil {
 		hash := readHashFromEntry(oldest)
 		delete(s.hashmap, hash)
-		s.onRemove(oldest)
+		s.removed(oldest)
+		s.forget(hash)
+		s.onRemove(oldest, NoSpace)
+		s.removedFor(NoSpace)
 		return nil
 	}
 	return err
@@ -215,30 +398,109 @@ func (s *cacheShard) removeOldestEntry() error {
 
 func (s *cacheShard) reset(config Config) {
 	s.lock.Lock()
+	atomic.AddInt64(&s.stats.Cleared, int64(len(s.hashmap)))
 	s.hashmap = make(map[uint64]uint32, config.initialShardSize())
 	s.entryBuffer = make([]byte, config.MaxEntrySize+headersSizeInBytes)
-	s.entries.Reset()
+	if len(s.pins) > 0 && config.MmapDir == "" { //open readers keep the former queue until they are closed
+		entries, _ := newQueue(config, s.sharedNum)
+		s.entries = *entries
+	} else {
+		s.entries.Reset()
+	}
+	s.pins = make(map[uint32]int)
+	s.released = make(map[uint32]bool)
+	s.generation++
 	s.ttlTable.reset()
+	if s.writes != nil {
+		s.writes = make(map[uint64]uint64, config.initialShardSize())
+		s.order = nil
+	}
+	if s.keys != nil {
+		s.keys = newKeyIndex()
+	}
+	atomic.StoreInt64(&s.count, 0)
+	atomic.StoreInt64(&s.liveBytes, 0)
+	atomic.StoreInt64(&s.allocated, int64(s.entries.Capacity()))
 	s.lock.Unlock()
 }
 
+// added accounts for an entry pushed into the queue, called with the lock held
+func (s *cacheShard) added(wrappedEntry []byte) {
+	atomic.StoreInt64(&s.count, int64(len(s.hashmap)))
+	atomic.AddInt64(&s.liveBytes, int64(len(wrappedEntry)))
+	atomic.StoreInt64(&s.allocated, int64(s.entries.Capacity()))
+	if s.keys != nil && !isNotFound(wrappedEntry) {
+		s.keys.add(readKeyFromEntry(wrappedEntry))
+	}
+}
+
+// removed accounts for an entry dropped from the shard, called with the lock held
+func (s *cacheShard) removed(wrappedEntry []byte) {
+	atomic.StoreInt64(&s.count, int64(len(s.hashmap)))
+	atomic.AddInt64(&s.liveBytes, -int64(len(wrappedEntry)))
+	if s.keys != nil {
+		s.keys.remove(readKeyFromEntry(wrappedEntry))
+	}
+}
+
 func (s *cacheShard) len() int {
+	return int(atomic.LoadInt64(&s.count))
+}
+
+func (s *cacheShard) size() int {
+	return int(atomic.LoadInt64(&s.liveBytes))
+}
+
+func (s *cacheShard) capacity() int {
+	return int(atomic.LoadInt64(&s.allocated))
+}
+
+// memory estimates the memory held by the shard
+func (s *cacheShard) memory(initialSize int) MemoryUsage {
 	s.lock.RLock()
-	res := len(s.hashmap)
+	indexed := max(len(s.hashmap), initialSize) //a map never gives back the room it grew to
+	if s.writes != nil {
+		indexed += max(len(s.writes), initialSize) + cap(s.order)
+	}
+	buffers := len(s.entryBuffer)
 	s.lock.RUnlock()
-	return res
-}
 
-func (s *cacheShard) getStats() Stats {
-	return s.stats
+	expiring, keyBytes := s.ttlTable.size()
+	return MemoryUsage{
+		Queues:  s.capacity(),
+		Index:   indexed * hashmapEntryBytes,
+		TTL:     expiring*ttlEntryBytes + keyBytes,
+		Buffers: buffers,
+	}
 }
 
-func (s *cacheShard) hit() {
-	atomic.AddInt64(&s.stats.Hits, 1)
+func (s *cacheShard) getStats() Stats {
+	hits, misses := s.lock.counts()
+	return Stats{
+		Hits:        atomic.LoadInt64(&s.stats.Hits) + hits,
+		Misses:      atomic.LoadInt64(&s.stats.Misses) + misses,
+		DelHits:     atomic.LoadInt64(&s.stats.DelHits),
+		DelMisses:   atomic.LoadInt64(&s.stats.DelMisses),
+		Collisions:  atomic.LoadInt64(&s.stats.Collisions),
+		EvictCount:  atomic.LoadInt64(&s.stats.EvictCount),
+		Expired:     atomic.LoadInt64(&s.stats.Expired),
+		Evicted:     atomic.LoadInt64(&s.stats.Evicted),
+		Deleted:     atomic.LoadInt64(&s.stats.Deleted),
+		Overwritten: atomic.LoadInt64(&s.stats.Overwritten),
+		Cleared:     atomic.LoadInt64(&s.stats.Cleared),
+		NoSpace:     atomic.LoadInt64(&s.stats.NoSpace),
+		BytesUsed:   int64(s.size()),
+		Reclaimed:   atomic.LoadInt64(&s.stats.Reclaimed),
+	}
 }
 
-func (s *cacheShard) miss() {
-	atomic.AddInt64(&s.stats.Misses, 1)
+// shardStats returns the statistics of the shard along with how full it is
+func (s *cacheShard) shardStats() ShardStats {
+	stats := ShardStats{Stats: s.getStats(), Entries: s.len(), Capacity: s.capacity()}
+	if stats.Capacity > 0 {
+		stats.Utilization = float64(stats.BytesUsed) / float64(stats.Capacity)
+	}
+	return stats
 }
 
 func (s *cacheShard) delhit() {
@@ -253,7 +515,72 @@ func (s *cacheShard) collision() {
 	atomic.AddInt64(&s.stats.Collisions, 1)
 }
 
+func (s *cacheShard) noSpace() {
+	atomic.AddInt64(&s.stats.NoSpace, 1)
+}
+
+// removedFor counts an entry removed from the shard by the reason it was removed
+func (s *cacheShard) removedFor(reason RemoveReason) {
+	switch reason {
+	case Expired:
+		atomic.AddInt64(&s.stats.Expired, 1)
+	case NoSpace:
+		atomic.AddInt64(&s.stats.Evicted, 1)
+	case Deleted:
+		atomic.AddInt64(&s.stats.Deleted, 1)
+	}
+}
+
+func (s *cacheShard) overwritten() {
+	atomic.AddInt64(&s.stats.Overwritten, 1)
+}
+
+// compact moves entries over the holes left by deleted ones, a batch at a time, until no hole is left or done
+// returns true. Returns the bytes reclaimed and whether holes remain
+func (s *cacheShard) compact(done func() bool) (int, bool) {
+	reclaimed := 0
+	for {
+		s.lock.Lock()
+		more := true
+		for x := 0; x < compactBatchSize && more; x++ {
+			var n int
+			n, more = s.entries.Compact(s.moved, s.pinned)
+			reclaimed += n
+		}
+		s.lock.Unlock()
+
+		if !more || done() {
+			atomic.AddInt64(&s.stats.Reclaimed, int64(reclaimed))
+			return reclaimed, more
+		}
+	}
+}
+
+// moved points the hashmap at the index an entry was moved to while compacting, called with the lock held
+func (s *cacheShard) moved(from, to int) {
+	wrappedEntry, err := s.entries.Get(to)
+	if err != nil {
+		return
+	}
+	if hash := readHashFromEntry(wrappedEntry); s.hashmap[hash] == uint32(from) {
+		s.hashmap[hash] = uint32(to)
+	}
+}
+
+// fragmented returns the number of bytes held by holes left by deleted entries
+func (s *cacheShard) fragmented() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.entries.Fragmented()
+}
+
+// delete gives back the space of the entry at index, once the readers of it are closed if it is pinned
 func (s *cacheShard) delete(index uint32) {
+	if s.pins[index] > 0 {
+		s.released[index] = true
+		return
+	}
 	s.entries.Delete(int(index))
 }
 
@@ -269,24 +596,46 @@ func (s *cacheShard) delete(index uint32) {
 //
 //	s.fList.adjustIndexes(index, diff)
 //}
-//func (s *cacheShard) doCompact() {
-//	for range time.NewTicker(time.Second * 10).C {
-//		s.lock.Lock()
-//		for x := 0; x < 512; x++ {
-//			index, err := s.fList.Pop()
-//			if err != nil {
-//				break
-//			}
-//			s.Delete(uint32(index))
-//		}
-//		s.lock.Unlock()
-//	}
-//}
 
-func initNewShard(config Config, callback onRemoveCallback, clock clock, num uint64) *cacheShard {
+// lockKey takes the lock of the shard to write the key hashed to hashedKey, timing the wait when a LockWaitBudget is set
+func (s *cacheShard) lockKey(hashedKey uint64) {
+	if s.lockWaitBudget == 0 {
+		s.lock.Lock()
+		return
+	}
+
+	started := s.clock.Now()
+	s.lock.Lock()
+	if waited := Since(s.clock, started); waited >= s.lockWaitBudget {
+		s.onSlowLock(s.sharedNum, hashedKey, waited)
+	}
+}
+
+// rlockKey takes the stripe of the lock of the shard to read the key hashed to hashedKey, timing the wait when a
+// LockWaitBudget is set
+func (s *cacheShard) rlockKey(hashedKey uint64) *lockStripe {
+	if s.lockWaitBudget == 0 {
+		return s.lock.rlockKey(hashedKey)
+	}
+
+	started := s.clock.Now()
+	stripe := s.lock.rlockKey(hashedKey)
+	if waited := Since(s.clock, started); waited >= s.lockWaitBudget {
+		s.onSlowLock(s.sharedNum, hashedKey, waited)
+	}
+	return stripe
+}
+
+func initNewShard(config Config, callback onRemoveCallback, clock clock, num uint64, sequence *uint64) (*cacheShard, error) {
+	entries, err := newQueue(config, num)
+	if err != nil {
+		return nil, err
+	}
+
 	shard := &cacheShard{
+		lock:        newStripedLock(max(config.LockStripes, 1)),
 		hashmap:     make(map[uint64]uint32, config.initialShardSize()),
-		entries:     *queue.NewBytesQueue(config.initialShardSize()*config.MaxEntrySize, config.maximumShardSize(), config.Verbose),
+		entries:     *entries,
 		entryBuffer: make([]byte, config.MaxEntrySize+headersSizeInBytes),
 		onRemove:    callback,
 
@@ -295,11 +644,48 @@ func initNewShard(config Config, callback onRemoveCallback, clock clock, num uin
 		clock:      clock,
 		lifeWindow: uint64(config.LifeWindow.Seconds()),
 		sharedNum:  num,
+		pins:       make(map[uint32]int),
+		released:   make(map[uint32]bool),
+
+		maxValueSize:   config.MaxValueSize,
+		onEvictionPass: config.OnEvictionPass,
+	}
+	if config.LockWaitBudget > 0 && config.OnSlowLock != nil {
+		shard.lockWaitBudget = config.LockWaitBudget
+		shard.onSlowLock = config.OnSlowLock
+	}
+
+	if config.HardMaxCacheSize > 0 {
+		shard.writes = make(map[uint64]uint64, config.initialShardSize())
+		shard.sequence = sequence
+		shard.maxBytes = config.maximumShardSize()
+	}
+
+	if config.IndexKeys {
+		shard.keys = newKeyIndex()
 	}
 
 	shard.ttlTable = newTtlManager(shard, config.Hasher)
+	shard.allocated = int64(shard.entries.Capacity())
 
-	//go shard.doCompact()
+	return shard, nil
+}
+
+// newQueue creates the queue of shard num, mapped to a file in MmapDir when it is set
+func newQueue(config Config, num uint64) (*queue.BytesQueue, error) {
+	initialCapacity := config.initialShardSize() * config.MaxEntrySize
+	if config.MmapDir == "" {
+		return queue.NewBytesQueue(initialCapacity, config.maximumShardSize(), config.Verbose), nil
+	}
+
+	path := filepath.Join(config.MmapDir, fmt.Sprintf("shard-%d.queue", num))
+	return queue.NewMappedBytesQueue(path, initialCapacity, config.maximumShardSize(), config.Verbose)
+}
+
+// close lets go of the file the queue is mapped to, if any
+func (s *cacheShard) close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 
-	return shard
+	return s.entries.Close()
 }
@@ -0,0 +1,527 @@
+package bigcache
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Snapshot layout:
+//
+//	header: 8 byte magic, 1 byte format version, 1 byte codec id
+//	stats:  4 byte shard count, then per shard the 8 byte Expired, Evicted, Deleted, Overwritten and Cleared counts
+//	chunks: 4 byte compressed size, 4 byte uncompressed size, 4 byte entry count, compressed entries
+//	end:    a chunk header with every field set to zero
+//
+// Every chunk is compressed on its own so chunks can be encoded and restored in parallel.
+// Entries inside a chunk are 8 byte expiry timestamp, 2 byte key length, 4 byte value length, key, value.
+// The highest bit of the key length flags the entries caching that their key was not found.
+// All integers are little endian. Version 1 snapshots have no stats, they are still restored.
+const (
+	snapshotMagic           = "NGBCSNAP"
+	snapshotVersion         = 2
+	snapshotHeaderSize      = len(snapshotMagic) + 2
+	snapshotChunkHeaderSize = 12
+	snapshotEntryHeaderSize = 8 + 2 + 4
+	snapshotRemovalCounts   = 5
+	maxSnapshotShards       = 1 << 16 //more shards than that in the stats means the snapshot is corrupted
+	snapshotZstdWindow      = 8 << 20 //zstd window chunks are written with, a frame asking for a larger one is refused
+
+	// DefaultSnapshotChunkSize is the number of entry bytes compressed together when SnapshotOptions.ChunkSize is not set
+	DefaultSnapshotChunkSize = 4 * 1024 * 1024
+)
+
+// Ids of the snapshot codecs. Ids are written into the snapshot so they must never change.
+const (
+	SnapshotCodecNone  byte = 0
+	SnapshotCodecFlate byte = 1
+	SnapshotCodecZstd  byte = 2
+)
+
+var (
+	// ErrBadSnapshot is returned when restoring from data that is not a snapshot or is corrupted
+	ErrBadSnapshot = errors.New("not a valid snapshot")
+	// ErrUnknownSnapshotCodec is returned when a snapshot was written with a codec that is not registered
+	ErrUnknownSnapshotCodec = errors.New("snapshot codec is not registered")
+)
+
+// SnapshotCodec compresses the chunks of a snapshot. Implementations must be safe for concurrent use.
+type SnapshotCodec interface {
+	// ID identifies the codec in the snapshot header
+	ID() byte
+	// Compress writes the compressed form of src to dst. Level 0 means the codec's default level.
+	Compress(dst io.Writer, src []byte, level int) error
+	// Decompress returns the uncompressed form of src, which is rawSize bytes long
+	Decompress(src []byte, rawSize int) ([]byte, error)
+}
+
+// SnapshotOptions tunes how a snapshot is written and restored
+type SnapshotOptions struct {
+	// Codec used to compress chunks, flate by default. Ignored on restore, the codec is read from the snapshot.
+	Codec SnapshotCodec
+	// Level is the compression level, its meaning depends on the codec. 0 means the codec's default.
+	Level int
+	// Workers caps the number of goroutines (and so CPUs) compressing or restoring chunks. Defaults to GOMAXPROCS.
+	Workers int
+	// ChunkSize is the number of entry bytes compressed together. Defaults to DefaultSnapshotChunkSize.
+	ChunkSize int
+}
+
+var (
+	snapshotCodecsLock sync.RWMutex
+	snapshotCodecs     = map[byte]SnapshotCodec{
+		SnapshotCodecNone:  noneCodec{},
+		SnapshotCodecFlate: flateCodec{},
+		SnapshotCodecZstd:  ZstdCodec,
+	}
+)
+
+// RegisterSnapshotCodec makes a codec available for writing and restoring snapshots
+func RegisterSnapshotCodec(codec SnapshotCodec) {
+	snapshotCodecsLock.Lock()
+	snapshotCodecs[codec.ID()] = codec
+	snapshotCodecsLock.Unlock()
+}
+
+func snapshotCodec(id byte) (SnapshotCodec, bool) {
+	snapshotCodecsLock.RLock()
+	codec, ok := snapshotCodecs[id]
+	snapshotCodecsLock.RUnlock()
+	return codec, ok
+}
+
+type noneCodec struct{}
+
+func (noneCodec) ID() byte { return SnapshotCodecNone }
+
+func (noneCodec) Compress(dst io.Writer, src []byte, level int) error {
+	_, err := dst.Write(src)
+	return err
+}
+
+func (noneCodec) Decompress(src []byte, rawSize int) ([]byte, error) {
+	return src, nil
+}
+
+type flateCodec struct{}
+
+func (flateCodec) ID() byte { return SnapshotCodecFlate }
+
+func (flateCodec) Compress(dst io.Writer, src []byte, level int) error {
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	w, err := flate.NewWriter(dst, level)
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(src); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+func (flateCodec) Decompress(src []byte, rawSize int) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+
+	return readRaw(r, rawSize)
+}
+
+// ZstdCodec compresses the chunks of a snapshot with zstandard, faster than flate for a smaller snapshot. Its
+// level is a zstd level from 1 to 22, 0 being the default level of 3
+var ZstdCodec SnapshotCodec = &zstdCodec{}
+
+type zstdCodec struct {
+	encoders sync.Map  // by level, EncodeAll is safe for concurrent use
+	decoders sync.Pool // a streaming decoder is used by one chunk at a time
+}
+
+func (*zstdCodec) ID() byte { return SnapshotCodecZstd }
+
+func (z *zstdCodec) Compress(dst io.Writer, src []byte, level int) error {
+	encoder, ok := z.encoders.Load(level)
+	if !ok {
+		speed := zstd.SpeedDefault
+		if level != 0 {
+			speed = zstd.EncoderLevelFromZstd(level)
+		}
+		created, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(speed), zstd.WithEncoderConcurrency(1),
+			zstd.WithWindowSize(snapshotZstdWindow))
+		if err != nil {
+			return err
+		}
+		if encoder, ok = z.encoders.LoadOrStore(level, created); ok {
+			created.Close()
+		}
+	}
+	_, err := dst.Write(encoder.(*zstd.Encoder).EncodeAll(src, nil))
+	return err
+}
+
+func (z *zstdCodec) Decompress(src []byte, rawSize int) ([]byte, error) {
+	decoder, _ := z.decoders.Get().(*zstd.Decoder)
+	if decoder == nil {
+		var err error
+		decoder, err = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(snapshotZstdWindow))
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer z.decoders.Put(decoder)
+
+	if err := decoder.Reset(bytes.NewReader(src)); err != nil {
+		return nil, err
+	}
+	return readRaw(decoder, rawSize)
+}
+
+// read the rawSize bytes a chunk decompresses to. The buffer grows with the data actually decompressed rather
+// than being sized off the chunk header, so a corrupted or forged size cannot make a restore allocate more than
+// the chunk really holds
+func readRaw(r io.Reader, rawSize int) ([]byte, error) {
+	initial := rawSize
+	if initial > DefaultSnapshotChunkSize {
+		initial = DefaultSnapshotChunkSize
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, initial))
+	if _, err := buf.ReadFrom(io.LimitReader(r, int64(rawSize)+1)); err != nil {
+		return nil, err
+	}
+	if buf.Len() != rawSize {
+		return nil, ErrBadSnapshot
+	}
+	return buf.Bytes(), nil
+}
+
+func (o SnapshotOptions) withDefaults() SnapshotOptions {
+	if o.Codec == nil {
+		o.Codec = flateCodec{}
+	}
+	if o.Workers < 1 {
+		o.Workers = runtime.GOMAXPROCS(0)
+	}
+	if o.ChunkSize < 1 {
+		o.ChunkSize = DefaultSnapshotChunkSize
+	}
+	return o
+}
+
+type snapshotChunk struct {
+	data    []byte
+	rawSize int
+	count   int
+}
+
+// Snapshot writes every entry of the cache to w, returning the number of entries written.
+// Chunks of entries are compressed by a pool of workers so the order of entries in the snapshot is not fixed.
+// Writes to the cache are not blocked while the snapshot is taken, entries changed meanwhile may or may not be in it.
+func (c *BigCache) Snapshot(w io.Writer, opts SnapshotOptions) (int, error) {
+	opts = opts.withDefaults()
+
+	header := make([]byte, snapshotHeaderSize)
+	copy(header, snapshotMagic)
+	header[len(snapshotMagic)] = snapshotVersion
+	header[len(snapshotMagic)+1] = opts.Codec.ID()
+	if _, err := w.Write(header); err != nil {
+		return 0, err
+	}
+	if _, err := w.Write(c.encodeRemovalStats()); err != nil {
+		return 0, err
+	}
+
+	done := make(chan struct{})
+	raw := make(chan snapshotChunk, opts.Workers)
+	compressed := make(chan snapshotChunk, opts.Workers)
+
+	go func() {
+		defer close(raw)
+		c.collectChunks(opts.ChunkSize, raw, done)
+	}()
+
+	var workers sync.WaitGroup
+	var encodeErr atomic.Value
+	for x := 0; x < opts.Workers; x++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for chunk := range raw {
+				var buf bytes.Buffer
+				if err := opts.Codec.Compress(&buf, chunk.data, opts.Level); err != nil {
+					encodeErr.Store(err)
+					continue
+				}
+				select {
+				case compressed <- snapshotChunk{data: buf.Bytes(), rawSize: len(chunk.data), count: chunk.count}:
+				case <-done:
+				}
+			}
+		}()
+	}
+	go func() {
+		workers.Wait()
+		close(compressed)
+	}()
+
+	count := 0
+	var err error
+	chunkHeader := make([]byte, snapshotChunkHeaderSize)
+	for chunk := range compressed {
+		if err != nil {
+			continue // drain so the workers can exit
+		}
+		binary.LittleEndian.PutUint32(chunkHeader, uint32(len(chunk.data)))
+		binary.LittleEndian.PutUint32(chunkHeader[4:], uint32(chunk.rawSize))
+		binary.LittleEndian.PutUint32(chunkHeader[8:], uint32(chunk.count))
+		if _, err = w.Write(chunkHeader); err == nil {
+			_, err = w.Write(chunk.data)
+		}
+		if err != nil {
+			close(done)
+			continue
+		}
+		count += chunk.count
+	}
+
+	if err != nil {
+		return count, err
+	}
+	if e, ok := encodeErr.Load().(error); ok {
+		return count, e
+	}
+
+	for x := range chunkHeader {
+		chunkHeader[x] = 0
+	}
+	_, err = w.Write(chunkHeader)
+	return count, err
+}
+
+// walk every shard, cutting its live entries into chunks of about chunkSize bytes
+func (c *BigCache) collectChunks(chunkSize int, chunks chan<- snapshotChunk, done <-chan struct{}) {
+	buf := make([]byte, 0, chunkSize)
+	count := 0
+	record := make([]byte, snapshotEntryHeaderSize)
+
+	flush := func() bool {
+		if count == 0 {
+			return true
+		}
+		select {
+		case chunks <- snapshotChunk{data: buf, count: count}:
+		case <-done:
+			return false
+		}
+		buf = make([]byte, 0, chunkSize)
+		count = 0
+		return true
+	}
+
+	for _, shard := range c.shardTable().all() {
+		indexes, n := shard.copyKeys()
+		for _, index := range indexes[:n] {
+			shard.lock.RLock()
+			wrapped, err := shard.entries.Get(int(index))
+			if err != nil || shard.hashmap[readHashFromEntry(wrapped)] != index { // removed since the keys were copied
+				shard.lock.RUnlock()
+				continue
+			}
+			keySize := binary.LittleEndian.Uint16(wrapped[timestampSizeInBytes+hashSizeInBytes:]) // along with its flag
+			keyLength := int(keySize & keySizeMask)
+			key := wrapped[headersSizeInBytes : headersSizeInBytes+keyLength]
+			value := wrapped[headersSizeInBytes+keyLength:]
+
+			binary.LittleEndian.PutUint64(record, readTimestampFromEntry(wrapped))
+			binary.LittleEndian.PutUint16(record[8:], keySize)
+			binary.LittleEndian.PutUint32(record[10:], uint32(len(value)))
+			buf = append(buf, record...)
+			buf = append(buf, key...)
+			buf = append(buf, value...)
+			shard.lock.RUnlock()
+
+			count++
+			if len(buf) >= chunkSize && !flush() {
+				return
+			}
+		}
+	}
+	flush()
+}
+
+// Restore loads the entries of a snapshot written by Snapshot into the cache, returning the number of entries restored.
+// Entries that expired since the snapshot was taken are skipped. Chunks are restored in parallel by opts.Workers goroutines.
+func (c *BigCache) Restore(r io.Reader, opts SnapshotOptions) (int, error) {
+	opts = opts.withDefaults()
+	reader := bufio.NewReader(r)
+
+	header := make([]byte, snapshotHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return 0, err
+	}
+	version := header[len(snapshotMagic)]
+	if string(header[:len(snapshotMagic)]) != snapshotMagic || version < 1 || version > snapshotVersion {
+		return 0, ErrBadSnapshot
+	}
+	codec, ok := snapshotCodec(header[len(snapshotMagic)+1])
+	if !ok {
+		return 0, fmt.Errorf("%s: id %d", ErrUnknownSnapshotCodec, header[len(snapshotMagic)+1])
+	}
+	if version >= 2 {
+		if err := c.restoreRemovalStats(reader); err != nil {
+			return 0, err
+		}
+	}
+
+	chunks := make(chan snapshotChunk, opts.Workers)
+	var restored int64
+	var restoreErr atomic.Value
+	var workers sync.WaitGroup
+	for x := 0; x < opts.Workers; x++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for chunk := range chunks {
+				n, err := c.restoreChunk(codec, chunk)
+				atomic.AddInt64(&restored, int64(n))
+				if err != nil {
+					restoreErr.Store(err)
+				}
+			}
+		}()
+	}
+
+	err := readChunks(reader, chunks)
+	close(chunks)
+	workers.Wait()
+
+	if err == nil {
+		if e, ok := restoreErr.Load().(error); ok {
+			err = e
+		}
+	}
+	return int(restored), err
+}
+
+// the lifetime counts of entries removed by reason of every shard, as written in the snapshot
+func (c *BigCache) encodeRemovalStats() []byte {
+	shards := c.shardTable().all()
+	block := make([]byte, 4+len(shards)*snapshotRemovalCounts*8)
+	binary.LittleEndian.PutUint32(block, uint32(len(shards)))
+	offset := 4
+	for _, shard := range shards {
+		stats := shard.getStats()
+		for _, count := range []int64{stats.Expired, stats.Evicted, stats.Deleted, stats.Overwritten, stats.Cleared} {
+			binary.LittleEndian.PutUint64(block[offset:], uint64(count))
+			offset += 8
+		}
+	}
+	return block
+}
+
+// add the counts of entries removed by reason read off the snapshot to those of the shards, so they keep counting
+// across restarts. When the snapshot was taken with another number of shards the counts all go to the first shard
+func (c *BigCache) restoreRemovalStats(reader io.Reader) error {
+	size := make([]byte, 4)
+	if _, err := io.ReadFull(reader, size); err != nil {
+		return ErrBadSnapshot
+	}
+	shards := int(binary.LittleEndian.Uint32(size))
+	if shards > maxSnapshotShards {
+		return ErrBadSnapshot
+	}
+	block := make([]byte, shards*snapshotRemovalCounts*8)
+	if _, err := io.ReadFull(reader, block); err != nil {
+		return ErrBadSnapshot
+	}
+
+	current := c.shardTable().shards
+	for x := 0; x < shards; x++ {
+		shard := current[0]
+		if shards == len(current) {
+			shard = current[x]
+		}
+		counts := block[x*snapshotRemovalCounts*8:]
+		for y, count := range []*int64{&shard.stats.Expired, &shard.stats.Evicted, &shard.stats.Deleted,
+			&shard.stats.Overwritten, &shard.stats.Cleared} {
+			atomic.AddInt64(count, int64(binary.LittleEndian.Uint64(counts[y*8:])))
+		}
+	}
+	return nil
+}
+
+// read chunks off the snapshot until the end marker
+func readChunks(reader io.Reader, chunks chan<- snapshotChunk) error {
+	chunkHeader := make([]byte, snapshotChunkHeaderSize)
+	for {
+		if _, err := io.ReadFull(reader, chunkHeader); err != nil {
+			if err == io.EOF {
+				return ErrBadSnapshot // the end marker is missing so the snapshot was cut short
+			}
+			return err
+		}
+
+		size := binary.LittleEndian.Uint32(chunkHeader)
+		rawSize := binary.LittleEndian.Uint32(chunkHeader[4:])
+		count := binary.LittleEndian.Uint32(chunkHeader[8:])
+		if size == 0 && rawSize == 0 && count == 0 {
+			return nil
+		}
+
+		data, err := ioutil.ReadAll(io.LimitReader(reader, int64(size)))
+		if err != nil {
+			return err
+		}
+		if len(data) != int(size) {
+			return ErrBadSnapshot
+		}
+		chunks <- snapshotChunk{data: data, rawSize: int(rawSize), count: int(count)}
+	}
+}
+
+func (c *BigCache) restoreChunk(codec SnapshotCodec, chunk snapshotChunk) (int, error) {
+	data, err := codec.Decompress(chunk.data, chunk.rawSize)
+	if err != nil {
+		return 0, err
+	}
+
+	now := uint64(c.clock.epoch())
+	restored := 0
+	for len(data) > 0 {
+		if len(data) < snapshotEntryHeaderSize {
+			return restored, ErrBadSnapshot
+		}
+		expiry := binary.LittleEndian.Uint64(data)
+		keySize := binary.LittleEndian.Uint16(data[8:])
+		keyLength := int(keySize & keySizeMask)
+		valueLength := int(binary.LittleEndian.Uint32(data[10:]))
+		data = data[snapshotEntryHeaderSize:]
+		if len(data) < keyLength+valueLength {
+			return restored, ErrBadSnapshot
+		}
+		key := string(data[:keyLength])
+		value := data[keyLength : keyLength+valueLength]
+		data = data[keyLength+valueLength:]
+
+		if expiry != NO_EXPIRY && expiry <= now {
+			continue
+		}
+
+		c.makeRoom(entrySize(key, value))
+		hashedKey := c.hash.Sum64(key)
+		if _, err := c.getShard(hashedKey).setAt(key, hashedKey, value, expiry, keySize&notFoundFlag != 0); err != nil {
+			return restored, err
+		}
+		restored++
+	}
+	return restored, nil
+}
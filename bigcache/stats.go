@@ -12,6 +12,48 @@ type Stats struct {
 	DelMisses int64
 	// Collisions is a number of happened key-collisions
 	Collisions int64
-
+	// EvictCount is a number of keys the expiry wheel went through, whether they were still stored or not
 	EvictCount int64
+	// Expired is a number of entries removed on reaching their expiry time
+	Expired int64
+	// Evicted is a number of entries evicted to make room for new ones, within HardMaxCacheSize or a full shard
+	Evicted int64
+	// Deleted is a number of entries removed by Delete
+	Deleted int64
+	// Overwritten is a number of entries replaced by a newer write under the same key
+	Overwritten int64
+	// Cleared is a number of entries dropped by Reset
+	Cleared int64
+	// NoSpace is a number of writes rejected because the shard reached its maximum size
+	NoSpace int64
+	// BytesUsed is the number of bytes held by the entries stored in the cache, what HardMaxCacheSize limits
+	BytesUsed int64
+	// Reclaimed is the number of bytes of holes left by deleted entries that compacting gave back
+	Reclaimed int64
+}
+
+// ShardStats stores the statistics of a single shard, shards holding far more entries than the others point at
+// keys the hasher does not spread well
+type ShardStats struct {
+	Stats
+	// Entries is the number of live entries in the shard
+	Entries int
+	// Capacity is the number of bytes allocated by the queue of the shard
+	Capacity int
+	// Utilization is the share of Capacity held by live entries, from 0 to 1
+	Utilization float64
+}
+
+// MemoryUsage is an estimate of the memory held by the cache, in bytes
+type MemoryUsage struct {
+	// Queues is the memory allocated by the queues of the shards, whether entries use it or not
+	Queues int
+	// Index is the estimated memory of the hashmaps locating the entries in the queues
+	Index int
+	// TTL is the estimated memory of the keys kept by the shards to expire them
+	TTL int
+	// Buffers is the memory of the buffers entries are wrapped in before they are stored
+	Buffers int
+	// Total is the sum of the above
+	Total int
 }
@@ -1,27 +1,47 @@
 package bigcache
 
 import (
-	"github.com/emirpasic/gods/sets/hashset"
-	"github.com/emirpasic/gods/trees/avltree"
-	"github.com/emirpasic/gods/utils"
 	"sync"
 	"time"
 )
 
+const (
+	//every level of the wheel splits the range of the level above into this many slots
+	wheelSlotBits = 6
+	wheelSlots    = 1 << wheelSlotBits
+	//the lowest level has a slot per second, the highest spans 2^36 seconds
+	wheelLevels = 6
+)
+
+//wheelSlot holds the keys expiring in the range of a slot along with their expiry timestamp
+type wheelSlot map[string]uint64
+
+//ttlManager is a hierarchical timing wheel of the keys of a shard that expire. a key is put in the lowest level
+//whose slots can tell its expiry apart from the current second, and moved down a level every time the slot it is
+//in comes up, until it reaches the lowest level and expires. putting, removing and expiring a key never depends on
+//the number of keys, and the slots are only allocated while they hold keys
 type ttlManager struct {
 	shard       *cacheShard
-	timeTree    *avltree.Tree
-	treeLock    sync.Mutex
-	timer       *time.Timer
+	wheel       [wheelLevels][wheelSlots]wheelSlot
+	current     uint64 //every key expiring up to this second has been expired
+	count       int
+	keyBytes    int //bytes of the keys held, for memory accounting
+	wheelLock   sync.Mutex
+	timer       Timer
+	done        chan struct{} //closed to stop the eviction goroutine
+	stopped     chan struct{} //closed once the eviction goroutine returned
+	stopOnce    sync.Once
 	ShardHasher Hasher
 }
 
 func newTtlManager(shard *cacheShard, hasher Hasher) *ttlManager {
 	ttl := &ttlManager{
 		shard:       shard,
-		timeTree:    avltree.NewWith(utils.UInt64Comparator),
-		treeLock:    sync.Mutex{},
-		timer:       time.NewTimer(time.Hour * 10), //fire the time on time. just for initialization
+		current:     uint64(shard.clock.epoch()),
+		wheelLock:   sync.Mutex{},
+		timer:       shard.clock.NewTimer(untilNextSecond(shard.clock)),
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
 		ShardHasher: hasher,
 	}
 
@@ -30,141 +50,168 @@ func newTtlManager(shard *cacheShard, hasher Hasher) *ttlManager {
 	return ttl
 }
 
-// Put item in the tree with key = timestamp
-// Manage collision with hashSet
-func (ttl *ttlManager) put(timestamp uint64, cacheKey string) {
+//time left before the clock moves on to the next second
+func untilNextSecond(clock Clock) time.Duration {
+	now := clock.Now()
+	return now.Truncate(time.Second).Add(time.Second).Sub(now)
+}
 
-	ttl.treeLock.Lock()
-	defer ttl.treeLock.Unlock()
+//the level and slot a key expiring at timestamp belongs in. keys already due go in the slot of the next second
+func (ttl *ttlManager) slotOf(timestamp uint64) (int, int) {
+	if timestamp <= ttl.current {
+		timestamp = ttl.current + 1
+	}
 
-	if data, found := ttl.timeTree.Get(timestamp); found { //a hashset already exist, add to it
-		data.(*hashset.Set).Add(cacheKey)
-		return
+	level := 0
+	for level < wheelLevels-1 && timestamp>>(wheelSlotBits*uint(level+1)) != ttl.current>>(wheelSlotBits*uint(level+1)) {
+		level++
+	}
+	return level, int(timestamp>>(wheelSlotBits*uint(level))) & (wheelSlots - 1)
+}
 
+//place a key in the wheel, called with the lock held
+func (ttl *ttlManager) place(timestamp uint64, cacheKey string) {
+	level, slot := ttl.slotOf(timestamp)
+	if ttl.wheel[level][slot] == nil {
+		ttl.wheel[level][slot] = make(wheelSlot)
+	}
+	if _, found := ttl.wheel[level][slot][cacheKey]; !found {
+		ttl.count++
+		ttl.keyBytes += len(cacheKey)
 	}
+	ttl.wheel[level][slot][cacheKey] = timestamp
+}
 
-	set := hashset.New() //new value, create a hashset and use it
-	set.Add(cacheKey)
-	ttl.timeTree.Put(timestamp, set)
+//account for a key taken out of the wheel, called with the lock held
+func (ttl *ttlManager) taken(cacheKey string) {
+	ttl.count--
+	ttl.keyBytes -= len(cacheKey)
+}
 
-	if ttl.timeTree.Size() == 1 {
-		ttl.resetTimer(timestamp)
-		return
-	}
+//number of keys in the wheel and the bytes of those keys
+func (ttl *ttlManager) size() (int, int) {
+	ttl.wheelLock.Lock()
+	defer ttl.wheelLock.Unlock()
 
-	//is this timestamp at the head, if so then it displaced the smallest one so reset timer
-	if key, _ := ttl.peek(); key == timestamp {
-		ttl.resetTimer(timestamp)
-	}
+	return ttl.count, ttl.keyBytes
+}
+
+// Put the key in the wheel to expire at timestamp
+func (ttl *ttlManager) put(timestamp uint64, cacheKey string) {
 
+	ttl.wheelLock.Lock()
+	defer ttl.wheelLock.Unlock()
+
+	ttl.place(timestamp, cacheKey)
 }
 
-//Remove a timestamp and key pair from the tree
+//Remove a timestamp and key pair from the wheel
 func (ttl *ttlManager) remove(timestamp uint64, key string) {
 
-	ttl.treeLock.Lock()
-	defer ttl.treeLock.Unlock()
-
-	if data, found := ttl.timeTree.Get(timestamp); found { // data is in hashset, only remove that one
-		set := data.(*hashset.Set)
-		set.Remove(key)
-		if set.Empty() {
-			if k, _ := ttl.peek(); k == timestamp {
-				ttl.timeTree.Remove(timestamp)
-				if k, _ = ttl.peek(); k != nil {
-					ttl.resetTimer(k.(uint64))
-				}
-				return
-			}
-			ttl.timeTree.Remove(timestamp) //remove everything if empty
+	ttl.wheelLock.Lock()
+	defer ttl.wheelLock.Unlock()
+
+	level, slot := ttl.slotOf(timestamp)
+	if stored, found := ttl.wheel[level][slot][key]; found && stored == timestamp {
+		delete(ttl.wheel[level][slot], key)
+		ttl.taken(key)
+		if len(ttl.wheel[level][slot]) == 0 {
+			ttl.wheel[level][slot] = nil
 		}
 	}
-
 }
 
 //reset everything to default
 func (ttl *ttlManager) reset() {
-	ttl.treeLock.Lock()
-	ttl.timeTree.Clear()
-	ttl.stopTimer()
-	ttl.treeLock.Unlock()
+	ttl.wheelLock.Lock()
+	ttl.wheel = [wheelLevels][wheelSlots]wheelSlot{}
+	ttl.count = 0
+	ttl.keyBytes = 0
+	ttl.current = uint64(ttl.shard.clock.epoch())
+	ttl.wheelLock.Unlock()
 }
 
-//goroutine that handles eviction
-func (ttl *ttlManager) eviction() {
+//stop the eviction goroutine and wait for it to return, for shards that are no longer used. the lock of the shard
+//must not be held, a pass of the goroutine under way takes it
+func (ttl *ttlManager) stop() {
+	ttl.stopOnce.Do(func() { close(ttl.done) })
+	<-ttl.stopped
+}
 
-	for range ttl.timer.C {
+//goroutine that handles eviction, once every second, until stopped
+func (ttl *ttlManager) eviction() {
+	defer close(ttl.stopped)
 
-		ttl.treeLock.Lock()          //acquire tree lock
-		if ttl.timeTree.Size() < 1 { //if tree is empty move on
-			ttl.treeLock.Unlock()
-			continue
+	for {
+		select {
+		case <-ttl.done:
+			ttl.timer.Stop()
+			return
+		case <-ttl.timer.C():
 		}
 
-		key, value := ttl.peek() //peek the value at the head(the tree keys are ordered)
-		ttl.timeTree.Remove(key)
-
-		ttl.treeLock.Unlock()
-		ttl.evict(key.(uint64), value)
-
-		for { //loop through to ensure all expired keys are removed in this single step
-
-			ttl.treeLock.Lock()
-			if ttl.timeTree.Size() < 1 {
+		started := ttl.shard.clock.Now()
+		now := uint64(ttl.shard.clock.epoch())
+		count := 0
+		for {
+			ttl.wheelLock.Lock()
+			moved, expired := ttl.advance(now)
+			ttl.wheelLock.Unlock()
+			if !moved {
 				break
 			}
-
-			key, value = ttl.peek()
-			nextExpiryTime := key.(uint64)
-			interval := nextExpiryTime - uint64(time.Now().Unix())
-			if 0 == interval {
-				ttl.timeTree.Remove(key)
-				ttl.treeLock.Unlock()
-				ttl.evict(key.(uint64), value)
-			} else {
-				ttl.resetTimer(nextExpiryTime) //TODO: should this not just call Reset() directly? seen that the timer just fired
-				break                          // TODO: and would be in the expired state
+			if len(expired) > 0 { //evicted without the wheel locked since the shard lock is taken before it
+				ttl.shard.evictDel(expired)
+				count += len(expired)
 			}
 		}
-
-		ttl.treeLock.Unlock()
+		if ttl.shard.onEvictionPass != nil {
+			ttl.shard.onEvictionPass(Since(ttl.shard.clock, started), count)
+		}
+		ttl.timer.Reset(untilNextSecond(ttl.shard.clock))
 	}
 }
 
-//Reset the eviction timer
-func (ttl *ttlManager) resetTimer(timeStamp uint64) {
+//move the wheel on by a second, up to now, and return the keys that expire at it. false once the wheel has
+//caught up with now. called with the lock held
+func (ttl *ttlManager) advance(now uint64) (bool, wheelSlot) {
+	if ttl.current >= now {
+		return false, nil
+	}
+	if ttl.count == 0 { //nothing to expire on the way
+		ttl.current = now
+		return false, nil
+	}
 
-	interval := timeStamp - uint64(time.Now().Unix())
-	if !ttl.timer.Stop() {
-		select {
-		case <-ttl.timer.C:
-		default:
-		}
+	ttl.current++
+	//the slots of the higher levels whose range starts at this second move down, highest first
+	top := 0
+	for top < wheelLevels-1 && ttl.current&((1<<(wheelSlotBits*uint(top+1)))-1) == 0 {
+		top++
+	}
+	for level := top; level > 0; level-- {
+		ttl.cascade(level, int(ttl.current>>(wheelSlotBits*uint(level)))&(wheelSlots-1))
 	}
-	ttl.timer.Reset(time.Second * time.Duration(interval))
-}
 
-//Stop the eviction timer
-func (ttl *ttlManager) stopTimer() {
-	if !ttl.timer.Stop() {
-		select {
-		case <-ttl.timer.C:
-		default:
+	slot := int(ttl.current) & (wheelSlots - 1)
+	keys := ttl.wheel[0][slot]
+	ttl.wheel[0][slot] = nil
+	for key, timestamp := range keys {
+		ttl.taken(key)
+		if timestamp > ttl.current { //put in the top level while too far off for the wheel
+			delete(keys, key)
+			ttl.place(timestamp, key)
 		}
 	}
+	return true, keys
 }
 
-//Grab the data at the beginning of the iterator
-func (ttl *ttlManager) peek() (interface{}, interface{}) {
-
-	it := ttl.timeTree.Iterator()
-	it.Next()
-	return it.Key(), it.Value()
-}
-
-//Do the eviction from the tree and parent shard
-func (ttl *ttlManager) evict(timeStamp uint64, value interface{}) {
-
-	set := value.(*hashset.Set)
-	ttl.shard.evictDel(timeStamp, set)
+//put the keys of a slot back in the wheel, a level or more below. called with the lock held
+func (ttl *ttlManager) cascade(level, slot int) {
+	keys := ttl.wheel[level][slot]
+	ttl.wheel[level][slot] = nil
+	for key, timestamp := range keys {
+		ttl.taken(key)
+		ttl.place(timestamp, key)
+	}
 }
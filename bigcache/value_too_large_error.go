@@ -0,0 +1,32 @@
+package bigcache
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrKeyTooLong is returned when a key is longer than the 32767 bytes an entry has room for
+var ErrKeyTooLong = errors.New("key is longer than 32767 bytes")
+
+// ValueTooLargeError is returned when a value is larger than Config.MaxValueSize
+type ValueTooLargeError struct {
+	Key   string
+	Size  int
+	Limit int
+}
+
+// Error returned when the value is larger than the limit.
+func (e *ValueTooLargeError) Error() string {
+	return fmt.Sprintf("value of %q is %d bytes, more than the limit of %d", e.Key, e.Size, e.Limit)
+}
+
+// checkValueSize fails values larger than limit, 0 meaning no limit, and keys too long to be stored
+func checkValueSize(key string, value []byte, limit int) error {
+	if len(key) > keySizeMask {
+		return ErrKeyTooLong
+	}
+	if limit > 0 && len(value) > limit {
+		return &ValueTooLargeError{Key: key, Size: len(value), Limit: limit}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package cluster
+
+import (
+	"time"
+)
+
+//longest interval between pings adaptive pinging backs off to, in multiples of PingInterval, when
+//MaxPingInterval is not set
+const defaultMaxPingIntervals = 8
+
+//adaptivePing spaces the pings to a remote node out while they are answered, twice as far apart every time up to
+//the longest interval, and skips them while the remote node sends messages as those prove it alive. it is back
+//to PingInterval as soon as a ping goes unanswered, so a flaky link is watched as closely as before
+type adaptivePing struct {
+	base       time.Duration //the configured interval, the shortest
+	longest    time.Duration
+	interval   time.Duration //until the next tick
+	lastActive int64         //of the remote node at the previous tick
+}
+
+//the adaptive pinging of the configuration, nil when pings are sent every PingInterval
+func newAdaptivePing(config *remoteNodeConfig) *adaptivePing {
+	if !config.AdaptivePing {
+		return nil
+	}
+	a := &adaptivePing{}
+	a.reset(config)
+	return a
+}
+
+//start over from PingInterval, once the ping settings changed
+func (a *adaptivePing) reset(config *remoteNodeConfig) {
+	a.base = time.Second * time.Duration(config.PingInterval)
+	a.longest = time.Second * time.Duration(config.MaxPingInterval)
+	if a.longest <= 0 {
+		a.longest = a.base * defaultMaxPingIntervals
+	}
+	if a.longest < a.base {
+		a.longest = a.base
+	}
+	a.interval = a.base
+}
+
+//at a tick of the ping timer, with the time the remote node last sent a message other than a pong and the pings
+//it left unanswered: whether to ping it now and the interval until the next tick
+func (a *adaptivePing) tick(lastActive int64, failures int32) (bool, time.Duration) {
+	active := lastActive != a.lastActive
+	a.lastActive = lastActive
+	if failures > 0 { //the link looks flaky
+		a.interval = a.base
+		return true, a.interval
+	}
+
+	if a.interval *= 2; a.interval > a.longest {
+		a.interval = a.longest
+	}
+	return !active, a.interval
+}
@@ -0,0 +1,40 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdaptivePing(t *testing.T) {
+	if newAdaptivePing(&remoteNodeConfig{PingInterval: 1}) != nil {
+		t.Error("pings ought to be sent every PingInterval unless adaptive")
+	}
+
+	a := newAdaptivePing(&remoteNodeConfig{PingInterval: 1, AdaptivePing: true, MaxPingInterval: 4})
+	for x, expected := range []struct {
+		lastActive int64
+		failures   int32
+		ping       bool
+		interval   time.Duration
+	}{
+		{0, 0, true, 2},  //idle and healthy, backs off
+		{5, 0, false, 4}, //messages came in, no need to ping
+		{9, 0, false, 4}, //up to the longest interval
+		{9, 0, true, 4},  //idle again
+		{9, 1, true, 1},  //unanswered, back to PingInterval
+		{9, 0, true, 2},
+	} {
+		ping, interval := a.tick(expected.lastActive, expected.failures)
+		if ping != expected.ping || interval != expected.interval*time.Second {
+			t.Errorf("tick %d ought to ping %t and wait %ds, got %t and %s", x, expected.ping, expected.interval, ping, interval)
+		}
+	}
+
+	a = newAdaptivePing(&remoteNodeConfig{PingInterval: 2, AdaptivePing: true})
+	for x := 0; x < 10; x++ {
+		a.tick(0, 0)
+	}
+	if _, interval := a.tick(0, 0); interval != time.Second*2*defaultMaxPingIntervals {
+		t.Errorf("pings ought to back off to %d times PingInterval by default, got %s", defaultMaxPingIntervals, interval)
+	}
+}
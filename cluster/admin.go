@@ -0,0 +1,331 @@
+package cluster
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/nggenius/ngbigcache/comms"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//number of hot keys reported by the admin server
+const adminTopKeys = 20
+
+//how long the admin server waits for peers to reply to a key audit, unless told otherwise
+const adminAuditTimeout = time.Second
+
+//entries removed from the local cache over its lifetime, by why they were removed. evictions growing faster than
+//expiries mean the cache is undersized rather than the keys churning
+type removalStats struct {
+	Expired     int64 `json:"expired"`     //reached their expiry time
+	Evicted     int64 `json:"evicted"`     //evicted to make room for new entries
+	Deleted     int64 `json:"deleted"`     //deleted explicitly
+	Overwritten int64 `json:"overwritten"` //replaced by a newer write of the same key
+	Cleared     int64 `json:"cleared"`     //dropped by a reset of the cache
+}
+
+//node details reported by the admin server
+type adminStats struct {
+	Id               string              `json:"id"`
+	Instance         string              `json:"instance,omitempty"`
+	Passive          bool                `json:"passive"`
+	Entries          int                 `json:"entries"`
+	CapacityBytes    int                 `json:"capacity_bytes"`
+	LiveBytes        int                 `json:"live_bytes"`
+	Hits             int64               `json:"hits"`
+	Misses           int64               `json:"misses"`
+	HitRatio         float64             `json:"hit_ratio"`
+	EvictCount       int64               `json:"evict_count"`
+	Removals         removalStats        `json:"removals"`
+	ReclaimedBytes   int64               `json:"reclaimed_bytes"` //bytes of holes left by deleted entries given back by compacting
+	ReplicationQueue int                 `json:"replication_queue"`
+	GetRequestQueue  int                 `json:"get_request_queue"`
+	NoPeers          uint64              `json:"no_peers"` //reads that failed for want of an active remote node to ask
+	ReadOnly         bool                `json:"read_only"`
+	ReadOnlyDropped  uint64              `json:"read_only_dropped"` //replicated writes not applied while read only
+	ExpiredReplicas  uint64              `json:"expired_replicas"`  //replicated puts dropped for arriving after their expiry
+	ClampedTTLs      uint64              `json:"clamped_ttls"`      //replicated puts kept for min_replicated_ttl for arriving with less time left
+	StaleWrites      uint64              `json:"stale_writes"`      //replicated puts discarded for being older than the local copy, when the last write wins
+	RoleDropped      roleCounts          `json:"role_dropped"`      //writes refused from peers by the role they asked for
+	Peers            []PeerStatistics    `json:"peers"`
+	TopKeys          []hotKey            `json:"top_keys"`
+	Divergence       DivergenceStats     `json:"divergence"`
+	Throttle         *ThrottleStats      `json:"throttle,omitempty"`
+	Members          []Member            `json:"members,omitempty"`
+	QuorumLost       bool                `json:"quorum_lost"`
+	WarmUp           *WarmUpStats        `json:"warm_up,omitempty"`
+	Memory           MemoryStats         `json:"memory"`
+	InternalErrors   uint64              `json:"internal_errors"`   //failures reported in strict mode
+	DNS              comms.ResolverStats `json:"dns"`               //lookups of peers addressed by hostname
+	IdleClosed       uint64              `json:"idle_closed"`       //passive clients closed for being idle
+	StatsSubscribers int                 `json:"stats_subscribers"` //remote nodes these stats are pushed to
+	Shards           int                 `json:"shards"`
+	Reshard          *ReshardStats       `json:"reshard,omitempty"` //how far resharding got, while it is under way
+	Bandwidth        BandwidthStats      `json:"bandwidth"`         //bytes of writes made, sent and received over the last interval
+	Profiles         *ProfileStats       `json:"profiles,omitempty"`
+	Namespaces       []NamespaceStats    `json:"namespaces,omitempty"`  //namespaces used through this node
+	Replication      []ReplicationLag    `json:"replication,omitempty"` //writes waiting for remote nodes, when retried
+	Changelog        *ChangelogStats     `json:"changelog,omitempty"`
+	SlowOps          uint64              `json:"slow_ops"` //gets, pings and lock waits logged for going over their threshold
+	ReReplication    *ReReplicationStats `json:"re_replication,omitempty"`
+	Evictions        EvictionStats       `json:"evictions"` //evictions broadcast to the remote nodes and applied from them
+}
+
+//bring up the admin http server on the debug port
+func (node *ClusteredBigCache) startAdminServer() error {
+
+	listener, err := net.Listen("tcp", node.debugAddress(node.config.DebugPort))
+	if err != nil {
+		utils.Error(node.logger, fmt.Sprintf("unable to start admin server on port %d. [%s]", node.config.DebugPort, err.Error()))
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", readOnlyRoute(node.handleAdminStats))
+	mux.HandleFunc("/dashboard", readOnlyRoute(node.handleAdminDashboard))
+	mux.HandleFunc("/audit-key", readOnlyRoute(node.handleAdminAuditKey))
+	mux.HandleFunc("/peer-stats", readOnlyRoute(node.handleAdminPeerStats))
+	mux.HandleFunc("/shards", readOnlyRoute(node.handleAdminShards))
+	mux.HandleFunc("/replication-factor", node.handleAdminReplicationFactor)
+	mux.HandleFunc("/keys", readOnlyRoute(node.handleAdminKeys))
+	mux.HandleFunc("/cluster-stats", readOnlyRoute(node.handleAdminClusterStats))
+	node.handleProbes(mux)
+	mux.HandleFunc("/debug/vars", readOnlyRoute(expvar.Handler().ServeHTTP))
+	node.adminServer = &http.Server{Handler: mux}
+
+	node.serve(node.adminServer, listener)
+	utils.Info(node.logger, fmt.Sprintf("admin server listening on %s", listener.Addr().String()))
+	return nil
+}
+
+//serve handler to GET and HEAD requests only, for the admin routes that change nothing
+func readOnlyRoute(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handler(w, req)
+	}
+}
+
+//whether req may change the node, which it may only when AdminToken is set and req carries it as a bearer token.
+//the admin server is otherwise open to whoever reaches its port
+func (node *ClusteredBigCache) authorizeAdmin(w http.ResponseWriter, req *http.Request) bool {
+	token := node.config.AdminToken
+	if token == "" {
+		http.Error(w, "changes through the admin server are disabled, set admin_token to allow them", http.StatusForbidden)
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+//gather the details shown by the admin server
+func (node *ClusteredBigCache) adminStats() *adminStats {
+	stats := &adminStats{
+		Id:               node.config.Id,
+		Instance:         node.config.Instance,
+		Passive:          node.mode == clusterModePASSIVE,
+		ReplicationQueue: node.replicationMsgs.len(),
+		GetRequestQueue:  len(node.getRequestChan),
+		NoPeers:          atomic.LoadUint64(&node.noPeers),
+		ReadOnly:         node.IsReadOnly(),
+		ReadOnlyDropped:  atomic.LoadUint64(&node.readOnlyDropped),
+		ExpiredReplicas:  atomic.LoadUint64(&node.expiredReplicas),
+		ClampedTTLs:      atomic.LoadUint64(&node.clampedTTLs),
+		StaleWrites:      node.versions.staleCount(),
+		RoleDropped:      node.roleDrops(),
+		Peers:            node.peerStatistics(),
+		TopKeys:          node.hotKeys.top(adminTopKeys),
+		Divergence:       node.divergence.stats(),
+		Throttle:         node.throttle.stats(),
+		Members:          node.gossip.list(),
+		QuorumLost:       !node.HasQuorum(),
+		WarmUp:           node.warmUp.stats(),
+		Memory:           node.MemoryUsage(),
+		InternalErrors:   node.InternalErrors(),
+		DNS:              node.DNSStats(),
+		IdleClosed:       node.idle.count(),
+		StatsSubscribers: node.statsPublisher.count(),
+		Bandwidth:        node.Bandwidth(),
+		Profiles:         node.Profiles(),
+		Namespaces:       node.NamespaceStats(),
+		Replication:      node.ReplicationLag(),
+		ReReplication:    node.ReReplication(),
+		Evictions:        node.EvictionStats(),
+		Changelog:        node.Changelog(),
+		SlowOps:          node.slow.count(),
+	}
+
+	if node.mode == clusterModeACTIVE {
+		s := node.cache.Stats()
+		stats.Entries = node.cache.Len()
+		stats.LiveBytes = node.cache.LiveBytes()
+		stats.Shards = node.cache.Shards()
+		stats.Reshard = node.reshardStats()
+		stats.CapacityBytes = node.cache.Capacity()
+		stats.Hits = s.Hits
+		stats.Misses = s.Misses
+		stats.EvictCount = s.EvictCount
+		stats.Removals = removalStats{
+			Expired:     s.Expired,
+			Evicted:     s.Evicted,
+			Deleted:     s.Deleted,
+			Overwritten: s.Overwritten,
+			Cleared:     s.Cleared,
+		}
+		stats.ReclaimedBytes = s.Reclaimed
+		if s.Hits+s.Misses > 0 {
+			stats.HitRatio = float64(s.Hits) / float64(s.Hits+s.Misses)
+		}
+	}
+
+	return stats
+}
+
+//serve the node details as json
+func (node *ClusteredBigCache) handleAdminStats(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(node.adminStats())
+}
+
+//serve the latest stats pushed by the remote nodes, once this node subscribed to them with SubscribeStats
+func (node *ClusteredBigCache) handleAdminPeerStats(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(node.PeerStats())
+}
+
+//serve the statistics of every shard of the local cache, to spot shards the hasher puts more keys in than others
+func (node *ClusteredBigCache) handleAdminShards(w http.ResponseWriter, req *http.Request) {
+	if node.mode != clusterModeACTIVE {
+		http.Error(w, errNotActive.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(node.cache.ShardStats())
+}
+
+//serve the audit of the key given as the key query parameter, e.g /audit-key?key=user:1&timeout=500
+//where timeout is how many milliseconds to wait for peers to reply
+func (node *ClusteredBigCache) handleAdminAuditKey(w http.ResponseWriter, req *http.Request) {
+	key := req.URL.Query().Get("key")
+	if key == "" {
+		http.Error(w, "key is required", http.StatusBadRequest)
+		return
+	}
+
+	timeout := adminAuditTimeout
+	if ms, err := strconv.Atoi(req.URL.Query().Get("timeout")); err == nil && ms > 0 {
+		timeout = time.Millisecond * time.Duration(ms)
+	}
+
+	audit, err := node.AuditKey(key, timeout)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(audit)
+}
+
+//serve the dashboard page, it renders the json served by /stats
+func (node *ClusteredBigCache) handleAdminDashboard(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(dashboardPage))
+}
+
+const dashboardPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>ngbigcache</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.4em; }
+h2 { font-size: 1.1em; margin-top: 2em; }
+.cards { display: flex; flex-wrap: wrap; gap: 1em; }
+.card { border: 1px solid #ccc; border-radius: 4px; padding: 0.8em 1.2em; min-width: 9em; }
+.card .value { font-size: 1.6em; font-weight: bold; }
+.card .label { font-size: 0.8em; color: #666; }
+table { border-collapse: collapse; }
+th, td { border-bottom: 1px solid #ddd; padding: 0.3em 1em; text-align: left; }
+#error { color: #b00; }
+</style>
+</head>
+<body>
+<h1>ngbigcache node <span id="id"></span></h1>
+<div id="error"></div>
+<div class="cards">
+<div class="card"><div class="value" id="hitratio">-</div><div class="label">hit ratio</div></div>
+<div class="card"><div class="value" id="entries">-</div><div class="label">entries</div></div>
+<div class="card"><div class="value" id="memory">-</div><div class="label">queue memory</div></div>
+<div class="card"><div class="value" id="evictions">-</div><div class="label">evictions</div></div>
+<div class="card"><div class="value" id="replication">-</div><div class="label">replication queue</div></div>
+<div class="card"><div class="value" id="getqueue">-</div><div class="label">get request queue</div></div>
+</div>
+<h2>Peers</h2>
+<table>
+<thead><tr><th>id</th><th>address</th><th>mode</th><th>role</th><th>connections</th><th>inbound queue</th><th>outbound queue</th><th>pings sent</th><th>pongs received</th><th>dropped</th><th>corrupt</th><th>rate limited</th><th>refused</th><th>role denied</th></tr></thead>
+<tbody id="peers"></tbody>
+</table>
+<h2>Top keys</h2>
+<table>
+<thead><tr><th>key</th><th>reads</th></tr></thead>
+<tbody id="keys"></tbody>
+</table>
+<script>
+function text(id, value) { document.getElementById(id).textContent = value; }
+function bytes(n) {
+  var units = ["B", "KB", "MB", "GB", "TB"], i = 0;
+  while (n >= 1024 && i < units.length - 1) { n /= 1024; i++; }
+  return n.toFixed(i ? 1 : 0) + " " + units[i];
+}
+function row(cells) {
+  var tr = document.createElement("tr");
+  cells.forEach(function (c) { var td = document.createElement("td"); td.textContent = c; tr.appendChild(td); });
+  return tr;
+}
+function fill(id, rows) {
+  var body = document.getElementById(id);
+  while (body.firstChild) { body.removeChild(body.firstChild); }
+  rows.forEach(function (r) { body.appendChild(row(r)); });
+}
+function refresh() {
+  fetch("stats").then(function (rsp) { return rsp.json(); }).then(function (s) {
+    text("error", "");
+    text("id", s.id + (s.passive ? " (passive)" : ""));
+    text("hitratio", (s.hit_ratio * 100).toFixed(1) + "%");
+    text("entries", s.entries);
+    text("memory", bytes(s.capacity_bytes));
+    text("evictions", s.evict_count);
+    text("replication", s.replication_queue);
+    text("getqueue", s.get_request_queue);
+    fill("peers", s.peers.map(function (p) {
+      return [p.id, p.address, p.passive ? "passive" : "active", p.role, p.connections, p.inbound_queue,
+        p.outbound_queue, p.ping_sent, p.pong_received, p.dropped_msg, p.corrupt_msg, p.rate_limited, p.refused,
+        p.role_denied];
+    }));
+    fill("keys", s.top_keys.map(function (k) { return [k.key, k.count]; }));
+  }).catch(function (err) { text("error", "unable to load stats: " + err); });
+}
+refresh();
+setInterval(refresh, 2000);
+</script>
+</body>
+</html>
+`
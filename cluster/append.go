@@ -0,0 +1,69 @@
+package cluster
+
+import (
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+	"github.com/nggenius/ngbigcache/message"
+)
+
+//AppendBytes adds data to the end of the entry under key in the cluster, creating it if there is none. a new
+//entry expires after duration, an existing one keeps its expiry. it is meant for per key logs or metrics
+//accumulated by several writers, which would race with one another reading the entry and putting it back
+func (node *ClusteredBigCache) AppendBytes(key string, data []byte, duration time.Duration) error {
+
+	if node.state != clusterStateStarted {
+		return ErrNotStarted
+	}
+	if err := node.admitWrite(); err != nil {
+		return err
+	}
+
+	//append locally first
+	expiryTime := bigcache.NO_EXPIRY
+	if node.mode == clusterModeACTIVE {
+		if err := node.throttle.admit(); err != nil {
+			return err
+		}
+		var err error
+		expiryTime, err = node.cache.AppendBytes(key, data, duration)
+		if err != nil {
+			return err
+		}
+		node.watchers.notify(key, false)
+	} else if duration != time.Duration(bigcache.NO_EXPIRY) {
+		expiryTime = uint64(node.clock.Now().Unix()) + uint64(duration.Seconds())
+	}
+	node.bandwidth.wrote(len(key) + len(data))
+
+	peers := node.remoteNodes.Values()
+	for x := 0; x < len(peers); x++ {
+		if peers[x].(*remoteNode).mode == clusterModePASSIVE {
+			continue
+		}
+		node.queueReplication(&replicationMsg{r: peers[x].(*remoteNode),
+			m: &message.AppendBytesMessage{Key: key, Data: data, Expiry: expiryTime}})
+	}
+	return nil
+}
+
+func (r *remoteNode) handleAppendBytes(msg *message.NodeWireMessage) {
+
+	appendMsg := message.AppendBytesMessage{}
+	appendMsg.DeSerialize(msg)
+	if !r.admitReplicatedWrite(msg.Code, appendMsg.Key) || !r.admitThrottledWrite(msg.Code, appendMsg.Key) {
+		return
+	}
+
+	var err error
+	if appendMsg.Expiry == bigcache.NO_EXPIRY {
+		_, err = r.parentNode.cache.AppendBytes(appendMsg.Key, appendMsg.Data, 0)
+	} else { //the expiry is an absolute time so keep it as is rather than recomputing a duration
+		_, err = r.parentNode.cache.AppendBytesUntil(appendMsg.Key, appendMsg.Data, time.Unix(int64(appendMsg.Expiry), 0))
+	}
+	if err != nil {
+		r.replicaWriteFailed("replicate append bytes", appendMsg.Key, err)
+		return
+	}
+	r.parentNode.watchers.notify(appendMsg.Key, false)
+}
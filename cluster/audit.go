@@ -0,0 +1,159 @@
+package cluster
+
+import (
+	"fmt"
+	"hash/crc32"
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+	"github.com/nggenius/ngbigcache/message"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//KeyCopy is what one node holds of an audited key
+type KeyCopy struct {
+	NodeId   string `json:"node_id"`
+	Found    bool   `json:"found"`
+	Expiry   uint64 `json:"expiry,omitempty"`   //unix time the copy expires at, not set if it never does
+	TTL      int64  `json:"ttl,omitempty"`      //seconds left before the copy expires
+	Size     int    `json:"size,omitempty"`     //size of the value in bytes
+	Checksum string `json:"checksum,omitempty"` //crc32 of the value
+	TimedOut bool   `json:"timed_out,omitempty"`
+}
+
+//KeyAudit shows which nodes hold a key and whether the copies agree with each other
+type KeyAudit struct {
+	Key              string    `json:"key"`
+	Copies           []KeyCopy `json:"copies"`
+	Replicas         int       `json:"replicas"`          //number of copies found
+	ExpectedReplicas int       `json:"expected_replicas"` //number of copies there ought to be
+	ReplicaCountOK   bool      `json:"replica_count_ok"`
+	Consistent       bool      `json:"consistent"` //every copy found has the same checksum and expiry
+}
+
+func newKeyCopy(nodeId string, found bool, expiry uint64, size int, checksum uint32, now time.Time) KeyCopy {
+	c := KeyCopy{NodeId: nodeId, Found: found}
+	if !found {
+		return c
+	}
+
+	c.Size = size
+	c.Checksum = fmt.Sprintf("%08x", checksum)
+	if expiry != bigcache.NO_EXPIRY {
+		c.Expiry = expiry
+		c.TTL = int64(expiry) - now.Unix()
+	}
+	return c
+}
+
+//the details of the local copy of key
+func (node *ClusteredBigCache) auditLocalKey(key string) *message.AuditRspMessage {
+	rsp := &message.AuditRspMessage{}
+	if node.mode != clusterModeACTIVE {
+		return rsp
+	}
+
+	data, expiry, err := node.cache.GetWithExpiry(key)
+	if err != nil {
+		return rsp
+	}
+
+	rsp.Found = true
+	rsp.Expiry = expiry
+	rsp.Size = len(data)
+	rsp.Checksum = crc32.ChecksumIEEE(data)
+	return rsp
+}
+
+//AuditKey asks every node in the cluster for its copy of key and reports which nodes hold it, the expiry,
+//size and checksum of each copy and whether there are as many copies as there ought to be. peers that do
+//not reply within the timeout are reported as timed out
+func (node *ClusteredBigCache) AuditKey(key string, timeout time.Duration) (*KeyAudit, error) {
+	if node.state != clusterStateStarted {
+		return nil, ErrNotStarted
+	}
+
+	audit := &KeyAudit{Key: key, Copies: make([]KeyCopy, 0)}
+	if node.mode == clusterModeACTIVE {
+		local := node.auditLocalKey(key)
+		audit.Copies = append(audit.Copies, newKeyCopy(node.config.Id, local.Found, local.Expiry, local.Size, local.Checksum, node.clock.Now()))
+		audit.ExpectedReplicas++
+	}
+
+	peers := node.getRemoteNodes()
+	replies := make(chan KeyCopy, len(peers))
+	pending := make(map[string]*remoteNode)
+	for _, peer := range peers {
+		r := peer.(*remoteNode)
+		if r.mode == clusterModePASSIVE {
+			continue
+		}
+		pendingKey := key + utils.GenerateNodeId(8)
+		r.auditKey(key, pendingKey, replies)
+		pending[r.config.Id] = r
+		audit.ExpectedReplicas++
+		defer r.pending.cancel(message.MsgAuditReq, pendingKey)
+	}
+
+	timer := node.clock.NewTimer(timeout)
+	defer timer.Stop()
+	for waiting := true; waiting && len(pending) > 0; {
+		select {
+		case c := <-replies:
+			delete(pending, c.NodeId)
+			audit.Copies = append(audit.Copies, c)
+		case <-timer.C():
+			waiting = false
+		}
+	}
+	for id := range pending {
+		audit.Copies = append(audit.Copies, KeyCopy{NodeId: id, TimedOut: true})
+	}
+
+	audit.Consistent = true
+	var first *KeyCopy
+	for x := range audit.Copies {
+		c := &audit.Copies[x]
+		if !c.Found {
+			continue
+		}
+		audit.Replicas++
+		if first == nil {
+			first = c
+		} else if c.Checksum != first.Checksum || c.Expiry != first.Expiry {
+			audit.Consistent = false
+		}
+	}
+	audit.ReplicaCountOK = audit.Replicas == audit.ExpectedReplicas
+
+	return audit, nil
+}
+
+//ask the remote node for its copy of key, the reply is sent on replies. a remote node going away before it
+//answered is reported as timed out
+func (r *remoteNode) auditKey(key, pendingKey string, replies chan KeyCopy) {
+	if r.state == nodeStateDisconnected || r.pending.add(message.MsgAuditReq, pendingKey, replies, nil) != nil {
+		return
+	}
+	r.sendMessage(&message.AuditReqMessage{Key: key, PendingKey: pendingKey})
+}
+
+func (r *remoteNode) handleAuditRequest(msg *message.NodeWireMessage) {
+	reqMsg := message.AuditReqMessage{}
+	reqMsg.DeSerialize(msg)
+	rsp := r.parentNode.auditLocalKey(reqMsg.Key)
+	rsp.PendingKey = reqMsg.PendingKey
+	r.sendMessage(rsp)
+}
+
+func (r *remoteNode) handleAuditResponse(msg *message.NodeWireMessage) {
+	rspMsg := message.AuditRspMessage{}
+	rspMsg.DeSerialize(msg)
+	replies, ok := r.pending.take(message.MsgAuditReq, rspMsg.PendingKey)
+	if !ok { //the audit timed out
+		return
+	}
+
+	//buffered for every peer asked so this never blocks
+	replies.(chan KeyCopy) <- newKeyCopy(r.config.Id, rspMsg.Found, rspMsg.Expiry, rspMsg.Size, rspMsg.Checksum, r.parentNode.clock.Now())
+}
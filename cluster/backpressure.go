@@ -0,0 +1,63 @@
+package cluster
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+//how often PutContext looks again at the messages waiting for the remote nodes while it holds a put back
+const backpressurePollInterval = time.Millisecond * 10
+
+//PutContext adds data into the cluster like Put, but while a remote node has more than ReplicationHighWaterMark
+//messages waiting to be written to it, it holds the put back until the backlog drains below the mark rather than
+//refusing it. ctx done first fails it with the error of ctx, the put is then neither stored nor replicated
+func (node *ClusteredBigCache) PutContext(ctx context.Context, key string, data []byte, duration time.Duration) error {
+	if node.state != clusterStateStarted {
+		return ErrNotStarted
+	}
+	for {
+		if err := node.admitReplication(ctx); err != nil {
+			return err
+		}
+		//the backlog may have grown over the mark again since, wait for it once more then
+		if err := node.Put(key, data, duration); err != ErrReplicationBackpressure {
+			return err
+		}
+	}
+}
+
+//admit a put unless a remote node has more messages waiting than the high-water mark. with a nil ctx the put is
+//refused with ErrReplicationBackpressure straight away, otherwise it waits for the backlog to drain until ctx is done
+func (node *ClusteredBigCache) admitReplication(ctx context.Context) error {
+	mark := int64(node.config.ReplicationHighWaterMark)
+	if mark <= 0 {
+		return nil
+	}
+
+	for node.replicationBacklog() > mark {
+		if ctx == nil {
+			atomic.AddUint64(&node.backpressured, 1)
+			return ErrReplicationBackpressure
+		}
+		if !node.sleep(ctx, backpressurePollInterval) {
+			atomic.AddUint64(&node.backpressured, 1)
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+//the most messages waiting to be written to a remote node, those queued for it and those the replication queue
+//keeps for it while it cannot take them
+func (node *ClusteredBigCache) replicationBacklog() int64 {
+	most := int64(0)
+	for _, v := range node.getRemoteNodes() {
+		r := v.(*remoteNode)
+		backlog := atomic.LoadInt64(&r.unsent) + int64(node.retryQueue.queued(r.config.Id))
+		if backlog > most {
+			most = backlog
+		}
+	}
+	return most
+}
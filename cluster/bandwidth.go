@@ -0,0 +1,163 @@
+package cluster
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nggenius/ngbigcache/message"
+)
+
+//seconds between two samples of the write bandwidth, when BandwidthInterval is not set
+const defaultBandwidthInterval = 10
+
+//BandwidthStats compares, over the last interval sampled, the bytes written by the callers of the node with the
+//bytes sent and received to replicate writes. the write amplification is what the replication mode costs per byte
+//written, the figure to weigh full replication against the sharded mode with
+type BandwidthStats struct {
+	Interval      time.Duration `json:"interval"`
+	Written       uint64        `json:"written"`       //bytes of the keys and values written through this node
+	Sent          uint64        `json:"sent"`          //bytes of the writes sent to remote nodes to replicate them, framing included
+	Received      uint64        `json:"received"`      //bytes of the writes replicated to this node by remote nodes
+	Amplification float64       `json:"amplification"` //bytes stored locally and sent per byte written, 0 when nothing was written
+	Estimated     float64       `json:"estimated"`     //amplification the replication mode is expected to have with the active nodes now
+}
+
+//writeBandwidth counts the bytes of writes as they are made, sent and received, and keeps those of the last
+//complete interval
+type writeBandwidth struct {
+	node     *ClusteredBigCache
+	interval time.Duration
+	written  uint64
+	sent     uint64
+	received uint64
+	lock     sync.Mutex
+	last     BandwidthStats
+	done     chan struct{}
+}
+
+func newWriteBandwidth(node *ClusteredBigCache) *writeBandwidth {
+	interval := node.config.BandwidthInterval
+	if interval <= 0 {
+		interval = defaultBandwidthInterval
+	}
+	return &writeBandwidth{
+		node:     node,
+		interval: time.Second * time.Duration(interval),
+		last:     BandwidthStats{Interval: time.Second * time.Duration(interval)},
+		done:     make(chan struct{}),
+	}
+}
+
+//whether a message carries a write, replicated or sent to the owner of the key
+func isWrite(code uint16) bool {
+	switch code {
+	case message.MsgPUT, message.MsgPUTEx, message.MsgPUTStamped, message.MsgDEL, message.MsgAPPEND, message.MsgAPPENDBytes, message.MsgSADD,
+		message.MsgSETNXReq, message.MsgPUTAckReq, message.MsgEVICT, message.MsgNOTFOUND, message.MsgHSET, message.MsgHDEL,
+		message.MsgLIST, message.MsgLISTReq, message.MsgCOUNTER:
+		return true
+	}
+	return false
+}
+
+//bytes of the names and values of the fields of a hash
+func fieldsSize(fields map[string][]byte) int {
+	size := 0
+	for name, value := range fields {
+		size += len(name) + len(value)
+	}
+	return size
+}
+
+//bytes of the items of a list or the members of a set
+func itemsSize(items [][]byte) int {
+	size := 0
+	for _, item := range items {
+		size += len(item)
+	}
+	return size
+}
+
+//count a write made through this node
+func (b *writeBandwidth) wrote(bytes int) {
+	atomic.AddUint64(&b.written, uint64(bytes))
+}
+
+//count a frame sent to a remote node, only writes are counted
+func (b *writeBandwidth) sentFrame(code uint16, bytes int) {
+	if isWrite(code) {
+		atomic.AddUint64(&b.sent, uint64(bytes))
+	}
+}
+
+//count a message received from a remote node, only writes are counted
+func (b *writeBandwidth) receivedMessage(msg *message.NodeWireMessage) {
+	if isWrite(msg.Code) {
+		atomic.AddUint64(&b.received, uint64(6+len(msg.Data))) //as framed by buildFrame
+	}
+}
+
+//keep the bytes counted over the interval just over and start counting again
+func (b *writeBandwidth) sample() {
+	stats := BandwidthStats{
+		Interval:  b.interval,
+		Written:   atomic.SwapUint64(&b.written, 0),
+		Sent:      atomic.SwapUint64(&b.sent, 0),
+		Received:  atomic.SwapUint64(&b.received, 0),
+		Estimated: b.node.estimatedAmplification(),
+	}
+	if stats.Written > 0 {
+		stored := uint64(0)
+		if b.node.mode == clusterModeACTIVE {
+			stored = stats.Written
+		}
+		stats.Amplification = float64(stored+stats.Sent) / float64(stats.Written)
+	}
+
+	b.lock.Lock()
+	b.last = stats
+	b.lock.Unlock()
+}
+
+func (b *writeBandwidth) run() {
+	ticker := b.node.clock.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C():
+			b.sample()
+		}
+	}
+}
+
+func (b *writeBandwidth) close() {
+	close(b.done)
+}
+
+func (b *writeBandwidth) stats() BandwidthStats {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.last
+}
+
+//Bandwidth returns the bytes written through the node and those sent and received to replicate writes over the
+//last BandwidthInterval, along with the write amplification they amount to
+func (node *ClusteredBigCache) Bandwidth() BandwidthStats {
+	return node.bandwidth.stats()
+}
+
+//the number of copies of a write the replication mode makes with the active nodes there are now, the local one
+//included: every active node under full replication, at most ReplicationFactor when sharded
+func (node *ClusteredBigCache) estimatedAmplification() float64 {
+	copies := len(node.activePeers())
+	if node.mode == clusterModeACTIVE {
+		copies++
+	}
+	if node.config.ReplicationMode == REPLICATION_MODE_SHARD && node.config.ReplicationFactor < copies {
+		copies = node.config.ReplicationFactor
+	}
+	return float64(copies)
+}
@@ -0,0 +1,52 @@
+package cluster
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/nggenius/ngbigcache/message"
+)
+
+//largest buffer given back to the pool, larger ones are left to the garbage collector so a few large values do
+//not keep their memory held by the pool
+const maxPooledBuffer = 64 * 1024
+
+//buffers frames and the values served to remote nodes are built in, reused instead of allocated for every message
+var bufferPool = sync.Pool{New: func() interface{} {
+	buf := make([]byte, 0, 1024)
+	return &buf
+}}
+
+//an empty buffer from the pool, to be given back with putBuffer once nothing refers to it anymore
+func getBuffer() *[]byte {
+	buf := bufferPool.Get().(*[]byte)
+	*buf = (*buf)[:0]
+	return buf
+}
+
+func putBuffer(buf *[]byte) {
+	if cap(*buf) <= maxPooledBuffer {
+		bufferPool.Put(buf)
+	}
+}
+
+//appendFrame appends the frame of msg, as built by buildFrame, to dst
+func appendFrame(dst []byte, msg *message.NodeWireMessage) []byte {
+	var header [6]byte                                                // 6 ==> 4bytes for length of message, 2bytes for message code
+	binary.LittleEndian.PutUint32(header[:], uint32(len(msg.Data)+2)) //the 2 is for the message code
+	binary.LittleEndian.PutUint16(header[4:], msg.Code)
+	return append(append(dst, header[:]...), msg.Data...)
+}
+
+//pooledGetRsp is a get response whose data was read into a pooled buffer, given back once the response is serialized
+type pooledGetRsp struct {
+	message.GetRspMessage
+	value *[]byte
+}
+
+//give the buffer of a response served from the pool back once it is serialized, it is copied into the frame
+func releaseMessage(m message.NodeMessage) {
+	if rsp, ok := m.(*pooledGetRsp); ok {
+		putBuffer(rsp.value)
+	}
+}
@@ -0,0 +1,217 @@
+package cluster
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+	"github.com/nggenius/ngbigcache/message"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//ChangelogStats shows the changes made on this node kept to replay to remote nodes reconnecting
+type ChangelogStats struct {
+	Epoch    string `json:"epoch"`    //changes every time the node starts
+	Size     int    `json:"size"`     //changes kept at most
+	Kept     int    `json:"kept"`     //changes kept now
+	Seq      uint64 `json:"seq"`      //sequence number of the latest change
+	Resumed  uint64 `json:"resumed"`  //reconnections of remote nodes that had changes replayed
+	Replayed uint64 `json:"replayed"` //changes replayed, only the latest of each key is
+	Missed   uint64 `json:"missed"`   //changes remote nodes missed that were no longer kept when they reconnected
+}
+
+//a put or a delete made on this node
+type change struct {
+	seq     uint64
+	key     string
+	data    []byte
+	expiry  uint64
+	stamp   uint64 //timestamp of the put, 0 unless the last write wins
+	deleted bool
+}
+
+//how far the changes were sent to a remote node before it disconnected
+type changelogCursor struct {
+	epoch string //of the remote node, which lost what it was sent if it restarted since
+	seq   uint64 //of the last change sent
+}
+
+//changelog keeps the latest puts and deletes made on this node in a ring buffer, numbered in sequence, and how
+//far they were sent to every remote node that disconnected. when a remote node reconnects after a short outage,
+//without having restarted, only the changes it missed are replayed to it rather than leaving it diverged
+type changelog struct {
+	lock     sync.Mutex
+	changes  []change
+	next     int    //index in changes the next change goes to
+	seq      uint64 //of the latest change
+	cursors  map[string]changelogCursor
+	resumed  uint64
+	replayed uint64
+	missed   uint64
+}
+
+//a per start identifier of the node, sent during the handshake so its peers tell a restart from a reconnection
+func newEpoch() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36) //the wall clock even when another Clock is configured, to tell restarts apart
+}
+
+//the changelog of the configuration, nil when none is kept
+func newChangelog(config *ClusteredBigCacheConfig) *changelog {
+	if config.ChangelogSize <= 0 {
+		return nil
+	}
+	return &changelog{changes: make([]change, config.ChangelogSize), cursors: make(map[string]changelogCursor)}
+}
+
+//keep a change made on this node, returning its sequence number. 0 when no changelog is kept
+func (c *changelog) record(key string, data []byte, expiry, stamp uint64, deleted bool) uint64 {
+	if c == nil {
+		return 0
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.seq++
+	c.changes[c.next] = change{seq: c.seq, key: key, data: data, expiry: expiry, stamp: stamp, deleted: deleted}
+	c.next = (c.next + 1) % len(c.changes)
+	return c.seq
+}
+
+//sequence number of the oldest change kept, 0 when none is
+func (c *changelog) oldest() uint64 {
+	if c.seq < uint64(len(c.changes)) {
+		if c.seq == 0 {
+			return 0
+		}
+		return 1
+	}
+	return c.seq - uint64(len(c.changes)) + 1
+}
+
+//remember how far the changes were sent to a remote node going down, to replay the rest when it comes back
+func (c *changelog) disconnected(r *remoteNode) {
+	if c == nil || r.epoch == "" || r.mode != clusterModeACTIVE {
+		return
+	}
+	seq := atomic.LoadUint64(&r.sentSeq)
+	if seq == 0 { //it never got past the handshake
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.cursors[r.config.Id] = changelogCursor{epoch: r.epoch, seq: seq}
+}
+
+//replay to a remote node that reconnected the changes it missed while it was away. the latest of each key
+//still kept is sent, in the order they were made
+func (c *changelog) resume(r *remoteNode) {
+	if c == nil || r.mode != clusterModeACTIVE {
+		return
+	}
+
+	c.lock.Lock()
+	cursor, ok := c.cursors[r.config.Id]
+	delete(c.cursors, r.config.Id)
+	if !ok || cursor.epoch != r.epoch { //a new or restarted remote node, it is synced as it always was
+		atomic.CompareAndSwapUint64(&r.sentSeq, 0, c.seq)
+		c.lock.Unlock()
+		return
+	}
+	atomic.CompareAndSwapUint64(&r.sentSeq, 0, cursor.seq)
+
+	missed := uint64(0)
+	from := cursor.seq + 1
+	if oldest := c.oldest(); from < oldest {
+		missed = oldest - from
+		from = oldest
+	}
+	var replay []change
+	latest := make(map[string]int)
+	for seq := from; seq <= c.seq; seq++ {
+		ch := c.changes[(c.next+len(c.changes)-int(c.seq-seq)-1)%len(c.changes)]
+		if i, ok := latest[ch.key]; ok {
+			replay[i].seq = 0 //superseded
+		}
+		latest[ch.key] = len(replay)
+		replay = append(replay, ch)
+	}
+
+	now := uint64(r.parentNode.clock.Now().Unix())
+	msgs := make([]message.NodeMessage, 0, len(latest))
+	for _, ch := range replay {
+		if ch.seq == 0 || (!ch.deleted && ch.expiry != bigcache.NO_EXPIRY && ch.expiry <= now) {
+			continue
+		}
+		putMsg := &message.PutMessage{Key: ch.key, Data: ch.data, Expiry: ch.expiry}
+		if r.version() >= message.MsgMinVersion(message.MsgPUTStamped) {
+			putMsg.Timestamp = ch.stamp
+		}
+		var m message.NodeMessage = putMsg
+		if ch.deleted {
+			m = &message.DeleteMessage{Key: ch.key, Timestamp: ch.stamp}
+		}
+		msgs = append(msgs, sequenced(m, ch.seq))
+	}
+	c.resumed++
+	c.replayed += uint64(len(msgs))
+	c.missed += missed
+	c.lock.Unlock()
+
+	for _, m := range msgs {
+		r.sendMessage(m)
+	}
+
+	msg := fmt.Sprintf("remote node '%s' reconnected, replayed %d changes from %d", r.config.Id, len(msgs), cursor.seq+1)
+	if missed > 0 {
+		utils.Warn(r.logger, fmt.Sprintf("%s, %d more were no longer kept and are left to the cluster to reconcile", msg, missed))
+	} else {
+		utils.Info(r.logger, msg)
+	}
+}
+
+func (c *changelog) stats(epoch string) *ChangelogStats {
+	if c == nil {
+		return nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	kept := len(c.changes)
+	if c.seq < uint64(kept) {
+		kept = int(c.seq)
+	}
+	return &ChangelogStats{Epoch: epoch, Size: len(c.changes), Kept: kept, Seq: c.seq,
+		Resumed: c.resumed, Replayed: c.replayed, Missed: c.missed}
+}
+
+//Changelog returns what the changelog replayed to remote nodes reconnecting, nil unless ChangelogSize is set
+func (node *ClusteredBigCache) Changelog() *ChangelogStats {
+	return node.changelog.stats(node.epoch)
+}
+
+//sequencedMessage is a put or a delete tagged with its sequence number in the changelog, which is not sent but
+//tells how far the changes were sent to the remote node once it is written to the connection
+type sequencedMessage struct {
+	message.NodeMessage
+	seq uint64
+}
+
+//the message to send and its sequence number in the changelog, 0 when it is not a change
+func unsequence(m message.NodeMessage) (message.NodeMessage, uint64) {
+	if s, ok := m.(*sequencedMessage); ok {
+		return s.NodeMessage, s.seq
+	}
+	return m, 0
+}
+
+//the message replicating a change to a remote node
+func sequenced(m message.NodeMessage, seq uint64) message.NodeMessage {
+	if seq == 0 {
+		return m
+	}
+	return &sequencedMessage{NodeMessage: m, seq: seq}
+}
@@ -0,0 +1,393 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+	"github.com/nggenius/ngbigcache/message"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//how long a node waits for the writes it queued to be sent, or for the writes sent to it to be applied, during a
+//cluster snapshot
+const snapshotDrainTimeout = time.Second * 5
+
+//how long the node coordinating a cluster snapshot waits for every node to answer a phase
+const snapshotPhaseTimeout = time.Second * 15
+
+//how long writes stay held for a snapshot the node coordinating it never resumes, e.g because it died
+const snapshotPauseLimit = time.Second * 30
+
+//how often the queues are looked at while waiting for them to drain. the writes sent have to stay the same over
+//it, which gives the writes admitted just before the pause the time to be queued
+const snapshotPollInterval = time.Millisecond * 50
+
+//errors returned by cluster snapshots
+var (
+	ErrSnapshotInProgress = errors.New("a cluster snapshot is already in progress")
+	ErrSnapshotIncomplete = errors.New("not every node wrote its snapshot")
+)
+
+//NodeSnapshot is the snapshot one node wrote during a cluster snapshot
+type NodeSnapshot struct {
+	Id      string `json:"id"`
+	File    string `json:"file"` //on the filesystem of the node
+	Entries int    `json:"entries"`
+	Err     error  `json:"-"` //why the node did not write its snapshot, nil when it did
+}
+
+//writeFence holds the writes made on this node while a cluster snapshot is taken, so the snapshot of every node
+//has the same writes
+type writeFence struct {
+	lock    sync.Mutex
+	id      string         //of the snapshot holding the writes, empty when they are not held
+	resumed chan struct{}  //closed once the writes are taken again
+	timer   bigcache.Timer //resumes the writes when the snapshot is never resumed
+}
+
+//hold the writes for the snapshot id, ErrSnapshotInProgress when another snapshot holds them already
+func (f *writeFence) pause(id string, clock bigcache.Clock) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.id == id {
+		return nil
+	}
+	if f.id != "" {
+		return ErrSnapshotInProgress
+	}
+
+	f.id, f.resumed = id, make(chan struct{})
+	f.timer = clock.AfterFunc(snapshotPauseLimit, func() { f.resume(id) })
+	return nil
+}
+
+//take the writes held for the snapshot id again
+func (f *writeFence) resume(id string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if id == "" || f.id != id {
+		return
+	}
+
+	f.timer.Stop()
+	close(f.resumed)
+	f.id, f.resumed = "", nil
+}
+
+//wait until the writes are no longer held
+func (f *writeFence) wait() {
+	f.lock.Lock()
+	resumed := f.resumed
+	f.lock.Unlock()
+	if resumed != nil {
+		<-resumed
+	}
+}
+
+//what a remote node answered to a phase of a cluster snapshot
+type snapshotReply struct {
+	id  string
+	rsp message.SnapshotRspMessage
+	err error
+}
+
+//SnapshotCluster writes a snapshot of every active node of the cluster, each to dir on its own filesystem, in a
+//file named after the snapshot and the node. there is no leader in the cluster so the node called coordinates the
+//snapshot. the writes are first held on every node and the writes already made are sent. every node then applies
+//every write the others sent it before writing its snapshot, so the snapshots are of the same writes. writes are
+//taken again once every node answered, or after snapshotPauseLimit should this node never say so. the snapshot of
+//every node is returned, along with ErrSnapshotIncomplete when some of them could not be written
+func (node *ClusteredBigCache) SnapshotCluster(dir string) ([]NodeSnapshot, error) {
+	if node.state != clusterStateStarted {
+		return nil, ErrNotStarted
+	}
+
+	id := fmt.Sprintf("%d%s", node.clock.Now().UnixNano(), utils.GenerateNodeId(4))
+	results := make(map[string]*NodeSnapshot)
+	peers := make([]*remoteNode, 0)
+	for _, r := range node.activePeers() {
+		if r.version() < message.MsgMinVersion(message.MsgSNAPSHOTReq) {
+			results[r.config.Id] = &NodeSnapshot{Id: r.config.Id, Err: errors.New("remote node does not take cluster snapshots")}
+			continue
+		}
+		peers = append(peers, r)
+	}
+	local := node.mode == clusterModeACTIVE
+	defer func() {
+		node.fence.resume(id)
+		for _, r := range peers {
+			r.sendMessage(&message.SnapshotReqMessage{Id: id, Phase: message.SnapshotPhaseResume})
+		}
+	}()
+
+	sent := make(map[string]map[string]uint64) //writes each node sent to the others, by id of the node
+	if local {
+		counts, err := node.pauseForSnapshot(id)
+		if err != nil {
+			results[node.config.Id] = &NodeSnapshot{Id: node.config.Id, Err: err}
+		} else {
+			sent[node.config.Id] = counts
+		}
+	}
+	for peerId, reply := range node.snapshotPhase(peers, func(*remoteNode) *message.SnapshotReqMessage {
+		return &message.SnapshotReqMessage{Id: id, Phase: message.SnapshotPhasePause}
+	}) {
+		if reply.err != nil {
+			results[peerId] = &NodeSnapshot{Id: peerId, Err: reply.err}
+		} else {
+			sent[peerId] = reply.rsp.Sent
+		}
+	}
+
+	expected := func(target string) map[string]uint64 {
+		counts := make(map[string]uint64)
+		for source, s := range sent {
+			if n, ok := s[target]; ok && source != target {
+				counts[source] = n
+			}
+		}
+		return counts
+	}
+
+	var wg sync.WaitGroup
+	if _, paused := sent[node.config.Id]; paused {
+		wg.Add(1)
+		if !node.routines.spawn(func() { //written while the remote nodes write theirs
+			defer wg.Done()
+			file, entries, err := node.writeSnapshot(id, dir, expected(node.config.Id))
+			results[node.config.Id] = &NodeSnapshot{Id: node.config.Id, File: file, Entries: entries, Err: err}
+		}) {
+			wg.Done()
+			results[node.config.Id] = &NodeSnapshot{Id: node.config.Id, Err: ErrNotStarted}
+		}
+	}
+	writers := make([]*remoteNode, 0, len(peers))
+	for _, r := range peers {
+		if _, paused := sent[r.config.Id]; paused {
+			writers = append(writers, r)
+		}
+	}
+	replies := node.snapshotPhase(writers, func(r *remoteNode) *message.SnapshotReqMessage {
+		return &message.SnapshotReqMessage{Id: id, Phase: message.SnapshotPhaseWrite, Dir: dir, Expected: expected(r.config.Id)}
+	})
+	wg.Wait()
+	for peerId, reply := range replies {
+		results[peerId] = &NodeSnapshot{Id: peerId, File: reply.rsp.File, Entries: reply.rsp.Entries, Err: reply.err}
+	}
+
+	var err error
+	snapshots := make([]NodeSnapshot, 0, len(results))
+	for _, result := range results {
+		if result.Err != nil {
+			err = ErrSnapshotIncomplete
+		}
+		snapshots = append(snapshots, *result)
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Id < snapshots[j].Id })
+	utils.Info(node.logger, fmt.Sprintf("cluster snapshot '%s' of %d nodes written to '%s'", id, len(snapshots), dir))
+	return snapshots, err
+}
+
+//RestoreSnapshot loads a snapshot this node wrote during a cluster snapshot into the local cache, returning the
+//number of keys restored. the keys are not replicated, every node is restored from its own snapshot instead
+func (node *ClusteredBigCache) RestoreSnapshot(file string) (int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return node.cache.Restore(f, bigcache.SnapshotOptions{})
+}
+
+//ask every remote node to go through a phase of a cluster snapshot, the answers are by id of the remote node
+func (node *ClusteredBigCache) snapshotPhase(peers []*remoteNode,
+	request func(*remoteNode) *message.SnapshotReqMessage) map[string]snapshotReply {
+
+	answers := make(map[string]snapshotReply)
+	waiting := 0
+	replies := make(chan snapshotReply, len(peers))
+	for _, r := range peers {
+		msg := request(r)
+		msg.PendingKey = msg.Id + utils.GenerateNodeId(8)
+		if err := r.askSnapshot(msg, replies); err != nil {
+			answers[r.config.Id] = snapshotReply{id: r.config.Id, err: err}
+			continue
+		}
+		waiting++
+		defer r.pending.cancel(message.MsgSNAPSHOTReq, msg.PendingKey)
+	}
+
+	timer := node.clock.NewTimer(snapshotPhaseTimeout)
+	defer timer.Stop()
+	for ; waiting > 0; waiting-- {
+		select {
+		case reply := <-replies:
+			answers[reply.id] = reply
+		case <-timer.C():
+			for _, r := range peers {
+				if _, ok := answers[r.config.Id]; !ok {
+					answers[r.config.Id] = snapshotReply{id: r.config.Id, err: ErrTimeout}
+				}
+			}
+			return answers
+		}
+	}
+	return answers
+}
+
+//hold the writes made on this node for the snapshot id then wait for the writes already made to be sent. the
+//writes sent to every remote node since it connected are returned, by id
+func (node *ClusteredBigCache) pauseForSnapshot(id string) (map[string]uint64, error) {
+	if err := node.fence.pause(id, node.clock); err != nil {
+		return nil, err
+	}
+	if node.coalescer != nil {
+		node.coalescer.flush()
+	}
+
+	deadline := node.clock.Now().Add(snapshotDrainTimeout)
+	sent := node.writesSent()
+	for {
+		node.clock.Sleep(snapshotPollInterval)
+		current := node.writesSent()
+		if !node.writesQueued() && reflect.DeepEqual(current, sent) {
+			return current, nil
+		}
+		if node.clock.Now().After(deadline) {
+			return nil, errors.New("timed out sending the writes queued")
+		}
+		sent = current
+	}
+}
+
+//wait for the writes every remote node sent this node before the snapshot id to be applied, then write the
+//snapshot of the local cache. the file written and the number of entries in it are returned
+func (node *ClusteredBigCache) writeSnapshot(id, dir string, expected map[string]uint64) (string, int, error) {
+	peers := make(map[string]*remoteNode)
+	for _, r := range node.activePeers() {
+		peers[r.config.Id] = r
+	}
+
+	deadline := node.clock.Now().Add(snapshotDrainTimeout)
+	for peerId, count := range expected {
+		r, ok := peers[peerId]
+		if !ok {
+			return "", 0, fmt.Errorf("remote node '%s' disconnected before its writes were applied", peerId)
+		}
+		for atomic.LoadUint64(&r.writesHandled) < count {
+			if node.clock.Now().After(deadline) {
+				return "", 0, fmt.Errorf("timed out applying the writes of remote node '%s'", peerId)
+			}
+			node.clock.Sleep(time.Millisecond)
+		}
+	}
+
+	file := filepath.Join(dir, id+"-"+node.config.Id+".snap")
+	f, err := os.Create(file)
+	if err != nil {
+		return "", 0, err
+	}
+	entries, err := node.cache.Snapshot(f, bigcache.SnapshotOptions{})
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file)
+		return "", 0, err
+	}
+	return file, entries, nil
+}
+
+//the writes sent to every remote node since it connected, by id
+func (node *ClusteredBigCache) writesSent() map[string]uint64 {
+	sent := make(map[string]uint64)
+	for _, v := range node.getRemoteNodes() {
+		r := v.(*remoteNode)
+		sent[r.config.Id] = atomic.LoadUint64(&r.writesSent)
+	}
+	return sent
+}
+
+//true while messages are waiting to be sent to a remote node
+func (node *ClusteredBigCache) writesQueued() bool {
+	if node.replicationMsgs.len() > 0 {
+		return true
+	}
+	for _, lag := range node.ReplicationLag() {
+		if lag.Queued > 0 {
+			return true
+		}
+	}
+	for _, v := range node.getRemoteNodes() {
+		if len(v.(*remoteNode).outboundMsgQueue) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+//ask the remote node to go through a phase of a cluster snapshot, the answer is sent on replies
+func (r *remoteNode) askSnapshot(msg *message.SnapshotReqMessage, replies chan snapshotReply) error {
+	if r.state == nodeStateDisconnected {
+		return ErrNodeDisconnected
+	}
+	if err := r.pending.add(message.MsgSNAPSHOTReq, msg.PendingKey, replies, func() {
+		replies <- snapshotReply{id: r.config.Id, err: ErrNodeDisconnected}
+	}); err != nil {
+		return err
+	}
+	r.sendMessage(msg)
+	return nil
+}
+
+func (r *remoteNode) handleSnapshotRequest(msg *message.NodeWireMessage) {
+	reqMsg := message.SnapshotReqMessage{}
+	reqMsg.DeSerialize(msg)
+	if reqMsg.Phase == message.SnapshotPhaseResume {
+		r.parentNode.fence.resume(reqMsg.Id)
+		return
+	}
+
+	r.parentNode.routines.spawn(func() { //the message handler has to go on applying the writes this waits for
+		rsp := &message.SnapshotRspMessage{PendingKey: reqMsg.PendingKey}
+		var err error
+		switch {
+		case r.parentNode.mode != clusterModeACTIVE:
+			err = errors.New("passive clients hold no keys")
+		case reqMsg.Phase == message.SnapshotPhasePause:
+			rsp.Sent, err = r.parentNode.pauseForSnapshot(reqMsg.Id)
+		case reqMsg.Phase == message.SnapshotPhaseWrite:
+			rsp.File, rsp.Entries, err = r.parentNode.writeSnapshot(reqMsg.Id, reqMsg.Dir, reqMsg.Expected)
+		default:
+			err = fmt.Errorf("unknown snapshot phase '%s'", reqMsg.Phase)
+		}
+		if err != nil {
+			rsp.Error = err.Error()
+		}
+		r.sendMessage(rsp)
+	})
+}
+
+func (r *remoteNode) handleSnapshotResponse(msg *message.NodeWireMessage) {
+	rspMsg := message.SnapshotRspMessage{}
+	rspMsg.DeSerialize(msg)
+	replies, ok := r.pending.take(message.MsgSNAPSHOTReq, rspMsg.PendingKey)
+	if !ok { //the phase timed out
+		return
+	}
+
+	reply := snapshotReply{id: r.config.Id, rsp: rspMsg}
+	if rspMsg.Error != "" {
+		reply.err = errors.New(rspMsg.Error)
+	}
+	replies.(chan snapshotReply) <- reply //buffered for every remote node asked so this never blocks
+}
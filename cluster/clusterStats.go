@@ -0,0 +1,197 @@
+package cluster
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+	"github.com/nggenius/ngbigcache/message"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//time the admin server waits for the remote nodes to report their statistics
+const adminClusterStatsTimeout = time.Second
+
+//NodeReport is what a node reported of itself to ClusterStatistics
+type NodeReport struct {
+	Id         string                `json:"id"`
+	Statistics *NodeStatistics       `json:"statistics,omitempty"`
+	Shards     []bigcache.ShardStats `json:"shards,omitempty"` //of the local cache, nil for passive clients
+	Error      string                `json:"error,omitempty"`  //why the node did not report, nothing else is set then
+}
+
+//ClusterReport is the statistics of every node connected to this one, gathered so a dashboard reads the whole
+//cluster from a single node
+type ClusterReport struct {
+	Nodes   []NodeReport    `json:"nodes"`   //this node first, then the remote nodes by id
+	Cache   CacheStatistics `json:"cache"`   //summed over the local caches of the active nodes that reported
+	Active  int             `json:"active"`  //active nodes that reported, this one included
+	Passive int             `json:"passive"` //passive clients that reported, this one included
+	Failed  int             `json:"failed"`  //nodes that did not report in time or went away
+}
+
+//what a remote node reported of itself
+type statsReply struct {
+	id     string
+	stats  *NodeStatistics
+	shards []bigcache.ShardStats
+	err    error //ErrNodeDisconnected when the remote node went away before answering
+}
+
+//ClusterStatistics asks every connected remote node, active or passive, for its statistics and those of the
+//shards of its local cache, and returns them along with those of this node and the caches of the active nodes
+//summed. nodes that do not answer within timeout are reported with the error rather than failing the report
+func (node *ClusteredBigCache) ClusterStatistics(timeout time.Duration) (*ClusterReport, error) {
+	if node.state != clusterStateStarted {
+		return nil, ErrNotStarted
+	}
+
+	local := NodeReport{Id: node.config.Id, Statistics: node.StatisticsStruct()}
+	if node.mode == clusterModeACTIVE {
+		local.Shards = node.cache.ShardStats()
+	}
+
+	remotes := make([]NodeReport, 0)
+	waiting := make(map[string]bool)
+	peers := node.getRemoteNodes()
+	replies := make(chan statsReply, len(peers))
+	for _, v := range peers {
+		r := v.(*remoteNode)
+		if r.version() < message.MsgMinVersion(message.MsgSTATSReq) { //older nodes would never answer
+			remotes = append(remotes, NodeReport{Id: r.config.Id, Error: "does not answer statistics requests"})
+			continue
+		}
+		pendingKey := r.config.Id + utils.GenerateNodeId(8)
+		if err := r.askStats(pendingKey, replies); err != nil {
+			remotes = append(remotes, NodeReport{Id: r.config.Id, Error: err.Error()})
+			continue
+		}
+		waiting[r.config.Id] = true
+		defer r.pending.cancel(message.MsgSTATSReq, pendingKey)
+	}
+
+	timer := node.clock.NewTimer(timeout)
+	defer timer.Stop()
+	for len(waiting) > 0 {
+		select {
+		case reply := <-replies:
+			delete(waiting, reply.id)
+			report := NodeReport{Id: reply.id, Statistics: reply.stats, Shards: reply.shards}
+			if reply.err != nil {
+				report = NodeReport{Id: reply.id, Error: reply.err.Error()}
+			}
+			remotes = append(remotes, report)
+		case <-timer.C():
+			for id := range waiting {
+				remotes = append(remotes, NodeReport{Id: id, Error: ErrTimeout.Error()})
+			}
+			waiting = nil
+		}
+	}
+
+	sort.Slice(remotes, func(i, j int) bool { return remotes[i].Id < remotes[j].Id })
+	report := &ClusterReport{Nodes: append([]NodeReport{local}, remotes...)}
+	for _, n := range report.Nodes {
+		switch {
+		case n.Statistics == nil:
+			report.Failed++
+		case n.Statistics.Passive:
+			report.Passive++
+		default:
+			report.Active++
+			report.Cache.add(n.Statistics.Cache)
+		}
+	}
+	if report.Cache.Hits+report.Cache.Misses > 0 {
+		report.Cache.HitRatio = float64(report.Cache.Hits) / float64(report.Cache.Hits+report.Cache.Misses)
+	}
+	return report, nil
+}
+
+//add the statistics of another cache to these, but for the hit ratio which is computed from the sums
+func (c *CacheStatistics) add(other *CacheStatistics) {
+	if other == nil {
+		return
+	}
+	c.Entries += other.Entries
+	c.Shards += other.Shards
+	c.Capacity += other.Capacity
+	c.LiveBytes += other.LiveBytes
+	c.BytesUsed += other.BytesUsed
+	c.Hits += other.Hits
+	c.Misses += other.Misses
+	c.DelHits += other.DelHits
+	c.DelMisses += other.DelMisses
+	c.Collisions += other.Collisions
+	c.Expired += other.Expired
+	c.Evicted += other.Evicted
+	c.Deleted += other.Deleted
+	c.Overwritten += other.Overwritten
+	c.Cleared += other.Cleared
+	c.NoSpace += other.NoSpace
+	c.Reclaimed += other.Reclaimed
+}
+
+//ask the remote node for its statistics, the reply is sent on replies
+func (r *remoteNode) askStats(pendingKey string, replies chan statsReply) error {
+	if r.state == nodeStateDisconnected {
+		return ErrNodeDisconnected
+	}
+	if err := r.pending.add(message.MsgSTATSReq, pendingKey, replies, func() {
+		replies <- statsReply{id: r.config.Id, err: ErrNodeDisconnected}
+	}); err != nil {
+		return err
+	}
+	r.sendMessage(&message.StatsReqMessage{Shards: true, PendingKey: pendingKey})
+	return nil
+}
+
+func (r *remoteNode) handleStatsRequest(msg *message.NodeWireMessage) {
+	reqMsg := message.StatsReqMessage{}
+	reqMsg.DeSerialize(msg)
+
+	rsp := &message.StatsRspMessage{PendingKey: reqMsg.PendingKey}
+	rsp.Stats, _ = json.Marshal(r.parentNode.StatisticsStruct())
+	if reqMsg.Shards && r.parentNode.mode == clusterModeACTIVE {
+		rsp.Shards, _ = json.Marshal(r.parentNode.cache.ShardStats())
+	}
+	r.sendMessage(rsp)
+}
+
+func (r *remoteNode) handleStatsResponse(msg *message.NodeWireMessage) {
+	rspMsg := message.StatsRspMessage{}
+	rspMsg.DeSerialize(msg)
+	replies, ok := r.pending.take(message.MsgSTATSReq, rspMsg.PendingKey)
+	if !ok { //the request timed out
+		return
+	}
+
+	reply := statsReply{id: r.config.Id, stats: &NodeStatistics{}}
+	if err := json.Unmarshal(rspMsg.Stats, reply.stats); err != nil {
+		reply.err = err
+	} else if len(rspMsg.Shards) > 0 {
+		reply.err = json.Unmarshal(rspMsg.Shards, &reply.shards)
+	}
+	//buffered for every remote node asked so this never blocks
+	replies.(chan statsReply) <- reply
+}
+
+//serve the statistics of every node connected to this one, e.g /cluster-stats?timeout=500
+func (node *ClusteredBigCache) handleAdminClusterStats(w http.ResponseWriter, req *http.Request) {
+	timeout := adminClusterStatsTimeout
+	if ms, err := strconv.Atoi(req.URL.Query().Get("timeout")); err == nil && ms > 0 {
+		timeout = time.Millisecond * time.Duration(ms)
+	}
+
+	report, err := node.ClusterStatistics(timeout)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -1,16 +1,23 @@
 package cluster
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
+	"net/http"
+	"os"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 
 	"time"
+	"unsafe"
 
 	"github.com/nggenius/ngbigcache/bigcache"
 	"github.com/nggenius/ngbigcache/comms"
+	"github.com/nggenius/ngbigcache/discovery"
 	"github.com/nggenius/ngbigcache/message"
 	"github.com/nggenius/ngbigcache/utils"
 )
@@ -34,9 +41,20 @@ const CHAN_SIZE = 1024 * 64
 //Global errors that can be returned to callers
 var (
 	ErrNotEnoughReplica = errors.New("not enough replica")
-	ErrNotFound         = errors.New("data not found")
-	ErrTimedOut         = errors.New("not found as a result of timing out")
+	ErrEntryNotFound    = bigcache.ErrEntryNotFound //the same as the local cache returns, so errors.Is matches either
+	ErrNotFound         = ErrEntryNotFound          //former name of ErrEntryNotFound
+	ErrTimeout          = errors.New("timed out waiting for the remote nodes to answer")
+	ErrTimedOut         = ErrTimeout //former name of ErrTimeout
+	ErrNodeDisconnected = errors.New("remote node disconnected before answering")
 	ErrNotStarted       = errors.New("node not started, call Start()")
+	ErrNoQuorum         = errors.New("fewer nodes than MinimumClusterSize in the cluster, writes are refused")
+	ErrLeaving          = errors.New("node is leaving the cluster, writes are refused")
+	ErrLeaveIncomplete  = errors.New("not every node acknowledged the keys handed over before leaving")
+	ErrNotPassive       = errors.New("only passive clients can do this")
+	ErrNoPeers          = errors.New("not found locally and no active remote node to ask")
+	ErrReadOnly         = errors.New("node is read only, writes are refused")
+
+	ErrReplicationBackpressure = errors.New("a remote node has more writes waiting than ReplicationHighWaterMark, puts are refused")
 )
 
 //ClusteredBigCacheConfig is configuration for the cache
@@ -44,8 +62,13 @@ type ClusteredBigCacheConfig struct {
 	Id                      string   `json:"id"`
 	Join                    bool     `json:"join"`
 	JoinIp                  string   `json:"join_ip"`
-	LocalAddresses          []string `json:"local_addresses"`
+	JoinIps                 []string `json:"join_ips"`        //more seed addresses to join through, tried along with JoinIp until one answers
+	LocalAddresses          []string `json:"local_addresses"` //addresses to listen on, "host" or "host:port" with IPv6 hosts in brackets
 	LocalPort               int      `json:"local_port"`
+	LocalSocket             string   `json:"local_socket"`    //optional unix domain socket path to also listen on for co-located nodes
+	WebSocketPort           int      `json:"web_socket_port"` //optional port to also accept websocket connections on
+	AdvertisedHost          string   `json:"advertised_host"` //host other nodes are told to connect to, for nodes behind NAT or in containers
+	AdvertisedPort          int      `json:"advertised_port"` //port other nodes are told to connect to, LocalPort if not set
 	BindAll                 bool     `json:"bind_all"`
 	ConnectRetries          int      `json:"connect_retries"`
 	TerminateOnListenerExit bool     `json:"terminate_on_listener_exit"`
@@ -54,11 +77,122 @@ type ClusteredBigCacheConfig struct {
 	WriteAck                bool     `json:"write_ack"`
 	DebugMode               bool     `json:"debug_mode"`
 	DebugPort               int      `json:"debug_port"`
+	ProbePort               int      `json:"probe_port"` //port serving only the /healthz and /readyz probes, 0 serves them on the admin server alone
+	PprofPort               int      `json:"pprof_port"` //port serving net/http/pprof, goroutine dumps and queue depths, 0 for none
 	ReconnectOnDisconnect   bool     `json:"reconnect_on_disconnect"`
 	PingFailureThreshHold   int32    `json:"ping_failure_thresh_hold"`
 	PingInterval            int      `json:"ping_interval"`
 	PingTimeout             int      `json:"ping_timeout"`
-	ShardSize               int      `json:"shard_size"`
+	CloseOnCorruptMessage   bool     `json:"close_on_corrupt_message"` //close the connection to a node that sends a message which cannot be handled
+	ShardSize               int      `json:"shard_size"`               //number of shards of the local cache, a power of two, 16 if less
+	LockStripes             int      `json:"lock_stripes"`             //power of two locks reads of every shard of the local cache are spread over, 0 for one
+	MaxEntrySize            int      `json:"max_entry_size"`           //bytes of an entry the local cache is sized for up front, 500 if not set
+	MaxEntriesInWindow      int      `json:"max_entries_in_window"`    //entries the local cache is sized for up front, 600000 if not set
+	HardMaxCacheSize        int      `json:"hard_max_cache_size"`      //megabytes the local cache grows to before evicting its oldest entries, 0 for no limit
+	QuietCache              bool     `json:"quiet_cache"`              //do not log the memory the local cache allocates
+	IndexKeys               bool     `json:"index_keys"`               //index the keys of the local cache so they can be counted and listed by prefix
+	ConnectionsPerNode      int      `json:"connections_per_node"`
+	WriteCoalesceInterval   int      `json:"write_coalesce_interval"` //milliseconds between replication of PutCoalesced keys
+	CompactInterval         int      `json:"compact_interval"`        //milliseconds between background compactions of the local cache, 0 disables them
+	MmapDir                 string   `json:"mmap_dir"`                //directory the local cache is kept in files mapped to memory, "" keeps it on the heap
+	MaxValueSize            int      `json:"max_value_size"`          //largest value in bytes written or replicated, 0 for no limit
+	HotKeyCapacity          int      `json:"hot_key_capacity"`        //number of most read keys tracked for the dashboard and for priming
+	DetectDivergence        bool     `json:"detect_divergence"`       //ask every peer on reads and compare what they reply with
+	DivergenceWindow        int      `json:"divergence_window"`       //number of compared reads the divergence rate is computed over
+	DivergenceThreshold     float64  `json:"divergence_threshold"`    //rate of divergent reads that raises EventDivergenceAlarm
+
+	RetryInitialInterval int     `json:"retry_initial_interval"` //milliseconds before the first retry to connect to a remote node
+	RetryMaxInterval     int     `json:"retry_max_interval"`     //milliseconds the interval between retries grows up to
+	RetryMultiplier      float64 `json:"retry_multiplier"`       //factor the interval grows by after every retry
+	RetryJitter          float64 `json:"retry_jitter"`           //fraction of the interval randomly added or taken off it
+
+	WriteThrottleThreshold int  `json:"write_throttle_threshold"` //writes per second finding no space that turn write throttling on, 0 disables it
+	WriteThrottleMode      byte `json:"write_throttle_mode"`      //THROTTLE_MODE_DELAY or THROTTLE_MODE_REJECT
+	WriteThrottleDelay     int  `json:"write_throttle_delay"`     //milliseconds throttled writes are delayed by, or told to retry after
+
+	PrimeBudget int `json:"prime_budget"` //bytes of the most read keys to ask for when joining, 0 disables priming
+
+	Gossip           bool `json:"gossip"`            //keep the membership with SWIM style gossip and suspect failed nodes before declaring them dead
+	GossipInterval   int  `json:"gossip_interval"`   //milliseconds between rounds of gossip
+	GossipFanout     int  `json:"gossip_fanout"`     //number of random peers gossiped to every round
+	SuspicionTimeout int  `json:"suspicion_timeout"` //milliseconds a suspected node has to refute the suspicion before it is declared dead
+
+	InboundWriteRate         int `json:"inbound_write_rate"`         //put and delete messages per second accepted from a remote node, 0 for no limit
+	InboundReadRate          int `json:"inbound_read_rate"`          //get requests per second accepted from a remote node, 0 for no limit
+	InboundBurst             int `json:"inbound_burst"`              //messages accepted at once over the rates, the rate if less
+	FloodDisconnectThreshold int `json:"flood_disconnect_threshold"` //messages dropped within a second that close the connection, 0 never does
+
+	ReadOnlyMode byte `json:"read_only_mode"` //READ_ONLY_MODE_REFUSE or READ_ONLY_MODE_DROP, what a read only node does with replicated writes
+
+	Role byte `json:"role"` //PEER_ROLE_READ_WRITE or PEER_ROLE_READ_ONLY, the role asked for from peers. a read only node's writes fail with ErrReadOnly
+
+	NoPeersMode byte `json:"no_peers_mode"` //NO_PEERS_MODE_FAIL_FAST or NO_PEERS_MODE_WAIT, what a read does without active remote nodes
+
+	WarmUpPeriod   int     `json:"warm_up_period"`    //seconds after start during which keys read from remote nodes are stored locally, 0 disables warming up
+	WarmUpHitRatio float64 `json:"warm_up_hit_ratio"` //local hit ratio that ends warming up before WarmUpPeriod is over, 0 never does
+
+	MinimumClusterSize int  `json:"minimum_cluster_size"` //active nodes, this one included, that must be seen for the node to have quorum
+	QuorumMode         byte `json:"quorum_mode"`          //QUORUM_MODE_READ_ONLY or QUORUM_MODE_NOTIFY, what to do without quorum
+
+	StrictMode bool `json:"strict_mode"` //report dropped messages and replicated writes not applied instead of only counting them
+
+	LogRequests bool `json:"log_requests"` //log every get and put with a correlation id, which the remote nodes involved log as well
+
+	PassiveIdleTimeout int `json:"passive_idle_timeout"` //seconds without reads or writes from a passive client before its connection is closed, 0 keeps it open
+
+	DNSTTL int `json:"dns_ttl"` //milliseconds the system resolver's addresses of peers addressed by hostname are kept before being looked up again
+
+	MinReplicatedTTL int `json:"min_replicated_ttl"` //seconds replicated puts that arrive with less time left are kept for, 0 keeps them as they are
+
+	BandwidthInterval int `json:"bandwidth_interval"` //seconds over which the bytes of writes made, sent and received are reported, 10 if not set
+
+	ChangelogSize int `json:"changelog_size"` //latest puts and deletes made on this node kept to replay to remote nodes reconnecting after a short outage, 0 keeps none
+
+	ReplicationRetryQueue int `json:"replication_retry_queue"` //writes kept per remote node while it cannot take them, to retry with a backoff. 0 waits for it instead
+
+	ReplicationHighWaterMark int `json:"replication_high_water_mark"` //messages waiting for a remote node over which puts are refused with ErrReplicationBackpressure, 0 never refuses them
+
+	Weight int `json:"weight"` //capacity of the node relative to the others, it owns a share of the keys in proportion to it. 0 is 1
+
+	LastWriteWins bool `json:"last_write_wins"` //stamp puts with a hybrid logical clock and discard replicated puts older than the local copy, so nodes converge on the latest
+
+	ProfileDir          string `json:"profile_dir"`           //directory cpu and heap profiles captured on latency spikes are saved to, "" disables the auto-profiler
+	ProfileLatency      int    `json:"profile_latency"`       //milliseconds a get, put or delete taking longer triggers a profile, 0 never
+	ProfileEvictionPass int    `json:"profile_eviction_pass"` //milliseconds a pass of a shard expiring entries taking longer triggers a profile, 0 never
+	ProfileDuration     int    `json:"profile_duration"`      //milliseconds the cpu is profiled for, 2000 if not set
+	ProfileInterval     int    `json:"profile_interval"`      //seconds at least between two profiles, 300 if not set
+
+	SlowGetThreshold  int `json:"slow_get_threshold"`  //milliseconds a get taking longer is logged with the hash of its key and the remote node that answered, 0 never
+	SlowPingThreshold int `json:"slow_ping_threshold"` //milliseconds a ping round trip taking longer is logged with the remote node, 0 never
+	SlowLockWait      int `json:"slow_lock_wait"`      //microseconds a read or write waiting longer for the lock of its shard is logged with the hash of its key, 0 never
+
+	Instance     string `json:"instance"`       //name telling this node from others in the same process, put in front of its log messages and in its stats
+	MaxFrameSize int    `json:"max_frame_size"` //largest message in bytes accepted from a remote node, message.MaxFrameSize if not set
+
+	ReadDeadline  int `json:"read_deadline"`  //milliseconds without a message from a remote node before its connection is dropped, longer than PingInterval, 0 never
+	WriteDeadline int `json:"write_deadline"` //milliseconds a message may take to be written to a remote node before its connection is dropped, 0 never
+
+	EvictionBroadcast     byte `json:"eviction_broadcast"`      //which evictions from the local cache the remote nodes are told to delete their copies of, EVICTION_BROADCAST_OFF by default
+	EvictionBatchInterval int  `json:"eviction_batch_interval"` //milliseconds evicted keys are gathered for before they are broadcast in a batch, 100 if not set
+
+	AdaptivePing    bool `json:"adaptive_ping"`     //space the pings to a remote node out while they are answered and skip them while it sends messages, back to PingInterval once one is not answered
+	MaxPingInterval int  `json:"max_ping_interval"` //seconds adaptive pings are spaced out to at most, 8 times PingInterval if not set
+
+	Expvar bool `json:"expvar"` //publish the statistics of the node through expvar as ngbigcache, or ngbigcache.<instance> when Instance is set
+
+	DebugBindAddress string `json:"debug_bind_address"` //host the admin, probe and pprof servers listen on, 127.0.0.1 if not set. 0.0.0.0 for every interface
+	AdminToken       string `json:"admin_token"`        //bearer token the admin routes that change the node require, "" refuses those routes
+
+	OnEvent          func(Event)            `json:"-"` //called with cluster events, it must not block
+	Discovery        discovery.Discovery    `json:"-"` //optional backend the node announces itself to and learns its peers from
+	ConflictResolver ConflictResolver       `json:"-"` //optional merge of local and remote copies of a key that disagree
+	OnInternalError  func(*InternalError)   `json:"-"` //called in strict mode with failures the node recovered from, it must not block
+	Resolver         comms.HostResolver     `json:"-"` //optional lookup of peers addressed by hostname, the system resolver with DNSTTL when nil
+	ConfigureCache   func(*bigcache.Config) `json:"-"` //optional, called with the configuration of the local cache before it is created, for what is not set above
+	WarmLoader       WarmLoader             `json:"-"` //optional, run by Start to fill the local cache before the node listens and joins the cluster
+	Clock            bigcache.Clock         `json:"-"` //optional source of the time of the node and its local cache, the system clock when nil
+	Transport        comms.Transport        `json:"-"` //optional, how the node listens and dials remote nodes, e.g comms.NewMemoryTransport() in tests. the network stack when nil
+	FrameInterceptor comms.Interceptor      `json:"-"` //optional, set on every connection of the node to drop, delay, duplicate or corrupt the frames it sends, for tests
 }
 
 //ClusteredBigCache definition
@@ -67,71 +201,181 @@ type ClusteredBigCache struct {
 	cache           *bigcache.BigCache
 	remoteNodes     *utils.SliceList
 	logger          utils.AppLogger
-	serverEndpoint  net.Listener
+	serverEndpoints []net.Listener
+	socketEndpoint  net.Listener
+	webSocketServer *http.Server
 	joinQueue       chan *message.ProposedPeer
 	pendingConn     sync.Map
 	nodeIndex       int
 	getRequestChan  chan *getRequestDataWrapper
-	replicationChan chan *replicationMsg
+	replicationMsgs replicationChans //writes waiting to be replicated, a channel per replication goroutine
 	state           byte
 	mode            byte
+	coalescer       *writeCoalescer
+	hotKeys         *hotKeyTracker
+	divergence      *divergenceTracker
+	throttle        *writeThrottle
+	adminServer     *http.Server
+	probeServer     *http.Server
+	pprofServer     *http.Server
+	discoveryDone   chan struct{}
+	gossip          *gossiper
+	quorumLost      int32
+	leaving         int32
+	watch           *keyWatch
+	watchers        *watchRegistry
+	warmUp          *warmUp
+	peerReady       chan struct{}
+	peerReadyLock   sync.Mutex
+	noPeers         uint64
+	readOnly        int32
+	readOnlyDropped uint64
+	roleDropped     [len(peerRoleNames)]uint64
+	keyLocks        keyLocks
+	internalErrors  uint64
+	dns             *comms.DNSCache //addresses of the peers addressed by hostname
+	idle            *idleReaper
+	idlePeers       sync.Map //active nodes that closed this passive client's connections for being idle, by id
+	statsFeed       *statsFeed
+	statsPublisher  *statsPublisher
+	expiredReplicas uint64       //replicated puts dropped for arriving after their expiry
+	clampedTTLs     uint64       //replicated puts kept for MinReplicatedTTL for arriving with less time left
+	backpressured   uint64       //puts refused or given up on while a remote node had too many writes waiting
+	resharding      atomic.Value //*ReshardStats while the local cache is resharded
+	reReplication   *ReReplicationStats
+	replicaLock     sync.Mutex //guards reReplication
+	bandwidth       *writeBandwidth
+	profiler        *autoProfiler     //nil unless profiles are captured on latency spikes
+	slow            *slowOps          //nil unless slow operations are logged
+	namespaces      sync.Map          //*Namespace by name
+	counts          sync.Map          //*uint64 count of a passive client in every counter it added to, by key
+	retryQueue      *replicationQueue //nil unless replicated writes are retried
+	epoch           string            //changes every time the node is created, sent during the handshake
+	changelog       *changelog        //nil unless changes are kept to replay to remote nodes reconnecting
+	versions        *writeVersions    //nil unless the last write wins
+	fence           writeFence        //holds the writes while a cluster snapshot is taken
+	listeners       int32             //listening loops started
+	listening       int32             //listening loops still accepting connections
+	warming         int32             //set while the WarmLoader runs
+	clock           bigcache.Clock    //the configured Clock, or the system clock
+
+	evictions      *evictionBroadcaster //nil unless evictions are broadcast
+	evictedByPeers uint64               //keys deleted as remote nodes evicted them
+	listLocks      keyLocks             //order the pushes and pops of the lists owned by this node
+	runGroups      *runGroups           //actors of the run.Groups of the remote nodes still running
+	ctx            context.Context      //done once ShutDown begins, those of the remote nodes derive from it
+	cancel         context.CancelFunc   //cancels ctx
+	routines       routines             //goroutines of the node and its remote nodes, waited for by ShutDownAndWait
+	reReplicating  sync.WaitGroup       //copying started by SetReplicationFactor, ShutDown waits for it before closing the cache
 }
 
 //New creates a new local node
 func New(config *ClusteredBigCacheConfig, logger utils.AppLogger) *ClusteredBigCache {
 
-	cfg := bigcache.DefaultConfig()
-	if config.ShardSize < 16 {
-		cfg.Shards = 16
-	} else {
-		cfg.Shards = config.ShardSize
+	logger = utils.WithPrefix(logger, instancePrefix(config))
+	if errs := config.Validate(); len(errs) > 0 { //refused by Start
+		utils.Error(logger, (&bigcache.ConfigError{Errors: errs}).Error())
+		return newNode(config, nil, logger, clusterModeACTIVE)
+	}
+
+	cfg := config.cacheConfig()
+	profiler := newAutoProfiler(config, logger)
+	if profiler != nil && config.ProfileEvictionPass > 0 {
+		cfg.OnEvictionPass = profiler.observeEvictionPass
+	}
+	slow := newSlowOps(config, logger)
+	if slow != nil && slow.lock > 0 {
+		cfg.LockWaitBudget = slow.lock
+		cfg.OnSlowLock = slow.observeLockWait
 	}
+	if config.ConfigureCache != nil {
+		config.ConfigureCache(&cfg)
+	}
+	evictions := newEvictionBroadcaster(config)
+	evictions.hook(&cfg)
+	versions := newWriteVersions(config)
+	versions.hook(&cfg)
 	cache, err := bigcache.NewBigCache(cfg)
 	if err != nil {
 		panic(err)
 	}
 
-	return &ClusteredBigCache{
-		config:          config,
-		cache:           cache,
-		remoteNodes:     utils.NewSliceList(),
-		logger:          logger,
-		joinQueue:       make(chan *message.ProposedPeer, 512),
-		pendingConn:     sync.Map{},
-		nodeIndex:       0,
-		getRequestChan:  make(chan *getRequestDataWrapper, CHAN_SIZE),
-		replicationChan: make(chan *replicationMsg, CHAN_SIZE),
-		state:           clusterStateStarting,
-		mode:            clusterModeACTIVE,
-	}
+	node := newNode(config, cache, logger, clusterModeACTIVE)
+	node.profiler = profiler
+	node.slow = slow
+	node.evictions = evictions
+	node.versions = versions.bind(node)
+	return node
 }
 
-//NewPassiveClient creates a new local node that does not store any data locally
+//NewPassiveClient creates a new local node that does not store any data locally.
+//serverEndpoint can hold several comma separated seed addresses, the client fails over between them
 func NewPassiveClient(id string, serverEndpoint string, localPort, pingInterval, pingTimeout int, pingFailureThreashold int32, logger utils.AppLogger) *ClusteredBigCache {
 
 	config := DefaultClusterConfig()
 	config.Id = id
 	config.Join = true
-	config.JoinIp = serverEndpoint
+	for _, seed := range strings.Split(serverEndpoint, ",") {
+		config.JoinIps = append(config.JoinIps, strings.TrimSpace(seed))
+	}
 	config.ReconnectOnDisconnect = true
 	config.LocalPort = localPort
 	config.PingInterval = pingInterval
 	config.PingTimeout = pingTimeout
 	config.PingFailureThreshHold = pingFailureThreashold
 
-	return &ClusteredBigCache{
+	node := newNode(config, nil, logger, clusterModePASSIVE)
+	node.versions = newWriteVersions(config).bind(node)
+	return node
+}
+
+//build the node struct shared by active and passive nodes
+func newNode(config *ClusteredBigCacheConfig, cache *bigcache.BigCache, logger utils.AppLogger, mode byte) *ClusteredBigCache {
+
+	node := &ClusteredBigCache{
 		config:          config,
-		cache:           nil,
+		cache:           cache,
 		remoteNodes:     utils.NewSliceList(),
 		logger:          logger,
 		joinQueue:       make(chan *message.ProposedPeer, 512),
 		pendingConn:     sync.Map{},
 		nodeIndex:       0,
 		getRequestChan:  make(chan *getRequestDataWrapper, CHAN_SIZE),
-		replicationChan: make(chan *replicationMsg, CHAN_SIZE),
+		replicationMsgs: newReplicationChans(CHAN_SIZE),
 		state:           clusterStateStarting,
-		mode:            clusterModePASSIVE,
+		mode:            mode,
+		hotKeys:         newHotKeyTracker(config.HotKeyCapacity),
+		divergence:      newDivergenceTracker(config.DivergenceThreshold, config.DivergenceWindow),
+		watch:           &keyWatch{},
+		watchers:        newWatchRegistry(),
+		statsFeed:       &statsFeed{},
+		clock:           config.clock(),
 	}
+	node.dns = node.newDNSCache()
+	node.statsPublisher = newStatsPublisher(node)
+	node.bandwidth = newWriteBandwidth(node)
+	node.retryQueue = newReplicationQueue(node)
+	node.epoch = newEpoch()
+	node.changelog = newChangelog(config)
+	node.runGroups = newRunGroups()
+	node.ctx, node.cancel = context.WithCancel(context.Background())
+	return node
+}
+
+//what the log messages of the instance config configures start with, nothing when it has no name
+func instancePrefix(config *ClusteredBigCacheConfig) string {
+	if config.Instance == "" {
+		return ""
+	}
+	return "[" + config.Instance + "] "
+}
+
+//largest frame accepted from remote nodes
+func (node *ClusteredBigCache) maxFrameSize() uint32 {
+	if node.config.MaxFrameSize > 0 {
+		return uint32(node.config.MaxFrameSize)
+	}
+	return message.MaxFrameSize
 }
 
 //check configuration values
@@ -161,12 +405,40 @@ func (node *ClusteredBigCache) LocalPort() int {
 //Start this Cluster running
 func (node *ClusteredBigCache) Start() error {
 
+	if errs := node.config.Validate(); len(errs) > 0 {
+		return &bigcache.ConfigError{Errors: errs}
+	}
 	for x := 0; x < 5; x++ {
-		go node.requestSenderForGET()
-		go node.replication()
+		node.routines.spawn(node.requestSenderForGET)
+	}
+	for _, ch := range node.replicationMsgs {
+		ch := ch
+		node.routines.spawn(func() { node.replication(ch) })
 	}
 
 	node.checkConfig()
+	if node.config.WriteCoalesceInterval > 0 {
+		node.coalescer = newWriteCoalescer(node, time.Millisecond*time.Duration(node.config.WriteCoalesceInterval))
+	}
+	node.evictions.start(node)
+	if node.config.WriteThrottleThreshold > 0 && node.mode == clusterModeACTIVE {
+		node.throttle = newWriteThrottle(node, cachePressure(node))
+		node.routines.spawn(node.throttle.run)
+	}
+	if node.config.WarmLoader != nil { //before the node can be asked for keys
+		if err := node.Warm(node.config.WarmLoader); err != nil {
+			utils.Error(node.logger, fmt.Sprintf("warming the cache up failed, starting with what was loaded [%s]", err))
+		}
+	}
+	if node.config.WarmUpPeriod > 0 && node.mode == clusterModeACTIVE {
+		node.warmUp = newWarmUp(node)
+		node.routines.spawn(node.warmUp.run)
+	}
+	if node.config.PassiveIdleTimeout > 0 && node.mode == clusterModeACTIVE {
+		node.idle = newIdleReaper(node)
+		node.routines.spawn(node.idle.run)
+	}
+	node.routines.spawn(node.bandwidth.run)
 	if "" == node.config.Id {
 		node.config.Id = utils.GenerateNodeId(32)
 	}
@@ -176,11 +448,32 @@ func (node *ClusteredBigCache) Start() error {
 		return err
 	}
 
-	if node.config.LocalPort == 0 {
-		node.config.LocalPort = node.serverEndpoint.Addr().(*net.TCPAddr).Port
+	node.checkQuorum() //a node starts without quorum until it has joined enough nodes
+	if node.config.Gossip {
+		node.gossip = newGossiper(node)
+		node.routines.spawn(node.gossip.run)
+	}
+
+	if node.config.DebugMode {
+		if err := node.startAdminServer(); err != nil {
+			return err
+		}
+	}
+	if node.config.ProbePort > 0 {
+		if err := node.startProbeServer(); err != nil {
+			return err
+		}
+	}
+	if node.config.PprofPort > 0 {
+		if err := node.startPprofServer(); err != nil {
+			return err
+		}
+	}
+	if node.config.Expvar {
+		node.publishExpvar()
 	}
 
-	go node.connectToExistingNodes()
+	node.routines.spawn(node.connectToExistingNodes)
 	if true == node.config.Join { //we are to join an existing cluster
 		if err := node.joinCluster(); err != nil {
 			return err
@@ -188,6 +481,11 @@ func (node *ClusteredBigCache) Start() error {
 	}
 
 	node.state = clusterStateStarted
+	if node.config.Discovery != nil {
+		if err := node.startDiscovery(); err != nil {
+			return err
+		}
+	}
 	time.Sleep(time.Millisecond * 200) //allow things to start up
 	return nil
 }
@@ -196,51 +494,233 @@ func (node *ClusteredBigCache) Start() error {
 func (node *ClusteredBigCache) ShutDown() {
 
 	node.state = clusterStateEnded
+	node.routines.stop()
+	if node.gossip != nil {
+		node.gossip.leave()
+	}
+	if node.coalescer != nil { //replicate pending coalesced writes before the replication channel goes away
+		node.coalescer.close()
+	}
+	node.flush(shutDownFlushTimeout)
+	node.hangUp(shutDownFlushTimeout)
+
+	node.cancel() //the remote nodes still connecting or verifying give up too
 	for _, v := range node.remoteNodes.Values() {
 		rn := v.(*remoteNode)
 		rn.config.ReconnectOnDisconnect = false
 		rn.tearDown()
 	}
 
-	close(node.joinQueue)
+	if node.throttle != nil {
+		node.throttle.close()
+	}
+	node.evictions.close()
+
+	if node.warmUp != nil {
+		node.warmUp.close()
+	}
+
+	if node.idle != nil {
+		node.idle.close()
+	}
+	node.statsPublisher.close()
+	node.bandwidth.close()
+	node.profiler.close()
+	node.retryQueue.close()
+
+	node.stopDiscovery()
+
 	close(node.getRequestChan)
-	close(node.replicationChan)
+	node.replicationMsgs.close()
+
+	node.closeListeners()
 
-	if node.serverEndpoint != nil {
-		node.serverEndpoint.Close()
+	if node.adminServer != nil {
+		node.adminServer.Close()
+	}
+	if node.probeServer != nil {
+		node.probeServer.Close()
+	}
+	if node.pprofServer != nil {
+		node.pprofServer.Close()
+	}
+	if node.config.Expvar {
+		node.unpublishExpvar()
+	}
+	//copying under-replicated keys stops at the next key now the node is no longer started
+	node.reReplicating.Wait()
+	if node.cache != nil { //passive clients have no local cache
+		if err := node.cache.Close(); err != nil {
+			utils.Error(node.logger, fmt.Sprintf("failed to close the local cache: %s", err.Error()))
+		}
 	}
 }
 
 //join an existing cluster
 func (node *ClusteredBigCache) joinCluster() error {
-	if "" == node.config.JoinIp {
+	seeds := node.seeds()
+	if len(seeds) == 0 {
 		utils.Critical(node.logger, "the server's IP to join can not be empty.")
 		return errors.New("the server's IP to join can not be empty since Join is true, there must be a JoinIP")
 	}
 
-	remoteNode := newRemoteNode(&remoteNodeConfig{IpAddress: node.config.JoinIp,
+	remoteNode := newRemoteNode(&remoteNodeConfig{IpAddress: seeds[0], Seeds: seeds,
 		ConnectRetries: node.config.ConnectRetries,
 		Sync:           true, ReconnectOnDisconnect: node.config.ReconnectOnDisconnect,
 		PingInterval:          node.config.PingInterval,
 		PingTimeout:           node.config.PingTimeout,
-		PingFailureThreshHold: node.config.PingFailureThreshHold},
+		PingFailureThreshHold: node.config.PingFailureThreshHold,
+		Connections:           node.config.ConnectionsPerNode,
+		ReadDeadline:          node.config.readDeadline(),
+		WriteDeadline:         node.config.writeDeadline(),
+		AdaptivePing:          node.config.AdaptivePing,
+		MaxPingInterval:       node.config.MaxPingInterval,
+		Retry:                 newRetryPolicy(node.config)},
 		node, node.logger)
 	remoteNode.join()
 	return nil
 }
 
+//every address the node can join the cluster through
+func (node *ClusteredBigCache) seeds() []string {
+	seeds := make([]string, 0, len(node.config.JoinIps)+1)
+	seen := make(map[string]bool)
+	for _, seed := range append([]string{node.config.JoinIp}, node.config.JoinIps...) {
+		if "" == seed || seen[seed] {
+			continue
+		}
+		seen[seed] = true
+		seeds = append(seeds, seed)
+	}
+	return seeds
+}
+
 //bring up this Cluster
 func (node *ClusteredBigCache) bringNodeUp() error {
 
 	var err error
 	utils.Info(node.logger, "bringing up node "+node.config.Id)
-	node.serverEndpoint, err = net.Listen("tcp", ":"+strconv.Itoa(node.config.LocalPort))
+	for _, address := range node.listenAddresses() {
+		if _, port, _ := net.SplitHostPort(address); port == "0" && node.config.LocalPort != 0 {
+			//the first listener picked the port, every other interface uses the same one
+			host, _, _ := net.SplitHostPort(address)
+			address = net.JoinHostPort(host, strconv.Itoa(node.config.LocalPort))
+		}
+
+		listener, err := node.listenOn("tcp", address)
+		if err != nil {
+			utils.Error(node.logger, fmt.Sprintf("unable to Listen on %s. [%s]", address, err.Error()))
+			node.closeListeners()
+			return err
+		}
+		node.serverEndpoints = append(node.serverEndpoints, listener)
+
+		if node.config.LocalPort == 0 {
+			node.config.LocalPort = listener.Addr().(*net.TCPAddr).Port
+		}
+		node.startListening(listener)
+	}
+
+	if "" != node.config.LocalSocket {
+		os.Remove(node.config.LocalSocket) //a previous run may have left the socket file behind
+		node.socketEndpoint, err = node.listenOn("unix", node.config.LocalSocket)
+		if err != nil {
+			utils.Error(node.logger, fmt.Sprintf("unable to Listen on socket %s. [%s]", node.config.LocalSocket, err.Error()))
+			node.closeListeners()
+			return err
+		}
+		node.startListening(node.socketEndpoint)
+	}
+
+	if node.config.WebSocketPort > 0 {
+		if err = node.startWebSocketServer(); err != nil {
+			node.closeListeners()
+			return err
+		}
+	}
+	return nil
+}
+
+//listen on the address of the network through the configured Transport, or the network stack
+func (node *ClusteredBigCache) listenOn(network, address string) (net.Listener, error) {
+	if node.config.Transport != nil {
+		return node.config.Transport.Listen(network, address)
+	}
+	return net.Listen(network, address)
+}
+
+//connect to the endpoint of a remote node through the configured Transport, or the network stack with the
+//hosts of the peers looked up through the dns cache
+func (node *ClusteredBigCache) connect(endpoint string) (*comms.Connection, error) {
+	var conn *comms.Connection
+	var err error
+	if node.config.Transport != nil {
+		conn, err = comms.NewTransportConnection(endpoint, time.Second*5, node.config.Transport)
+	} else {
+		conn, err = comms.NewResolvedConnection(endpoint, time.Second*5, node.dns)
+	}
+	if err == nil {
+		conn.SetInterceptor(node.config.FrameInterceptor)
+	}
+	return conn, err
+}
+
+//the tcp addresses to listen on. LocalAddresses without a port use LocalPort
+func (node *ClusteredBigCache) listenAddresses() []string {
+	port := strconv.Itoa(node.config.LocalPort)
+	if len(node.config.LocalAddresses) == 0 {
+		return []string{net.JoinHostPort("", port)}
+	}
+
+	addresses := make([]string, 0, len(node.config.LocalAddresses))
+	for _, address := range node.config.LocalAddresses {
+		if _, _, err := net.SplitHostPort(address); err == nil {
+			addresses = append(addresses, address)
+			continue
+		}
+		host := strings.TrimSuffix(strings.TrimPrefix(address, "["), "]") //"[::1]" and "::1" are the same host
+		addresses = append(addresses, net.JoinHostPort(host, port))
+	}
+	return addresses
+}
+
+//close every listener of this node
+func (node *ClusteredBigCache) closeListeners() {
+	for _, listener := range node.serverEndpoints {
+		listener.Close()
+	}
+
+	if node.socketEndpoint != nil {
+		node.socketEndpoint.Close()
+	}
+
+	if node.webSocketServer != nil {
+		node.webSocketServer.Close()
+	}
+}
+
+//accept remote nodes connecting through a websocket, for peers that can only reach this node via http
+func (node *ClusteredBigCache) startWebSocketServer() error {
+
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(node.config.WebSocketPort))
 	if err != nil {
-		utils.Error(node.logger, fmt.Sprintf("unable to Listen on port %d. [%s]", node.config.LocalPort, err.Error()))
+		utils.Error(node.logger, fmt.Sprintf("unable to start websocket server on port %d. [%s]", node.config.WebSocketPort, err.Error()))
 		return err
 	}
 
-	go node.listen()
+	node.webSocketServer = &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := comms.UpgradeWebSocket(w, req)
+		if err != nil {
+			utils.Warn(node.logger, fmt.Sprintf("rejected websocket connection from '%s' [%s]", req.RemoteAddr, err))
+			return
+		}
+		if !node.routines.spawn(func() { node.acceptConnection(conn, comms.WebSocketScheme+req.RemoteAddr) }) {
+			conn.Close()
+		}
+	})}
+
+	node.serve(node.webSocketServer, listener)
+	utils.Info(node.logger, fmt.Sprintf("websocket server listening on %s", listener.Addr().String()))
 	return nil
 }
 
@@ -248,6 +728,24 @@ func (node *ClusteredBigCache) bringNodeUp() error {
 func (node *ClusteredBigCache) eventRemoteNodeDisconneced(r *remoteNode) {
 
 	node.remoteNodes.Remove(r.config.Id)
+	node.gossip.suspect(r.config.Id)
+	node.checkQuorum()
+	node.watchers.remove(r.config.Id)
+	node.statsPublisher.unsubscribe(r.config.Id)
+	node.dropPeerStats(r.config.Id)
+	if node.mode == clusterModePASSIVE {
+		node.subscribeWatch(false)
+	}
+}
+
+//check if this node is connected to at least one active remote node
+func (node *ClusteredBigCache) hasActivePeers() bool {
+	for _, v := range node.getRemoteNodes() {
+		if v.(*remoteNode).mode == clusterModeACTIVE {
+			return true
+		}
+	}
+	return false
 }
 
 //util function to return all know remoteNodes
@@ -267,6 +765,7 @@ func (node *ClusteredBigCache) eventVerifyRemoteNode(remoteNode *remoteNode) boo
 	node.remoteNodes.Add(remoteNode.config.Id, remoteNode)
 	utils.Info(node.logger, fmt.Sprintf("added remote node '%s' into group", remoteNode.config.Id))
 	node.pendingConn.Delete(remoteNode.config.Id)
+	node.checkQuorum()
 
 	return true
 }
@@ -276,13 +775,24 @@ func (node *ClusteredBigCache) eventUnableToConnect(config *remoteNodeConfig) {
 	node.pendingConn.Delete(config.Id)
 }
 
+//start a listening loop, counted for the liveness probe
+func (node *ClusteredBigCache) startListening(listener net.Listener) {
+	atomic.AddInt32(&node.listeners, 1)
+	atomic.AddInt32(&node.listening, 1)
+	node.routines.spawn(func() { node.listen(listener) })
+}
+
 //listen for new connections to this node
-func (node *ClusteredBigCache) listen() {
+func (node *ClusteredBigCache) listen(listener net.Listener) {
 
-	utils.Info(node.logger, fmt.Sprintf("node '%s' is up and running", node.config.Id))
+	utils.Info(node.logger, fmt.Sprintf("node '%s' is up and running on %s", node.config.Id, listener.Addr().String()))
 	errCount := 0
 	for {
-		conn, err := node.serverEndpoint.Accept()
+		conn, err := listener.Accept()
+		if err != nil && node.ctx.Err() != nil { //closed by ShutDown
+			atomic.AddInt32(&node.listening, -1)
+			return
+		}
 		if err != nil {
 			utils.Error(node.logger, err.Error())
 			errCount++
@@ -293,33 +803,97 @@ func (node *ClusteredBigCache) listen() {
 		}
 		errCount = 0
 
-		//build a new remoteNode from this new connection
-		tcpConn := conn.(*net.TCPConn)
-		remoteNode := newRemoteNode(&remoteNodeConfig{IpAddress: tcpConn.RemoteAddr().String(),
-			ConnectRetries: node.config.ConnectRetries,
-			Sync:           false, ReconnectOnDisconnect: false,
-			PingInterval:          node.config.PingInterval,
-			PingTimeout:           node.config.PingTimeout,
-			PingFailureThreshHold: node.config.PingFailureThreshHold},
-			node, node.logger)
-		remoteNode.setState(nodeStateHandshake)
-		remoteNode.setConnection(comms.WrapConnection(tcpConn))
-		utils.Info(node.logger, fmt.Sprintf("new connection from remote '%s'", tcpConn.RemoteAddr().String()))
-		remoteNode.start()
+		remoteAddress := conn.RemoteAddr().String()
+		if _, ok := conn.(*net.UnixConn); ok { //so it is never mistaken for a tcp address that can be handed to other nodes
+			remoteAddress = comms.UnixScheme + remoteAddress
+		}
+		if !node.routines.spawn(func() { node.acceptConnection(conn, remoteAddress) }) {
+			conn.Close()
+		}
 	}
+	atomic.AddInt32(&node.listening, -1)
 	utils.Critical(node.logger, "listening loop terminated unexpectedly due to too many errors")
 	if node.config.TerminateOnListenerExit {
 		panic("listening loop terminated unexpectedly due to too many errors")
 	}
 }
 
+//look at the first message on a new connection. it is either the verification of a new remote node
+//or an extra connection for a remote node that is already verified
+func (node *ClusteredBigCache) acceptConnection(netConn net.Conn, remoteAddress string) {
+
+	conn := comms.WrapConnection(netConn)
+	conn.SetInterceptor(node.config.FrameInterceptor)
+	first, err := readFrame(conn, time.Second*5, node.maxFrameSize())
+	if err != nil {
+		utils.Warn(node.logger, fmt.Sprintf("no message received from new connection '%s' [%s]", remoteAddress, err))
+		conn.Close()
+		return
+	}
+
+	if first.Code == message.MsgATTACH {
+		attachMsg := message.AttachMessage{}
+		attachMsg.DeSerialize(first)
+		node.attachConnection(attachMsg.Id, attachMsg.Token, conn)
+		return
+	}
+
+	//build a new remoteNode from this new connection
+	remoteNode := newRemoteNode(&remoteNodeConfig{IpAddress: remoteAddress,
+		ConnectRetries: node.config.ConnectRetries,
+		Sync:           false, ReconnectOnDisconnect: false,
+		PingInterval:          node.config.PingInterval,
+		PingTimeout:           node.config.PingTimeout,
+		PingFailureThreshHold: node.config.PingFailureThreshHold,
+		ReadDeadline:          node.config.readDeadline(),
+		WriteDeadline:         node.config.writeDeadline(),
+		AdaptivePing:          node.config.AdaptivePing,
+		MaxPingInterval:       node.config.MaxPingInterval},
+		node, node.logger)
+	remoteNode.setState(nodeStateHandshake)
+	remoteNode.setConnection(conn)
+	utils.Info(node.logger, fmt.Sprintf("new connection from remote '%s'", remoteAddress))
+	remoteNode.start()
+	remoteNode.queueInboundMessage(first)
+}
+
+//hand an extra connection over to the verified remote node it belongs to, which it must prove with the lane token
+//issued to that remote node on its verified connection
+func (node *ClusteredBigCache) attachConnection(id, token string, conn *comms.Connection) {
+	value, ok := node.remoteNodes.Get(id)
+	if !ok {
+		utils.Warn(node.logger, fmt.Sprintf("extra connection for unknown remote node '%s', closing it", id))
+		conn.Close()
+		return
+	}
+
+	rn := value.(*remoteNode)
+	if rn.version() < message.ProtocolVersion2 {
+		utils.Warn(node.logger, fmt.Sprintf("remote node '%s' did not negotiate extra connections, closing it", id))
+		conn.Close()
+		return
+	}
+	if !rn.laneTokenMatches(token) {
+		utils.Warn(node.logger, fmt.Sprintf("extra connection for remote node '%s' without its lane token, closing it", id))
+		conn.Close()
+		return
+	}
+	rn.addLane(conn)
+}
+
 //this is a goroutine that takes details from a channel and connect to them if they are not known
 //when a remote system connects to this node or when this node connects to a remote system, it will query that system
 //for the list of its connected nodes and pushes that list into this channel so that this node can connect forming
 //a mesh network in the process
 func (node *ClusteredBigCache) connectToExistingNodes() {
 
-	for value := range node.joinQueue {
+	for {
+		var value *message.ProposedPeer
+		select {
+		case <-node.ctx.Done():
+			return
+		case value = <-node.joinQueue:
+		}
 
 		if node.state != clusterStateStarted {
 			continue
@@ -339,124 +913,363 @@ func (node *ClusteredBigCache) connectToExistingNodes() {
 		//we are here because we don't know this remote node
 		remoteNode := newRemoteNode(&remoteNodeConfig{IpAddress: value.IpAddress,
 			ConnectRetries: node.config.ConnectRetries,
-			Id:             value.Id, Sync: false, ReconnectOnDisconnect: node.config.ReconnectOnDisconnect}, node, node.logger)
+			Id:             value.Id, Sync: false, ReconnectOnDisconnect: node.config.ReconnectOnDisconnect,
+			Connections: node.config.ConnectionsPerNode, Retry: newRetryPolicy(node.config),
+			ReadDeadline: node.config.readDeadline(), WriteDeadline: node.config.writeDeadline(),
+			AdaptivePing: node.config.AdaptivePing, MaxPingInterval: node.config.MaxPingInterval}, node, node.logger)
 		remoteNode.join()
 		node.pendingConn.Store(value.Id, value.IpAddress)
 	}
 }
 
+//ask for a connection to a node learnt about through discovery or gossip. of two active nodes only the one
+//with the lower id dials, otherwise they could both end up with a connection the other refuses as a duplicate.
+//passive clients are never dialled so they always dial
+func (node *ClusteredBigCache) dial(id, address string, mode byte) {
+	if id == node.config.Id || mode == clusterModePASSIVE || (node.mode == clusterModeACTIVE && id < node.config.Id) {
+		return
+	}
+	node.proposePeer(&message.ProposedPeer{Id: id, IpAddress: address})
+}
+
+//ask for a new connection to a remote node whose connection was lost, after waiting delay so that the nodes
+//which lost it at the same time do not all reconnect at the same moment
+func (node *ClusteredBigCache) redial(id, address string, delay time.Duration) {
+	if !node.sleep(node.ctx, delay) || node.state != clusterStateStarted {
+		return
+	}
+	node.proposePeer(&message.ProposedPeer{Id: id, IpAddress: address})
+}
+
+//queue peer for connectToExistingNodes to connect to, unless the node is shutting down. the join queue is never
+//closed, connectToExistingNodes returns once the context of the node is done instead, so this is safe at any time
+func (node *ClusteredBigCache) proposePeer(peer *message.ProposedPeer) {
+	select {
+	case <-node.ctx.Done():
+	case node.joinQueue <- peer:
+	}
+}
+
+//the error a value larger than MaxValueSize is refused with, checked before writing it so passive clients,
+//which have no local cache to refuse it, do not send it to the cluster either
+func (node *ClusteredBigCache) checkValueSize(key string, data []byte) error {
+	if limit := node.config.MaxValueSize; limit > 0 && len(data) > limit {
+		return &bigcache.ValueTooLargeError{Key: key, Size: len(data), Limit: limit}
+	}
+	return nil
+}
+
 //Put adds data into the cluster
 func (node *ClusteredBigCache) Put(key string, data []byte, duration time.Duration) error {
+	defer node.profiler.observe("put", node.clock.Now())
+
+	requestId, expiryTime, stamp, err := node.putLocally(key, data, duration, time.Time{})
+	if err != nil {
+		return err
+	}
+	node.replicatePut(key, data, expiryTime, stamp, requestId)
+	return nil
+}
 
+//the part of a put done before it is replicated, storing it locally on active nodes. it expires at expireAt
+//unless it is zero, after duration otherwise. the correlation id of the put, its absolute expiry time and its
+//timestamp are returned
+func (node *ClusteredBigCache) putLocally(key string, data []byte, duration time.Duration, expireAt time.Time) (string, uint64, uint64, error) {
 	if node.state != clusterStateStarted {
-		return ErrNotStarted
+		return "", 0, 0, ErrNotStarted
+	}
+	if err := node.checkValueSize(key, data); err != nil {
+		return "", 0, 0, err
+	}
+	if err := node.admitWrite(); err != nil {
+		return "", 0, 0, err
+	}
+	if err := node.admitReplication(nil); err != nil {
+		return "", 0, 0, err
 	}
 
 	//store it locally first
+	requestId := node.newRequestId()
 	expiryTime := bigcache.NO_EXPIRY
+	var stamp uint64
 	if node.mode == clusterModeACTIVE {
+		if err := node.throttle.admit(); err != nil {
+			return "", 0, 0, err
+		}
+		unlock := node.versions.lockKey(key)
 		var err error
-		expiryTime, err = node.cache.Set(key, data, duration)
+		if expireAt.IsZero() {
+			expiryTime, err = node.cache.Set(key, data, duration)
+		} else {
+			expiryTime, err = node.cache.SetUntil(key, data, expireAt)
+		}
 		if err != nil {
-			return err
+			unlock()
+			logRequest(node.logger, requestId, fmt.Sprintf("put '%s' failed locally [%s]", key, err.Error()))
+			return "", 0, 0, err
 		}
+		stamp = node.versions.stamp(key, expiryTime)
+		unlock()
+		node.watchers.notify(key, false)
 	} else if node.mode == clusterModePASSIVE {
-		expiryTime = uint64(time.Now().Unix())
-		if duration != time.Duration(bigcache.NO_EXPIRY) {
-			expiryTime += uint64(duration.Seconds())
-		} else {
-			expiryTime = bigcache.NO_EXPIRY
+		if !expireAt.IsZero() {
+			if expireAt.Unix() <= node.clock.Now().Unix() {
+				return "", 0, 0, bigcache.ErrExpiryInPast
+			}
+			expiryTime = uint64(expireAt.Unix())
+		} else if duration != time.Duration(bigcache.NO_EXPIRY) {
+			expiryTime = uint64(node.clock.Now().Unix()) + uint64(duration.Seconds())
 		}
+		stamp = node.versions.next()
 	}
 
+	if node.coalescer != nil { //this write supersedes any coalesced write still pending
+		node.coalescer.discard(key)
+	}
+	node.evictions.discard(key)
+	node.bandwidth.wrote(len(key) + len(data))
+	return requestId, expiryTime, stamp, nil
+}
+
+//PutCoalesced adds data into the cluster like Put but replication is deferred by up to WriteCoalesceInterval
+//and only the latest value written within that interval is sent to remote nodes. local reads always see the
+//newest value. it is meant for keys rewritten many times per second such as counters or last known states.
+//when WriteCoalesceInterval is not set it behaves exactly like Put
+func (node *ClusteredBigCache) PutCoalesced(key string, data []byte, duration time.Duration) error {
+
+	if node.coalescer == nil {
+		return node.Put(key, data, duration)
+	}
+
+	_, expiryTime, stamp, err := node.putLocally(key, data, duration, time.Time{})
+	if err != nil {
+		return err
+	}
+	node.coalescer.put(key, data, expiryTime, stamp)
+	return nil
+}
+
+//PutUntil adds data into the cluster which expires at the given wall-clock time instead of after a duration
+func (node *ClusteredBigCache) PutUntil(key string, data []byte, expireAt time.Time) error {
+	defer node.profiler.observe("put", node.clock.Now())
+
+	requestId, expiryTime, stamp, err := node.putLocally(key, data, 0, expireAt)
+	if err != nil {
+		return err
+	}
+	node.replicatePut(key, data, expiryTime, stamp, requestId)
+	return nil
+}
+
+//send a put with an absolute expiry time to every active remote node. the correlation id requestId and the
+//timestamp stamp of the write are only sent to remote nodes that speak a protocol version carrying them
+func (node *ClusteredBigCache) replicatePut(key string, data []byte, expiryTime, stamp uint64, requestId string) {
+
 	//we are going to do full replication across the cluster
+	seq := node.changelog.record(key, data, expiryTime, stamp, false)
 	peers := node.remoteNodes.Values()
+	replicated := 0
 	for x := 0; x < len(peers); x++ { //just replicate serially from left to right
-		if peers[x].(*remoteNode).mode == clusterModePASSIVE {
+		peer := peers[x].(*remoteNode)
+		if peer.mode == clusterModePASSIVE {
 			continue
 		}
-		node.replicationChan <- &replicationMsg{r: peers[x].(*remoteNode),
-			m: &message.PutMessage{Key: key, Data: data, Expiry: expiryTime}}
+		msg := &message.PutMessage{Key: key, Data: data, Expiry: expiryTime}
+		if peer.version() >= message.MsgMinVersion(message.MsgPUTEx) {
+			msg.RequestId = requestId
+		}
+		if peer.version() >= message.MsgMinVersion(message.MsgPUTStamped) {
+			msg.Timestamp = stamp
+		}
+		node.queueReplication(&replicationMsg{r: peer, m: sequenced(msg, seq)})
+		replicated++
 	}
-
-	return nil
+	logRequest(node.logger, requestId, fmt.Sprintf("put '%s' replicating to %d remote nodes", key, replicated))
 }
 
-//Get retrieves data from the cluster
+//Get retrieves data from the cluster. on an active node a key cached as not found by PutNotFound fails with an
+//error matching bigcache.ErrCachedNotFound
 func (node *ClusteredBigCache) Get(key string, timeout time.Duration) ([]byte, error) {
+	defer node.profiler.observe("get", node.clock.Now())
 	if node.state != clusterStateStarted {
 		return nil, ErrNotStarted
 	}
 
+	answeredBy := ""
+	defer func(started time.Time) { node.slow.observeGet(key, answeredBy, started) }(node.clock.Now())
+	node.hotKeys.record(key)
+	requestId := node.newRequestId()
+
 	//if present locally then send it
+	var local []byte
+	hasLocal := false
 	if node.mode == clusterModeACTIVE {
 		data, err := node.cache.Get(key)
+		if errors.Is(err, bigcache.ErrCachedNotFound) { //the remote nodes are not asked again
+			logRequest(node.logger, requestId, fmt.Sprintf("get '%s' cached as not found", key))
+			return nil, err
+		}
+		node.warmUp.record(err == nil)
 		if err == nil {
-			return data, nil
+			if !node.config.DetectDivergence {
+				logRequest(node.logger, requestId, fmt.Sprintf("get '%s' served locally", key))
+				return data, nil
+			}
+			local, hasLocal = data, true
 		}
 	}
 
 	//we did not get the data locally so lets check the cluster
-	peers := node.getRemoteNodes()
+	started := node.clock.Now()
+	peers := node.activePeers()
 	if len(peers) < 1 {
-		return nil, ErrNotFound
+		if hasLocal {
+			logRequest(node.logger, requestId, fmt.Sprintf("get '%s' served locally", key))
+			return local, nil
+		}
+		var waited time.Duration
+		if peers, waited = node.waitForActivePeers(timeout); len(peers) < 1 {
+			atomic.AddUint64(&node.noPeers, 1)
+			logRequest(node.logger, requestId, fmt.Sprintf("get '%s' failed, no remote node to ask after %s", key, waited))
+			return nil, ErrNoPeers
+		}
+		timeout -= waited
 	}
+	logRequest(node.logger, requestId, fmt.Sprintf("get '%s' asking %d remote nodes", key, len(peers)))
 	replyC := make(chan *getReplyData)
-	reqData := &getRequestData{key: key, randStr: utils.GenerateNodeId(8),
-		replyChan: replyC, done: make(chan struct{})}
+	reqData := &getRequestData{key: key, randStr: utils.GenerateNodeId(8), requestId: requestId, started: started,
+		replyChan: replyC, done: make(chan struct{}),
+		expiry: (!hasLocal && node.warmUp.active()) || (node.config.DetectDivergence && node.config.ConflictResolver != nil)}
+	if node.config.DetectDivergence {
+		reqData.replies = make(chan *getReplyData, len(peers))
+	}
 
 	for _, peer := range peers {
-		if peer.(*remoteNode).mode == clusterModePASSIVE {
-			continue
+		node.getRequestChan <- &getRequestDataWrapper{r: peer, g: reqData}
+	}
+
+	if node.config.DetectDivergence {
+		node.routines.spawn(func() { node.compareReplies(key, local, hasLocal, reqData, len(peers), timeout) })
+		if hasLocal { //the local value is served straight away, the peers are only asked to compare with it
+			close(reqData.done)
+			return local, nil
 		}
-		node.getRequestChan <- &getRequestDataWrapper{r: peer.(*remoteNode), g: reqData}
 	}
 
+	timer := node.clock.NewTimer(timeout)
+	defer timer.Stop()
 	var replyData *getReplyData
 	select {
 	case replyData = <-replyC:
-	case <-time.After(timeout):
-		return nil, ErrTimedOut
+	case <-timer.C():
+		logRequest(node.logger, requestId, fmt.Sprintf("get '%s' timed out after %s", key, bigcache.Since(node.clock, started)))
+		return nil, ErrTimeout
 	}
 
 	close(reqData.done)
+	answeredBy = replyData.peer
+	logRequest(node.logger, requestId, fmt.Sprintf("get '%s' answered by '%s' after %s", key, replyData.peer, bigcache.Since(node.clock, started)))
+	if replyData.withExpiry && node.warmUp.active() { //keep it so the next read of it is served locally
+		node.warmUp.store(key, replyData.data, replyData.expiry)
+	}
 	return replyData.data, nil
 }
 
 //Delete removes a key from the cluster
 func (node *ClusteredBigCache) Delete(key string) error {
+	defer node.profiler.observe("delete", node.clock.Now())
 
 	if node.state != clusterStateStarted {
 		return ErrNotStarted
 	}
+	if err := node.admitWrite(); err != nil {
+		return err
+	}
 
 	//delete locally
+	var stamp uint64
 	if node.mode == clusterModeACTIVE {
+		unlock := node.versions.lockKey(key)
 		node.cache.Delete(key)
+		stamp = node.versions.delete(key)
+		unlock()
+		node.watchers.notify(key, true)
+	} else {
+		stamp = node.versions.next()
+	}
+
+	if node.coalescer != nil { //a pending coalesced write must not bring the key back
+		node.coalescer.discard(key)
 	}
+	node.bandwidth.wrote(len(key))
+	node.replicateDelete(key, stamp)
+	return nil
+}
 
+//send the delete of key to the remote nodes, stamped with the timestamp of the delete when the last write wins
+func (node *ClusteredBigCache) replicateDelete(key string, stamp uint64) {
+	seq := node.changelog.record(key, nil, 0, stamp, true)
 	peers := node.remoteNodes.Values()
 	//just send the delete message to everyone
 	for x := 0; x < len(peers); x++ {
 		if peers[x].(*remoteNode).mode == clusterModePASSIVE {
 			continue
 		}
-		node.replicationChan <- &replicationMsg{r: peers[x].(*remoteNode), m: &message.DeleteMessage{Key: key}}
+		node.queueReplication(&replicationMsg{r: peers[x].(*remoteNode), m: sequenced(&message.DeleteMessage{Key: key, Timestamp: stamp}, seq)})
 	}
+}
 
-	return nil
+//MemoryStats is an estimate of the memory held by a node, in bytes
+type MemoryStats struct {
+	Cache    bigcache.MemoryUsage `json:"cache"`    //the local cache, empty for passive clients
+	Channels int                  `json:"channels"` //buffers of the queues of the node and of its remote nodes
+	Total    int                  `json:"total"`
+}
+
+//MemoryUsage estimates the memory held by the node: its local cache, including what indexes and expires the
+//entries, and the buffers of the queues messages wait in, a pair of which is allocated per remote node
+func (node *ClusteredBigCache) MemoryUsage() MemoryStats {
+	var m MemoryStats
+	if node.mode == clusterModeACTIVE {
+		m.Cache = node.cache.MemoryUsage()
+	}
+
+	m.Channels = node.replicationMsgs.cap()*int(unsafe.Sizeof(&replicationMsg{})) +
+		cap(node.getRequestChan)*int(unsafe.Sizeof(&getRequestDataWrapper{})) +
+		cap(node.joinQueue)*int(unsafe.Sizeof(&message.ProposedPeer{}))
+	for _, v := range node.getRemoteNodes() {
+		r := v.(*remoteNode)
+		m.Channels += r.inboundMsgQueue.memory() +
+			(cap(r.outboundMsgQueue)+cap(r.controlMsgQueue))*int(unsafe.Sizeof(message.NodeMessage(nil)))
+		r.lanesLock.RLock()
+		for _, lane := range r.lanes { //each extra connection queues frames of its own
+			m.Channels += cap(lane.queue) * int(unsafe.Sizeof([]byte(nil)))
+		}
+		r.lanesLock.RUnlock()
+	}
+	m.Total = m.Cache.Total + m.Channels
+	return m
 }
 
-//Statistics returns the cache stats
+//Statistics returns the statistics of StatisticsStruct formatted for people to read
 func (node *ClusteredBigCache) Statistics() string {
 	if node.mode == clusterModeACTIVE {
-		stats := node.cache.Stats()
-		return fmt.Sprintf("%q", stats)
+		return node.StatisticsStruct().String()
 	}
 
 	return "No stats for passive mode"
 }
 
+//Compact moves the entries of the local cache over the holes left by deleted ones for at most maxDuration or
+//until ctx is done, so operators can schedule it for quiet periods. Returns the bytes reclaimed and whether
+//holes remain. passive clients hold no data so there is nothing to compact
+func (node *ClusteredBigCache) Compact(ctx context.Context, maxDuration time.Duration) (int, bool) {
+	if node.mode != clusterModeACTIVE {
+		return 0, false
+	}
+	return node.cache.Compact(ctx, maxDuration)
+}
+
 //a goroutine to send get request to members of the cluster
 func (node *ClusteredBigCache) requestSenderForGET() {
 	for value := range node.getRequestChan {
@@ -464,9 +1277,26 @@ func (node *ClusteredBigCache) requestSenderForGET() {
 	}
 }
 
-//a goroutine used to replicate messages across the cluster
-func (node *ClusteredBigCache) replication() {
-	for msg := range node.replicationChan {
-		msg.r.sendMessage(msg.m)
+//a goroutine used to replicate messages across the cluster, those queued on its channel
+func (node *ClusteredBigCache) replication(replicationChan chan *replicationMsg) {
+	for msg := range replicationChan {
+		if node.retryQueue != nil {
+			node.retryQueue.send(msg)
+		} else {
+			msg.r.sendMessage(msg.m)
+		}
+		atomic.AddInt64(&msg.r.unsent, -1) //counted by the outbound queue or the retry queue from now on
 	}
 }
+
+//queue a write to be replicated to its remote node, counted as unsent by the remote node until it is sent
+func (node *ClusteredBigCache) queueReplication(msg *replicationMsg) {
+	atomic.AddInt64(&msg.r.unsent, 1)
+	defer func() {
+		if e := recover(); e != nil { //the replication channel was closed on shut down
+			atomic.AddInt64(&msg.r.unsent, -1)
+			panic(e)
+		}
+	}()
+	node.replicationMsgs.of(msg.r) <- msg
+}
@@ -1,9 +1,31 @@
 package cluster
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/nggenius/ngbigcache/bigcache"
+	"github.com/nggenius/ngbigcache/comms"
+	"github.com/nggenius/ngbigcache/discovery"
+	"github.com/nggenius/ngbigcache/message"
 	"github.com/nggenius/ngbigcache/utils"
 )
 
@@ -105,6 +127,123 @@ func TestPutData(t *testing.T) {
 	node2.ShutDown()
 }
 
+func TestPutUntil(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1988, ConnectRetries: 0}, nil)
+	node2 := New(&ClusteredBigCacheConfig{Join: true, LocalPort: 1997, JoinIp: "localhost:1988", ConnectRetries: 2}, nil)
+
+	node1.Start()
+	node2.Start()
+
+	if err := node1.PutUntil("key_1", []byte("data_1"), time.Now().Add(-time.Minute)); err == nil {
+		t.Error("an expiry time in the past ought to be rejected")
+	}
+
+	expireAt := time.Now().Add(time.Minute)
+	node1.PutUntil("key_1", []byte("data_1"), expireAt)
+	time.Sleep(time.Millisecond * 200)
+	result, err := node2.Get("key_1", time.Millisecond*200)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(result) != "data_1" {
+		t.Error("data placed in node1 not the same gotten from node2")
+	}
+
+	node1.ShutDown()
+	node2.ShutDown()
+}
+
+func TestMultipleConnections(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1987, ConnectRetries: 0}, nil)
+	node2 := New(&ClusteredBigCacheConfig{Join: true, LocalPort: 1996, JoinIp: "localhost:1987", ConnectRetries: 2,
+		ConnectionsPerNode: 3}, nil)
+
+	node1.Start()
+	node2.Start()
+	time.Sleep(time.Millisecond * 1500)
+
+	for _, v := range node1.remoteNodes.Values() {
+		if lanes := len(v.(*remoteNode).lanes); lanes != 2 {
+			t.Errorf("remote node ought to have 2 extra connections, it has %d", lanes)
+		}
+	}
+
+	for x := 0; x < 20; x++ {
+		node2.Put("key_"+strconv.Itoa(x), []byte("data_"+strconv.Itoa(x)), time.Minute)
+	}
+	time.Sleep(time.Millisecond * 200)
+	for x := 0; x < 20; x++ {
+		result, err := node1.Get("key_"+strconv.Itoa(x), time.Millisecond*200)
+		if err != nil || string(result) != "data_"+strconv.Itoa(x) {
+			t.Error("data placed in node2 not the same gotten from node1")
+		}
+	}
+
+	//an extra connection claiming to belong to node2 without its lane token
+	conn, err := net.Dial("tcp", "localhost:1987")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write(buildFrame((&message.AttachMessage{Id: node2.config.Id, Token: "forged"}).Serialize()))
+	time.Sleep(time.Millisecond * 200)
+	for _, v := range node1.remoteNodes.Values() {
+		if lanes := len(v.(*remoteNode).lanes); lanes != 2 {
+			t.Errorf("an extra connection without the lane token ought to be refused, remote node has %d", lanes)
+		}
+	}
+
+	//a large put spread over a lane other than the one of the delete that follows it would let the delete overtake it
+	large := make([]byte, 256*1024)
+	for x := 0; x < 20; x++ {
+		node2.Put("ordered_"+strconv.Itoa(x), large, time.Minute)
+		node2.Delete("ordered_" + strconv.Itoa(x))
+		node2.Put("reput_"+strconv.Itoa(x), large, time.Minute)
+		node2.Delete("reput_" + strconv.Itoa(x))
+		node2.Put("reput_"+strconv.Itoa(x), []byte("data_"+strconv.Itoa(x)), time.Minute)
+	}
+	time.Sleep(time.Millisecond * 500)
+	for x := 0; x < 20; x++ {
+		if _, err := node1.cache.Get("ordered_" + strconv.Itoa(x)); err == nil {
+			t.Errorf("the delete of 'ordered_%d' ought not to be overtaken by its put", x)
+		}
+		if result, err := node1.cache.Get("reput_" + strconv.Itoa(x)); err != nil || string(result) != "data_"+strconv.Itoa(x) {
+			t.Errorf("the writes of 'reput_%d' ought to be applied in order, got '%s' %v", x, result, err)
+		}
+	}
+
+	node1.ShutDown()
+	node2.ShutDown()
+}
+
+func TestPutCoalesced(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1986, ConnectRetries: 0}, nil)
+	node2 := New(&ClusteredBigCacheConfig{Join: true, LocalPort: 1995, JoinIp: "localhost:1986", ConnectRetries: 2,
+		WriteCoalesceInterval: 300}, nil)
+
+	node1.Start()
+	node2.Start()
+	time.Sleep(time.Millisecond * 200)
+
+	for x := 0; x < 100; x++ {
+		node2.PutCoalesced("counter", []byte(strconv.Itoa(x)), time.Minute)
+	}
+
+	if result, _ := node2.Get("counter", time.Millisecond*200); string(result) != "99" {
+		t.Error("local reads ought to see the newest value straight away")
+	}
+
+	time.Sleep(time.Millisecond * 500)
+	result, err := node1.Get("counter", time.Millisecond*200)
+	if err != nil || string(result) != "99" {
+		t.Error("only the latest coalesced value ought to have been replicated")
+	}
+
+	node1.ShutDown()
+	node2.ShutDown()
+}
+
 func TestPutDataWithPassiveClient(t *testing.T) {
 	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1979, ConnectRetries: 0}, nil)
 	node2 := NewPassiveClient("testMachine", "localhost:1979", 1898, 5, 3, 10, nil)
@@ -222,6 +361,2791 @@ func TestClusteredBigCache_Statistics(t *testing.T) {
 		t.Error("passive client node does not have statistics info")
 	}
 
+	node1.Put("key_1", []byte("data_1"), time.Minute)
+	node1.Get("key_1", time.Millisecond*100)
+	stats := node1.StatisticsStruct()
+	if stats.Cache == nil || stats.Cache.Entries != 1 || stats.Cache.Hits != 1 || stats.Peers == nil {
+		t.Errorf("unexpected statistics %+v", stats)
+	}
+	if !strings.Contains(node1.Statistics(), "1 entries") {
+		t.Errorf("expected the statistics formatted, got %s", node1.Statistics())
+	}
+	if stats = node2.StatisticsStruct(); !stats.Passive || stats.Cache != nil {
+		t.Errorf("expected no cache statistics for a passive client, got %+v", stats)
+	}
+
+	node1.ShutDown()
+	node2.ShutDown()
+}
+
+func TestAdminServer(t *testing.T) {
+	node := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1189, ConnectRetries: 0, DebugMode: true, DebugPort: 1190}, nil)
+	if err := node.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer node.ShutDown()
+
+	node.Put("key_1", []byte("data_1"), time.Minute)
+	node.Get("key_1", time.Millisecond*200)
+
+	rsp, err := http.Get("http://localhost:1190/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+
+	stats := adminStats{}
+	if err := json.NewDecoder(rsp.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Entries != 1 || stats.Hits != 1 || len(stats.TopKeys) != 1 {
+		t.Errorf("unexpected stats from admin server %+v", stats)
+	}
+
+	rsp, err = http.Get("http://localhost:1190/dashboard")
+	if err != nil || rsp.StatusCode != http.StatusOK {
+		t.Error("dashboard ought to be served")
+	}
+
+	//only on the loopback address by default, leaving the port free on the others
+	if listener, err := net.Listen("tcp", "127.0.0.2:1190"); err != nil {
+		t.Errorf("expected the admin server listening on 127.0.0.1 alone, got %v", err)
+	} else {
+		listener.Close()
+	}
+
+	changeFactor := func(token string) int {
+		req, _ := http.NewRequest(http.MethodPost, "http://localhost:1190/replication-factor?factor=1", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rsp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsp.Body.Close()
+		return rsp.StatusCode
+	}
+	if status := changeFactor("secret"); status != http.StatusForbidden {
+		t.Errorf("expected changes refused without an admin token configured, got %d", status)
+	}
+	node.config.AdminToken = "secret"
+	if status := changeFactor("wrong"); status != http.StatusUnauthorized {
+		t.Errorf("expected a change with the wrong token refused, got %d", status)
+	}
+	if status := changeFactor("secret"); status != http.StatusOK || node.config.ReplicationFactor != 1 {
+		t.Errorf("expected a change with the token made, got %d and factor %d", status, node.config.ReplicationFactor)
+	}
+
+	for _, path := range []string{"/stats", "/healthz", "/debug/vars", "/replication-factor"} {
+		req, _ := http.NewRequest(http.MethodDelete, "http://localhost:1190"+path, nil)
+		rsp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsp.Body.Close()
+		if rsp.StatusCode != http.StatusMethodNotAllowed {
+			t.Errorf("expected %s refusing DELETE, got %d", path, rsp.StatusCode)
+		}
+	}
+}
+
+func TestUnixSocketTransport(t *testing.T) {
+	socket := filepath.Join(os.TempDir(), "ngbigcache_test.sock")
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1169, LocalSocket: socket, ConnectRetries: 0}, nil)
+	node2 := NewPassiveClient("testMachine", "unix://"+socket, 1170, 5, 3, 10, nil)
+
+	if err := node1.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer node1.ShutDown()
+	if err := node2.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer node2.ShutDown()
+
+	node2.Put("key_1", []byte("data_1"), time.Minute*1)
+	time.Sleep(time.Millisecond * 200)
+	result, err := node1.Get("key_1", time.Millisecond*200)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(result) != "data_1" {
+		t.Error("data placed in node2 over the unix socket not the same gotten from node1")
+	}
+
+	node1.Put("key_2", []byte("data_2"), time.Minute*1)
+	time.Sleep(time.Millisecond * 200)
+	result, err = node2.Get("key_2", time.Millisecond*200)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(result) != "data_2" {
+		t.Error("data placed in node1 not the same gotten from node2 over the unix socket")
+	}
+}
+
+func TestDetectDivergence(t *testing.T) {
+	events := make(chan Event, 4)
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1149, ConnectRetries: 0,
+		DetectDivergence: true, DivergenceWindow: 2, DivergenceThreshold: 0.5,
+		OnEvent: func(e Event) { events <- e }}, nil)
+	node2 := New(&ClusteredBigCacheConfig{Join: true, LocalPort: 1150, JoinIp: "localhost:1149", ConnectRetries: 2}, nil)
+
+	node1.Start()
+	defer node1.ShutDown()
+	node2.Start()
+	defer node2.ShutDown()
+
+	node1.Put("user:1", []byte("data_1"), time.Minute*1)
+	time.Sleep(time.Millisecond * 200)
+	node2.cache.Set("user:1", []byte("stale"), 0) //behind the cluster's back
+
+	for x := 0; x < 2; x++ {
+		result, err := node1.Get("user:1", time.Millisecond*200)
+		if err != nil || string(result) != "data_1" {
+			t.Error("the local value ought to be served")
+		}
+		time.Sleep(time.Millisecond * 300)
+	}
+
+	select {
+	case e := <-events:
+		if e.Type != EventDivergenceAlarm {
+			t.Errorf("expected a divergence alarm, got %v", e.Type)
+		}
+	case <-time.After(time.Second):
+		t.Error("divergence alarm not raised")
+	}
+
+	stats := node1.DivergenceStats()
+	if stats.ByPeer[node2.config.Id].Divergent != 2 || stats.ByPrefix["user"].Divergent != 2 {
+		t.Errorf("unexpected divergence stats %+v", stats)
+	}
+}
+
+func TestWebSocketTransport(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1139, WebSocketPort: 1140, ConnectRetries: 0}, nil)
+	node2 := NewPassiveClient("testMachine", "ws://localhost:1140/ngbigcache", 1141, 5, 3, 10, nil)
+
+	if err := node1.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer node1.ShutDown()
+	if err := node2.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer node2.ShutDown()
+
+	node2.Put("key_1", []byte("data_1"), time.Minute*1)
+	time.Sleep(time.Millisecond * 200)
+	result, err := node1.Get("key_1", time.Millisecond*200)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(result) != "data_1" {
+		t.Error("data placed in node2 over the websocket not the same gotten from node1")
+	}
+
+	data := make([]byte, 70000) //large enough to need the 64 bit websocket frame length
+	data[len(data)-1] = 'x'
+	node1.Put("key_2", data, time.Minute*1)
+	time.Sleep(time.Millisecond * 200)
+	result, err = node2.Get("key_2", time.Millisecond*200)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(result) != len(data) || result[len(result)-1] != 'x' {
+		t.Error("data placed in node1 not the same gotten from node2 over the websocket")
+	}
+}
+
+func TestAdvertisedAddress(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Id: "node1", Join: false, LocalPort: 1129, ConnectRetries: 0}, nil)
+	node2 := New(&ClusteredBigCacheConfig{Id: "node2", Join: true, LocalPort: 1128, JoinIp: "localhost:1129", ConnectRetries: 2,
+		AdvertisedHost: "127.0.0.1", AdvertisedPort: 1127}, nil)
+
+	node1.Start()
+	defer node1.ShutDown()
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 200)
+
+	value, ok := node1.remoteNodes.Get("node2")
+	if !ok {
+		t.Fatal("node2 ought to be connected to node1")
+	}
+	rn := value.(*remoteNode)
+	if rn.config.AdvertisedHost != "127.0.0.1" || rn.config.ServicePort != "1127" {
+		t.Errorf("node1 ought to know node2 by its advertised address, got %s:%s", rn.config.AdvertisedHost, rn.config.ServicePort)
+	}
+}
+
+func TestMultipleListenAddresses(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 0, LocalAddresses: []string{"127.0.0.1", "[::1]"}, ConnectRetries: 0, ShardSize: 16}, nil)
+	if err := node1.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer node1.ShutDown()
+
+	port := strconv.Itoa(node1.LocalPort())
+	node2 := NewPassiveClient("testMachine_1", "127.0.0.1:"+port, 1117, 5, 3, 10, nil)
+	node3 := NewPassiveClient("testMachine_2", "[::1]:"+port, 1118, 5, 3, 10, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	node3.Start()
+	defer node3.ShutDown()
+	time.Sleep(time.Millisecond * 200)
+
+	if len(node1.getRemoteNodes()) != 2 {
+		t.Error("passive clients ought to connect over both IPv4 and IPv6")
+	}
+
+	if _, err := net.DialTimeout("tcp", "127.0.0.2:"+port, time.Second); err == nil {
+		t.Error("node ought not to listen on interfaces outside LocalAddresses")
+	}
+}
+
+func TestJoinSeedFailover(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1109, ConnectRetries: 0}, nil)
+	node1.Start()
+
+	//the first seed is down so the client has to fail over to the second one
+	client := NewPassiveClient("testMachine", "localhost:1107, localhost:1109", 1106, 1, 1, 2, nil)
+	client.Start()
+	defer client.ShutDown()
+	time.Sleep(time.Millisecond * 500)
+
+	if !client.hasActivePeers() {
+		t.Fatal("client ought to join through the seed that is up")
+	}
+
+	node2 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1107, ConnectRetries: 0}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	peers := node1.getRemoteNodes()
 	node1.ShutDown()
+	for _, peer := range peers { //make sure the connections go down with the node
+		peer.(*remoteNode).shutDown()
+	}
+	time.Sleep(time.Second * 2)
+
+	if !client.hasActivePeers() {
+		t.Fatal("client ought to fail over to the other seed once its node goes down")
+	}
+	client.Put("key_1", []byte("data_1"), time.Minute)
+	time.Sleep(time.Millisecond * 200)
+	if result, _ := node2.Get("key_1", time.Millisecond*200); string(result) != "data_1" {
+		t.Error("data placed in the client ought to reach the node it failed over to")
+	}
+}
+
+func TestPrimeOnJoin(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1099, ConnectRetries: 0}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+
+	for x := 0; x < 10; x++ {
+		node1.Put("key_"+strconv.Itoa(x), []byte("data_"+strconv.Itoa(x)), time.Minute)
+	}
+	for x := 0; x < 3; x++ {
+		for y := 0; y <= x; y++ {
+			node1.Get("key_"+strconv.Itoa(x), time.Millisecond*100)
+		}
+	}
+
+	//room for the two most read keys only
+	node2 := New(&ClusteredBigCacheConfig{Join: true, JoinIp: "localhost:1099", LocalPort: 1098, ConnectRetries: 2, PrimeBudget: 24}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Second * 2)
+
+	for _, key := range []string{"key_2", "key_1"} {
+		if _, err := node2.cache.Get(key); err != nil {
+			t.Errorf("'%s' ought to be primed from the node joined through", key)
+		}
+	}
+	for _, key := range []string{"key_0", "key_5"} {
+		if _, err := node2.cache.Get(key); err == nil {
+			t.Errorf("'%s' ought not to fit in the prime budget", key)
+		}
+	}
+}
+
+func TestAuditKey(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1089, ConnectRetries: 0, DebugMode: true, DebugPort: 1087}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+
+	node2 := New(&ClusteredBigCacheConfig{Join: true, JoinIp: "localhost:1089", LocalPort: 1088, ConnectRetries: 2}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 500)
+
+	node1.Put("key_1", []byte("data_1"), time.Minute)
+	time.Sleep(time.Millisecond * 200)
+
+	audit, err := node1.AuditKey("key_1", time.Millisecond*500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(audit.Copies) != 2 || !audit.ReplicaCountOK || !audit.Consistent || audit.Copies[1].Size != 6 || audit.Copies[1].TTL < 1 {
+		t.Errorf("key ought to be replicated to both nodes %+v", audit)
+	}
+
+	node2.cache.Delete("key_1")
+	rsp, err := http.Get("http://localhost:1087/audit-key?key=key_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+
+	audit = &KeyAudit{}
+	if err := json.NewDecoder(rsp.Body).Decode(audit); err != nil {
+		t.Fatal(err)
+	}
+	if audit.Replicas != 1 || audit.ExpectedReplicas != 2 || audit.ReplicaCountOK {
+		t.Errorf("audit ought to report the missing replica %+v", audit)
+	}
+}
+
+//discovery backend shared by the nodes of a test, in memory
+type memDiscovery struct {
+	lock     sync.Mutex
+	peers    map[string]discovery.Peer
+	watchers []chan []discovery.Peer
+}
+
+func (m *memDiscovery) list() []discovery.Peer {
+	list := make([]discovery.Peer, 0, len(m.peers))
+	for _, peer := range m.peers {
+		list = append(list, peer)
+	}
+	return list
+}
+
+func (m *memDiscovery) notify() {
+	for _, w := range m.watchers {
+		w <- m.list()
+	}
+}
+
+func (m *memDiscovery) Register(self discovery.Peer) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.peers[self.Id] = self
+	m.notify()
+	return nil
+}
+
+func (m *memDiscovery) Deregister(self discovery.Peer) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.peers, self.Id)
+	m.notify()
+	return nil
+}
+
+func (m *memDiscovery) Watch(done <-chan struct{}) (<-chan []discovery.Peer, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	w := make(chan []discovery.Peer, 16)
+	w <- m.list()
+	m.watchers = append(m.watchers, w)
+	return w, nil
+}
+
+func TestDiscovery(t *testing.T) {
+	disc := &memDiscovery{peers: make(map[string]discovery.Peer)}
+	node1 := New(&ClusteredBigCacheConfig{Id: "node_1", LocalAddresses: []string{"127.0.0.1"}, LocalPort: 1069, ConnectRetries: 2, Discovery: disc}, nil)
+	if err := node1.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer node1.ShutDown()
+
+	node2 := New(&ClusteredBigCacheConfig{Id: "node_2", LocalAddresses: []string{"127.0.0.1"}, LocalPort: 1068, ConnectRetries: 2, Discovery: disc}, nil)
+	if err := node2.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 500)
+
+	if node1.remoteNodes.Size() != 1 || node2.remoteNodes.Size() != 1 {
+		t.Fatal("nodes ought to find each other through discovery")
+	}
+	node2.Put("key_1", []byte("data_1"), time.Minute)
+	time.Sleep(time.Millisecond * 200)
+	if result, _ := node1.Get("key_1", time.Millisecond*200); string(result) != "data_1" {
+		t.Error("data ought to be replicated between nodes that found each other through discovery")
+	}
+}
+
+func TestGossipMembership(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Id: "node_1", LocalPort: 1049, ConnectRetries: 2, Gossip: true, GossipInterval: 100}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+
+	node2 := New(&ClusteredBigCacheConfig{Id: "node_2", Join: true, JoinIp: "localhost:1049", LocalPort: 1048, ConnectRetries: 2,
+		Gossip: true, GossipInterval: 100}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+
+	node3 := New(&ClusteredBigCacheConfig{Id: "node_3", Join: true, JoinIp: "localhost:1049", LocalPort: 1047, ConnectRetries: 2,
+		Gossip: true, GossipInterval: 100}, nil)
+	node3.Start()
+	time.Sleep(time.Second)
+
+	for _, node := range []*ClusteredBigCache{node1, node2, node3} {
+		members := node.Members()
+		if len(members) != 3 {
+			t.Fatalf("'%s' ought to know every member %+v", node.config.Id, members)
+		}
+		for _, m := range members {
+			if m.State != MEMBER_STATE_ALIVE {
+				t.Errorf("'%s' ought to see '%s' as alive", node.config.Id, m.Id)
+			}
+		}
+	}
+
+	node3.ShutDown()
+	time.Sleep(time.Millisecond * 300)
+	for _, m := range node2.Members() {
+		if m.Id == "node_3" && m.State != MEMBER_STATE_DEAD {
+			t.Error("member that left ought to be seen as dead")
+		}
+	}
+}
+
+func TestMinimumClusterSize(t *testing.T) {
+	var lock sync.Mutex
+	events := make([]EventType, 0)
+	node1 := New(&ClusteredBigCacheConfig{LocalPort: 1039, ConnectRetries: 2, MinimumClusterSize: 2,
+		OnEvent: func(e Event) {
+			lock.Lock()
+			events = append(events, e.Type)
+			lock.Unlock()
+		}}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+
+	if err := node1.Put("key_1", []byte("data_1"), time.Minute); err != ErrNoQuorum {
+		t.Error("writes ought to be refused without quorum")
+	}
+
+	node2 := New(&ClusteredBigCacheConfig{Join: true, JoinIp: "localhost:1039", LocalPort: 1038, ConnectRetries: 2}, nil)
+	node2.Start()
+	time.Sleep(time.Millisecond * 300)
+	if err := node1.Put("key_1", []byte("data_1"), time.Minute); err != nil || !node1.HasQuorum() {
+		t.Error("writes ought to be accepted once quorum is reached")
+	}
+
+	peers := node1.getRemoteNodes()
 	node2.ShutDown()
+	for _, peer := range peers { //make sure the connections go down with the node
+		peer.(*remoteNode).shutDown()
+	}
+	time.Sleep(time.Millisecond * 300)
+	if err := node1.Delete("key_1"); err != ErrNoQuorum {
+		t.Error("writes ought to be refused once quorum is lost")
+	}
+	if result, _ := node1.Get("key_1", time.Millisecond*100); string(result) != "data_1" {
+		t.Error("reads ought to be served without quorum")
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(events) != 2 || events[0] != EventQuorumRegained || events[1] != EventQuorumLost {
+		t.Errorf("unexpected quorum events %v", events)
+	}
+}
+
+func TestLeave(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1029, ConnectRetries: 2, ReconnectOnDisconnect: true}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+
+	node2 := New(&ClusteredBigCacheConfig{Join: true, JoinIp: "localhost:1029", LocalPort: 1028, ConnectRetries: 2}, nil)
+	node2.Start()
+	time.Sleep(time.Millisecond * 300)
+
+	node1.cache.Set("key_1", []byte("data_node1"), time.Minute)
+	for x := 1; x <= 10; x++ { //only held by the node leaving
+		node2.cache.Set("key_"+strconv.Itoa(x), []byte("data_"+strconv.Itoa(x)), time.Minute)
+	}
+
+	peers := node1.getRemoteNodes()
+	if err := node2.Leave(time.Second * 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := node2.Put("key_11", []byte("data_11"), time.Minute); err != ErrNotStarted {
+		t.Error("a node that left ought to refuse writes")
+	}
+	for _, peer := range peers {
+		if peer.(*remoteNode).config.ReconnectOnDisconnect {
+			t.Error("a node that left ought not to be reconnected to")
+		}
+		peer.(*remoteNode).shutDown()
+	}
+
+	for x := 2; x <= 10; x++ {
+		if result, _ := node1.cache.Get("key_" + strconv.Itoa(x)); string(result) != "data_"+strconv.Itoa(x) {
+			t.Errorf("key_%d ought to be handed over", x)
+		}
+	}
+	if result, _ := node1.cache.Get("key_1"); string(result) != "data_node1" {
+		t.Error("a key already held ought not to be overwritten by the one handed over")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1019, ConnectRetries: 0}, nil)
+	client := NewPassiveClient("testMachine", "localhost:1019", 1018, 5, 3, 10, nil)
+	node1.Start()
+	client.Start()
+	defer node1.ShutDown()
+	defer client.ShutDown()
+	time.Sleep(time.Millisecond * 200)
+
+	if err := node1.Watch([]string{"session:"}, nil); err != ErrNotPassive {
+		t.Error("only passive clients ought to be able to watch keys")
+	}
+
+	var lock sync.Mutex
+	invalidated := make([]string, 0)
+	err := client.Watch([]string{"session:", "user:"}, func(key string, deleted bool) {
+		lock.Lock()
+		defer lock.Unlock()
+		invalidated = append(invalidated, key+":"+strconv.FormatBool(deleted))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 200)
+
+	node1.Put("session:1", []byte("data_1"), time.Minute)
+	node1.Put("bulk:1", []byte("data_2"), time.Minute)
+	node1.Delete("session:1")
+	client.Put("user:1", []byte("data_3"), time.Minute)
+	time.Sleep(time.Millisecond * 300)
+
+	lock.Lock()
+	if strings.Join(invalidated, ",") != "session:1:false,session:1:true,user:1:false" {
+		t.Errorf("unexpected invalidations %v", invalidated)
+	}
+	lock.Unlock()
+
+	client.Watch(nil, nil)
+	time.Sleep(time.Millisecond * 200)
+	node1.watchers.lock.RLock()
+	defer node1.watchers.lock.RUnlock()
+	if len(node1.watchers.watchers) != 0 {
+		t.Error("watching without prefixes ought to end the subscription")
+	}
+}
+
+func TestWarmUp(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1009, ConnectRetries: 0}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+	node1.cache.SetUntil("key_1", []byte("data_1"), time.Now().Add(time.Minute))
+	_, expiry, _ := node1.cache.GetWithExpiry("key_1")
+
+	node2 := New(&ClusteredBigCacheConfig{Join: true, JoinIp: "localhost:1009", LocalPort: 1008, ConnectRetries: 2, WarmUpPeriod: 60}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 300)
+
+	if result, err := node2.Get("key_1", time.Millisecond*200); err != nil || string(result) != "data_1" {
+		t.Fatal("a local miss ought to be answered by the remote node")
+	}
+	data, localExpiry, err := node2.cache.GetWithExpiry("key_1")
+	if err != nil || string(data) != "data_1" || localExpiry != expiry {
+		t.Error("a key read from a remote node while warming up ought to be stored with its original expiry")
+	}
+	node2.Get("key_1", time.Millisecond*200)
+
+	stats := node2.WarmUpStats()
+	if stats == nil || !stats.Warming || stats.Hits != 1 || stats.Misses != 1 || stats.Fetched != 1 {
+		t.Errorf("unexpected warm up stats %+v", stats)
+	}
+	if node1.WarmUpStats() != nil {
+		t.Error("a node not warming up ought not to have warm up stats")
+	}
+}
+
+func TestNoPeers(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1007, ConnectRetries: 0}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+
+	start := time.Now()
+	if _, err := node1.Get("key_1", time.Second); err != ErrNoPeers || time.Since(start) > time.Millisecond*100 {
+		t.Error("a read without remote nodes ought to fail fast")
+	}
+	if node1.adminStats().NoPeers != 1 {
+		t.Error("reads without remote nodes ought to be counted")
+	}
+
+	node1.config.NoPeersMode = NO_PEERS_MODE_WAIT
+	node2 := New(&ClusteredBigCacheConfig{Join: true, JoinIp: "localhost:1007", LocalPort: 1006, ConnectRetries: 2}, nil)
+	node2.cache.Set("key_1", []byte("data_1"), time.Minute)
+	go func() {
+		time.Sleep(time.Millisecond * 200)
+		node2.Start()
+	}()
+	defer node2.ShutDown()
+
+	if result, err := node1.Get("key_1", time.Second*3); err != nil || string(result) != "data_1" {
+		t.Errorf("a read ought to wait for a remote node to connect, got %v", err)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1003, ConnectRetries: 0}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+
+	node2 := New(&ClusteredBigCacheConfig{Join: true, JoinIp: "localhost:1003", LocalPort: 1002, ConnectRetries: 2}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 300)
+
+	node1.Put("key_1", []byte("data_1"), time.Minute)
+	time.Sleep(time.Millisecond * 100)
+	node2.SetReadOnly(true)
+	if err := node2.Put("key_2", []byte("data_2"), time.Minute); err != ErrReadOnly {
+		t.Error("a read only node ought to reject writes")
+	}
+
+	node1.Put("key_3", []byte("data_3"), time.Minute)
+	node1.Delete("key_1")
+	time.Sleep(time.Millisecond * 200)
+	if _, err := node2.cache.Get("key_3"); err == nil {
+		t.Error("a read only node ought not to apply replicated writes")
+	}
+	if result, err := node2.Get("key_1", time.Millisecond*100); err != nil || string(result) != "data_1" {
+		t.Error("a read only node ought to still serve reads")
+	}
+	for _, peer := range node1.getRemoteNodes() {
+		if refused := atomic.LoadUint64(&peer.(*remoteNode).metrics.refused); refused != 2 {
+			t.Errorf("the refused writes ought to be reported back, got %d", refused)
+		}
+	}
+
+	node2.SetReadOnly(false)
+	if err := node2.Put("key_2", []byte("data_2"), time.Minute); err != nil {
+		t.Error("writes ought to be accepted once no longer read only")
+	}
+}
+
+func TestReadOnlyRole(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1001, ConnectRetries: 0}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+
+	node2 := New(&ClusteredBigCacheConfig{Join: true, JoinIp: "localhost:1001", LocalPort: 1000, ConnectRetries: 2,
+		Role: PEER_ROLE_READ_ONLY}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 300)
+
+	if err := node2.Put("key_1", []byte("data_1"), time.Minute); err != ErrReadOnly {
+		t.Error("a node with the read only role ought to reject writes")
+	}
+	node1.Put("key_2", []byte("data_2"), time.Minute)
+	time.Sleep(time.Millisecond * 100)
+	if result, err := node2.Get("key_2", time.Millisecond*100); err != nil || string(result) != "data_2" {
+		t.Error("a node with the read only role ought to still receive writes and serve reads")
+	}
+
+	//writes sent anyway are refused by the peer
+	for _, peer := range node2.getRemoteNodes() {
+		peer.(*remoteNode).sendMessage(&message.PutMessage{Key: "key_3", Data: []byte("data_3")})
+		peer.(*remoteNode).sendMessage(&message.DeleteMessage{Key: "key_2"})
+	}
+	time.Sleep(time.Millisecond * 200)
+	if _, err := node1.cache.Get("key_3"); err == nil {
+		t.Error("a put from a read only peer ought not to be applied")
+	}
+	if _, err := node1.cache.Get("key_2"); err != nil {
+		t.Error("a delete from a read only peer ought not to be applied")
+	}
+	if drops := node1.roleDrops(); drops["read_only"] != 2 || drops["read_write"] != 0 {
+		t.Errorf("expected 2 writes dropped for the read only role, got %v", drops)
+	}
+	for _, peer := range node2.getRemoteNodes() {
+		if refused := atomic.LoadUint64(&peer.(*remoteNode).metrics.refused); refused != 2 {
+			t.Errorf("the refused writes ought to be reported back, got %d", refused)
+		}
+	}
+}
+
+func TestTTL(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1994, ConnectRetries: 0}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+
+	client := NewPassiveClient("ttl_client", "localhost:1994", 1993, 5, 3, 10, nil)
+	client.Start()
+	defer client.ShutDown()
+	time.Sleep(time.Millisecond * 300)
+
+	node1.Put("key_1", []byte("data_1"), time.Minute)
+	node1.Put("key_2", []byte("data_2"), time.Duration(bigcache.NO_EXPIRY))
+
+	if ttl, err := node1.TTL("key_1", time.Millisecond*100); err != nil || ttl <= 58*time.Second || ttl > time.Minute {
+		t.Errorf("expected about a minute left locally, got %s: %v", ttl, err)
+	}
+	if ttl, err := client.TTL("key_1", time.Millisecond*200); err != nil || ttl <= 58*time.Second || ttl > time.Minute {
+		t.Errorf("expected about a minute left from the remote node, got %s: %v", ttl, err)
+	}
+	if ttl, err := client.TTL("key_2", time.Millisecond*200); err != nil || ttl != time.Duration(bigcache.NO_EXPIRY) {
+		t.Errorf("expected no expiry from the remote node, got %s: %v", ttl, err)
+	}
+	if _, err := client.TTL("key_3", time.Millisecond*200); err != ErrNotFound {
+		t.Errorf("expected a missing key not to be found, got %v", err)
+	}
+}
+
+func TestConflictResolver(t *testing.T) {
+	union := func(key string, local, remote Entry) Entry { //values are sets of letters
+		merged := []byte{}
+		for c := byte('a'); c <= 'z'; c++ {
+			if bytes.IndexByte(local.Data, c) >= 0 || bytes.IndexByte(remote.Data, c) >= 0 {
+				merged = append(merged, c)
+			}
+		}
+		return Entry{Data: merged, Expiry: local.Expiry}
+	}
+
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1992, ConnectRetries: 0, ConflictResolver: union}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+
+	node2 := New(&ClusteredBigCacheConfig{Join: true, JoinIp: "localhost:1992", LocalPort: 1991, ConnectRetries: 2,
+		ConflictResolver: union}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 300)
+
+	node2.cache.Set("key_1", []byte("bc"), 0) //written while the nodes could not see each other
+	node1.Put("key_1", []byte("ab"), 0)
+	time.Sleep(time.Millisecond * 200)
+
+	for _, node := range []*ClusteredBigCache{node1, node2} {
+		if data, err := node.cache.Get("key_1"); err != nil || string(data) != "abc" {
+			t.Errorf("expected the conflicting writes to be merged, got %q: %v", data, err)
+		}
+	}
+}
+
+func TestWithKeyLock(t *testing.T) {
+	node := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1990, ConnectRetries: 0}, nil)
+	node.Start()
+	defer node.ShutDown()
+
+	node.Put("counter", []byte("0"), 0)
+	wg := sync.WaitGroup{}
+	for x := 0; x < 50; x++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			node.WithKeyLock("counter", func() {
+				data, _ := node.Get("counter", time.Millisecond*100)
+				count, _ := strconv.Atoi(string(data))
+				node.Put("counter", []byte(strconv.Itoa(count+1)), 0)
+			})
+		}()
+	}
+	wg.Wait()
+
+	if data, _ := node.Get("counter", time.Millisecond*100); string(data) != "50" {
+		t.Errorf("expected every increment to be kept, got %s", data)
+	}
+}
+
+func TestMemoryUsage(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1985, ConnectRetries: 0}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+
+	alone := node1.MemoryUsage()
+	node2 := New(&ClusteredBigCacheConfig{Join: true, JoinIp: "localhost:1985", LocalPort: 1984, ConnectRetries: 2}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 300)
+
+	node1.Put("key_1", []byte("data_1"), time.Minute)
+	usage := node1.MemoryUsage()
+	if usage.Cache.Total <= 0 || usage.Total != usage.Cache.Total+usage.Channels {
+		t.Errorf("the memory of the cache ought to be accounted for, got %+v", usage)
+	}
+	if usage.Channels <= alone.Channels {
+		t.Error("the queues of a remote node ought to be accounted for")
+	}
+}
+
+func TestAppendReadRange(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1983, ConnectRetries: 0}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+
+	node2 := New(&ClusteredBigCacheConfig{Join: true, JoinIp: "localhost:1983", LocalPort: 1982, ConnectRetries: 2}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+
+	client := NewPassiveClient("list_client", "localhost:1983", 1981, 5, 3, 10, nil)
+	client.Start()
+	defer client.ShutDown()
+	time.Sleep(time.Millisecond * 300)
+
+	//the appends of a single writer, made back to back, are applied in the same order by every node
+	for _, items := range []string{"ab", "cd", "ef", "gh"} {
+		if err := client.Append("recent", [][]byte{[]byte(items[:1]), []byte(items[1:])}, 3, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Millisecond * 200)
+
+	for _, node := range []*ClusteredBigCache{node1, node2} {
+		data, _ := node.cache.Get("recent")
+		if items, err := bigcache.ListRange(data, 0, -1); err != nil ||
+			string(bytes.Join(items, nil)) != "fgh" {
+			t.Errorf("expected the appends to be replicated in order, got %q: %v", items, err)
+		}
+	}
+	if items, err := client.ReadRange("recent", -2, -1, time.Millisecond*200); err != nil ||
+		string(bytes.Join(items, nil)) != "gh" {
+		t.Errorf("expected the last two items from the remote node, got %q: %v", items, err)
+	}
+}
+
+func TestSetType(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1980, ConnectRetries: 0}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+
+	node2 := New(&ClusteredBigCacheConfig{Join: true, JoinIp: "localhost:1980", LocalPort: 1978, ConnectRetries: 2}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 300)
+
+	node1.SAdd("seen", [][]byte{[]byte("id_1"), []byte("id_2")}, time.Minute)
+	node2.SAdd("seen", [][]byte{[]byte("id_3")}, time.Minute)
+	time.Sleep(time.Millisecond * 200)
+
+	for _, node := range []*ClusteredBigCache{node1, node2} {
+		if members, err := node.SMembers("seen", time.Millisecond*200); err != nil || len(members) != 3 {
+			t.Errorf("expected the members added on both nodes, got %q: %v", members, err)
+		}
+		if found, err := node.SIsMember("seen", []byte("id_3"), time.Millisecond*200); err != nil || !found {
+			t.Errorf("expected id_3 to be a member: %v", err)
+		}
+	}
+}
+
+//keeps every line logged, for checking what was logged
+type recordingLogger struct {
+	lock  sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) record(msg string) {
+	l.lock.Lock()
+	l.lines = append(l.lines, msg)
+	l.lock.Unlock()
+}
+
+func (l *recordingLogger) Info(msg string)     { l.record(msg) }
+func (l *recordingLogger) Warn(msg string)     { l.record(msg) }
+func (l *recordingLogger) Critical(msg string) { l.record(msg) }
+func (l *recordingLogger) Error(msg string)    { l.record(msg) }
+
+//the request ids of the lines logged about key
+func (l *recordingLogger) requestIds(key string) map[string]bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	ids := make(map[string]bool)
+	for _, line := range l.lines {
+		if strings.HasPrefix(line, "request ") && strings.Contains(line, "'"+key+"'") {
+			ids[strings.TrimSuffix(strings.Fields(line)[1], ":")] = true
+		}
+	}
+	return ids
+}
+
+func TestRequestCorrelation(t *testing.T) {
+	logger1, logger2 := &recordingLogger{}, &recordingLogger{}
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1976, ConnectRetries: 0, LogRequests: true}, logger1)
+	node1.Start()
+	defer node1.ShutDown()
+
+	node2 := New(&ClusteredBigCacheConfig{Join: true, JoinIp: "localhost:1976", LocalPort: 1975, ConnectRetries: 2}, logger2)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 300)
+
+	node1.Put("key_1", []byte("data_1"), time.Minute)
+	time.Sleep(time.Millisecond * 200)
+	node2.cache.Set("key_2", []byte("data_2"), time.Minute)
+	if _, err := node1.Get("key_2", time.Millisecond*200); err != nil {
+		t.Fatalf("expected key_2 from the remote node: %v", err)
+	}
+
+	for _, key := range []string{"key_1", "key_2"} {
+		local, remote := logger1.requestIds(key), logger2.requestIds(key)
+		if len(local) != 1 || len(remote) != 1 {
+			t.Fatalf("expected a single request id for %s on each node, got %v and %v", key, local, remote)
+		}
+		for id := range local {
+			if !remote[id] {
+				t.Errorf("expected the remote node to log request %s for %s, got %v", id, key, remote)
+			}
+		}
+	}
+
+	node2.Put("key_3", []byte("data_3"), time.Minute)
+	time.Sleep(time.Millisecond * 200)
+	if len(logger1.requestIds("key_3"))+len(logger2.requestIds("key_3")) != 0 {
+		t.Error("requests ought not to be logged without LogRequests")
+	}
+}
+
+func TestPeerResolution(t *testing.T) {
+	var lock sync.Mutex
+	address := "127.0.0.1"
+	resolver := func(host string) ([]string, time.Duration, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if host != "peer.cache.test" {
+			return nil, 0, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+		return []string{address}, 0, nil //looked up again every time
+	}
+
+	events := make(chan Event, 8)
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1974, ConnectRetries: 0}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+
+	node2 := New(&ClusteredBigCacheConfig{Join: true, JoinIp: "peer.cache.test:1974", LocalPort: 1973, ConnectRetries: 2,
+		Resolver: resolver, OnEvent: func(e Event) { events <- e }}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 300)
+
+	if len(node2.getRemoteNodes()) != 1 {
+		t.Fatal("expected the node to join through the peer addressed by hostname")
+	}
+	if node2.DNSStats().Lookups == 0 {
+		t.Error("expected the hostname of the peer to be looked up")
+	}
+
+	lock.Lock()
+	address = "127.0.0.2"
+	lock.Unlock()
+	if addrs, err := node2.dns.Resolve("peer.cache.test:1974"); err != nil || len(addrs) != 1 || addrs[0] != "127.0.0.2:1974" {
+		t.Fatalf("expected the peer to be looked up again once its ttl ran out, got %v: %v", addrs, err)
+	}
+	if node2.DNSStats().Changes != 1 {
+		t.Errorf("expected the change of address to be counted, got %+v", node2.DNSStats())
+	}
+	select {
+	case e := <-events:
+		if e.Type != EventPeerAddressChanged {
+			t.Errorf("expected %s, got %s", EventPeerAddressChanged, e.Type)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected an event for the change of address")
+	}
+}
+
+func TestPassiveIdleTimeout(t *testing.T) {
+	node := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1972, ConnectRetries: 0, PassiveIdleTimeout: 1}, nil)
+	node.Start()
+	defer node.ShutDown()
+
+	client := NewPassiveClient("idleClient", "localhost:1972", 1971, 5, 3, 10, nil)
+	client.Start()
+	defer client.ShutDown()
+	time.Sleep(time.Millisecond * 300)
+
+	if err := client.Put("key_1", []byte("data_1"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 2000)
+
+	if len(node.getRemoteNodes()) != 0 || len(client.getRemoteNodes()) != 0 {
+		t.Fatal("expected the connection of the idle passive client to be closed")
+	}
+	if closed := node.adminStats().IdleClosed; closed != 1 {
+		t.Errorf("expected a passive client closed for being idle, got %d", closed)
+	}
+
+	data, err := client.Get("key_1", time.Second*3)
+	if err != nil || string(data) != "data_1" {
+		t.Fatalf("expected the client to dial again on demand, got %q: %v", data, err)
+	}
+	if err := client.Put("key_2", []byte("data_2"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 200)
+	if data, _ := node.cache.Get("key_2"); string(data) != "data_2" {
+		t.Error("expected writes from the client to reach the active node once it dialled again")
+	}
+}
+
+func TestAppendBytes(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1970, ConnectRetries: 0}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+
+	node2 := New(&ClusteredBigCacheConfig{Join: true, JoinIp: "localhost:1970", LocalPort: 1969, ConnectRetries: 2}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 300)
+
+	node1.Put("log", []byte("a"), time.Minute)
+	time.Sleep(time.Millisecond * 100)
+	node2.AppendBytes("log", []byte("b"), 0)
+	time.Sleep(time.Millisecond * 100)
+	node1.AppendBytes("log", []byte("c"), 0)
+	time.Sleep(time.Millisecond * 200)
+
+	for _, node := range []*ClusteredBigCache{node1, node2} {
+		if data, err := node.cache.Get("log"); err != nil || string(data) != "abc" {
+			t.Errorf("expected the appends to be replicated, got %q: %v", data, err)
+		}
+		if ttl, err := node.cache.TTL("log"); err != nil || ttl <= 58*time.Second {
+			t.Errorf("expected appending to keep the expiry of the entry, got %s: %v", ttl, err)
+		}
+	}
+}
+
+func TestSetIfAbsent(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1965, ConnectRetries: 0}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+
+	node2 := New(&ClusteredBigCacheConfig{Join: true, JoinIp: "localhost:1965", LocalPort: 1964, ConnectRetries: 2}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 300)
+
+	var wg sync.WaitGroup
+	var won int32
+	for x := 0; x < 20; x++ {
+		for _, node := range []*ClusteredBigCache{node1, node2} {
+			wg.Add(1)
+			go func(node *ClusteredBigCache, x int) {
+				defer wg.Done()
+				stored, err := node.SetIfAbsent("lock_"+strconv.Itoa(x%4), []byte(node.config.Id), time.Minute, time.Second)
+				if err != nil {
+					t.Errorf("expected set if absent to be answered, got %v", err)
+				}
+				if stored {
+					atomic.AddInt32(&won, 1)
+				}
+			}(node, x)
+		}
+	}
+	wg.Wait()
+	time.Sleep(time.Millisecond * 200)
+
+	if won != 4 {
+		t.Errorf("expected exactly one caller per key to store it, %d did", won)
+	}
+	for x := 0; x < 4; x++ {
+		data1, _ := node1.cache.Get("lock_" + strconv.Itoa(x))
+		data2, _ := node2.cache.Get("lock_" + strconv.Itoa(x))
+		if len(data1) == 0 || !bytes.Equal(data1, data2) {
+			t.Errorf("expected both nodes to hold the value stored, got %q and %q", data1, data2)
+		}
+	}
+
+	if stored, err := node2.SetIfAbsent("lock_0", []byte("late"), 0, time.Second); stored || err != nil {
+		t.Errorf("expected a key already held not to be stored again, got %v: %v", stored, err)
+	}
+}
+
+func TestSubscribeStats(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1963, ConnectRetries: 0}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+
+	node2 := New(&ClusteredBigCacheConfig{Join: true, JoinIp: "localhost:1963", LocalPort: 1962, ConnectRetries: 2}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 300)
+
+	node1.Put("key_1", []byte("value_1"), 0)
+	var pushed int32
+	node2.SubscribeStats(time.Millisecond*100, func(stats PeerStats) {
+		atomic.AddInt32(&pushed, 1)
+	})
+	time.Sleep(time.Millisecond * 450)
+
+	stats := node2.PeerStats()
+	if len(stats) != 1 || stats[0].Id != node1.config.Id || atomic.LoadInt32(&pushed) < 2 {
+		t.Fatalf("expected the stats of node1 to be pushed every interval, got %d pushes of %v", pushed, stats)
+	}
+	var pushedStats adminStats
+	if err := json.Unmarshal(stats[0].Stats, &pushedStats); err != nil || pushedStats.Entries != 1 {
+		t.Errorf("expected the admin stats of node1, got %s: %v", stats[0].Stats, err)
+	}
+	if subscribers := node1.adminStats().StatsSubscribers; subscribers != 1 {
+		t.Errorf("expected node1 to push its stats to one remote node, got %d", subscribers)
+	}
+
+	other, _ := node2.remoteNodes.Get(node1.config.Id)
+	other.(*remoteNode).config.ReconnectOnDisconnect = false
+	value, _ := node1.remoteNodes.Get(node2.config.Id)
+	value.(*remoteNode).config.ReconnectOnDisconnect = false
+	value.(*remoteNode).shutDown()
+	time.Sleep(time.Millisecond * 300)
+	if subscribers := node1.adminStats().StatsSubscribers; subscribers != 0 {
+		t.Errorf("expected node1 to stop pushing its stats once node2 disconnected, got %d subscribers", subscribers)
+	}
+	if stats := node2.PeerStats(); len(stats) != 0 {
+		t.Errorf("expected node2 to forget the stats of node1 once disconnected, got %v", stats)
+	}
+}
+
+func TestMaxValueSize(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1961, ConnectRetries: 0}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+
+	node2 := New(&ClusteredBigCacheConfig{Join: true, JoinIp: "localhost:1961", LocalPort: 1960, ConnectRetries: 2, MaxValueSize: 8}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 300)
+
+	err := node2.Put("key_1", []byte("more than eight"), 0)
+	if _, ok := err.(*bigcache.ValueTooLargeError); !ok {
+		t.Errorf("expected a value over the limit to be refused, got %v", err)
+	}
+
+	node1.Put("key_2", []byte("more than eight"), 0)
+	time.Sleep(time.Millisecond * 200)
+	if _, err := node2.cache.Get("key_2"); err == nil {
+		t.Error("expected a replicated value over the limit not to be stored")
+	}
+	value, _ := node1.remoteNodes.Get(node2.config.Id)
+	if refused := atomic.LoadUint64(&value.(*remoteNode).metrics.refused); refused != 1 {
+		t.Errorf("expected the writer to be told its put was refused, got %d refusals", refused)
+	}
+}
+
+func TestMinReplicatedTTL(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1959, ConnectRetries: 0}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+
+	node2 := New(&ClusteredBigCacheConfig{Join: true, JoinIp: "localhost:1959", LocalPort: 1958, ConnectRetries: 2, MinReplicatedTTL: 30}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 300)
+
+	value, _ := node1.remoteNodes.Get(node2.config.Id)
+	r := value.(*remoteNode)
+	now := uint64(time.Now().Unix())
+	r.sendMessage(&message.PutMessage{Key: "expired", Data: []byte("data"), Expiry: now - 5})
+	r.sendMessage(&message.PutMessage{Key: "late", Data: []byte("data"), Expiry: now + 1})
+	r.sendMessage(&message.PutMessage{Key: "timely", Data: []byte("data"), Expiry: now + 60})
+	time.Sleep(time.Millisecond * 200)
+
+	if _, err := node2.cache.Get("expired"); err == nil {
+		t.Error("expected a put arriving after its expiry to be dropped")
+	}
+	if ttl, err := node2.cache.TTL("late"); err != nil || ttl < 25*time.Second {
+		t.Errorf("expected a put arriving with little time left to be kept for MinReplicatedTTL, got %s: %v", ttl, err)
+	}
+	if ttl, err := node2.cache.TTL("timely"); err != nil || ttl < 55*time.Second {
+		t.Errorf("expected a put arriving in time to keep its expiry, got %s: %v", ttl, err)
+	}
+	if stats := node2.adminStats(); stats.ExpiredReplicas != 1 || stats.ClampedTTLs != 1 {
+		t.Errorf("expected both late puts to be counted, got %d expired and %d clamped", stats.ExpiredReplicas, stats.ClampedTTLs)
+	}
+}
+
+func TestSetShardSize(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1957, ConnectRetries: 0}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+
+	for x := 0; x < 500; x++ {
+		node1.Put("key_"+strconv.Itoa(x), []byte("data_"+strconv.Itoa(x)), time.Minute)
+	}
+
+	if err := node1.SetShardSize(48); err == nil {
+		t.Error("expected a number of shards that is not a power of two to be refused")
+	}
+	if err := node1.SetShardSize(64); err != nil {
+		t.Fatal(err)
+	}
+	if stats := node1.adminStats(); stats.Shards != 64 || stats.Reshard != nil || stats.Entries != 500 {
+		t.Errorf("expected the 500 entries to be spread over 64 shards, got %d over %d", stats.Entries, stats.Shards)
+	}
+	for x := 0; x < 500; x++ {
+		if data, err := node1.Get("key_"+strconv.Itoa(x), time.Millisecond*100); err != nil || string(data) != "data_"+strconv.Itoa(x) {
+			t.Fatalf("expected key_%d to be kept, got %q: %v", x, data, err)
+		}
+	}
+	if err := node1.DoubleShardSize(); err != nil || node1.cache.Shards() != 128 || node1.cache.Len() != 500 {
+		t.Errorf("expected the 500 entries to be spread over 128 shards, got %d over %d: %v", node1.cache.Len(), node1.cache.Shards(), err)
+	}
+}
+
+func TestBandwidth(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1956, ConnectRetries: 0}, nil)
+	node2 := New(&ClusteredBigCacheConfig{Join: true, LocalPort: 1955, JoinIp: "localhost:1956", ConnectRetries: 2}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 500)
+
+	for x := 0; x < 100; x++ {
+		node1.Put("key_"+strconv.Itoa(x), []byte("data_"+strconv.Itoa(x)), time.Minute)
+	}
+	time.Sleep(time.Millisecond * 500)
+	node1.bandwidth.sample() //rather than waiting for the 10 seconds of the interval
+	node2.bandwidth.sample()
+
+	stats := node1.Bandwidth()
+	if stats.Written == 0 || stats.Sent <= stats.Written {
+		t.Errorf("expected the writes and more bytes sent to replicate them, got %+v", stats)
+	}
+	if stats.Amplification <= 2 || stats.Estimated != 2 {
+		t.Errorf("expected the framing to add to the 2 copies of every write, got %+v", stats)
+	}
+	if received := node2.Bandwidth().Received; received != stats.Sent {
+		t.Errorf("expected the %d bytes sent to be received, got %d", stats.Sent, received)
+	}
+}
+
+func TestAutoProfiler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1954, ConnectRetries: 0, ProfileDir: dir,
+		ProfileLatency: 20, ProfileDuration: 100, NoPeersMode: NO_PEERS_MODE_WAIT}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+
+	node1.Put("key_1", []byte("data_1"), time.Minute)
+	if stats := node1.Profiles(); stats.Skipped != 0 || !stats.Last.IsZero() {
+		t.Errorf("expected a fast put not to be profiled, got %+v", stats)
+	}
+
+	//with no remote node to ask a miss waits for one for the whole timeout
+	node1.Get("missing", time.Millisecond*50)
+	node1.Get("missing", time.Millisecond*50)
+	time.Sleep(time.Millisecond * 500)
+
+	stats := node1.Profiles()
+	if stats.Captured != 1 || stats.Skipped != 1 || stats.Error != "" {
+		t.Fatalf("expected the first slow get to be profiled and the second skipped, got %+v", stats)
+	}
+	if len(stats.Files) != 2 {
+		t.Fatalf("expected a cpu and a heap profile, got %v", stats.Files)
+	}
+	for _, file := range stats.Files {
+		if info, err := os.Stat(file); err != nil || info.Size() == 0 {
+			t.Errorf("expected %s to be saved: %v", file, err)
+		}
+	}
+}
+
+func TestMultipleInstances(t *testing.T) {
+	logger1, logger2 := &recordingLogger{}, &recordingLogger{}
+	config := func(instance string, port int, joinPort int) *ClusteredBigCacheConfig {
+		config := DefaultClusterConfig()
+		config.Instance, config.LocalPort, config.ConnectRetries = instance, port, 2
+		config.MaxFrameSize = 1024
+		if joinPort != 0 {
+			config.Join, config.JoinIp = true, "localhost:"+strconv.Itoa(joinPort)
+		}
+		return config
+	}
+
+	//two clusters of two nodes each, in the same process
+	hot1, hot2 := New(config("hot", 1940, 0), logger1), New(config("hot", 1941, 1940), logger1)
+	cold1, cold2 := New(config("cold", 1942, 0), logger2), New(config("cold", 1943, 1942), logger2)
+	for _, node := range []*ClusteredBigCache{hot1, hot2, cold1, cold2} {
+		if err := node.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer node.ShutDown()
+	}
+	time.Sleep(time.Millisecond * 300)
+
+	hot1.Put("key_1", []byte("hot_1"), time.Minute)
+	cold1.Put("key_1", []byte("cold_1"), time.Minute)
+	time.Sleep(time.Millisecond * 200)
+	if data, err := hot2.Get("key_1", time.Millisecond*200); err != nil || string(data) != "hot_1" {
+		t.Errorf("expected key_1 replicated within the hot cluster only, got %q: %v", data, err)
+	}
+	if data, err := cold2.Get("key_1", time.Millisecond*200); err != nil || string(data) != "cold_1" {
+		t.Errorf("expected key_1 replicated within the cold cluster only, got %q: %v", data, err)
+	}
+	if hot1.remoteNodes.Size() != 1 || cold1.remoteNodes.Size() != 1 {
+		t.Error("expected each node to only know the other node of its cluster")
+	}
+
+	//frames over MaxFrameSize close the connection of the instance refusing them, not the other cluster's
+	hot1.Put("key_2", make([]byte, 2048), time.Minute)
+	time.Sleep(time.Millisecond * 300)
+	if _, err := hot2.cache.Get("key_2"); err == nil {
+		t.Error("expected a value over MaxFrameSize not to be replicated")
+	}
+	if cold1.remoteNodes.Size() != 1 {
+		t.Error("expected the cold cluster not to be disturbed by the hot one")
+	}
+
+	for _, logger := range []*recordingLogger{logger1, logger2} {
+		logger.lock.Lock()
+		for _, line := range logger.lines {
+			if !strings.HasPrefix(line, "[hot] ") && !strings.HasPrefix(line, "[cold] ") {
+				t.Errorf("expected every line logged to name its instance, got %q", line)
+				break
+			}
+		}
+		logger.lock.Unlock()
+	}
+	if stats := cold2.adminStats(); stats.Instance != "cold" {
+		t.Errorf("expected the stats to name the instance, got %q", stats.Instance)
+	}
+}
+
+func TestCacheConfig(t *testing.T) {
+	configured := false
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1939, ConnectRetries: 0, ShardSize: 32,
+		MaxEntrySize: 100, MaxEntriesInWindow: 32 * 1000, HardMaxCacheSize: 64, QuietCache: true,
+		ConfigureCache: func(cfg *bigcache.Config) {
+			configured = cfg.Shards == 32 && cfg.HardMaxCacheSize == 64 && !cfg.Verbose
+		}}, nil)
+
+	if !configured {
+		t.Error("expected the configuration of the local cache to be given to ConfigureCache")
+	}
+	if shards := node1.cache.Shards(); shards != 32 {
+		t.Errorf("expected 32 shards, got %d", shards)
+	}
+	if capacity := node1.cache.Capacity(); capacity != 32*1000*100 {
+		t.Errorf("expected the shards sized for 1000 entries of 100 bytes each, got %d bytes", capacity)
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1938, ConnectRetries: 0}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+	node2 := New(&ClusteredBigCacheConfig{Join: true, JoinIp: "localhost:1938", LocalPort: 1937, ConnectRetries: 2}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 300)
+
+	sessions, carts := node1.Namespace("sessions"), node1.Namespace("carts")
+	if node1.Namespace("sessions") != sessions {
+		t.Error("expected the same namespace for the same name")
+	}
+	sessions.SetDefaultTTL(time.Minute)
+	sessions.Put("key_1", []byte("session_1"))
+	carts.Put("key_1", []byte("cart_1"))
+	node1.Put("key_1", []byte("data_1"), time.Minute)
+	time.Sleep(time.Millisecond * 200)
+
+	//the namespaces are replicated without the remote node being told about them
+	for ns, expected := range map[*Namespace]string{node2.Namespace("sessions"): "session_1", node2.Namespace("carts"): "cart_1"} {
+		if data, err := ns.Get("key_1", time.Millisecond*200); err != nil || string(data) != expected {
+			t.Errorf("expected %q in %s, got %q: %v", expected, ns.Name(), data, err)
+		}
+	}
+	if data, _ := node2.Get("key_1", time.Millisecond*200); string(data) != "data_1" {
+		t.Errorf("expected keys outside of any namespace to be kept apart, got %q", data)
+	}
+	if ttl, err := sessions.TTL("key_1", time.Millisecond*200); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("expected the default ttl of the namespace, got %s: %v", ttl, err)
+	}
+	if ttl, _ := carts.TTL("key_1", time.Millisecond*200); ttl != time.Duration(bigcache.NO_EXPIRY) {
+		t.Errorf("expected entries of a namespace without a default ttl not to expire, got %s", ttl)
+	}
+
+	carts.Delete("key_1")
+	time.Sleep(time.Millisecond * 200)
+	if _, err := node2.Namespace("carts").Get("key_1", time.Millisecond*100); err == nil {
+		t.Error("expected the delete to be replicated")
+	}
+	if data, _ := node2.Namespace("sessions").Get("key_1", time.Millisecond*100); string(data) != "session_1" {
+		t.Error("expected a delete in one namespace to leave the others alone")
+	}
+
+	stats := node2.NamespaceStats()
+	if len(stats) != 2 || stats[0].Name != "carts" || stats[0].Hits != 1 || stats[0].Misses != 1 || stats[1].Hits != 2 {
+		t.Errorf("expected the reads of both namespaces counted, got %+v", stats)
+	}
+	if stats := node1.adminStats().Namespaces; len(stats) != 2 || stats[1].Puts != 1 || stats[0].Deletes != 1 {
+		t.Errorf("expected the writes of both namespaces counted, got %+v", stats)
+	}
+}
+
+func TestPutWithAck(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Id: "node_1", Join: false, LocalPort: 1936, ConnectRetries: 0}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+	node2 := New(&ClusteredBigCacheConfig{Id: "node_2", Join: true, JoinIp: "localhost:1936", LocalPort: 1935, ConnectRetries: 2}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	node3 := New(&ClusteredBigCacheConfig{Id: "node_3", Join: true, JoinIp: "localhost:1936", LocalPort: 1934, ConnectRetries: 2}, nil)
+	node3.Start()
+	defer node3.ShutDown()
+	time.Sleep(time.Millisecond * 500)
+
+	result, err := node1.PutWithAck("key_1", []byte("data_1"), time.Minute, time.Second)
+	if err != nil || result.Replicas != 2 || result.Acked != 2 || len(result.Failed) != 0 {
+		t.Fatalf("expected both remote nodes to acknowledge the put, got %+v: %v", result, err)
+	}
+	if data, err := node2.cache.Get("key_1"); err != nil || string(data) != "data_1" {
+		t.Errorf("expected the put applied once acknowledged, got %q: %v", data, err)
+	}
+
+	node3.SetReadOnly(true)
+	result, err = node1.PutWithAck("key_2", []byte("data_2"), time.Minute, time.Second)
+	if err != nil || result.Replicas != 2 || result.Acked != 1 || result.Failed["node_3"] != ErrReadOnly {
+		t.Errorf("expected the read only node to say why it did not apply the put, got %+v: %v", result, err)
+	}
+	if _, err := node1.cache.Get("key_2"); err != nil {
+		t.Error("expected the put kept locally whatever the remote nodes answer")
+	}
+
+	if _, err := node3.PutWithAck("key_3", []byte("data_3"), time.Minute, time.Second); err != ErrReadOnly {
+		t.Errorf("expected the write refused locally first, got %v", err)
+	}
+}
+
+func TestReplicationRetryQueue(t *testing.T) {
+	node := New(&ClusteredBigCacheConfig{Id: "node_1", LocalPort: 1933, ReplicationRetryQueue: 3}, nil)
+	if lags := node.ReplicationLag(); len(lags) != 0 {
+		t.Fatalf("expected nothing waiting before any write, got %+v", lags)
+	}
+	rn := newRemoteNode(&remoteNodeConfig{Id: "node_2"}, node, nil)
+	node.remoteNodes.Add(rn.config.Id, rn)
+	defer node.retryQueue.close()
+
+	for i := 1; i <= 5; i++ {
+		node.retryQueue.send(&replicationMsg{r: rn, m: &message.PutMessage{Key: "key_" + strconv.Itoa(i)}})
+	}
+	lags := node.ReplicationLag()
+	if len(lags) != 1 || lags[0].Id != "node_2" || lags[0].Queued != 3 || lags[0].Overflowed != 2 || lags[0].Lag <= 0 {
+		t.Fatalf("expected the newest writes kept for the disconnected node, got %+v", lags)
+	}
+
+	rn.setState(nodeStateConnected)
+	time.Sleep(time.Millisecond * 300)
+	lags = node.ReplicationLag()
+	if lags[0].Queued != 0 || lags[0].Retried != 3 || lags[0].Failures != 0 {
+		t.Errorf("expected the writes sent once the node took them, got %+v", lags)
+	}
+	if len(rn.outboundMsgQueue) != 3 {
+		t.Fatalf("expected 3 writes sent, got %d", len(rn.outboundMsgQueue))
+	}
+	if m := (<-rn.outboundMsgQueue).(*message.PutMessage); m.Key != "key_3" {
+		t.Errorf("expected the writes sent in order, got %s first", m.Key)
+	}
+
+	node.retryQueue.send(&replicationMsg{r: rn, m: &message.PutMessage{Key: "key_6"}})
+	if lags = node.ReplicationLag(); lags[0].Queued != 0 || len(rn.outboundMsgQueue) != 3 {
+		t.Errorf("expected writes sent right away when nothing is waiting, got %+v", lags)
+	}
+}
+
+func TestChangelogReplay(t *testing.T) {
+	node2 := New(&ClusteredBigCacheConfig{Id: "node_2", Join: false, LocalPort: 1932, ConnectRetries: 2}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	node3 := New(&ClusteredBigCacheConfig{Id: "node_3", Join: true, JoinIp: "localhost:1932", LocalPort: 1930, ConnectRetries: 2}, nil)
+	node3.Start()
+	defer node3.ShutDown()
+	node1 := New(&ClusteredBigCacheConfig{Id: "node_1", Join: true, JoinIp: "localhost:1932", LocalPort: 1931, ConnectRetries: 2,
+		ReconnectOnDisconnect: true, RetryInitialInterval: 500, ChangelogSize: 4}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+	time.Sleep(time.Millisecond * 500)
+
+	node1.Put("key_1", []byte("data_1"), time.Minute)
+	time.Sleep(time.Millisecond * 100)
+	peer, _ := node1.remoteNodes.Get("node_2")
+	peer.(*remoteNode).shutDown() //a short outage, over once node_1 redials
+	time.Sleep(time.Millisecond * 100)
+	node1.Put("key_2", []byte("data_2"), time.Minute)
+	node1.Put("key_3", []byte("data_3"), time.Minute)
+	node1.Delete("key_1")
+	node1.Put("key_2", []byte("data_2b"), time.Minute)
+	time.Sleep(time.Second)
+
+	if _, err := node2.cache.Get("key_1"); err == nil {
+		t.Error("expected the delete missed replayed")
+	}
+	if data, err := node2.cache.Get("key_2"); err != nil || string(data) != "data_2b" {
+		t.Errorf("expected the latest put of the key replayed, got %q: %v", data, err)
+	}
+	if _, err := node2.cache.Get("key_3"); err != nil {
+		t.Error("expected the put missed replayed")
+	}
+	if stats := node1.Changelog(); stats.Seq != 5 || stats.Kept != 4 || stats.Resumed != 1 || stats.Replayed != 3 || stats.Missed != 0 {
+		t.Errorf("unexpected changelog stats %+v", stats)
+	}
+	if node2.Changelog() != nil {
+		t.Error("expected no changelog kept unless configured")
+	}
+}
+
+func TestLastWriteWins(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Id: "node_1", Join: false, LocalPort: 1940, ConnectRetries: 2, LastWriteWins: true}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+	node2 := New(&ClusteredBigCacheConfig{Id: "node_2", Join: true, JoinIp: "localhost:1940", LocalPort: 1941, ConnectRetries: 2,
+		LastWriteWins: true}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 500)
+
+	node1.Put("key_1", []byte("data_new"), time.Minute)
+	time.Sleep(time.Millisecond * 200)
+	peer, ok := node2.remoteNodes.Get("node_1")
+	if !ok {
+		t.Fatal("expected node_2 connected to node_1")
+	}
+	expiry := uint64(time.Now().Add(time.Minute).Unix())
+	//a put node_1 made before the last one, arriving late
+	peer.(*remoteNode).applyPut(message.MsgPUTStamped, &message.PutMessage{Key: "key_1", Data: []byte("data_old"), Expiry: expiry, Timestamp: 1})
+	if data, _ := node2.cache.Get("key_1"); string(data) != "data_new" {
+		t.Errorf("expected the older put discarded, got %q", data)
+	}
+	if node2.versions.staleCount() != 1 {
+		t.Errorf("expected one stale write counted, got %d", node2.versions.staleCount())
+	}
+
+	peer.(*remoteNode).applyPut(message.MsgPUTStamped, &message.PutMessage{Key: "key_1", Data: []byte("data_newer"), Expiry: expiry,
+		Timestamp: node2.versions.next() + 1})
+	if data, _ := node2.cache.Get("key_1"); string(data) != "data_newer" {
+		t.Errorf("expected the newer put applied, got %q", data)
+	}
+	if stamp := node2.versions.next(); stamp <= node2.versions.versions["key_1"].stamp {
+		t.Error("expected the clock moved past the timestamps received")
+	}
+
+	node2.Put("key_1", []byte("data_2"), time.Minute)
+	time.Sleep(time.Millisecond * 200)
+	if data, _ := node1.cache.Get("key_1"); string(data) != "data_2" {
+		t.Errorf("expected the latest put replicated, got %q", data)
+	}
+	node2.Delete("key_1")
+	peer.(*remoteNode).applyPut(message.MsgPUTStamped, &message.PutMessage{Key: "key_1", Data: []byte("data_3"), Expiry: expiry, Timestamp: 1})
+	if data, err := node2.cache.Get("key_1"); err == nil {
+		t.Errorf("expected a put older than the delete discarded, got %q", data)
+	}
+	node2.Put("key_1", []byte("data_4"), time.Minute)
+	peer.(*remoteNode).handleDelete((&message.DeleteMessage{Key: "key_1", Timestamp: 1}).Serialize())
+	if data, _ := node2.cache.Get("key_1"); string(data) != "data_4" {
+		t.Errorf("expected a delete older than the put discarded, got %q", data)
+	}
+	peer.(*remoteNode).handleDelete((&message.DeleteMessage{Key: "key_1"}).Serialize())
+	if _, err := node2.cache.Get("key_1"); err == nil {
+		t.Error("expected a delete without a timestamp applied")
+	}
+
+	//a put and a delete of the same keys made at the same time on both nodes leave them with the same copies
+	var wg sync.WaitGroup
+	for x := 0; x < 50; x++ {
+		key := "key_" + strconv.Itoa(x)
+		node1.Put(key, []byte("data"), time.Minute)
+		wg.Add(2)
+		go func() { defer wg.Done(); node1.Put(key, []byte("data_new"), time.Minute) }()
+		go func() { defer wg.Done(); node2.Delete(key) }()
+	}
+	wg.Wait()
+	time.Sleep(time.Millisecond * 300)
+	for x := 0; x < 50; x++ {
+		key := "key_" + strconv.Itoa(x)
+		data1, err1 := node1.cache.Get(key)
+		data2, err2 := node2.cache.Get(key)
+		if (err1 == nil) != (err2 == nil) || string(data1) != string(data2) {
+			t.Errorf("expected both nodes to converge on '%s', got %q [%v] and %q [%v]", key, data1, err1, data2, err2)
+		}
+	}
+}
+
+func TestLastWriteWinsEviction(t *testing.T) {
+	transport := comms.NewMemoryTransport()
+	node1 := New(&ClusteredBigCacheConfig{Id: "node_1", Join: false, LocalPort: 7266, ConnectRetries: 2, Transport: transport,
+		LastWriteWins: true, ShardSize: 1, HardMaxCacheSize: 1}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+
+	//keys that never expire, most of them evicted to stay within HardMaxCacheSize
+	data := make([]byte, 1024)
+	for x := 0; x < 4096; x++ {
+		node1.Put("key_"+strconv.Itoa(x), data, 0)
+	}
+	node1.Put("short", data, time.Second)
+	time.Sleep(time.Millisecond * 2500)
+
+	node1.versions.lock.Lock()
+	versions := len(node1.versions.versions)
+	_, short := node1.versions.versions["short"]
+	node1.versions.lock.Unlock()
+	if versions != node1.cache.Len() {
+		t.Errorf("expected a version kept for each key cached only, got %d for %d keys", versions, node1.cache.Len())
+	}
+	if short {
+		t.Error("expected the version of an expired key forgotten")
+	}
+}
+
+func TestSnapshotCluster(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	node1 := New(&ClusteredBigCacheConfig{Id: "node_1", Join: false, LocalPort: 1942, ConnectRetries: 2}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+	node2 := New(&ClusteredBigCacheConfig{Id: "node_2", Join: true, JoinIp: "localhost:1942", LocalPort: 1943, ConnectRetries: 2}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 500)
+
+	for x := 0; x < 50; x++ {
+		node1.Put("key_1_"+strconv.Itoa(x), []byte("data"), time.Minute)
+		node2.Put("key_2_"+strconv.Itoa(x), []byte("data"), time.Minute)
+	}
+	snapshots, err := node1.SnapshotCluster(dir)
+	if err != nil {
+		t.Fatalf("expected every node to write its snapshot, got %v %+v", err, snapshots)
+	}
+	if len(snapshots) != 2 || snapshots[0].Id != "node_1" || snapshots[1].Id != "node_2" {
+		t.Fatalf("expected a snapshot of both nodes, got %+v", snapshots)
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.Entries != 100 {
+			t.Errorf("expected every write in the snapshot of %s, got %d entries", snapshot.Id, snapshot.Entries)
+		}
+	}
+	if err := node2.Put("key_3", []byte("data"), time.Minute); err != nil {
+		t.Errorf("expected writes taken again after the snapshot, got %v", err)
+	}
+
+	node3 := New(&ClusteredBigCacheConfig{Id: "node_3", Join: false, LocalPort: 1944, ConnectRetries: 2}, nil)
+	node3.Start()
+	defer node3.ShutDown()
+	if restored, err := node3.RestoreSnapshot(snapshots[1].File); err != nil || restored != 100 {
+		t.Errorf("expected 100 keys restored, got %d %v", restored, err)
+	}
+	if data, err := node3.Get("key_1_7", time.Millisecond*100); err != nil || string(data) != "data" {
+		t.Errorf("expected a restored key, got %q %v", data, err)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Id: "node_1", Join: false, LocalPort: 1945, ConnectRetries: 0}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+	node1.Put("key_1", []byte("data_1"), time.Minute)
+	node1.Put("key_2", []byte("data_2"), time.Duration(bigcache.NO_EXPIRY))
+	_, expiry, _ := node1.cache.GetWithExpiry("key_1")
+
+	for _, format := range []byte{EXPORT_FORMAT_JSON, EXPORT_FORMAT_BINARY} {
+		var buf bytes.Buffer
+		if count, err := node1.Export(&buf, format); err != nil || count != 2 {
+			t.Fatalf("expected 2 keys exported in format %d, got %d %v", format, count, err)
+		}
+		if format == EXPORT_FORMAT_JSON && strings.Count(buf.String(), "\n") != 2 {
+			t.Errorf("expected a line per key, got %q", buf.String())
+		}
+
+		node2 := New(&ClusteredBigCacheConfig{Id: "node_2", Join: false, LocalPort: 1946, ConnectRetries: 0}, nil)
+		node2.Start()
+		if count, err := node2.Import(&buf); err != nil || count != 2 {
+			t.Errorf("expected 2 keys imported in format %d, got %d %v", format, count, err)
+		}
+		if data, exp, err := node2.cache.GetWithExpiry("key_1"); err != nil || string(data) != "data_1" || exp != expiry {
+			t.Errorf("expected key_1 imported with its expiry, got %q %d %v", data, exp, err)
+		}
+		if data, exp, err := node2.cache.GetWithExpiry("key_2"); err != nil || string(data) != "data_2" || exp != bigcache.NO_EXPIRY {
+			t.Errorf("expected key_2 imported without expiry, got %q %d %v", data, exp, err)
+		}
+		node2.ShutDown()
+	}
+
+	if _, err := node1.Export(ioutil.Discard, 9); err != ErrUnknownExportFormat {
+		t.Errorf("expected an unknown format refused, got %v", err)
+	}
+	if _, err := node1.Import(strings.NewReader(string(exportMagic) + "\x05key")); err == nil {
+		t.Error("expected a truncated import to fail")
+	}
+}
+
+func TestProbes(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Id: "node_1", Join: false, LocalPort: 1948, ConnectRetries: 0, ProbePort: 1949,
+		MinimumClusterSize: 2}, nil)
+	if err := node1.Healthy(); err != ErrNotStarted {
+		t.Errorf("expected a node not started unhealthy, got %v", err)
+	}
+	node1.Start()
+	defer node1.ShutDown()
+
+	if err := node1.Healthy(); err != nil {
+		t.Errorf("expected a started node healthy, got %v", err)
+	}
+	if err := node1.Ready(); err != ErrNoQuorum {
+		t.Errorf("expected a node without quorum not ready, got %v", err)
+	}
+	rsp, err := http.Get("http://localhost:1949/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected /readyz to fail without quorum, got %d", rsp.StatusCode)
+	}
+
+	node1.config.MinimumClusterSize = 1
+	node1.checkQuorum()
+	if err := node1.Ready(); err != nil {
+		t.Errorf("expected the node ready, got %v", err)
+	}
+	rsp, err = http.Get("http://localhost:1949/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		t.Errorf("expected /healthz to pass, got %d", rsp.StatusCode)
+	}
+
+	node1.serverEndpoints[0].Close()
+	time.Sleep(time.Millisecond * 100)
+	if err := node1.Healthy(); err != ErrListenerDown {
+		t.Errorf("expected a node with a listener down unhealthy, got %v", err)
+	}
+}
+
+func TestExpvar(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Id: "node_1", Join: false, LocalPort: 1950, ConnectRetries: 0, Expvar: true, Instance: "expvar"}, nil)
+	node1.Start()
+
+	node1.Put("key_1", []byte("data_1"), time.Minute)
+	published := expvar.Get("ngbigcache.expvar")
+	if published == nil {
+		t.Fatal("expected the statistics published")
+	}
+	stats := NodeStatistics{}
+	if err := json.Unmarshal([]byte(published.String()), &stats); err != nil || stats.Id != "node_1" || stats.Cache.Entries != 1 {
+		t.Errorf("unexpected statistics published %s %v", published.String(), err)
+	}
+
+	node1.ShutDown()
+	if published.String() != "null" {
+		t.Errorf("expected nothing published once shut down, got %s", published.String())
+	}
+}
+
+func TestTrafficCounters(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Id: "node_1", Join: false, LocalPort: 1951, ConnectRetries: 2}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+	node2 := New(&ClusteredBigCacheConfig{Id: "node_2", Join: true, JoinIp: "localhost:1951", LocalPort: 1952, ConnectRetries: 2}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 500)
+
+	node1.Put("key_1", []byte("data_1"), time.Minute)
+	node1.Put("key_2", []byte("data_2"), time.Minute)
+	time.Sleep(time.Millisecond * 200)
+
+	sent := node1.StatisticsStruct().Traffic[message.MsgCodeToString(message.MsgPUT)]
+	received := node2.StatisticsStruct().Traffic[message.MsgCodeToString(message.MsgPUT)]
+	if sent.SentMessages != 2 || received.ReceivedMessages != 2 || sent.SentBytes == 0 || sent.SentBytes != received.ReceivedBytes {
+		t.Errorf("expected the puts counted on both nodes, got %+v and %+v", sent, received)
+	}
+	peers := node2.StatisticsStruct().Peers
+	if len(peers) != 1 || peers[0].Traffic[message.MsgCodeToString(message.MsgPUT)].ReceivedMessages != 2 {
+		t.Errorf("expected the puts counted for the peer, got %+v", peers)
+	}
+}
+
+func TestSlowOps(t *testing.T) {
+	logger := &recordingLogger{}
+	slow := newSlowOps(&ClusteredBigCacheConfig{SlowGetThreshold: 50, SlowPingThreshold: 20}, logger)
+
+	slow.observeGet("key_1", "node_2", time.Now())
+	slow.observePing("node_2", time.Millisecond)
+	if slow.count() != 0 {
+		t.Errorf("expected nothing logged under the thresholds, got %v", logger.lines)
+	}
+
+	slow.observeGet("key_1", "node_2", time.Now().Add(-time.Millisecond*60))
+	slow.observePing("node_2", time.Millisecond*30)
+	if slow.count() != 2 || len(logger.lines) != 2 {
+		t.Fatalf("expected the slow get and ping logged, got %v", logger.lines)
+	}
+	if want := fmt.Sprintf("slow op=get key_hash=%016x peer=node_2 ", keyHash("key_1")); !strings.HasPrefix(logger.lines[0], want) {
+		t.Errorf("expected %q, got %q", want, logger.lines[0])
+	}
+	if !strings.HasPrefix(logger.lines[1], "slow op=ping peer=node_2 rtt=30ms") {
+		t.Errorf("unexpected ping logged %q", logger.lines[1])
+	}
+	if newSlowOps(&ClusteredBigCacheConfig{}, logger) != nil {
+		t.Error("expected no slow operations logged unless a threshold is set")
+	}
+}
+
+func TestClock(t *testing.T) {
+	clock := bigcache.NewManualClock(time.Unix(1000000, 0))
+	resolver := func(host string) ([]string, time.Duration, error) {
+		return []string{"127.0.0.1"}, time.Second * 10, nil
+	}
+	node := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1953, ConnectRetries: 0, Clock: clock, Resolver: resolver}, nil)
+	node.Start()
+	defer node.ShutDown()
+
+	node.Put("key_1", []byte("data_1"), time.Second*10)
+	clock.Advance(time.Second * 4)
+	if ttl, err := node.TTL("key_1", time.Second); err != nil || ttl != time.Second*6 {
+		t.Errorf("expected the ttl read from the configured clock, got %s %v", ttl, err)
+	}
+	if err := node.PutUntil("key_2", []byte("data_2"), time.Unix(1000002, 0)); err != bigcache.ErrExpiryInPast {
+		t.Errorf("expected an expiry before the configured clock refused, got %v", err)
+	}
+	if err := node.PutUntil("key_2", []byte("data_2"), time.Unix(1000030, 0)); err != nil {
+		t.Error(err)
+	}
+	if ttl, _ := node.TTL("key_2", time.Second); ttl != time.Second*26 {
+		t.Errorf("expected the ttl of key_2 read from the configured clock, got %s", ttl)
+	}
+
+	node.dns.Resolve("peer.cache.test:1953")
+	clock.Advance(time.Second * 9)
+	node.dns.Resolve("peer.cache.test:1953")
+	if lookups := node.DNSStats().Lookups; lookups != 1 {
+		t.Errorf("expected the addresses of the peer kept until their ttl ran out on the configured clock, got %d lookups", lookups)
+	}
+	clock.Advance(time.Second * 2)
+	node.dns.Resolve("peer.cache.test:1953")
+	if lookups := node.DNSStats().Lookups; lookups != 2 {
+		t.Errorf("expected the peer looked up again once its ttl ran out on the configured clock, got %d lookups", lookups)
+	}
+}
+
+func TestMemoryTransport(t *testing.T) {
+	transport := comms.NewMemoryTransport()
+	node1 := New(&ClusteredBigCacheConfig{Id: "node_1", Join: false, LocalPort: 7101, ConnectRetries: 2, Transport: transport}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+	node2 := New(&ClusteredBigCacheConfig{Id: "node_2", Join: true, JoinIp: "localhost:7101", LocalPort: 7102, ConnectRetries: 2,
+		Transport: transport}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 500)
+
+	listener, err := net.Listen("tcp", ":7101")
+	if err != nil {
+		t.Errorf("expected no port taken by the nodes, got %v", err)
+	} else {
+		listener.Close()
+	}
+	if len(node1.getRemoteNodes()) != 1 || len(node2.getRemoteNodes()) != 1 {
+		t.Fatalf("expected the nodes connected through the transport, got %d and %d remote nodes",
+			len(node1.getRemoteNodes()), len(node2.getRemoteNodes()))
+	}
+
+	node1.Put("key_1", []byte("data_1"), time.Minute)
+	time.Sleep(time.Millisecond * 200)
+	if data, err := node2.cache.Get("key_1"); err != nil || string(data) != "data_1" {
+		t.Errorf("expected the put replicated through the transport, got %q %v", data, err)
+	}
+
+	if _, err := transport.Dial("tcp", "localhost:7103", time.Second); err == nil {
+		t.Error("expected a connection to a port nobody listens on refused")
+	}
+}
+
+func TestFrameInterceptor(t *testing.T) {
+	faults := map[string]comms.Fault{"key_1": {Drop: true}, "key_2": {Duplicate: true}, "key_3": {Corrupt: true},
+		"key_4": {Delay: time.Millisecond * 300}}
+	transport := comms.NewMemoryTransport()
+	node1 := New(&ClusteredBigCacheConfig{Id: "node_1", Join: false, LocalPort: 7111, ConnectRetries: 2, Transport: transport,
+		FrameInterceptor: func(c *comms.Connection, frame []byte) comms.Fault {
+			code, _, err := message.ParseFrameHeaderMax(frame[:message.FrameHeaderSize], message.MaxFrameSize)
+			if err != nil || code != message.MsgPUT {
+				return comms.Fault{}
+			}
+			for key, fault := range faults {
+				if bytes.Contains(frame, []byte(key)) {
+					return fault
+				}
+			}
+			return comms.Fault{}
+		}}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+	node2 := New(&ClusteredBigCacheConfig{Id: "node_2", Join: true, JoinIp: "localhost:7111", LocalPort: 7112, ConnectRetries: 2,
+		Transport: transport}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 500)
+
+	node1.Put("key_1", []byte("data_1"), time.Minute)
+	node1.Put("key_2", []byte("data_2"), time.Minute)
+	node1.Put("key_3", []byte("data_3"), time.Minute)
+	time.Sleep(time.Millisecond * 100)
+
+	if _, err := node2.cache.Get("key_1"); err == nil {
+		t.Error("expected the dropped put not received")
+	}
+	if data, err := node2.cache.Get("key_2"); err != nil || string(data) != "data_2" {
+		t.Errorf("expected the duplicated put received, got %q %v", data, err)
+	}
+	if received := node2.StatisticsStruct().Traffic[message.MsgCodeToString(message.MsgPUT)].ReceivedMessages; received < 3 {
+		t.Errorf("expected the duplicated put received twice, got %d puts", received)
+	}
+	if data, err := node2.cache.Get("key_3"); err != nil || string(data) == "data_3" {
+		t.Errorf("expected the corrupted put received altered, got %q %v", data, err)
+	}
+
+	node1.Put("key_4", []byte("data_4"), time.Minute)
+	time.Sleep(time.Millisecond * 100)
+	if _, err := node2.cache.Get("key_4"); err == nil {
+		t.Error("expected the delayed put not received yet")
+	}
+	time.Sleep(time.Millisecond * 400)
+	if data, err := node2.cache.Get("key_4"); err != nil || string(data) != "data_4" {
+		t.Errorf("expected the delayed put received once the delay passed, got %q %v", data, err)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	if errs := DefaultClusterConfig().Validate(); len(errs) != 0 {
+		t.Errorf("expected the default configuration valid, got %v", errs)
+	}
+
+	config := &ClusteredBigCacheConfig{Join: true, LocalPort: 70000, DebugPort: 1953, ProbePort: 1953, ShardSize: 24,
+		ReplicationFactor: -1, WarmUpHitRatio: 1.5, QuorumMode: 7}
+	if errs := config.Validate(); len(errs) != 7 {
+		t.Errorf("expected every problem of the configuration listed, got %v", errs)
+	}
+
+	node := New(config, nil)
+	defer node.ShutDown()
+	err := node.Start()
+	if configErr, ok := err.(*bigcache.ConfigError); !ok || len(configErr.Errors) != 7 {
+		t.Errorf("expected the node refused to start with the problems of its configuration, got %v", err)
+	}
+}
+
+func TestUpdateConfig(t *testing.T) {
+	transport := comms.NewMemoryTransport()
+	node1 := New(&ClusteredBigCacheConfig{Id: "node_1", Join: false, LocalPort: 7121, ConnectRetries: 2, Transport: transport,
+		ReplicationRetryQueue: 10}, nil)
+	if err := node1.UpdateConfig(ConfigUpdate{}); err != ErrNotStarted {
+		t.Errorf("expected a node not started refused, got %v", err)
+	}
+	node1.Start()
+	defer node1.ShutDown()
+	node2 := New(&ClusteredBigCacheConfig{Id: "node_2", Join: true, JoinIp: "localhost:7121", LocalPort: 7122, ConnectRetries: 2,
+		Transport: transport}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 500)
+
+	interval, timeout, rf, queue, zero := 2, 1, 2, 5, 0
+	err := node2.UpdateConfig(ConfigUpdate{PingTimeout: &zero, ReplicationRetryQueue: &queue})
+	if configErr, ok := err.(*bigcache.ConfigError); !ok || len(configErr.Errors) != 2 {
+		t.Errorf("expected every problem of the update listed, got %v", err)
+	}
+
+	logRequests := true
+	err = node1.UpdateConfig(ConfigUpdate{PingInterval: &interval, PingTimeout: &timeout, ReplicationFactor: &rf,
+		ReplicationRetryQueue: &queue, LogRequests: &logRequests})
+	if err != nil {
+		t.Fatalf("expected the update applied, got %v", err)
+	}
+	time.Sleep(time.Millisecond * 200)
+
+	if !node1.config.LogRequests || node1.retryQueue.size != queue {
+		t.Error("expected the settings of the node updated")
+	}
+	for _, v := range node1.getRemoteNodes() {
+		if r := v.(*remoteNode); r.config.PingInterval != interval || r.config.PingTimeout != timeout {
+			t.Errorf("expected the ping settings of the remote node updated, got %d and %d",
+				r.config.PingInterval, r.config.PingTimeout)
+		}
+	}
+	if node2.config.ReplicationFactor != rf {
+		t.Errorf("expected the replication factor sent to the remote node, got %d", node2.config.ReplicationFactor)
+	}
+	if len(node1.getRemoteNodes()) != 1 || len(node2.getRemoteNodes()) != 1 {
+		t.Error("expected the connections kept")
+	}
+}
+
+func TestSetReplicationFactor(t *testing.T) {
+	transport := comms.NewMemoryTransport()
+	node1 := New(&ClusteredBigCacheConfig{Id: "node_1", Join: false, LocalPort: 7131, ConnectRetries: 2, Transport: transport}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+	for x := 0; x < 5; x++ { //put before the remote node joins, so it has no copy
+		node1.Put(fmt.Sprintf("key_%d", x), []byte("data"), time.Minute)
+	}
+	node2 := New(&ClusteredBigCacheConfig{Id: "node_2", Join: true, JoinIp: "localhost:7131", LocalPort: 7132, ConnectRetries: 2,
+		Transport: transport}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 500)
+
+	if err := node1.SetReplicationFactor(0); err == nil {
+		t.Error("expected a replication factor under 1 refused")
+	}
+	if err := node1.SetReplicationFactor(2); err != nil {
+		t.Fatalf("expected the replication factor changed, got %v", err)
+	}
+	for x := 0; x < 50 && !node1.ReReplication().Done; x++ {
+		time.Sleep(time.Millisecond * 100)
+	}
+	time.Sleep(time.Millisecond * 200)
+
+	stats := node1.ReReplication()
+	if !stats.Done || stats.Checked != 5 || stats.Under != 5 || stats.Copied != 5 {
+		t.Errorf("expected every key copied to the remote node, got %+v", stats)
+	}
+	if node2.config.ReplicationFactor != 2 {
+		t.Errorf("expected the replication factor sent to the remote node, got %d", node2.config.ReplicationFactor)
+	}
+	for x := 0; x < 5; x++ {
+		if _, err := node2.cache.Get(fmt.Sprintf("key_%d", x)); err != nil {
+			t.Errorf("expected key_%d copied to the remote node, got %v", x, err)
+		}
+	}
+}
+
+func TestConnectionDeadlines(t *testing.T) {
+	transport := comms.NewMemoryTransport()
+	listener, err := transport.Listen("tcp", ":7140")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		if conn, err := listener.Accept(); err == nil { //accepted but never read from nor written to
+			defer conn.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+	conn, err := comms.NewTransportConnection("localhost:7140", time.Second, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadlines(time.Millisecond*100, time.Millisecond*100)
+	if _, err = conn.ReadData(6, 0); err != comms.ErrReadTimeout {
+		t.Errorf("expected the read timed out, got %v", err)
+	}
+	if err = conn.SendData([]byte("data")); err != comms.ErrWriteTimeout {
+		t.Errorf("expected the write timed out, got %v", err)
+	}
+
+	var hung int32
+	node1 := New(&ClusteredBigCacheConfig{Id: "node_1", Join: false, LocalPort: 7141, ConnectRetries: 2, Transport: transport,
+		PingInterval: 1, PingTimeout: 1, ReadDeadline: 1500}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+	node2 := New(&ClusteredBigCacheConfig{Id: "node_2", Join: true, JoinIp: "localhost:7141", LocalPort: 7142, ConnectRetries: 2,
+		PingInterval: 1, PingTimeout: 1, Transport: transport,
+		FrameInterceptor: func(c *comms.Connection, frame []byte) comms.Fault {
+			return comms.Fault{Drop: atomic.LoadInt32(&hung) == 1}
+		}}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 2000) //pings keep the connection up past the read deadline
+	if len(node1.getRemoteNodes()) != 1 {
+		t.Fatal("expected the nodes connected")
+	}
+
+	atomic.StoreInt32(&hung, 1) //node_2 goes silent
+	time.Sleep(time.Millisecond * 2500)
+	if len(node1.getRemoteNodes()) != 0 {
+		t.Error("expected the silent remote node dropped once the read deadline passed, before pings failed")
+	}
+}
+
+func TestAdaptivePingInterval(t *testing.T) {
+	transport := comms.NewMemoryTransport()
+	node1 := New(&ClusteredBigCacheConfig{Id: "node_1", Join: false, LocalPort: 7151, ConnectRetries: 2, Transport: transport,
+		PingInterval: 1, PingTimeout: 1, AdaptivePing: true}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+	node2 := New(&ClusteredBigCacheConfig{Id: "node_2", Join: true, JoinIp: "localhost:7151", LocalPort: 7152, ConnectRetries: 2,
+		PingInterval: 1, PingTimeout: 1, Transport: transport}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 3500)
+
+	peers := node1.StatisticsStruct().Peers
+	if len(peers) != 1 || peers[0].PingInterval <= time.Second {
+		t.Fatalf("expected the pings to a healthy remote node spaced out, got %+v", peers)
+	}
+	if peers = node2.StatisticsStruct().Peers; len(peers) != 1 || peers[0].PingInterval != time.Second {
+		t.Errorf("expected the pings of a node not pinging adaptively sent every PingInterval, got %+v", peers)
+	}
+	if len(node1.getRemoteNodes()) != 1 || len(node2.getRemoteNodes()) != 1 {
+		t.Error("expected the connection kept")
+	}
+}
+
+func TestEvictionBroadcast(t *testing.T) {
+	transport := comms.NewMemoryTransport()
+	node1 := New(&ClusteredBigCacheConfig{Id: "node_1", Join: false, LocalPort: 7161, ConnectRetries: 2, Transport: transport,
+		EvictionBroadcast: EVICTION_BROADCAST_ALL, EvictionBatchInterval: 50}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+	node2 := New(&ClusteredBigCacheConfig{Id: "node_2", Join: true, JoinIp: "localhost:7161", LocalPort: 7162, ConnectRetries: 2,
+		Transport: transport}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 200)
+
+	//only the local cache of node1 expires the keys, those of node2 are kept until deleted
+	keys := []string{"expired_1", "expired_2", "expired_3"}
+	for _, key := range keys {
+		node1.cache.Set(key, []byte("value"), time.Second)
+		node2.cache.Set(key, []byte("value"), 0)
+	}
+	time.Sleep(time.Millisecond * 2500)
+
+	for _, key := range keys {
+		if _, err := node2.Get(key, time.Millisecond*100); err == nil {
+			t.Errorf("expected '%s' deleted as node1 evicted it", key)
+		}
+	}
+	if stats := node1.EvictionStats(); stats.Sent != 3 || stats.Pending != 0 {
+		t.Errorf("expected the 3 evictions broadcast, got %+v", stats)
+	}
+	if stats := node2.EvictionStats(); stats.Received != 3 || stats.Sent != 0 {
+		t.Errorf("expected the 3 evictions applied by node2 which broadcasts none, got %+v", stats)
+	}
+
+	//a key written again before the batch is sent keeps its new value
+	node1.evictions.evicted("kept", bigcache.Expired)
+	if err := node1.Put("kept", []byte("new value"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 200)
+	if data, err := node2.Get("kept", time.Millisecond*100); err != nil || string(data) != "new value" {
+		t.Errorf("expected the new value of a key written after its eviction, got '%s' %v", data, err)
+	}
+}
+
+func TestPutNotFound(t *testing.T) {
+	transport := comms.NewMemoryTransport()
+	node1 := New(&ClusteredBigCacheConfig{Id: "node_1", Join: false, LocalPort: 7171, ConnectRetries: 2, Transport: transport}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+	node2 := New(&ClusteredBigCacheConfig{Id: "node_2", Join: true, JoinIp: "localhost:7171", LocalPort: 7172, ConnectRetries: 2,
+		Transport: transport}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 200)
+
+	if err := node1.PutNotFound("missing", time.Millisecond*500); err != ErrNotFoundTTL {
+		t.Errorf("expected a key cached as not found for less than a second refused, got %v", err)
+	}
+	if err := node1.PutNotFound("missing", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 200)
+
+	for _, node := range []*ClusteredBigCache{node1, node2} {
+		started := time.Now()
+		if _, err := node.Get("missing", time.Second); !errors.Is(err, bigcache.ErrCachedNotFound) {
+			t.Errorf("expected the key cached as not found on '%s', got %v", node.config.Id, err)
+		}
+		if time.Since(started) > time.Millisecond*100 {
+			t.Errorf("expected '%s' not to ask the remote nodes for a key cached as not found", node.config.Id)
+		}
+	}
+
+	if err := node2.Put("missing", []byte("found"), 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 200)
+	if data, err := node1.Get("missing", time.Second); err != nil || string(data) != "found" {
+		t.Errorf("expected the value written over the key cached as not found, got '%s' %v", data, err)
+	}
+}
+
+func TestLock(t *testing.T) {
+	transport := comms.NewMemoryTransport()
+	node1 := New(&ClusteredBigCacheConfig{Id: "node_1", Join: false, LocalPort: 7181, ConnectRetries: 2, Transport: transport}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+	node2 := New(&ClusteredBigCacheConfig{Id: "node_2", Join: true, JoinIp: "localhost:7181", LocalPort: 7182, ConnectRetries: 2,
+		Transport: transport}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 200)
+
+	if _, err := node1.Lock("job", time.Millisecond*500); err != ErrLockTTL {
+		t.Errorf("expected a lock held for less than a second refused, got %v", err)
+	}
+	token, err := node1.Lock("job", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = node2.Lock("job", time.Minute); err != ErrLocked {
+		t.Errorf("expected the lock held by node1, got %v", err)
+	}
+	if err = node2.Unlock("job", "not the token"); err != ErrNotLocked {
+		t.Errorf("expected the lock not released without its token, got %v", err)
+	}
+	if err = node1.Unlock("job", token); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if err = node1.Unlock("job", token); err != ErrNotLocked {
+		t.Errorf("expected a released lock not released again, got %v", err)
+	}
+
+	//the lock expires when its owner never releases it
+	if _, err = node2.Lock("job", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 2500)
+	if _, err = node1.Lock("job", time.Minute); err != nil {
+		t.Errorf("expected an expired lock taken again, got %v", err)
+	}
+}
+
+func TestHash(t *testing.T) {
+	transport := comms.NewMemoryTransport()
+	node1 := New(&ClusteredBigCacheConfig{Id: "node_1", Join: false, LocalPort: 7191, ConnectRetries: 2, Transport: transport}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+	node2 := New(&ClusteredBigCacheConfig{Id: "node_2", Join: true, JoinIp: "localhost:7191", LocalPort: 7192, ConnectRetries: 2,
+		Transport: transport}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 200)
+
+	//each node sets its own fields, neither undoes the other
+	node1.HSet("session", map[string][]byte{"user": []byte("u1"), "cart": []byte("c1")}, time.Minute)
+	node2.HSet("session", map[string][]byte{"lang": []byte("en")}, time.Minute)
+	time.Sleep(time.Millisecond * 200)
+	for _, node := range []*ClusteredBigCache{node1, node2} {
+		if fields, err := node.HGetAll("session", time.Millisecond*200); err != nil || len(fields) != 3 {
+			t.Errorf("expected the fields set by both nodes on '%s', got %v %v", node.config.Id, fields, err)
+		}
+	}
+
+	node2.HDel("session", "cart")
+	time.Sleep(time.Millisecond * 200)
+	if _, err := node1.HGet("session", "cart", time.Millisecond*200); err != bigcache.ErrFieldNotFound {
+		t.Errorf("expected the field removed by node2 gone, got %v", err)
+	}
+	if value, err := node1.HGet("session", "user", time.Millisecond*200); err != nil || string(value) != "u1" {
+		t.Errorf("expected the other fields kept, got '%s' %v", value, err)
+	}
+}
+
+func TestWorkQueue(t *testing.T) {
+	transport := comms.NewMemoryTransport()
+	node1 := New(&ClusteredBigCacheConfig{Id: "node_1", Join: false, LocalPort: 7201, ConnectRetries: 2, Transport: transport}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+	node2 := New(&ClusteredBigCacheConfig{Id: "node_2", Join: true, JoinIp: "localhost:7201", LocalPort: 7202, ConnectRetries: 2,
+		Transport: transport}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 200)
+
+	const jobs = 50
+	for x := 0; x < jobs; x++ {
+		node := []*ClusteredBigCache{node1, node2}[x%2]
+		if err := node.LPush("jobs", [][]byte{[]byte(strconv.Itoa(x))}, 0, time.Minute, time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//every job is popped once whichever node pops it
+	var lock sync.Mutex
+	popped := make(map[string]int)
+	var wg sync.WaitGroup
+	for _, node := range []*ClusteredBigCache{node1, node2, node1, node2} {
+		wg.Add(1)
+		go func(node *ClusteredBigCache) {
+			defer wg.Done()
+			for {
+				item, err := node.RPop("jobs", time.Second)
+				if err != nil {
+					if err != bigcache.ErrListEmpty {
+						t.Errorf("expected the queue emptied, got %v", err)
+					}
+					return
+				}
+				lock.Lock()
+				popped[string(item)]++
+				lock.Unlock()
+			}
+		}(node)
+	}
+	wg.Wait()
+	if len(popped) != jobs {
+		t.Errorf("expected the %d jobs popped, got %d", jobs, len(popped))
+	}
+	for job, times := range popped {
+		if times != 1 {
+			t.Errorf("expected job %s popped once, it was %d times", job, times)
+		}
+	}
+
+	time.Sleep(time.Millisecond * 200)
+	for _, node := range []*ClusteredBigCache{node1, node2} {
+		if items, err := node.cache.ReadRange("jobs", 0, -1); err != nil || len(items) != 0 {
+			t.Errorf("expected the queue empty on '%s', got %d items %v", node.config.Id, len(items), err)
+		}
+	}
+}
+
+func TestKeysWithPrefix(t *testing.T) {
+	transport := comms.NewMemoryTransport()
+	node1 := New(&ClusteredBigCacheConfig{Id: "node_1", Join: false, LocalPort: 7211, ConnectRetries: 2, IndexKeys: true,
+		Transport: transport}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+	node2 := New(&ClusteredBigCacheConfig{Id: "node_2", Join: true, JoinIp: "localhost:7211", LocalPort: 7212, ConnectRetries: 2,
+		IndexKeys: true, Transport: transport}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	client := NewPassiveClient("client", "localhost:7211", 7213, 5, 3, 10, nil)
+	client.config.Transport = transport
+	client.Start()
+	defer client.ShutDown()
+	time.Sleep(time.Millisecond * 200)
+
+	for x := 1; x <= 5; x++ {
+		node1.Put("user:"+strconv.Itoa(x), []byte("value"), 0)
+	}
+	node1.Put("order:1", []byte("value"), 0)
+	node2.cache.Set("user:6", []byte("value"), 0) //held by node_2 only
+	time.Sleep(time.Millisecond * 200)
+
+	//the pages listed by the passive client merge the keys of both nodes
+	var keys []string
+	cursor, pages := "", 0
+	for {
+		page, err := client.KeysWithPrefix("user:", cursor, 2, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, page.Keys...)
+		pages++
+		if page.Next == "" {
+			break
+		}
+		cursor = page.Next
+	}
+	if want := []string{"user:1", "user:2", "user:3", "user:4", "user:5", "user:6"}; !reflect.DeepEqual(keys, want) || pages != 3 {
+		t.Errorf("expected %q listed in 3 pages, got %q in %d", want, keys, pages)
+	}
+	if count, err := node1.CountPrefix("user:", time.Second); err != nil || count != 6 {
+		t.Errorf("expected the 6 keys node_2 holds counted, got %d %v", count, err)
+	}
+	if page, err := node1.KeysWithPrefix("order:", "", 0, time.Second); err != nil || !reflect.DeepEqual(page.Keys, []string{"order:1"}) {
+		t.Errorf("expected the key replicated to both nodes listed once, got %q %v", page.Keys, err)
+	}
+
+	plain := New(&ClusteredBigCacheConfig{Id: "node_3", Join: true, JoinIp: "localhost:7211", LocalPort: 7214, ConnectRetries: 2,
+		Transport: transport}, nil)
+	plain.Start()
+	defer plain.ShutDown()
+	time.Sleep(time.Millisecond * 200)
+	if _, err := node1.KeysWithPrefix("user:", "", 0, time.Second); err != bigcache.ErrNotIndexed {
+		t.Errorf("expected a node without IndexKeys to fail the listing, got %v", err)
+	}
+}
+
+func TestClusterStatistics(t *testing.T) {
+	transport := comms.NewMemoryTransport()
+	node1 := New(&ClusteredBigCacheConfig{Id: "node_1", Join: false, LocalPort: 7221, ConnectRetries: 2, Transport: transport}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+	node2 := New(&ClusteredBigCacheConfig{Id: "node_2", Join: true, JoinIp: "localhost:7221", LocalPort: 7222, ConnectRetries: 2,
+		Transport: transport}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	client := NewPassiveClient("client", "localhost:7221", 7223, 5, 3, 10, nil)
+	client.config.Transport = transport
+	client.Start()
+	defer client.ShutDown()
+	time.Sleep(time.Millisecond * 200)
+
+	for x := 0; x < 10; x++ {
+		node1.Put("key_"+strconv.Itoa(x), []byte("value"), 0)
+	}
+	time.Sleep(time.Millisecond * 200)
+
+	report, err := client.ClusterStatistics(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Nodes) != 3 || report.Nodes[0].Id != "client" || report.Active != 2 || report.Passive != 1 || report.Failed != 0 {
+		t.Fatalf("expected the client and both nodes reported, got %+v", report)
+	}
+	if report.Cache.Entries != 20 || report.Cache.Shards != 32 {
+		t.Errorf("expected the caches of both nodes summed, got %d entries in %d shards", report.Cache.Entries, report.Cache.Shards)
+	}
+	for _, n := range report.Nodes[1:] {
+		if n.Statistics == nil || n.Statistics.Cache == nil || n.Statistics.Cache.Entries != 10 || len(n.Shards) != 16 {
+			t.Errorf("expected '%s' to report its 10 entries and 16 shards, got %+v", n.Id, n)
+		}
+	}
+}
+
+func TestPprofServer(t *testing.T) {
+	transport := comms.NewMemoryTransport()
+	node1 := New(&ClusteredBigCacheConfig{Id: "node_1", Join: false, LocalPort: 7241, ConnectRetries: 2, PprofPort: 7243,
+		Transport: transport}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+	node2 := New(&ClusteredBigCacheConfig{Id: "node_2", Join: true, JoinIp: "localhost:7241", LocalPort: 7242, ConnectRetries: 2,
+		Transport: transport}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 200)
+
+	get := func(path string) string {
+		rsp, err := http.Get("http://localhost:7243" + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rsp.Body.Close()
+		body, _ := ioutil.ReadAll(rsp.Body)
+		if rsp.StatusCode != http.StatusOK {
+			t.Errorf("expected %s served, got %d", path, rsp.StatusCode)
+		}
+		return string(body)
+	}
+
+	get("/debug/pprof/")
+	if dump := get("/debug/goroutines"); !strings.Contains(dump, `"remote_node":"node_2"`) || !strings.Contains(dump, `"task":"network sender"`) {
+		t.Errorf("expected the goroutines of node_2 labelled in the dump, got %s", dump)
+	}
+	var groups []RunGroupStats
+	if err := json.Unmarshal([]byte(get("/debug/run-groups")), &groups); err != nil || len(groups) != 1 || groups[0].Id != "node_2" ||
+		!groups[0].Connected || len(groups[0].Actors) != 7 {
+		t.Errorf("expected the 7 actors of node_2 running, got %+v %v", groups, err)
+	}
+	var queues QueueStats
+	if err := json.Unmarshal([]byte(get("/debug/queues")), &queues); err != nil || queues.Replication.Cap != CHAN_SIZE ||
+		queues.Outbound["node_2"].Cap != CHAN_SIZE {
+		t.Errorf("expected the depths of the queues, got %+v %v", queues, err)
+	}
+}
+
+func TestShutDownAndWait(t *testing.T) {
+	transport := comms.NewMemoryTransport()
+	node1 := New(&ClusteredBigCacheConfig{Id: "node_1", Join: false, LocalPort: 7251, ConnectRetries: 2, Transport: transport}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+	node2 := New(&ClusteredBigCacheConfig{Id: "node_2", Join: true, JoinIp: "localhost:7251", LocalPort: 7252, ConnectRetries: 2,
+		Transport: transport}, nil)
+	node2.Start()
+	time.Sleep(time.Millisecond * 200)
+
+	if err := node2.Put("key_1", []byte("data_1"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	node2.Delete("key_1") //still sent once shutting down began
+	node2.ShutDownAndWait()
+	if groups := node2.RunGroups(); len(groups) != 0 {
+		t.Errorf("expected no actor left running once shut down, got %+v", groups)
+	}
+
+	for x := 0; x < 20 && len(node1.RunGroups()) > 0; x++ {
+		time.Sleep(time.Millisecond * 50)
+	}
+	if groups := node1.RunGroups(); len(groups) != 0 {
+		t.Errorf("expected the remote node torn down once its connection closed, got %+v", groups)
+	}
+	if _, err := node1.Get("key_1", time.Millisecond*100); err == nil {
+		t.Error("expected the delete made before shutting down replicated")
+	}
+
+	//a node still trying to join gives up straight away
+	node3 := New(&ClusteredBigCacheConfig{Id: "node_3", Join: true, JoinIp: "localhost:7259", LocalPort: 7253, ConnectRetries: 100,
+		RetryInitialInterval: 5000, Transport: transport}, nil)
+	node3.Start()
+	start := time.Now()
+	node3.ShutDownAndWait()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the join given up on shutting down, took %s", elapsed)
+	}
+}
+
+func TestReplicationBackpressure(t *testing.T) {
+	transport := comms.NewMemoryTransport()
+	node1 := New(&ClusteredBigCacheConfig{Id: "node_1", Join: false, LocalPort: 7261, ConnectRetries: 2, Transport: transport}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+	node2 := New(&ClusteredBigCacheConfig{Id: "node_2", Join: true, JoinIp: "localhost:7261", LocalPort: 7262, ConnectRetries: 2,
+		ReplicationHighWaterMark: 5, WriteCoalesceInterval: 60000, Transport: transport}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 200)
+
+	if err := node2.Put("key_1", []byte("data_1"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	//a remote node far behind
+	r := node2.getRemoteNodes()[0].(*remoteNode)
+	atomic.AddInt64(&r.unsent, 100)
+	if err := node2.Put("key_2", []byte("data_2"), time.Minute); err != ErrReplicationBackpressure {
+		t.Errorf("expected a put refused over the high-water mark, got %v", err)
+	}
+	if _, err := node2.Get("key_2", time.Millisecond*100); err == nil {
+		t.Error("expected a refused put not stored")
+	}
+
+	if err := node2.PutUntil("key_2", []byte("data_2"), time.Now().Add(time.Minute)); err != ErrReplicationBackpressure {
+		t.Errorf("expected a put with an expiry time refused over the high-water mark, got %v", err)
+	}
+	if err := node2.PutCoalesced("key_2", []byte("data_2"), time.Minute); err != ErrReplicationBackpressure {
+		t.Errorf("expected a coalesced put refused over the high-water mark, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if err := node2.PutContext(ctx, "key_2", []byte("data_2"), time.Minute); err != context.DeadlineExceeded {
+		t.Errorf("expected a put held back until its context expired, got %v", err)
+	}
+
+	time.AfterFunc(time.Millisecond*100, func() { atomic.AddInt64(&r.unsent, -100) })
+	start := time.Now()
+	if err := node2.PutContext(context.Background(), "key_2", []byte("data_2"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond*50 {
+		t.Errorf("expected the put held back until the backlog drained, took %s", elapsed)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if data, err := node1.Get("key_2", time.Millisecond*100); err != nil || string(data) != "data_2" {
+		t.Errorf("expected the put held back replicated once let through, got '%s' %v", data, err)
+	}
+	if refused := node2.StatisticsStruct().Backpressured; refused != 4 {
+		t.Errorf("expected 4 puts counted as refused, got %d", refused)
+	}
+}
+
+func TestCounter(t *testing.T) {
+	transport := comms.NewMemoryTransport()
+	node1 := New(&ClusteredBigCacheConfig{Id: "node_1", Join: false, LocalPort: 7263, ConnectRetries: 2, Transport: transport}, nil)
+	node1.Start()
+	defer node1.ShutDown()
+	node2 := New(&ClusteredBigCacheConfig{Id: "node_2", Join: true, JoinIp: "localhost:7263", LocalPort: 7264, ConnectRetries: 2,
+		Transport: transport}, nil)
+	node2.Start()
+	defer node2.ShutDown()
+	client := NewPassiveClient("client_1", "localhost:7263", 7265, 5, 3, 10, nil)
+	client.config.Transport = transport
+	client.Start()
+	defer client.ShutDown()
+	time.Sleep(time.Millisecond * 300)
+
+	var wg sync.WaitGroup
+	for _, node := range []*ClusteredBigCache{node1, node2, client} {
+		wg.Add(1)
+		go func(node *ClusteredBigCache) {
+			defer wg.Done()
+			for x := 0; x < 50; x++ {
+				if err := node.CounterAdd("hits", 2, time.Minute); err != nil {
+					t.Errorf("expected the counter added to, got %v", err)
+				}
+			}
+		}(node)
+	}
+	wg.Wait()
+	time.Sleep(time.Millisecond * 300)
+
+	for _, node := range []*ClusteredBigCache{node1, node2, client} {
+		if value, err := node.CounterValue("hits", time.Millisecond*200); err != nil || value != 300 {
+			t.Errorf("expected every increment made on every node counted on %s, got %d %v", node.config.Id, value, err)
+		}
+	}
+	if ttl, err := node2.cache.TTL("hits"); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("expected the counter replicated with its expiry, got %s %v", ttl, err)
+	}
+}
+
+func TestIdleConnection(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Id: "node_1", Join: false, LocalPort: 0, LocalAddresses: []string{"127.0.0.1"}, ConnectRetries: 0}, nil)
+	if err := node1.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer node1.ShutDown()
+	port := strconv.Itoa(node1.LocalPort())
+
+	//one connection sends nothing, the other half a frame header, both must be let go once the first message is late
+	idle, err := net.Dial("tcp", "127.0.0.1:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	partial, err := net.Dial("tcp", "127.0.0.1:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer partial.Close()
+	partial.Write([]byte{0, 1, 0})
+
+	time.Sleep(time.Second * 6)
+	for _, conn := range []net.Conn{idle, partial} {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("node ought to hang up on a connection sending no message, got %v", err)
+		}
+	}
+
+	node2 := New(&ClusteredBigCacheConfig{Id: "node_2", Join: true, JoinIp: "127.0.0.1:" + port, LocalPort: 0,
+		LocalAddresses: []string{"127.0.0.1"}, ConnectRetries: 2}, nil)
+	if err := node2.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 300)
+	if len(node1.getRemoteNodes()) != 1 {
+		t.Error("node ought to still accept remote nodes after idle connections timed out")
+	}
+}
+
+func TestPutCoalescedInterleaved(t *testing.T) {
+	node1 := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 7271, ConnectRetries: 0}, nil)
+	node2 := New(&ClusteredBigCacheConfig{Join: true, LocalPort: 7272, JoinIp: "localhost:7271", ConnectRetries: 2,
+		WriteCoalesceInterval: 60000}, nil)
+
+	node1.Start()
+	node2.Start()
+	defer node1.ShutDown()
+	defer node2.ShutDown()
+	time.Sleep(time.Millisecond * 500)
+
+	node2.PutCoalesced("put", []byte("old"), time.Minute)
+	node2.PutCoalesced("deleted", []byte("old"), time.Minute)
+
+	//each key is written again while the flush is replicating its coalesced value
+	var writes sync.WaitGroup
+	send := node2.coalescer.send
+	node2.coalescer.send = func(key string, w *coalescedWrite) {
+		writes.Add(1)
+		go func() {
+			defer writes.Done()
+			if key == "put" {
+				node2.Put(key, []byte("new"), time.Minute)
+			} else {
+				node2.Delete(key)
+			}
+		}()
+		time.Sleep(time.Millisecond * 100)
+		send(key, w)
+	}
+	node2.coalescer.flush()
+	writes.Wait()
+	time.Sleep(time.Millisecond * 300)
+
+	if data, _ := node1.cache.Get("put"); string(data) != "new" {
+		t.Errorf("the put ought to win over the coalesced value flushed with it, got %q", data)
+	}
+	if _, err := node1.cache.Get("deleted"); err == nil {
+		t.Error("the coalesced value flushed with the delete ought not to bring the key back")
+	}
+}
+
+func TestShutDownAndWaitLeaks(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	transport := comms.NewMemoryTransport()
+	disc := &memDiscovery{peers: make(map[string]discovery.Peer)}
+	config := func(id string, port int) *ClusteredBigCacheConfig {
+		return &ClusteredBigCacheConfig{Id: id, LocalPort: port, ConnectRetries: 2, Transport: transport, Discovery: disc,
+			Instance: id, Expvar: true, DebugMode: true, DebugPort: port + 10, ProbePort: port + 20, PprofPort: port + 30,
+			WebSocketPort: port + 40, Gossip: true, GossipInterval: 50, WriteCoalesceInterval: 20, CompactInterval: 20,
+			EvictionBroadcast: EVICTION_BROADCAST_ALL, WriteThrottleThreshold: 1000, WarmUpPeriod: 60, PassiveIdleTimeout: 60,
+			ProfileDir: t.TempDir(), ProfileLatency: 60000, ChangelogSize: 100, ReplicationRetryQueue: 100,
+			ConnectionsPerNode: 2, AdaptivePing: true, DetectDivergence: true, LastWriteWins: true, ReadDeadline: 60000}
+	}
+	node1 := New(config("node_1", 7273), nil)
+	node2 := New(config("node_2", 7274), nil)
+	if err := node1.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := node2.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 500)
+
+	node1.SubscribeStats(time.Second, func(PeerStats) {})
+	for x := 0; x < 100; x++ {
+		node1.Put("key_"+strconv.Itoa(x), []byte("data"), time.Minute)
+		node2.PutCoalesced("counter", []byte(strconv.Itoa(x)), time.Minute)
+	}
+	node2.Get("key_1", time.Millisecond*200)
+	node1.SetReplicationFactor(2)
+
+	node1.ShutDownAndWait()
+	node2.ShutDownAndWait()
+	after := runtime.NumGoroutine()
+	for x := 0; x < 20 && after > before; x++ { //goroutines of the standard library, e.g of the http servers' listeners
+		time.Sleep(time.Millisecond * 50)
+		after = runtime.NumGoroutine()
+	}
+	if after > before {
+		buf := make([]byte, 1<<20)
+		t.Errorf("%d goroutines before starting, %d left once shut down\n%s", before, after, buf[:runtime.Stack(buf, true)])
+	}
+}
+
+func TestProposePeerAfterShutDown(t *testing.T) {
+	transport := comms.NewMemoryTransport()
+	node := New(&ClusteredBigCacheConfig{Id: "node_1", Join: false, LocalPort: 7275, ConnectRetries: 2, Transport: transport}, nil)
+	if err := node.Start(); err != nil {
+		t.Fatal(err)
+	}
+	node.ShutDown()
+
+	//the join queue full, a peer proposed now must not wait for connectToExistingNodes, which returned
+	for x := 0; x < cap(node.joinQueue); x++ {
+		node.joinQueue <- &message.ProposedPeer{Id: "node_" + strconv.Itoa(x)}
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		node.dial("node_2", "localhost:7276", clusterModeACTIVE)
+		node.redial("node_2", "localhost:7276", 0)
+		rn := newRemoteNode(&remoteNodeConfig{IpAddress: "localhost:7276"}, node, nil)
+		rn.handleSyncResponse((&message.SyncRspMessage{List: []message.ProposedPeer{{Id: "node_3"}}}).Serialize())
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("expected a peer proposed after shutting down given up on")
+	}
 }
@@ -0,0 +1,113 @@
+package cluster
+
+import (
+	"sync"
+	"time"
+)
+
+//a pending write waiting to be replicated
+type coalescedWrite struct {
+	data   []byte
+	expiry uint64
+	stamp  uint64
+}
+
+//writeCoalescer holds the latest value of keys written through PutCoalesced and replicates
+//them once every interval, so a key rewritten many times within an interval is only sent once
+type writeCoalescer struct {
+	node     *ClusteredBigCache
+	interval time.Duration
+	lock     sync.Mutex
+	pending  map[string]*coalescedWrite
+	flushing map[string]*coalescedWrite //taken by the flush under way, not replicated yet
+	flushes  sync.Mutex                 //one flush at a time
+	done     chan struct{}
+	wg       sync.WaitGroup
+
+	send func(key string, w *coalescedWrite) //replicates a flushed value, tests wrap it
+}
+
+func newWriteCoalescer(node *ClusteredBigCache, interval time.Duration) *writeCoalescer {
+	wc := &writeCoalescer{
+		node:     node,
+		interval: interval,
+		pending:  make(map[string]*coalescedWrite),
+		done:     make(chan struct{}),
+	}
+	wc.send = func(key string, w *coalescedWrite) {
+		node.replicatePut(key, w.data, w.expiry, w.stamp, "")
+	}
+
+	wc.wg.Add(1)
+	if !node.routines.spawn(wc.run) {
+		wc.wg.Done()
+	}
+	return wc
+}
+
+//remember the latest value of a key, replacing any value not yet replicated
+func (wc *writeCoalescer) put(key string, data []byte, expiry, stamp uint64) {
+	buf := make([]byte, len(data)) //the caller is free to reuse data once Put returns
+	copy(buf, data)
+
+	wc.lock.Lock()
+	wc.pending[key] = &coalescedWrite{data: buf, expiry: expiry, stamp: stamp}
+	wc.lock.Unlock()
+}
+
+//forget a pending value, used when the key is written or deleted through the normal path. a value the flush under
+//way already replicated was queued before discard returns, so the write replicated by the caller after lands last
+func (wc *writeCoalescer) discard(key string) {
+	wc.lock.Lock()
+	delete(wc.pending, key)
+	delete(wc.flushing, key)
+	wc.lock.Unlock()
+}
+
+//replicate every pending value. each is queued under the lock, unless discarded meanwhile, so a put or a delete
+//of the key can not slip in between and have its write overtaken by the older coalesced value
+func (wc *writeCoalescer) flush() {
+	wc.flushes.Lock()
+	defer wc.flushes.Unlock()
+
+	wc.lock.Lock()
+	wc.flushing = wc.pending
+	wc.pending = make(map[string]*coalescedWrite, len(wc.flushing))
+	keys := make([]string, 0, len(wc.flushing))
+	for key := range wc.flushing {
+		keys = append(keys, key)
+	}
+	wc.lock.Unlock()
+
+	for _, key := range keys {
+		wc.lock.Lock()
+		if w, ok := wc.flushing[key]; ok {
+			delete(wc.flushing, key)
+			wc.send(key, w)
+		}
+		wc.lock.Unlock()
+	}
+}
+
+//goroutine that periodically flushes pending writes
+func (wc *writeCoalescer) run() {
+	defer wc.wg.Done()
+
+	ticker := wc.node.clock.NewTicker(wc.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			wc.flush()
+		case <-wc.done:
+			wc.flush()
+			return
+		}
+	}
+}
+
+//stop the coalescer after replicating whatever is still pending
+func (wc *writeCoalescer) close() {
+	close(wc.done)
+	wc.wg.Wait()
+}
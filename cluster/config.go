@@ -1,16 +1,28 @@
 package cluster
 
-import "github.com/nggenius/ngbigcache/message"
+import (
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+	"github.com/nggenius/ngbigcache/message"
+)
 
 type getReplyData struct {
-	data []byte
+	data       []byte
+	peer       string
+	withExpiry bool
+	expiry     uint64
 }
 
 type getRequestData struct {
 	key       string
 	randStr   string
+	requestId string    //correlation id logged along with the request, empty when not logged
+	started   time.Time //when the remote nodes were first asked
 	replyChan chan *getReplyData
 	done      chan struct{}
+	replies   chan *getReplyData //every reply, including empty ones. only set when comparing replies for divergence
+	expiry    bool               //ask for the expiry of the key along with its value
 }
 
 type getRequestDataWrapper struct {
@@ -23,6 +35,24 @@ type replicationMsg struct {
 	m message.NodeMessage
 }
 
+//the Clock of the config, the system clock when not set
+func (config *ClusteredBigCacheConfig) clock() bigcache.Clock {
+	if config.Clock == nil {
+		return bigcache.SystemClock()
+	}
+	return config.Clock
+}
+
+//the ReadDeadline of the config as a duration
+func (config *ClusteredBigCacheConfig) readDeadline() time.Duration {
+	return time.Millisecond * time.Duration(config.ReadDeadline)
+}
+
+//the WriteDeadline of the config as a duration
+func (config *ClusteredBigCacheConfig) writeDeadline() time.Duration {
+	return time.Millisecond * time.Duration(config.WriteDeadline)
+}
+
 //DefaultClusterConfig creates a new default configuration
 func DefaultClusterConfig() *ClusteredBigCacheConfig {
 
@@ -36,5 +66,6 @@ func DefaultClusterConfig() *ClusteredBigCacheConfig {
 		WriteAck:                true,
 		ReplicationMode:         REPLICATION_MODE_FULL_REPLICATE,
 		ReconnectOnDisconnect:   false,
+		ConnectionsPerNode:      1,
 	}
 }
@@ -0,0 +1,92 @@
+package cluster
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+	"github.com/nggenius/ngbigcache/message"
+)
+
+//Entry is a value of a key along with the unix time it expires at, bigcache.NO_EXPIRY if it never does
+type Entry struct {
+	Data   []byte
+	Expiry uint64
+}
+
+//ConflictResolver picks the value a key ends up with when the local copy and a copy from a remote node
+//disagree. it is called when a replicated write meets a different local copy, when divergent reads are
+//repaired and when keys are handed over by a leaving node. it must be deterministic and not depend on the
+//order of local and remote, otherwise the nodes keep sending each other what they resolved
+type ConflictResolver func(key string, local, remote Entry) Entry
+
+func (e Entry) equal(other Entry) bool {
+	return e.Expiry == other.Expiry && bytes.Equal(e.Data, other.Data)
+}
+
+//resolve the copy of key from a remote node against the local copy. without a resolver, a local copy or a
+//difference between the two the remote copy is returned as is, and false tells the resolver was not called
+func (node *ClusteredBigCache) resolveConflict(key string, remote Entry) (Entry, bool) {
+	if node.config.ConflictResolver == nil || node.mode != clusterModeACTIVE {
+		return remote, false
+	}
+
+	data, expiry, err := node.cache.GetWithExpiry(key)
+	if err != nil {
+		return remote, false
+	}
+	local := Entry{Data: data, Expiry: expiry}
+	if local.equal(remote) {
+		return remote, false
+	}
+	return node.config.ConflictResolver(key, local, remote), true
+}
+
+//store entry locally keeping its absolute expiry. false if it had already expired or could not be stored
+func (node *ClusteredBigCache) storeEntry(key string, entry Entry) (bool, error) {
+	var err error
+	if entry.Expiry == bigcache.NO_EXPIRY {
+		_, err = node.cache.Set(key, entry.Data, 0)
+	} else if entry.Expiry > uint64(node.clock.Now().Unix()) {
+		_, err = node.cache.SetUntil(key, entry.Data, time.Unix(int64(entry.Expiry), 0))
+	} else {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	node.watchers.notify(key, false)
+	return true, nil
+}
+
+//read repair. every remote node whose copy of key differs from the local one has it resolved, the result
+//is stored locally and sent back to the remote node if it does not hold it already
+func (node *ClusteredBigCache) repairReplies(key string, replies []peerReply) {
+	for _, reply := range replies {
+		if len(reply.data) < 1 { //the remote node does not hold the key
+			continue
+		}
+		remote := Entry{Data: reply.data, Expiry: reply.expiry}
+		resolved, ok := node.resolveConflict(key, remote)
+		if !ok {
+			continue
+		}
+		unlock := node.versions.lockKey(key)
+		if _, err := node.storeEntry(key, resolved); err != nil {
+			node.internalError(&InternalError{Op: "read repair", NodeId: reply.peer, Key: key, Err: ErrReplicaWriteFailed, Cause: err})
+		}
+		stamp := node.versions.stamp(key, resolved.Expiry)
+		unlock()
+		if resolved.equal(remote) {
+			continue
+		}
+		if v, found := node.remoteNodes.Get(reply.peer); found {
+			peer := v.(*remoteNode)
+			msg := &message.PutMessage{Key: key, Data: resolved.Data, Expiry: resolved.Expiry}
+			if peer.version() >= message.MsgMinVersion(message.MsgPUTStamped) {
+				msg.Timestamp = stamp
+			}
+			node.queueReplication(&replicationMsg{r: peer, m: msg})
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package cluster
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"sync/atomic"
+
+	"github.com/nggenius/ngbigcache/comms"
+	"github.com/nggenius/ngbigcache/message"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//connLane is an additional connection to a remote node. the primary connection (the one used for verification)
+//is not a lane, it is always used directly by networkSender
+type connLane struct {
+	connection *comms.Connection
+	queue      chan *[]byte //frames from the buffer pool, given back once written
+	done       chan struct{}
+	closed     int32
+}
+
+//a secret the remote node is given on its verified connection, so an extra connection claiming to belong to it
+//can be told to come from it rather than from anyone knowing its id
+func newLaneToken() string {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "" //attaches nothing, every extra connection is refused
+	}
+	return hex.EncodeToString(token)
+}
+
+//whether token is the lane token issued to the remote node
+func (r *remoteNode) laneTokenMatches(token string) bool {
+	return r.laneToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(r.laneToken)) == 1
+}
+
+func newConnLane(conn *comms.Connection) *connLane {
+	return &connLane{
+		connection: conn,
+		queue:      make(chan *[]byte, CHAN_SIZE),
+		done:       make(chan struct{}),
+	}
+}
+
+//queue a frame to be written on this lane
+func (l *connLane) send(data *[]byte) {
+	select {
+	case <-l.done:
+	case l.queue <- data:
+	}
+}
+
+//shut the lane down. safe to call more than once
+func (l *connLane) close() {
+	if atomic.CompareAndSwapInt32(&l.closed, 0, 1) {
+		close(l.done)
+		l.connection.Close()
+	}
+}
+
+//pick the lane a message is to be sent on. messages about a key always go through the same connection
+//so that a put followed by a delete can not overtake each other. everything else goes through the
+//primary connection, signalled by a nil return
+func (r *remoteNode) pickLane(m message.NodeMessage) *connLane {
+	r.lanesLock.RLock()
+	defer r.lanesLock.RUnlock()
+
+	if len(r.lanes) == 0 {
+		return nil
+	}
+
+	var key string
+	switch msg := m.(type) {
+	case *message.PutMessage:
+		key = msg.Key
+	case *message.DeleteMessage:
+		key = msg.Key
+	case *message.GetReqMessage:
+		key = msg.Key
+	case *message.GetRspMessage:
+		key = msg.PendingKey
+	case *pooledGetRsp:
+		key = msg.PendingKey
+	default:
+		return nil
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	idx := int(h.Sum32() % uint32(len(r.lanes)+1))
+	if idx == 0 {
+		return nil
+	}
+
+	return r.lanes[idx-1]
+}
+
+//add a connection to this remote node and start reading and writing on it
+func (r *remoteNode) addLane(conn *comms.Connection) {
+	conn.SetDeadlines(r.config.ReadDeadline, r.config.WriteDeadline)
+	lane := newConnLane(conn)
+
+	r.lanesLock.Lock()
+	if r.lanesClosed {
+		r.lanesLock.Unlock()
+		conn.Close()
+		return
+	}
+	r.lanes = append(r.lanes, lane)
+	count := len(r.lanes)
+	r.lanesLock.Unlock()
+
+	r.parentNode.routines.spawn(func() { //writer
+		for {
+			select {
+			case <-lane.done:
+				return
+			case data := <-lane.queue:
+				err := lane.connection.SendData(*data)
+				putBuffer(data)
+				if err != nil {
+					utils.Critical(r.logger, fmt.Sprintf("unexpected error while sending data on extra connection to '%s' [%s]", r.config.Id, err))
+					r.shutDown()
+					return
+				}
+			}
+		}
+	})
+
+	r.parentNode.routines.spawn(func() { //reader
+		for {
+			msg, err := readFrame(lane.connection, 0, r.parentNode.maxFrameSize())
+			if err != nil {
+				break
+			}
+			r.queueInboundMessage(msg)
+		}
+		if atomic.LoadInt32(&lane.closed) == 0 { //the lane died on its own so the remote node is unreliable
+			utils.Critical(r.logger, fmt.Sprintf("extra connection to remote node '%s' has disconnected", r.config.Id))
+			r.shutDown()
+		}
+	})
+
+	utils.Info(r.logger, fmt.Sprintf("remote node '%s' now has %d connection(s)", r.config.Id, count+1))
+}
+
+//open the configured number of extra connections to the remote node
+func (r *remoteNode) openLanes() {
+	if r.config.Connections < 2 || r.version() < message.ProtocolVersion2 {
+		return
+	}
+	if r.peerLaneToken == "" {
+		utils.Warn(r.logger, fmt.Sprintf("remote node '%s' issued no lane token, not opening extra connections", r.config.Id))
+		return
+	}
+
+	attach := &message.AttachMessage{Id: r.parentNode.config.Id, Token: r.peerLaneToken}
+	for x := 1; x < r.config.Connections; x++ {
+		conn, err := r.parentNode.connect(r.config.IpAddress)
+		if err != nil {
+			utils.Error(r.logger, fmt.Sprintf("unable to open extra connection to '%s' [%s]", r.config.Id, err))
+			return
+		}
+
+		if err = conn.SendData(buildFrame(attach.Serialize())); err != nil {
+			conn.Close()
+			utils.Error(r.logger, fmt.Sprintf("unable to attach extra connection to '%s' [%s]", r.config.Id, err))
+			return
+		}
+		r.addLane(conn)
+	}
+}
+
+//close every extra connection
+func (r *remoteNode) closeLanes() {
+	r.lanesLock.Lock()
+	lanes := r.lanes
+	r.lanes = nil
+	r.lanesClosed = true
+	r.lanesLock.Unlock()
+
+	for _, lane := range lanes {
+		lane.close()
+	}
+}
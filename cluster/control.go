@@ -0,0 +1,45 @@
+package cluster
+
+import (
+	"github.com/nggenius/ngbigcache/message"
+)
+
+//control messages waiting to be sent to a remote node at most. they are a handful at a time, a ping and a pong
+//every ping interval and the handshake
+const controlQueueSize = 64
+
+//control messages keep a connection alive and verified. they go in lanes of their own, apart from the data
+//messages, and are sent and handled ahead of them so heavy traffic never delays a ping into a false failure
+func isControl(code uint16) bool {
+	switch code {
+	case message.MsgPING, message.MsgPONG, message.MsgVERIFY, message.MsgVERIFYOK:
+		return true
+	}
+	return false
+}
+
+//isControl for a message not serialized yet
+func isControlMessage(msg message.NodeMessage) bool {
+	switch msg.(type) {
+	case *message.PingMessage, *message.PongMessage, *message.VerifyMessage, *message.VerifyOKMessage:
+		return true
+	}
+	return false
+}
+
+//the next message to send to the remote node, waiting for one. a control message is taken ahead of every data
+//message waiting. false once the outbound queue is closed and empty
+func (r *remoteNode) nextOutbound() (message.NodeMessage, bool) {
+	select {
+	case m := <-r.controlMsgQueue:
+		return m, true
+	default:
+	}
+
+	select {
+	case m := <-r.controlMsgQueue:
+		return m, true
+	case m, ok := <-r.outboundMsgQueue:
+		return m, ok
+	}
+}
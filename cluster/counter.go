@@ -0,0 +1,93 @@
+package cluster
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+	"github.com/nggenius/ngbigcache/message"
+)
+
+//CounterAdd adds delta to the grow-only counter under key in the cluster, creating it if there is none. a new
+//counter expires after duration, an existing one keeps its expiry. every node keeps a count of its own of what
+//was added through it, the counts are sent to the remote nodes which merge them into their copy keeping the
+//larger count of each node. increments made at the same time on different nodes thus all add up without the
+//nodes coordinating as a strict increment would, and a copy that missed a merge catches up with the next one
+func (node *ClusteredBigCache) CounterAdd(key string, delta uint64, duration time.Duration) error {
+
+	if node.state != clusterStateStarted {
+		return ErrNotStarted
+	}
+	if err := node.admitWrite(); err != nil {
+		return err
+	}
+
+	//add locally first
+	expiryTime := bigcache.NO_EXPIRY
+	var counts map[string]uint64
+	if node.mode == clusterModeACTIVE {
+		if err := node.throttle.admit(); err != nil {
+			return err
+		}
+		var err error
+		if counts, err = node.cache.CounterAdd(key, node.config.Id, delta, duration); err != nil {
+			return err
+		}
+		if ttl, err := node.cache.TTL(key); err == nil && ttl != time.Duration(bigcache.NO_EXPIRY) {
+			expiryTime = uint64(node.clock.Now().Add(ttl).Unix())
+		}
+		node.watchers.notify(key, false)
+	} else {
+		if duration != time.Duration(bigcache.NO_EXPIRY) {
+			expiryTime = uint64(node.clock.Now().Unix()) + uint64(duration.Seconds())
+		}
+		counts = map[string]uint64{node.config.Id: node.addCount(key, delta)}
+	}
+	node.bandwidth.wrote(len(key) + 8)
+
+	msg := &message.CounterMessage{Key: key, Counts: counts, Expiry: expiryTime}
+	for _, peer := range node.activePeers() {
+		if peer.version() >= message.MsgMinVersion(message.MsgCOUNTER) {
+			node.queueReplication(&replicationMsg{r: peer, m: msg})
+		}
+	}
+	return nil
+}
+
+//CounterValue returns the value of the grow-only counter under key, the sum of the counts of every node merged
+//into the copy read. the counter is read like Get reads a key
+func (node *ClusteredBigCache) CounterValue(key string, timeout time.Duration) (uint64, error) {
+	data, err := node.Get(key, timeout)
+	if err != nil {
+		return 0, err
+	}
+	return bigcache.CounterSum(data)
+}
+
+//add delta to the count of this passive client in the counter under key, which it holds no copy of, and return
+//the count after
+func (node *ClusteredBigCache) addCount(key string, delta uint64) uint64 {
+	count, _ := node.counts.LoadOrStore(key, new(uint64))
+	return atomic.AddUint64(count.(*uint64), delta)
+}
+
+func (r *remoteNode) handleCounter(msg *message.NodeWireMessage) {
+
+	counterMsg := message.CounterMessage{}
+	counterMsg.DeSerialize(msg)
+	if !r.admitReplicatedWrite(msg.Code, counterMsg.Key) || !r.admitThrottledWrite(msg.Code, counterMsg.Key) {
+		return
+	}
+
+	var err error
+	if counterMsg.Expiry == bigcache.NO_EXPIRY {
+		_, err = r.parentNode.cache.CounterMerge(counterMsg.Key, counterMsg.Counts, 0)
+	} else { //the expiry is an absolute time so keep it as is rather than recomputing a duration
+		_, err = r.parentNode.cache.CounterMergeUntil(counterMsg.Key, counterMsg.Counts, time.Unix(int64(counterMsg.Expiry), 0))
+	}
+	if err != nil {
+		r.replicaWriteFailed("replicate counter", counterMsg.Key, err)
+		return
+	}
+	r.parentNode.watchers.notify(counterMsg.Key, false)
+}
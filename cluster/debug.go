@@ -0,0 +1,196 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	runtimepprof "runtime/pprof"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//host the admin, probe and pprof servers listen on unless DebugBindAddress says otherwise, so they are not reachable
+//from other hosts by default
+const defaultDebugBindAddress = "127.0.0.1"
+
+//runGroups keeps the actors of the run.Group of every remote node that are still running. a remote node stays
+//listed until every one of its actors returned, even once it is no longer connected, so goroutines left behind
+//by a connection that went away show on the pprof server
+type runGroups struct {
+	lock    sync.Mutex
+	running map[*remoteNode]map[string]time.Time //when each actor started, by task
+}
+
+//RunGroupStats is the actors of the run.Group of a remote node still running
+type RunGroupStats struct {
+	Id        string               `json:"id"`
+	Address   string               `json:"address"`
+	Connected bool                 `json:"connected"` //false for a remote node removed while some of its actors still run
+	Actors    map[string]time.Time `json:"actors"`    //when each actor still running started, by task
+}
+
+//QueueDepth is the number of items in a queue and the number it holds at most
+type QueueDepth struct {
+	Len int `json:"len"`
+	Cap int `json:"cap"`
+}
+
+//QueueStats is the depths of the queues of a node and of those of its remote nodes
+type QueueStats struct {
+	Replication QueueDepth            `json:"replication"`
+	GetRequests QueueDepth            `json:"get_requests"`
+	Joins       QueueDepth            `json:"joins"`
+	Inbound     map[string]QueueDepth `json:"inbound"`  //messages received not handled yet, by id of the remote node
+	Outbound    map[string]QueueDepth `json:"outbound"` //messages not sent yet, by id of the remote node
+	Control     map[string]QueueDepth `json:"control"`  //control messages not sent yet, by id of the remote node
+}
+
+func newRunGroups() *runGroups {
+	return &runGroups{running: make(map[*remoteNode]map[string]time.Time)}
+}
+
+func (g *runGroups) started(r *remoteNode, task string, at time.Time) {
+	if g == nil { //a remote node of a node not created by New
+		return
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.running[r] == nil {
+		g.running[r] = make(map[string]time.Time)
+	}
+	g.running[r][task] = at
+}
+
+func (g *runGroups) stopped(r *remoteNode, task string) {
+	if g == nil {
+		return
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	delete(g.running[r], task)
+	if len(g.running[r]) == 0 {
+		delete(g.running, r)
+	}
+}
+
+//RunGroups returns the actors of the run.Group of every remote node still running, by id of the remote node
+func (node *ClusteredBigCache) RunGroups() []RunGroupStats {
+	connected := make(map[*remoteNode]bool)
+	for _, v := range node.getRemoteNodes() {
+		connected[v.(*remoteNode)] = true
+	}
+
+	g := node.runGroups
+	if g == nil {
+		return nil
+	}
+	g.lock.Lock()
+	stats := make([]RunGroupStats, 0, len(g.running))
+	for r, running := range g.running {
+		actors := make(map[string]time.Time, len(running))
+		for task, at := range running {
+			actors[task] = at
+		}
+		stats = append(stats, RunGroupStats{Id: r.config.Id, Address: r.config.IpAddress, Connected: connected[r], Actors: actors})
+	}
+	g.lock.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Id < stats[j].Id })
+	return stats
+}
+
+//Queues returns the depths of the queues of the node and of those of its remote nodes
+func (node *ClusteredBigCache) Queues() QueueStats {
+	stats := QueueStats{
+		Replication: QueueDepth{Len: node.replicationMsgs.len(), Cap: node.replicationMsgs.cap()},
+		GetRequests: QueueDepth{Len: len(node.getRequestChan), Cap: cap(node.getRequestChan)},
+		Joins:       QueueDepth{Len: len(node.joinQueue), Cap: cap(node.joinQueue)},
+		Inbound:     make(map[string]QueueDepth),
+		Outbound:    make(map[string]QueueDepth),
+		Control:     make(map[string]QueueDepth),
+	}
+	for _, v := range node.getRemoteNodes() {
+		r := v.(*remoteNode)
+		stats.Inbound[r.config.Id] = QueueDepth{Len: r.inboundMsgQueue.len(), Cap: r.inboundMsgQueue.cap()}
+		stats.Outbound[r.config.Id] = QueueDepth{Len: len(r.outboundMsgQueue), Cap: cap(r.outboundMsgQueue)}
+		stats.Control[r.config.Id] = QueueDepth{Len: len(r.controlMsgQueue), Cap: cap(r.controlMsgQueue)}
+	}
+	return stats
+}
+
+//an actor of the run.Group of this remoteNode, running execute with its goroutine labelled with the id of the
+//remote node and the task so goroutine dumps tell which remote node every goroutine serves
+func (r *remoteNode) actor(task string, execute func() error) func() error {
+	return func() (err error) {
+		groups := r.parentNode.runGroups
+		groups.started(r, task, r.parentNode.clock.Now())
+		defer groups.stopped(r, task)
+
+		labels := runtimepprof.Labels("remote_node", r.config.Id, "task", task)
+		runtimepprof.Do(context.Background(), labels, func(context.Context) {
+			err = execute()
+		})
+		return err
+	}
+}
+
+//address a debug server listens on, port on DebugBindAddress
+func (node *ClusteredBigCache) debugAddress(port int) string {
+	host := node.config.DebugBindAddress
+	if host == "" {
+		host = defaultDebugBindAddress
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+//bring up the http server serving net/http/pprof, goroutine dumps and the depths of the queues on the pprof port
+func (node *ClusteredBigCache) startPprofServer() error {
+	listener, err := net.Listen("tcp", node.debugAddress(node.config.PprofPort))
+	if err != nil {
+		utils.Error(node.logger, fmt.Sprintf("unable to start pprof server on port %d. [%s]", node.config.PprofPort, err.Error()))
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/goroutines", handleGoroutines)
+	mux.HandleFunc("/debug/run-groups", node.handleRunGroups)
+	mux.HandleFunc("/debug/queues", node.handleQueues)
+	node.pprofServer = &http.Server{Handler: mux}
+
+	node.serve(node.pprofServer, listener)
+	utils.Info(node.logger, fmt.Sprintf("pprof server listening on %s", listener.Addr().String()))
+	return nil
+}
+
+//serve a dump of every goroutine, grouped by stack along with the labels of the actors of the remote nodes.
+//?debug=2 dumps every goroutine on its own, as an unrecovered panic does, but without the labels
+func handleGoroutines(w http.ResponseWriter, req *http.Request) {
+	debug, err := strconv.Atoi(req.URL.Query().Get("debug"))
+	if err != nil || debug < 1 {
+		debug = 1
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	runtimepprof.Lookup("goroutine").WriteTo(w, debug)
+}
+
+func (node *ClusteredBigCache) handleRunGroups(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(node.RunGroups())
+}
+
+func (node *ClusteredBigCache) handleQueues(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(node.Queues())
+}
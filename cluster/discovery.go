@@ -0,0 +1,88 @@
+package cluster
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/nggenius/ngbigcache/discovery"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//the address other nodes are told to connect to this node on
+func (node *ClusteredBigCache) advertisedAddress() string {
+	port := node.config.LocalPort
+	if node.config.AdvertisedPort > 0 {
+		port = node.config.AdvertisedPort
+	}
+
+	host := node.config.AdvertisedHost
+	if "" == host {
+		for _, address := range node.listenAddresses() {
+			h, _, _ := net.SplitHostPort(address)
+			if ip := net.ParseIP(h); h != "" && (ip == nil || !ip.IsUnspecified()) {
+				host = h
+				break
+			}
+		}
+	}
+	if "" == host { //listening on every interface
+		host, _ = os.Hostname()
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+//announce this node to the discovery backend and connect to the peers it knows about as they come and go
+func (node *ClusteredBigCache) startDiscovery() error {
+	self := discovery.Peer{Id: node.config.Id, Address: node.advertisedAddress()}
+	if node.mode == clusterModeACTIVE { //passive clients hold no data so they are not announced
+		if err := node.config.Discovery.Register(self); err != nil {
+			utils.Error(node.logger, fmt.Sprintf("unable to register with discovery. [%s]", err.Error()))
+			return err
+		}
+		utils.Info(node.logger, fmt.Sprintf("registered with discovery as %s", self.Address))
+	}
+
+	node.discoveryDone = make(chan struct{})
+	peers, err := node.config.Discovery.Watch(node.discoveryDone)
+	if err != nil {
+		utils.Error(node.logger, fmt.Sprintf("unable to watch discovery. [%s]", err.Error()))
+		return err
+	}
+
+	node.routines.spawn(func() { node.connectToDiscoveredPeers(peers) })
+	return nil
+}
+
+func (node *ClusteredBigCache) connectToDiscoveredPeers(peers <-chan []discovery.Peer) {
+	for {
+		select {
+		case list, ok := <-peers:
+			if !ok {
+				return
+			}
+			for _, peer := range list {
+				node.dial(peer.Id, peer.Address, clusterModeACTIVE) //only active nodes are announced
+			}
+		case <-node.ctx.Done():
+			return
+		}
+	}
+}
+
+//stop watching the discovery backend and withdraw this node's announcement
+func (node *ClusteredBigCache) stopDiscovery() {
+	if node.discoveryDone == nil {
+		return
+	}
+
+	close(node.discoveryDone)
+	if node.mode == clusterModeACTIVE {
+		self := discovery.Peer{Id: node.config.Id, Address: node.advertisedAddress()}
+		if err := node.config.Discovery.Deregister(self); err != nil {
+			utils.Warn(node.logger, fmt.Sprintf("unable to deregister from discovery. [%s]", err.Error()))
+		}
+	}
+}
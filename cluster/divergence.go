@@ -0,0 +1,205 @@
+package cluster
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//keys are grouped for divergence statistics by everything before the first separator
+	divergencePrefixSeparator = ":"
+	//default number of compared reads the divergence rate is computed over
+	defaultDivergenceWindow = 100
+)
+
+//DivergenceCount is the number of compared reads and how many of them were divergent
+type DivergenceCount struct {
+	Reads     uint64 `json:"reads"`
+	Divergent uint64 `json:"divergent"`
+}
+
+//DivergenceStats is the breakdown of divergent reads by key prefix and by peer
+type DivergenceStats struct {
+	Total    DivergenceCount            `json:"total"`
+	ByPrefix map[string]DivergenceCount `json:"by_prefix"`
+	ByPeer   map[string]DivergenceCount `json:"by_peer"`
+	Alarmed  bool                       `json:"alarmed"`
+}
+
+//one reply to a compared read
+type peerReply struct {
+	peer   string
+	data   []byte
+	expiry uint64
+}
+
+//divergenceTracker counts reads where the peers did not agree on the value of a key
+type divergenceTracker struct {
+	lock      sync.Mutex
+	threshold float64
+	window    int
+	total     DivergenceCount
+	byPrefix  map[string]*DivergenceCount
+	byPeer    map[string]*DivergenceCount
+	current   DivergenceCount //counts of the window being filled
+	alarmed   bool
+}
+
+func newDivergenceTracker(threshold float64, window int) *divergenceTracker {
+	if window < 1 {
+		window = defaultDivergenceWindow
+	}
+
+	return &divergenceTracker{
+		threshold: threshold,
+		window:    window,
+		byPrefix:  make(map[string]*DivergenceCount),
+		byPeer:    make(map[string]*DivergenceCount),
+	}
+}
+
+func keyPrefix(key string) string {
+	if idx := strings.Index(key, divergencePrefixSeparator); idx >= 0 {
+		return key[:idx]
+	}
+	return ""
+}
+
+func countFor(counts map[string]*DivergenceCount, name string) *DivergenceCount {
+	c, ok := counts[name]
+	if !ok {
+		c = &DivergenceCount{}
+		counts[name] = c
+	}
+	return c
+}
+
+//the value replies are compared against. the local value wins if there is one, otherwise it is the value
+//most peers replied with
+func referenceValue(local []byte, hasLocal bool, replies []peerReply) []byte {
+	if hasLocal {
+		return local
+	}
+
+	var ref []byte
+	best := 0
+	for x := range replies {
+		count := 0
+		for y := range replies {
+			if bytes.Equal(replies[x].data, replies[y].data) {
+				count++
+			}
+		}
+		if count > best {
+			best = count
+			ref = replies[x].data
+		}
+	}
+	return ref
+}
+
+//record the replies of one compared read. when a window is complete, the event to raise (if any)
+//is returned along with the divergence rate of that window
+func (d *divergenceTracker) record(key string, local []byte, hasLocal bool, replies []peerReply) (EventType, float64) {
+	ref := referenceValue(local, hasLocal, replies)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	divergent := false
+	for _, reply := range replies {
+		c := countFor(d.byPeer, reply.peer)
+		c.Reads++
+		if !bytes.Equal(reply.data, ref) {
+			c.Divergent++
+			divergent = true
+		}
+	}
+
+	prefix := countFor(d.byPrefix, keyPrefix(key))
+	prefix.Reads++
+	d.total.Reads++
+	d.current.Reads++
+	if divergent {
+		prefix.Divergent++
+		d.total.Divergent++
+		d.current.Divergent++
+	}
+
+	if d.current.Reads < uint64(d.window) {
+		return 0, 0
+	}
+
+	rate := float64(d.current.Divergent) / float64(d.current.Reads)
+	d.current = DivergenceCount{}
+	if d.threshold <= 0 {
+		return 0, rate
+	}
+
+	if rate >= d.threshold && !d.alarmed {
+		d.alarmed = true
+		return EventDivergenceAlarm, rate
+	}
+	if rate < d.threshold && d.alarmed {
+		d.alarmed = false
+		return EventDivergenceCleared, rate
+	}
+	return 0, rate
+}
+
+//copy of the statistics gathered so far
+func (d *divergenceTracker) stats() DivergenceStats {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	s := DivergenceStats{
+		Total:    d.total,
+		ByPrefix: make(map[string]DivergenceCount, len(d.byPrefix)),
+		ByPeer:   make(map[string]DivergenceCount, len(d.byPeer)),
+		Alarmed:  d.alarmed,
+	}
+	for k, v := range d.byPrefix {
+		s.ByPrefix[k] = *v
+	}
+	for k, v := range d.byPeer {
+		s.ByPeer[k] = *v
+	}
+	return s
+}
+
+//wait for every peer asked about the key to reply, then compare the replies. peers that do not reply
+//within the timeout are left out of the comparison
+func (node *ClusteredBigCache) compareReplies(key string, local []byte, hasLocal bool, reqData *getRequestData, expected int, timeout time.Duration) {
+	replies := make([]peerReply, 0, expected)
+	timer := node.clock.NewTimer(timeout)
+	defer timer.Stop()
+
+	for len(replies) < expected {
+		select {
+		case reply := <-reqData.replies:
+			replies = append(replies, peerReply{peer: reply.peer, data: reply.data, expiry: reply.expiry})
+		case <-timer.C():
+			expected = len(replies)
+		}
+	}
+
+	if len(replies) < 1 {
+		return
+	}
+
+	event, rate := node.divergence.record(key, local, hasLocal, replies)
+	if event != 0 {
+		node.emitEvent(event, fmt.Sprintf("%.1f%% of the last %d compared reads were divergent", rate*100, node.divergence.window))
+	}
+	if hasLocal {
+		node.repairReplies(key, replies)
+	}
+}
+
+//DivergenceStats returns the number of divergent reads seen while DetectDivergence is enabled
+func (node *ClusteredBigCache) DivergenceStats() DivergenceStats {
+	return node.divergence.stats()
+}
@@ -0,0 +1,43 @@
+package cluster
+
+import "testing"
+
+func TestDivergenceTracker(t *testing.T) {
+	tracker := newDivergenceTracker(0.5, 4)
+	same := []peerReply{{peer: "a", data: []byte("v1")}, {peer: "b", data: []byte("v1")}}
+	diff := []peerReply{{peer: "a", data: []byte("v1")}, {peer: "b", data: []byte("v2")}}
+
+	for x := 0; x < 3; x++ {
+		if event, _ := tracker.record("user:1", nil, false, same); event != 0 {
+			t.Error("no event ought to be raised before the window is complete")
+		}
+	}
+	if event, _ := tracker.record("user:2", []byte("v1"), true, diff); event != 0 {
+		t.Error("a divergence rate under the threshold ought not to raise an alarm")
+	}
+
+	for x := 0; x < 3; x++ {
+		tracker.record("session:1", []byte("v1"), true, diff)
+	}
+	if event, rate := tracker.record("session:2", []byte("v1"), true, diff); event != EventDivergenceAlarm || rate != 1 {
+		t.Errorf("a divergence rate over the threshold ought to raise an alarm, got %v at %v", event, rate)
+	}
+
+	for x := 0; x < 3; x++ {
+		tracker.record("user:1", nil, false, same)
+	}
+	if event, _ := tracker.record("user:1", nil, false, same); event != EventDivergenceCleared {
+		t.Error("the alarm ought to be cleared once the divergence rate falls under the threshold")
+	}
+
+	stats := tracker.stats()
+	if stats.Total.Reads != 12 || stats.Total.Divergent != 5 {
+		t.Errorf("unexpected totals %+v", stats.Total)
+	}
+	if stats.ByPrefix["session"].Divergent != 4 || stats.ByPrefix["user"].Divergent != 1 {
+		t.Errorf("unexpected prefix breakdown %+v", stats.ByPrefix)
+	}
+	if stats.ByPeer["b"].Divergent != 5 || stats.ByPeer["a"].Divergent != 0 {
+		t.Errorf("unexpected peer breakdown %+v", stats.ByPeer)
+	}
+}
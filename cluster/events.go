@@ -0,0 +1,80 @@
+package cluster
+
+import "time"
+
+//EventType identifies the kind of cluster event
+type EventType int
+
+const (
+	//EventDivergenceAlarm is raised when the rate of divergent reads crosses DivergenceThreshold
+	EventDivergenceAlarm EventType = iota + 1
+	//EventDivergenceCleared is raised when the rate of divergent reads falls back under DivergenceThreshold
+	EventDivergenceCleared
+	//EventThrottleOn is raised when writes start being throttled because the cache is out of space
+	EventThrottleOn
+	//EventThrottleOff is raised when writes are no longer throttled
+	EventThrottleOff
+	//EventMemberAlive is raised when gossip shows a member that was suspected or dead is alive
+	EventMemberAlive
+	//EventMemberSuspect is raised when a member is suspected to have failed
+	EventMemberSuspect
+	//EventMemberDead is raised when a member did not refute its suspicion in time or left the cluster
+	EventMemberDead
+	//EventQuorumLost is raised when the node sees fewer nodes than MinimumClusterSize
+	EventQuorumLost
+	//EventQuorumRegained is raised when the node sees MinimumClusterSize nodes again
+	EventQuorumRegained
+	//EventWarmedUp is raised when a node started with a cold cache is done warming up
+	EventWarmedUp
+	//EventPeerAddressChanged is raised when a peer addressed by hostname is found at other addresses than before
+	EventPeerAddressChanged
+	//EventProfileCaptured is raised when the auto-profiler saved a profile of the node after a latency spike
+	EventProfileCaptured
+)
+
+//Event is something that happened in the cluster which the application might want to act on
+type Event struct {
+	Type    EventType
+	NodeId  string
+	Message string
+	Time    time.Time
+}
+
+func (t EventType) String() string {
+	switch t {
+	case EventDivergenceAlarm:
+		return "divergenceAlarm"
+	case EventDivergenceCleared:
+		return "divergenceCleared"
+	case EventThrottleOn:
+		return "throttleOn"
+	case EventThrottleOff:
+		return "throttleOff"
+	case EventMemberAlive:
+		return "memberAlive"
+	case EventMemberSuspect:
+		return "memberSuspect"
+	case EventMemberDead:
+		return "memberDead"
+	case EventQuorumLost:
+		return "quorumLost"
+	case EventQuorumRegained:
+		return "quorumRegained"
+	case EventWarmedUp:
+		return "warmedUp"
+	case EventPeerAddressChanged:
+		return "peerAddressChanged"
+	case EventProfileCaptured:
+		return "profileCaptured"
+	}
+	return "unknown"
+}
+
+//hand an event over to the application, if it asked for events
+func (node *ClusteredBigCache) emitEvent(eventType EventType, msg string) {
+	if node.config.OnEvent == nil {
+		return
+	}
+
+	node.config.OnEvent(Event{Type: eventType, NodeId: node.config.Id, Message: msg, Time: node.clock.Now()})
+}
@@ -0,0 +1,200 @@
+package cluster
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+	"github.com/nggenius/ngbigcache/message"
+)
+
+//Which evictions from the local cache are broadcast to the remote nodes for them to delete their copies
+const (
+	EVICTION_BROADCAST_OFF      byte = iota //every node evicts on its own, the others keep serving their copies
+	EVICTION_BROADCAST_NO_SPACE             //entries evicted to make room within HardMaxCacheSize
+	EVICTION_BROADCAST_ALL                  //those and the entries which expired
+)
+
+const (
+	defaultEvictionBatchInterval = time.Millisecond * 100
+	maxEvictionBatch             = 1000   //keys sent in a message at most
+	maxPendingEvictions          = 100000 //keys waiting to be sent at most, those evicted past it are not broadcast
+)
+
+//EvictionStats tells how many evictions were broadcast to the remote nodes and how many of theirs were applied
+type EvictionStats struct {
+	Pending  int    `json:"pending"`  //keys evicted waiting for the next batch
+	Sent     uint64 `json:"sent"`     //keys broadcast
+	Dropped  uint64 `json:"dropped"`  //keys not broadcast for too many waiting already
+	Received uint64 `json:"received"` //keys deleted as remote nodes evicted them
+}
+
+//evictionBroadcaster batches the keys the local cache evicts and sends them to the remote nodes once every
+//interval, so a burst of evictions makes few messages
+type evictionBroadcaster struct {
+	node     *ClusteredBigCache //set once the node is created, the cache evicting before
+	mode     byte
+	interval time.Duration
+	lock     sync.Mutex
+	pending  map[string]struct{}
+	sent     uint64
+	dropped  uint64
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+//the broadcaster of the configuration, nil when evictions are not broadcast
+func newEvictionBroadcaster(config *ClusteredBigCacheConfig) *evictionBroadcaster {
+	if config.EvictionBroadcast == EVICTION_BROADCAST_OFF {
+		return nil
+	}
+
+	interval := time.Millisecond * time.Duration(config.EvictionBatchInterval)
+	if interval <= 0 {
+		interval = defaultEvictionBatchInterval
+	}
+	return &evictionBroadcaster{
+		mode:     config.EvictionBroadcast,
+		interval: interval,
+		pending:  make(map[string]struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+//have the cache tell the broadcaster about the entries it removes, along with the callbacks set by ConfigureCache
+func (b *evictionBroadcaster) hook(cfg *bigcache.Config) {
+	if b == nil {
+		return
+	}
+
+	onRemove, onRemoveWithReason := cfg.OnRemove, cfg.OnRemoveWithReason
+	cfg.OnRemoveWithReason = func(key string, entry []byte, reason bigcache.RemoveReason) {
+		if onRemoveWithReason != nil {
+			onRemoveWithReason(key, entry, reason)
+		} else if onRemove != nil {
+			onRemove(key, entry)
+		}
+		b.evicted(key, reason)
+	}
+}
+
+//queue a key removed from the local cache when it is an eviction to broadcast. called with the lock of the
+//shard held so it must not block
+func (b *evictionBroadcaster) evicted(key string, reason bigcache.RemoveReason) {
+	if reason != bigcache.NoSpace && (reason != bigcache.Expired || b.mode != EVICTION_BROADCAST_ALL) {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(b.pending) >= maxPendingEvictions {
+		b.dropped++
+		return
+	}
+	b.pending[key] = struct{}{}
+}
+
+//forget a pending eviction of a key written again since, its new value must not be deleted by the remote nodes
+func (b *evictionBroadcaster) discard(key string) {
+	if b == nil {
+		return
+	}
+
+	b.lock.Lock()
+	delete(b.pending, key)
+	b.lock.Unlock()
+}
+
+func (b *evictionBroadcaster) start(node *ClusteredBigCache) {
+	if b == nil {
+		return
+	}
+
+	b.node = node
+	b.wg.Add(1)
+	if !node.routines.spawn(b.run) {
+		b.wg.Done()
+	}
+}
+
+//goroutine sending the pending evictions once every interval
+func (b *evictionBroadcaster) run() {
+	defer b.wg.Done()
+
+	ticker := b.node.clock.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			b.flush()
+		case <-b.done:
+			return
+		}
+	}
+}
+
+//send the pending evictions to the active remote nodes, in batches of at most maxEvictionBatch keys
+func (b *evictionBroadcaster) flush() {
+	b.lock.Lock()
+	if len(b.pending) == 0 {
+		b.lock.Unlock()
+		return
+	}
+	keys := make([]string, 0, len(b.pending))
+	for key := range b.pending {
+		keys = append(keys, key)
+	}
+	b.pending = make(map[string]struct{})
+	b.sent += uint64(len(keys))
+	b.lock.Unlock()
+
+	for _, peer := range b.node.activePeers() {
+		if peer.version() < message.MsgMinVersion(message.MsgEVICT) {
+			continue
+		}
+		for x := 0; x < len(keys); x += maxEvictionBatch {
+			end := x + maxEvictionBatch
+			if end > len(keys) {
+				end = len(keys)
+			}
+			peer.sendMessage(&message.EvictMessage{Keys: keys[x:end]})
+		}
+	}
+}
+
+func (b *evictionBroadcaster) close() {
+	if b != nil && b.node != nil {
+		close(b.done)
+		b.wg.Wait()
+	}
+}
+
+//EvictionStats returns how many evictions were broadcast to the remote nodes, none unless EvictionBroadcast is
+//set, and how many of theirs were applied
+func (node *ClusteredBigCache) EvictionStats() EvictionStats {
+	stats := EvictionStats{Received: atomic.LoadUint64(&node.evictedByPeers)}
+	if b := node.evictions; b != nil {
+		b.lock.Lock()
+		stats.Pending, stats.Sent, stats.Dropped = len(b.pending), b.sent, b.dropped
+		b.lock.Unlock()
+	}
+	return stats
+}
+
+//the remote node evicted keys, delete the local copies. they are not broadcast again as they are deleted
+//rather than evicted
+func (r *remoteNode) handleEvict(msg *message.NodeWireMessage) {
+	evictMsg := message.EvictMessage{}
+	evictMsg.DeSerialize(msg)
+	if len(evictMsg.Keys) == 0 || r.parentNode.mode != clusterModeACTIVE || !r.admitReplicatedWrite(msg.Code, evictMsg.Keys[0]) {
+		return
+	}
+
+	for _, key := range evictMsg.Keys {
+		r.parentNode.cache.Delete(key)
+		r.parentNode.versions.forget(key)
+		r.parentNode.watchers.notify(key, true)
+	}
+	atomic.AddUint64(&r.parentNode.evictedByPeers, uint64(len(evictMsg.Keys)))
+}
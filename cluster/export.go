@@ -0,0 +1,171 @@
+package cluster
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+)
+
+//Formats Export writes keys in
+const (
+	EXPORT_FORMAT_JSON   byte = iota //a json object per line, ExportedEntry, for standard tools to read
+	EXPORT_FORMAT_BINARY             //length prefixed keys and values, compact and quick to read back
+)
+
+//the first bytes of an export in the binary format, which Import tells the formats apart by. the last byte is
+//the version of the format
+var exportMagic = []byte("NGBX\x01")
+
+//largest key or value read back from an export in the binary format
+const maxExportedField = 1 << 30
+
+//ErrUnknownExportFormat is returned by Export for a format it does not know
+var ErrUnknownExportFormat = errors.New("unknown export format")
+
+//ExportedEntry is a key as Export writes it in the json format
+type ExportedEntry struct {
+	Key    string `json:"key"`
+	Value  []byte `json:"value"`            //base64 encoded
+	Expiry uint64 `json:"expiry,omitempty"` //unix time the key expires at, omitted when it never does
+}
+
+//Export writes every key of the local cache to w in format along with its value and the time it expires at,
+//returning the number of keys written. expired keys are left out. passive clients hold no keys
+func (node *ClusteredBigCache) Export(w io.Writer, format byte) (int, error) {
+	if node.state != clusterStateStarted {
+		return 0, ErrNotStarted
+	}
+
+	buf := bufio.NewWriter(w)
+	var write func(key string, data []byte, expiry uint64) error
+	switch format {
+	case EXPORT_FORMAT_JSON:
+		encoder := json.NewEncoder(buf)
+		write = func(key string, data []byte, expiry uint64) error {
+			return encoder.Encode(&ExportedEntry{Key: key, Value: data, Expiry: expiry})
+		}
+	case EXPORT_FORMAT_BINARY:
+		if _, err := buf.Write(exportMagic); err != nil {
+			return 0, err
+		}
+		scratch := make([]byte, binary.MaxVarintLen64)
+		write = func(key string, data []byte, expiry uint64) error {
+			for _, field := range [][]byte{[]byte(key), data} {
+				buf.Write(scratch[:binary.PutUvarint(scratch, uint64(len(field)))])
+				buf.Write(field)
+			}
+			_, err := buf.Write(scratch[:binary.PutUvarint(scratch, expiry)])
+			return err
+		}
+	default:
+		return 0, ErrUnknownExportFormat
+	}
+
+	count := 0
+	if node.mode == clusterModeACTIVE {
+		now := uint64(node.clock.Now().Unix())
+		for it := node.cache.Iterator(); it.SetNext(); {
+			entry, err := it.Value()
+			if err != nil { //removed while iterating
+				continue
+			}
+			data, expiry, err := node.cache.GetWithExpiry(entry.Key())
+			if err != nil || (expiry != bigcache.NO_EXPIRY && expiry <= now) {
+				continue
+			}
+			if err = write(entry.Key(), data, expiry); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+	return count, buf.Flush()
+}
+
+//Import puts every key read from r, written by Export in either format, into the cluster with the time it
+//expires at. keys that expired since they were exported are skipped. the number of keys put is returned, along
+//with the first error reading r or putting a key, which stops the import
+func (node *ClusteredBigCache) Import(r io.Reader) (int, error) {
+	if node.state != clusterStateStarted {
+		return 0, ErrNotStarted
+	}
+
+	buf := bufio.NewReader(r)
+	next := nextJSONEntry(buf)
+	if magic, _ := buf.Peek(len(exportMagic)); bytes.Equal(magic, exportMagic) {
+		buf.Discard(len(exportMagic))
+		next = nextBinaryEntry(buf)
+	}
+
+	count := 0
+	for {
+		entry, err := next()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, fmt.Errorf("unable to read key %d of the import [%s]", count+1, err)
+		}
+
+		if entry.Expiry == bigcache.NO_EXPIRY {
+			err = node.Put(entry.Key, entry.Value, time.Duration(bigcache.NO_EXPIRY))
+		} else if entry.Expiry > uint64(node.clock.Now().Unix()) {
+			err = node.PutUntil(entry.Key, entry.Value, time.Unix(int64(entry.Expiry), 0))
+		} else {
+			continue
+		}
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+}
+
+//read the keys of an export in the json format, io.EOF once they are all read
+func nextJSONEntry(r io.Reader) func() (*ExportedEntry, error) {
+	decoder := json.NewDecoder(r)
+	return func() (*ExportedEntry, error) {
+		entry := &ExportedEntry{}
+		return entry, decoder.Decode(entry)
+	}
+}
+
+//read the keys of an export in the binary format, io.EOF once they are all read
+func nextBinaryEntry(r *bufio.Reader) func() (*ExportedEntry, error) {
+	field := func() ([]byte, error) {
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if size > maxExportedField { //corrupt, rather than allocated
+			return nil, fmt.Errorf("field of %d bytes is too large", size)
+		}
+		data := make([]byte, size)
+		if _, err = io.ReadFull(r, data); err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return data, err
+	}
+
+	return func() (*ExportedEntry, error) {
+		key, err := field()
+		if err != nil {
+			return nil, err //io.EOF only when no key was started
+		}
+		entry := &ExportedEntry{Key: string(key)}
+		if entry.Value, err = field(); err == nil {
+			entry.Expiry, err = binary.ReadUvarint(r)
+		}
+		if err == io.EOF { //cut short within a key
+			err = io.ErrUnexpectedEOF
+		}
+		return entry, err
+	}
+}
@@ -0,0 +1,51 @@
+package cluster
+
+import (
+	"expvar"
+	"sync"
+)
+
+//nodes publishing their statistics through expvar, by variable name. a variable can not be published twice so
+//it stays published once a node is shut down and is taken over by the next node started with the same name
+var (
+	expvarLock  sync.Mutex
+	expvarNodes = make(map[string]*ClusteredBigCache)
+)
+
+//the expvar variable the statistics of the node are published as, ngbigcache followed by the instance if set
+func (node *ClusteredBigCache) expvarName() string {
+	if node.config.Instance == "" {
+		return "ngbigcache"
+	}
+	return "ngbigcache." + node.config.Instance
+}
+
+//publish the statistics of the node through expvar, served at /debug/vars
+func (node *ClusteredBigCache) publishExpvar() {
+	name := node.expvarName()
+	expvarLock.Lock()
+	defer expvarLock.Unlock()
+
+	expvarNodes[name] = node
+	if expvar.Get(name) == nil {
+		expvar.Publish(name, expvar.Func(func() interface{} {
+			expvarLock.Lock()
+			published := expvarNodes[name]
+			expvarLock.Unlock()
+			if published == nil { //shut down
+				return nil
+			}
+			return published.StatisticsStruct()
+		}))
+	}
+}
+
+//stop publishing the statistics of the node, unless another node took the variable over
+func (node *ClusteredBigCache) unpublishExpvar() {
+	name := node.expvarName()
+	expvarLock.Lock()
+	if expvarNodes[name] == node {
+		delete(expvarNodes, name)
+	}
+	expvarLock.Unlock()
+}
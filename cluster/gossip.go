@@ -0,0 +1,271 @@
+package cluster
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/nggenius/ngbigcache/message"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//Membership states of the nodes known through gossip. a later state overrides an earlier one
+//at the same incarnation
+const (
+	MEMBER_STATE_ALIVE byte = iota
+	MEMBER_STATE_SUSPECT
+	MEMBER_STATE_DEAD
+)
+
+const (
+	defaultGossipInterval   = time.Millisecond * 500
+	defaultGossipFanout     = 3
+	defaultSuspicionTimeout = time.Second * 5
+	//dead members are remembered for this many suspicion timeouts so they are not brought back by stale gossip
+	deadMemberRetention = 10
+)
+
+//Member is a node of the cluster as seen through gossip
+type Member struct {
+	Id          string    `json:"id"`
+	Address     string    `json:"address"`
+	Passive     bool      `json:"passive"`
+	Incarnation uint64    `json:"incarnation"`
+	State       byte      `json:"state"`
+	Since       time.Time `json:"since"` //when the member entered its current state
+}
+
+type gossipMember struct {
+	message.GossipMember
+	since time.Time
+}
+
+//gossiper keeps the membership of the cluster in the style of SWIM. every interval the whole membership
+//is sent to a few random peers. a node whose connection is lost is only suspected at first, it is declared
+//dead when it has not refuted the suspicion within the suspicion timeout. a node refutes a suspicion about
+//itself by raising its incarnation
+type gossiper struct {
+	node      *ClusteredBigCache
+	lock      sync.Mutex
+	self      *gossipMember
+	members   map[string]*gossipMember
+	interval  time.Duration
+	fanout    int
+	suspicion time.Duration
+	done      chan struct{}
+}
+
+func newGossiper(node *ClusteredBigCache) *gossiper {
+	interval := time.Millisecond * time.Duration(node.config.GossipInterval)
+	if interval <= 0 {
+		interval = defaultGossipInterval
+	}
+	fanout := node.config.GossipFanout
+	if fanout < 1 {
+		fanout = defaultGossipFanout
+	}
+	suspicion := time.Millisecond * time.Duration(node.config.SuspicionTimeout)
+	if suspicion <= 0 {
+		suspicion = defaultSuspicionTimeout
+	}
+
+	//the incarnation starts from the clock so a restarted node overrides what is remembered of its previous run
+	self := &gossipMember{GossipMember: message.GossipMember{Id: node.config.Id, Address: node.advertisedAddress(),
+		Mode: node.mode, Incarnation: uint64(node.clock.Now().UnixNano()), State: MEMBER_STATE_ALIVE}, since: node.clock.Now()}
+
+	return &gossiper{
+		node:      node,
+		self:      self,
+		members:   map[string]*gossipMember{self.Id: self},
+		interval:  interval,
+		fanout:    fanout,
+		suspicion: suspicion,
+		done:      make(chan struct{}),
+	}
+}
+
+//the membership as sent to peers
+func (g *gossiper) digest() []message.GossipMember {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	digest := make([]message.GossipMember, 0, len(g.members))
+	for _, m := range g.members {
+		digest = append(digest, m.GossipMember)
+	}
+	return digest
+}
+
+//merge the membership gossiped by a peer into ours
+func (g *gossiper) merge(digest []message.GossipMember) {
+	if g == nil {
+		return
+	}
+
+	var dial []message.GossipMember
+	var events []Event
+	g.lock.Lock()
+	for _, update := range digest {
+		if update.Id == g.self.Id {
+			if update.State != MEMBER_STATE_ALIVE && update.Incarnation >= g.self.Incarnation && g.node.state == clusterStateStarted {
+				g.self.Incarnation = update.Incarnation + 1 //refute, the next round of gossip spreads it
+				utils.Warn(g.node.logger, "refuting suspicion about this node")
+			}
+			continue
+		}
+
+		current, ok := g.members[update.Id]
+		if !ok {
+			if update.State == MEMBER_STATE_DEAD { //nothing to learn about a node that is gone
+				continue
+			}
+			current = &gossipMember{GossipMember: update, since: g.node.clock.Now()}
+			g.members[update.Id] = current
+			if update.State == MEMBER_STATE_ALIVE {
+				dial = append(dial, update)
+			}
+			continue
+		}
+
+		if update.Incarnation < current.Incarnation ||
+			(update.Incarnation == current.Incarnation && update.State <= current.State) {
+			continue //stale or already known
+		}
+
+		previous := current.State
+		current.GossipMember = update
+		if previous == update.State {
+			continue
+		}
+		current.since = g.node.clock.Now()
+		switch update.State {
+		case MEMBER_STATE_ALIVE:
+			dial = append(dial, update)
+			events = append(events, Event{Type: EventMemberAlive, Message: fmt.Sprintf("'%s' is alive", update.Id)})
+		case MEMBER_STATE_SUSPECT:
+			events = append(events, Event{Type: EventMemberSuspect, Message: fmt.Sprintf("'%s' is suspected to have failed", update.Id)})
+		case MEMBER_STATE_DEAD:
+			events = append(events, Event{Type: EventMemberDead, Message: fmt.Sprintf("'%s' has failed or left", update.Id)})
+		}
+	}
+	g.lock.Unlock()
+
+	for _, e := range events {
+		g.node.emitEvent(e.Type, e.Message)
+	}
+	for _, m := range dial {
+		g.node.dial(m.Id, m.Address, m.Mode)
+	}
+}
+
+//the connection to a member was lost, suspect it rather than declaring it dead straight away
+func (g *gossiper) suspect(id string) {
+	if g == nil || g.node.state != clusterStateStarted {
+		return
+	}
+
+	g.lock.Lock()
+	m, ok := g.members[id]
+	if !ok || m.State != MEMBER_STATE_ALIVE {
+		g.lock.Unlock()
+		return
+	}
+	m.State = MEMBER_STATE_SUSPECT
+	m.since = g.node.clock.Now()
+	g.lock.Unlock()
+
+	g.node.emitEvent(EventMemberSuspect, fmt.Sprintf("'%s' is suspected to have failed", id))
+}
+
+//declare suspects that did not refute in time dead and forget members that have been dead for long
+func (g *gossiper) expire(now time.Time) {
+	var dead []string
+	g.lock.Lock()
+	for id, m := range g.members {
+		switch {
+		case m.State == MEMBER_STATE_SUSPECT && now.Sub(m.since) >= g.suspicion:
+			m.State = MEMBER_STATE_DEAD
+			m.since = now
+			dead = append(dead, id)
+		case m.State == MEMBER_STATE_DEAD && now.Sub(m.since) >= g.suspicion*deadMemberRetention:
+			delete(g.members, id)
+		}
+	}
+	g.lock.Unlock()
+
+	for _, id := range dead {
+		utils.Warn(g.node.logger, fmt.Sprintf("remote node '%s' did not refute its suspicion, declaring it dead", id))
+		g.node.emitEvent(EventMemberDead, fmt.Sprintf("'%s' has failed or left", id))
+	}
+}
+
+//send the membership to a few random peers
+func (g *gossiper) gossip() {
+	peers := g.node.getRemoteNodes()
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	if len(peers) > g.fanout {
+		peers = peers[:g.fanout]
+	}
+
+	digest := g.digest()
+	for _, peer := range peers {
+		peer.(*remoteNode).sendMessage(&message.GossipMessage{Members: digest})
+	}
+}
+
+func (g *gossiper) run() {
+	ticker := g.node.clock.NewTicker(g.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-g.done:
+			return
+		case now := <-ticker.C():
+			g.expire(now)
+			g.gossip()
+		}
+	}
+}
+
+//stop gossiping and tell every peer this node is leaving so they do not have to wait for it to time out
+func (g *gossiper) leave() {
+	close(g.done)
+
+	g.lock.Lock()
+	g.self.State = MEMBER_STATE_DEAD
+	g.lock.Unlock()
+
+	digest := g.digest()
+	for _, peer := range g.node.getRemoteNodes() {
+		peer.(*remoteNode).sendMessage(&message.GossipMessage{Members: digest})
+	}
+}
+
+func (g *gossiper) list() []Member {
+	if g == nil {
+		return nil
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	members := make([]Member, 0, len(g.members))
+	for _, m := range g.members {
+		members = append(members, Member{Id: m.Id, Address: m.Address, Passive: m.Mode == clusterModePASSIVE,
+			Incarnation: m.Incarnation, State: m.State, Since: m.since})
+	}
+	return members
+}
+
+func (r *remoteNode) handleGossip(msg *message.NodeWireMessage) {
+	gossipMsg := message.GossipMessage{}
+	gossipMsg.DeSerialize(msg)
+	r.parentNode.gossip.merge(gossipMsg.Members)
+}
+
+//Members returns the membership of the cluster as seen through gossip, nil if gossip is not enabled
+func (node *ClusteredBigCache) Members() []Member {
+	return node.gossip.list()
+}
@@ -0,0 +1,69 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nggenius/ngbigcache/message"
+)
+
+func memberState(g *gossiper, id string) (byte, bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	m, ok := g.members[id]
+	if !ok {
+		return 0, false
+	}
+	return m.State, true
+}
+
+func TestGossiperMerge(t *testing.T) {
+	events := make([]EventType, 0)
+	node := New(&ClusteredBigCacheConfig{Id: "node_1", LocalPort: 1059, SuspicionTimeout: 100,
+		OnEvent: func(e Event) { events = append(events, e.Type) }}, nil)
+	node.state = clusterStateStarted
+	g := newGossiper(node)
+
+	g.merge([]message.GossipMember{{Id: "node_2", Address: "localhost:1058", Incarnation: 1}})
+	if state, ok := memberState(g, "node_2"); !ok || state != MEMBER_STATE_ALIVE {
+		t.Fatal("gossiped member ought to be known as alive")
+	}
+
+	g.suspect("node_2")
+	g.merge([]message.GossipMember{{Id: "node_2", Address: "localhost:1058", Incarnation: 1}})
+	if state, _ := memberState(g, "node_2"); state != MEMBER_STATE_SUSPECT {
+		t.Error("alive at the same incarnation ought not to override a suspicion")
+	}
+
+	g.merge([]message.GossipMember{{Id: "node_2", Address: "localhost:1058", Incarnation: 2}})
+	if state, _ := memberState(g, "node_2"); state != MEMBER_STATE_ALIVE {
+		t.Error("alive at a higher incarnation ought to refute a suspicion")
+	}
+
+	g.suspect("node_2")
+	g.expire(time.Now().Add(time.Millisecond * 100))
+	if state, _ := memberState(g, "node_2"); state != MEMBER_STATE_DEAD {
+		t.Error("suspect ought to be declared dead once the suspicion timeout elapses")
+	}
+
+	expected := []EventType{EventMemberSuspect, EventMemberAlive, EventMemberSuspect, EventMemberDead}
+	if len(events) != len(expected) {
+		t.Fatalf("unexpected events %v", events)
+	}
+	for x := range expected {
+		if events[x] != expected[x] {
+			t.Errorf("unexpected events %v", events)
+		}
+	}
+
+	incarnation := g.self.Incarnation
+	g.merge([]message.GossipMember{{Id: "node_1", Incarnation: incarnation, State: MEMBER_STATE_SUSPECT}})
+	if g.self.Incarnation <= incarnation || g.self.State != MEMBER_STATE_ALIVE {
+		t.Error("node ought to refute a suspicion about itself")
+	}
+
+	g.merge([]message.GossipMember{{Id: "node_3", Incarnation: 1, State: MEMBER_STATE_DEAD}})
+	if _, ok := memberState(g, "node_3"); ok {
+		t.Error("unknown dead member ought not to be remembered")
+	}
+}
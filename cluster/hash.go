@@ -0,0 +1,139 @@
+package cluster
+
+import (
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+	"github.com/nggenius/ngbigcache/message"
+)
+
+//HSet sets fields of the hash under key in the cluster, creating it if there is none, the other fields are kept
+//as they are. a new hash expires after duration, an existing one keeps its expiry. only the fields set are sent
+//to the remote nodes, which set them in their own copy with the shard locked, so concurrent writers of different
+//fields do not undo each other like a read-modify-write of the whole value would
+func (node *ClusteredBigCache) HSet(key string, fields map[string][]byte, duration time.Duration) error {
+
+	if node.state != clusterStateStarted {
+		return ErrNotStarted
+	}
+	if err := node.admitWrite(); err != nil {
+		return err
+	}
+
+	//set locally first
+	expiryTime := bigcache.NO_EXPIRY
+	if node.mode == clusterModeACTIVE {
+		if err := node.throttle.admit(); err != nil {
+			return err
+		}
+		if _, err := node.cache.HSet(key, fields, duration); err != nil {
+			return err
+		}
+		if ttl, err := node.cache.TTL(key); err == nil && ttl != time.Duration(bigcache.NO_EXPIRY) {
+			expiryTime = uint64(node.clock.Now().Add(ttl).Unix())
+		}
+		node.watchers.notify(key, false)
+	} else if duration != time.Duration(bigcache.NO_EXPIRY) {
+		expiryTime = uint64(node.clock.Now().Unix()) + uint64(duration.Seconds())
+	}
+	node.evictions.discard(key)
+	node.bandwidth.wrote(len(key) + fieldsSize(fields))
+
+	node.replicateHash(key, &message.HSetMessage{Key: key, Fields: fields, Expiry: expiryTime})
+	return nil
+}
+
+//HDel removes fields from the hash under key in the cluster. a hash left without fields is kept, empty, until it
+//expires or is deleted
+func (node *ClusteredBigCache) HDel(key string, fields ...string) error {
+
+	if node.state != clusterStateStarted {
+		return ErrNotStarted
+	}
+	if err := node.admitWrite(); err != nil {
+		return err
+	}
+
+	if node.mode == clusterModeACTIVE {
+		if err := node.throttle.admit(); err != nil {
+			return err
+		}
+		if _, err := node.cache.HDel(key, fields); err != nil {
+			return err
+		}
+		node.watchers.notify(key, false)
+	}
+	size := len(key)
+	for _, field := range fields {
+		size += len(field)
+	}
+	node.bandwidth.wrote(size)
+
+	node.replicateHash(key, &message.HDelMessage{Key: key, Fields: fields})
+	return nil
+}
+
+//HGet returns the value of a field of the hash under key, bigcache.ErrFieldNotFound when it has no such field. the
+//hash is read like Get reads a key
+func (node *ClusteredBigCache) HGet(key, field string, timeout time.Duration) ([]byte, error) {
+	data, err := node.Get(key, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return bigcache.HashField(data, field)
+}
+
+//HGetAll returns every field of the hash under key
+func (node *ClusteredBigCache) HGetAll(key string, timeout time.Duration) (map[string][]byte, error) {
+	data, err := node.Get(key, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return bigcache.HashFields(data)
+}
+
+//send a change of the hash under key to the remote nodes holding data
+func (node *ClusteredBigCache) replicateHash(key string, msg message.NodeMessage) {
+	for _, peer := range node.activePeers() {
+		if peer.version() >= message.MsgMinVersion(message.MsgHSET) {
+			node.queueReplication(&replicationMsg{r: peer, m: msg})
+		}
+	}
+}
+
+func (r *remoteNode) handleHSet(msg *message.NodeWireMessage) {
+
+	hSetMsg := message.HSetMessage{}
+	hSetMsg.DeSerialize(msg)
+	if !r.admitReplicatedWrite(msg.Code, hSetMsg.Key) || !r.admitThrottledWrite(msg.Code, hSetMsg.Key) {
+		return
+	}
+
+	var err error
+	if hSetMsg.Expiry == bigcache.NO_EXPIRY {
+		_, err = r.parentNode.cache.HSet(hSetMsg.Key, hSetMsg.Fields, 0)
+	} else { //the expiry is an absolute time so keep it as is rather than recomputing a duration
+		_, err = r.parentNode.cache.HSetUntil(hSetMsg.Key, hSetMsg.Fields, time.Unix(int64(hSetMsg.Expiry), 0))
+	}
+	if err != nil {
+		r.replicaWriteFailed("replicate hash set", hSetMsg.Key, err)
+		return
+	}
+	r.parentNode.evictions.discard(hSetMsg.Key)
+	r.parentNode.watchers.notify(hSetMsg.Key, false)
+}
+
+func (r *remoteNode) handleHDel(msg *message.NodeWireMessage) {
+
+	hDelMsg := message.HDelMessage{}
+	hDelMsg.DeSerialize(msg)
+	if !r.admitReplicatedWrite(msg.Code, hDelMsg.Key) || !r.admitThrottledWrite(msg.Code, hDelMsg.Key) {
+		return
+	}
+
+	if _, err := r.parentNode.cache.HDel(hDelMsg.Key, hDelMsg.Fields); err != nil {
+		r.replicaWriteFailed("replicate hash delete", hDelMsg.Key, err)
+		return
+	}
+	r.parentNode.watchers.notify(hDelMsg.Key, false)
+}
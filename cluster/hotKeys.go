@@ -0,0 +1,108 @@
+package cluster
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+)
+
+//default number of keys tracked by the hot key tracker
+const defaultHotKeyCapacity = 64
+
+//hotKey is a key along with its (approximate) number of reads
+type hotKey struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+	index int
+}
+
+//min-heap of tracked keys ordered by count
+type hotKeyHeap []*hotKey
+
+func (h hotKeyHeap) Len() int           { return len(h) }
+func (h hotKeyHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h hotKeyHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *hotKeyHeap) Push(x interface{}) {
+	k := x.(*hotKey)
+	k.index = len(*h)
+	*h = append(*h, k)
+}
+
+func (h *hotKeyHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	k := old[n-1]
+	*h = old[:n-1]
+	return k
+}
+
+//hotKeyTracker keeps track of the most read keys in bounded memory using the space-saving algorithm.
+//when a key that is not tracked is read and the tracker is full, it replaces the least read key and
+//inherits its count, so counts are an upper bound of the real number of reads
+type hotKeyTracker struct {
+	lock     sync.Mutex
+	capacity int
+	keys     map[string]*hotKey
+	heap     hotKeyHeap
+}
+
+func newHotKeyTracker(capacity int) *hotKeyTracker {
+	if capacity < 1 {
+		capacity = defaultHotKeyCapacity
+	}
+
+	return &hotKeyTracker{
+		capacity: capacity,
+		keys:     make(map[string]*hotKey, capacity),
+		heap:     make(hotKeyHeap, 0, capacity),
+	}
+}
+
+//record a read of key
+func (t *hotKeyTracker) record(key string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if k, ok := t.keys[key]; ok {
+		k.Count++
+		heap.Fix(&t.heap, k.index)
+		return
+	}
+
+	if len(t.heap) < t.capacity {
+		k := &hotKey{Key: key, Count: 1}
+		heap.Push(&t.heap, k)
+		t.keys[key] = k
+		return
+	}
+
+	//replace the least read key
+	k := t.heap[0]
+	delete(t.keys, k.Key)
+	k.Key = key
+	k.Count++
+	t.keys[key] = k
+	heap.Fix(&t.heap, 0)
+}
+
+//top returns at most n of the most read keys, most read first
+func (t *hotKeyTracker) top(n int) []hotKey {
+	t.lock.Lock()
+	result := make([]hotKey, 0, len(t.heap))
+	for _, k := range t.heap {
+		result = append(result, hotKey{Key: k.Key, Count: k.Count})
+	}
+	t.lock.Unlock()
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Count > result[j].Count })
+	if n > 0 && len(result) > n {
+		result = result[:n]
+	}
+
+	return result
+}
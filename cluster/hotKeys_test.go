@@ -0,0 +1,28 @@
+package cluster
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestHotKeyTracker(t *testing.T) {
+	tracker := newHotKeyTracker(8)
+	for x := 0; x < 100; x++ {
+		tracker.record("hot")
+		if x%2 == 0 {
+			tracker.record("warm")
+		}
+		if x%5 == 0 {
+			tracker.record("cold_" + strconv.Itoa(x))
+		}
+	}
+
+	top := tracker.top(2)
+	if len(top) != 2 || top[0].Key != "hot" || top[1].Key != "warm" {
+		t.Errorf("hot and warm keys ought to be the most read keys, got %v", top)
+	}
+
+	if len(tracker.top(0)) != 8 {
+		t.Error("tracker ought not to track more keys than its capacity")
+	}
+}
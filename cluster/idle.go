@@ -0,0 +1,136 @@
+package cluster
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/nggenius/ngbigcache/message"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+const (
+	//time the close message is given to reach a passive client before its connection is shut down anyway
+	idleCloseGrace = time.Second
+	//time a passive client waits for an active node that closed its connection for being idle to be back
+	idleWakeTimeout = time.Second * 5
+	//least time between two checks for idle passive clients
+	minIdleCheckInterval = time.Millisecond * 100
+)
+
+//idleReaper closes the connections of passive clients that neither read nor wrote for PassiveIdleTimeout,
+//telling them why so they only dial again the next time they read or write
+type idleReaper struct {
+	node    *ClusteredBigCache
+	timeout time.Duration
+	closed  uint64
+	done    chan struct{}
+}
+
+//an active node that closed the connection of this passive client for being idle
+type idlePeer struct {
+	address string
+	dialled int64 //unix nano of the last time it was dialled again
+}
+
+func newIdleReaper(node *ClusteredBigCache) *idleReaper {
+	return &idleReaper{
+		node:    node,
+		timeout: time.Second * time.Duration(node.config.PassiveIdleTimeout),
+		done:    make(chan struct{}),
+	}
+}
+
+//check for idle passive clients a few times per timeout until closed
+func (i *idleReaper) run() {
+	interval := i.timeout / 4
+	if interval < minIdleCheckInterval {
+		interval = minIdleCheckInterval
+	}
+
+	ticker := i.node.clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-i.done:
+			return
+		case now := <-ticker.C():
+			i.closeIdle(now)
+		}
+	}
+}
+
+//close the connections of the passive clients idle since before now less the timeout. clients that would not
+//understand the close message are left alone since they would dial straight back
+func (i *idleReaper) closeIdle(now time.Time) {
+	for _, v := range i.node.getRemoteNodes() {
+		r := v.(*remoteNode)
+		if r.mode != clusterModePASSIVE || r.version() < message.MsgMinVersion(message.MsgCLOSE) {
+			continue
+		}
+		if now.Sub(time.Unix(0, atomic.LoadInt64(&r.lastActive))) < i.timeout || !atomic.CompareAndSwapInt32(&r.closing, 0, 1) {
+			continue
+		}
+
+		utils.Info(i.node.logger, fmt.Sprintf("closing the connection of passive client '%s', idle for more than %s", r.config.Id, i.timeout))
+		atomic.AddUint64(&i.closed, 1)
+		r.sendMessage(&message.CloseMessage{Reason: message.CloseReasonIdle})
+		i.node.routines.spawn(func() { //in case the client does not close it itself
+			if i.node.sleep(r.ctx, idleCloseGrace) {
+				r.shutDown()
+			}
+		})
+	}
+}
+
+//number of passive clients closed for being idle
+func (i *idleReaper) count() uint64 {
+	if i == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&i.closed)
+}
+
+func (i *idleReaper) close() {
+	close(i.done)
+}
+
+//the remote node is about to close the connection. it is closed from this side first and, when it was closed for
+//being idle, only dialled again the next time this node reads or writes
+func (r *remoteNode) handleClose(msg *message.NodeWireMessage) {
+	closeMsg := message.CloseMessage{}
+	closeMsg.DeSerialize(msg)
+
+	utils.Info(r.logger, fmt.Sprintf("remote node '%s' is closing the connection [%s]", r.config.Id, closeMsg.Reason))
+	if closeMsg.Reason == message.CloseReasonIdle {
+		r.config.ReconnectOnDisconnect = false
+		r.parentNode.idlePeers.Store(r.config.Id, &idlePeer{address: r.config.IpAddress})
+	}
+	r.shutDown()
+}
+
+//dial again the active nodes that closed the connections of this passive client for being idle, each at most
+//once per idleWakeTimeout. false when there are none
+func (node *ClusteredBigCache) wakeIdlePeers() bool {
+	woken := false
+	now := node.clock.Now().UnixNano()
+	node.idlePeers.Range(func(key, value interface{}) bool {
+		woken = true
+		peer := value.(*idlePeer)
+		last := atomic.LoadInt64(&peer.dialled)
+		if now-last >= int64(idleWakeTimeout) && atomic.CompareAndSwapInt64(&peer.dialled, last, now) {
+			utils.Info(node.logger, fmt.Sprintf("dialling '%s' again, it closed the connection for being idle", key))
+			node.routines.spawn(func() { node.redial(key.(string), peer.address, 0) })
+		}
+		return true
+	})
+	return woken
+}
+
+//wait for the active nodes that closed this passive client's connections for being idle before writing, so
+//the write is not lost for want of a remote node to replicate it to
+func (node *ClusteredBigCache) wakeForWrite() {
+	if node.mode == clusterModePASSIVE && !node.hasActivePeers() && node.wakeIdlePeers() {
+		node.awaitActivePeers(idleWakeTimeout)
+	}
+}
@@ -0,0 +1,344 @@
+package cluster
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/nggenius/ngbigcache/message"
+)
+
+//classes of the messages received, from the first dropped when the inbound queue is full to the last
+const (
+	inboundClassPeriodic = iota //gossip and the other messages sent again periodically
+	inboundClassRead            //requests the remote node gives up on after a timeout
+	inboundClassOther           //writes, answers and the rest, which are never dropped to make room
+	inboundClassControl         //liveness and handshake messages, kept apart from the others and taken first
+	inboundClasses
+)
+
+var inboundClassNames = [inboundClasses]string{"periodic", "read", "other", "control"}
+
+//slots of the rings of the classes that are dropped first and of the control lane, the queue drops the messages
+//of those classes once their ring is full
+const inboundRingSize = 1024
+
+//the class of a message code
+func inboundClass(code uint16) int {
+	if isControl(code) {
+		return inboundClassControl
+	}
+	switch code {
+	case message.MsgGossip, message.MsgSTATSPush:
+		return inboundClassPeriodic
+	case message.MsgGETReq, message.MsgTTLReq, message.MsgKEYSReq, message.MsgSTATSReq, message.MsgAuditReq:
+		return inboundClassRead
+	}
+	return inboundClassOther
+}
+
+//a slot of an inboundRing. it holds a message when seq is its position in the ring plus one and is free for the
+//message put at the position when seq is the position
+type inboundSlot struct {
+	seq   uint64
+	stamp uint64 //order the message arrived in, among those of every ring of the queue
+	msg   *message.NodeWireMessage
+}
+
+//inboundRing is a bounded lock-free ring buffer many goroutines put messages in and a single one takes them from
+type inboundRing struct {
+	slots []inboundSlot
+	mask  uint64
+	tail  uint64 //position the next message is put at
+	head  uint64 //position the next message is taken from, only the consumer changes it
+}
+
+//a ring of at least size slots, rounded up to a power of two
+func newInboundRing(size int) *inboundRing {
+	slots := 1
+	for slots < size {
+		slots <<= 1
+	}
+	r := &inboundRing{slots: make([]inboundSlot, slots), mask: uint64(slots - 1)}
+	for x := range r.slots {
+		r.slots[x].seq = uint64(x)
+	}
+	return r
+}
+
+//put a message in the ring, false when it is full
+func (r *inboundRing) put(stamp uint64, msg *message.NodeWireMessage) bool {
+	for {
+		pos := atomic.LoadUint64(&r.tail)
+		slot := &r.slots[pos&r.mask]
+		switch diff := int64(atomic.LoadUint64(&slot.seq) - pos); {
+		case diff == 0:
+			if atomic.CompareAndSwapUint64(&r.tail, pos, pos+1) {
+				slot.stamp, slot.msg = stamp, msg
+				atomic.StoreUint64(&slot.seq, pos+1)
+				return true
+			}
+		case diff < 0: //the message put a lap ago was not taken yet
+			return false
+		}
+	}
+}
+
+//the slot of the oldest message, nil when there is none
+func (r *inboundRing) peek() *inboundSlot {
+	slot := &r.slots[r.head&r.mask]
+	if atomic.LoadUint64(&slot.seq) != r.head+1 {
+		return nil
+	}
+	return slot
+}
+
+//take the oldest message off the ring, peek must have returned its slot
+func (r *inboundRing) take() *message.NodeWireMessage {
+	slot := &r.slots[r.head&r.mask]
+	msg := slot.msg
+	slot.msg = nil
+	atomic.StoreUint64(&slot.seq, r.head+r.mask+1)
+	atomic.StoreUint64(&r.head, r.head+1) //read by len
+	return msg
+}
+
+//inboundQueue holds the messages received from a remote node until the message handler takes them, in the order
+//they arrived. it holds capacity messages at most: once it is full a message takes the room of the oldest message
+//of the lowest class below its own, periodic ones first, or is dropped when there is none. messages of
+//inboundClassOther are never dropped, they wait for room instead as they did when the queue was a channel.
+//control messages go in a lane of their own, outside of the capacity, the message handler takes them ahead of
+//the others so heavy traffic never delays a ping long enough to fail it. the readers of the connections and the
+//message handler never take a lock, every ring is lock-free and the counts are atomic
+type inboundQueue struct {
+	rings     [inboundClasses]*inboundRing
+	capacity  int64
+	length    int64                  //messages queued, not counting those dropped to make room
+	stamp     uint64                 //of the last message queued
+	counts    [inboundClasses]uint64 //messages of the class queued in the high 32 bits, dropped to make room but still on the ring in the low 32
+	dropped   [inboundClasses]uint64
+	ready     chan struct{} //signalled when a message was queued
+	room      chan struct{} //signalled when a message was taken
+	done      chan struct{} //closed by close
+	closeOnce sync.Once
+}
+
+func newInboundQueue(capacity int) *inboundQueue {
+	small := capacity
+	if small > inboundRingSize {
+		small = inboundRingSize
+	}
+	read := capacity / 4
+	if read < small {
+		read = small
+	}
+
+	q := &inboundQueue{
+		capacity: int64(capacity),
+		ready:    make(chan struct{}, 1),
+		room:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	q.rings[inboundClassPeriodic] = newInboundRing(small)
+	q.rings[inboundClassRead] = newInboundRing(read)
+	q.rings[inboundClassOther] = newInboundRing(capacity)
+	q.rings[inboundClassControl] = newInboundRing(small)
+	return q
+}
+
+//queue a message unless the queue is closed. a message that can be dropped is dropped, false, when the queue is
+//full of messages of its class or above, one of inboundClassOther waits for room until ctx is done. a control
+//message is only dropped when its lane is full
+func (q *inboundQueue) put(ctx context.Context, msg *message.NodeWireMessage) bool {
+	class := inboundClass(msg.Code)
+	if class == inboundClassControl {
+		select {
+		case <-q.done:
+			return false
+		default:
+		}
+		if !q.rings[class].put(0, msg) {
+			atomic.AddUint64(&q.dropped[class], 1)
+			return false
+		}
+		q.queued()
+		return true
+	}
+
+	for {
+		select {
+		case <-q.done:
+			return false
+		default:
+		}
+
+		if q.reserve(class) {
+			if q.rings[class].put(atomic.AddUint64(&q.stamp, 1), msg) {
+				atomic.AddUint64(&q.counts[class], 1<<32)
+				q.queued()
+				return true
+			}
+			atomic.AddInt64(&q.length, -1) //the ring is full of messages dropped to make room, not taken yet
+		}
+		if class != inboundClassOther {
+			atomic.AddUint64(&q.dropped[class], 1)
+			return false
+		}
+
+		select {
+		case <-q.room:
+		case <-ctx.Done():
+			return false
+		case <-q.done:
+			return false
+		}
+	}
+}
+
+//tell the message handler a message was queued
+func (q *inboundQueue) queued() {
+	select {
+	case q.ready <- struct{}{}:
+	default: //it was already told
+	}
+}
+
+//take room for a message of class, making it when the queue is full by dropping the oldest message of the lowest
+//class below it. false when there is none to drop
+func (q *inboundQueue) reserve(class int) bool {
+	for {
+		length := atomic.LoadInt64(&q.length)
+		if length >= q.capacity {
+			break
+		}
+		if atomic.CompareAndSwapInt64(&q.length, length, length+1) {
+			return true
+		}
+	}
+
+	for c := 0; c < class; c++ {
+		if q.evict(c) {
+			return true
+		}
+	}
+	return false
+}
+
+//drop the oldest message of class still queued, it is left on its ring for the message handler to skip. its room
+//goes to the message taking it, so the length of the queue does not change
+func (q *inboundQueue) evict(class int) bool {
+	for {
+		counts := atomic.LoadUint64(&q.counts[class])
+		if counts>>32 == 0 {
+			return false
+		}
+		if atomic.CompareAndSwapUint64(&q.counts[class], counts, counts-1<<32+1) {
+			atomic.AddUint64(&q.dropped[class], 1)
+			return true
+		}
+	}
+}
+
+//wait for the message that arrived first and take it. the messages dropped to make room are handed to dropped as
+//they are skipped. false once the queue is closed and empty
+func (q *inboundQueue) next(dropped func(*message.NodeWireMessage)) (*message.NodeWireMessage, bool) {
+	for {
+		if msg := q.take(dropped); msg != nil {
+			return msg, true
+		}
+		select {
+		case <-q.ready:
+		case <-q.done:
+			if msg := q.take(dropped); msg != nil {
+				return msg, true
+			}
+			return nil, false
+		}
+	}
+}
+
+//take the control message that arrived first, else the message that arrived first. nil when there is none
+func (q *inboundQueue) take(dropped func(*message.NodeWireMessage)) *message.NodeWireMessage {
+	if control := q.rings[inboundClassControl]; control.peek() != nil {
+		return control.take()
+	}
+
+	for {
+		class := -1
+		var oldest *inboundSlot
+		for c, ring := range q.rings[:inboundClassControl] {
+			if slot := ring.peek(); slot != nil && (oldest == nil || slot.stamp < oldest.stamp) {
+				class, oldest = c, slot
+			}
+		}
+		if oldest == nil {
+			return nil
+		}
+
+		msg := q.rings[class].take()
+		if !q.taken(class) {
+			dropped(msg)
+			continue
+		}
+		atomic.AddInt64(&q.length, -1)
+		select {
+		case q.room <- struct{}{}:
+		default:
+		}
+		return msg
+	}
+}
+
+//count a message of class taken off its ring, false when it was dropped to make room
+func (q *inboundQueue) taken(class int) bool {
+	for {
+		counts := atomic.LoadUint64(&q.counts[class])
+		switch {
+		case counts&(1<<32-1) > 0:
+			if atomic.CompareAndSwapUint64(&q.counts[class], counts, counts-1) {
+				return false
+			}
+		case counts>>32 > 0:
+			if atomic.CompareAndSwapUint64(&q.counts[class], counts, counts-1<<32) {
+				return true
+			}
+		default: //put on the ring but not counted yet by the goroutine queueing it
+			runtime.Gosched()
+		}
+	}
+}
+
+//stop queueing messages. those queued are still taken, next returns false once they all were
+func (q *inboundQueue) close() {
+	q.closeOnce.Do(func() { close(q.done) })
+}
+
+//messages queued, control messages included
+func (q *inboundQueue) len() int {
+	control := q.rings[inboundClassControl]
+	return int(atomic.LoadInt64(&q.length)) + int(atomic.LoadUint64(&control.tail)-atomic.LoadUint64(&control.head))
+}
+
+func (q *inboundQueue) cap() int {
+	return int(q.capacity)
+}
+
+//messages dropped for want of room, by name of their class
+func (q *inboundQueue) droppedByClass() map[string]uint64 {
+	dropped := make(map[string]uint64, inboundClasses)
+	for c, name := range inboundClassNames {
+		dropped[name] = atomic.LoadUint64(&q.dropped[c])
+	}
+	return dropped
+}
+
+//bytes taken by the slots of the rings
+func (q *inboundQueue) memory() int {
+	slots := 0
+	for _, ring := range q.rings {
+		slots += len(ring.slots)
+	}
+	return slots * int(unsafe.Sizeof(inboundSlot{}))
+}
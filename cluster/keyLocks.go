@@ -0,0 +1,29 @@
+package cluster
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+//number of mutexes keys are striped across, keys sharing a stripe wait on each other
+const keyLockStripes = 256
+
+//keyLocks serializes callers working on the same key without a mutex per key
+type keyLocks [keyLockStripes]sync.Mutex
+
+func (l *keyLocks) stripe(key string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &l[h.Sum32()%keyLockStripes]
+}
+
+//WithKeyLock calls fn while holding the write lock of key, so calls for the same key on this node never run
+//at the same time and a read-modify-write of the key done in fn is not interleaved with another one. the lock
+//is local to this node, it does not keep other nodes from writing the key. fn must not call WithKeyLock
+func (node *ClusteredBigCache) WithKeyLock(key string, fn func()) {
+	lock := node.keyLocks.stripe(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	fn()
+}
@@ -0,0 +1,213 @@
+package cluster
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nggenius/ngbigcache/message"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//time the admin server waits for the remote nodes to list their keys
+const adminKeysTimeout = time.Second * 2
+
+//KeyPage is a page of the keys with a prefix listed by KeysWithPrefix
+type KeyPage struct {
+	Keys []string `json:"keys"`
+	Next string   `json:"next,omitempty"` //cursor of the next page, empty when this page is the last
+}
+
+//what a node holds of the keys with a prefix
+type keysReply struct {
+	keys  []string
+	count int
+	err   error //ErrNodeDisconnected when the remote node went away before answering
+}
+
+//KeysWithPrefix lists in order a page of at most limit keys with the prefix, all of them when limit is 0 or less.
+//cursor is "" for the first page and the Next of the page before for the following ones. the local cache and
+//every active remote node are asked and their keys merged, so keys only some nodes hold are listed too. the local
+//caches must be created with IndexKeys, bigcache.ErrNotIndexed is returned otherwise
+func (node *ClusteredBigCache) KeysWithPrefix(prefix, cursor string, limit int, timeout time.Duration) (KeyPage, error) {
+	reqMsg := message.KeysReqMessage{Prefix: prefix, After: cursor}
+	if limit > 0 {
+		reqMsg.Limit = limit + 1 //one more tells whether there is a next page
+	}
+	replies, err := node.gatherKeys(reqMsg, timeout)
+	if err != nil {
+		return KeyPage{}, err
+	}
+
+	var keys []string
+	for _, reply := range replies {
+		keys = append(keys, reply.keys...)
+	}
+	sort.Strings(keys)
+	page := KeyPage{Keys: keys[:0]}
+	for i, key := range keys {
+		if i == 0 || key != keys[i-1] {
+			page.Keys = append(page.Keys, key)
+		}
+	}
+	if limit > 0 && len(page.Keys) > limit {
+		page.Keys = page.Keys[:limit]
+		page.Next = page.Keys[limit-1]
+	}
+	return page, nil
+}
+
+//CountPrefix returns the number of keys with the prefix, the largest number of them the local cache or any active
+//remote node holds. it is exact when every active node holds every key, as they do unless ReplicationFactor is set.
+//the local caches must be created with IndexKeys, bigcache.ErrNotIndexed is returned otherwise
+func (node *ClusteredBigCache) CountPrefix(prefix string, timeout time.Duration) (int, error) {
+	replies, err := node.gatherKeys(message.KeysReqMessage{Prefix: prefix, Count: true}, timeout)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, reply := range replies {
+		count = max(count, reply.count)
+	}
+	return count, nil
+}
+
+//ask the local cache and every active remote node for the keys with a prefix, or their number. fails when any
+//of them fails or has not answered within timeout, as the keys it holds would be missed
+func (node *ClusteredBigCache) gatherKeys(reqMsg message.KeysReqMessage, timeout time.Duration) ([]keysReply, error) {
+	if node.state != clusterStateStarted {
+		return nil, ErrNotStarted
+	}
+
+	replies := make([]keysReply, 0)
+	if node.mode == clusterModeACTIVE {
+		reply := node.localKeys(&reqMsg)
+		if reply.err != nil {
+			return nil, reply.err
+		}
+		replies = append(replies, reply)
+	}
+
+	peers := make([]*remoteNode, 0)
+	for _, r := range node.activePeers() {
+		if r.version() >= message.MsgMinVersion(message.MsgKEYSReq) { //older nodes would never answer
+			peers = append(peers, r)
+		}
+	}
+	if len(peers) < 1 && len(replies) < 1 {
+		return nil, ErrNoPeers
+	}
+
+	waiting := 0
+	remoteReplies := make(chan keysReply, len(peers))
+	for _, r := range peers {
+		pendingKey := reqMsg.Prefix + utils.GenerateNodeId(8)
+		if err := r.askKeys(reqMsg, pendingKey, remoteReplies); err != nil {
+			return nil, err
+		}
+		waiting++
+		defer r.pending.cancel(message.MsgKEYSReq, pendingKey)
+	}
+
+	timer := node.clock.NewTimer(timeout)
+	defer timer.Stop()
+	for ; waiting > 0; waiting-- {
+		select {
+		case reply := <-remoteReplies:
+			if reply.err != nil {
+				return nil, reply.err
+			}
+			replies = append(replies, reply)
+		case <-timer.C():
+			return nil, ErrTimeout
+		}
+	}
+	return replies, nil
+}
+
+//the keys of the local cache a keys request asks for
+func (node *ClusteredBigCache) localKeys(reqMsg *message.KeysReqMessage) keysReply {
+	reply := keysReply{}
+	if reqMsg.Count {
+		reply.count, reply.err = node.cache.CountPrefix(reqMsg.Prefix)
+	} else {
+		reply.keys, reply.err = node.cache.KeysWithPrefixAfter(reqMsg.Prefix, reqMsg.After, reqMsg.Limit)
+	}
+	return reply
+}
+
+//ask the remote node for the keys with a prefix it holds, the reply is sent on replies
+func (r *remoteNode) askKeys(reqMsg message.KeysReqMessage, pendingKey string, replies chan keysReply) error {
+	if r.state == nodeStateDisconnected {
+		return ErrNodeDisconnected
+	}
+	if err := r.pending.add(message.MsgKEYSReq, pendingKey, replies, func() {
+		replies <- keysReply{err: ErrNodeDisconnected}
+	}); err != nil {
+		return err
+	}
+	reqMsg.PendingKey = pendingKey
+	r.sendMessage(&reqMsg)
+	return nil
+}
+
+func (r *remoteNode) handleKeysRequest(msg *message.NodeWireMessage) {
+	reqMsg := message.KeysReqMessage{}
+	reqMsg.DeSerialize(msg)
+
+	rsp := &message.KeysRspMessage{PendingKey: reqMsg.PendingKey}
+	if r.parentNode.mode != clusterModeACTIVE {
+		rsp.Error = errNotActive.Error()
+	} else if reply := r.parentNode.localKeys(&reqMsg); reply.err != nil {
+		rsp.Error = reply.err.Error()
+	} else {
+		rsp.Keys, rsp.Count = reply.keys, reply.count
+	}
+	r.sendMessage(rsp)
+}
+
+func (r *remoteNode) handleKeysResponse(msg *message.NodeWireMessage) {
+	rspMsg := message.KeysRspMessage{}
+	rspMsg.DeSerialize(msg)
+	replies, ok := r.pending.take(message.MsgKEYSReq, rspMsg.PendingKey)
+	if !ok { //the request timed out
+		return
+	}
+
+	reply := keysReply{keys: rspMsg.Keys, count: rspMsg.Count}
+	if rspMsg.Error != "" {
+		reply.err = remoteError(r.config.Id, rspMsg.Error)
+	}
+	//buffered for every peer asked so this never blocks
+	replies.(chan keysReply) <- reply
+}
+
+//list a page of the keys with a prefix, or count them when count is set
+func (node *ClusteredBigCache) handleAdminKeys(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	timeout := adminKeysTimeout
+	if ms, err := strconv.Atoi(query.Get("timeout")); err == nil && ms > 0 {
+		timeout = time.Millisecond * time.Duration(ms)
+	}
+
+	var result interface{}
+	var err error
+	if query.Get("count") != "" {
+		var count int
+		count, err = node.CountPrefix(query.Get("prefix"), timeout)
+		result = map[string]int{"count": count}
+	} else {
+		limit, _ := strconv.Atoi(query.Get("limit"))
+		result, err = node.KeysWithPrefix(query.Get("prefix"), query.Get("cursor"), limit, timeout)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
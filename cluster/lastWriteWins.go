@@ -0,0 +1,247 @@
+package cluster
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+)
+
+//bits of a timestamp counting writes within the same millisecond, the milliseconds are in the bits above them
+const hlcLogicalBits = 16
+
+//entries kept in the write versions before the expired ones are swept out for the first time
+const minVersionsSweep = 1024
+
+//how long the tombstone of a deleted key is kept. a put made before the delete arriving later than that brings
+//the key back on the node it arrives at
+const tombstoneLifetime = time.Minute * 5
+
+//hybridClock hands out hybrid logical clock timestamps, the wall clock in milliseconds with a counter of the
+//writes made within the same millisecond. it never goes back and moves past every timestamp received from
+//remote nodes, so a write made after another one was seen is always newer even if the clocks of the nodes differ
+type hybridClock struct {
+	lock sync.Mutex
+	last uint64
+}
+
+//a timestamp newer than every one given out or observed so far, with the wall clock reading now
+func (c *hybridClock) now(now time.Time) uint64 {
+	wall := uint64(now.UnixNano()/int64(time.Millisecond)) << hlcLogicalBits
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if wall > c.last {
+		c.last = wall
+	} else {
+		c.last++
+	}
+	return c.last
+}
+
+//move the clock past a timestamp received from a remote node
+func (c *hybridClock) observe(stamp uint64) {
+	c.lock.Lock()
+	if stamp > c.last {
+		c.last = stamp
+	}
+	c.lock.Unlock()
+}
+
+//the timestamp of the latest write of a key and the node that made it, which breaks ties between timestamps.
+//a deleted key keeps a tombstone, the version of the delete expiring after tombstoneLifetime
+type keyVersion struct {
+	stamp   uint64
+	writer  string
+	expiry  uint64
+	deleted bool
+}
+
+func (kv keyVersion) newerThan(stamp uint64, writer string) bool {
+	return kv.stamp > stamp || (kv.stamp == stamp && kv.writer > writer)
+}
+
+func (kv keyVersion) expired(now uint64) bool {
+	return kv.expiry != bigcache.NO_EXPIRY && kv.expiry <= now
+}
+
+//writeVersions orders the puts of a key made on different nodes by the timestamp of the write rather than by the
+//order they arrive in, which differs from node to node. a replicated put older than the latest write of the key
+//is discarded so every node ends up with the value written last. a delete is ordered the same way, a put older
+//than it is discarded as long as its tombstone is kept. the versions of keys are kept until they expire or the
+//local cache evicts them
+type writeVersions struct {
+	node     *ClusteredBigCache //set by bind, the cache evicting before
+	clock    hybridClock
+	keyLocks keyLocks //held around writing a key and keeping its version, so the two are never interleaved
+	lock     sync.Mutex
+	versions map[string]keyVersion
+	swept    int    //versions left after the last sweep
+	stale    uint64 //replicated puts and deletes discarded for being older than the local copy
+}
+
+//the write versions of the configuration, nil unless the last write wins
+func newWriteVersions(config *ClusteredBigCacheConfig) *writeVersions {
+	if !config.LastWriteWins {
+		return nil
+	}
+	return &writeVersions{versions: make(map[string]keyVersion)}
+}
+
+//bind the versions to the node whose writes they stamp
+func (v *writeVersions) bind(node *ClusteredBigCache) *writeVersions {
+	if v != nil {
+		v.node = node
+	}
+	return v
+}
+
+//have the cache tell the versions about the entries it expires or evicts, along with the callbacks set before,
+//so the versions of keys no longer cached are not kept forever
+func (v *writeVersions) hook(cfg *bigcache.Config) {
+	if v == nil {
+		return
+	}
+
+	onRemove, onRemoveWithReason := cfg.OnRemove, cfg.OnRemoveWithReason
+	cfg.OnRemoveWithReason = func(key string, entry []byte, reason bigcache.RemoveReason) {
+		if onRemoveWithReason != nil {
+			onRemoveWithReason(key, entry, reason)
+		} else if onRemove != nil {
+			onRemove(key, entry)
+		}
+		if reason != bigcache.Deleted { //a delete leaves a tombstone
+			v.removed(key)
+		}
+	}
+}
+
+//forget the version of a key the cache removed. called with the lock of the shard held so it must not block
+func (v *writeVersions) removed(key string) {
+	v.lock.Lock()
+	if kv, ok := v.versions[key]; ok && !kv.deleted {
+		delete(v.versions, key)
+	}
+	v.lock.Unlock()
+}
+
+//lock key while it is written locally and its version kept, the function returned unlocks it
+func (v *writeVersions) lockKey(key string) func() {
+	if v == nil {
+		return func() {}
+	}
+	lock := v.keyLocks.stripe(key)
+	lock.Lock()
+	return lock.Unlock
+}
+
+//a timestamp for a write sent to the cluster without being stored locally, 0 unless the last write wins
+func (v *writeVersions) next() uint64 {
+	if v == nil {
+		return 0
+	}
+	return v.clock.now(v.node.clock.Now())
+}
+
+//stamp a write of key made on this node, keeping its version. 0 unless the last write wins
+func (v *writeVersions) stamp(key string, expiry uint64) uint64 {
+	if v == nil {
+		return 0
+	}
+
+	stamp := v.clock.now(v.node.clock.Now())
+	v.lock.Lock()
+	v.versions[key] = keyVersion{stamp: stamp, writer: v.node.config.Id, expiry: expiry}
+	v.sweep()
+	v.lock.Unlock()
+	return stamp
+}
+
+//check a put of key replicated by the node writer, keeping its version. false if the local copy was written
+//after it. puts without a timestamp, from nodes that do not order writes, are applied as they always were
+func (v *writeVersions) admit(key string, stamp uint64, writer string, expiry uint64) bool {
+	if v == nil || stamp == 0 {
+		return true
+	}
+	return v.keep(key, keyVersion{stamp: stamp, writer: writer, expiry: expiry})
+}
+
+//stamp a delete of key made on this node, keeping its tombstone. 0 unless the last write wins
+func (v *writeVersions) delete(key string) uint64 {
+	if v == nil {
+		return 0
+	}
+
+	now := v.node.clock.Now()
+	stamp := v.clock.now(now)
+	v.lock.Lock()
+	v.versions[key] = keyVersion{stamp: stamp, writer: v.node.config.Id, expiry: uint64(now.Add(tombstoneLifetime).Unix()), deleted: true}
+	v.sweep()
+	v.lock.Unlock()
+	return stamp
+}
+
+//check a delete of key replicated by the node writer, keeping its tombstone. false if the local copy was written
+//after it. deletes without a timestamp, from nodes that do not order writes, are applied as they always were
+func (v *writeVersions) admitDelete(key string, stamp uint64, writer string) bool {
+	if v == nil {
+		return true
+	}
+	if stamp == 0 {
+		v.forget(key)
+		return true
+	}
+	expiry := uint64(v.node.clock.Now().Add(tombstoneLifetime).Unix())
+	return v.keep(key, keyVersion{stamp: stamp, writer: writer, expiry: expiry, deleted: true})
+}
+
+//keep the version of a write received from a remote node unless the local copy was written after it
+func (v *writeVersions) keep(key string, kv keyVersion) bool {
+	v.clock.observe(kv.stamp)
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if current, ok := v.versions[key]; ok && !current.expired(uint64(v.node.clock.Now().Unix())) && current.newerThan(kv.stamp, kv.writer) {
+		v.stale++
+		return false
+	}
+	v.versions[key] = kv
+	v.sweep()
+	return true
+}
+
+//forget the version of a key no longer cached
+func (v *writeVersions) forget(key string) {
+	if v == nil {
+		return
+	}
+
+	v.lock.Lock()
+	delete(v.versions, key)
+	v.lock.Unlock()
+}
+
+//drop the versions of expired keys and tombstones once the versions doubled since the last sweep. must hold the lock
+func (v *writeVersions) sweep() {
+	if len(v.versions) < minVersionsSweep || len(v.versions) < 2*v.swept {
+		return
+	}
+
+	now := uint64(v.node.clock.Now().Unix())
+	for key, kv := range v.versions {
+		if kv.expired(now) {
+			delete(v.versions, key)
+		}
+	}
+	v.swept = len(v.versions)
+}
+
+//number of replicated puts and deletes discarded for being older than the local copy
+func (v *writeVersions) staleCount() uint64 {
+	if v == nil {
+		return 0
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.stale
+}
@@ -0,0 +1,157 @@
+package cluster
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+	"github.com/nggenius/ngbigcache/message"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//bytes of keys and values handed over in a single leave message
+const leaveBatchSize = 1 << 20
+
+//leaveHandoff counts the leave messages that have not been acknowledged yet. the node leaving holds one count
+//itself until every message is sent, so done is not closed while messages are still being sent
+type leaveHandoff struct {
+	pending int64
+	done    chan struct{}
+}
+
+func newLeaveHandoff() *leaveHandoff {
+	return &leaveHandoff{pending: 1, done: make(chan struct{})}
+}
+
+func (h *leaveHandoff) add() {
+	atomic.AddInt64(&h.pending, 1)
+}
+
+func (h *leaveHandoff) ack() {
+	if atomic.AddInt64(&h.pending, -1) == 0 {
+		close(h.done)
+	}
+}
+
+//Leave hands every key of this node over to the other active nodes, waits for them to acknowledge the keys,
+//announces that this node is leaving then shuts it down. unlike ShutDown no key held only by this node is lost.
+//writes are refused with ErrLeaving while the keys are handed over. when not every node acknowledged the keys
+//within timeout the node is shut down all the same and ErrLeaveIncomplete is returned
+func (node *ClusteredBigCache) Leave(timeout time.Duration) error {
+	if node.state != clusterStateStarted {
+		return ErrNotStarted
+	}
+	if !atomic.CompareAndSwapInt32(&node.leaving, 0, 1) {
+		return ErrLeaving
+	}
+
+	peers := make([]*remoteNode, 0)
+	for _, v := range node.getRemoteNodes() {
+		if r := v.(*remoteNode); r.mode == clusterModeACTIVE {
+			peers = append(peers, r)
+		}
+	}
+
+	handoff := newLeaveHandoff()
+	var seq uint64
+	send := func(entries []message.LeaveEntry, final bool) {
+		seq++
+		for _, r := range peers {
+			r.handOver(&message.LeaveMessage{Seq: seq, Entries: entries, Final: final}, handoff)
+		}
+	}
+
+	keys := 0
+	batch := make([]message.LeaveEntry, 0)
+	if node.mode == clusterModeACTIVE && len(peers) > 0 {
+		now := uint64(node.clock.Now().Unix())
+		size := 0
+		for it := node.cache.Iterator(); it.SetNext(); {
+			entry, err := it.Value()
+			if err != nil { //removed while iterating
+				continue
+			}
+			data, expiry, err := node.cache.GetWithExpiry(entry.Key())
+			if err != nil || (expiry != bigcache.NO_EXPIRY && expiry <= now) {
+				continue
+			}
+
+			batch = append(batch, message.LeaveEntry{Key: entry.Key(), Data: data, Expiry: expiry})
+			size += len(entry.Key()) + len(data)
+			keys++
+			if size >= leaveBatchSize {
+				send(batch, false)
+				batch, size = make([]message.LeaveEntry, 0), 0
+			}
+		}
+	}
+	send(batch, true) //the last batch announces the departure
+	utils.Info(node.logger, fmt.Sprintf("leaving the cluster, handing %d keys over to %d nodes", keys, len(peers)))
+	handoff.ack()
+
+	var err error
+	timer := node.clock.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-handoff.done:
+	case <-timer.C():
+		utils.Warn(node.logger, fmt.Sprintf("not every node acknowledged the keys handed over within %s", timeout))
+		err = ErrLeaveIncomplete
+	}
+
+	node.ShutDown()
+	return err
+}
+
+//send a batch of keys to the remote node, the handoff is acknowledged once the remote node has stored them
+func (r *remoteNode) handOver(msg *message.LeaveMessage, handoff *leaveHandoff) {
+	if r.state == nodeStateDisconnected {
+		return
+	}
+	handoff.add()
+	r.pendingLeave.Store(msg.Seq, handoff)
+	r.sendMessage(msg)
+}
+
+//a remote node leaving the cluster handed keys over. only keys this node does not hold are stored, a copy
+//held here is at least as recent as the one handed over. with a ConflictResolver a copy held here that
+//differs is resolved against the one handed over instead
+func (r *remoteNode) handleLeave(msg *message.NodeWireMessage) {
+	leaveMsg := message.LeaveMessage{}
+	leaveMsg.DeSerialize(msg)
+
+	if r.parentNode.mode == clusterModeACTIVE {
+		for _, entry := range leaveMsg.Entries {
+			remote := Entry{Data: entry.Data, Expiry: entry.Expiry}
+			if resolved, conflict := r.parentNode.resolveConflict(entry.Key, remote); conflict {
+				_, err := r.parentNode.storeEntry(entry.Key, resolved)
+				r.replicaWriteFailed("hand over", entry.Key, err)
+				continue
+			}
+			if _, err := r.parentNode.cache.Get(entry.Key); err == nil {
+				continue
+			}
+			_, err := r.parentNode.storeEntry(entry.Key, remote)
+			r.replicaWriteFailed("hand over", entry.Key, err)
+		}
+	}
+
+	if leaveMsg.Final {
+		r.config.ReconnectOnDisconnect = false //it is not coming back
+		utils.Info(r.logger, fmt.Sprintf("remote node '%s' is leaving the cluster", r.config.Id))
+	}
+	r.sendMessage(&message.LeaveAckMessage{Seq: leaveMsg.Seq})
+}
+
+func (r *remoteNode) handleLeaveAck(msg *message.NodeWireMessage) {
+	ackMsg := message.LeaveAckMessage{}
+	ackMsg.DeSerialize(msg)
+	handoff, ok := r.pendingLeave.Load(ackMsg.Seq)
+	if !ok {
+		return
+	}
+
+	r.pendingLeave.Delete(ackMsg.Seq)
+	handoff.(*leaveHandoff).ack()
+}
@@ -0,0 +1,143 @@
+package cluster
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//longest ShutDown waits for the messages queued to be written to the remote nodes before closing the connections
+const shutDownFlushTimeout = time.Second
+
+//the goroutines of a node and of its remote nodes live by these rules, so none outlives ShutDownAndWait:
+//  - the node has a context cancelled by ShutDown. every remote node has a context derived from it, also cancelled
+//    by shutDown() and as soon as any actor of the run.Group of the remote node returns
+//  - every goroutine is started through the routines of the node and, rather than sleeping or blocking on a
+//    channel alone, also waits on the context of its remote node or of the node and returns once it is done.
+//    the profiles of the auto-profiler, which exists before the node does, are captured through routines of
+//    its own that its close, called by ShutDown, waits for
+//  - the http servers return once ShutDown closes them, and the copying of under-replicated keys is waited for
+//    by ShutDown before the local cache is closed
+//  - goroutines blocked reading or writing a connection return once the connection is closed, which the
+//    run.Group of the remote node does when it stops and ShutDown does for the listeners
+//  - once ShutDown begins no goroutine is started any more, a remote node connecting then is closed straight away
+
+//routines counts the goroutines started for the node and its remote nodes so shutting down can wait for them
+type routines struct {
+	lock    sync.Mutex
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+//run fn on a goroutine of its own, unless shutting down began. false when fn was not started
+func (g *routines) spawn(fn func()) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.stopped {
+		return false
+	}
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		fn()
+	}()
+	return true
+}
+
+//start no goroutine any more
+func (g *routines) stop() {
+	g.lock.Lock()
+	g.stopped = true
+	g.lock.Unlock()
+}
+
+//serve server on listener from a goroutine of the node, until ShutDown closes the server
+func (node *ClusteredBigCache) serve(server *http.Server, listener net.Listener) {
+	if !node.routines.spawn(func() { server.Serve(listener) }) {
+		listener.Close()
+	}
+}
+
+//wait for d on the clock of the node unless ctx is done first, false when it was
+func (node *ClusteredBigCache) sleep(ctx context.Context, d time.Duration) bool {
+	timer := node.clock.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C():
+		return true
+	}
+}
+
+//wait up to timeout for the writes replicated and the messages queued for the remote nodes to be written to
+//them, so those made just before shutting down are not lost with the connections. real time is waited, not
+//the clock of the node, since it is the network that is waited for
+func (node *ClusteredBigCache) flush(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for node.unsent() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+//writes waiting to be replicated and messages waiting to be written to the remote nodes
+func (node *ClusteredBigCache) unsent() int64 {
+	unsent := int64(0)
+	for _, v := range node.getRemoteNodes() {
+		r := v.(*remoteNode)
+		unsent += atomic.LoadInt64(&r.unsent)
+		r.lanesLock.RLock()
+		for _, lane := range r.lanes {
+			unsent += int64(len(lane.queue))
+		}
+		r.lanesLock.RUnlock()
+	}
+	return unsent
+}
+
+//stop sending to every remote node then wait up to timeout for them to close the connections. a remote node
+//reads everything sent before it sees the connection closed, whereas closing a connection with messages of the
+//remote node not read yet resets it and the remote node loses those it had not read yet of this node
+func (node *ClusteredBigCache) hangUp(timeout time.Duration) {
+	hungUp := make([]*remoteNode, 0)
+	for _, v := range node.getRemoteNodes() {
+		if r := v.(*remoteNode); r.closeWrite() {
+			hungUp = append(hungUp, r)
+		}
+	}
+
+	deadline := time.After(timeout)
+	for _, r := range hungUp {
+		select {
+		case <-r.ctx.Done(): //the network consumer read the connection to its end
+		case <-deadline:
+			return
+		}
+	}
+}
+
+//stop sending on every connection to the remote node, false when they can not all be half closed
+func (r *remoteNode) closeWrite() bool {
+	if r.connection == nil { //never connected
+		return false
+	}
+	r.lanesLock.RLock()
+	defer r.lanesLock.RUnlock()
+
+	closed := r.connection.CloseWrite()
+	for _, lane := range r.lanes {
+		closed = lane.connection.CloseWrite() && closed
+	}
+	return closed
+}
+
+//ShutDownAndWait shuts the node down as ShutDown does then blocks until every goroutine of the node and of its
+//remote nodes returned. it must not be called from a callback of the node, e.g an OnEvent, which would wait on itself
+func (node *ClusteredBigCache) ShutDownAndWait() {
+	node.ShutDown()
+	node.routines.wg.Wait()
+}
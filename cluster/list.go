@@ -0,0 +1,86 @@
+package cluster
+
+import (
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+	"github.com/nggenius/ngbigcache/message"
+)
+
+//Append adds items to the end of the list under key in the cluster, creating it if there is none, and drops
+//the oldest items beyond maxLen, no limit if maxLen is not above zero. a new list expires after duration, an
+//existing one keeps its expiry. it is meant for bounded event logs or recent items kept per user. the list is
+//a single entry so it is read whole by remote nodes, keep it small.
+//the append itself is replicated, every node applies the appends in the order it receives them. those of a
+//single writer reach every node in the order they were made, so the copies of a list written through one node
+//or client converge. appends to the same list made at the same time through different nodes may be applied in
+//another order on each node and leave copies with their items in a different order, or with different items
+//once maxLen trims them, for good. write a list shared by several writers through one of them
+func (node *ClusteredBigCache) Append(key string, items [][]byte, maxLen int, duration time.Duration) error {
+
+	if node.state != clusterStateStarted {
+		return ErrNotStarted
+	}
+	if err := node.admitWrite(); err != nil {
+		return err
+	}
+
+	//append locally first
+	expiryTime := bigcache.NO_EXPIRY
+	if node.mode == clusterModeACTIVE {
+		if err := node.throttle.admit(); err != nil {
+			return err
+		}
+		var err error
+		expiryTime, err = node.cache.Append(key, items, maxLen, duration)
+		if err != nil {
+			return err
+		}
+		node.watchers.notify(key, false)
+	} else if duration != time.Duration(bigcache.NO_EXPIRY) {
+		expiryTime = uint64(node.clock.Now().Unix()) + uint64(duration.Seconds())
+	}
+	node.bandwidth.wrote(len(key) + itemsSize(items))
+
+	peers := node.remoteNodes.Values()
+	for x := 0; x < len(peers); x++ {
+		if peers[x].(*remoteNode).mode == clusterModePASSIVE {
+			continue
+		}
+		node.queueReplication(&replicationMsg{r: peers[x].(*remoteNode),
+			m: &message.AppendMessage{Key: key, Items: items, MaxLen: maxLen, Expiry: expiryTime}})
+	}
+	return nil
+}
+
+//ReadRange returns the items of the list under key from start to stop, both included. negative indexes count
+//from the end of the list, -1 being its last item. the list is read like Get reads a key
+func (node *ClusteredBigCache) ReadRange(key string, start, stop int, timeout time.Duration) ([][]byte, error) {
+	data, err := node.Get(key, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return bigcache.ListRange(data, start, stop)
+}
+
+func (r *remoteNode) handleAppend(msg *message.NodeWireMessage) {
+
+	appendMsg := message.AppendMessage{}
+	appendMsg.DeSerialize(msg)
+	if !r.admitReplicatedWrite(msg.Code, appendMsg.Key) || !r.admitThrottledWrite(msg.Code, appendMsg.Key) {
+		return
+	}
+
+	var err error
+	if appendMsg.Expiry == bigcache.NO_EXPIRY {
+		_, err = r.parentNode.cache.Append(appendMsg.Key, appendMsg.Items, appendMsg.MaxLen, 0)
+	} else { //the expiry is an absolute time so keep it as is rather than recomputing a duration
+		_, err = r.parentNode.cache.AppendUntil(appendMsg.Key, appendMsg.Items, appendMsg.MaxLen,
+			time.Unix(int64(appendMsg.Expiry), 0))
+	}
+	if err != nil {
+		r.replicaWriteFailed("replicate append", appendMsg.Key, err)
+		return
+	}
+	r.parentNode.watchers.notify(appendMsg.Key, false)
+}
@@ -0,0 +1,110 @@
+package cluster
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nggenius/ngbigcache/message"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//how long the owner of a lock key is waited for by Lock and Unlock
+const lockTimeout = time.Second * 2
+
+//errors of the distributed locks
+var (
+	ErrLocked    = errors.New("key is locked by another owner")
+	ErrNotLocked = errors.New("key is not locked with this token, it expired or was locked by another owner since")
+	ErrLockTTL   = errors.New("a lock is held for a second at least and must expire")
+)
+
+//Lock takes the distributed lock named key for ttl, after which it is released unless Unlock was called first.
+//the lock is a key of the cache stored with SetIfAbsent, holding a token only the caller gets which Unlock has
+//to be given back. ErrLocked is returned while another owner holds it. the lock shares the guarantees of
+//SetIfAbsent, while the active nodes change two owners may each take it once, and ErrNodeDisconnected leaves
+//it unknown whether the lock was taken, it then expires after ttl if it was
+func (node *ClusteredBigCache) Lock(key string, ttl time.Duration) (string, error) {
+	if ttl < time.Second {
+		return "", ErrLockTTL
+	}
+
+	token := node.config.Id + ":" + utils.GenerateNodeId(16)
+	locked, err := node.SetIfAbsent(key, []byte(token), ttl, lockTimeout)
+	if err != nil {
+		return "", err
+	}
+	if !locked {
+		return "", ErrLocked
+	}
+	return token, nil
+}
+
+//Unlock releases the lock named key taken by Lock with token. ErrNotLocked is returned when the lock is not held
+//with token, it expired and may have been taken by another owner since
+func (node *ClusteredBigCache) Unlock(key, token string) error {
+	if node.state != clusterStateStarted {
+		return ErrNotStarted
+	}
+	if err := node.admitWrite(); err != nil {
+		return err
+	}
+
+	owner, found := node.keyOwner(key)
+	if !found {
+		return ErrNoPeers
+	}
+	var released bool
+	var err error
+	if owner == nil {
+		released, err = node.releaseLocally(key, []byte(token))
+	} else {
+		released, err = node.askRelease(owner, key, token)
+	}
+	if err != nil {
+		return err
+	}
+	if !released {
+		return ErrNotLocked
+	}
+	return nil
+}
+
+//ask the remote node owning the lock named key to release it
+func (node *ClusteredBigCache) askRelease(owner *remoteNode, key, token string) (bool, error) {
+	replies := make(chan setIfAbsentReply, 1)
+	pendingKey := key + utils.GenerateNodeId(8)
+	if err := owner.askOwner(&message.SetIfAbsentReqMessage{Key: key, Data: []byte(token), PendingKey: pendingKey,
+		Release: true}, pendingKey, replies); err != nil {
+		return false, err
+	}
+	defer owner.pending.cancel(message.MsgSETNXReq, pendingKey)
+
+	timer := node.clock.NewTimer(lockTimeout)
+	defer timer.Stop()
+	select {
+	case reply := <-replies:
+		return reply.stored, reply.err
+	case <-timer.C():
+		return false, ErrTimeout
+	}
+}
+
+//delete the key if it holds token, replicating the delete to the remote nodes when deleted
+func (node *ClusteredBigCache) releaseLocally(key string, token []byte) (bool, error) {
+	if err := node.throttle.admit(); err != nil {
+		return false, err
+	}
+
+	unlock := node.versions.lockKey(key)
+	released, _ := node.cache.DeleteIfEqual(key, token) //not found only means the lock is not held
+	if !released {
+		unlock()
+		return false, nil
+	}
+	stamp := node.versions.delete(key)
+	unlock()
+
+	node.watchers.notify(key, true)
+	node.replicateDelete(key, stamp)
+	return true, nil
+}
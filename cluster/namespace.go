@@ -0,0 +1,123 @@
+package cluster
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+)
+
+//put in front of the name of a namespace and between it and the keys of the namespace, so keys of different
+//namespaces, and keys outside of any, never collide
+const namespaceSeparator = "\x1f"
+
+//Namespace is a logical cache of its own within the cluster, for several applications to share a cluster without
+//prefixing their keys themselves. its keys are stored and replicated under the name of the namespace, so every
+//node of the cluster serves it without being told about it, and it keeps a default ttl and stats of its own
+type Namespace struct {
+	defaultTTL int64 //time.Duration of the entries put without one
+	puts       uint64
+	hits       uint64
+	misses     uint64
+	deletes    uint64
+	node       *ClusteredBigCache
+	name       string
+	prefix     string
+}
+
+//NamespaceStats is what was done with the keys of a namespace through this node
+type NamespaceStats struct {
+	Name       string        `json:"name"`
+	DefaultTTL time.Duration `json:"default_ttl"` //time.Duration(bigcache.NO_EXPIRY) when entries do not expire
+	Puts       uint64        `json:"puts"`
+	Hits       uint64        `json:"hits"`
+	Misses     uint64        `json:"misses"` //reads that failed, whether not found or timed out
+	Deletes    uint64        `json:"deletes"`
+}
+
+//Namespace returns the namespace called name, created on first use with entries that do not expire. every call
+//with the same name returns the same namespace. the name must not contain the byte 0x1f
+func (node *ClusteredBigCache) Namespace(name string) *Namespace {
+	ns, _ := node.namespaces.LoadOrStore(name, &Namespace{node: node, name: name,
+		prefix: namespaceSeparator + name + namespaceSeparator, defaultTTL: int64(bigcache.NO_EXPIRY)})
+	return ns.(*Namespace)
+}
+
+//NamespaceStats returns the stats of every namespace used through this node, by name
+func (node *ClusteredBigCache) NamespaceStats() []NamespaceStats {
+	stats := make([]NamespaceStats, 0)
+	node.namespaces.Range(func(_, ns interface{}) bool {
+		stats = append(stats, ns.(*Namespace).Stats())
+		return true
+	})
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+//Name returns the name of the namespace
+func (ns *Namespace) Name() string {
+	return ns.name
+}
+
+//Key returns the key the cluster stores key of the namespace under, for the calls Namespace does not have
+func (ns *Namespace) Key(key string) string {
+	return ns.prefix + key
+}
+
+//SetDefaultTTL sets the time the entries put without one are kept for, time.Duration(bigcache.NO_EXPIRY) for
+//them not to expire
+func (ns *Namespace) SetDefaultTTL(ttl time.Duration) {
+	atomic.StoreInt64(&ns.defaultTTL, int64(ttl))
+}
+
+//Put adds data into the namespace, kept for its default ttl
+func (ns *Namespace) Put(key string, data []byte) error {
+	return ns.PutWithTTL(key, data, time.Duration(atomic.LoadInt64(&ns.defaultTTL)))
+}
+
+//PutWithTTL adds data into the namespace, kept for duration
+func (ns *Namespace) PutWithTTL(key string, data []byte, duration time.Duration) error {
+	if err := ns.node.Put(ns.Key(key), data, duration); err != nil {
+		return err
+	}
+	atomic.AddUint64(&ns.puts, 1)
+	return nil
+}
+
+//Get retrieves data of the namespace from the cluster
+func (ns *Namespace) Get(key string, timeout time.Duration) ([]byte, error) {
+	data, err := ns.node.Get(ns.Key(key), timeout)
+	if err != nil {
+		atomic.AddUint64(&ns.misses, 1)
+		return nil, err
+	}
+	atomic.AddUint64(&ns.hits, 1)
+	return data, nil
+}
+
+//TTL returns the time left before key of the namespace expires, see ClusteredBigCache.TTL
+func (ns *Namespace) TTL(key string, timeout time.Duration) (time.Duration, error) {
+	return ns.node.TTL(ns.Key(key), timeout)
+}
+
+//Delete removes key of the namespace from the cluster
+func (ns *Namespace) Delete(key string) error {
+	if err := ns.node.Delete(ns.Key(key)); err != nil {
+		return err
+	}
+	atomic.AddUint64(&ns.deletes, 1)
+	return nil
+}
+
+//Stats returns what was done with the keys of the namespace through this node
+func (ns *Namespace) Stats() NamespaceStats {
+	return NamespaceStats{
+		Name:       ns.name,
+		DefaultTTL: time.Duration(atomic.LoadInt64(&ns.defaultTTL)),
+		Puts:       atomic.LoadUint64(&ns.puts),
+		Hits:       atomic.LoadUint64(&ns.hits),
+		Misses:     atomic.LoadUint64(&ns.misses),
+		Deletes:    atomic.LoadUint64(&ns.deletes),
+	}
+}
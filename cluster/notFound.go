@@ -0,0 +1,69 @@
+package cluster
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nggenius/ngbigcache/message"
+)
+
+//ErrNotFoundTTL is returned when a key would be cached as not found for less than a second or for ever
+var ErrNotFoundTTL = errors.New("a key is cached as not found for a second at least and must expire")
+
+//PutNotFound caches across the cluster that key has no value, for duration, typically after the store the cache is
+//in front of did not find it either. Get on an active node then fails straight away with an error matching
+//bigcache.ErrCachedNotFound rather than asking the remote nodes, and the store again, for the key. a write of the
+//key replaces it like it replaces any value
+func (node *ClusteredBigCache) PutNotFound(key string, duration time.Duration) error {
+	if node.state != clusterStateStarted {
+		return ErrNotStarted
+	}
+	if duration < time.Second {
+		return ErrNotFoundTTL
+	}
+	if err := node.admitWrite(); err != nil {
+		return err
+	}
+
+	expiryTime := uint64(node.clock.Now().Unix()) + uint64(duration.Seconds())
+	if node.mode == clusterModeACTIVE {
+		if _, err := node.cache.SetNotFoundUntil(key, time.Unix(int64(expiryTime), 0)); err != nil {
+			return err
+		}
+		node.versions.forget(key)
+		node.watchers.notify(key, true)
+	}
+	if node.coalescer != nil { //a pending coalesced write must not bring the key back
+		node.coalescer.discard(key)
+	}
+	node.evictions.discard(key)
+	node.bandwidth.wrote(len(key))
+
+	for _, peer := range node.activePeers() {
+		if peer.version() >= message.MsgMinVersion(message.MsgNOTFOUND) {
+			node.queueReplication(&replicationMsg{r: peer, m: &message.NotFoundMessage{Key: key, Expiry: expiryTime}})
+		}
+	}
+	return nil
+}
+
+//the remote node cached a key as not found
+func (r *remoteNode) handleNotFound(msg *message.NodeWireMessage) {
+	notFoundMsg := message.NotFoundMessage{}
+	notFoundMsg.DeSerialize(msg)
+	if r.parentNode.mode != clusterModeACTIVE || !r.admitReplicatedWrite(msg.Code, notFoundMsg.Key) {
+		return
+	}
+	expiry, live := r.parentNode.replicatedExpiry(notFoundMsg.Expiry)
+	if !live {
+		return
+	}
+
+	if _, err := r.parentNode.cache.SetNotFoundUntil(notFoundMsg.Key, time.Unix(int64(expiry), 0)); err != nil {
+		r.replicaWriteFailed("replicate not found", notFoundMsg.Key, err)
+		return
+	}
+	r.parentNode.versions.forget(notFoundMsg.Key)
+	r.parentNode.watchers.notify(notFoundMsg.Key, true)
+	r.parentNode.evictions.discard(notFoundMsg.Key)
+}
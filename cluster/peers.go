@@ -0,0 +1,75 @@
+package cluster
+
+import (
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+)
+
+//What a read missing locally does when no active remote node is connected to ask
+const (
+	NO_PEERS_MODE_FAIL_FAST byte = iota //fail with ErrNoPeers straight away
+	NO_PEERS_MODE_WAIT                  //wait for an active remote node to connect, up to the read's timeout
+)
+
+//the active remote nodes, the ones that can be asked for data
+func (node *ClusteredBigCache) activePeers() []*remoteNode {
+	peers := make([]*remoteNode, 0)
+	for _, v := range node.getRemoteNodes() {
+		if r := v.(*remoteNode); r.mode == clusterModeACTIVE {
+			peers = append(peers, r)
+		}
+	}
+	return peers
+}
+
+//wait up to timeout for an active remote node to be ready, unless failing fast while no active node that closed
+//this passive client's connections for being idle is dialled again. the active remote nodes are returned along
+//with how long it took
+func (node *ClusteredBigCache) waitForActivePeers(timeout time.Duration) ([]*remoteNode, time.Duration) {
+	if woken := node.wakeIdlePeers(); !woken && node.config.NoPeersMode != NO_PEERS_MODE_WAIT {
+		return nil, 0
+	}
+	return node.awaitActivePeers(timeout)
+}
+
+//wait up to timeout for an active remote node to be ready
+func (node *ClusteredBigCache) awaitActivePeers(timeout time.Duration) ([]*remoteNode, time.Duration) {
+	start := node.clock.Now()
+	deadline := node.clock.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		ready := node.peerReadyChan()
+		if peers := node.activePeers(); len(peers) > 0 {
+			return peers, bigcache.Since(node.clock, start)
+		}
+
+		select {
+		case <-ready:
+		case <-deadline.C():
+			return nil, bigcache.Since(node.clock, start)
+		}
+	}
+}
+
+//closed the next time an active remote node is ready to be asked for data
+func (node *ClusteredBigCache) peerReadyChan() <-chan struct{} {
+	node.peerReadyLock.Lock()
+	defer node.peerReadyLock.Unlock()
+
+	if node.peerReady == nil {
+		node.peerReady = make(chan struct{})
+	}
+	return node.peerReady
+}
+
+//wake up the reads waiting for an active remote node
+func (node *ClusteredBigCache) eventPeerReady() {
+	node.peerReadyLock.Lock()
+	defer node.peerReadyLock.Unlock()
+
+	if node.peerReady != nil {
+		close(node.peerReady)
+		node.peerReady = nil
+	}
+}
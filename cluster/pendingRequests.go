@@ -0,0 +1,78 @@
+package cluster
+
+import (
+	"sync"
+)
+
+//a request sent to a remote node, waiting in pendingRequests for its answer
+type pendingRequest struct {
+	replies interface{} //the channel the answer is sent on
+	fail    func()      //sends ErrNodeDisconnected on replies, nil for requests left to time out instead
+}
+
+//what a pending request is found by, the code of the request message and the pending key its answer carries back
+type pendingId struct {
+	code uint16
+	key  string
+}
+
+//pendingRequests holds the requests sent to a remote node that wait for its answer. once the remote node goes
+//away fail answers every request still waiting with ErrNodeDisconnected, and the requests made afterwards are
+//refused with it, so a request racing a disconnection is never left waiting on a torn down remote node
+type pendingRequests struct {
+	lock     sync.Mutex
+	requests map[pendingId]pendingRequest
+	failed   bool
+}
+
+func newPendingRequests() *pendingRequests {
+	return &pendingRequests{requests: make(map[pendingId]pendingRequest)}
+}
+
+//add a request of code under key, ErrNodeDisconnected once the remote node went away. fail is called at most once,
+//and only when the request was not answered nor cancelled
+func (p *pendingRequests) add(code uint16, key string, replies interface{}, fail func()) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.failed {
+		return ErrNodeDisconnected
+	}
+
+	p.requests[pendingId{code: code, key: key}] = pendingRequest{replies: replies, fail: fail}
+	return nil
+}
+
+//take the request of code under key off to answer it, false when it timed out or failed already
+func (p *pendingRequests) take(code uint16, key string) (interface{}, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	id := pendingId{code: code, key: key}
+	request, ok := p.requests[id]
+	if ok {
+		delete(p.requests, id)
+	}
+	return request.replies, ok
+}
+
+//forget a request given up on
+func (p *pendingRequests) cancel(code uint16, key string) {
+	p.lock.Lock()
+	delete(p.requests, pendingId{code: code, key: key})
+	p.lock.Unlock()
+}
+
+//the remote node went away, fail every request still waiting on it and refuse those made from now on
+func (p *pendingRequests) fail() {
+	p.lock.Lock()
+	requests := p.requests
+	p.requests = make(map[pendingId]pendingRequest)
+	p.failed = true
+	p.lock.Unlock()
+
+	for _, request := range requests {
+		if request.fail != nil { //the replies are buffered for every answer waited for so this never blocks
+			request.fail()
+		}
+	}
+}
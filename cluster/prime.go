@@ -0,0 +1,50 @@
+package cluster
+
+import (
+	"fmt"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+	"github.com/nggenius/ngbigcache/message"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//the most read keys of the local cache, most read first, for as many keys and values as fit in budget bytes.
+//keys that are gone, expired or too large for what is left of the budget are skipped
+func (node *ClusteredBigCache) primeEntries(budget int) []*message.PutMessage {
+	var entries []*message.PutMessage
+	now := uint64(node.clock.Now().Unix())
+	for _, k := range node.hotKeys.top(0) {
+		data, expiry, err := node.cache.GetWithExpiry(k.Key)
+		if err != nil || (expiry != bigcache.NO_EXPIRY && expiry <= now) {
+			continue
+		}
+
+		size := len(k.Key) + len(data)
+		if size > budget {
+			continue
+		}
+		budget -= size
+		entries = append(entries, &message.PutMessage{Key: k.Key, Data: data, Expiry: expiry})
+	}
+
+	return entries
+}
+
+//a node that just joined through this node asked for the most read keys so it is useful before it
+//has seen enough writes. the keys are sent as ordinary put messages
+func (r *remoteNode) handlePrimeRequest(msg *message.NodeWireMessage) {
+	if r.parentNode.mode != clusterModeACTIVE {
+		return
+	}
+
+	primeMsg := message.PrimeReqMessage{}
+	primeMsg.DeSerialize(msg)
+	entries := r.parentNode.primeEntries(primeMsg.ByteBudget)
+	utils.Info(r.logger, fmt.Sprintf("priming '%s' with %d of the most read keys", r.config.Id, len(entries)))
+
+	r.parentNode.routines.spawn(func() {
+		for _, entry := range entries {
+			r.sendMessage(entry)
+		}
+	})
+}
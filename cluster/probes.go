@@ -0,0 +1,86 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//errors returned by the readiness and liveness probes
+var (
+	ErrListenerDown = errors.New("a listener of the node stopped accepting connections")
+	ErrWarming      = errors.New("node is still warming its cache up")
+	ErrNoActiveNode = errors.New("passive client is not connected to an active node")
+)
+
+//Healthy tells if the node is alive, started with every one of its listeners accepting connections. nil when it is,
+//otherwise why it is not. a node that is not healthy has to be restarted
+func (node *ClusteredBigCache) Healthy() error {
+	if node.state != clusterStateStarted {
+		return ErrNotStarted
+	}
+	if atomic.LoadInt32(&node.listening) < atomic.LoadInt32(&node.listeners) {
+		return ErrListenerDown
+	}
+	return nil
+}
+
+//Ready tells if the node is to be sent traffic. it has to be healthy, done running its WarmLoader, not leaving
+//the cluster and have quorum. a passive client also has to be connected to an active node. nil when it is,
+//otherwise why it is not
+func (node *ClusteredBigCache) Ready() error {
+	if atomic.LoadInt32(&node.warming) == 1 {
+		return ErrWarming
+	}
+	if err := node.Healthy(); err != nil {
+		return err
+	}
+	if atomic.LoadInt32(&node.leaving) == 1 {
+		return ErrLeaving
+	}
+	if !node.HasQuorum() {
+		return ErrNoQuorum
+	}
+	if node.mode == clusterModePASSIVE && len(node.activePeers()) == 0 {
+		return ErrNoActiveNode
+	}
+	return nil
+}
+
+//serve a probe, 200 when it passes otherwise 503 with why it did not
+func serveProbe(probe func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if err := probe(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	}
+}
+
+//register the probes on mux, /healthz for liveness and /readyz for readiness
+func (node *ClusteredBigCache) handleProbes(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", readOnlyRoute(serveProbe(node.Healthy)))
+	mux.HandleFunc("/readyz", readOnlyRoute(serveProbe(node.Ready)))
+}
+
+//bring up the http server serving only the probes on the probe port
+func (node *ClusteredBigCache) startProbeServer() error {
+	listener, err := net.Listen("tcp", node.debugAddress(node.config.ProbePort))
+	if err != nil {
+		utils.Error(node.logger, fmt.Sprintf("unable to start probe server on port %d. [%s]", node.config.ProbePort, err.Error()))
+		return err
+	}
+
+	mux := http.NewServeMux()
+	node.handleProbes(mux)
+	node.probeServer = &http.Server{Handler: mux}
+
+	node.serve(node.probeServer, listener)
+	utils.Info(node.logger, fmt.Sprintf("probe server listening on %s", listener.Addr().String()))
+	return nil
+}
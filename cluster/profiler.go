@@ -0,0 +1,198 @@
+package cluster
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+const (
+	//milliseconds the cpu profile runs for, when ProfileDuration is not set
+	defaultProfileDuration = 2000
+	//seconds at least between two profiles, when ProfileInterval is not set
+	defaultProfileInterval = 300
+)
+
+//ProfileStats tells what the auto-profiler captured
+type ProfileStats struct {
+	Captured uint64    `json:"captured"`         //profiles saved
+	Skipped  uint64    `json:"skipped"`          //spikes that came too soon after the last profile to capture another
+	Last     time.Time `json:"last,omitempty"`   //when the last profile was started
+	Reason   string    `json:"reason,omitempty"` //the spike the last profile was captured for
+	Files    []string  `json:"files,omitempty"`  //where the last profile was saved
+	Error    string    `json:"error,omitempty"`  //why the last profile could not be saved, if it could not
+}
+
+//autoProfiler captures a cpu and a heap profile when a read or write, or a pass of a shard expiring entries, takes
+//longer than its threshold. profiles are at least ProfileInterval apart so a node that keeps stalling is not
+//slowed down further by profiling it all the time
+type autoProfiler struct {
+	config       *ClusteredBigCacheConfig
+	logger       utils.AppLogger
+	latency      time.Duration //0 when reads and writes are not timed
+	evictionPass time.Duration //0 when eviction passes are not timed
+	duration     time.Duration
+	interval     time.Duration
+	last         int64 //unix nano time the last profile was started at
+	skipped      uint64
+	lock         sync.Mutex
+	latest       ProfileStats
+	done         chan struct{}
+	captures     routines //waited for by close
+}
+
+//the auto-profiler asked for by the config, nil if it is not
+func newAutoProfiler(config *ClusteredBigCacheConfig, logger utils.AppLogger) *autoProfiler {
+	if config.ProfileDir == "" || (config.ProfileLatency <= 0 && config.ProfileEvictionPass <= 0) {
+		return nil
+	}
+
+	p := &autoProfiler{
+		config:       config,
+		logger:       logger,
+		latency:      time.Millisecond * time.Duration(config.ProfileLatency),
+		evictionPass: time.Millisecond * time.Duration(config.ProfileEvictionPass),
+		duration:     time.Millisecond * defaultProfileDuration,
+		interval:     time.Second * defaultProfileInterval,
+		done:         make(chan struct{}),
+	}
+	if config.ProfileDuration > 0 {
+		p.duration = time.Millisecond * time.Duration(config.ProfileDuration)
+	}
+	if config.ProfileInterval > 0 {
+		p.interval = time.Second * time.Duration(config.ProfileInterval)
+	}
+	return p
+}
+
+//time a read or write started at started, meant to be deferred
+func (p *autoProfiler) observe(op string, started time.Time) {
+	if p == nil || p.latency == 0 {
+		return
+	}
+	if took := bigcache.Since(p.config.clock(), started); took >= p.latency {
+		p.trigger(fmt.Sprintf("%s took %s", op, took))
+	}
+}
+
+//time a pass of a shard expiring entries, called by the cache
+func (p *autoProfiler) observeEvictionPass(took time.Duration, expired int) {
+	if p.evictionPass > 0 && took >= p.evictionPass {
+		p.trigger(fmt.Sprintf("eviction pass expiring %d entries took %s", expired, took))
+	}
+}
+
+//capture a profile for reason unless the last one is too recent
+func (p *autoProfiler) trigger(reason string) {
+	now := p.config.clock().Now().UnixNano()
+	last := atomic.LoadInt64(&p.last)
+	if last != 0 && time.Duration(now-last) < p.interval {
+		atomic.AddUint64(&p.skipped, 1)
+		return
+	}
+	if !atomic.CompareAndSwapInt64(&p.last, last, now) { //another spike got there first
+		atomic.AddUint64(&p.skipped, 1)
+		return
+	}
+	p.captures.spawn(func() { p.capture(reason, time.Unix(0, now)) })
+}
+
+//profile the cpu for ProfileDuration, then the heap, saving both to ProfileDir
+func (p *autoProfiler) capture(reason string, started time.Time) {
+	base := filepath.Join(p.config.ProfileDir, fmt.Sprintf("%s-%s", p.config.Id, started.Format("20060102-150405.000")))
+	files, err := p.profile(base)
+
+	p.lock.Lock()
+	p.latest.Last, p.latest.Reason, p.latest.Files, p.latest.Error = started, reason, files, ""
+	if err != nil {
+		p.latest.Error = err.Error()
+	} else {
+		p.latest.Captured++
+	}
+	p.lock.Unlock()
+
+	if err != nil {
+		utils.Error(p.logger, fmt.Sprintf("could not profile the node after %s [%s]", reason, err))
+		return
+	}
+	msg := fmt.Sprintf("profiled the node after %s, saved to %v", reason, files)
+	utils.Warn(p.logger, msg)
+	if p.config.OnEvent != nil {
+		p.config.OnEvent(Event{Type: EventProfileCaptured, NodeId: p.config.Id, Message: msg, Time: p.config.clock().Now()})
+	}
+}
+
+//write the cpu and heap profiles to files named after base, returning those written
+func (p *autoProfiler) profile(base string) ([]string, error) {
+	if err := os.MkdirAll(p.config.ProfileDir, 0755); err != nil {
+		return nil, err
+	}
+
+	var files []string
+	cpu, err := os.Create(base + ".cpu.pprof")
+	if err != nil {
+		return nil, err
+	}
+	if err := pprof.StartCPUProfile(cpu); err != nil { //the cpu is being profiled already, by someone else
+		cpu.Close()
+		os.Remove(cpu.Name())
+		utils.Warn(p.logger, fmt.Sprintf("only profiling the heap [%s]", err))
+	} else {
+		timer := p.config.clock().NewTimer(p.duration)
+		select {
+		case <-timer.C():
+		case <-p.done:
+		}
+		timer.Stop()
+		pprof.StopCPUProfile()
+		if err := cpu.Close(); err != nil {
+			return files, err
+		}
+		files = append(files, cpu.Name())
+	}
+
+	heap, err := os.Create(base + ".heap.pprof")
+	if err != nil {
+		return files, err
+	}
+	if err := pprof.Lookup("heap").WriteTo(heap, 0); err != nil {
+		heap.Close()
+		return files, err
+	}
+	if err := heap.Close(); err != nil {
+		return files, err
+	}
+	return append(files, heap.Name()), nil
+}
+
+func (p *autoProfiler) close() {
+	if p != nil {
+		p.captures.stop()
+		close(p.done)
+		p.captures.wg.Wait()
+	}
+}
+
+func (p *autoProfiler) stats() *ProfileStats {
+	if p == nil {
+		return nil
+	}
+
+	p.lock.Lock()
+	stats := p.latest
+	p.lock.Unlock()
+	stats.Skipped = atomic.LoadUint64(&p.skipped)
+	return &stats
+}
+
+//Profiles returns what the auto-profiler captured, nil when ProfileDir or the thresholds are not set
+func (node *ClusteredBigCache) Profiles() *ProfileStats {
+	return node.profiler.stats()
+}
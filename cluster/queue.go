@@ -0,0 +1,170 @@
+package cluster
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+	"github.com/nggenius/ngbigcache/message"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//LPush adds items to the head of the list under key in the cluster, creating it if there is none, each in turn so
+//the last of them ends up first, and drops the items at the tail beyond maxLen, no limit if maxLen is not above
+//zero. a new list expires after duration, an existing one keeps its expiry. like SetIfAbsent the push is done by
+//the owner of the key, which replicates it, so pushes and pops of the list are applied in the same order by every
+//node. ErrNodeDisconnected is returned when the owner went away before answering, the items may or may not have
+//been pushed then
+func (node *ClusteredBigCache) LPush(key string, items [][]byte, maxLen int, duration, timeout time.Duration) error {
+	expiryTime := bigcache.NO_EXPIRY
+	if duration != time.Duration(bigcache.NO_EXPIRY) {
+		expiryTime = uint64(node.clock.Now().Unix()) + uint64(duration.Seconds())
+	}
+	_, err := node.listRequest(&message.ListMessage{Key: key, Op: message.ListOpPush, Items: items, MaxLen: maxLen,
+		Expiry: expiryTime}, timeout)
+	if err == nil {
+		node.bandwidth.wrote(len(key) + itemsSize(items))
+	}
+	return err
+}
+
+//RPop removes the last item of the list under key in the cluster and returns it, so along with LPush the list is
+//a work queue handing every item to a single caller across the cluster. the pop is done by the owner of the key
+//like LPush. bigcache.ErrListEmpty is returned when there is no item to pop. ErrNodeDisconnected is returned when
+//the owner went away before answering, an item may have been popped and lost then
+func (node *ClusteredBigCache) RPop(key string, timeout time.Duration) ([]byte, error) {
+	return node.listRequest(&message.ListMessage{Key: key, Op: message.ListOpPop}, timeout)
+}
+
+//have the owner of the key of msg push or pop, the item popped is returned
+func (node *ClusteredBigCache) listRequest(msg *message.ListMessage, timeout time.Duration) ([]byte, error) {
+	if node.state != clusterStateStarted {
+		return nil, ErrNotStarted
+	}
+	if err := node.admitWrite(); err != nil {
+		return nil, err
+	}
+
+	owner, found := node.keyOwner(msg.Key)
+	if !found {
+		return nil, ErrNoPeers
+	}
+	if owner == nil {
+		return node.applyList(msg)
+	}
+
+	replies := make(chan setIfAbsentReply, 1)
+	msg.PendingKey = msg.Key + utils.GenerateNodeId(8)
+	if err := owner.askOwner(msg, msg.PendingKey, replies); err != nil {
+		return nil, err
+	}
+	defer owner.pending.cancel(message.MsgSETNXReq, msg.PendingKey)
+
+	timer := node.clock.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case reply := <-replies:
+		return reply.data, reply.err
+	case <-timer.C():
+		return nil, ErrTimeout
+	}
+}
+
+//push or pop as the owner of the key, replicating it to the remote nodes when done. the key is locked until it
+//is queued for replication so the remote nodes get the changes of the list in the order they were made
+func (node *ClusteredBigCache) applyList(msg *message.ListMessage) ([]byte, error) {
+	if err := node.throttle.admit(); err != nil {
+		return nil, err
+	}
+
+	lock := node.listLocks.stripe(msg.Key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	item, err := node.applyListLocally(msg)
+	if err != nil {
+		return nil, err
+	}
+	node.watchers.notify(msg.Key, false)
+	for _, peer := range node.activePeers() {
+		if peer.version() >= message.MsgMinVersion(message.MsgLIST) {
+			node.queueReplication(&replicationMsg{r: peer,
+				m: &message.ListMessage{Key: msg.Key, Op: msg.Op, Items: msg.Items, MaxLen: msg.MaxLen, Expiry: msg.Expiry}})
+		}
+	}
+	return item, nil
+}
+
+//push or pop in the local cache, the item popped is returned
+func (node *ClusteredBigCache) applyListLocally(msg *message.ListMessage) ([]byte, error) {
+	switch msg.Op {
+	case message.ListOpPush:
+		var err error
+		if msg.Expiry == bigcache.NO_EXPIRY {
+			_, err = node.cache.LPush(msg.Key, msg.Items, msg.MaxLen, 0)
+		} else { //the expiry is an absolute time so keep it as is rather than recomputing a duration
+			_, err = node.cache.LPushUntil(msg.Key, msg.Items, msg.MaxLen, time.Unix(int64(msg.Expiry), 0))
+		}
+		node.evictions.discard(msg.Key)
+		return nil, err
+	case message.ListOpPop:
+		item, err := node.cache.RPop(msg.Key)
+		if errors.Is(err, bigcache.ErrEntryNotFound) { //no list has no item either
+			err = bigcache.ErrListEmpty
+		}
+		return item, err
+	}
+	return nil, errUnknownListOp
+}
+
+//a list message with an operation this build does not know
+var errUnknownListOp = errors.New("unknown list operation")
+
+func (r *remoteNode) handleListRequest(msg *message.NodeWireMessage) {
+	reqMsg := message.ListMessage{}
+	reqMsg.DeSerialize(msg)
+
+	rsp := &message.ListRspMessage{PendingKey: reqMsg.PendingKey}
+	err := r.parentNode.admitWrite()
+	if err == nil && r.parentNode.mode != clusterModeACTIVE {
+		err = errNotActive
+	}
+	if err == nil {
+		rsp.Item, err = r.parentNode.applyList(&reqMsg)
+	}
+	if err != nil {
+		rsp.Error = err.Error()
+	}
+	r.sendMessage(rsp)
+}
+
+func (r *remoteNode) handleListResponse(msg *message.NodeWireMessage) {
+	rspMsg := message.ListRspMessage{}
+	rspMsg.DeSerialize(msg)
+	replies, ok := r.pending.take(message.MsgSETNXReq, rspMsg.PendingKey)
+	if !ok { //the request timed out
+		return
+	}
+
+	reply := setIfAbsentReply{stored: rspMsg.Error == "", data: rspMsg.Item}
+	if rspMsg.Error != "" {
+		reply.err = remoteError(r.config.Id, rspMsg.Error)
+	}
+	//buffered for the one reply so this never blocks
+	replies.(chan setIfAbsentReply) <- reply
+}
+
+//the owner of a list pushed or popped
+func (r *remoteNode) handleList(msg *message.NodeWireMessage) {
+	listMsg := message.ListMessage{}
+	listMsg.DeSerialize(msg)
+	if !r.admitReplicatedWrite(msg.Code, listMsg.Key) || !r.admitThrottledWrite(msg.Code, listMsg.Key) {
+		return
+	}
+
+	if _, err := r.parentNode.applyListLocally(&listMsg); err != nil && err != bigcache.ErrListEmpty {
+		r.replicaWriteFailed("replicate list", listMsg.Key, err)
+		return
+	}
+	r.parentNode.watchers.notify(listMsg.Key, false)
+}
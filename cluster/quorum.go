@@ -0,0 +1,72 @@
+package cluster
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//What a node does while it sees fewer nodes than MinimumClusterSize
+const (
+	QUORUM_MODE_READ_ONLY byte = iota //writes fail with ErrNoQuorum, reads are still served
+	QUORUM_MODE_NOTIFY                //writes are still accepted, only the events are raised
+)
+
+//number of active nodes in the cluster as seen by this node, itself included if it is active
+func (node *ClusteredBigCache) clusterSize() int {
+	size := 0
+	if node.mode == clusterModeACTIVE {
+		size++
+	}
+	for _, v := range node.getRemoteNodes() {
+		if v.(*remoteNode).mode == clusterModeACTIVE {
+			size++
+		}
+	}
+	return size
+}
+
+//check the cluster size against MinimumClusterSize after a remote node came or went. losing quorum means
+//this node is likely on the small side of a network partition
+func (node *ClusteredBigCache) checkQuorum() {
+	if node.config.MinimumClusterSize < 1 {
+		return
+	}
+
+	size := node.clusterSize()
+	if size < node.config.MinimumClusterSize {
+		if atomic.CompareAndSwapInt32(&node.quorumLost, 0, 1) && node.state == clusterStateStarted {
+			msg := fmt.Sprintf("only %d of the minimum %d nodes are in the cluster", size, node.config.MinimumClusterSize)
+			utils.Warn(node.logger, msg)
+			node.emitEvent(EventQuorumLost, msg)
+		}
+	} else if atomic.CompareAndSwapInt32(&node.quorumLost, 1, 0) {
+		msg := fmt.Sprintf("%d nodes are in the cluster, quorum of %d regained", size, node.config.MinimumClusterSize)
+		utils.Info(node.logger, msg)
+		node.emitEvent(EventQuorumRegained, msg)
+	}
+}
+
+//fail writes while the node is leaving, read only, asked for the read only role or while quorum is lost, unless only notifying.
+//writes wait while a cluster snapshot holds them. a passive client first dials again the active nodes that closed its
+//connections for being idle
+func (node *ClusteredBigCache) admitWrite() error {
+	if atomic.LoadInt32(&node.leaving) == 1 {
+		return ErrLeaving
+	}
+	if node.IsReadOnly() || node.config.Role == PEER_ROLE_READ_ONLY {
+		return ErrReadOnly
+	}
+	if node.config.QuorumMode == QUORUM_MODE_READ_ONLY && atomic.LoadInt32(&node.quorumLost) == 1 {
+		return ErrNoQuorum
+	}
+	node.fence.wait()
+	node.wakeForWrite()
+	return nil
+}
+
+//HasQuorum checks if the node sees at least MinimumClusterSize nodes, always true when it is not set
+func (node *ClusteredBigCache) HasQuorum() bool {
+	return atomic.LoadInt32(&node.quorumLost) == 0
+}
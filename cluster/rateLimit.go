@@ -0,0 +1,119 @@
+package cluster
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nggenius/ngbigcache/message"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//tokenBucket allows rate events per second on average and up to burst events at once
+type tokenBucket struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst int, now time.Time) *tokenBucket {
+	if burst < rate {
+		burst = rate
+	}
+	return &tokenBucket{rate: float64(rate), burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+//take a token, false if there is none left
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+//inboundLimiter limits the writes and reads a remote node can send, so a misbehaving node can not starve
+//the handling of every other message. messages that are neither, e.g pings and responses, are never limited
+type inboundLimiter struct {
+	writes    *tokenBucket //put and delete messages
+	reads     *tokenBucket //get requests
+	threshold uint64       //drops within a second that close the connection, 0 never does
+	lock      sync.Mutex
+	window    time.Time
+	drops     uint64
+}
+
+//the inbound limits of the configuration, nil if there are none
+func newInboundLimiter(config *ClusteredBigCacheConfig) *inboundLimiter {
+	if config.InboundWriteRate <= 0 && config.InboundReadRate <= 0 {
+		return nil
+	}
+
+	now := config.clock().Now()
+	l := &inboundLimiter{threshold: uint64(config.FloodDisconnectThreshold), window: now}
+	if config.InboundWriteRate > 0 {
+		l.writes = newTokenBucket(config.InboundWriteRate, config.InboundBurst, now)
+	}
+	if config.InboundReadRate > 0 {
+		l.reads = newTokenBucket(config.InboundReadRate, config.InboundBurst, now)
+	}
+	return l
+}
+
+//the bucket limiting a message code, nil if it is not limited
+func (l *inboundLimiter) bucket(code uint16) *tokenBucket {
+	if isWrite(code) {
+		return l.writes
+	}
+	switch code {
+	case message.MsgGETReq, message.MsgTTLReq, message.MsgKEYSReq, message.MsgSTATSReq:
+		return l.reads
+	}
+	return nil
+}
+
+//admit a message from the remote node, counting and dropping it when over the limit of its class. a remote
+//node that goes over FloodDisconnectThreshold drops within a second is disconnected
+func (r *remoteNode) admitInbound(msg *message.NodeWireMessage) bool {
+	l := r.limiter
+	if l == nil {
+		return true
+	}
+	b := l.bucket(msg.Code)
+	now := r.parentNode.clock.Now()
+	if b == nil || b.allow(now) {
+		return true
+	}
+
+	atomic.AddUint64(&r.metrics.rateLimited, 1)
+	if l.threshold == 0 {
+		return false
+	}
+
+	l.lock.Lock()
+	if now.Sub(l.window) >= time.Second {
+		l.window, l.drops = now, 0
+	}
+	l.drops++
+	flooding := l.drops == l.threshold
+	l.lock.Unlock()
+
+	if flooding {
+		utils.Warn(r.logger, fmt.Sprintf("shutting down connection to remote node '%s' for flooding, %d messages dropped within a second",
+			r.config.Id, l.threshold))
+		r.shutDown()
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+	"github.com/nggenius/ngbigcache/message"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//how long the remote nodes are waited for when auditing a key to re-replicate
+const reReplicationTimeout = time.Second
+
+//ReReplicationStats tells how far copying the keys with fewer copies than ReplicationFactor to more nodes got
+type ReReplicationStats struct {
+	Factor  int  `json:"factor"`  //copies the keys ought to have, the replication factor when the key was checked
+	Checked int  `json:"checked"` //keys of the local cache checked so far
+	Under   int  `json:"under"`   //keys found with fewer copies than they ought to have
+	Copied  int  `json:"copied"`  //copies sent to remote nodes lacking the key
+	Done    bool `json:"done"`
+}
+
+//SetReplicationFactor changes the number of copies kept of every key across the cluster. the factor is sent to
+//the remote nodes like UpdateConfig does, and when it went up the keys of the local cache held by fewer nodes
+//are copied to additional remote nodes in the background, those rendezvous hashing ranks highest for the key
+//first. the progress is reported by ReReplication and the admin server
+func (node *ClusteredBigCache) SetReplicationFactor(rf int) error {
+	previous := node.config.ReplicationFactor
+	if err := node.UpdateConfig(ConfigUpdate{ReplicationFactor: &rf}); err != nil {
+		return err
+	}
+	if rf <= previous || node.mode != clusterModeACTIVE {
+		return nil
+	}
+
+	node.replicaLock.Lock()
+	defer node.replicaLock.Unlock()
+	if node.reReplication != nil && !node.reReplication.Done { //it reads the new factor as it goes
+		return nil
+	}
+	stats := &ReReplicationStats{Factor: rf}
+	node.reReplication = stats
+	node.reReplicating.Add(1)
+	if !node.routines.spawn(func() {
+		defer node.reReplicating.Done()
+		node.reReplicate(stats)
+	}) {
+		node.reReplicating.Done()
+		stats.Done = true
+	}
+	return nil
+}
+
+//ReReplication returns how far copying under-replicated keys got since the replication factor was last raised
+//on this node, nil if it never was
+func (node *ClusteredBigCache) ReReplication() *ReReplicationStats {
+	node.replicaLock.Lock()
+	defer node.replicaLock.Unlock()
+	if node.reReplication == nil {
+		return nil
+	}
+	stats := *node.reReplication
+	return &stats
+}
+
+//copy every key of the local cache held by fewer nodes than the replication factor to more remote nodes
+func (node *ClusteredBigCache) reReplicate(stats *ReReplicationStats) {
+	utils.Info(node.logger, fmt.Sprintf("copying the keys with fewer than %d copies to more nodes", stats.Factor))
+	for it := node.cache.Iterator(); it.SetNext() && node.state == clusterStateStarted; {
+		entry, err := it.Value()
+		if err != nil || entry.NotFound() { //removed while iterating, or no value to copy
+			continue
+		}
+		factor := node.config.ReplicationFactor
+		copied, under := node.reReplicateKey(entry.Key(), factor)
+
+		node.replicaLock.Lock()
+		stats.Factor = factor
+		stats.Checked++
+		stats.Copied += copied
+		if under {
+			stats.Under++
+		}
+		node.replicaLock.Unlock()
+	}
+
+	node.replicaLock.Lock()
+	stats.Done = true
+	node.replicaLock.Unlock()
+	utils.Info(node.logger, fmt.Sprintf("checked %d keys, copied %d keys with too few copies %d times",
+		stats.Checked, stats.Under, stats.Copied))
+}
+
+//copy key to as many of the remote nodes lacking it as it takes to have factor copies, or a copy on every
+//active node when there are fewer. the copies sent are returned and whether the key had too few
+func (node *ClusteredBigCache) reReplicateKey(key string, factor int) (int, bool) {
+	audit, err := node.AuditKey(key, reReplicationTimeout)
+	if err != nil {
+		return 0, false
+	}
+	want := factor
+	if want > audit.ExpectedReplicas {
+		want = audit.ExpectedReplicas
+	}
+	if audit.Replicas >= want {
+		return 0, false
+	}
+
+	var lacking []*remoteNode
+	for _, c := range audit.Copies {
+		if c.Found || c.TimedOut {
+			continue
+		}
+		if v, ok := node.remoteNodes.Get(c.NodeId); ok {
+			lacking = append(lacking, v.(*remoteNode))
+		}
+	}
+	sort.Slice(lacking, func(i, j int) bool {
+		return utils.WeightedScore(lacking[i].config.Id, key, lacking[i].weight) >
+			utils.WeightedScore(lacking[j].config.Id, key, lacking[j].weight)
+	})
+	if missing := want - audit.Replicas; len(lacking) > missing {
+		lacking = lacking[:missing]
+	}
+
+	data, expiry, err := node.cache.GetWithExpiry(key)
+	if err != nil || (expiry != bigcache.NO_EXPIRY && expiry <= uint64(node.clock.Now().Unix())) {
+		return 0, true
+	}
+	for _, r := range lacking {
+		r.sendMessage(&message.PutMessage{Key: key, Data: data, Expiry: expiry})
+	}
+	return len(lacking), true
+}
+
+//serve the replication factor and how far copying under-replicated keys got, or change the factor when posted
+//with the factor query parameter and AdminToken, e.g /replication-factor?factor=3
+func (node *ClusteredBigCache) handleAdminReplicationFactor(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost:
+		if !node.authorizeAdmin(w, req) {
+			return
+		}
+		rf, err := strconv.Atoi(req.URL.Query().Get("factor"))
+		if err != nil {
+			http.Error(w, "factor must be a number", http.StatusBadRequest)
+			return
+		}
+		if err = node.SetReplicationFactor(rf); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		ReplicationFactor int                 `json:"replication_factor"`
+		ReReplication     *ReReplicationStats `json:"re_replication,omitempty"`
+	}{node.config.ReplicationFactor, node.ReReplication()})
+}
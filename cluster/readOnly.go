@@ -0,0 +1,57 @@
+package cluster
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/nggenius/ngbigcache/message"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//What a read only node does with the writes replicated to it
+const (
+	READ_ONLY_MODE_REFUSE byte = iota //the write is not applied and the remote node is told so
+	READ_ONLY_MODE_DROP               //the write is silently not applied
+)
+
+//SetReadOnly switches read only mode on or off. a read only node rejects local writes with ErrReadOnly and does
+//not apply the writes replicated to it, while reads are still served and connections stay up. it is meant to
+//quiesce a node before maintenance
+func (node *ClusteredBigCache) SetReadOnly(readOnly bool) {
+	if readOnly {
+		if atomic.CompareAndSwapInt32(&node.readOnly, 0, 1) {
+			utils.Info(node.logger, "node is now read only")
+		}
+	} else if atomic.CompareAndSwapInt32(&node.readOnly, 1, 0) {
+		utils.Info(node.logger, "node is no longer read only")
+	}
+}
+
+//IsReadOnly checks if the node is in read only mode
+func (node *ClusteredBigCache) IsReadOnly() bool {
+	return atomic.LoadInt32(&node.readOnly) == 1
+}
+
+//admit a write replicated by the remote node, refusing or dropping it when read only
+func (r *remoteNode) admitReplicatedWrite(code uint16, key string) bool {
+	if !r.parentNode.IsReadOnly() {
+		return true
+	}
+
+	atomic.AddUint64(&r.parentNode.readOnlyDropped, 1)
+	if r.parentNode.config.ReadOnlyMode == READ_ONLY_MODE_REFUSE {
+		r.sendMessage(&message.RefusedMessage{MsgCode: code, Key: key, Reason: "read only"})
+	}
+	return false
+}
+
+//a write sent to the remote node was not applied
+func (r *remoteNode) handleRefused(msg *message.NodeWireMessage) {
+	refusedMsg := message.RefusedMessage{}
+	refusedMsg.DeSerialize(msg)
+
+	if atomic.AddUint64(&r.metrics.refused, 1) == 1 { //once, it is counted from then on
+		utils.Warn(r.logger, fmt.Sprintf("remote node '%s' refused %s of '%s' [%s]", r.config.Id,
+			message.MsgCodeToString(refusedMsg.MsgCode), refusedMsg.Key, refusedMsg.Reason))
+	}
+}
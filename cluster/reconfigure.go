@@ -0,0 +1,124 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+	"github.com/nggenius/ngbigcache/message"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//ConfigUpdate is the subset of the configuration UpdateConfig changes on a running node. a nil field is left as
+//it is
+type ConfigUpdate struct {
+	PingInterval          *int   `json:"ping_interval,omitempty"`            //seconds between pings to the remote nodes
+	PingTimeout           *int   `json:"ping_timeout,omitempty"`             //seconds a pong is waited for
+	PingFailureThreshHold *int32 `json:"ping_failure_thresh_hold,omitempty"` //pings without pong before a remote node is dropped
+	ReplicationFactor     *int   `json:"replication_factor,omitempty"`       //copies of a key, sent to the rest of the cluster
+	ReplicationRetryQueue *int   `json:"replication_retry_queue,omitempty"`  //writes kept per remote node, the node must have been started with some
+	LogRequests           *bool  `json:"log_requests,omitempty"`             //log every get and put with a correlation id
+}
+
+//UpdateConfig changes settings of the running node without restarting it or its connections. the ping settings
+//apply to the remote nodes already connected as well as to those connecting later. a change of ReplicationFactor
+//is sent to the remote nodes so the whole cluster keeps the same number of copies, except by passive clients,
+//which only change their own. an update with problems is refused as a whole with a *bigcache.ConfigError
+func (node *ClusteredBigCache) UpdateConfig(update ConfigUpdate) error {
+	if node.state != clusterStateStarted {
+		return ErrNotStarted
+	}
+	if errs := node.validateUpdate(update); len(errs) > 0 {
+		return &bigcache.ConfigError{Errors: errs}
+	}
+
+	if update.PingInterval != nil {
+		node.config.PingInterval = *update.PingInterval
+	}
+	if update.PingTimeout != nil {
+		node.config.PingTimeout = *update.PingTimeout
+	}
+	if update.PingFailureThreshHold != nil {
+		node.config.PingFailureThreshHold = *update.PingFailureThreshHold
+	}
+	if update.PingInterval != nil || update.PingTimeout != nil || update.PingFailureThreshHold != nil {
+		if node.config.PingTimeout > node.config.PingInterval {
+			utils.Warn(node.logger, "ping timeout is greater than ping interval, pings will NEVER timeout")
+		}
+		for _, v := range node.remoteNodes.Values() {
+			v.(*remoteNode).reconfigurePing(node.config)
+		}
+	}
+
+	if update.ReplicationRetryQueue != nil {
+		node.config.ReplicationRetryQueue = *update.ReplicationRetryQueue
+		node.retryQueue.resize(*update.ReplicationRetryQueue)
+	}
+	if update.LogRequests != nil {
+		node.config.LogRequests = *update.LogRequests
+	}
+
+	if update.ReplicationFactor != nil && *update.ReplicationFactor != node.config.ReplicationFactor {
+		node.setReplicationFactor(*update.ReplicationFactor)
+		if node.mode == clusterModeACTIVE {
+			for _, v := range node.remoteNodes.Values() {
+				v.(*remoteNode).sendMessage(&message.ConfigMessage{ReplicationFactor: node.config.ReplicationFactor})
+			}
+		}
+	}
+
+	utils.Info(node.logger, "configuration updated")
+	return nil
+}
+
+//the problems of an update, none when it can be applied
+func (node *ClusteredBigCache) validateUpdate(update ConfigUpdate) []error {
+	var errs []error
+	for _, field := range []struct {
+		name  string
+		value *int
+	}{
+		{"PingInterval", update.PingInterval},
+		{"PingTimeout", update.PingTimeout},
+		{"ReplicationFactor", update.ReplicationFactor},
+		{"ReplicationRetryQueue", update.ReplicationRetryQueue},
+	} {
+		if field.value != nil && *field.value < 1 {
+			errs = append(errs, fmt.Errorf("%s must be at least 1, got %d", field.name, *field.value))
+		}
+	}
+	if update.PingFailureThreshHold != nil && *update.PingFailureThreshHold < 1 {
+		errs = append(errs, fmt.Errorf("PingFailureThreshHold must be at least 1, got %d", *update.PingFailureThreshHold))
+	}
+	if update.PingInterval != nil && node.config.ReadDeadline > 0 && *update.PingInterval*1000 >= node.config.ReadDeadline {
+		errs = append(errs, fmt.Errorf("PingInterval %ds must be shorter than the ReadDeadline of %dms", *update.PingInterval,
+			node.config.ReadDeadline))
+	}
+	if update.ReplicationRetryQueue != nil && node.retryQueue == nil {
+		errs = append(errs, errors.New("ReplicationRetryQueue can only be changed when the node was started with it"))
+	}
+	return errs
+}
+
+//take the ping settings of the node on, resetting the ping timer to the new interval
+func (r *remoteNode) reconfigurePing(config *ClusteredBigCacheConfig) {
+	r.config.PingInterval = config.PingInterval
+	r.config.PingTimeout = config.PingTimeout
+	r.config.PingFailureThreshHold = config.PingFailureThreshHold
+	select {
+	case r.pingReset <- struct{}{}:
+	default: //a reset is already pending, it reads the new interval
+	}
+}
+
+//the remote node changed settings the whole cluster shares
+func (r *remoteNode) handleConfig(msg *message.NodeWireMessage) {
+	configMsg := message.ConfigMessage{}
+	configMsg.DeSerialize(msg)
+
+	if configMsg.ReplicationFactor > 0 && configMsg.ReplicationFactor != r.parentNode.config.ReplicationFactor {
+		r.parentNode.setReplicationFactor(configMsg.ReplicationFactor)
+		utils.Info(r.logger, fmt.Sprintf("replication factor set to %d by remote node '%s'",
+			configMsg.ReplicationFactor, r.config.Id))
+	}
+}
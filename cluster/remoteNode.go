@@ -1,16 +1,20 @@
 package cluster
 
 import (
+	"context"
 	"fmt"
+	"math/rand"
 	"net"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"encoding/binary"
+	"encoding/hex"
 	"errors"
 
+	"github.com/nggenius/ngbigcache/bigcache"
 	"github.com/nggenius/ngbigcache/comms"
 	"github.com/nggenius/ngbigcache/message"
 	"github.com/nggenius/ngbigcache/utils"
@@ -24,6 +28,9 @@ const (
 	nodeStateHandshake
 )
 
+//number of bytes of a corrupt message dumped to the log
+const corruptMsgDumpSize = 256
+
 type remoteNodeState uint8
 
 type nodeMetrics struct {
@@ -32,19 +39,40 @@ type nodeMetrics struct {
 	pongSent     uint64
 	pongRecieved uint64
 	dropedMsg    uint64
+	unsupported  uint64 //messages not sent because the remote node's protocol version does not know them
+	corruptMsg   uint64 //messages that could not be handled, e.g because they were malformed
+	rateLimited  uint64 //messages dropped for going over the inbound rate limits
+	refused      uint64 //writes the remote node refused to apply
+	roleDenied   uint64 //writes of the remote node refused because of its role
+	pingSentAt   int64  //unix nano time the last ping was sent
+	pingRTT      int64  //nanoseconds the last ping took to be answered
+	pingSkipped  uint64 //pings not sent as messages of the remote node proved it alive, when pinging adaptively
+	pingInterval int64  //nanoseconds between the pings sent now
+	traffic      trafficCounters
 }
 
 // remote node configuration
 type remoteNodeConfig struct {
-	Id                    string `json:"id"`
-	IpAddress             string `json:"ip_address"`
-	PingFailureThreshHold int32  `json:"ping_failure_thresh_hold"`
-	PingInterval          int    `json:"ping_interval"`
-	PingTimeout           int    `json:"ping_timeout"`
-	ConnectRetries        int    `json:"connect_retries"`
-	ServicePort           string `json:"service_port"`
-	Sync                  bool   `json:"sync"`
-	ReconnectOnDisconnect bool   `json:"reconnect_on_disconnect"`
+	Id                    string   `json:"id"`
+	IpAddress             string   `json:"ip_address"`
+	PingFailureThreshHold int32    `json:"ping_failure_thresh_hold"`
+	PingInterval          int      `json:"ping_interval"`
+	PingTimeout           int      `json:"ping_timeout"`
+	ConnectRetries        int      `json:"connect_retries"`
+	ServicePort           string   `json:"service_port"`
+	AdvertisedHost        string   `json:"advertised_host"`
+	Sync                  bool     `json:"sync"`
+	ReconnectOnDisconnect bool     `json:"reconnect_on_disconnect"`
+	Connections           int      `json:"connections"`
+	Seeds                 []string `json:"seeds"` //set when joining through seed addresses, they are tried in turn until one answers
+
+	ReadDeadline  time.Duration `json:"read_deadline"`  //longest wait for the next message from the remote node, 0 for no limit
+	WriteDeadline time.Duration `json:"write_deadline"` //longest a message may take to be written to the remote node, 0 for no limit
+
+	AdaptivePing    bool `json:"adaptive_ping"`     //space pings out while they are answered, skip them while messages come in
+	MaxPingInterval int  `json:"max_ping_interval"` //seconds adaptive pings are spaced out to at most
+
+	Retry retryPolicy `json:"-"` //how the attempts to connect are spaced out
 }
 
 // remote node definition
@@ -53,18 +81,41 @@ type remoteNode struct {
 	metrics          *nodeMetrics
 	connection       *comms.Connection
 	parentNode       *ClusteredBigCache
-	inboundMsgQueue  chan *message.NodeWireMessage
+	inboundMsgQueue  *inboundQueue
 	outboundMsgQueue chan message.NodeMessage
+	controlMsgQueue  chan message.NodeMessage //control messages, sent ahead of those of outboundMsgQueue
 	logger           utils.AppLogger
 	state            remoteNodeState
 	stateLock        sync.Mutex
-	done             chan struct{}
-	pingTimer        *time.Ticker //used to send ping message to remote
-	pingTimeout      *time.Timer  //used to monitor ping response
-	pingFailure      int32        //count the number of pings without response
+	ctx              context.Context    //done once the remote node is to stop, derived from that of the node
+	cancel           context.CancelFunc //stops the remote node, see the lifecycle of the goroutines in lifecycle.go
+	tornDown         sync.Once
+	pingTimer        bigcache.Ticker //used to send ping message to remote
+	pingTimeout      bigcache.Timer  //used to monitor ping response
+	pingReset        chan struct{}   //signalled when the ping interval was changed, to reset pingTimer
+	pingFailure      int32           //count the number of pings without response
 	pendingGet       *sync.Map
+	pendingLeave     *sync.Map
+	pending          *pendingRequests //requests waiting for an answer of the remote node, failed once it goes away
 	mode             byte
 	wg               *sync.WaitGroup
+	protocolVersion  uint32 //negotiated during verification, always use version() to read it
+	outbound         bool   //true when this node dialled the remote node
+	lanes            []*connLane
+	lanesLock        sync.RWMutex
+	lanesClosed      bool
+	laneToken        string //issued to the remote node in VerifyOK, the extra connections it opens must carry it
+	peerLaneToken    string //issued by the remote node in VerifyOK, carried by the extra connections opened to it
+	limiter          *inboundLimiter
+	role             byte   //role the remote node asked for during the handshake
+	lastActive       int64  //unix nano of the last message other than a ping or a pong, to close idle passive clients
+	closing          int32  //set once the connection is being closed for being idle
+	epoch            string //of the remote node, sent during the handshake
+	sentSeq          uint64 //sequence number in the changelog of the last change written to the connection
+	weight           int    //capacity weight of the remote node, sent during the handshake
+	writesSent       uint64 //writes sent to the remote node since it connected
+	writesHandled    uint64 //writes received from the remote node since it connected, applied or not
+	unsent           int64  //messages queued by sendMessage not written or dropped by networkSender yet
 }
 
 //check configurations for sensible defaults
@@ -85,23 +136,40 @@ func checkConfig(logger utils.AppLogger, config *remoteNodeConfig) {
 	if config.PingFailureThreshHold == 0 {
 		config.PingFailureThreshHold = 5
 	}
+
+	if config.Connections < 1 {
+		config.Connections = 1
+	}
+
+	if config.Retry.initial <= 0 {
+		config.Retry = newRetryPolicy(&ClusteredBigCacheConfig{})
+	}
 }
 
 //create a new remoteNode object
 func newRemoteNode(config *remoteNodeConfig, parent *ClusteredBigCache, logger utils.AppLogger) *remoteNode {
 	checkConfig(logger, config)
+	ctx, cancel := context.WithCancel(parent.ctx)
 	return &remoteNode{
 		config:           config,
-		inboundMsgQueue:  make(chan *message.NodeWireMessage, CHAN_SIZE),
+		inboundMsgQueue:  newInboundQueue(CHAN_SIZE),
 		outboundMsgQueue: make(chan message.NodeMessage, CHAN_SIZE),
-		done:             make(chan struct{}),
+		controlMsgQueue:  make(chan message.NodeMessage, controlQueueSize),
+		ctx:              ctx,
+		cancel:           cancel,
+		pingReset:        make(chan struct{}, 1),
 		state:            nodeStateDisconnected,
 		stateLock:        sync.Mutex{},
 		parentNode:       parent,
 		logger:           logger,
 		metrics:          &nodeMetrics{},
 		pendingGet:       &sync.Map{},
+		pendingLeave:     &sync.Map{},
+		pending:          newPendingRequests(),
 		wg:               &sync.WaitGroup{},
+		protocolVersion:  uint32(message.MinProtocolVersion),
+		limiter:          newInboundLimiter(parent.config),
+		laneToken:        newLaneToken(),
 	}
 }
 
@@ -113,53 +181,71 @@ func (r *remoteNode) setState(state remoteNodeState) {
 	r.state = state
 }
 
+//the protocol version negotiated with the remote node
+func (r *remoteNode) version() uint16 {
+	return uint16(atomic.LoadUint32(&r.protocolVersion))
+}
+
 //just set the connection for this remoteNode
 func (r *remoteNode) setConnection(conn *comms.Connection) {
+	conn.SetDeadlines(r.config.ReadDeadline, r.config.WriteDeadline)
 	r.connection = conn
 }
 
-//shut down this object
+//shut down this object. safe to call more than once and before the remote node started
 func (r *remoteNode) shutDown() {
-	defer func() { recover() }()
-
-	select {
-	case r.done <- struct{}{}:
-	default:
-	}
+	r.cancel()
 }
 
 //startup this remoteNode
 func (r *remoteNode) start() {
+	atomic.StoreInt64(&r.lastActive, r.parentNode.clock.Now().UnixNano())
 	r.wg.Add(1)     //temporary increment
 	var g run.Group //uses run.Group
 	{
-		g.Add(func() error { //this is to terminate this remoteNode from its parent
-			<-r.done
+		g.Add(r.actor("terminate", func() error { //this is to terminate this remoteNode from its parent
+			<-r.ctx.Done()
 			return errors.New("terminating")
-		}, func(err error) {
-			close(r.done)
+		}), func(err error) {
+			r.cancel()
 		})
 	}
 	{
-		done := make(chan struct{})
-		g.Add(func() error { //this is for the ping timer
-			r.pingTimer = time.NewTicker(time.Second * time.Duration(r.config.PingInterval))
-			r.sendMessage(&message.PingMessage{}) //send the first ping message
+		g.Add(r.actor("ping timer", func() error { //this is for the ping timer
+			r.pingTimer = r.parentNode.clock.NewTicker(time.Second * time.Duration(r.config.PingInterval))
+			atomic.StoreInt64(&r.metrics.pingInterval, int64(time.Second*time.Duration(r.config.PingInterval)))
+			adaptive := newAdaptivePing(r.config)
+			r.sendPing() //send the first ping message
 			exit := false
 			r.wg.Add(1)
 			for {
 				select {
-				case <-r.pingTimer.C:
+				case <-r.pingTimer.C():
+					if adaptive != nil {
+						ping, interval := adaptive.tick(atomic.LoadInt64(&r.lastActive), atomic.LoadInt32(&r.pingFailure))
+						r.pingTimer.Reset(interval)
+						atomic.StoreInt64(&r.metrics.pingInterval, int64(interval))
+						if !ping {
+							atomic.AddUint64(&r.metrics.pingSkipped, 1)
+							continue
+						}
+					}
 					atomic.AddUint64(&r.metrics.pingSent, 1)
 					if !r.pingTimeout.Stop() {
 						select {
-						case <-r.pingTimeout.C:
+						case <-r.pingTimeout.C():
 						default:
 						}
 					}
 					r.pingTimeout.Reset(time.Second * time.Duration(r.config.PingTimeout))
-					r.sendMessage(&message.PingMessage{})
-				case <-done: //we have this so that the goroutine would not linger after this node disconnects because
+					r.sendPing()
+				case <-r.pingReset:
+					r.pingTimer.Reset(time.Second * time.Duration(r.config.PingInterval))
+					atomic.StoreInt64(&r.metrics.pingInterval, int64(time.Second*time.Duration(r.config.PingInterval)))
+					if adaptive != nil {
+						adaptive.reset(r.config)
+					}
+				case <-r.ctx.Done(): //we have this so that the goroutine would not linger after this node disconnects because
 					exit = true //of the blocking channel in the above case statement
 					break
 				}
@@ -171,20 +257,19 @@ func (r *remoteNode) start() {
 			utils.Info(r.logger, fmt.Sprintf("shutting down ping timer goroutine for '%s'", r.config.Id))
 			r.wg.Done()
 			return errors.New("terminating timer goroutine")
-		}, func(err error) {
-			close(done)
+		}), func(err error) {
+			r.cancel()
 		})
 	}
 	{
-		done := make(chan struct{})
-		g.Add(func() error { //this is for the ping response (pong) timer
-			r.pingTimeout = time.NewTimer(time.Second * time.Duration(r.config.PingTimeout))
+		g.Add(r.actor("ping timeout", func() error { //this is for the ping response (pong) timer
+			r.pingTimeout = r.parentNode.clock.NewTimer(time.Second * time.Duration(r.config.PingTimeout))
 			fault := false
 			exit := false
 			r.wg.Add(1)
 			for {
 				select {
-				case <-r.pingTimeout.C:
+				case <-r.pingTimeout.C():
 					if r.state != nodeStateHandshake {
 						utils.Warn(r.logger,
 							fmt.Sprintf("no ping response within configured time frame from remote node '%s'", r.config.Id))
@@ -197,7 +282,7 @@ func (r *remoteNode) start() {
 						fault = true
 						break
 					}
-				case <-done: //we have this so that the goroutine would not linger after this node disconnects because
+				case <-r.ctx.Done(): //we have this so that the goroutine would not linger after this node disconnects because
 					exit = true //of the blocking channel in the above case statement
 					break
 				}
@@ -214,57 +299,63 @@ func (r *remoteNode) start() {
 			utils.Info(r.logger, fmt.Sprintf("shutting down ping timeout goroutine for '%s'", r.config.Id))
 			r.wg.Done()
 			return errors.New("terminating timeout goroutine")
-		}, func(err error) {
-			close(done)
+		}), func(err error) {
+			r.cancel()
 		})
 	}
 	{
-		g.Add(func() error { //this is for the network consumer. ie reading data off the network
+		g.Add(r.actor("network consumer", func() error { //this is for the network consumer. ie reading data off the network
 			r.wg.Add(1)
 			r.networkConsumer()
 			r.wg.Done()
 			return errors.New("terminating networkConsumer")
-		}, func(err error) {
+		}), func(err error) {
 			r.setState(nodeStateDisconnected)
 		})
 	}
 	{
-		g.Add(func() error { //this is for handle and dispatching messages
+		g.Add(r.actor("message handler", func() error { //this is for handle and dispatching messages
 			r.wg.Add(1)
 			r.handleMessage()
 			r.wg.Done()
 			return errors.New("terminating handleMessage")
-		}, func(err error) {
-			close(r.inboundMsgQueue)
+		}), func(err error) {
+			r.inboundMsgQueue.close()
 			r.setState(nodeStateDisconnected)
 		})
 	}
 	{
-		g.Add(func() error { //this is for sending messages on the network
+		g.Add(r.actor("network sender", func() error { //this is for sending messages on the network
 			r.wg.Add(1)
 			r.networkSender()
 			r.wg.Done()
 			return errors.New("terminating networkSender")
-		}, func(err error) {
+		}), func(err error) {
 			close(r.outboundMsgQueue)
 			r.setState(nodeStateDisconnected)
 		})
 	}
 	{
-		g.Add(func() error { //this is for gracefully bringing down this remoteNode
+		g.Add(r.actor("tear down", func() error { //this is for gracefully bringing down this remoteNode
 			r.wg.Wait()
 			r.tearDown()
 			return errors.New("terminating tearDown")
-		}, func(err error) {
+		}), func(err error) {
 			r.connection.Close()
 			r.setState(nodeStateDisconnected)
 			r.wg.Done() //decrement the temporary increment
 		})
 	}
 
-	go func() {
+	started := r.parentNode.routines.spawn(func() {
 		utils.Warn(r.logger, fmt.Sprintf("remoteNode '%s' shutting down, caused by: %q", r.config.Id, g.Run()))
-	}()
+	})
+	if !started { //the node is shutting down
+		r.cancel()
+		r.connection.Close()
+		r.setState(nodeStateDisconnected)
+		return
+	}
 	r.sendVerify()
 }
 
@@ -272,16 +363,20 @@ func (r *remoteNode) start() {
 func (r *remoteNode) join() {
 	utils.Info(r.logger, "joining remote node via "+r.config.IpAddress)
 
-	go func() { //goroutine will try to connect to the cluster until it succeeds or max tries reached
+	r.parentNode.routines.spawn(func() { //goroutine will try to connect to the cluster until it succeeds or max tries reached
 		r.setState(nodeStateConnecting)
 		var err error
-		tries := 0
+		tries, retry := 0, 0
 		for {
-			if err = r.connect(); err == nil {
+			if err = r.connectToSeeds(); err == nil {
 				break
 			}
 			utils.Error(r.logger, err.Error())
-			time.Sleep(time.Second * 3)
+			if !r.parentNode.sleep(r.ctx, r.config.Retry.interval(retry)) { //the node is shutting down
+				r.setState(nodeStateDisconnected)
+				return
+			}
+			retry++
 			if r.config.ConnectRetries > 0 {
 				tries++
 				if tries >= r.config.ConnectRetries {
@@ -293,99 +388,205 @@ func (r *remoteNode) join() {
 		}
 		utils.Info(r.logger, "connected to node via "+r.config.IpAddress)
 		r.start()
-	}()
+	})
+}
+
+//connect to the remote node, or to the first seed that answers when joining through seeds.
+//seeds are shuffled so that joining nodes spread across them
+func (r *remoteNode) connectToSeeds() error {
+	if len(r.config.Seeds) == 0 {
+		return r.connect()
+	}
+
+	seeds := make([]string, len(r.config.Seeds))
+	copy(seeds, r.config.Seeds)
+	rand.Shuffle(len(seeds), func(i, j int) { seeds[i], seeds[j] = seeds[j], seeds[i] })
+
+	var err error
+	for _, seed := range seeds {
+		r.config.IpAddress = seed
+		if err = r.connect(); err == nil {
+			return nil
+		}
+		utils.Warn(r.logger, fmt.Sprintf("unable to join through seed '%s' [%s]", seed, err))
+	}
+	return err
 }
 
 //handles to low level connection to remote node
 func (r *remoteNode) connect() error {
 	var err error
 	utils.Info(r.logger, "connecting to "+r.config.IpAddress)
-	r.connection, err = comms.NewConnection(r.config.IpAddress, time.Second*5)
+	r.connection, err = r.parentNode.connect(r.config.IpAddress)
 	if err != nil {
 		return err
 	}
+	r.connection.SetDeadlines(r.config.ReadDeadline, r.config.WriteDeadline)
 
+	r.outbound = true
 	r.setState(nodeStateHandshake)
 
 	return nil
 }
 
 //this is a goroutine dedicated in reading data from the network
-//it does this by reading a 6bytes header which is
-//	byte 1 - 4 == length of data
-//	byte 5 & 6 == message code
-//	the rest of the data based on length is the message body
 func (r *remoteNode) networkConsumer() {
 
 	for (r.state == nodeStateConnected) || (r.state == nodeStateHandshake) {
 
-		header, err := r.connection.ReadData(6, 0) //read 6 byte header
+		msg, err := readFrame(r.connection, 0, r.parentNode.maxFrameSize())
 		if nil != err {
 			utils.Critical(r.logger, fmt.Sprintf("remote node '%s' has disconnected", r.config.Id))
 			break
 		}
+		r.queueInboundMessage(msg) //queue message to be processed
+	}
+	utils.Info(r.logger, fmt.Sprintf("network consumer loop terminated... %s", r.config.Id))
 
-		dataLength := binary.LittleEndian.Uint32(header) - 2 //subtracted 2 becos of message code
-		msgCode := binary.LittleEndian.Uint16(header[4:])
-		var data []byte
-		if dataLength > 0 {
-			data, err = r.connection.ReadData(uint(dataLength), 0)
-			if nil != err {
-				utils.Critical(r.logger, fmt.Sprintf("remote node '%s' has disconnected", r.config.Id))
-				break
-			}
+}
+
+// read a single message off a connection
+// it does this by reading a 6bytes header which is
+//
+//	byte 1 - 4 == length of data
+//	byte 5 & 6 == message code
+//	the rest of the data based on length is the message body
+//
+// frames longer than maxSize are refused
+func readFrame(conn *comms.Connection, timeout time.Duration, maxSize uint32) (*message.NodeWireMessage, error) {
+	var header []byte
+	var err error
+	if timeout == 0 { //a read with a timeout goes through ReadData, which sets the deadline for it
+		buf := getBuffer()
+		defer putBuffer(buf)
+		header = append(*buf, make([]byte, message.FrameHeaderSize)...)
+		err = conn.ReadInto(header)
+	} else {
+		header, err = conn.ReadData(message.FrameHeaderSize, timeout) //read 6 byte header
+	}
+	if nil != err {
+		return nil, err
+	}
+
+	msgCode, dataLength, err := message.ParseFrameHeaderMax(header, maxSize) //the length is checked before anything is allocated for it
+	if nil != err {
+		return nil, err
+	}
+	var data []byte
+	if dataLength > 0 {
+		data, err = conn.ReadData(uint(dataLength), timeout)
+		if nil != err {
+			return nil, err
 		}
-		r.queueInboundMessage(&message.NodeWireMessage{Code: msgCode, Data: data}) //queue message to be processed
 	}
-	utils.Info(r.logger, fmt.Sprintf("network consumer loop terminated... %s", r.config.Id))
 
+	return &message.NodeWireMessage{Code: msgCode, Data: data}, nil
+}
+
+//build a message for the network using the following protocol
+// bytes 1 - 4 == total length of the data (including the 2 byte message code)
+// bytes 5 & 6 == message code
+// bytes 7 upwards == message content
+func buildFrame(msg *message.NodeWireMessage) []byte {
+	return appendFrame(make([]byte, 0, 6+len(msg.Data)), msg)
 }
 
 //just queue the message in the outbound channel
 func (r *remoteNode) sendMessage(msg message.NodeMessage) {
-	defer func() { recover() }()
+	defer func() {
+		if e := recover(); e != nil { //the outbound queue was closed on shut down
+			atomic.AddInt64(&r.unsent, -1)
+			r.messageDropped("send", fmt.Sprintf("%T", msg), e)
+		}
+	}()
 
 	if r.state == nodeStateDisconnected {
+		r.messageDropped("send", fmt.Sprintf("%T", msg), "disconnected")
 		return
 	}
 
+	queue := r.outboundMsgQueue
+	if isControlMessage(msg) {
+		queue = r.controlMsgQueue
+	}
+	atomic.AddInt64(&r.unsent, 1)
 	select {
-	case <-r.done:
-	case r.outboundMsgQueue <- msg:
+	case <-r.ctx.Done():
+		atomic.AddInt64(&r.unsent, -1)
+	case queue <- msg:
 	}
 }
 
-//this sends a message on the network
-//it builds the message using the following protocol
-// bytes 1 & 2 == total length of the data (including the 2 byte message code)
-// bytes 3 & 4 == message code
-// bytes 5 upwards == message content
+//this sends a message on the network, striping keyed messages across the connections to the remote node. control
+//messages are sent ahead of the data messages waiting
 func (r *remoteNode) networkSender() {
 
-	for m := range r.outboundMsgQueue {
-		if r.state == nodeStateDisconnected {
-			continue
+	for {
+		m, ok := r.nextOutbound()
+		if !ok {
+			break
 		}
-		msg := m.Serialize()
-		data := make([]byte, 6+len(msg.Data))                        // 6 ==> 4bytes for length of message, 2bytes for message code
-		binary.LittleEndian.PutUint32(data, uint32(len(msg.Data)+2)) //the 2 is for the message code
-		binary.LittleEndian.PutUint16(data[4:], msg.Code)
-		copy(data[6:], msg.Data)
-		if err := r.connection.SendData(data); err != nil {
-			utils.Critical(r.logger, fmt.Sprintf("unexpected error while sending %s data [%s]", message.MsgCodeToString(msg.Code), err))
+		ok = r.write(m)
+		atomic.AddInt64(&r.unsent, -1)
+		if !ok {
 			break
 		}
 	}
 	utils.Info(r.logger, "terminated network sender for "+r.config.Id)
 }
 
-//bring down the remote node. should not be called from outside networkConsumer()
+//write one message taken off the outbound queue, false means the network sender has to stop
+func (r *remoteNode) write(m message.NodeMessage) bool {
+	if r.state == nodeStateDisconnected {
+		return true
+	}
+	m, seq := unsequence(m)
+	msg := m.Serialize()
+	releaseMessage(m)
+	if message.MsgMinVersion(msg.Code) > r.version() { //the remote node would not understand this message
+		atomic.AddUint64(&r.metrics.unsupported, 1)
+		r.messageDropped("send", message.MsgCodeToString(msg.Code), "not supported by the remote node")
+		return true
+	}
+	if isWrite(msg.Code) {
+		atomic.AddUint64(&r.writesSent, 1)
+	}
+	data := getBuffer()
+	*data = appendFrame(*data, msg)
+	r.parentNode.bandwidth.sentFrame(msg.Code, len(*data))
+	r.metrics.traffic.sent(msg.Code, len(*data))
+	if lane := r.pickLane(m); lane != nil {
+		lane.send(data)
+	} else {
+		err := r.connection.SendData(*data)
+		putBuffer(data)
+		if err != nil {
+			utils.Critical(r.logger, fmt.Sprintf("unexpected error while sending %s data [%s]", message.MsgCodeToString(msg.Code), err))
+			return false
+		}
+	}
+	if seq > 0 {
+		atomic.StoreUint64(&r.sentSeq, seq)
+	}
+	return true
+}
+
+//bring down the remote node, once however many times it is called. it is called by the run.Group of the
+//remote node once every other actor returned, and by ShutDown
 func (r *remoteNode) tearDown() {
+	r.tornDown.Do(r.bringDown)
+}
+
+func (r *remoteNode) bringDown() {
 
+	r.cancel()
 	r.parentNode.eventRemoteNodeDisconneced(r)
-	jq := r.parentNode.joinQueue
-	if r.config.ReconnectOnDisconnect {
-		jq <- &message.ProposedPeer{Id: r.config.Id, IpAddress: r.config.IpAddress}
+	if r.config.ReconnectOnDisconnect && r.parentNode.ctx.Err() == nil {
+		if len(r.config.Seeds) > 0 && !r.parentNode.hasActivePeers() { //cut off from the cluster so fail over to whichever seed is up
+			r.parentNode.routines.spawn(func() { r.parentNode.joinCluster() })
+		} else {
+			r.parentNode.routines.spawn(func() { r.parentNode.redial(r.config.Id, r.config.IpAddress, r.config.Retry.interval(0)) })
+		}
 	}
 
 	if r.pingTimeout != nil {
@@ -394,8 +595,12 @@ func (r *remoteNode) tearDown() {
 	if r.pingTimer != nil {
 		r.pingTimer.Stop()
 	}
+	r.closeLanes()
 
+	r.pending.fail()
+	r.parentNode.changelog.disconnected(r)
 	r.pendingGet = nil
+	r.pendingLeave = nil
 	utils.Info(r.logger, fmt.Sprintf("remote node '%s' completely shutdown", r.config.Id))
 }
 
@@ -403,52 +608,50 @@ func (r *remoteNode) tearDown() {
 func (r *remoteNode) queueInboundMessage(msg *message.NodeWireMessage) {
 	defer func() { recover() }()
 
+	r.metrics.traffic.received(msg)
+
 	if r.state == nodeStateHandshake { //when in the handshake state only accept MsgVERIFY and MsgVERIFYOK messages
 		code := msg.Code
 		if (code != message.MsgVERIFY) && (code != message.MsgVERIFYOK) {
 			r.metrics.dropedMsg++
+			r.messageDropped("receive", message.MsgCodeToString(code), "handshake not done")
 			return
 		}
 	}
 
-	if r.state != nodeStateDisconnected {
-		select {
-		case <-r.done:
-		case r.inboundMsgQueue <- msg:
+	if !r.admitInbound(msg) { //dropped before it takes room in the queue
+		if isWrite(msg.Code) {
+			atomic.AddUint64(&r.writesHandled, 1)
 		}
+		return
+	}
+
+	if r.state != nodeStateDisconnected && !r.inboundMsgQueue.put(r.ctx, msg) && r.ctx.Err() == nil {
+		r.inboundDropped(msg)
 	}
 }
 
-//message handler
+//count and report a message dropped from the inbound queue for want of room
+func (r *remoteNode) inboundDropped(msg *message.NodeWireMessage) {
+	atomic.AddUint64(&r.metrics.dropedMsg, 1)
+	r.messageDropped("receive", message.MsgCodeToString(msg.Code), "inbound queue full")
+}
+
+//message handler. the messages received before the remote node disconnected are still handled, the inbound
+//queue is closed once it did so this returns after the last of them
 func (r *remoteNode) handleMessage() {
 
-	for msg := range r.inboundMsgQueue {
-		if r.state == nodeStateDisconnected {
-			continue
+	for {
+		msg, ok := r.inboundMsgQueue.next(r.inboundDropped)
+		if !ok {
+			break
 		}
-		switch msg.Code {
-		case message.MsgVERIFY:
-			if !r.handleVerify(msg) {
-				return
-			}
-		case message.MsgVERIFYOK:
-			r.handleVerifyOK()
-		case message.MsgPING:
-			r.handlePing()
-		case message.MsgPONG:
-			r.handlePong()
-		case message.MsgSyncRsp:
-			r.handleSyncResponse(msg)
-		case message.MsgSyncReq:
-			r.handleSyncRequest(msg)
-		case message.MsgGETReq:
-			r.handleGetRequest(msg)
-		case message.MsgGETRsp:
-			r.handleGetResponse(msg)
-		case message.MsgPUT:
-			r.handlePut(msg)
-		case message.MsgDEL:
-			r.handleDelete(msg)
+		ok = r.handleInbound(msg)
+		if isWrite(msg.Code) { //counted once applied, so a cluster snapshot can wait for the writes sent before it
+			atomic.AddUint64(&r.writesHandled, 1)
+		}
+		if !ok {
+			return
 		}
 	}
 
@@ -456,10 +659,167 @@ func (r *remoteNode) handleMessage() {
 
 }
 
+//handle one message taken off the inbound queue, false means the message handler has to stop
+func (r *remoteNode) handleInbound(msg *message.NodeWireMessage) bool {
+	if message.MsgMinVersion(msg.Code) > r.version() { //not part of the negotiated protocol
+		r.metrics.dropedMsg++
+		r.messageDropped("receive", message.MsgCodeToString(msg.Code), "not part of the negotiated protocol")
+		return true
+	}
+	if !r.permitted(msg) {
+		return true
+	}
+	if r.mode == clusterModePASSIVE && isWrite(msg.Code) { //held like the writes made on this node during a snapshot
+		r.parentNode.fence.wait()
+	}
+	return r.dispatchMessage(msg)
+}
+
+//handle one message, false means the message handler has to stop. malformed messages are not handled and
+//a panic while handling a message is recovered from, so neither takes the connection down with it
+func (r *remoteNode) dispatchMessage(msg *message.NodeWireMessage) (ok bool) {
+	defer func() {
+		if e := recover(); e != nil {
+			r.handleCorruptMessage(msg, e)
+			ok = true
+		}
+	}()
+
+	if err := message.Validate(msg); err != nil {
+		r.handleCorruptMessage(msg, err)
+		return true
+	}
+
+	if msg.Code != message.MsgPING && msg.Code != message.MsgPONG {
+		atomic.StoreInt64(&r.lastActive, r.parentNode.clock.Now().UnixNano())
+	}
+	r.parentNode.bandwidth.receivedMessage(msg)
+
+	switch msg.Code {
+	case message.MsgVERIFY:
+		return r.handleVerify(msg)
+	case message.MsgVERIFYOK:
+		r.handleVerifyOK(msg)
+	case message.MsgPING:
+		r.handlePing()
+	case message.MsgPONG:
+		r.handlePong()
+	case message.MsgSyncRsp:
+		r.handleSyncResponse(msg)
+	case message.MsgSyncReq:
+		r.handleSyncRequest(msg)
+	case message.MsgGETReq:
+		r.handleGetRequest(msg)
+	case message.MsgGETRsp, message.MsgGETRspEx:
+		r.handleGetResponse(msg)
+	case message.MsgPUT, message.MsgPUTEx, message.MsgPUTStamped:
+		r.handlePut(msg)
+	case message.MsgPUTAckReq:
+		r.handlePutAckRequest(msg)
+	case message.MsgPUTAck:
+		r.handlePutAck(msg)
+	case message.MsgDEL:
+		r.handleDelete(msg)
+	case message.MsgAPPEND:
+		r.handleAppend(msg)
+	case message.MsgAPPENDBytes:
+		r.handleAppendBytes(msg)
+	case message.MsgSADD:
+		r.handleSAdd(msg)
+	case message.MsgHSET:
+		r.handleHSet(msg)
+	case message.MsgHDEL:
+		r.handleHDel(msg)
+	case message.MsgCOUNTER:
+		r.handleCounter(msg)
+	case message.MsgLIST:
+		r.handleList(msg)
+	case message.MsgLISTReq:
+		r.handleListRequest(msg)
+	case message.MsgLISTRsp:
+		r.handleListResponse(msg)
+	case message.MsgPrimeReq:
+		r.handlePrimeRequest(msg)
+	case message.MsgAuditReq:
+		r.handleAuditRequest(msg)
+	case message.MsgAuditRsp:
+		r.handleAuditResponse(msg)
+	case message.MsgGossip:
+		r.handleGossip(msg)
+	case message.MsgLEAVE:
+		r.handleLeave(msg)
+	case message.MsgLEAVEAck:
+		r.handleLeaveAck(msg)
+	case message.MsgWATCH:
+		r.handleWatch(msg)
+	case message.MsgINVALIDATE:
+		r.handleInvalidate(msg)
+	case message.MsgREFUSED:
+		r.handleRefused(msg)
+	case message.MsgTTLReq:
+		r.handleTTLRequest(msg)
+	case message.MsgTTLRsp:
+		r.handleTTLResponse(msg)
+	case message.MsgKEYSReq:
+		r.handleKeysRequest(msg)
+	case message.MsgKEYSRsp:
+		r.handleKeysResponse(msg)
+	case message.MsgSTATSReq:
+		r.handleStatsRequest(msg)
+	case message.MsgSTATSRsp:
+		r.handleStatsResponse(msg)
+	case message.MsgSETNXReq:
+		r.handleSetIfAbsentRequest(msg)
+	case message.MsgSETNXRsp:
+		r.handleSetIfAbsentResponse(msg)
+	case message.MsgSTATSSubscribe:
+		r.handleStatsSubscribe(msg)
+	case message.MsgSTATSPush:
+		r.handleStatsPush(msg)
+	case message.MsgCLOSE:
+		r.handleClose(msg)
+	case message.MsgSNAPSHOTReq:
+		r.handleSnapshotRequest(msg)
+	case message.MsgSNAPSHOTRsp:
+		r.handleSnapshotResponse(msg)
+	case message.MsgCONFIG:
+		r.handleConfig(msg)
+	case message.MsgEVICT:
+		r.handleEvict(msg)
+	case message.MsgNOTFOUND:
+		r.handleNotFound(msg)
+	}
+
+	return true
+}
+
+//log the message that could not be handled and close the connection if the configuration asks for it
+func (r *remoteNode) handleCorruptMessage(msg *message.NodeWireMessage, cause interface{}) {
+	atomic.AddUint64(&r.metrics.corruptMsg, 1)
+
+	data := msg.Data
+	if len(data) > corruptMsgDumpSize {
+		data = data[:corruptMsgDumpSize]
+	}
+	utils.Error(r.logger, fmt.Sprintf("unable to handle %s message (code %d, %d bytes) from remote node '%s' [%v]\n%s",
+		message.MsgCodeToString(msg.Code), msg.Code, len(msg.Data), r.config.Id, cause, hex.Dump(data)))
+
+	if r.parentNode.config.CloseOnCorruptMessage {
+		utils.Warn(r.logger, fmt.Sprintf("shutting down connection to remote node '%s' due to a corrupt message", r.config.Id))
+		r.shutDown()
+	}
+}
+
 //send a verify message. this is always the first message to be sent once a connection is established.
 func (r *remoteNode) sendVerify() {
+	servicePort := r.parentNode.config.LocalPort
+	if r.parentNode.config.AdvertisedPort > 0 {
+		servicePort = r.parentNode.config.AdvertisedPort
+	}
 	verifyMsgRsp := message.VerifyMessage{Id: r.parentNode.config.Id,
-		ServicePort: strconv.Itoa(r.parentNode.config.LocalPort), Mode: r.parentNode.mode}
+		ServicePort: strconv.Itoa(servicePort), Mode: r.parentNode.mode,
+		ProtocolVersion: message.ProtocolVersion, AdvertisedHost: r.parentNode.config.AdvertisedHost,
+		Role: r.parentNode.config.Role, Epoch: r.parentNode.epoch, Weight: r.parentNode.config.Weight}
 	r.sendMessage(&verifyMsgRsp)
 }
 
@@ -471,7 +831,27 @@ func (r *remoteNode) handleVerify(msg *message.NodeWireMessage) bool {
 
 	r.config.Id = verifyMsgRsp.Id
 	r.config.ServicePort = verifyMsgRsp.ServicePort
+	r.config.AdvertisedHost = verifyMsgRsp.AdvertisedHost
 	r.mode = verifyMsgRsp.Mode
+	r.role = verifyMsgRsp.Role
+	r.epoch = verifyMsgRsp.Epoch
+	r.weight = verifyMsgRsp.Weight
+
+	version := message.NegotiateVersion(message.ProtocolVersion, verifyMsgRsp.ProtocolVersion)
+	if version < message.MinProtocolVersion {
+		utils.Warn(r.logger, fmt.Sprintf("remote node '%s' speaks protocol version %d but at least %d is required, shuting down the connection",
+			verifyMsgRsp.Id, version, message.MinProtocolVersion))
+		return false
+	}
+	atomic.StoreUint32(&r.protocolVersion, uint32(version))
+
+	if int(r.role) >= len(peerRoleNames) {
+		utils.Warn(r.logger, fmt.Sprintf("remote node '%s' asked for unknown role %d, shuting down the connection", verifyMsgRsp.Id, r.role))
+		return false
+	}
+	if r.role != PEER_ROLE_READ_WRITE {
+		utils.Info(r.logger, fmt.Sprintf("remote node '%s' is %s", verifyMsgRsp.Id, peerRoleName(r.role)))
+	}
 
 	//check if connecting node and this node are both in passive mode
 	if verifyMsgRsp.Mode == clusterModePASSIVE {
@@ -491,17 +871,23 @@ func (r *remoteNode) handleVerify(msg *message.NodeWireMessage) bool {
 	}
 
 	r.setState(nodeStateConnected)
-	r.sendMessage(&message.VerifyOKMessage{}) //must reply back with a verify OK message if all goes well
+	r.sendMessage(&message.VerifyOKMessage{LaneToken: r.laneToken}) //must reply back with a verify OK message if all goes well
 
 	return true
 }
 
 //handles verify OK from a remote node. this allows this system to sync with remote node
-func (r *remoteNode) handleVerifyOK() {
-	go func() {
+func (r *remoteNode) handleVerifyOK(msg *message.NodeWireMessage) {
+	verifyOKMsg := message.VerifyOKMessage{}
+	verifyOKMsg.DeSerialize(msg)
+	r.peerLaneToken = verifyOKMsg.LaneToken
+
+	r.parentNode.routines.spawn(func() {
 		count := 0
 		for r.state == nodeStateHandshake {
-			time.Sleep(time.Second * 1)
+			if !r.parentNode.sleep(r.ctx, time.Second*1) { //the remote node went away meanwhile
+				return
+			}
 			count++
 			if count >= 5 {
 				utils.Warn(r.logger, fmt.Sprintf("node '%s' state refused to change out of handshake", r.config.Id))
@@ -511,9 +897,24 @@ func (r *remoteNode) handleVerifyOK() {
 		if count < 5 {
 			if r.config.Sync { //only sync if you are joining the cluster
 				r.sendMessage(&message.SyncReqMessage{Mode: r.parentNode.mode})
+				if r.parentNode.mode == clusterModeACTIVE && r.parentNode.config.PrimeBudget > 0 {
+					r.sendMessage(&message.PrimeReqMessage{ByteBudget: r.parentNode.config.PrimeBudget})
+				}
+			}
+			if r.outbound { //only the dialling side opens the extra connections
+				r.openLanes()
+			}
+			if r.parentNode.mode == clusterModePASSIVE { //an active node to hold the subscription, if it has none
+				r.parentNode.subscribeWatch(false)
+			}
+			r.parentNode.subscribeStats(r)
+			r.parentNode.changelog.resume(r)
+			if r.mode == clusterModeACTIVE { //the remote node has verified this node so it answers reads now
+				r.parentNode.idlePeers.Delete(r.config.Id)
+				r.parentNode.eventPeerReady()
 			}
 		}
-	}()
+	})
 }
 
 //handles ping message from a remote node
@@ -523,12 +924,23 @@ func (r *remoteNode) handlePing() {
 	atomic.AddUint64(&r.metrics.pongSent, 1)
 }
 
+//send a ping, timed to its pong
+func (r *remoteNode) sendPing() {
+	atomic.StoreInt64(&r.metrics.pingSentAt, r.parentNode.clock.Now().UnixNano())
+	r.sendMessage(&message.PingMessage{})
+}
+
 //handle a pong message from the remote node, reset flags
 func (r *remoteNode) handlePong() {
 	atomic.AddUint64(&r.metrics.pongRecieved, 1)
+	if sent := atomic.LoadInt64(&r.metrics.pingSentAt); sent > 0 {
+		rtt := r.parentNode.clock.Now().UnixNano() - sent
+		atomic.StoreInt64(&r.metrics.pingRTT, rtt)
+		r.parentNode.slow.observePing(r.config.Id, time.Duration(rtt))
+	}
 	if !r.pingTimeout.Stop() { //stop the timer since we got a response
 		select {
-		case <-r.pingTimeout.C:
+		case <-r.pingTimeout.C():
 		default:
 		}
 	}
@@ -547,15 +959,51 @@ func (r *remoteNode) sendSyncResponse(msg *message.SyncReqMessage) {
 		if (n.mode == clusterModePASSIVE) && (msg.Mode == clusterModePASSIVE) {
 			continue
 		}
-		host, _, _ := net.SplitHostPort(n.config.IpAddress)
-		nodeList = append(nodeList, message.ProposedPeer{Id: n.config.Id, IpAddress: net.JoinHostPort(host, n.config.ServicePort)})
+		address, ok := n.proposedAddress()
+		if !ok {
+			continue
+		}
+		nodeList = append(nodeList, message.ProposedPeer{Id: n.config.Id, IpAddress: address})
 	}
 
-	if len(nodeList) > 0 {
-		r.sendMessage(&message.SyncRspMessage{List: nodeList, ReplicationFactor: r.parentNode.config.ReplicationFactor})
+	for _, page := range syncResponsePages(nodeList, r.parentNode.config.ReplicationFactor, message.MaxSyncPeers) {
+		r.sendMessage(page)
 	}
 }
 
+//split the peer list into sync responses of at most size peers each
+func syncResponsePages(nodeList []message.ProposedPeer, replicationFactor, size int) []*message.SyncRspMessage {
+	pages := (len(nodeList) + size - 1) / size
+	rsp := make([]*message.SyncRspMessage, 0, pages)
+	for x := 0; x < pages; x++ {
+		end := (x + 1) * size
+		if end > len(nodeList) {
+			end = len(nodeList)
+		}
+		rsp = append(rsp, &message.SyncRspMessage{List: nodeList[x*size : end], ReplicationFactor: replicationFactor,
+			Page: x + 1, Pages: pages})
+	}
+	return rsp
+}
+
+//the address other nodes can reach this remote node on, false if there is none
+func (r *remoteNode) proposedAddress() (string, bool) {
+	host := strings.TrimSuffix(strings.TrimPrefix(r.config.AdvertisedHost, "["), "]")
+	if "" == host {
+		if !comms.IsTCPEndpoint(r.config.IpAddress) { //unix socket and websocket peers can not be dialed by other nodes
+			return "", false
+		}
+		h, _, err := net.SplitHostPort(r.config.IpAddress)
+		if err != nil {
+			return "", false
+		}
+		host = h
+	}
+
+	//JoinHostPort puts IPv6 hosts in brackets
+	return net.JoinHostPort(host, r.config.ServicePort), true
+}
+
 //handles sync request by just sending a sync response
 func (r *remoteNode) handleSyncRequest(msg *message.NodeWireMessage) {
 	m := &message.SyncReqMessage{}
@@ -569,8 +1017,11 @@ func (r *remoteNode) handleSyncResponse(msg *message.NodeWireMessage) {
 	syncMsg.DeSerialize(msg)
 	r.parentNode.setReplicationFactor(syncMsg.ReplicationFactor)
 	length := len(syncMsg.List)
-	for x := 0; x < length; x++ {
-		r.parentNode.joinQueue <- &syncMsg.List[x]
+	for x := 0; x < length; x++ { //connect to the peers of this page without waiting for the rest
+		r.parentNode.proposePeer(&syncMsg.List[x])
+	}
+	if syncMsg.Page == syncMsg.Pages && syncMsg.Pages > 1 {
+		utils.Info(r.logger, fmt.Sprintf("received the last of %d pages of peers from '%s'", syncMsg.Pages, r.config.Id))
 	}
 }
 
@@ -580,14 +1031,27 @@ func (r *remoteNode) getData(reqData *getRequestData) {
 	}
 	randStr := reqData.randStr
 	r.pendingGet.Store(reqData.key+randStr, reqData)
-	r.sendMessage(&message.GetReqMessage{Key: reqData.key, PendingKey: reqData.key + randStr})
+	r.sendMessage(&message.GetReqMessage{Key: reqData.key, PendingKey: reqData.key + randStr, RequestId: reqData.requestId,
+		WithExpiry: reqData.expiry && r.version() >= message.MsgMinVersion(message.MsgGETRspEx)})
 }
 
 func (r *remoteNode) handleGetRequest(msg *message.NodeWireMessage) {
 	reqMsg := message.GetReqMessage{}
 	reqMsg.DeSerialize(msg)
-	data, _ := r.parentNode.cache.Get(reqMsg.Key)
-	r.sendMessage(&message.GetRspMessage{PendingKey: reqMsg.PendingKey, Data: data})
+	if reqMsg.WithExpiry {
+		data, expiry, _ := r.parentNode.cache.GetWithExpiry(reqMsg.Key)
+		logRequest(r.logger, reqMsg.RequestId, fmt.Sprintf("get '%s' asked by '%s', found %v", reqMsg.Key, r.config.Id, data != nil))
+		r.sendMessage(&message.GetRspMessage{PendingKey: reqMsg.PendingKey, Data: data, WithExpiry: true, Expiry: expiry})
+		return
+	}
+	value := getBuffer() //given back once the response is serialized
+	data, _, err := r.parentNode.cache.AppendValue(*value, reqMsg.Key)
+	*value = data
+	logRequest(r.logger, reqMsg.RequestId, fmt.Sprintf("get '%s' asked by '%s', found %v", reqMsg.Key, r.config.Id, err == nil))
+	if err != nil {
+		data = nil
+	}
+	r.sendMessage(&pooledGetRsp{GetRspMessage: message.GetRspMessage{PendingKey: reqMsg.PendingKey, Data: data}, value: value})
 }
 
 func (r *remoteNode) handleGetResponse(msg *message.NodeWireMessage) {
@@ -600,15 +1064,22 @@ func (r *remoteNode) handleGetResponse(msg *message.NodeWireMessage) {
 	}
 
 	r.pendingGet.Delete(rspMsg.PendingKey)
+	reqData := origReq.(*getRequestData)
+	if reqData.replies != nil { //buffered for every peer asked so this never blocks
+		select {
+		case reqData.replies <- &getReplyData{data: rspMsg.Data, peer: r.config.Id, expiry: rspMsg.Expiry}:
+		default:
+		}
+	}
+
 	if len(rspMsg.Data) < 1 {
 		return
 	}
 
 	//some other remote node might have sent the data so we do not want to block forever on the channel hence the select
-	reqData := origReq.(*getRequestData)
 	select {
 	case <-reqData.done:
-	case reqData.replyChan <- &getReplyData{data: rspMsg.Data}:
+	case reqData.replyChan <- &getReplyData{data: rspMsg.Data, peer: r.config.Id, withExpiry: rspMsg.WithExpiry, expiry: rspMsg.Expiry}:
 	default:
 	}
 }
@@ -617,17 +1088,88 @@ func (r *remoteNode) handlePut(msg *message.NodeWireMessage) {
 
 	putMsg := message.PutMessage{}
 	putMsg.DeSerialize(msg)
-	if putMsg.Expiry == 0 {
-		r.parentNode.cache.Set(putMsg.Key, putMsg.Data, 0)
-	} else {
-		t1 := time.Unix(int64(putMsg.Expiry), 0)
-		t2 := t1.Sub(time.Now())
-		r.parentNode.cache.Set(putMsg.Key, putMsg.Data, t2)
+	r.applyPut(msg.Code, &putMsg)
+}
+
+//apply a put replicated by the remote node, the error tells why it was not applied
+func (r *remoteNode) applyPut(code uint16, putMsg *message.PutMessage) error {
+	var refused error
+	if !r.admitReplicatedWrite(code, putMsg.Key) {
+		refused = ErrReadOnly
+	} else if !r.admitThrottledWrite(code, putMsg.Key) {
+		refused = errReplicaThrottled
+	}
+	if refused != nil {
+		logRequest(r.logger, putMsg.RequestId, fmt.Sprintf("put '%s' from '%s' not applied", putMsg.Key, r.config.Id))
+		return refused
+	}
+	if err := r.parentNode.checkValueSize(putMsg.Key, putMsg.Data); err != nil { //a node with a larger limit wrote it
+		logRequest(r.logger, putMsg.RequestId, fmt.Sprintf("put '%s' from '%s' not applied [%s]", putMsg.Key, r.config.Id, err.Error()))
+		r.replicaWriteFailed("replicate put", putMsg.Key, err)
+		r.sendMessage(&message.RefusedMessage{MsgCode: code, Key: putMsg.Key, Reason: "value too large"})
+		return err
 	}
+	expiry, live := r.parentNode.replicatedExpiry(putMsg.Expiry)
+	if !live {
+		logRequest(r.logger, putMsg.RequestId, fmt.Sprintf("put '%s' from '%s' expired before it arrived", putMsg.Key, r.config.Id))
+		return errReplicaExpired
+	}
+	putMsg.Expiry = expiry
+
+	unlock := r.parentNode.versions.lockKey(putMsg.Key)
+	if !r.parentNode.versions.admit(putMsg.Key, putMsg.Timestamp, r.config.Id, putMsg.Expiry) {
+		unlock()
+		logRequest(r.logger, putMsg.RequestId, fmt.Sprintf("put '%s' from '%s' is older than the local copy, discarded", putMsg.Key, r.config.Id))
+		return nil
+	}
+	remote := Entry{Data: putMsg.Data, Expiry: putMsg.Expiry}
+	resolved, conflict := r.parentNode.resolveConflict(putMsg.Key, remote)
+	if !conflict {
+		var err error
+		if putMsg.Expiry == bigcache.NO_EXPIRY {
+			_, err = r.parentNode.cache.Set(putMsg.Key, putMsg.Data, 0)
+		} else { //the expiry is an absolute time so keep it as is rather than recomputing a duration
+			_, err = r.parentNode.cache.SetUntil(putMsg.Key, putMsg.Data, time.Unix(int64(putMsg.Expiry), 0))
+		}
+		unlock()
+		if err != nil {
+			logRequest(r.logger, putMsg.RequestId, fmt.Sprintf("put '%s' from '%s' failed [%s]", putMsg.Key, r.config.Id, err.Error()))
+			r.replicaWriteFailed("replicate put", putMsg.Key, err)
+			return err
+		}
+		logRequest(r.logger, putMsg.RequestId, fmt.Sprintf("put '%s' from '%s' applied", putMsg.Key, r.config.Id))
+		r.parentNode.evictions.discard(putMsg.Key)
+		r.parentNode.watchers.notify(putMsg.Key, false)
+		return nil
+	}
+
+	_, err := r.parentNode.storeEntry(putMsg.Key, resolved)
+	var stamp uint64
+	if !resolved.equal(remote) { //newer than the remote copy, which it replaces everywhere
+		stamp = r.parentNode.versions.stamp(putMsg.Key, resolved.Expiry)
+	}
+	unlock()
+	r.replicaWriteFailed("replicate put", putMsg.Key, err)
+	logRequest(r.logger, putMsg.RequestId, fmt.Sprintf("put '%s' from '%s' conflicted with the local copy, resolved", putMsg.Key, r.config.Id))
+	if !resolved.equal(remote) { //the writer and the other nodes hold the remote copy, bring them in line
+		r.parentNode.replicatePut(putMsg.Key, resolved.Data, resolved.Expiry, stamp, putMsg.RequestId)
+	}
+	return err
 }
 
 func (r *remoteNode) handleDelete(msg *message.NodeWireMessage) {
 	delMsg := message.DeleteMessage{}
 	delMsg.DeSerialize(msg)
+	if !r.admitReplicatedWrite(msg.Code, delMsg.Key) {
+		return
+	}
+
+	unlock := r.parentNode.versions.lockKey(delMsg.Key)
+	if !r.parentNode.versions.admitDelete(delMsg.Key, delMsg.Timestamp, r.config.Id) {
+		unlock()
+		return
+	}
 	r.parentNode.cache.Delete(delMsg.Key)
+	unlock()
+	r.parentNode.watchers.notify(delMsg.Key, true)
 }
@@ -1,9 +1,16 @@
 package cluster
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
 	"testing"
 	"time"
 
+	"github.com/nggenius/ngbigcache/bigcache"
+	"github.com/nggenius/ngbigcache/message"
 	"github.com/nggenius/ngbigcache/utils"
 )
 
@@ -142,3 +149,328 @@ func TestPinging(t *testing.T) {
 	rn.shutDown()
 	node.ShutDown()
 }
+
+func TestProposedAddress(t *testing.T) {
+	cases := []struct {
+		config   remoteNodeConfig
+		expected string
+		ok       bool
+	}{
+		{remoteNodeConfig{IpAddress: "10.0.0.1:51234", ServicePort: "9911"}, "10.0.0.1:9911", true},
+		{remoteNodeConfig{IpAddress: "[fe80::1]:51234", ServicePort: "9911"}, "[fe80::1]:9911", true},
+		{remoteNodeConfig{IpAddress: "10.0.0.1:51234", ServicePort: "9911", AdvertisedHost: "::1"}, "[::1]:9911", true},
+		{remoteNodeConfig{IpAddress: "10.0.0.1:51234", ServicePort: "9911", AdvertisedHost: "[::1]"}, "[::1]:9911", true},
+		{remoteNodeConfig{IpAddress: "unix://@", ServicePort: "9911"}, "", false},
+		{remoteNodeConfig{IpAddress: "not an address", ServicePort: "9911"}, "", false},
+	}
+
+	for _, c := range cases {
+		config := c.config
+		rn := &remoteNode{config: &config}
+		address, ok := rn.proposedAddress()
+		if address != c.expected || ok != c.ok {
+			t.Errorf("expected %q (%v) for %+v, got %q (%v)", c.expected, c.ok, c.config, address, ok)
+		}
+	}
+}
+
+func TestCorruptMessage(t *testing.T) {
+	node := New(&ClusteredBigCacheConfig{LocalPort: 1079}, nil)
+	rn := newRemoteNode(&remoteNodeConfig{IpAddress: "localhost:1078"}, node, nil)
+
+	//too short to hold the expiry and key length of a put message
+	if !rn.dispatchMessage(&message.NodeWireMessage{Code: message.MsgPUT, Data: []byte{1, 2, 3}}) {
+		t.Error("message handler ought to keep going after a corrupt message")
+	}
+	if rn.metrics.corruptMsg != 1 {
+		t.Error("corrupt message ought to be counted")
+	}
+
+	closed := make(chan struct{})
+	go func() { //stands in for the goroutine that terminates a started remote node
+		<-rn.ctx.Done()
+		close(closed)
+	}()
+	time.Sleep(time.Millisecond * 50)
+
+	node.config.CloseOnCorruptMessage = true
+	rn.dispatchMessage(&message.NodeWireMessage{Code: message.MsgPUT, Data: []byte{1, 2, 3}})
+	select {
+	case <-closed:
+	case <-time.After(time.Millisecond * 200):
+		t.Error("connection ought to be closed after a corrupt message when asked to")
+	}
+}
+
+func TestSyncResponsePages(t *testing.T) {
+	peers := make([]message.ProposedPeer, 5)
+	for x := range peers {
+		peers[x] = message.ProposedPeer{Id: "node_" + strconv.Itoa(x)}
+	}
+
+	pages := syncResponsePages(peers, 2, 2)
+	if len(pages) != 3 {
+		t.Fatalf("5 peers ought to be sent over 3 pages, got %d", len(pages))
+	}
+	for x, page := range pages {
+		if page.Page != x+1 || page.Pages != 3 || page.ReplicationFactor != 2 {
+			t.Errorf("unexpected page %+v", page)
+		}
+	}
+	if len(pages[2].List) != 1 || pages[2].List[0].Id != "node_4" {
+		t.Error("last page ought to hold the remaining peer")
+	}
+
+	if len(syncResponsePages(nil, 1, 2)) != 0 {
+		t.Error("no sync response ought to be sent without peers")
+	}
+}
+
+func TestInboundRateLimit(t *testing.T) {
+	node := New(&ClusteredBigCacheConfig{LocalPort: 1005, InboundWriteRate: 10, FloodDisconnectThreshold: 5}, nil)
+	rn := newRemoteNode(&remoteNodeConfig{IpAddress: "localhost:1004"}, node, nil)
+
+	closed := make(chan struct{})
+	go func() { //stands in for the goroutine that terminates a started remote node
+		<-rn.ctx.Done()
+		close(closed)
+	}()
+	time.Sleep(time.Millisecond * 50)
+
+	put := (&message.PutMessage{Key: "key_1", Data: []byte("data_1")}).Serialize()
+	admitted := 0
+	for x := 0; x < 14; x++ {
+		if rn.admitInbound(put) {
+			admitted++
+		}
+	}
+	if admitted != 10 || rn.metrics.rateLimited != 4 {
+		t.Errorf("only a burst of 10 writes ought to be admitted, got %d", admitted)
+	}
+	if !rn.admitInbound(&message.NodeWireMessage{Code: message.MsgGETReq}) || !rn.admitInbound(&message.NodeWireMessage{Code: message.MsgPING}) {
+		t.Error("messages without a limit ought to be admitted")
+	}
+
+	rn.admitInbound(put)
+	select {
+	case <-closed:
+	case <-time.After(time.Millisecond * 200):
+		t.Error("connection ought to be closed when flooding")
+	}
+
+	time.Sleep(time.Millisecond * 200) //the bucket refills at the rate
+	if !rn.admitInbound(put) {
+		t.Error("writes ought to be admitted again once the bucket refilled")
+	}
+}
+
+func TestStrictMode(t *testing.T) {
+	var reported []*InternalError
+	node := New(&ClusteredBigCacheConfig{LocalPort: 1007, StrictMode: true,
+		OnInternalError: func(e *InternalError) { reported = append(reported, e) }}, nil)
+	rn := newRemoteNode(&remoteNodeConfig{Id: "remote_1", IpAddress: "localhost:1006"}, node, nil)
+
+	rn.sendMessage(&message.PingMessage{}) //not connected
+	rn.setState(nodeStateHandshake)
+	rn.queueInboundMessage((&message.PutMessage{Key: "key_1", Data: []byte("data_1")}).Serialize())
+
+	if len(reported) != 2 || node.InternalErrors() != 2 {
+		t.Fatalf("2 dropped messages ought to be reported, got %d", len(reported))
+	}
+	for _, e := range reported {
+		if e.Err != ErrMessageDropped || e.NodeId != "remote_1" {
+			t.Errorf("unexpected internal error %q", e.Error())
+		}
+	}
+
+	node.config.StrictMode = false
+	rn.queueInboundMessage((&message.PutMessage{Key: "key_1", Data: []byte("data_1")}).Serialize())
+	if len(reported) != 2 {
+		t.Error("nothing ought to be reported outside strict mode")
+	}
+}
+
+func TestPendingRequestsOnDisconnect(t *testing.T) {
+	node := New(&ClusteredBigCacheConfig{LocalPort: 1079}, nil)
+	rn := newRemoteNode(&remoteNodeConfig{IpAddress: "localhost:1078"}, node, nil)
+
+	if err := rn.askTTL("key", "key_1", make(chan ttlReply, 1)); !errors.Is(err, ErrNodeDisconnected) {
+		t.Errorf("asking a disconnected remote node ought to fail with ErrNodeDisconnected, got %v", err)
+	}
+	if err := rn.askSetIfAbsent("key", nil, 0, "key_1", make(chan setIfAbsentReply, 1)); !errors.Is(err, ErrNodeDisconnected) {
+		t.Errorf("asking a disconnected remote node ought to fail with ErrNodeDisconnected, got %v", err)
+	}
+
+	//the requests are only queued to be sent since the remote node never connected
+	rn.state = nodeStateConnected
+	ttlReplies := make(chan ttlReply, 1)
+	setNXReplies := make(chan setIfAbsentReply, 1)
+	keysReplies := make(chan keysReply, 1)
+	statsReplies := make(chan statsReply, 1)
+	auditReplies := make(chan KeyCopy, 1)
+	acks := make(chan putAck, 1)
+	if err := rn.askTTL("key", "key_2", ttlReplies); err != nil {
+		t.Fatal(err)
+	}
+	if err := rn.askSetIfAbsent("key", nil, 0, "key_2", setNXReplies); err != nil {
+		t.Fatal(err)
+	}
+	if err := rn.askKeys(message.KeysReqMessage{}, "key_2", keysReplies); err != nil {
+		t.Fatal(err)
+	}
+	if err := rn.askStats("key_2", statsReplies); err != nil {
+		t.Fatal(err)
+	}
+	rn.auditKey("key", "key_2", auditReplies)
+	if err := rn.askPutAck(&message.PutAckReqMessage{Key: "key", PendingKey: "key_2"}, acks); err != nil {
+		t.Fatal(err)
+	}
+	rn.pending.fail()
+	if reply := <-ttlReplies; !errors.Is(reply.err, ErrNodeDisconnected) {
+		t.Errorf("a pending ttl request ought to fail with ErrNodeDisconnected, got %v", reply.err)
+	}
+	if reply := <-setNXReplies; !errors.Is(reply.err, ErrNodeDisconnected) {
+		t.Errorf("a pending set if absent request ought to fail with ErrNodeDisconnected, got %v", reply.err)
+	}
+	if reply := <-keysReplies; !errors.Is(reply.err, ErrNodeDisconnected) {
+		t.Errorf("a pending keys request ought to fail with ErrNodeDisconnected, got %v", reply.err)
+	}
+	if reply := <-statsReplies; !errors.Is(reply.err, ErrNodeDisconnected) {
+		t.Errorf("a pending statistics request ought to fail with ErrNodeDisconnected, got %v", reply.err)
+	}
+	if ack := <-acks; !errors.Is(ack.err, ErrNodeDisconnected) {
+		t.Errorf("a pending put acknowledgement ought to fail with ErrNodeDisconnected, got %v", ack.err)
+	}
+	if len(auditReplies) != 0 {
+		t.Error("a pending audit ought to be left to time out")
+	}
+	if _, ok := rn.pending.take(message.MsgTTLReq, "key_2"); ok {
+		t.Error("a failed request ought not to be answered")
+	}
+
+	if !errors.Is(ErrNotFound, bigcache.ErrEntryNotFound) || !errors.Is(ErrTimedOut, ErrTimeout) {
+		t.Error("the former names of the errors ought to match the new ones")
+	}
+	if !unavailable(fmt.Errorf("get failed: %w", ErrNodeDisconnected)) {
+		t.Error("a wrapped ErrNodeDisconnected ought to make the cluster unavailable")
+	}
+
+	//torn down while the state still said it was connected
+	if err := rn.askTTL("key", "key_3", make(chan ttlReply, 1)); !errors.Is(err, ErrNodeDisconnected) {
+		t.Errorf("asking a torn down remote node ought to fail with ErrNodeDisconnected, got %v", err)
+	}
+	if err := rn.askPutAck(&message.PutAckReqMessage{Key: "key", PendingKey: "key_3"}, acks); !errors.Is(err, ErrNodeDisconnected) {
+		t.Errorf("a put to a torn down remote node ought to fail with ErrNodeDisconnected, got %v", err)
+	}
+}
+
+func BenchmarkServeGet(b *testing.B) {
+	config := bigcache.DefaultConfig()
+	config.Verbose = false
+	cache, _ := bigcache.NewBigCache(config)
+	cache.Set("key", make([]byte, 512), 0)
+
+	b.Run("allocated", func(b *testing.B) {
+		b.ReportAllocs()
+		for x := 0; x < b.N; x++ {
+			data, _ := cache.Get("key")
+			buildFrame((&message.GetRspMessage{PendingKey: "pending", Data: data}).Serialize())
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for x := 0; x < b.N; x++ { //as handleGetRequest and networkSender do
+			value := getBuffer()
+			*value, _, _ = cache.AppendValue(*value, "key")
+			rsp := &pooledGetRsp{GetRspMessage: message.GetRspMessage{PendingKey: "pending", Data: *value}, value: value}
+			msg := rsp.Serialize()
+			releaseMessage(rsp)
+			frame := getBuffer()
+			*frame = appendFrame(*frame, msg)
+			putBuffer(frame)
+		}
+	})
+}
+
+func TestInboundQueue(t *testing.T) {
+	q := newInboundQueue(4)
+	gossip := func() *message.NodeWireMessage { return &message.NodeWireMessage{Code: message.MsgGossip} }
+	get := func(key string) *message.NodeWireMessage { return (&message.GetReqMessage{Key: key}).Serialize() }
+	put := func(key string) *message.NodeWireMessage { return (&message.PutMessage{Key: key}).Serialize() }
+
+	for _, msg := range []*message.NodeWireMessage{gossip(), gossip(), get("get_1"), put("put_1")} {
+		if !q.put(context.Background(), msg) {
+			t.Fatal("messages ought to be queued while there is room")
+		}
+	}
+	if !q.put(context.Background(), put("put_2")) || !q.put(context.Background(), get("get_2")) {
+		t.Error("a full queue ought to make room by dropping the oldest periodic messages")
+	}
+	if q.put(context.Background(), gossip()) || q.put(context.Background(), get("get_3")) {
+		t.Error("a full queue ought to drop messages with none of a lower class to make room")
+	}
+	if !q.put(context.Background(), put("put_3")) || !q.put(context.Background(), put("put_4")) {
+		t.Error("a full queue ought to make room for writes by dropping the oldest reads")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if q.put(ctx, put("put_5")) {
+		t.Error("a write ought to wait for room rather than drop the writes queued")
+	}
+	if q.len() != 4 {
+		t.Errorf("expected the queue full, got %d messages", q.len())
+	}
+	if dropped := q.droppedByClass(); dropped["periodic"] != 3 || dropped["read"] != 3 || dropped["other"] != 0 {
+		t.Errorf("unexpected drops by class %v", dropped)
+	}
+
+	var skipped []uint16
+	var keys []string
+	q.close()
+	for {
+		msg, ok := q.next(func(msg *message.NodeWireMessage) { skipped = append(skipped, msg.Code) })
+		if !ok {
+			break
+		}
+		m := message.PutMessage{}
+		m.DeSerialize(msg)
+		keys = append(keys, m.Key)
+	}
+	if !reflect.DeepEqual(keys, []string{"put_1", "put_2", "put_3", "put_4"}) {
+		t.Errorf("expected the messages left taken in the order they arrived, got %v", keys)
+	}
+	if !reflect.DeepEqual(skipped, []uint16{message.MsgGossip, message.MsgGossip, message.MsgGETReq, message.MsgGETReq}) {
+		t.Errorf("expected the messages dropped skipped, got %v", skipped)
+	}
+	if q.put(context.Background(), put("put_6")) {
+		t.Error("a closed queue ought not to take messages")
+	}
+}
+
+func TestControlLanes(t *testing.T) {
+	q := newInboundQueue(2)
+	put := (&message.PutMessage{Key: "key_1"}).Serialize()
+	q.put(context.Background(), put)
+	q.put(context.Background(), put)
+	if !q.put(context.Background(), &message.NodeWireMessage{Code: message.MsgPING}) {
+		t.Fatal("a ping ought to be queued apart from a queue full of data")
+	}
+	if msg, _ := q.next(nil); msg.Code != message.MsgPING {
+		t.Errorf("expected the ping handled ahead of the data queued before it, got %s", message.MsgCodeToString(msg.Code))
+	}
+	if msg, _ := q.next(nil); msg.Code != message.MsgPUT {
+		t.Errorf("expected the data handled after the ping, got %s", message.MsgCodeToString(msg.Code))
+	}
+
+	node := New(&ClusteredBigCacheConfig{LocalPort: 1081}, nil)
+	rn := newRemoteNode(&remoteNodeConfig{Id: "remote_1", IpAddress: "localhost:1080"}, node, nil)
+	rn.setState(nodeStateConnected)
+	rn.sendMessage(&message.PutMessage{Key: "key_1"})
+	rn.sendPing()
+	if m, _ := rn.nextOutbound(); m.Serialize().Code != message.MsgPING {
+		t.Errorf("expected the ping sent ahead of the data queued before it, got %T", m)
+	}
+	if m, _ := rn.nextOutbound(); m.Serialize().Code != message.MsgPUT {
+		t.Errorf("expected the data sent after the ping, got %T", m)
+	}
+}
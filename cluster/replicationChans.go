@@ -0,0 +1,56 @@
+package cluster
+
+import (
+	"hash/fnv"
+)
+
+//goroutines sending the writes to replicate to the remote nodes
+const replicationWorkers = 5
+
+//replicationChans queue the writes to replicate, one channel per replication goroutine. the writes to a remote
+//node all go through the same channel so they reach it in the order they were made, the appends of a single
+//writer to a list or a put followed by a delete are never swapped by goroutines racing each other
+type replicationChans []chan *replicationMsg
+
+//channels holding size writes between them
+func newReplicationChans(size int) replicationChans {
+	chans := make(replicationChans, replicationWorkers)
+	for x := range chans {
+		share := size / replicationWorkers
+		if x < size%replicationWorkers {
+			share++
+		}
+		chans[x] = make(chan *replicationMsg, share)
+	}
+	return chans
+}
+
+//the channel of the writes to r
+func (c replicationChans) of(r *remoteNode) chan *replicationMsg {
+	h := fnv.New32a()
+	h.Write([]byte(r.config.Id))
+	return c[h.Sum32()%uint32(len(c))]
+}
+
+//writes queued
+func (c replicationChans) len() int {
+	queued := 0
+	for _, ch := range c {
+		queued += len(ch)
+	}
+	return queued
+}
+
+func (c replicationChans) cap() int {
+	size := 0
+	for _, ch := range c {
+		size += cap(ch)
+	}
+	return size
+}
+
+func (c replicationChans) close() {
+	for _, ch := range c {
+		close(ch)
+	}
+}
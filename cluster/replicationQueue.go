@@ -0,0 +1,229 @@
+package cluster
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+	"github.com/nggenius/ngbigcache/message"
+)
+
+//attempts in a row a remote node is given to take the writes waiting for it before they are dropped
+const replicationRetryLimit = 10
+
+//the backoff between attempts to send a remote node the writes waiting for it, shorter than between attempts to
+//connect to it as a full outbound queue usually drains within milliseconds
+var replicationRetryPolicy = retryPolicy{
+	initial:    time.Millisecond * 100,
+	max:        time.Second * 10,
+	multiplier: defaultRetryMultiplier,
+	jitter:     defaultRetryJitter,
+}
+
+//ReplicationLag tells how far behind the writes replicated to a remote node are
+type ReplicationLag struct {
+	Id         string        `json:"id"`
+	Queued     int           `json:"queued"`     //writes waiting to be sent
+	Lag        time.Duration `json:"lag"`        //how long the oldest write waiting has waited
+	Failures   int           `json:"failures"`   //attempts in a row that found the remote node unable to take them
+	Retried    uint64        `json:"retried"`    //writes sent after waiting
+	Overflowed uint64        `json:"overflowed"` //oldest writes dropped to make room for newer ones
+	Dropped    uint64        `json:"dropped"`    //writes dropped once the remote node failed replicationRetryLimit attempts
+}
+
+//a write waiting for its remote node to take it
+type queuedWrite struct {
+	m      message.NodeMessage
+	queued time.Time
+}
+
+//the writes waiting for a remote node, kept by id so they survive it reconnecting
+type replicationBacklog struct {
+	lag    ReplicationLag
+	writes []queuedWrite
+	active bool //a goroutine is retrying them
+}
+
+//replicationQueue keeps the writes a remote node cannot take right away, because its outbound queue is full or
+//it is disconnected, and retries them with an exponential backoff. writes to a remote node with writes waiting
+//are queued behind them so they are applied in order. the writer never waits on a slow remote node
+type replicationQueue struct {
+	node     *ClusteredBigCache
+	size     int //writes kept per remote node
+	lock     sync.Mutex
+	backlogs map[string]*replicationBacklog
+	done     chan struct{}
+}
+
+//the replication queue of the configuration, nil when writes are not retried
+func newReplicationQueue(node *ClusteredBigCache) *replicationQueue {
+	if node.config.ReplicationRetryQueue <= 0 {
+		return nil
+	}
+	return &replicationQueue{
+		node:     node,
+		size:     node.config.ReplicationRetryQueue,
+		backlogs: make(map[string]*replicationBacklog),
+		done:     make(chan struct{}),
+	}
+}
+
+//send a write to its remote node, queueing it when the remote node cannot take it or has writes waiting
+func (q *replicationQueue) send(msg *replicationMsg) {
+	id := msg.r.config.Id
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	backlog := q.backlogs[id]
+	if (backlog == nil || len(backlog.writes) == 0) && msg.r.trySendMessage(msg.m) {
+		return
+	}
+	if backlog == nil {
+		backlog = &replicationBacklog{lag: ReplicationLag{Id: id}}
+		q.backlogs[id] = backlog
+	}
+	if len(backlog.writes) >= q.size {
+		backlog.writes = backlog.writes[1:]
+		backlog.lag.Overflowed++
+		q.node.internalError(&InternalError{Op: "replicate", NodeId: id, Err: ErrMessageDropped, Cause: "replication queue full"})
+	}
+	backlog.writes = append(backlog.writes, queuedWrite{m: msg.m, queued: q.node.clock.Now()})
+	if !backlog.active {
+		backlog.active = true
+		q.node.routines.spawn(func() { q.retry(id, backlog) })
+	}
+}
+
+//send the writes waiting for the remote node with id until none are left or it failed too many attempts
+func (q *replicationQueue) retry(id string, backlog *replicationBacklog) {
+	for {
+		q.lock.Lock()
+		wait := replicationRetryPolicy.interval(backlog.lag.Failures)
+		q.lock.Unlock()
+		timer := q.node.clock.NewTimer(wait)
+		select {
+		case <-q.done:
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+
+		var peer *remoteNode
+		if v, ok := q.node.remoteNodes.Get(id); ok { //the remote node it was queued for may have reconnected since
+			peer = v.(*remoteNode)
+		}
+
+		q.lock.Lock()
+		sent := 0
+		for ; sent < len(backlog.writes) && peer != nil && peer.trySendMessage(backlog.writes[sent].m); sent++ {
+		}
+		backlog.writes = backlog.writes[sent:]
+		backlog.lag.Retried += uint64(sent)
+		if sent > 0 {
+			backlog.lag.Failures = 0
+		} else {
+			backlog.lag.Failures++
+		}
+		if len(backlog.writes) > 0 && backlog.lag.Failures >= replicationRetryLimit {
+			backlog.lag.Dropped += uint64(len(backlog.writes))
+			q.node.internalError(&InternalError{Op: "replicate", NodeId: id, Err: ErrMessageDropped,
+				Cause: fmt.Sprintf("%d writes not taken after %d attempts", len(backlog.writes), backlog.lag.Failures)})
+			backlog.writes, backlog.lag.Failures = nil, 0
+		}
+		if len(backlog.writes) == 0 {
+			backlog.active = false
+			q.lock.Unlock()
+			return
+		}
+		q.lock.Unlock()
+	}
+}
+
+//how far behind the writes replicated to every remote node that ever had writes waiting are, by id
+func (q *replicationQueue) lag() []ReplicationLag {
+	if q == nil {
+		return nil
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	lags := make([]ReplicationLag, 0, len(q.backlogs))
+	for _, backlog := range q.backlogs {
+		lag := backlog.lag
+		lag.Queued = len(backlog.writes)
+		if lag.Queued > 0 {
+			lag.Lag = bigcache.Since(q.node.clock, backlog.writes[0].queued)
+		}
+		lags = append(lags, lag)
+	}
+	sort.Slice(lags, func(i, j int) bool { return lags[i].Id < lags[j].Id })
+	return lags
+}
+
+//writes waiting for the remote node with id
+func (q *replicationQueue) queued(id string) int {
+	if q == nil {
+		return 0
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if backlog := q.backlogs[id]; backlog != nil {
+		return len(backlog.writes)
+	}
+	return 0
+}
+
+//keep at most size writes per remote node from now on, dropping the oldest of those over it
+func (q *replicationQueue) resize(size int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.size = size
+	for id, backlog := range q.backlogs {
+		if over := len(backlog.writes) - size; over > 0 {
+			backlog.writes = backlog.writes[over:]
+			backlog.lag.Overflowed += uint64(over)
+			q.node.internalError(&InternalError{Op: "replicate", NodeId: id, Err: ErrMessageDropped,
+				Cause: "replication queue shrunk"})
+		}
+	}
+}
+
+func (q *replicationQueue) close() {
+	if q != nil {
+		close(q.done)
+	}
+}
+
+//ReplicationLag returns how far behind the writes replicated to the remote nodes are, for those that had writes
+//waiting since the node started. nil unless ReplicationRetryQueue is set
+func (node *ClusteredBigCache) ReplicationLag() []ReplicationLag {
+	return node.retryQueue.lag()
+}
+
+//queue a message to the remote node unless its outbound queue is full or it is disconnected, false when it was not
+func (r *remoteNode) trySendMessage(msg message.NodeMessage) (sent bool) {
+	defer func() {
+		if e := recover(); e != nil { //the outbound queue was closed on shut down
+			sent = false
+		}
+		if !sent {
+			atomic.AddInt64(&r.unsent, -1)
+		}
+	}()
+
+	atomic.AddInt64(&r.unsent, 1)
+	if r.state == nodeStateDisconnected {
+		return false
+	}
+	select {
+	case r.outboundMsgQueue <- msg:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,26 @@
+package cluster
+
+import (
+	"fmt"
+
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//length of the correlation ids of gets and puts
+const requestIdLength = 12
+
+//a correlation id for a get or put, empty unless LogRequests is set so nothing is logged for it
+func (node *ClusteredBigCache) newRequestId() string {
+	if !node.config.LogRequests {
+		return ""
+	}
+	return utils.GenerateNodeId(requestIdLength)
+}
+
+//log a line about the get or put with the correlation id requestId, nothing if it has none
+func logRequest(logger utils.AppLogger, requestId, msg string) {
+	if requestId == "" {
+		return
+	}
+	utils.Info(logger, fmt.Sprintf("request %s: %s", requestId, msg))
+}
@@ -0,0 +1,71 @@
+package cluster
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//least time between two logs of the progress of resharding
+const reshardLogInterval = time.Second
+
+//ReshardStats tells how far the local cache got spreading its entries over a new number of shards
+type ReshardStats struct {
+	From      int `json:"from"`
+	To        int `json:"to"`
+	Moved     int `json:"moved"`     //entries moved to the new shards so far
+	Remaining int `json:"remaining"` //entries left in the former shards
+}
+
+//SetShardSize spreads the entries of the local cache over shards shards, raised to 16 like ShardSize and which
+//must be a power of two, without emptying it. reads and writes are served all along, the entries they use are
+//moved first. it returns once every entry is moved, the progress is logged and reported by the admin server
+//meanwhile
+func (node *ClusteredBigCache) SetShardSize(shards int) error {
+	if node.mode != clusterModeACTIVE {
+		return errNotActive
+	}
+	if shards < 16 {
+		shards = 16
+	}
+
+	from := node.cache.Shards()
+	if from == shards {
+		return nil
+	}
+	utils.Info(node.logger, fmt.Sprintf("resharding the cache from %d to %d shards", from, shards))
+	started, logged := node.clock.Now(), node.clock.Now()
+	err := node.cache.Reshard(shards, func(p bigcache.ReshardProgress) {
+		node.resharding.Store(&ReshardStats{From: from, To: shards, Moved: p.Moved, Remaining: p.Remaining})
+		if bigcache.Since(node.clock, logged) >= reshardLogInterval {
+			logged = node.clock.Now()
+			utils.Info(node.logger, fmt.Sprintf("resharding moved %d entries, %d left", p.Moved, p.Remaining))
+		}
+	})
+	node.resharding.Store((*ReshardStats)(nil))
+	if err != nil {
+		utils.Error(node.logger, fmt.Sprintf("unable to reshard the cache to %d shards [%s]", shards, err.Error()))
+		return err
+	}
+
+	node.config.ShardSize = shards
+	utils.Info(node.logger, fmt.Sprintf("resharded the cache to %d shards in %s", shards, bigcache.Since(node.clock, started)))
+	return nil
+}
+
+//DoubleShardSize is SetShardSize with twice the shards the local cache has, for a node that outgrew them. it is
+//meant to be called from a goroutine of its own as it returns once every entry is moved
+func (node *ClusteredBigCache) DoubleShardSize() error {
+	if node.mode != clusterModeACTIVE {
+		return errNotActive
+	}
+	return node.SetShardSize(node.cache.Shards() * 2)
+}
+
+//how far resharding got, nil when the cache is not being resharded
+func (node *ClusteredBigCache) reshardStats() *ReshardStats {
+	stats, _ := node.resharding.Load().(*ReshardStats)
+	return stats
+}
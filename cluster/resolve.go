@@ -0,0 +1,35 @@
+package cluster
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nggenius/ngbigcache/comms"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//time the addresses of a peer addressed by hostname are relied upon when DNSTTL is not set
+const defaultDNSTTL = 30 * time.Second
+
+//cache of the addresses of the peers addressed by hostname, looked up through the configured resolver
+func (node *ClusteredBigCache) newDNSCache() *comms.DNSCache {
+	resolve := node.config.Resolver
+	if resolve == nil {
+		ttl := defaultDNSTTL
+		if node.config.DNSTTL > 0 {
+			ttl = time.Duration(node.config.DNSTTL) * time.Millisecond
+		}
+		resolve = comms.SystemResolver(ttl)
+	}
+
+	return comms.NewDNSCache(resolve, node.clock.Now, func(host string, from, to []string) {
+		msg := fmt.Sprintf("'%s' moved from %v to %v", host, from, to)
+		utils.Info(node.logger, msg)
+		node.emitEvent(EventPeerAddressChanged, msg)
+	})
+}
+
+//DNSStats returns the counts of the lookups of peers addressed by hostname
+func (node *ClusteredBigCache) DNSStats() comms.ResolverStats {
+	return node.dns.Stats()
+}
@@ -0,0 +1,60 @@
+package cluster
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+//defaults of the policy used to retry connecting to remote nodes
+const (
+	defaultRetryInitialInterval = time.Second * 3
+	defaultRetryMaxInterval     = time.Second * 60
+	defaultRetryMultiplier      = 2.0
+	defaultRetryJitter          = 0.2
+)
+
+//retryPolicy spaces out the attempts to connect to a remote node exponentially, with jitter so that the nodes
+//of a cluster restarting all at once do not all retry at the same moments
+type retryPolicy struct {
+	initial    time.Duration
+	max        time.Duration
+	multiplier float64
+	jitter     float64
+}
+
+//the retry policy of the configuration, with defaults for what is not set
+func newRetryPolicy(config *ClusteredBigCacheConfig) retryPolicy {
+	p := retryPolicy{
+		initial:    time.Millisecond * time.Duration(config.RetryInitialInterval),
+		max:        time.Millisecond * time.Duration(config.RetryMaxInterval),
+		multiplier: config.RetryMultiplier,
+		jitter:     config.RetryJitter,
+	}
+	if p.initial <= 0 {
+		p.initial = defaultRetryInitialInterval
+	}
+	if p.max <= 0 {
+		p.max = defaultRetryMaxInterval
+	}
+	if p.max < p.initial {
+		p.max = p.initial
+	}
+	if p.multiplier < 1 {
+		p.multiplier = defaultRetryMultiplier
+	}
+	if p.jitter <= 0 || p.jitter > 1 {
+		p.jitter = defaultRetryJitter
+	}
+	return p
+}
+
+//how long to wait before the given retry, the first retry being 0
+func (p retryPolicy) interval(retry int) time.Duration {
+	d := float64(p.initial) * math.Pow(p.multiplier, float64(retry))
+	if d > float64(p.max) {
+		d = float64(p.max)
+	}
+	d += d * p.jitter * (2*rand.Float64() - 1)
+	return time.Duration(d)
+}
@@ -0,0 +1,23 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	p := newRetryPolicy(&ClusteredBigCacheConfig{})
+	if p.initial != defaultRetryInitialInterval || p.max != defaultRetryMaxInterval || p.multiplier != defaultRetryMultiplier {
+		t.Errorf("unset retry policy ought to use the defaults, got %+v", p)
+	}
+
+	p = newRetryPolicy(&ClusteredBigCacheConfig{RetryInitialInterval: 100, RetryMaxInterval: 1000, RetryMultiplier: 3, RetryJitter: 0.1})
+	for retry, expected := range []time.Duration{100, 300, 900, 1000, 1000} {
+		expected *= time.Millisecond
+		for x := 0; x < 20; x++ {
+			if d := p.interval(retry); d < expected*9/10 || d > expected*11/10 {
+				t.Fatalf("retry %d ought to wait %s give or take 10%%, got %s", retry, expected, d)
+			}
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package cluster
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/nggenius/ngbigcache/message"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//Roles a node asks its peers for during the handshake
+const (
+	PEER_ROLE_READ_WRITE byte = iota //every message of the node is accepted
+	PEER_ROLE_READ_ONLY              //the node only reads, its writes are refused. e.g analytics consumers
+)
+
+var peerRoleNames = [...]string{"read_write", "read_only"}
+
+func peerRoleName(role byte) string {
+	if int(role) < len(peerRoleNames) {
+		return peerRoleNames[role]
+	}
+	return "unknown"
+}
+
+//check a message from the remote node against the role it asked for, the writes of a read only peer are
+//refused and counted against its role
+func (r *remoteNode) permitted(msg *message.NodeWireMessage) bool {
+	if r.role != PEER_ROLE_READ_ONLY {
+		return true
+	}
+
+	var key string
+	switch msg.Code {
+	case message.MsgPUT, message.MsgPUTEx, message.MsgPUTStamped:
+		putMsg := message.PutMessage{}
+		putMsg.DeSerialize(msg)
+		key = putMsg.Key
+	case message.MsgDEL:
+		delMsg := message.DeleteMessage{}
+		delMsg.DeSerialize(msg)
+		key = delMsg.Key
+	case message.MsgNOTFOUND:
+		notFoundMsg := message.NotFoundMessage{}
+		notFoundMsg.DeSerialize(msg)
+		key = notFoundMsg.Key
+	case message.MsgEVICT:
+		evictMsg := message.EvictMessage{}
+		evictMsg.DeSerialize(msg)
+		if len(evictMsg.Keys) > 0 {
+			key = evictMsg.Keys[0]
+		}
+	case message.MsgAPPEND:
+		appendMsg := message.AppendMessage{}
+		appendMsg.DeSerialize(msg)
+		key = appendMsg.Key
+	case message.MsgAPPENDBytes:
+		appendMsg := message.AppendBytesMessage{}
+		appendMsg.DeSerialize(msg)
+		key = appendMsg.Key
+	case message.MsgSADD:
+		sAddMsg := message.SAddMessage{}
+		sAddMsg.DeSerialize(msg)
+		key = sAddMsg.Key
+	case message.MsgHSET:
+		hSetMsg := message.HSetMessage{}
+		hSetMsg.DeSerialize(msg)
+		key = hSetMsg.Key
+	case message.MsgHDEL:
+		hDelMsg := message.HDelMessage{}
+		hDelMsg.DeSerialize(msg)
+		key = hDelMsg.Key
+	case message.MsgCOUNTER:
+		counterMsg := message.CounterMessage{}
+		counterMsg.DeSerialize(msg)
+		key = counterMsg.Key
+	case message.MsgLIST, message.MsgLISTReq:
+		listMsg := message.ListMessage{}
+		listMsg.DeSerialize(msg)
+		key = listMsg.Key
+	case message.MsgSETNXReq:
+		setNXMsg := message.SetIfAbsentReqMessage{}
+		setNXMsg.DeSerialize(msg)
+		key = setNXMsg.Key
+	case message.MsgPUTAckReq:
+		putMsg := message.PutAckReqMessage{}
+		putMsg.DeSerialize(msg)
+		key = putMsg.Key
+		defer r.sendMessage(&message.PutAckMessage{PendingKey: putMsg.PendingKey, Error: ErrReadOnly.Error()}) //the writer waits for it
+	default:
+		return true
+	}
+
+	atomic.AddUint64(&r.parentNode.roleDropped[r.role], 1)
+	if atomic.AddUint64(&r.metrics.roleDenied, 1) == 1 { //once, it is counted from then on
+		utils.Warn(r.logger, fmt.Sprintf("refusing %s of '%s' from remote node '%s' which is %s",
+			message.MsgCodeToString(msg.Code), key, r.config.Id, peerRoleName(r.role)))
+	}
+	r.sendMessage(&message.RefusedMessage{MsgCode: msg.Code, Key: key, Reason: peerRoleName(r.role)})
+	return false
+}
+
+//counts by the name of the role
+type roleCounts map[string]uint64
+
+//writes refused from peers by the role they asked for
+func (node *ClusteredBigCache) roleDrops() roleCounts {
+	drops := make(roleCounts, len(peerRoleNames))
+	for role, name := range peerRoleNames {
+		drops[name] = atomic.LoadUint64(&node.roleDropped[role])
+	}
+	return drops
+}
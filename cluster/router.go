@@ -0,0 +1,180 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//separator between the tenant and the rest of a key, when RouterConfig does not set one
+const defaultTenantSeparator = ":"
+
+//ErrNoRoute is returned when no rule picks a cluster for a key and there is no default cluster
+var ErrNoRoute = errors.New("no cluster to route the key to")
+
+//RouteRule sends the keys it matches to Cluster. a rule matches the keys starting with Prefix and, when Tenant is
+//set, whose tenant is Tenant. the tenant of a key is the part of it before the tenant separator
+type RouteRule struct {
+	Prefix  string `json:"prefix"`
+	Tenant  string `json:"tenant"`
+	Cluster string `json:"cluster"`
+}
+
+//RouterConfig is the configuration of a Router
+type RouterConfig struct {
+	Clusters        map[string]*ClusteredBigCache `json:"-"`                //nodes or passive clients of every cluster, by name
+	Rules           []RouteRule                   `json:"rules"`            //tried in order, the first that matches a key picks its cluster
+	Default         string                        `json:"default"`          //cluster of the keys no rule matches, "" fails them with ErrNoRoute
+	Fallbacks       map[string][]string           `json:"fallbacks"`        //clusters tried in turn, by cluster, when it cannot serve a request
+	TenantSeparator string                        `json:"tenant_separator"` //ends the tenant of a key, ":" if not set
+}
+
+//Router sends every key to the cluster its rules pick, for organizations running separate clusters per region
+//or class of data. a cluster that cannot serve a request, because it has no remote node to ask, times out, lost
+//quorum, loses the remote node it asked or refuses writes, hands it over to its fallbacks
+type Router struct {
+	config    RouterConfig
+	fallbacks uint64
+}
+
+//NewRouter creates a router, every cluster named by the rules, the default and the fallbacks must be given
+func NewRouter(config RouterConfig) (*Router, error) {
+	if config.TenantSeparator == "" {
+		config.TenantSeparator = defaultTenantSeparator
+	}
+
+	known := func(name string) error {
+		if _, ok := config.Clusters[name]; !ok {
+			return fmt.Errorf("unknown cluster '%s'", name)
+		}
+		return nil
+	}
+	for _, rule := range config.Rules {
+		if err := known(rule.Cluster); err != nil {
+			return nil, err
+		}
+	}
+	if config.Default != "" {
+		if err := known(config.Default); err != nil {
+			return nil, err
+		}
+	}
+	for name, fallbacks := range config.Fallbacks {
+		if err := known(name); err != nil {
+			return nil, err
+		}
+		for _, fallback := range fallbacks {
+			if err := known(fallback); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &Router{config: config}, nil
+}
+
+//Route returns the name of the cluster the key is sent to, "" if there is none
+func (router *Router) Route(key string) string {
+	tenant := key
+	if x := strings.Index(key, router.config.TenantSeparator); x >= 0 {
+		tenant = key[:x]
+	}
+
+	for _, rule := range router.config.Rules {
+		if strings.HasPrefix(key, rule.Prefix) && (rule.Tenant == "" || rule.Tenant == tenant) {
+			return rule.Cluster
+		}
+	}
+	return router.config.Default
+}
+
+//Cluster returns the node or passive client of the cluster the key is sent to, nil if there is none
+func (router *Router) Cluster(key string) *ClusteredBigCache {
+	return router.config.Clusters[router.Route(key)]
+}
+
+//Fallbacks returns the number of requests served by a fallback cluster
+func (router *Router) Fallbacks() uint64 {
+	return atomic.LoadUint64(&router.fallbacks)
+}
+
+//Put adds data into the cluster of the key
+func (router *Router) Put(key string, data []byte, duration time.Duration) error {
+	return router.route(key, func(node *ClusteredBigCache) error {
+		return node.Put(key, data, duration)
+	})
+}
+
+//PutUntil adds data into the cluster of the key which expires at the given wall-clock time
+func (router *Router) PutUntil(key string, data []byte, expireAt time.Time) error {
+	return router.route(key, func(node *ClusteredBigCache) error {
+		return node.PutUntil(key, data, expireAt)
+	})
+}
+
+//AppendBytes adds data to the end of the entry under key in the cluster of the key
+func (router *Router) AppendBytes(key string, data []byte, duration time.Duration) error {
+	return router.route(key, func(node *ClusteredBigCache) error {
+		return node.AppendBytes(key, data, duration)
+	})
+}
+
+//Get retrieves data from the cluster of the key
+func (router *Router) Get(key string, timeout time.Duration) ([]byte, error) {
+	var data []byte
+	err := router.route(key, func(node *ClusteredBigCache) error {
+		var err error
+		data, err = node.Get(key, timeout)
+		return err
+	})
+	return data, err
+}
+
+//TTL returns the time left before the key expires in its cluster
+func (router *Router) TTL(key string, timeout time.Duration) (time.Duration, error) {
+	var ttl time.Duration
+	err := router.route(key, func(node *ClusteredBigCache) error {
+		var err error
+		ttl, err = node.TTL(key, timeout)
+		return err
+	})
+	return ttl, err
+}
+
+//Delete removes a key from its cluster
+func (router *Router) Delete(key string) error {
+	return router.route(key, func(node *ClusteredBigCache) error {
+		return node.Delete(key)
+	})
+}
+
+//call fn with the cluster of the key, then with its fallbacks in turn while the cluster tried is unavailable
+func (router *Router) route(key string, fn func(node *ClusteredBigCache) error) error {
+	name := router.Route(key)
+	if name == "" {
+		return ErrNoRoute
+	}
+
+	err := fn(router.config.Clusters[name])
+	for _, fallback := range router.config.Fallbacks[name] {
+		if !unavailable(err) {
+			break
+		}
+		if err = fn(router.config.Clusters[fallback]); err == nil {
+			atomic.AddUint64(&router.fallbacks, 1)
+		}
+	}
+	return err
+}
+
+//whether err means the cluster could not serve the request, rather than it was served and failed
+func unavailable(err error) bool {
+	for _, target := range []error{ErrNotStarted, ErrNoPeers, ErrTimeout, ErrNodeDisconnected, ErrNoQuorum, ErrLeaving, ErrReadOnly} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRouter(t *testing.T) {
+	eu := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1968, ConnectRetries: 0}, nil)
+	us := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1967, ConnectRetries: 0}, nil)
+	archive := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1966, ConnectRetries: 0}, nil) //never started
+	us.Start()
+	defer us.ShutDown()
+
+	if _, err := NewRouter(RouterConfig{Clusters: map[string]*ClusteredBigCache{"eu": eu},
+		Rules: []RouteRule{{Prefix: "eu/", Cluster: "asia"}}}); err == nil {
+		t.Error("expected rules naming unknown clusters to be refused")
+	}
+
+	router, err := NewRouter(RouterConfig{
+		Clusters: map[string]*ClusteredBigCache{"eu": eu, "us": us, "archive": archive},
+		Rules: []RouteRule{
+			{Prefix: "eu/", Cluster: "eu"},
+			{Tenant: "acme", Cluster: "eu"},
+			{Prefix: "old/", Cluster: "archive"},
+		},
+		Default:   "us",
+		Fallbacks: map[string][]string{"archive": {"us"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, cluster := range map[string]string{"eu/key": "eu", "acme:key": "eu", "acme2:key": "us", "old/key": "archive", "key": "us"} {
+		if route := router.Route(key); route != cluster {
+			t.Errorf("expected %s to be routed to %s, got %s", key, cluster, route)
+		}
+	}
+
+	if err := router.Put("key", []byte("data"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := us.cache.Get("key"); string(data) != "data" {
+		t.Error("expected the key to be put in the default cluster")
+	}
+
+	if err := router.Put("old/key", []byte("data"), time.Minute); err != nil {
+		t.Fatalf("expected the write to fall back on a cluster that is up: %v", err)
+	}
+	if data, err := router.Get("old/key", time.Millisecond*100); err != nil || string(data) != "data" {
+		t.Errorf("expected the read to fall back on a cluster that is up, got %q: %v", data, err)
+	}
+	if router.Fallbacks() != 2 {
+		t.Errorf("expected 2 requests served by a fallback, got %d", router.Fallbacks())
+	}
+
+	if err := router.Put("eu/key", []byte("data"), time.Minute); err != ErrNotStarted {
+		t.Errorf("expected a cluster without fallbacks to fail, got %v", err)
+	}
+}
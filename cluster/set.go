@@ -0,0 +1,88 @@
+package cluster
+
+import (
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+	"github.com/nggenius/ngbigcache/message"
+)
+
+//SAdd adds members to the set under key in the cluster, creating it if there is none. a new set expires after
+//duration, an existing one keeps its expiry. only the members added are sent to the remote nodes, which add
+//them to their own copy
+func (node *ClusteredBigCache) SAdd(key string, members [][]byte, duration time.Duration) error {
+
+	if node.state != clusterStateStarted {
+		return ErrNotStarted
+	}
+	if err := node.admitWrite(); err != nil {
+		return err
+	}
+
+	//add locally first
+	expiryTime := bigcache.NO_EXPIRY
+	if node.mode == clusterModeACTIVE {
+		if err := node.throttle.admit(); err != nil {
+			return err
+		}
+		if _, err := node.cache.SAdd(key, members, duration); err != nil {
+			return err
+		}
+		if ttl, err := node.cache.TTL(key); err == nil && ttl != time.Duration(bigcache.NO_EXPIRY) {
+			expiryTime = uint64(node.clock.Now().Add(ttl).Unix())
+		}
+		node.watchers.notify(key, false)
+	} else if duration != time.Duration(bigcache.NO_EXPIRY) {
+		expiryTime = uint64(node.clock.Now().Unix()) + uint64(duration.Seconds())
+	}
+	node.bandwidth.wrote(len(key) + itemsSize(members))
+
+	peers := node.remoteNodes.Values()
+	for x := 0; x < len(peers); x++ {
+		if peers[x].(*remoteNode).mode == clusterModePASSIVE {
+			continue
+		}
+		node.queueReplication(&replicationMsg{r: peers[x].(*remoteNode),
+			m: &message.SAddMessage{Key: key, Members: members, Expiry: expiryTime}})
+	}
+	return nil
+}
+
+//SIsMember tells whether member is in the set under key. the set is read like Get reads a key
+func (node *ClusteredBigCache) SIsMember(key string, member []byte, timeout time.Duration) (bool, error) {
+	data, err := node.Get(key, timeout)
+	if err != nil {
+		return false, err
+	}
+	return bigcache.SetContains(data, member)
+}
+
+//SMembers returns the members of the set under key, in no particular order
+func (node *ClusteredBigCache) SMembers(key string, timeout time.Duration) ([][]byte, error) {
+	data, err := node.Get(key, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return bigcache.SetMembers(data)
+}
+
+func (r *remoteNode) handleSAdd(msg *message.NodeWireMessage) {
+
+	sAddMsg := message.SAddMessage{}
+	sAddMsg.DeSerialize(msg)
+	if !r.admitReplicatedWrite(msg.Code, sAddMsg.Key) || !r.admitThrottledWrite(msg.Code, sAddMsg.Key) {
+		return
+	}
+
+	var err error
+	if sAddMsg.Expiry == bigcache.NO_EXPIRY {
+		_, err = r.parentNode.cache.SAdd(sAddMsg.Key, sAddMsg.Members, 0)
+	} else { //the expiry is an absolute time so keep it as is rather than recomputing a duration
+		_, err = r.parentNode.cache.SAddUntil(sAddMsg.Key, sAddMsg.Members, time.Unix(int64(sAddMsg.Expiry), 0))
+	}
+	if err != nil {
+		r.replicaWriteFailed("replicate set add", sAddMsg.Key, err)
+		return
+	}
+	r.parentNode.watchers.notify(sAddMsg.Key, false)
+}
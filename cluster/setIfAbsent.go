@@ -0,0 +1,197 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+	"github.com/nggenius/ngbigcache/message"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//a set if absent request reached a node which holds no data
+var errNotActive = errors.New("only active nodes own keys")
+
+//what the owner of a key answered to a set if absent or a list request
+type setIfAbsentReply struct {
+	stored bool
+	data   []byte //the item popped by a list request
+	err    error
+}
+
+//SetIfAbsent adds data into the cluster only if no node holds the key yet, returning whether it was stored. every
+//node sends the request to the same owner of the key, picked among the active nodes by rendezvous hashing, which
+//checks and stores the key atomically and replicates it when stored, so of concurrent callers across the cluster
+//exactly one succeeds. this makes it fit for distributed locks and deduplication as long as the nodes agree on
+//the active nodes, while the membership changes two owners may each accept the key once. ErrNodeDisconnected is
+//returned when the owner went away before answering, the key may or may not have been stored then
+func (node *ClusteredBigCache) SetIfAbsent(key string, data []byte, duration, timeout time.Duration) (bool, error) {
+
+	if node.state != clusterStateStarted {
+		return false, ErrNotStarted
+	}
+	if err := node.checkValueSize(key, data); err != nil {
+		return false, err
+	}
+	if err := node.admitWrite(); err != nil {
+		return false, err
+	}
+
+	expiryTime := bigcache.NO_EXPIRY
+	if duration != time.Duration(bigcache.NO_EXPIRY) {
+		expiryTime = uint64(node.clock.Now().Unix()) + uint64(duration.Seconds())
+	}
+
+	owner, found := node.keyOwner(key)
+	if !found {
+		return false, ErrNoPeers
+	}
+	if owner == nil {
+		stored, err := node.setIfAbsentLocally(key, data, expiryTime)
+		if stored {
+			node.bandwidth.wrote(len(key) + len(data))
+		}
+		return stored, err
+	}
+
+	replies := make(chan setIfAbsentReply, 1)
+	pendingKey := key + utils.GenerateNodeId(8)
+	if err := owner.askSetIfAbsent(key, data, expiryTime, pendingKey, replies); err != nil {
+		return false, err
+	}
+	defer owner.pending.cancel(message.MsgSETNXReq, pendingKey)
+
+	timer := node.clock.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case reply := <-replies:
+		if reply.stored {
+			node.bandwidth.wrote(len(key) + len(data))
+		}
+		if reply.stored && node.mode == clusterModeACTIVE { //the owner replicates it too, this saves waiting for it
+			node.storeEntry(key, Entry{Data: data, Expiry: expiryTime})
+		}
+		return reply.stored, reply.err
+	case <-timer.C():
+		return false, ErrTimeout
+	}
+}
+
+//the owner of key: nil for this node, otherwise the active remote node owning it. false when there is no active
+//node at all. every node hashes the key with the ids and weights of the same nodes so they all pick the same
+//owner, and a node owns a share of the keys in proportion to its weight
+func (node *ClusteredBigCache) keyOwner(key string) (*remoteNode, bool) {
+	var owner *remoteNode
+	found := false
+	var highest float64
+	if node.mode == clusterModeACTIVE {
+		highest, found = utils.WeightedScore(node.config.Id, key, node.config.Weight), true
+	}
+
+	for _, r := range node.activePeers() {
+		if r.version() < message.MsgMinVersion(message.MsgSETNXReq) { //older nodes would never answer
+			continue
+		}
+		if score := utils.WeightedScore(r.config.Id, key, r.weight); !found || score > highest {
+			owner, highest, found = r, score, true
+		}
+	}
+	return owner, found
+}
+
+//store the key unless it is held already, replicating it to the remote nodes when stored
+func (node *ClusteredBigCache) setIfAbsentLocally(key string, data []byte, expiryTime uint64) (bool, error) {
+	if err := node.throttle.admit(); err != nil {
+		return false, err
+	}
+
+	unlock := node.versions.lockKey(key)
+	var stored bool
+	var err error
+	if expiryTime == bigcache.NO_EXPIRY {
+		stored, err = node.cache.SetIfAbsent(key, data, 0)
+	} else { //the expiry is an absolute time so keep it as is rather than recomputing a duration
+		stored, err = node.cache.SetIfAbsentUntil(key, data, time.Unix(int64(expiryTime), 0))
+	}
+	if err != nil || !stored {
+		unlock()
+		return false, err
+	}
+	stamp := node.versions.stamp(key, expiryTime)
+	unlock()
+
+	node.watchers.notify(key, false)
+	node.replicatePut(key, data, expiryTime, stamp, node.newRequestId())
+	return true, nil
+}
+
+//ask the remote node owning key to store it unless it holds it already, the reply is sent on replies
+func (r *remoteNode) askSetIfAbsent(key string, data []byte, expiryTime uint64, pendingKey string,
+	replies chan setIfAbsentReply) error {
+	return r.askOwner(&message.SetIfAbsentReqMessage{Key: key, Data: data, Expiry: expiryTime, PendingKey: pendingKey},
+		pendingKey, replies)
+}
+
+//send a request to the remote node owning its key, the reply is sent on replies. set if absent, release and list
+//requests are all pending as MsgSETNXReq since they are answered the same way
+func (r *remoteNode) askOwner(reqMsg message.NodeMessage, pendingKey string, replies chan setIfAbsentReply) error {
+	if r.state == nodeStateDisconnected {
+		return ErrNodeDisconnected
+	}
+	if err := r.pending.add(message.MsgSETNXReq, pendingKey, replies, func() {
+		replies <- setIfAbsentReply{err: ErrNodeDisconnected}
+	}); err != nil {
+		return err
+	}
+	r.sendMessage(reqMsg)
+	return nil
+}
+
+func (r *remoteNode) handleSetIfAbsentRequest(msg *message.NodeWireMessage) {
+	reqMsg := message.SetIfAbsentReqMessage{}
+	reqMsg.DeSerialize(msg)
+
+	rsp := &message.SetIfAbsentRspMessage{PendingKey: reqMsg.PendingKey}
+	err := r.parentNode.admitWrite()
+	if err == nil && r.parentNode.mode != clusterModeACTIVE {
+		err = errNotActive
+	}
+	if err == nil && reqMsg.Release {
+		rsp.Stored, err = r.parentNode.releaseLocally(reqMsg.Key, reqMsg.Data)
+	} else if err == nil {
+		rsp.Stored, err = r.parentNode.setIfAbsentLocally(reqMsg.Key, reqMsg.Data, reqMsg.Expiry)
+	}
+	if err != nil {
+		rsp.Error = err.Error()
+	}
+	r.sendMessage(rsp)
+}
+
+func (r *remoteNode) handleSetIfAbsentResponse(msg *message.NodeWireMessage) {
+	rspMsg := message.SetIfAbsentRspMessage{}
+	rspMsg.DeSerialize(msg)
+	replies, ok := r.pending.take(message.MsgSETNXReq, rspMsg.PendingKey)
+	if !ok { //the request timed out
+		return
+	}
+
+	reply := setIfAbsentReply{stored: rspMsg.Stored}
+	if rspMsg.Error != "" {
+		reply.err = remoteError(r.config.Id, rspMsg.Error)
+	}
+	//buffered for the one reply so this never blocks
+	replies.(chan setIfAbsentReply) <- reply
+}
+
+//the error a remote node failed with, the errors of this package and of the cache are given back as themselves so
+//callers can check for them with errors.Is
+func remoteError(id, reason string) error {
+	for _, err := range []error{ErrLeaving, ErrReadOnly, ErrNoQuorum, bigcache.ErrExpiryInPast, bigcache.ErrShardFull,
+		bigcache.ErrListEmpty, bigcache.ErrNotList, bigcache.ErrNotIndexed} {
+		if err.Error() == reason {
+			return err
+		}
+	}
+	return fmt.Errorf("remote node '%s' failed [%s]", id, reason)
+}
@@ -0,0 +1,85 @@
+package cluster
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync/atomic"
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//slowOps logs the gets, the ping round trips and the waits for the lock of a shard of the local cache that take
+//longer than their threshold. the log lines are key=value pairs to be searched for, keys are logged by their hash
+//so their content does not end up in the logs
+type slowOps struct {
+	logger utils.AppLogger
+	clock  bigcache.Clock
+	get    time.Duration //0 when gets are not timed
+	ping   time.Duration //0 when pings are not timed
+	lock   time.Duration //0 when lock waits are not timed
+	logged uint64
+}
+
+//the slow operations logged for the configuration, nil if none is
+func newSlowOps(config *ClusteredBigCacheConfig, logger utils.AppLogger) *slowOps {
+	if config.SlowGetThreshold <= 0 && config.SlowPingThreshold <= 0 && config.SlowLockWait <= 0 {
+		return nil
+	}
+	return &slowOps{
+		logger: logger,
+		clock:  config.clock(),
+		get:    time.Millisecond * time.Duration(config.SlowGetThreshold),
+		ping:   time.Millisecond * time.Duration(config.SlowPingThreshold),
+		lock:   time.Microsecond * time.Duration(config.SlowLockWait),
+	}
+}
+
+//the hash keys are logged by
+func keyHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+//time a get of key started at started and answered by peer, empty when it was served locally or not answered
+func (s *slowOps) observeGet(key, peer string, started time.Time) {
+	if s == nil || s.get == 0 {
+		return
+	}
+	if took := bigcache.Since(s.clock, started); took >= s.get {
+		if peer == "" {
+			peer = "-"
+		}
+		s.log(fmt.Sprintf("slow op=get key_hash=%016x peer=%s took=%s threshold=%s", keyHash(key), peer, took, s.get))
+	}
+}
+
+//check the round trip of a ping to peer
+func (s *slowOps) observePing(peer string, rtt time.Duration) {
+	if s == nil || s.ping == 0 {
+		return
+	}
+	if rtt >= s.ping {
+		s.log(fmt.Sprintf("slow op=ping peer=%s rtt=%s threshold=%s", peer, rtt, s.ping))
+	}
+}
+
+//a read or write of the key hashed to hashedKey waited longer than the budget for the lock of its shard
+func (s *slowOps) observeLockWait(shard, hashedKey uint64, waited time.Duration) {
+	s.log(fmt.Sprintf("slow op=lock shard=%d key_hash=%016x waited=%s threshold=%s", shard, hashedKey, waited, s.lock))
+}
+
+func (s *slowOps) log(msg string) {
+	atomic.AddUint64(&s.logged, 1)
+	utils.Warn(s.logger, msg)
+}
+
+//number of slow operations logged
+func (s *slowOps) count() uint64 {
+	if s == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&s.logged)
+}
@@ -0,0 +1,170 @@
+package cluster
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//CacheStatistics are the statistics of the local cache, summed over its shards
+type CacheStatistics struct {
+	Entries     int     `json:"entries"`
+	Shards      int     `json:"shards"`
+	Capacity    int     `json:"capacity"`   //bytes allocated by the queues of the shards
+	LiveBytes   int     `json:"live_bytes"` //bytes of those held by live entries
+	BytesUsed   int64   `json:"bytes_used"` //what HardMaxCacheSize limits
+	Hits        int64   `json:"hits"`
+	Misses      int64   `json:"misses"`
+	HitRatio    float64 `json:"hit_ratio"`
+	DelHits     int64   `json:"del_hits"`
+	DelMisses   int64   `json:"del_misses"`
+	Collisions  int64   `json:"collisions"`
+	Expired     int64   `json:"expired"`
+	Evicted     int64   `json:"evicted"`
+	Deleted     int64   `json:"deleted"`
+	Overwritten int64   `json:"overwritten"`
+	Cleared     int64   `json:"cleared"`
+	NoSpace     int64   `json:"no_space"`  //writes rejected for want of room in their shard
+	Reclaimed   int64   `json:"reclaimed"` //bytes of holes left by deleted entries given back by compacting
+}
+
+//PeerStatistics are the statistics of the connection to a remote node
+type PeerStatistics struct {
+	Id            string        `json:"id"`
+	Address       string        `json:"address"`
+	Passive       bool          `json:"passive"`
+	Connections   int           `json:"connections"`
+	InboundQueue  int           `json:"inbound_queue"`
+	OutboundQueue int           `json:"outbound_queue"`
+	PingSent      uint64        `json:"ping_sent"`
+	PongReceived  uint64        `json:"pong_received"`
+	PingRTT       time.Duration `json:"ping_rtt"`      //round trip of the last ping answered
+	PingSkipped   uint64        `json:"ping_skipped"`  //pings not sent as messages of the peer proved it alive
+	PingInterval  time.Duration `json:"ping_interval"` //between the pings sent now, which adaptive pinging changes
+	DroppedMsg    uint64        `json:"dropped_msg"`
+	CorruptMsg    uint64        `json:"corrupt_msg"`
+	RateLimited   uint64        `json:"rate_limited"`
+	Refused       uint64        `json:"refused"`
+	Role          string        `json:"role"`
+	RoleDenied    uint64        `json:"role_denied"` //writes refused because of the role of the peer
+	Weight        int           `json:"weight"`      //capacity weight the peer announced, 0 if it did not
+
+	InboundDropped map[string]uint64       `json:"inbound_dropped"` //messages dropped from the inbound queue when it was full, by class
+	Traffic        map[string]TrafficStats `json:"traffic"`         //by message code
+}
+
+//NodeStatistics are the statistics of a node for code to consume, Statistics formats them for people to read
+type NodeStatistics struct {
+	Id               string                  `json:"id"`
+	Passive          bool                    `json:"passive"`
+	Cache            *CacheStatistics        `json:"cache,omitempty"` //nil for passive clients, which hold no keys
+	ReplicationQueue int                     `json:"replication_queue"`
+	GetRequestQueue  int                     `json:"get_request_queue"`
+	Peers            []PeerStatistics        `json:"peers"`
+	Replication      []ReplicationLag        `json:"replication,omitempty"` //writes waiting for remote nodes, when retried
+	Backpressured    uint64                  `json:"backpressured"`         //puts refused over ReplicationHighWaterMark
+	Traffic          map[string]TrafficStats `json:"traffic"`               //with every remote node, by message code
+}
+
+//StatisticsStruct returns the statistics of the local cache, of the queues of the node and of its remote nodes
+func (node *ClusteredBigCache) StatisticsStruct() *NodeStatistics {
+	stats := &NodeStatistics{
+		Id:               node.config.Id,
+		Passive:          node.mode == clusterModePASSIVE,
+		ReplicationQueue: node.replicationMsgs.len(),
+		GetRequestQueue:  len(node.getRequestChan),
+		Peers:            node.peerStatistics(),
+		Replication:      node.ReplicationLag(),
+		Backpressured:    atomic.LoadUint64(&node.backpressured),
+		Traffic:          make(map[string]TrafficStats),
+	}
+	for _, v := range node.getRemoteNodes() {
+		v.(*remoteNode).metrics.traffic.addTo(stats.Traffic)
+	}
+
+	if node.mode == clusterModeACTIVE {
+		s := node.cache.Stats()
+		stats.Cache = &CacheStatistics{
+			Entries:     node.cache.Len(),
+			Shards:      node.cache.Shards(),
+			Capacity:    node.cache.Capacity(),
+			LiveBytes:   node.cache.LiveBytes(),
+			BytesUsed:   s.BytesUsed,
+			Hits:        s.Hits,
+			Misses:      s.Misses,
+			DelHits:     s.DelHits,
+			DelMisses:   s.DelMisses,
+			Collisions:  s.Collisions,
+			Expired:     s.Expired,
+			Evicted:     s.Evicted,
+			Deleted:     s.Deleted,
+			Overwritten: s.Overwritten,
+			Cleared:     s.Cleared,
+			NoSpace:     s.NoSpace,
+			Reclaimed:   s.Reclaimed,
+		}
+		if s.Hits+s.Misses > 0 {
+			stats.Cache.HitRatio = float64(s.Hits) / float64(s.Hits+s.Misses)
+		}
+	}
+	return stats
+}
+
+//the statistics of the connection to every remote node
+func (node *ClusteredBigCache) peerStatistics() []PeerStatistics {
+	peers := make([]PeerStatistics, 0)
+	for _, v := range node.getRemoteNodes() {
+		r := v.(*remoteNode)
+		r.lanesLock.RLock()
+		connections := len(r.lanes) + 1
+		r.lanesLock.RUnlock()
+		traffic := make(map[string]TrafficStats)
+		r.metrics.traffic.addTo(traffic)
+		peers = append(peers, PeerStatistics{
+			Id:            r.config.Id,
+			Address:       r.config.IpAddress,
+			Passive:       r.mode == clusterModePASSIVE,
+			Connections:   connections,
+			InboundQueue:  r.inboundMsgQueue.len(),
+			OutboundQueue: len(r.outboundMsgQueue),
+			PingSent:      atomic.LoadUint64(&r.metrics.pingSent),
+			PongReceived:  atomic.LoadUint64(&r.metrics.pongRecieved),
+			PingRTT:       time.Duration(atomic.LoadInt64(&r.metrics.pingRTT)),
+			PingSkipped:   atomic.LoadUint64(&r.metrics.pingSkipped),
+			PingInterval:  time.Duration(atomic.LoadInt64(&r.metrics.pingInterval)),
+			DroppedMsg:    atomic.LoadUint64(&r.metrics.dropedMsg),
+			CorruptMsg:    atomic.LoadUint64(&r.metrics.corruptMsg),
+			RateLimited:   atomic.LoadUint64(&r.metrics.rateLimited),
+			Refused:       atomic.LoadUint64(&r.metrics.refused),
+			Role:          peerRoleName(r.role),
+			RoleDenied:    atomic.LoadUint64(&r.metrics.roleDenied),
+			Weight:        r.weight,
+			Traffic:       traffic,
+
+			InboundDropped: r.inboundMsgQueue.droppedByClass(),
+		})
+	}
+	return peers
+}
+
+//format the statistics for people to read, a line for the node then a line per remote node
+func (stats *NodeStatistics) String() string {
+	var b strings.Builder
+	c := stats.Cache
+	if c == nil {
+		c = &CacheStatistics{}
+	}
+	fmt.Fprintf(&b, "node '%s': %d entries in %d shards, %d of %d bytes live, %d hits %d misses (%.2f), %d collisions, "+
+		"%d expired %d evicted %d deleted %d overwritten %d rejected for want of room, replication queue %d, get request queue %d",
+		stats.Id, c.Entries, c.Shards, c.LiveBytes, c.Capacity, c.Hits, c.Misses, c.HitRatio, c.Collisions,
+		c.Expired, c.Evicted, c.Deleted, c.Overwritten, c.NoSpace, stats.ReplicationQueue, stats.GetRequestQueue)
+	for _, p := range stats.Peers {
+		fmt.Fprintf(&b, "\npeer '%s' at %s: %d connections, inbound queue %d, outbound queue %d, %d dropped %d corrupt %d rate limited %d refused",
+			p.Id, p.Address, p.Connections, p.InboundQueue, p.OutboundQueue, p.DroppedMsg, p.CorruptMsg, p.RateLimited, p.Refused)
+	}
+	for _, lag := range stats.Replication {
+		fmt.Fprintf(&b, "\nreplication to '%s': %d writes queued for %s", lag.Id, lag.Queued, lag.Lag)
+	}
+	return b.String()
+}
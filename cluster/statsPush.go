@@ -0,0 +1,230 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nggenius/ngbigcache/message"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//least time between two pushes of stats to a subscriber, the stats pushed are built at most once per interval
+//whatever the number of subscribers
+const minStatsPushInterval = time.Millisecond * 100
+
+//PeerStats is the latest stats a remote node pushed to this node
+type PeerStats struct {
+	Id       string          `json:"id"`
+	Received time.Time       `json:"received"`
+	Stats    json.RawMessage `json:"stats"` //as reported by the admin server of the remote node
+}
+
+//statsFeed keeps the stats pushed by the remote nodes this node subscribed to, so a dashboard reads them from
+//this node instead of polling every node
+type statsFeed struct {
+	lock     sync.Mutex
+	interval time.Duration
+	handler  func(PeerStats)
+	peers    map[string]PeerStats
+}
+
+//statsPublisher pushes the stats of this node to the remote nodes subscribed to them
+type statsPublisher struct {
+	node        *ClusteredBigCache
+	lock        sync.Mutex
+	subscribers map[string]chan struct{} //closed to stop pushing, by id of the remote node
+	subscribed  int32
+	buildLock   sync.Mutex
+	built       []byte
+	builtAt     time.Time
+}
+
+func newStatsPublisher(node *ClusteredBigCache) *statsPublisher {
+	return &statsPublisher{node: node, subscribers: make(map[string]chan struct{})}
+}
+
+//SubscribeStats asks every remote node, and those connecting later, to push its stats every interval. the
+//latest stats of each are returned by PeerStats and passed to handler, when not nil, as they arrive. handler is
+//called from the goroutine handling messages from the remote node, it must not block. calling it again
+//replaces the interval and the handler, an interval of 0 unsubscribes. remote nodes stop pushing on their own
+//when their connection to this node is lost
+func (node *ClusteredBigCache) SubscribeStats(interval time.Duration, handler func(PeerStats)) error {
+	if node.state != clusterStateStarted {
+		return ErrNotStarted
+	}
+	if interval > 0 && interval < minStatsPushInterval {
+		interval = minStatsPushInterval
+	}
+
+	node.statsFeed.lock.Lock()
+	node.statsFeed.interval = interval
+	node.statsFeed.handler = handler
+	if interval == 0 {
+		node.statsFeed.peers = nil
+	}
+	node.statsFeed.lock.Unlock()
+
+	for _, v := range node.getRemoteNodes() {
+		v.(*remoteNode).sendStatsSubscription(interval)
+	}
+	utils.Info(node.logger, fmt.Sprintf("subscribed to the stats of the remote nodes every %s", interval))
+	return nil
+}
+
+//PeerStats returns the latest stats pushed by every remote node this node subscribed to, by id
+func (node *ClusteredBigCache) PeerStats() []PeerStats {
+	node.statsFeed.lock.Lock()
+	stats := make([]PeerStats, 0, len(node.statsFeed.peers))
+	for _, s := range node.statsFeed.peers {
+		stats = append(stats, s)
+	}
+	node.statsFeed.lock.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Id < stats[j].Id })
+	return stats
+}
+
+//ask the remote node for its stats every interval, when this node subscribed to them
+func (r *remoteNode) sendStatsSubscription(interval time.Duration) {
+	if r.version() < message.MsgMinVersion(message.MsgSTATSSubscribe) { //older nodes would never push
+		return
+	}
+	r.sendMessage(&message.StatsSubscribeMessage{Interval: int64(interval / time.Millisecond)})
+}
+
+//subscribe a newly connected remote node to its stats when this node subscribed to those of every node
+func (node *ClusteredBigCache) subscribeStats(r *remoteNode) {
+	node.statsFeed.lock.Lock()
+	interval := node.statsFeed.interval
+	node.statsFeed.lock.Unlock()
+
+	if interval > 0 {
+		r.sendStatsSubscription(interval)
+	}
+}
+
+//forget the stats of a remote node that disconnected
+func (node *ClusteredBigCache) dropPeerStats(id string) {
+	node.statsFeed.lock.Lock()
+	delete(node.statsFeed.peers, id)
+	node.statsFeed.lock.Unlock()
+}
+
+//start pushing stats to the remote node every interval, replacing any earlier subscription. 0 stops pushing
+func (p *statsPublisher) subscribe(r *remoteNode, interval time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if stop, ok := p.subscribers[r.config.Id]; ok {
+		close(stop)
+		delete(p.subscribers, r.config.Id)
+		atomic.StoreInt32(&p.subscribed, int32(len(p.subscribers)))
+	}
+	if interval <= 0 || p.subscribers == nil {
+		return
+	}
+	if interval < minStatsPushInterval {
+		interval = minStatsPushInterval
+	}
+
+	stop := make(chan struct{})
+	p.subscribers[r.config.Id] = stop
+	atomic.StoreInt32(&p.subscribed, int32(len(p.subscribers)))
+	if !p.node.routines.spawn(func() { p.push(r, interval, stop) }) {
+		delete(p.subscribers, r.config.Id)
+		atomic.StoreInt32(&p.subscribed, int32(len(p.subscribers)))
+		return
+	}
+	utils.Info(p.node.logger, fmt.Sprintf("pushing stats to remote node '%s' every %s", r.config.Id, interval))
+}
+
+//stop pushing stats to a remote node, it disconnected
+func (p *statsPublisher) unsubscribe(id string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if stop, ok := p.subscribers[id]; ok {
+		close(stop)
+		delete(p.subscribers, id)
+		atomic.StoreInt32(&p.subscribed, int32(len(p.subscribers)))
+	}
+}
+
+//push the stats to the remote node every interval until stopped
+func (p *statsPublisher) push(r *remoteNode, interval time.Duration, stop chan struct{}) {
+	ticker := p.node.clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C():
+			r.sendMessage(&message.StatsPushMessage{Stats: p.stats()})
+		}
+	}
+}
+
+//the stats of this node as reported by the admin server, built again once they are older than
+//minStatsPushInterval so that many subscribers do not each have them built
+func (p *statsPublisher) stats() []byte {
+	p.buildLock.Lock()
+	defer p.buildLock.Unlock()
+
+	if now := p.node.clock.Now(); now.Sub(p.builtAt) >= minStatsPushInterval {
+		p.built, _ = json.Marshal(p.node.adminStats())
+		p.builtAt = now
+	}
+	return p.built
+}
+
+//number of remote nodes stats are pushed to
+func (p *statsPublisher) count() int {
+	return int(atomic.LoadInt32(&p.subscribed))
+}
+
+//stop pushing stats to every remote node
+func (p *statsPublisher) close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, stop := range p.subscribers {
+		close(stop)
+	}
+	p.subscribers = nil
+	atomic.StoreInt32(&p.subscribed, 0)
+}
+
+//a remote node subscribed to the stats of this node
+func (r *remoteNode) handleStatsSubscribe(msg *message.NodeWireMessage) {
+	subMsg := message.StatsSubscribeMessage{}
+	subMsg.DeSerialize(msg)
+	r.parentNode.statsPublisher.subscribe(r, time.Duration(subMsg.Interval)*time.Millisecond)
+}
+
+//the remote node pushed its stats
+func (r *remoteNode) handleStatsPush(msg *message.NodeWireMessage) {
+	pushMsg := message.StatsPushMessage{}
+	pushMsg.DeSerialize(msg)
+
+	feed := r.parentNode.statsFeed
+	stats := PeerStats{Id: r.config.Id, Received: r.parentNode.clock.Now(), Stats: pushMsg.Stats}
+	feed.lock.Lock()
+	if feed.interval == 0 { //pushed before the remote node was told to stop
+		feed.lock.Unlock()
+		return
+	}
+	if feed.peers == nil {
+		feed.peers = make(map[string]PeerStats)
+	}
+	feed.peers[r.config.Id] = stats
+	handler := feed.handler
+	feed.lock.Unlock()
+
+	if handler != nil {
+		handler(stats)
+	}
+}
@@ -0,0 +1,73 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//Errors reported to OnInternalError in strict mode
+var (
+	ErrMessageDropped     = errors.New("message dropped")
+	ErrReplicaWriteFailed = errors.New("replicated write could not be applied")
+)
+
+//InternalError is a failure the node recovered from on its own and which would otherwise go unnoticed, such as
+//a message dropped or a replicated write not applied. it is only reported in strict mode
+type InternalError struct {
+	Op     string      //what the node was doing, e.g "send" or "replicate put"
+	NodeId string      //the remote node involved, if any
+	Key    string      //the key involved, if any
+	Err    error       //ErrMessageDropped or ErrReplicaWriteFailed
+	Cause  interface{} //what caused it, an error or a recovered panic, if known
+}
+
+func (e *InternalError) Error() string {
+	msg := e.Op
+	if e.NodeId != "" {
+		msg += fmt.Sprintf(" with '%s'", e.NodeId)
+	}
+	if e.Key != "" {
+		msg += fmt.Sprintf(" of '%s'", e.Key)
+	}
+	msg += ": " + e.Err.Error()
+	if e.Cause != nil {
+		msg += fmt.Sprintf(" [%v]", e.Cause)
+	}
+	return msg
+}
+
+//InternalErrors returns the number of internal errors reported since the node started, always 0 unless in strict mode
+func (node *ClusteredBigCache) InternalErrors() uint64 {
+	return atomic.LoadUint64(&node.internalErrors)
+}
+
+//report a failure the node recovered from on its own, when in strict mode. the first one is logged and every one
+//is handed over to OnInternalError, which must not block
+func (node *ClusteredBigCache) internalError(e *InternalError) {
+	if !node.config.StrictMode {
+		return
+	}
+
+	if atomic.AddUint64(&node.internalErrors, 1) == 1 { //once, it is counted from then on
+		utils.Warn(node.logger, fmt.Sprintf("internal error, later ones are counted but not logged [%s]", e.Error()))
+	}
+	if node.config.OnInternalError != nil {
+		node.config.OnInternalError(e)
+	}
+}
+
+//report a message to or from the remote node that was dropped, what names the message
+func (r *remoteNode) messageDropped(op, what string, cause interface{}) {
+	r.parentNode.internalError(&InternalError{Op: op + " " + what, NodeId: r.config.Id, Err: ErrMessageDropped, Cause: cause})
+}
+
+//report a write replicated by the remote node that was not applied locally
+func (r *remoteNode) replicaWriteFailed(op, key string, err error) {
+	if err == nil {
+		return
+	}
+	r.parentNode.internalError(&InternalError{Op: op, NodeId: r.config.Id, Key: key, Err: ErrReplicaWriteFailed, Cause: err})
+}
@@ -0,0 +1,179 @@
+package cluster
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/nggenius/ngbigcache/message"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//Write throttle modes
+const (
+	THROTTLE_MODE_DELAY  byte = iota //throttled writes are delayed by WriteThrottleDelay
+	THROTTLE_MODE_REJECT             //throttled writes fail with a ThrottledError
+)
+
+//how often the eviction pressure is sampled
+const throttleSampleInterval = time.Second
+
+//ThrottledError is returned by writes rejected while the node is under eviction pressure
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("writes are throttled under eviction pressure, retry after %s", e.RetryAfter)
+}
+
+//ThrottleStats shows how often writes were throttled
+type ThrottleStats struct {
+	Throttled          bool   `json:"throttled"`
+	PressureRate       int64  `json:"pressure_rate"` //writes that found no space in the last sample interval
+	Delayed            uint64 `json:"delayed"`
+	Rejected           uint64 `json:"rejected"`
+	DroppedReplication uint64 `json:"dropped_replication"`
+}
+
+//writeThrottle slows down or rejects writes while the cache is out of space, rather than letting
+//every write churn the cache
+type writeThrottle struct {
+	node      *ClusteredBigCache
+	pressure  func() int64 //ever increasing count of writes that found no space
+	threshold int64
+	mode      byte
+	delay     time.Duration
+	throttled int32
+	last      int64
+	rate      int64
+	delayed   uint64
+	rejected  uint64
+	dropped   uint64
+	done      chan struct{}
+}
+
+func newWriteThrottle(node *ClusteredBigCache, pressure func() int64) *writeThrottle {
+	delay := time.Millisecond * time.Duration(node.config.WriteThrottleDelay)
+	if delay <= 0 {
+		delay = time.Millisecond * 100
+	}
+
+	return &writeThrottle{
+		node:      node,
+		pressure:  pressure,
+		threshold: int64(node.config.WriteThrottleThreshold),
+		mode:      node.config.WriteThrottleMode,
+		delay:     delay,
+		last:      pressure(),
+		done:      make(chan struct{}),
+	}
+}
+
+//the pressure on the local cache
+func cachePressure(node *ClusteredBigCache) func() int64 {
+	return func() int64 {
+		return node.cache.Stats().NoSpace
+	}
+}
+
+//sample the pressure and switch throttling on or off
+func (t *writeThrottle) update() {
+	current := t.pressure()
+	rate := current - t.last
+	t.last = current
+	atomic.StoreInt64(&t.rate, rate)
+
+	if rate >= t.threshold {
+		if atomic.CompareAndSwapInt32(&t.throttled, 0, 1) {
+			msg := fmt.Sprintf("%d writes found no space in the last %s, throttling writes", rate, throttleSampleInterval)
+			utils.Warn(t.node.logger, msg)
+			t.node.emitEvent(EventThrottleOn, msg)
+		}
+	} else if atomic.CompareAndSwapInt32(&t.throttled, 1, 0) {
+		msg := fmt.Sprintf("%d writes found no space in the last %s, no longer throttling writes", rate, throttleSampleInterval)
+		utils.Info(t.node.logger, msg)
+		t.node.emitEvent(EventThrottleOff, msg)
+	}
+}
+
+func (t *writeThrottle) run() {
+	ticker := t.node.clock.NewTicker(throttleSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C():
+			t.update()
+		}
+	}
+}
+
+func (t *writeThrottle) close() {
+	close(t.done)
+}
+
+//admit a local write, delaying or rejecting it when throttled
+func (t *writeThrottle) admit() error {
+	if t == nil || atomic.LoadInt32(&t.throttled) == 0 {
+		return nil
+	}
+
+	if t.mode == THROTTLE_MODE_REJECT {
+		atomic.AddUint64(&t.rejected, 1)
+		return &ThrottledError{RetryAfter: t.delay}
+	}
+
+	atomic.AddUint64(&t.delayed, 1)
+	t.node.clock.Sleep(t.delay)
+	return nil
+}
+
+//admit a write replicated from a remote node. delaying it holds back the remote node's messages,
+//rejecting it drops the write since there is no one to retry it
+func (t *writeThrottle) admitReplicated() bool {
+	if t == nil || atomic.LoadInt32(&t.throttled) == 0 {
+		return true
+	}
+
+	if t.mode == THROTTLE_MODE_REJECT {
+		atomic.AddUint64(&t.dropped, 1)
+		return false
+	}
+
+	atomic.AddUint64(&t.delayed, 1)
+	t.node.clock.Sleep(t.delay)
+	return true
+}
+
+//admit a write replicated by the remote node, a write dropped by the throttle is an internal error
+func (r *remoteNode) admitThrottledWrite(code uint16, key string) bool {
+	if r.parentNode.throttle.admitReplicated() {
+		return true
+	}
+
+	r.parentNode.internalError(&InternalError{Op: "replicate " + message.MsgCodeToString(code), NodeId: r.config.Id,
+		Key: key, Err: ErrReplicaWriteFailed, Cause: "throttled"})
+	return false
+}
+
+func (t *writeThrottle) stats() *ThrottleStats {
+	if t == nil {
+		return nil
+	}
+
+	return &ThrottleStats{
+		Throttled:          atomic.LoadInt32(&t.throttled) == 1,
+		PressureRate:       atomic.LoadInt64(&t.rate),
+		Delayed:            atomic.LoadUint64(&t.delayed),
+		Rejected:           atomic.LoadUint64(&t.rejected),
+		DroppedReplication: atomic.LoadUint64(&t.dropped),
+	}
+}
+
+//ThrottleStats returns the write throttling statistics, nil if write throttling is not enabled
+func (node *ClusteredBigCache) ThrottleStats() *ThrottleStats {
+	return node.throttle.stats()
+}
@@ -0,0 +1,51 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWriteThrottle(t *testing.T) {
+	var pressure int64
+	events := make([]EventType, 0)
+	node := New(&ClusteredBigCacheConfig{WriteThrottleThreshold: 10, WriteThrottleMode: THROTTLE_MODE_REJECT, WriteThrottleDelay: 50,
+		OnEvent: func(e Event) { events = append(events, e.Type) }}, nil)
+	throttle := newWriteThrottle(node, func() int64 { return pressure })
+
+	pressure += 5
+	throttle.update()
+	if err := throttle.admit(); err != nil {
+		t.Error("writes ought not to be throttled under the threshold")
+	}
+
+	pressure += 10
+	throttle.update()
+	err := throttle.admit()
+	if e, ok := err.(*ThrottledError); !ok || e.RetryAfter != time.Millisecond*50 {
+		t.Errorf("writes ought to be rejected with a retry after over the threshold, got %v", err)
+	}
+	if throttle.admitReplicated() {
+		t.Error("replicated writes ought to be dropped when rejecting")
+	}
+
+	throttle.update() //no new pressure
+	if err := throttle.admit(); err != nil {
+		t.Error("writes ought not to be throttled once the pressure is gone")
+	}
+
+	stats := throttle.stats()
+	if stats.Rejected != 1 || stats.DroppedReplication != 1 || stats.Throttled {
+		t.Errorf("unexpected throttle stats %+v", stats)
+	}
+	if len(events) != 2 || events[0] != EventThrottleOn || events[1] != EventThrottleOff {
+		t.Errorf("throttling on and off ought to raise events, got %v", events)
+	}
+
+	throttle.mode = THROTTLE_MODE_DELAY
+	pressure += 10
+	throttle.update()
+	start := time.Now()
+	if err := throttle.admit(); err != nil || time.Since(start) < time.Millisecond*50 {
+		t.Error("writes ought to be delayed when delaying")
+	}
+}
@@ -0,0 +1,60 @@
+package cluster
+
+import (
+	"sync/atomic"
+
+	"github.com/nggenius/ngbigcache/message"
+)
+
+//message codes traffic is counted for, every code of the protocol is below it
+const trafficCodes = 64
+
+//TrafficStats are the messages of one code sent to and received from remote nodes, and their bytes framing included
+type TrafficStats struct {
+	SentMessages     uint64 `json:"sent_messages"`
+	SentBytes        uint64 `json:"sent_bytes"`
+	ReceivedMessages uint64 `json:"received_messages"`
+	ReceivedBytes    uint64 `json:"received_bytes"`
+}
+
+//the traffic with a remote node by message code, so what dominates the bandwidth, replication or remote reads,
+//can be told apart
+type trafficCounters [trafficCodes]TrafficStats
+
+//count a frame of bytes sent with the message code
+func (t *trafficCounters) sent(code uint16, bytes int) {
+	if code < trafficCodes {
+		atomic.AddUint64(&t[code].SentMessages, 1)
+		atomic.AddUint64(&t[code].SentBytes, uint64(bytes))
+	}
+}
+
+//count a message received, as framed by buildFrame
+func (t *trafficCounters) received(msg *message.NodeWireMessage) {
+	if msg.Code < trafficCodes {
+		atomic.AddUint64(&t[msg.Code].ReceivedMessages, 1)
+		atomic.AddUint64(&t[msg.Code].ReceivedBytes, uint64(message.FrameHeaderSize+len(msg.Data)))
+	}
+}
+
+//add the traffic counted to stats, by name of the message code. codes without traffic are left out
+func (t *trafficCounters) addTo(stats map[string]TrafficStats) {
+	for code := range t {
+		c := TrafficStats{
+			SentMessages:     atomic.LoadUint64(&t[code].SentMessages),
+			SentBytes:        atomic.LoadUint64(&t[code].SentBytes),
+			ReceivedMessages: atomic.LoadUint64(&t[code].ReceivedMessages),
+			ReceivedBytes:    atomic.LoadUint64(&t[code].ReceivedBytes),
+		}
+		if c.SentMessages == 0 && c.ReceivedMessages == 0 {
+			continue
+		}
+		name := message.MsgCodeToString(uint16(code))
+		total := stats[name]
+		total.SentMessages += c.SentMessages
+		total.SentBytes += c.SentBytes
+		total.ReceivedMessages += c.ReceivedMessages
+		total.ReceivedBytes += c.ReceivedBytes
+		stats[name] = total
+	}
+}
@@ -0,0 +1,135 @@
+package cluster
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+	"github.com/nggenius/ngbigcache/message"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//what a remote node holds of a key whose ttl was asked for
+type ttlReply struct {
+	found bool
+	ttl   time.Duration
+	err   error //ErrNodeDisconnected when the remote node went away before answering
+}
+
+//TTL returns the time left before key expires, time.Duration(bigcache.NO_EXPIRY) if it never does. the local
+//copy answers if there is one, otherwise every active remote node is asked and the first to hold the key answers.
+//ErrNodeDisconnected rather than ErrEntryNotFound is returned when a remote node asked went away before answering
+func (node *ClusteredBigCache) TTL(key string, timeout time.Duration) (time.Duration, error) {
+	if node.state != clusterStateStarted {
+		return 0, ErrNotStarted
+	}
+
+	if node.mode == clusterModeACTIVE {
+		if ttl, err := node.cache.TTL(key); err == nil {
+			return ttl, nil
+		}
+	}
+
+	peers := make([]*remoteNode, 0)
+	for _, r := range node.activePeers() {
+		if r.version() >= message.MsgMinVersion(message.MsgTTLReq) { //older nodes would never answer
+			peers = append(peers, r)
+		}
+	}
+	if len(peers) < 1 {
+		return 0, ErrNotFound
+	}
+
+	var failed error
+	waiting := 0
+	replies := make(chan ttlReply, len(peers))
+	for _, r := range peers {
+		pendingKey := key + utils.GenerateNodeId(8)
+		if err := r.askTTL(key, pendingKey, replies); err != nil {
+			failed = err
+			continue
+		}
+		waiting++
+		defer r.pending.cancel(message.MsgTTLReq, pendingKey)
+	}
+
+	timer := node.clock.NewTimer(timeout)
+	defer timer.Stop()
+	for ; waiting > 0; waiting-- {
+		select {
+		case reply := <-replies:
+			if reply.found {
+				return reply.ttl, nil
+			}
+			if reply.err != nil {
+				failed = reply.err
+			}
+		case <-timer.C():
+			return 0, ErrTimeout
+		}
+	}
+	if failed != nil { //the key may be on the remote node that went away
+		return 0, failed
+	}
+	return 0, ErrEntryNotFound
+}
+
+//the expiry a replicated put is stored with. a put delivered late may have little or no time left, false means
+//it expired already and is dropped rather than stored only to be expired right away. less time left than
+//MinReplicatedTTL is stretched to it so the copy is not gone before it is read
+func (node *ClusteredBigCache) replicatedExpiry(expiry uint64) (uint64, bool) {
+	if expiry == bigcache.NO_EXPIRY {
+		return expiry, true
+	}
+
+	now := uint64(node.clock.Now().Unix())
+	if expiry <= now {
+		atomic.AddUint64(&node.expiredReplicas, 1)
+		return expiry, false
+	}
+	if floor := now + uint64(node.config.MinReplicatedTTL); expiry < floor {
+		atomic.AddUint64(&node.clampedTTLs, 1)
+		return floor, true
+	}
+	return expiry, true
+}
+
+//ask the remote node how long its copy of key has left, the reply is sent on replies
+func (r *remoteNode) askTTL(key, pendingKey string, replies chan ttlReply) error {
+	if r.state == nodeStateDisconnected {
+		return ErrNodeDisconnected
+	}
+	if err := r.pending.add(message.MsgTTLReq, pendingKey, replies, func() {
+		replies <- ttlReply{err: ErrNodeDisconnected}
+	}); err != nil {
+		return err
+	}
+	r.sendMessage(&message.TTLReqMessage{Key: key, PendingKey: pendingKey})
+	return nil
+}
+
+func (r *remoteNode) handleTTLRequest(msg *message.NodeWireMessage) {
+	reqMsg := message.TTLReqMessage{}
+	reqMsg.DeSerialize(msg)
+
+	rsp := &message.TTLRspMessage{PendingKey: reqMsg.PendingKey}
+	if r.parentNode.mode == clusterModeACTIVE {
+		if ttl, err := r.parentNode.cache.TTL(reqMsg.Key); err == nil {
+			rsp.Found = true
+			rsp.TTL = int64(ttl)
+		}
+	}
+	r.sendMessage(rsp)
+}
+
+func (r *remoteNode) handleTTLResponse(msg *message.NodeWireMessage) {
+	rspMsg := message.TTLRspMessage{}
+	rspMsg.DeSerialize(msg)
+	replies, ok := r.pending.take(message.MsgTTLReq, rspMsg.PendingKey)
+	if !ok { //the request timed out
+		return
+	}
+
+	//buffered for every peer asked so this never blocks
+	replies.(chan ttlReply) <- ttlReply{found: rspMsg.Found, ttl: time.Duration(rspMsg.TTL)}
+}
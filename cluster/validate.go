@@ -0,0 +1,184 @@
+package cluster
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+)
+
+//highest port a node can listen on
+const maxPort = 65535
+
+//Validate returns every problem of the configuration, those of the local cache it configures included, none when
+//a node can be started with it. Start refuses a configuration with problems with a *bigcache.ConfigError listing
+//them. ConfigureCache is called to validate the configuration of the local cache
+func (config *ClusteredBigCacheConfig) Validate() []error {
+	var errs []error
+
+	ports := make(map[int]string)
+	for _, port := range []struct {
+		name  string
+		value int
+	}{
+		{"LocalPort", config.LocalPort},
+		{"AdvertisedPort", config.AdvertisedPort},
+		{"WebSocketPort", config.WebSocketPort},
+		{"DebugPort", config.DebugPort},
+		{"ProbePort", config.ProbePort},
+		{"PprofPort", config.PprofPort},
+	} {
+		if port.value < 0 || port.value > maxPort {
+			errs = append(errs, fmt.Errorf("%s %d is not a valid port", port.name, port.value))
+			continue
+		}
+		if port.value == 0 || port.name == "AdvertisedPort" { //not listened on
+			continue
+		}
+		if other, taken := ports[port.value]; taken {
+			errs = append(errs, fmt.Errorf("%s and %s are both port %d", other, port.name, port.value))
+		}
+		ports[port.value] = port.name
+	}
+	if config.Join && config.JoinIp == "" && len(config.JoinIps) == 0 && config.Discovery == nil {
+		errs = append(errs, fmt.Errorf("Join is set without JoinIp, JoinIps or Discovery to join through"))
+	}
+
+	for _, mode := range []struct {
+		name       string
+		value, max byte
+	}{
+		{"ReplicationMode", config.ReplicationMode, REPLICATION_MODE_SHARD},
+		{"WriteThrottleMode", config.WriteThrottleMode, THROTTLE_MODE_REJECT},
+		{"ReadOnlyMode", config.ReadOnlyMode, READ_ONLY_MODE_DROP},
+		{"Role", config.Role, PEER_ROLE_READ_ONLY},
+		{"NoPeersMode", config.NoPeersMode, NO_PEERS_MODE_WAIT},
+		{"QuorumMode", config.QuorumMode, QUORUM_MODE_NOTIFY},
+		{"EvictionBroadcast", config.EvictionBroadcast, EVICTION_BROADCAST_ALL},
+	} {
+		if mode.value > mode.max {
+			errs = append(errs, fmt.Errorf("%s %d is unknown", mode.name, mode.value))
+		}
+	}
+
+	for _, field := range []struct {
+		name  string
+		value int64
+	}{
+		{"ConnectRetries", int64(config.ConnectRetries)},
+		{"ReplicationFactor", int64(config.ReplicationFactor)},
+		{"PingFailureThreshHold", int64(config.PingFailureThreshHold)},
+		{"PingInterval", int64(config.PingInterval)},
+		{"PingTimeout", int64(config.PingTimeout)},
+		{"ConnectionsPerNode", int64(config.ConnectionsPerNode)},
+		{"WriteCoalesceInterval", int64(config.WriteCoalesceInterval)},
+		{"CompactInterval", int64(config.CompactInterval)},
+		{"HotKeyCapacity", int64(config.HotKeyCapacity)},
+		{"DivergenceWindow", int64(config.DivergenceWindow)},
+		{"RetryInitialInterval", int64(config.RetryInitialInterval)},
+		{"RetryMaxInterval", int64(config.RetryMaxInterval)},
+		{"WriteThrottleThreshold", int64(config.WriteThrottleThreshold)},
+		{"WriteThrottleDelay", int64(config.WriteThrottleDelay)},
+		{"PrimeBudget", int64(config.PrimeBudget)},
+		{"GossipInterval", int64(config.GossipInterval)},
+		{"GossipFanout", int64(config.GossipFanout)},
+		{"SuspicionTimeout", int64(config.SuspicionTimeout)},
+		{"InboundWriteRate", int64(config.InboundWriteRate)},
+		{"InboundReadRate", int64(config.InboundReadRate)},
+		{"InboundBurst", int64(config.InboundBurst)},
+		{"FloodDisconnectThreshold", int64(config.FloodDisconnectThreshold)},
+		{"WarmUpPeriod", int64(config.WarmUpPeriod)},
+		{"MinimumClusterSize", int64(config.MinimumClusterSize)},
+		{"PassiveIdleTimeout", int64(config.PassiveIdleTimeout)},
+		{"DNSTTL", int64(config.DNSTTL)},
+		{"MinReplicatedTTL", int64(config.MinReplicatedTTL)},
+		{"BandwidthInterval", int64(config.BandwidthInterval)},
+		{"ChangelogSize", int64(config.ChangelogSize)},
+		{"ReplicationRetryQueue", int64(config.ReplicationRetryQueue)},
+		{"ReplicationHighWaterMark", int64(config.ReplicationHighWaterMark)},
+		{"Weight", int64(config.Weight)},
+		{"ProfileLatency", int64(config.ProfileLatency)},
+		{"ProfileEvictionPass", int64(config.ProfileEvictionPass)},
+		{"ProfileDuration", int64(config.ProfileDuration)},
+		{"ProfileInterval", int64(config.ProfileInterval)},
+		{"SlowGetThreshold", int64(config.SlowGetThreshold)},
+		{"SlowPingThreshold", int64(config.SlowPingThreshold)},
+		{"SlowLockWait", int64(config.SlowLockWait)},
+		{"MaxFrameSize", int64(config.MaxFrameSize)},
+		{"ReadDeadline", int64(config.ReadDeadline)},
+		{"WriteDeadline", int64(config.WriteDeadline)},
+		{"MaxPingInterval", int64(config.MaxPingInterval)},
+		{"EvictionBatchInterval", int64(config.EvictionBatchInterval)},
+	} {
+		if field.value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative, got %d", field.name, field.value))
+		}
+	}
+
+	for _, field := range []struct {
+		name  string
+		value float64
+	}{
+		{"DivergenceThreshold", config.DivergenceThreshold},
+		{"WarmUpHitRatio", config.WarmUpHitRatio},
+		{"RetryJitter", config.RetryJitter},
+	} {
+		if field.value < 0 || field.value > 1 {
+			errs = append(errs, fmt.Errorf("%s must be between 0 and 1, got %g", field.name, field.value))
+		}
+	}
+	if config.RetryMultiplier < 0 {
+		errs = append(errs, fmt.Errorf("RetryMultiplier must not be negative, got %g", config.RetryMultiplier))
+	}
+	pingInterval := config.PingInterval
+	if pingInterval < 1 { //the default of the remote nodes
+		pingInterval = 5
+	}
+	if config.AdaptivePing { //the longest idle connections go without pings
+		if config.MaxPingInterval > 0 {
+			pingInterval = config.MaxPingInterval
+		} else {
+			pingInterval *= defaultMaxPingIntervals
+		}
+	}
+	if config.ReadDeadline > 0 && config.ReadDeadline <= pingInterval*1000 {
+		errs = append(errs, fmt.Errorf("ReadDeadline %dms must be longer than the %ds between pings, connections would be dropped between them",
+			config.ReadDeadline, pingInterval))
+	}
+
+	cfg := config.cacheConfig()
+	if config.ConfigureCache != nil {
+		config.ConfigureCache(&cfg)
+	}
+	return append(errs, cfg.Validate()...)
+}
+
+//the configuration of the local cache of an active node, before ConfigureCache is applied
+func (config *ClusteredBigCacheConfig) cacheConfig() bigcache.Config {
+	cfg := bigcache.DefaultConfig()
+	if config.ShardSize < 16 {
+		cfg.Shards = 16
+	} else {
+		cfg.Shards = config.ShardSize
+	}
+	cfg.CompactInterval = time.Duration(config.CompactInterval) * time.Millisecond
+	cfg.MmapDir = config.MmapDir
+	cfg.MaxValueSize = config.MaxValueSize
+	cfg.LockStripes = config.LockStripes
+	cfg.IndexKeys = config.IndexKeys
+	if config.MaxEntrySize > 0 {
+		cfg.MaxEntrySize = config.MaxEntrySize
+	}
+	if config.MaxEntriesInWindow > 0 {
+		cfg.MaxEntriesInWindow = config.MaxEntriesInWindow
+	}
+	cfg.HardMaxCacheSize = config.HardMaxCacheSize
+	cfg.Verbose = !config.QuietCache
+	cfg.Clock = config.clock()
+	if config.Instance != "" {
+		cfg.Logger = log.New(os.Stdout, instancePrefix(config), log.LstdFlags)
+	}
+	return cfg
+}
@@ -0,0 +1,39 @@
+package cluster
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//WarmLoader fills the local cache of a node, typically from the store the cache is in front of, calling put with
+//every key to store. ttl is time.Duration(bigcache.NO_EXPIRY) for a key that never expires
+type WarmLoader func(put func(key string, val []byte, ttl time.Duration)) error
+
+//Warm runs loader and stores every key it puts in the local cache, so a node just deployed does not answer its
+//first reads with misses. the keys are not replicated, every node runs the loader for itself. keys that could not
+//be stored are counted and logged, the error of the loader is returned. passive clients hold no keys, the loader
+//is not run
+func (node *ClusteredBigCache) Warm(loader WarmLoader) error {
+	if node.mode != clusterModeACTIVE {
+		return nil
+	}
+
+	atomic.StoreInt32(&node.warming, 1) //not ready until the loader is done
+	defer atomic.StoreInt32(&node.warming, 0)
+	start := node.clock.Now()
+	loaded, failed := 0, 0
+	err := loader(func(key string, val []byte, ttl time.Duration) {
+		if _, err := node.cache.Set(key, val, ttl); err != nil {
+			failed++
+			utils.Warn(node.logger, fmt.Sprintf("unable to warm '%s' up [%s]", key, err))
+			return
+		}
+		loaded++
+	})
+	utils.Info(node.logger, fmt.Sprintf("warmed the cache up with %d keys in %s, %d failed", loaded, bigcache.Since(node.clock, start), failed))
+	return err
+}
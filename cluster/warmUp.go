@@ -0,0 +1,157 @@
+package cluster
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+const (
+	//how often the local hit ratio is sampled while warming up
+	warmUpSampleInterval = time.Second
+	//reads needed in a sample interval before its hit ratio can end warming up
+	warmUpMinReads = 100
+)
+
+//WarmUpStats shows how far a node restarted with a cold cache has warmed up
+type WarmUpStats struct {
+	Warming   bool          `json:"warming"`
+	Remaining time.Duration `json:"remaining"` //left of WarmUpPeriod, 0 once warmed up
+	Hits      uint64        `json:"hits"`      //reads served locally while warming up
+	Misses    uint64        `json:"misses"`    //reads that had to be answered by remote nodes
+	Fetched   uint64        `json:"fetched"`   //keys read from remote nodes and stored locally
+	HitRatio  float64       `json:"hit_ratio"` //local hit ratio of the last sample interval
+}
+
+//warmUp stores the keys local misses read from remote nodes, with their original expiry, for WarmUpPeriod
+//after the node started or until the local hit ratio reaches WarmUpHitRatio, whichever comes first
+type warmUp struct {
+	node      *ClusteredBigCache
+	until     time.Time
+	target    float64
+	warming   int32
+	hits      uint64
+	misses    uint64
+	fetched   uint64
+	lastHits  uint64
+	lastMiss  uint64
+	ratioBits uint64
+	done      chan struct{}
+}
+
+func newWarmUp(node *ClusteredBigCache) *warmUp {
+	return &warmUp{
+		node:    node,
+		until:   node.clock.Now().Add(time.Second * time.Duration(node.config.WarmUpPeriod)),
+		target:  node.config.WarmUpHitRatio,
+		warming: 1,
+		done:    make(chan struct{}),
+	}
+}
+
+//check if reads missing locally are still to be stored
+func (w *warmUp) active() bool {
+	return w != nil && atomic.LoadInt32(&w.warming) == 1
+}
+
+//count a read, hit when it was served locally
+func (w *warmUp) record(hit bool) {
+	if !w.active() {
+		return
+	}
+
+	if hit {
+		atomic.AddUint64(&w.hits, 1)
+	} else {
+		atomic.AddUint64(&w.misses, 1)
+	}
+}
+
+//store a key read from a remote node with the expiry it has there
+func (w *warmUp) store(key string, data []byte, expiry uint64) {
+	var err error
+	if expiry == bigcache.NO_EXPIRY {
+		_, err = w.node.cache.Set(key, data, 0)
+	} else {
+		_, err = w.node.cache.SetUntil(key, data, time.Unix(int64(expiry), 0))
+	}
+	if err == nil {
+		atomic.AddUint64(&w.fetched, 1)
+	}
+}
+
+//sample the hit ratio and end warming up when it recovered or the period is over
+func (w *warmUp) update(now time.Time) bool {
+	hits, misses := atomic.LoadUint64(&w.hits), atomic.LoadUint64(&w.misses)
+	reads := (hits - w.lastHits) + (misses - w.lastMiss)
+	ratio := 0.0
+	if reads > 0 {
+		ratio = float64(hits-w.lastHits) / float64(reads)
+	}
+	w.lastHits, w.lastMiss = hits, misses
+	atomic.StoreUint64(&w.ratioBits, math.Float64bits(ratio))
+
+	var msg string
+	switch {
+	case w.target > 0 && reads >= warmUpMinReads && ratio >= w.target:
+		msg = fmt.Sprintf("local hit ratio recovered to %.2f, warmed up with %d keys fetched", ratio, atomic.LoadUint64(&w.fetched))
+	case !now.Before(w.until):
+		msg = fmt.Sprintf("warm up period is over, warmed up with %d keys fetched", atomic.LoadUint64(&w.fetched))
+	default:
+		return false
+	}
+
+	atomic.StoreInt32(&w.warming, 0)
+	utils.Info(w.node.logger, msg)
+	w.node.emitEvent(EventWarmedUp, msg)
+	return true
+}
+
+func (w *warmUp) run() {
+	ticker := w.node.clock.NewTicker(warmUpSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case now := <-ticker.C():
+			if w.update(now) {
+				return
+			}
+		}
+	}
+}
+
+func (w *warmUp) close() {
+	close(w.done)
+}
+
+func (w *warmUp) stats() *WarmUpStats {
+	if w == nil {
+		return nil
+	}
+
+	stats := &WarmUpStats{
+		Warming:  w.active(),
+		Hits:     atomic.LoadUint64(&w.hits),
+		Misses:   atomic.LoadUint64(&w.misses),
+		Fetched:  atomic.LoadUint64(&w.fetched),
+		HitRatio: math.Float64frombits(atomic.LoadUint64(&w.ratioBits)),
+	}
+	if stats.Warming {
+		if stats.Remaining = w.until.Sub(w.node.clock.Now()); stats.Remaining < 0 {
+			stats.Remaining = 0
+		}
+	}
+	return stats
+}
+
+//WarmUpStats returns how far the node has warmed up, nil if warming up is not enabled
+func (node *ClusteredBigCache) WarmUpStats() *WarmUpStats {
+	return node.warmUp.stats()
+}
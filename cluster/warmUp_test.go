@@ -0,0 +1,64 @@
+package cluster
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWarmUpHitRatio(t *testing.T) {
+	events := make([]EventType, 0)
+	node := New(&ClusteredBigCacheConfig{WarmUpPeriod: 60, WarmUpHitRatio: 0.8,
+		OnEvent: func(e Event) { events = append(events, e.Type) }}, nil)
+	warmUp := newWarmUp(node)
+
+	for x := 0; x < warmUpMinReads; x++ {
+		warmUp.record(x%2 == 0)
+	}
+	if warmUp.update(time.Now()) || !warmUp.active() {
+		t.Error("a hit ratio under the target ought not to end warming up")
+	}
+
+	for x := 0; x < warmUpMinReads; x++ {
+		warmUp.record(x%10 != 0)
+	}
+	if !warmUp.update(time.Now()) || warmUp.active() {
+		t.Error("a hit ratio over the target ought to end warming up")
+	}
+
+	stats := warmUp.stats()
+	if stats.Hits != 140 || stats.Misses != 60 || stats.HitRatio != 0.9 || stats.Remaining != 0 {
+		t.Errorf("unexpected warm up stats %+v", stats)
+	}
+	if len(events) != 1 || events[0] != EventWarmedUp {
+		t.Errorf("warming up ought to raise an event, got %v", events)
+	}
+
+	warmUp = newWarmUp(node)
+	if !warmUp.update(time.Now().Add(time.Minute)) {
+		t.Error("warming up ought to end once the period is over")
+	}
+}
+
+func TestWarmLoader(t *testing.T) {
+	node := New(&ClusteredBigCacheConfig{Join: false, LocalPort: 1947, ConnectRetries: 0,
+		WarmLoader: func(put func(key string, val []byte, ttl time.Duration)) error {
+			put("key_1", []byte("data_1"), time.Minute)
+			put("key_2", []byte("data_2"), 0)
+			return nil
+		}}, nil)
+	node.Start()
+	defer node.ShutDown()
+
+	if data, err := node.cache.Get("key_1"); err != nil || string(data) != "data_1" {
+		t.Errorf("expected the keys loaded before the node started, got %q %v", data, err)
+	}
+	if ttl, err := node.cache.TTL("key_1"); err != nil || ttl > time.Minute || ttl < time.Second*55 {
+		t.Errorf("expected the ttl of the key kept, got %s %v", ttl, err)
+	}
+
+	failure := errors.New("store unreachable")
+	if err := node.Warm(func(put func(string, []byte, time.Duration)) error { return failure }); err != failure {
+		t.Errorf("expected the error of the loader returned, got %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package cluster
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nggenius/ngbigcache/message"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//keyWatch is the subscription of a passive client. it is held by a single active node which, like every active
+//node, applies every write made in the cluster, so each invalidation is received once. when that node goes
+//away the subscription moves to another active node
+type keyWatch struct {
+	lock     sync.Mutex
+	prefixes []string
+	handler  func(key string, deleted bool)
+	via      string //id of the active node holding the subscription
+}
+
+//watchRegistry keeps the subscriptions passive clients hold with this node
+type watchRegistry struct {
+	lock     sync.RWMutex
+	watchers map[string]*watcher
+	count    int32
+}
+
+type watcher struct {
+	r        *remoteNode
+	prefixes []string
+}
+
+func newWatchRegistry() *watchRegistry {
+	return &watchRegistry{watchers: make(map[string]*watcher)}
+}
+
+//replace the subscription of a passive client, no prefix ends it
+func (w *watchRegistry) set(r *remoteNode, prefixes []string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if len(prefixes) == 0 {
+		delete(w.watchers, r.config.Id)
+	} else {
+		w.watchers[r.config.Id] = &watcher{r: r, prefixes: prefixes}
+	}
+	atomic.StoreInt32(&w.count, int32(len(w.watchers)))
+}
+
+func (w *watchRegistry) remove(id string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	delete(w.watchers, id)
+	atomic.StoreInt32(&w.count, int32(len(w.watchers)))
+}
+
+//tell the passive clients watching key that it was written or deleted
+func (w *watchRegistry) notify(key string, deleted bool) {
+	if atomic.LoadInt32(&w.count) == 0 {
+		return
+	}
+
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+
+	for _, watcher := range w.watchers {
+		for _, prefix := range watcher.prefixes {
+			if strings.HasPrefix(key, prefix) {
+				watcher.r.sendMessage(&message.InvalidateMessage{Key: key, Deleted: deleted})
+				break
+			}
+		}
+	}
+}
+
+//Watch asks to be told whenever a key starting with one of the prefixes is written or deleted anywhere in the
+//cluster, so a passive client caching e.g "session:" keys is not told about unrelated keys. an empty prefix
+//watches every key. calling it again replaces the prefixes, calling it without any stops watching. handler is
+//called from the goroutine handling messages from the cluster, it must not block. passive clients only
+func (node *ClusteredBigCache) Watch(prefixes []string, handler func(key string, deleted bool)) error {
+	if node.state != clusterStateStarted {
+		return ErrNotStarted
+	}
+	if node.mode != clusterModePASSIVE {
+		return ErrNotPassive
+	}
+
+	node.watch.lock.Lock()
+	node.watch.prefixes = prefixes
+	node.watch.handler = handler
+	node.watch.lock.Unlock()
+
+	node.subscribeWatch(true)
+	return nil
+}
+
+//send the subscription to the active node holding it, or to another active node when that one is gone.
+//unless changed is set nothing is sent while the node holding the subscription is still connected
+func (node *ClusteredBigCache) subscribeWatch(changed bool) {
+	w := node.watch
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if len(w.prefixes) == 0 && w.via == "" {
+		return
+	}
+
+	var holder *remoteNode
+	if v, ok := node.remoteNodes.Get(w.via); ok {
+		if !changed {
+			return
+		}
+		holder = v.(*remoteNode)
+	} else {
+		for _, v := range node.getRemoteNodes() {
+			if r := v.(*remoteNode); r.mode == clusterModeACTIVE && r.version() >= message.MsgMinVersion(message.MsgWATCH) {
+				holder = r
+				break
+			}
+		}
+	}
+
+	if holder == nil { //sent once an active node connects
+		w.via = ""
+		return
+	}
+	holder.sendMessage(&message.WatchMessage{Prefixes: w.prefixes})
+	w.via = holder.config.Id
+	if len(w.prefixes) == 0 {
+		w.via = ""
+	}
+	utils.Info(node.logger, fmt.Sprintf("watching %d key prefixes through '%s'", len(w.prefixes), holder.config.Id))
+}
+
+//a passive client subscribed to the invalidation of keys
+func (r *remoteNode) handleWatch(msg *message.NodeWireMessage) {
+	if r.parentNode.mode != clusterModeACTIVE {
+		return
+	}
+
+	watchMsg := message.WatchMessage{}
+	watchMsg.DeSerialize(msg)
+	r.parentNode.watchers.set(r, watchMsg.Prefixes)
+}
+
+//a key this passive client watches was written or deleted
+func (r *remoteNode) handleInvalidate(msg *message.NodeWireMessage) {
+	invalidateMsg := message.InvalidateMessage{}
+	invalidateMsg.DeSerialize(msg)
+
+	r.parentNode.watch.lock.Lock()
+	handler := r.parentNode.watch.handler
+	r.parentNode.watch.lock.Unlock()
+	if handler != nil {
+		handler(invalidateMsg.Key, invalidateMsg.Deleted)
+	}
+}
@@ -0,0 +1,135 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nggenius/ngbigcache/message"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//why a replicated put was not applied, besides the errors of the local cache
+var (
+	errReplicaThrottled = errors.New("replicated write throttled")
+	errReplicaExpired   = errors.New("replicated write expired before it arrived")
+	errNoAck            = errors.New("remote node does not acknowledge writes")
+)
+
+//what a remote node answered to a put asked to be acknowledged
+type putAck struct {
+	id  string //of the remote node
+	err error
+}
+
+//WriteResult tells how the replication of a write went
+type WriteResult struct {
+	Replicas int              //active remote nodes the write was sent to
+	Acked    int              //remote nodes that applied it
+	Failed   map[string]error //remote nodes that did not apply it or did not say so in time, by id, with why
+}
+
+//PutWithAck adds data into the cluster like Put, then waits up to timeout for the active remote nodes to
+//acknowledge it. the write is kept locally and replicated whatever the remote nodes answer, the result tells the
+//caller how many hold it so one needing durability can act on partial failures. remote nodes that did not answer
+//in time fail with ErrTimeout, those that went away before answering with ErrNodeDisconnected and those that
+//speak a protocol without acknowledgements are sent a plain put and fail with an error of their own
+func (node *ClusteredBigCache) PutWithAck(key string, data []byte, duration, timeout time.Duration) (*WriteResult, error) {
+	defer node.profiler.observe("put", node.clock.Now())
+
+	requestId, expiryTime, stamp, err := node.putLocally(key, data, duration, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &WriteResult{Failed: make(map[string]error)}
+	peers := node.remoteNodes.Values()
+	acks := make(chan putAck, len(peers))
+	waiting := make(map[string]bool)
+	for x := 0; x < len(peers); x++ {
+		peer := peers[x].(*remoteNode)
+		if peer.mode == clusterModePASSIVE {
+			continue
+		}
+		result.Replicas++
+		if peer.version() < message.MsgMinVersion(message.MsgPUTAckReq) {
+			node.queueReplication(&replicationMsg{r: peer, m: &message.PutMessage{Key: key, Data: data, Expiry: expiryTime}})
+			result.Failed[peer.config.Id] = errNoAck
+			continue
+		}
+		pendingKey := key + utils.GenerateNodeId(8)
+		if err := peer.askPutAck(&message.PutAckReqMessage{Key: key, Data: data, Expiry: expiryTime,
+			PendingKey: pendingKey, RequestId: requestId, Timestamp: stamp}, acks); err != nil {
+			result.Failed[peer.config.Id] = err
+			continue
+		}
+		waiting[peer.config.Id] = true
+		defer peer.pending.cancel(message.MsgPUTAckReq, pendingKey)
+	}
+	logRequest(node.logger, requestId, fmt.Sprintf("put '%s' replicating to %d remote nodes, waiting for %d to acknowledge it",
+		key, result.Replicas, len(waiting)))
+
+	timer := node.clock.NewTimer(timeout)
+	defer timer.Stop()
+	for len(waiting) > 0 {
+		select {
+		case ack := <-acks:
+			delete(waiting, ack.id)
+			if ack.err != nil {
+				result.Failed[ack.id] = ack.err
+			} else {
+				result.Acked++
+			}
+		case <-timer.C():
+			for id := range waiting {
+				result.Failed[id] = ErrTimeout
+			}
+			waiting = nil
+		}
+	}
+	logRequest(node.logger, requestId, fmt.Sprintf("put '%s' acknowledged by %d of %d remote nodes", key, result.Acked, result.Replicas))
+	return result, nil
+}
+
+//send the remote node a put to acknowledge, the acknowledgement is sent on acks
+func (r *remoteNode) askPutAck(msg *message.PutAckReqMessage, acks chan putAck) error {
+	if r.state == nodeStateDisconnected {
+		return ErrNodeDisconnected
+	}
+	if err := r.pending.add(message.MsgPUTAckReq, msg.PendingKey, acks, func() {
+		acks <- putAck{id: r.config.Id, err: ErrNodeDisconnected}
+	}); err != nil {
+		return err
+	}
+	r.sendMessage(msg)
+	return nil
+}
+
+func (r *remoteNode) handlePutAckRequest(msg *message.NodeWireMessage) {
+	reqMsg := message.PutAckReqMessage{}
+	reqMsg.DeSerialize(msg)
+
+	ack := &message.PutAckMessage{PendingKey: reqMsg.PendingKey}
+	putMsg := &message.PutMessage{Key: reqMsg.Key, Data: reqMsg.Data, Expiry: reqMsg.Expiry, RequestId: reqMsg.RequestId,
+		Timestamp: reqMsg.Timestamp}
+	if err := r.applyPut(msg.Code, putMsg); err != nil {
+		ack.Error = err.Error()
+	}
+	r.sendMessage(ack)
+}
+
+func (r *remoteNode) handlePutAck(msg *message.NodeWireMessage) {
+	ackMsg := message.PutAckMessage{}
+	ackMsg.DeSerialize(msg)
+	acks, ok := r.pending.take(message.MsgPUTAckReq, ackMsg.PendingKey)
+	if !ok { //the writer stopped waiting
+		return
+	}
+
+	ack := putAck{id: r.config.Id}
+	if ackMsg.Error != "" {
+		ack.err = remoteError(r.config.Id, ackMsg.Error)
+	}
+	//buffered for every remote node so this never blocks
+	acks.(chan putAck) <- ack
+}
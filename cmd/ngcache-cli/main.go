@@ -0,0 +1,300 @@
+//ngcache-cli inspects and changes the keys of a running cluster from the command line. it joins the cluster as a
+//passive client for the duration of a command, so it holds no keys and the cluster carries on unchanged once it
+//is done:
+//
+//	ngcache-cli -join host:port[,host:port] [flags] command [arguments]
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+	"github.com/nggenius/ngbigcache/cluster"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+const usage = `usage: ngcache-cli -join host:port[,host:port] [flags] command [arguments]
+
+commands:
+  get <key>               print the value of the key
+  put <key> <value>       set the key, expiring after -ttl
+  del <key>               delete the key
+  ttl <key>               print the time left before the key expires
+  stats                   print the statistics of every node as json
+  nodes                   list the nodes connected to the cluster
+  scan [prefix]           list the keys with the prefix, the nodes must be started with IndexKeys
+  clear [prefix]          delete the keys with the prefix, every key without one. needs -yes
+  snapshot <dir>          write a snapshot of every active node to dir on its own filesystem
+
+flags:
+`
+
+//the command line did not name a known command or gave it the wrong arguments
+var errUsage = errors.New("unknown command or wrong arguments, see -h")
+
+//ping settings of the passive client, a command is short lived so only a dead connection matters
+const (
+	pingInterval          = 5
+	pingTimeout           = 3
+	pingFailureThreshHold = 10
+)
+
+//cli runs a command through the passive client it joined the cluster with
+type cli struct {
+	node     *cluster.ClusteredBigCache
+	out      io.Writer
+	timeout  time.Duration
+	duration time.Duration //of the keys set by put
+	limit    int
+	yes      bool
+}
+
+//command is what a command line names, with the number of arguments it takes
+type command struct {
+	min, max int
+	run      func(c *cli, args []string) error
+}
+
+var commands = map[string]command{
+	"get":      {1, 1, (*cli).get},
+	"put":      {2, 2, (*cli).put},
+	"del":      {1, 1, (*cli).del},
+	"ttl":      {1, 1, (*cli).ttl},
+	"stats":    {0, 0, (*cli).stats},
+	"nodes":    {0, 0, (*cli).nodes},
+	"scan":     {0, 1, (*cli).scan},
+	"clear":    {0, 1, (*cli).clear},
+	"snapshot": {1, 1, (*cli).snapshot},
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintln(os.Stderr, "ngcache-cli:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+//parse the command line, join the cluster and run the command it names
+func run(args []string, out, errOut io.Writer) error {
+	flags := flag.NewFlagSet("ngcache-cli", flag.ContinueOnError)
+	flags.SetOutput(errOut)
+	flags.Usage = func() {
+		fmt.Fprint(errOut, usage)
+		flags.PrintDefaults()
+	}
+	join := flags.String("join", "localhost:9911", "comma separated addresses of nodes of the cluster")
+	id := flags.String("id", "", "id the passive client joins as, a random one if not set")
+	port := flags.Int("port", 0, "port the passive client listens on, 0 picks a free one")
+	c := &cli{out: out}
+	flags.DurationVar(&c.timeout, "timeout", time.Second*5, "time a command waits for the cluster")
+	flags.DurationVar(&c.duration, "ttl", 0, "time a key set by put expires after, 0 for never")
+	flags.IntVar(&c.limit, "limit", 100, "keys listed at once by scan and clear")
+	flags.BoolVar(&c.yes, "yes", false, "go ahead with clear")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok || flags.NArg()-1 < cmd.min || flags.NArg()-1 > cmd.max {
+		flags.Usage()
+		return errUsage
+	}
+	if *id == "" {
+		*id = "ngcache-cli-" + utils.GenerateNodeId(8)
+	}
+
+	c.node = cluster.NewPassiveClient(*id, *join, *port, pingInterval, pingTimeout, pingFailureThreshHold, nil)
+	if err := c.node.Start(); err != nil {
+		return err
+	}
+	defer c.node.ShutDown()
+	if err := c.connect(); err != nil {
+		return fmt.Errorf("no active node reached at %s [%s]", *join, err)
+	}
+	return cmd.run(c, flags.Args()[1:])
+}
+
+//wait up to the timeout for the passive client to be connected to an active node
+func (c *cli) connect() error {
+	deadline := time.Now().Add(c.timeout)
+	for {
+		err := c.node.Ready()
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+}
+
+//wait up to the timeout for the writes made to be sent to the remote nodes, so shutting down does not drop them
+func (c *cli) flush() error {
+	deadline := time.Now().Add(c.timeout)
+	for {
+		stats := c.node.StatisticsStruct()
+		pending := stats.ReplicationQueue
+		for _, peer := range stats.Peers {
+			pending += peer.OutboundQueue
+		}
+		if pending == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d writes not sent to the cluster", pending)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func (c *cli) get(args []string) error {
+	data, err := c.node.Get(args[0], c.timeout)
+	if err != nil {
+		return err
+	}
+	c.out.Write(append(data, '\n'))
+	return nil
+}
+
+func (c *cli) put(args []string) error {
+	result, err := c.node.PutWithAck(args[0], []byte(args[1]), c.duration, c.timeout)
+	if err != nil {
+		return err
+	}
+	if len(result.Failed) == 0 {
+		return nil
+	}
+
+	failed := make([]string, 0, len(result.Failed))
+	for id, err := range result.Failed {
+		failed = append(failed, fmt.Sprintf("%s: %s", id, err))
+	}
+	sort.Strings(failed)
+	return fmt.Errorf("stored on %d of %d nodes, %s", result.Acked, result.Replicas, strings.Join(failed, ", "))
+}
+
+func (c *cli) del(args []string) error {
+	if err := c.node.Delete(args[0]); err != nil {
+		return err
+	}
+	return c.flush()
+}
+
+func (c *cli) ttl(args []string) error {
+	ttl, err := c.node.TTL(args[0], c.timeout)
+	if err != nil {
+		return err
+	}
+	if ttl == time.Duration(bigcache.NO_EXPIRY) {
+		fmt.Fprintln(c.out, "no expiry")
+		return nil
+	}
+	fmt.Fprintln(c.out, ttl)
+	return nil
+}
+
+func (c *cli) stats(args []string) error {
+	report, err := c.node.ClusterStatistics(c.timeout)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(c.out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+func (c *cli) nodes(args []string) error {
+	report, err := c.node.ClusterStatistics(c.timeout)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tMODE\tENTRIES\tPEERS")
+	for _, n := range report.Nodes[1:] { //the first is this client
+		switch {
+		case n.Statistics == nil:
+			fmt.Fprintf(w, "%s\tunknown\t-\t%s\n", n.Id, n.Error)
+		case n.Statistics.Passive:
+			fmt.Fprintf(w, "%s\tpassive\t-\t%d\n", n.Id, len(n.Statistics.Peers))
+		default:
+			fmt.Fprintf(w, "%s\tactive\t%d\t%d\n", n.Id, n.Statistics.Cache.Entries, len(n.Statistics.Peers))
+		}
+	}
+	return w.Flush()
+}
+
+//call fn with every page of the keys with the prefix given, if any
+func (c *cli) pages(args []string, fn func(keys []string) error) error {
+	prefix := ""
+	if len(args) > 0 {
+		prefix = args[0]
+	}
+
+	cursor := ""
+	for {
+		page, err := c.node.KeysWithPrefix(prefix, cursor, c.limit, c.timeout)
+		if err != nil {
+			return err
+		}
+		if err := fn(page.Keys); err != nil {
+			return err
+		}
+		if page.Next == "" {
+			return nil
+		}
+		cursor = page.Next
+	}
+}
+
+func (c *cli) scan(args []string) error {
+	return c.pages(args, func(keys []string) error {
+		for _, key := range keys {
+			fmt.Fprintln(c.out, key)
+		}
+		return nil
+	})
+}
+
+func (c *cli) clear(args []string) error {
+	if !c.yes {
+		return errors.New("clear deletes every key listed by scan, pass -yes to go ahead")
+	}
+
+	deleted := 0
+	err := c.pages(args, func(keys []string) error {
+		for _, key := range keys {
+			if err := c.node.Delete(key); err != nil {
+				return err
+			}
+			deleted++
+		}
+		return nil
+	})
+	if err == nil {
+		err = c.flush()
+	}
+	fmt.Fprintf(c.out, "deleted %d keys\n", deleted)
+	return err
+}
+
+func (c *cli) snapshot(args []string) error {
+	snapshots, err := c.node.SnapshotCluster(args[0])
+	for _, s := range snapshots {
+		if s.Err != nil {
+			fmt.Fprintf(c.out, "%s: %s\n", s.Id, s.Err)
+			continue
+		}
+		fmt.Fprintf(c.out, "%s: %d entries written to %s\n", s.Id, s.Entries, s.File)
+	}
+	return err
+}
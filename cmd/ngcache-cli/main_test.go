@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nggenius/ngbigcache/cluster"
+)
+
+func TestCommands(t *testing.T) {
+	node := cluster.New(&cluster.ClusteredBigCacheConfig{Id: "node_1", LocalPort: 7231, ConnectRetries: 2, IndexKeys: true}, nil)
+	if err := node.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer node.ShutDown()
+
+	cmd := func(args ...string) (string, error) {
+		var out bytes.Buffer
+		err := run(append([]string{"-join", "localhost:7231", "-timeout", "2s"}, args...), &out, ioutil.Discard)
+		return out.String(), err
+	}
+
+	if _, err := cmd("-ttl", "1m", "put", "user:1", "one"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cmd("put", "user:2", "two"); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := cmd("get", "user:1"); err != nil || out != "one\n" {
+		t.Errorf("expected the value put, got '%s' %v", out, err)
+	}
+	if out, err := cmd("ttl", "user:1"); err != nil || !strings.HasSuffix(out, "s\n") {
+		t.Errorf("expected the time left of the key, got '%s' %v", out, err)
+	}
+	if out, err := cmd("ttl", "user:2"); err != nil || out != "no expiry\n" {
+		t.Errorf("expected a key put without ttl not to expire, got '%s' %v", out, err)
+	}
+	if out, err := cmd("-limit", "1", "scan", "user:"); err != nil || out != "user:1\nuser:2\n" {
+		t.Errorf("expected both keys listed a page at a time, got '%s' %v", out, err)
+	}
+	if out, err := cmd("nodes"); err != nil || !strings.Contains(out, "node_1") || !strings.Contains(out, "active") {
+		t.Errorf("expected the node listed, got '%s' %v", out, err)
+	}
+	out, err := cmd("stats")
+	report := cluster.ClusterReport{}
+	if err != nil || json.Unmarshal([]byte(out), &report) != nil || report.Active != 1 || report.Cache.Entries != 2 {
+		t.Errorf("expected the statistics of the node as json, got '%s' %v", out, err)
+	}
+
+	if _, err := cmd("del", "user:1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cmd("clear", "user:"); err == nil {
+		t.Error("expected clear refused without -yes")
+	}
+	if out, err := cmd("-yes", "clear", "user:"); err != nil || out != "deleted 1 keys\n" {
+		t.Errorf("expected the key left deleted, got '%s' %v", out, err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if _, err := node.Get("user:2", time.Millisecond*100); err == nil {
+		t.Error("expected the keys deleted from the node")
+	}
+
+	dir, _ := ioutil.TempDir("", "ngcache-cli")
+	defer os.RemoveAll(dir)
+	if out, err := cmd("snapshot", dir); err != nil || !strings.HasPrefix(out, "node_1: 0 entries written to ") {
+		t.Errorf("expected a snapshot of the node, got '%s' %v", out, err)
+	}
+
+	if _, err := cmd("unknown"); err != errUsage {
+		t.Errorf("expected an unknown command refused, got %v", err)
+	}
+	if _, err := cmd("get"); err != errUsage {
+		t.Errorf("expected a command without its arguments refused, got %v", err)
+	}
+}
@@ -7,56 +7,142 @@ import (
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var (
 	errConnectionUnusable = errors.New("connection is not usable")
 	errTimeout            = errors.New("i/o timeout")
+
+	//ErrReadTimeout is returned by reads which got nothing from the remote peer within their timeout or deadline
+	ErrReadTimeout = errors.New("read timed out")
+	//ErrWriteTimeout is returned by writes the remote peer did not take within the write deadline. the connection
+	//is no longer usable as part of the data might have been written
+	ErrWriteTimeout = errors.New("write timed out")
 )
 
+//UnixScheme is the prefix of endpoints that are unix domain sockets, e.g unix:///var/run/cache.sock
+const UnixScheme = "unix://"
+
+//SplitEndpoint returns the network and address to dial for an endpoint. endpoints starting with
+//unix:// are unix domain sockets, everything else is a tcp host:port
+func SplitEndpoint(endpoint string) (string, string) {
+	if strings.HasPrefix(endpoint, UnixScheme) {
+		return "unix", strings.TrimPrefix(endpoint, UnixScheme)
+	}
+	return "tcp", endpoint
+}
+
+//IsUnixEndpoint checks if the endpoint is a unix domain socket
+func IsUnixEndpoint(endpoint string) bool {
+	return strings.HasPrefix(endpoint, UnixScheme)
+}
+
 //Connection defines a connection to a remote peer
 type Connection struct {
-	Remote      string
-	Uid         string
-	conn        *net.TCPConn
-	buffReader  *bufio.Reader
-	readTimeout time.Duration
-	Usable      bool
-	writeLock   sync.Mutex
+	Remote        string
+	Uid           string
+	conn          net.Conn
+	buffReader    *bufio.Reader
+	readTimeout   time.Duration
+	readDeadline  time.Duration //longest wait for data by reads without a timeout of their own, 0 for no limit
+	writeDeadline time.Duration //longest a write may take, 0 for no limit
+	Usable        bool
+	writeLock     sync.Mutex
+	interceptor   atomic.Value //Interceptor of the frames sent, for tests injecting faults
 }
 
-//NewConnection Create a new tcp connection and connects to the remote entity
+//NewConnection Create a new tcp, unix domain socket or websocket connection and connects to the remote entity
 func NewConnection(endpoint string, connectionTimeout time.Duration) (*Connection, error) {
+	return NewResolvedConnection(endpoint, connectionTimeout, nil)
+}
 
-	c := &Connection{}
-	conn, err := net.DialTimeout("tcp", endpoint, connectionTimeout)
+//NewResolvedConnection is NewConnection with the host of a tcp endpoint looked up through dns, every address it
+//resolves to is tried in turn. the addresses are forgotten when none can be connected to, so the next attempt
+//looks the host up again. a nil dns leaves the lookup to the dialer
+func NewResolvedConnection(endpoint string, connectionTimeout time.Duration, dns *DNSCache) (*Connection, error) {
+
+	var conn net.Conn
+	var err error
+	if IsWebSocketEndpoint(endpoint) {
+		conn, err = DialWebSocket(endpoint, connectionTimeout)
+	} else {
+		network, address := SplitEndpoint(endpoint)
+		conn, err = dial(network, address, connectionTimeout, dns)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newConnection(conn, endpoint), nil
+}
+
+//NewTransportConnection is NewConnection dialing tcp and unix domain socket endpoints through transport rather
+//than the network stack. websocket endpoints are always dialed through the network stack
+func NewTransportConnection(endpoint string, connectionTimeout time.Duration, transport Transport) (*Connection, error) {
+	if IsWebSocketEndpoint(endpoint) {
+		return NewConnection(endpoint, connectionTimeout)
+	}
+
+	network, address := SplitEndpoint(endpoint)
+	conn, err := transport.Dial(network, address, connectionTimeout)
 	if err != nil {
 		return nil, err
 	}
+	return newConnection(conn, endpoint), nil
+}
 
-	c.conn = conn.(*net.TCPConn)
+//wrap a conn dialed to endpoint
+func newConnection(conn net.Conn, endpoint string) *Connection {
+
+	c := &Connection{}
+	c.conn = conn
 
 	c.Uid = c.conn.LocalAddr().String()
 	c.Remote = endpoint
-	c.conn.SetKeepAlive(true)
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetKeepAlive(true)
+	}
 	//c.conn.SetReadBuffer(1024 * 1024)
 	//c.conn.SetWriteBuffer(1024 * 1024)
 	c.buffReader = bufio.NewReader(c)
 	c.Usable = true
 	c.writeLock = sync.Mutex{}
 
-	return c, nil
+	return c
 }
 
-//WrapConnection wraps a tcp conn pointer into this struct
-func WrapConnection(conn *net.TCPConn) *Connection {
+//dial the address, through the addresses its host resolves to when dns is given
+func dial(network, address string, connectionTimeout time.Duration, dns *DNSCache) (net.Conn, error) {
+	if dns == nil || network != "tcp" {
+		return net.DialTimeout(network, address, connectionTimeout)
+	}
+
+	addrs, err := dns.Resolve(address)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		var conn net.Conn
+		if conn, err = net.DialTimeout(network, addr, connectionTimeout); err == nil {
+			return conn, nil
+		}
+	}
+	dns.Forget(address) //the host might have moved
+	return nil, err
+}
+
+//WrapConnection wraps a tcp, unix domain socket or websocket conn into this struct
+func WrapConnection(conn net.Conn) *Connection {
 
 	c := &Connection{}
 	c.conn = conn
 	c.Uid = c.conn.LocalAddr().String()
 	c.Remote = conn.RemoteAddr().String()
-	c.conn.SetKeepAlive(true)
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetKeepAlive(true)
+	}
 	//c.conn.SetReadBuffer(1024 * 1024)
 	//c.conn.SetWriteBuffer(1024 * 1024)
 	c.buffReader = bufio.NewReader(c)
@@ -71,6 +157,13 @@ func (c *Connection) SetReadTimeout(timeout time.Duration) {
 	c.readTimeout = timeout
 }
 
+//SetDeadlines sets how long reads without a timeout of their own wait for data from the remote peer and how long
+//a write may take, so a hung peer is found out without waiting for pings to fail. 0 waits as long as it takes
+func (c *Connection) SetDeadlines(read, write time.Duration) {
+	c.readDeadline = read
+	c.writeDeadline = write
+}
+
 func (c *Connection) Read(p []byte) (int, error) {
 	if !c.Usable {
 		return 0, errConnectionUnusable
@@ -85,8 +178,8 @@ func (c *Connection) Read(p []byte) (int, error) {
 		if err == io.EOF {
 			c.Usable = false
 		} else {
-			if strings.Contains(err.Error(), "timeout") {
-				err = errors.New("i/o timeout")
+			if isTimeout(err) {
+				err = ErrReadTimeout
 			}
 		}
 	}
@@ -101,16 +194,14 @@ func (c *Connection) Write(data []byte) (int, error) {
 
 	c.writeLock.Lock()
 	defer c.writeLock.Unlock()
+	defer c.applyWriteDeadline()()
 
 	count := 0
 	size := len(data)
 	for count < size {
 		n, err := c.conn.Write(data[count:])
 		if err != nil {
-			if err == io.EOF {
-				c.Usable = false
-			}
-			return count, err
+			return count, c.writeFailed(err)
 		}
 
 		count += n
@@ -126,55 +217,112 @@ func (c *Connection) SendData(data []byte) error {
 		return errConnectionUnusable
 	}
 
+	data, times := c.intercept(data)
 	c.writeLock.Lock()
 	defer c.writeLock.Unlock()
-
-	count := 0
-	size := len(data)
-	for count < size {
-		n, err := c.conn.Write(data[count:])
-		if err != nil {
-			if err == io.EOF {
-				c.Usable = false
+	defer c.applyWriteDeadline()()
+
+	for ; times > 0; times-- {
+		count := 0
+		size := len(data)
+		for count < size {
+			n, err := c.conn.Write(data[count:])
+			if err != nil {
+				return c.writeFailed(err)
 			}
-			return err
-		}
 
-		count += n
+			count += n
+		}
 	}
 
 	return nil
 }
 
-//ReadData reads size byte of data and return is to the caller
+//set the write deadline for a write about to start, the returned func clears it once done. called with the
+//write lock held
+func (c *Connection) applyWriteDeadline() func() {
+	if c.writeDeadline == 0 {
+		return func() {}
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(c.writeDeadline))
+	return func() { c.conn.SetWriteDeadline(time.Time{}) }
+}
+
+//the error of a failed write, the connection is no longer usable when it ended or timed out half way
+func (c *Connection) writeFailed(err error) error {
+	if err == io.EOF {
+		c.Usable = false
+	} else if isTimeout(err) {
+		c.Usable = false
+		err = ErrWriteTimeout
+	}
+	return err
+}
+
+//whether err is a network timeout
+func isTimeout(err error) bool {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+	return strings.Contains(err.Error(), "timeout")
+}
+
+//ReadData reads size byte of data and return is to the caller. with a timeout the whole read must be done within
+//it, it fails with ErrReadTimeout otherwise
 func (c *Connection) ReadData(size uint, timeout time.Duration) ([]byte, error) {
 
 	ret := make([]byte, size)
-	var err error
 
 	tmp := c.readTimeout
-	c.SetReadTimeout(timeout)
+	c.SetReadTimeout(0)
 	defer c.SetReadTimeout(tmp)
 
 	if 0 != timeout {
-		done := make(chan bool)
-		defer close(done)
-
-		go func() {
-			_, err = io.ReadFull(c.buffReader, ret)
-			done <- true
-		}()
-
-		select {
-		case <-done:
-			return ret, err
-		case <-time.After(timeout):
-			return nil, errTimeout
-		}
+		//a deadline on the conn rather than a goroutine racing a timer, nothing is left reading once this returns
+		c.conn.SetReadDeadline(time.Now().Add(timeout))
+		defer c.conn.SetReadDeadline(time.Time{}) //do not leave the deadline behind for reads without a timeout
 	} else {
-		_, err = io.ReadFull(c.buffReader, ret)
-		return ret, err
+		defer c.applyReadDeadline()()
+	}
+
+	if _, err := io.ReadFull(c.buffReader, ret); err != nil {
+		return nil, err
 	}
+	return ret, nil
+}
+
+//ReadInto fills buf with the next bytes read, like ReadData without a timeout but sparing the buffer it allocates
+func (c *Connection) ReadInto(buf []byte) error {
+	tmp := c.readTimeout
+	c.SetReadTimeout(0)
+	defer c.SetReadTimeout(tmp)
+	defer c.applyReadDeadline()()
+
+	_, err := io.ReadFull(c.buffReader, buf)
+	return err
+}
+
+//read with the read deadline until the returned func is called, which clears the deadline off the connection
+func (c *Connection) applyReadDeadline() func() {
+	if c.readDeadline == 0 {
+		return func() {}
+	}
+	c.SetReadTimeout(c.readDeadline)
+	return func() { c.conn.SetReadDeadline(time.Time{}) }
+}
+
+//CloseWrite stops sending on the connection while still reading from it, so the remote end reads everything sent
+//then sees the connection closed. false when the connection can not be half closed, e.g a websocket or a pipe of
+//the memory transport, Close has to be used then
+func (c *Connection) CloseWrite() bool {
+	half, ok := c.conn.(interface{ CloseWrite() error })
+	if !ok {
+		return false
+	}
+
+	c.writeLock.Lock() //after the write in progress
+	defer c.writeLock.Unlock()
+	return half.CloseWrite() == nil
 }
 
 //Close calls shutdown on this struct
@@ -0,0 +1,44 @@
+package comms
+
+import "time"
+
+//Interceptor is called with every frame about to be sent through SendData on a connection it is set on, and
+//decides what happens to it. it is meant for tests injecting faults, and must not modify frame
+type Interceptor func(c *Connection, frame []byte) Fault
+
+//Fault is what an Interceptor does to a frame, the zero Fault sends it as it is
+type Fault struct {
+	Drop      bool          //the frame is not sent, as if lost on the way
+	Delay     time.Duration //the frame is sent once this has passed, frames sent meanwhile may overtake it
+	Duplicate bool          //the frame is sent twice in a row
+	Corrupt   bool          //the bits of the last byte of the frame are flipped, so it keeps its length but not its content
+}
+
+//SetInterceptor sets the Interceptor of the frames sent on the connection, nil sends them as they are
+func (c *Connection) SetInterceptor(intercept Interceptor) {
+	c.interceptor.Store(intercept)
+}
+
+//apply the Interceptor of the connection to a frame, returning the frame to send and how many times to send it
+func (c *Connection) intercept(data []byte) ([]byte, int) {
+	intercept, _ := c.interceptor.Load().(Interceptor)
+	if intercept == nil {
+		return data, 1
+	}
+
+	fault := intercept(c, data)
+	if fault.Drop {
+		return data, 0
+	}
+	if fault.Delay > 0 {
+		time.Sleep(fault.Delay)
+	}
+	if fault.Corrupt && len(data) > 0 {
+		data = append([]byte(nil), data...)
+		data[len(data)-1] ^= 0xff
+	}
+	if fault.Duplicate {
+		return data, 2
+	}
+	return data, 1
+}
@@ -0,0 +1,158 @@
+package comms
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errNoAddresses = errors.New("host resolved to no address")
+
+//HostResolver looks up the ip addresses of a host along with how long they can be relied upon
+type HostResolver func(host string) ([]string, time.Duration, error)
+
+//SystemResolver looks hosts up with the resolver of the system. it cannot tell the ttl of the records so the
+//addresses found are relied upon for ttl
+func SystemResolver(ttl time.Duration) HostResolver {
+	return func(host string) ([]string, time.Duration, error) {
+		addrs, err := net.LookupHost(host)
+		return addrs, ttl, err
+	}
+}
+
+//ResolverStats counts the lookups of a DNSCache
+type ResolverStats struct {
+	Lookups  uint64 `json:"lookups"`  //hosts looked up because their addresses expired or could not be connected to
+	Failures uint64 `json:"failures"` //lookups that failed, the former addresses are used if there are any
+	Changes  uint64 `json:"changes"`  //lookups that found other addresses than the host had before
+}
+
+//the addresses of a host and until when they are relied upon
+type resolvedHost struct {
+	addrs  []string
+	expiry time.Time
+}
+
+//DNSCache keeps the addresses hosts resolve to until their ttl runs out or connecting to them fails, so
+//reconnecting to a peer addressed by hostname follows it to its new address rather than the first one found
+type DNSCache struct {
+	resolve  HostResolver
+	now      func() time.Time //when the addresses found expire is read from it
+	onChange func(host string, from, to []string)
+	lock     sync.Mutex
+	hosts    map[string]*resolvedHost
+	stats    ResolverStats
+}
+
+//NewDNSCache creates a cache of the addresses found by resolve, which expire by the time now tells, time.Now when
+//nil. onChange, when not nil, is called with the former and the new addresses of a host whenever a lookup finds it moved
+func NewDNSCache(resolve HostResolver, now func() time.Time, onChange func(host string, from, to []string)) *DNSCache {
+	if now == nil {
+		now = time.Now
+	}
+	return &DNSCache{
+		resolve:  resolve,
+		now:      now,
+		onChange: onChange,
+		hosts:    make(map[string]*resolvedHost),
+	}
+}
+
+//Resolve returns the ip:port addresses to dial for a host:port endpoint. endpoints with an ip address, or that
+//are not host:port, are returned as they are
+func (d *DNSCache) Resolve(endpoint string) ([]string, error) {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil || net.ParseIP(host) != nil {
+		return []string{endpoint}, nil
+	}
+
+	addrs, err := d.lookup(host)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		resolved = append(resolved, net.JoinHostPort(addr, port))
+	}
+	return resolved, nil
+}
+
+//Forget expires the addresses of the host of endpoint, so it is looked up again the next time it is resolved
+func (d *DNSCache) Forget(endpoint string) {
+	host, _, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return
+	}
+
+	d.lock.Lock()
+	if resolved, ok := d.hosts[host]; ok {
+		resolved.expiry = time.Time{}
+	}
+	d.lock.Unlock()
+}
+
+//Stats returns the counts of the lookups made so far
+func (d *DNSCache) Stats() ResolverStats {
+	return ResolverStats{
+		Lookups:  atomic.LoadUint64(&d.stats.Lookups),
+		Failures: atomic.LoadUint64(&d.stats.Failures),
+		Changes:  atomic.LoadUint64(&d.stats.Changes),
+	}
+}
+
+//the addresses of host, looked up again once they expired. the lookup is made without the lock held so a slow
+//resolver does not hold up the other hosts
+func (d *DNSCache) lookup(host string) ([]string, error) {
+	d.lock.Lock()
+	previous := d.hosts[host]
+	if previous != nil && d.now().Before(previous.expiry) {
+		d.lock.Unlock()
+		return previous.addrs, nil
+	}
+	d.lock.Unlock()
+
+	atomic.AddUint64(&d.stats.Lookups, 1)
+	addrs, ttl, err := d.resolve(host)
+	if err == nil && len(addrs) == 0 {
+		err = errNoAddresses
+	}
+	if err != nil {
+		atomic.AddUint64(&d.stats.Failures, 1)
+		if previous != nil { //better the former addresses than none while the resolver is unavailable
+			return previous.addrs, nil
+		}
+		return nil, err
+	}
+
+	addrs = append([]string(nil), addrs...)
+	sort.Strings(addrs)
+
+	d.lock.Lock()
+	d.hosts[host] = &resolvedHost{addrs: addrs, expiry: d.now().Add(ttl)}
+	d.lock.Unlock()
+
+	if previous != nil && !sameAddresses(previous.addrs, addrs) {
+		atomic.AddUint64(&d.stats.Changes, 1)
+		if d.onChange != nil {
+			d.onChange(host, previous.addrs, addrs)
+		}
+	}
+	return addrs, nil
+}
+
+//whether two sorted lists of addresses are the same
+func sameAddresses(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for x := range a {
+		if a[x] != b[x] {
+			return false
+		}
+	}
+	return true
+}
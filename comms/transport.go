@@ -0,0 +1,192 @@
+package comms
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	errAddressInUse      = errors.New("address already in use")
+	errConnectionRefused = errors.New("connection refused")
+	errListenerClosed    = errors.New("use of closed listener")
+)
+
+//first port handed out by a MemoryTransport to listeners of port 0 and to the dialing end of connections
+const memoryEphemeralPort = 49152
+
+//Transport is how nodes listen for connections and dial one another. nodes use the network stack unless given
+//another Transport, such as a MemoryTransport connecting the nodes of a process without sockets
+type Transport interface {
+	Listen(network, address string) (net.Listener, error)
+	Dial(network, address string, timeout time.Duration) (net.Conn, error)
+}
+
+//NetTransport is the Transport of the network stack
+type NetTransport struct{}
+
+//Listen listens on the address of the network, tcp or unix
+func (NetTransport) Listen(network, address string) (net.Listener, error) {
+	return net.Listen(network, address)
+}
+
+//Dial connects to the address of the network, tcp or unix
+func (NetTransport) Dial(network, address string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout(network, address, timeout)
+}
+
+//MemoryTransport connects the nodes of a process through in-memory pipes rather than sockets, so a cluster can be
+//tested without taking ports. tcp listeners are told apart by their port alone, any host dialed reaches them, and
+//unix listeners by their path. nodes connecting to one another must share the same MemoryTransport
+type MemoryTransport struct {
+	lock      sync.Mutex
+	listeners map[string]*memoryListener //by port, or path of unix listeners
+	next      int                        //next ephemeral port
+}
+
+//NewMemoryTransport creates a MemoryTransport without listeners
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{listeners: make(map[string]*memoryListener), next: memoryEphemeralPort}
+}
+
+//Listen registers a listener on the address, the port is picked when it is 0
+func (t *MemoryTransport) Listen(network, address string) (net.Listener, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	key, port, err := memoryKey(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if network != "unix" && port == 0 {
+		port = t.ephemeral()
+		key = strconv.Itoa(port)
+	}
+	if _, found := t.listeners[key]; found {
+		return nil, &net.OpError{Op: "listen", Net: network, Err: errAddressInUse}
+	}
+
+	l := &memoryListener{transport: t, key: key, addr: memoryAddr(network, address, port),
+		accept: make(chan net.Conn), done: make(chan struct{})}
+	t.listeners[key] = l
+	return l, nil
+}
+
+//Dial connects to the listener on the address, refused when there is none
+func (t *MemoryTransport) Dial(network, address string, timeout time.Duration) (net.Conn, error) {
+	key, _, err := memoryKey(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	t.lock.Lock()
+	l := t.listeners[key]
+	local := memoryAddr(network, "", t.ephemeral())
+	t.lock.Unlock()
+	if l == nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: errConnectionRefused}
+	}
+
+	client, server := net.Pipe()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case l.accept <- &memoryConn{Conn: server, local: l.addr, remote: local}:
+		return &memoryConn{Conn: client, local: local, remote: l.addr}, nil
+	case <-l.done:
+		err = errConnectionRefused
+	case <-timer.C:
+		err = errTimeout
+	}
+	client.Close()
+	server.Close()
+	return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+}
+
+//a port not taken by a listener, called with the lock held
+func (t *MemoryTransport) ephemeral() int {
+	for {
+		port := t.next
+		if t.next++; t.next > 65535 {
+			t.next = memoryEphemeralPort
+		}
+		if _, found := t.listeners[strconv.Itoa(port)]; !found {
+			return port
+		}
+	}
+}
+
+//the key listeners of address are found by and its port, 0 for unix addresses
+func memoryKey(network, address string) (string, int, error) {
+	if network == "unix" {
+		return address, 0, nil
+	}
+	_, p, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil {
+		return "", 0, &net.AddrError{Err: "invalid port", Addr: address}
+	}
+	return strconv.Itoa(port), port, nil
+}
+
+//the address of a listener or an end of a connection, the same types the network stack has so callers can tell
+//tcp and unix addresses apart as usual
+func memoryAddr(network, address string, port int) net.Addr {
+	if network == "unix" {
+		return &net.UnixAddr{Name: address, Net: "unix"}
+	}
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+}
+
+//memoryListener hands the server ends of the pipes dialed to it out on Accept
+type memoryListener struct {
+	transport *MemoryTransport
+	key       string
+	addr      net.Addr
+	accept    chan net.Conn
+	done      chan struct{} //closed once the listener is closed
+	closeOnce sync.Once
+}
+
+func (l *memoryListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.accept:
+		return conn, nil
+	case <-l.done:
+		return nil, &net.OpError{Op: "accept", Net: l.addr.Network(), Addr: l.addr, Err: errListenerClosed}
+	}
+}
+
+func (l *memoryListener) Close() error {
+	l.closeOnce.Do(func() {
+		l.transport.lock.Lock()
+		delete(l.transport.listeners, l.key)
+		l.transport.lock.Unlock()
+		close(l.done)
+	})
+	return nil
+}
+
+func (l *memoryListener) Addr() net.Addr {
+	return l.addr
+}
+
+//memoryConn is an end of a pipe with the addresses of the listener and of the dialing end
+type memoryConn struct {
+	net.Conn
+	local  net.Addr
+	remote net.Addr
+}
+
+func (c *memoryConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *memoryConn) RemoteAddr() net.Addr {
+	return c.remote
+}
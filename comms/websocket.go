@@ -0,0 +1,335 @@
+package comms
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//WebSocketScheme is the prefix of endpoints reached through a websocket, e.g ws://cache.example.com/ngbigcache
+	WebSocketScheme = "ws://"
+	//SecureWebSocketScheme is the prefix of endpoints reached through a websocket over tls
+	SecureWebSocketScheme = "wss://"
+
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+
+	wsMaxControlPayload = 125
+)
+
+var (
+	errBadHandshake    = errors.New("websocket handshake failed")
+	errBadControlFrame = errors.New("websocket control frame is too large or fragmented")
+)
+
+//IsWebSocketEndpoint checks if the endpoint is reached through a websocket
+func IsWebSocketEndpoint(endpoint string) bool {
+	return strings.HasPrefix(endpoint, WebSocketScheme) || strings.HasPrefix(endpoint, SecureWebSocketScheme)
+}
+
+//IsTCPEndpoint checks if the endpoint is a plain tcp host:port that other nodes can dial
+func IsTCPEndpoint(endpoint string) bool {
+	return !strings.Contains(endpoint, "://")
+}
+
+//wsConn frames everything written to it as binary websocket messages and reads back the payload of the
+//data frames it receives. it is a net.Conn so it can be used by Connection like any other conn
+type wsConn struct {
+	net.Conn
+	reader    *bufio.Reader
+	client    bool //clients mask the frames they send
+	writeLock sync.Mutex
+	remaining uint64 //payload bytes of the current data frame still to be read
+	masked    bool
+	mask      [4]byte
+	maskPos   int
+}
+
+//DialWebSocket connects to a ws:// or wss:// endpoint and performs the websocket handshake
+func DialWebSocket(endpoint string, connectionTimeout time.Duration) (net.Conn, error) {
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "wss" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: connectionTimeout}
+	var conn net.Conn
+	if u.Scheme == "wss" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: u.Hostname()})
+	} else {
+		conn, err = dialer.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetKeepAlive(true)
+	}
+
+	conn.SetDeadline(time.Now().Add(connectionTimeout))
+	ws, err := clientHandshake(conn, u)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	return ws, nil
+}
+
+func clientHandshake(conn net.Conn, u *url.URL) (*wsConn, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	path := u.RequestURI()
+	req := "GET " + path + " HTTP/1.1\r\n" +
+		"Host: " + u.Host + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	rsp, err := http.ReadResponse(reader, &http.Request{Method: "GET"})
+	if err != nil {
+		return nil, err
+	}
+	rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusSwitchingProtocols ||
+		!strings.EqualFold(rsp.Header.Get("Upgrade"), "websocket") ||
+		rsp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return nil, fmt.Errorf("%s: unexpected response '%s'", errBadHandshake, rsp.Status)
+	}
+
+	return &wsConn{Conn: conn, reader: reader, client: true}, nil
+}
+
+//UpgradeWebSocket turns an http request into a websocket connection. the connection is taken over from the
+//http server so the handler must not use the response writer afterwards
+func UpgradeWebSocket(w http.ResponseWriter, req *http.Request) (net.Conn, error) {
+
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if req.Method != http.MethodGet || key == "" ||
+		!headerContains(req.Header, "Connection", "upgrade") ||
+		!headerContains(req.Header, "Upgrade", "websocket") ||
+		req.Header.Get("Sec-WebSocket-Version") != "13" {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return nil, errBadHandshake
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, errBadHandshake
+	}
+
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	rsp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(rsp)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetKeepAlive(true)
+	}
+
+	return &wsConn{Conn: conn, reader: brw.Reader}, nil
+}
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (ws *wsConn) Read(p []byte) (int, error) {
+	for ws.remaining == 0 {
+		if err := ws.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	if uint64(len(p)) > ws.remaining {
+		p = p[:ws.remaining]
+	}
+
+	n, err := ws.reader.Read(p)
+	if ws.masked {
+		for x := 0; x < n; x++ {
+			p[x] ^= ws.mask[ws.maskPos&3]
+			ws.maskPos++
+		}
+	}
+	ws.remaining -= uint64(n)
+	return n, err
+}
+
+//read frame headers until a data frame comes in, answering control frames on the way
+func (ws *wsConn) nextFrame() error {
+	var header [2]byte
+	if _, err := io.ReadFull(ws.reader, header[:]); err != nil {
+		return err
+	}
+
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0F
+	ws.masked = header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(ws.reader, ext[:]); err != nil {
+			return err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(ws.reader, ext[:]); err != nil {
+			return err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if ws.masked {
+		if _, err := io.ReadFull(ws.reader, ws.mask[:]); err != nil {
+			return err
+		}
+	}
+	ws.maskPos = 0
+
+	switch opcode {
+	case wsOpContinuation, wsOpText, wsOpBinary:
+		ws.remaining = length
+		return nil
+	}
+
+	//control frame
+	if !fin || length > wsMaxControlPayload {
+		return errBadControlFrame
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(ws.reader, payload); err != nil {
+		return err
+	}
+	if ws.masked {
+		for x := range payload {
+			payload[x] ^= ws.mask[x&3]
+		}
+	}
+
+	switch opcode {
+	case wsOpPing:
+		return ws.writeFrame(wsOpPong, payload)
+	case wsOpClose:
+		ws.writeFrame(wsOpClose, payload)
+		return io.EOF
+	}
+	return nil //pong or an unknown control frame
+}
+
+func (ws *wsConn) Write(p []byte) (int, error) {
+	if err := ws.writeFrame(wsOpBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (ws *wsConn) writeFrame(opcode byte, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+14)
+	frame = append(frame, 0x80|opcode)
+
+	var maskBit byte
+	if ws.client {
+		maskBit = 0x80
+	}
+
+	length := len(payload)
+	switch {
+	case length <= wsMaxControlPayload:
+		frame = append(frame, maskBit|byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, maskBit|126, byte(length>>8), byte(length))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(length))
+		frame = append(frame, maskBit|127)
+		frame = append(frame, ext[:]...)
+	}
+
+	if ws.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		for x := start; x < len(frame); x++ {
+			frame[x] ^= mask[(x-start)&3]
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	ws.writeLock.Lock()
+	defer ws.writeLock.Unlock()
+	_, err := ws.Conn.Write(frame)
+	return err
+}
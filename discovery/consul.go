@@ -0,0 +1,201 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//ConsulConfig is the configuration of the consul discovery backend
+type ConsulConfig struct {
+	Address         string        //http address of the local consul agent, http://127.0.0.1:8500 if not set
+	Service         string        //service name the nodes of the cluster register under, ngbigcache if not set
+	Token           string        //acl token, if the agent requires one
+	CheckInterval   time.Duration //how often consul checks the node accepts connections, 10s if not set
+	DeregisterAfter time.Duration //how long a node can fail its check before consul removes it, 1m if not set
+	WaitTime        time.Duration //how long a watch waits for a change before asking again, 5m if not set
+}
+
+//Consul announces nodes as consul services with a tcp health check and watches the healthy instances
+type Consul struct {
+	config ConsulConfig
+	client *http.Client
+}
+
+type consulCheck struct {
+	TCP                            string `json:"TCP"`
+	Interval                       string `json:"Interval"`
+	DeregisterCriticalServiceAfter string `json:"DeregisterCriticalServiceAfter"`
+}
+
+type consulRegistration struct {
+	ID      string      `json:"ID"`
+	Name    string      `json:"Name"`
+	Address string      `json:"Address"`
+	Port    int         `json:"Port"`
+	Check   consulCheck `json:"Check"`
+}
+
+type consulServiceEntry struct {
+	Node struct {
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		ID      string `json:"ID"`
+		Address string `json:"Address"`
+		Port    int    `json:"Port"`
+	} `json:"Service"`
+}
+
+//NewConsul creates a consul discovery backend
+func NewConsul(config ConsulConfig) *Consul {
+	if config.Address == "" {
+		config.Address = "http://127.0.0.1:8500"
+	}
+	config.Address = strings.TrimSuffix(config.Address, "/")
+	if config.Service == "" {
+		config.Service = "ngbigcache"
+	}
+	if config.CheckInterval <= 0 {
+		config.CheckInterval = time.Second * 10
+	}
+	if config.DeregisterAfter <= 0 {
+		config.DeregisterAfter = time.Minute
+	}
+	if config.WaitTime <= 0 {
+		config.WaitTime = time.Minute * 5
+	}
+
+	return &Consul{config: config, client: &http.Client{}}
+}
+
+func (c *Consul) newRequest(method, path string, body interface{}) (*http.Request, error) {
+	req, err := newJSONRequest(method, c.config.Address+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.config.Token != "" {
+		req.Header.Set("X-Consul-Token", c.config.Token)
+	}
+	return req, nil
+}
+
+//Register the node as an instance of the service
+func (c *Consul) Register(self Peer) error {
+	host, port, err := net.SplitHostPort(self.Address)
+	if err != nil {
+		return err
+	}
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		return err
+	}
+
+	req, err := c.newRequest(http.MethodPut, "/v1/agent/service/register", &consulRegistration{
+		ID:      self.Id,
+		Name:    c.config.Service,
+		Address: host,
+		Port:    portNum,
+		Check: consulCheck{
+			TCP:                            self.Address,
+			Interval:                       c.config.CheckInterval.String(),
+			DeregisterCriticalServiceAfter: c.config.DeregisterAfter.String(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return doJSON(c.client, req, nil)
+}
+
+//Deregister the node's instance of the service
+func (c *Consul) Deregister(self Peer) error {
+	req, err := c.newRequest(http.MethodPut, "/v1/agent/service/deregister/"+url.PathEscape(self.Id), nil)
+	if err != nil {
+		return err
+	}
+	return doJSON(c.client, req, nil)
+}
+
+//the healthy instances of the service. index is the consul index to block on, the new index is returned
+func (c *Consul) healthy(ctx context.Context, index uint64) ([]Peer, uint64, error) {
+	query := url.Values{}
+	query.Set("passing", "true")
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", c.config.WaitTime.String())
+	}
+
+	req, err := c.newRequest(http.MethodGet, "/v1/health/service/"+url.PathEscape(c.config.Service)+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	res, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var entries []consulServiceEntry
+	if err := readJSON(res, &entries); err != nil {
+		return nil, 0, err
+	}
+	newIndex, _ := strconv.ParseUint(res.Header.Get("X-Consul-Index"), 10, 64)
+
+	peers := make([]Peer, 0, len(entries))
+	for _, entry := range entries {
+		host := entry.Service.Address
+		if host == "" { //the service uses the address of the node it runs on
+			host = entry.Node.Address
+		}
+		peers = append(peers, Peer{Id: entry.Service.ID, Address: net.JoinHostPort(host, strconv.Itoa(entry.Service.Port))})
+	}
+	return peers, newIndex, nil
+}
+
+//Watch the healthy instances of the service with consul's blocking queries
+func (c *Consul) Watch(done <-chan struct{}) (<-chan []Peer, error) {
+	out := make(chan []Peer)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-done
+		cancel()
+	}()
+
+	go func() {
+		defer close(out)
+		var index uint64
+		published := false
+		for {
+			peers, newIndex, err := c.healthy(ctx, index)
+			if err != nil {
+				index = 0
+				if !wait(done) {
+					return
+				}
+				continue
+			}
+
+			changed := !published || newIndex != index
+			if newIndex < index { //consul's index went backwards, start over as its documentation advises
+				newIndex = 0
+			}
+			index = newIndex
+			if changed {
+				if !publish(out, peers, done) {
+					return
+				}
+				published = true
+			}
+			if index == 0 && !wait(done) { //queries do not block without an index so poll instead
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
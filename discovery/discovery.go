@@ -0,0 +1,96 @@
+package discovery
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+//how long to wait before retrying a backend that failed
+const retryInterval = time.Second * 2
+
+//Peer is a node announced through a discovery backend
+type Peer struct {
+	Id      string `json:"id"`
+	Address string `json:"address"` //host:port other nodes connect to
+}
+
+//Discovery lets a node announce itself and learn about the other nodes of the cluster, so clusters can
+//form without knowing the address of any node up front
+type Discovery interface {
+	//Register announces the node until it is deregistered or stops running
+	Register(self Peer) error
+	//Deregister withdraws the announcement of the node
+	Deregister(self Peer) error
+	//Watch sends every announced peer whenever they change, until done is closed.
+	//the returned channel is closed once watching stops
+	Watch(done <-chan struct{}) (<-chan []Peer, error)
+}
+
+//send the peers unless done is closed first
+func publish(out chan<- []Peer, peers []Peer, done <-chan struct{}) bool {
+	select {
+	case out <- peers:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+//wait before retrying, false if done is closed in the meantime
+func wait(done <-chan struct{}) bool {
+	select {
+	case <-time.After(retryInterval):
+		return true
+	case <-done:
+		return false
+	}
+}
+
+//send a request and decode the json response into rsp if it is not nil
+func doJSON(client *http.Client, req *http.Request, rsp interface{}) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	return readJSON(res, rsp)
+}
+
+//decode the json response into rsp if it is not nil, anything but a 2xx status is an error
+func readJSON(res *http.Response, rsp interface{}) error {
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("%s %s: %s %s", res.Request.Method, res.Request.URL.Path, res.Status, bytes.TrimSpace(body))
+	}
+
+	if rsp == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(rsp)
+}
+
+func newJSONRequest(method, url string, body interface{}) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
@@ -0,0 +1,283 @@
+package discovery
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//just enough of consul's agent and health api for the consul backend
+type fakeConsul struct {
+	lock     sync.Mutex
+	index    uint64
+	changed  chan struct{}
+	services map[string]consulRegistration
+}
+
+func newFakeConsul() *fakeConsul {
+	return &fakeConsul{index: 1, changed: make(chan struct{}), services: make(map[string]consulRegistration)}
+}
+
+func (f *fakeConsul) update(change func()) {
+	f.lock.Lock()
+	change()
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+	f.lock.Unlock()
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch {
+	case req.URL.Path == "/v1/agent/service/register":
+		reg := consulRegistration{}
+		json.NewDecoder(req.Body).Decode(&reg)
+		f.update(func() { f.services[reg.ID] = reg })
+	case strings.HasPrefix(req.URL.Path, "/v1/agent/service/deregister/"):
+		id := strings.TrimPrefix(req.URL.Path, "/v1/agent/service/deregister/")
+		f.update(func() { delete(f.services, id) })
+	case strings.HasPrefix(req.URL.Path, "/v1/health/service/"):
+		f.lock.Lock()
+		changed := f.changed
+		if index, _ := strconv.ParseUint(req.URL.Query().Get("index"), 10, 64); index == f.index {
+			f.lock.Unlock()
+			select { //block until something changes, like consul does
+			case <-changed:
+			case <-req.Context().Done():
+				return
+			}
+			f.lock.Lock()
+		}
+		entries := make([]consulServiceEntry, 0)
+		for _, reg := range f.services {
+			entry := consulServiceEntry{}
+			entry.Service.ID = reg.ID
+			entry.Service.Address = reg.Address
+			entry.Service.Port = reg.Port
+			entries = append(entries, entry)
+		}
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+		f.lock.Unlock()
+		json.NewEncoder(w).Encode(entries)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func nextPeers(t *testing.T, peers <-chan []Peer) []string {
+	select {
+	case list := <-peers:
+		ids := make([]string, 0, len(list))
+		for _, peer := range list {
+			ids = append(ids, peer.Id+"@"+peer.Address)
+		}
+		sort.Strings(ids)
+		return ids
+	case <-time.After(time.Second * 2):
+		t.Fatal("no peers received from the watch")
+	}
+	return nil
+}
+
+func TestConsul(t *testing.T) {
+	svr := httptest.NewServer(newFakeConsul())
+	defer svr.Close()
+
+	consul := NewConsul(ConsulConfig{Address: svr.URL})
+	if err := consul.Register(Peer{Id: "node_1", Address: "10.0.0.1:9911"}); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	peers, err := consul.Watch(done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := nextPeers(t, peers); len(ids) != 1 || ids[0] != "node_1@10.0.0.1:9911" {
+		t.Errorf("unexpected peers %v", ids)
+	}
+
+	consul.Register(Peer{Id: "node_2", Address: "[::1]:9912"})
+	if ids := nextPeers(t, peers); len(ids) != 2 || ids[1] != "node_2@[::1]:9912" {
+		t.Errorf("watch ought to see the new peer %v", ids)
+	}
+
+	consul.Deregister(Peer{Id: "node_1"})
+	if ids := nextPeers(t, peers); len(ids) != 1 || ids[0] != "node_2@[::1]:9912" {
+		t.Errorf("watch ought to see the peer go away %v", ids)
+	}
+}
+
+//just enough of etcd's v3 json gateway for the etcd backend
+type fakeEtcd struct {
+	lock     sync.Mutex
+	revision int64
+	changed  chan struct{}
+	leases   map[int64]bool
+	keys     map[string][]byte
+	owners   map[string]int64 //lease of each key
+}
+
+func newFakeEtcd() *fakeEtcd {
+	return &fakeEtcd{revision: 1, changed: make(chan struct{}), leases: make(map[int64]bool),
+		keys: make(map[string][]byte), owners: make(map[string]int64)}
+}
+
+func (f *fakeEtcd) update(change func()) {
+	f.lock.Lock()
+	change()
+	f.revision++
+	close(f.changed)
+	f.changed = make(chan struct{})
+	f.lock.Unlock()
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		ID            int64  `json:"ID"`
+		TTL           int64  `json:"TTL"`
+		Key           []byte `json:"key"`
+		RangeEnd      []byte `json:"range_end"`
+		Value         []byte `json:"value"`
+		Lease         int64  `json:"lease"`
+		CreateRequest struct {
+			StartRevision int64 `json:"start_revision"`
+		} `json:"create_request"`
+	}
+	json.NewDecoder(req.Body).Decode(&body)
+
+	switch req.URL.Path {
+	case "/v3/lease/grant":
+		f.lock.Lock()
+		id := int64(len(f.leases) + 1)
+		f.leases[id] = true
+		f.lock.Unlock()
+		w.Write([]byte(`{"ID":"` + strconv.FormatInt(id, 10) + `","TTL":"` + strconv.FormatInt(body.TTL, 10) + `"}`))
+	case "/v3/lease/keepalive":
+		w.Write([]byte(`{"result":{"TTL":"10"}}`))
+	case "/v3/lease/revoke":
+		f.update(func() {
+			for key, lease := range f.owners {
+				if lease == body.ID {
+					delete(f.keys, key)
+					delete(f.owners, key)
+				}
+			}
+		})
+		w.Write([]byte(`{}`))
+	case "/v3/kv/put":
+		f.update(func() {
+			f.keys[string(body.Key)] = body.Value
+			f.owners[string(body.Key)] = body.Lease
+		})
+		w.Write([]byte(`{}`))
+	case "/v3/kv/range":
+		f.lock.Lock()
+		rsp := etcdRangeResponse{}
+		rsp.Header.Revision = etcdInt(f.revision)
+		for key, value := range f.keys {
+			if key >= string(body.Key) && key < string(body.RangeEnd) {
+				rsp.Kvs = append(rsp.Kvs, etcdKeyValue{Key: []byte(key), Value: value})
+			}
+		}
+		f.lock.Unlock()
+		json.NewEncoder(w).Encode(rsp)
+	case "/v3/watch":
+		w.Write([]byte(`{"result":{"created":true}}` + "\n"))
+		w.(http.Flusher).Flush()
+		for {
+			f.lock.Lock()
+			revision, changed := f.revision, f.changed
+			f.lock.Unlock()
+			if revision >= body.CreateRequest.StartRevision {
+				w.Write([]byte(`{"result":{"events":[{}]}}` + "\n"))
+				return
+			}
+			select {
+			case <-changed:
+			case <-req.Context().Done():
+				return
+			}
+		}
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func TestEtcd(t *testing.T) {
+	svr := httptest.NewServer(newFakeEtcd())
+	defer svr.Close()
+
+	//the first endpoint is down so the backend has to fail over to the second one
+	etcd := NewEtcd(EtcdConfig{Endpoints: []string{"http://127.0.0.1:1", svr.URL}})
+	if err := etcd.Register(Peer{Id: "node_1", Address: "10.0.0.1:9911"}); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	peers, err := etcd.Watch(done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := nextPeers(t, peers); len(ids) != 1 || ids[0] != "node_1@10.0.0.1:9911" {
+		t.Errorf("unexpected peers %v", ids)
+	}
+
+	node2 := NewEtcd(EtcdConfig{Endpoints: []string{svr.URL}})
+	node2.Register(Peer{Id: "node_2", Address: "10.0.0.2:9911"})
+	if ids := nextPeers(t, peers); len(ids) != 2 || ids[1] != "node_2@10.0.0.2:9911" {
+		t.Errorf("watch ought to see the new peer %v", ids)
+	}
+
+	etcd.Deregister(Peer{Id: "node_1"})
+	if ids := nextPeers(t, peers); len(ids) != 1 || ids[0] != "node_2@10.0.0.2:9911" {
+		t.Errorf("watch ought to see the peer go away %v", ids)
+	}
+	node2.Deregister(Peer{Id: "node_2"})
+}
+
+func TestPrefixEnd(t *testing.T) {
+	if end := string(prefixEnd("/nodes/")); end != "/nodes0" {
+		t.Errorf("unexpected end of range '%s'", end)
+	}
+}
+
+func TestEtcdRenewAfterDeregister(t *testing.T) {
+	fake := newFakeEtcd()
+	svr := httptest.NewServer(fake)
+	defer svr.Close()
+
+	etcd := NewEtcd(EtcdConfig{Endpoints: []string{svr.URL}})
+	self := Peer{Id: "node_1", Address: "10.0.0.1:9911"}
+	if err := etcd.Register(self); err != nil {
+		t.Fatal(err)
+	}
+	etcd.lock.Lock()
+	renewing := etcd.keepAlive
+	etcd.lock.Unlock()
+
+	//the lease expired just as the node deregistered, the renewal must not announce it again
+	etcd.Deregister(self)
+	if err := etcd.register(self, renewing); err != nil {
+		t.Fatal(err)
+	}
+	fake.lock.Lock()
+	keys := len(fake.keys)
+	fake.lock.Unlock()
+	if keys != 0 {
+		t.Errorf("the node ought to stay deregistered, %d keys left", keys)
+	}
+	etcd.lock.Lock()
+	defer etcd.lock.Unlock()
+	if etcd.keepAlive != nil {
+		t.Error("a renewal after Deregister ought not to keep a lease alive")
+	}
+}
@@ -0,0 +1,274 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errLeaseExpired = errors.New("etcd lease expired")
+
+//EtcdConfig is the configuration of the etcd discovery backend
+type EtcdConfig struct {
+	Endpoints []string //http addresses of the etcd v3 json gateway, tried in turn. http://127.0.0.1:2379 if not set
+	Prefix    string   //prefix of the keys nodes are announced under, /ngbigcache/nodes/ if not set
+	TTL       int64    //seconds an announcement outlives its node, 10 if not set
+}
+
+//Etcd announces nodes as keys attached to a lease that is kept alive while the node runs, and watches
+//the keys under the prefix
+type Etcd struct {
+	config    EtcdConfig
+	client    *http.Client
+	lock      sync.Mutex
+	lease     int64
+	keepAlive chan struct{} //closed to stop keeping the lease alive
+}
+
+//etcd's json gateway sends 64 bit integers as strings
+type etcdInt int64
+
+func (i *etcdInt) UnmarshalJSON(data []byte) error {
+	v, err := strconv.ParseInt(strings.Trim(string(data), `"`), 10, 64)
+	*i = etcdInt(v)
+	return err
+}
+
+type etcdKeyValue struct {
+	Key   []byte `json:"key"` //[]byte is base64 in json, which is what the gateway expects
+	Value []byte `json:"value"`
+}
+
+type etcdRangeResponse struct {
+	Header struct {
+		Revision etcdInt `json:"revision"`
+	} `json:"header"`
+	Kvs []etcdKeyValue `json:"kvs"`
+}
+
+type etcdWatchResponse struct {
+	Result struct {
+		Events []json.RawMessage `json:"events"`
+	} `json:"result"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+//NewEtcd creates an etcd discovery backend
+func NewEtcd(config EtcdConfig) *Etcd {
+	if len(config.Endpoints) == 0 {
+		config.Endpoints = []string{"http://127.0.0.1:2379"}
+	}
+	for x := range config.Endpoints {
+		config.Endpoints[x] = strings.TrimSuffix(config.Endpoints[x], "/")
+	}
+	if config.Prefix == "" {
+		config.Prefix = "/ngbigcache/nodes/"
+	}
+	if config.TTL <= 0 {
+		config.TTL = 10
+	}
+
+	return &Etcd{config: config, client: &http.Client{}}
+}
+
+//the end of the range of keys starting with prefix
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for x := len(end) - 1; x >= 0; x-- {
+		if end[x] < 0xff {
+			end[x]++
+			return end[:x+1]
+		}
+	}
+	return []byte{0} //every key
+}
+
+//post to the first endpoint that answers
+func (e *Etcd) post(ctx context.Context, path string, body interface{}, rsp interface{}) (*http.Response, error) {
+	var lastErr error
+	for _, endpoint := range e.config.Endpoints {
+		req, err := newJSONRequest(http.MethodPost, endpoint+path, body)
+		if err != nil {
+			return nil, err
+		}
+		res, err := e.client.Do(req.WithContext(ctx))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if rsp == nil { //the caller reads the response
+			return res, nil
+		}
+		return res, readJSON(res, rsp)
+	}
+	return nil, lastErr
+}
+
+//Register the node under the prefix, the key goes away on its own if the node stops keeping its lease alive
+func (e *Etcd) Register(self Peer) error {
+	return e.register(self, nil)
+}
+
+//register the node, again after its lease expired when renewing is the channel stopping the goroutine that kept
+//it alive. that registration gives up, revoking the lease it got, once Deregister ran or another registration
+//replaced it meanwhile, so a node shutting down is never announced again
+func (e *Etcd) register(self Peer, renewing chan struct{}) error {
+	var grant struct {
+		ID  etcdInt `json:"ID"`
+		TTL etcdInt `json:"TTL"`
+	}
+	if _, err := e.post(context.Background(), "/v3/lease/grant", map[string]int64{"TTL": e.config.TTL}, &grant); err != nil {
+		return err
+	}
+
+	value, _ := json.Marshal(self)
+	put := map[string]interface{}{"key": []byte(e.config.Prefix + self.Id), "value": value, "lease": int64(grant.ID)}
+	if _, err := e.post(context.Background(), "/v3/kv/put", put, &struct{}{}); err != nil {
+		return err
+	}
+
+	e.lock.Lock()
+	if renewing != nil && e.keepAlive != renewing {
+		e.lock.Unlock()
+		return e.revoke(int64(grant.ID))
+	}
+	defer e.lock.Unlock()
+	if e.keepAlive != nil {
+		close(e.keepAlive)
+	}
+	e.lease = int64(grant.ID)
+	e.keepAlive = make(chan struct{})
+	go e.keepLeaseAlive(self, e.lease, e.keepAlive)
+	return nil
+}
+
+//refresh the lease a few times per ttl, registering again if it expired anyway
+func (e *Etcd) keepLeaseAlive(self Peer, lease int64, done chan struct{}) {
+	ticker := time.NewTicker(time.Duration(e.config.TTL) * time.Second / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		var rsp struct {
+			Result struct {
+				TTL etcdInt `json:"TTL"`
+			} `json:"result"`
+		}
+		_, err := e.post(context.Background(), "/v3/lease/keepalive", map[string]int64{"ID": lease}, &rsp)
+		if err == nil && rsp.Result.TTL <= 0 {
+			err = errLeaseExpired
+		}
+		if err == errLeaseExpired {
+			go e.register(self, done) //starts a new goroutine for the new lease unless deregistered meanwhile
+			return
+		}
+	}
+}
+
+//Deregister the node by revoking its lease, which deletes its key
+func (e *Etcd) Deregister(self Peer) error {
+	e.lock.Lock()
+	lease := e.lease
+	if e.keepAlive != nil {
+		close(e.keepAlive)
+		e.keepAlive = nil
+	}
+	e.lock.Unlock()
+
+	if lease == 0 {
+		return nil
+	}
+	return e.revoke(lease)
+}
+
+//revoke a lease, which deletes the key put with it
+func (e *Etcd) revoke(lease int64) error {
+	_, err := e.post(context.Background(), "/v3/lease/revoke", map[string]int64{"ID": lease}, &struct{}{})
+	return err
+}
+
+//the peers announced under the prefix along with the revision they were read at
+func (e *Etcd) peers(ctx context.Context) ([]Peer, int64, error) {
+	var rsp etcdRangeResponse
+	query := map[string]interface{}{"key": []byte(e.config.Prefix), "range_end": prefixEnd(e.config.Prefix)}
+	if _, err := e.post(ctx, "/v3/kv/range", query, &rsp); err != nil {
+		return nil, 0, err
+	}
+
+	peers := make([]Peer, 0, len(rsp.Kvs))
+	for _, kv := range rsp.Kvs {
+		peer := Peer{}
+		if err := json.Unmarshal(kv.Value, &peer); err == nil {
+			peers = append(peers, peer)
+		}
+	}
+	return peers, int64(rsp.Header.Revision), nil
+}
+
+//block until a key under the prefix changes after revision
+func (e *Etcd) waitForChange(ctx context.Context, revision int64) error {
+	create := map[string]interface{}{"create_request": map[string]interface{}{
+		"key": []byte(e.config.Prefix), "range_end": prefixEnd(e.config.Prefix), "start_revision": revision + 1}}
+	res, err := e.post(ctx, "/v3/watch", create, nil)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return readJSON(res, nil)
+	}
+	defer res.Body.Close()
+
+	decoder := json.NewDecoder(res.Body)
+	for {
+		var rsp etcdWatchResponse
+		if err := decoder.Decode(&rsp); err != nil {
+			return err
+		}
+		if rsp.Error != nil {
+			return errors.New(rsp.Error.Message)
+		}
+		if len(rsp.Result.Events) > 0 {
+			return nil
+		}
+	}
+}
+
+//Watch the keys under the prefix
+func (e *Etcd) Watch(done <-chan struct{}) (<-chan []Peer, error) {
+	out := make(chan []Peer)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-done
+		cancel()
+	}()
+
+	go func() {
+		defer close(out)
+		for {
+			peers, revision, err := e.peers(ctx)
+			if err == nil {
+				if !publish(out, peers, done) {
+					return
+				}
+				err = e.waitForChange(ctx, revision)
+			}
+			if err != nil && !wait(done) {
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
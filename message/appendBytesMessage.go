@@ -0,0 +1,23 @@
+package message
+
+import "encoding/json"
+
+//AppendBytesMessage adds bytes to the end of the entry under a key
+type AppendBytesMessage struct {
+	Code   uint16 `json:"code"`
+	Key    string `json:"key"`
+	Data   []byte `json:"data"`
+	Expiry uint64 `json:"expiry"` //of the entry if it has to be created
+}
+
+//Serialize append bytes message to node wire message
+func (am *AppendBytesMessage) Serialize() *NodeWireMessage {
+	am.Code = MsgAPPENDBytes
+	data, _ := json.Marshal(am)
+	return &NodeWireMessage{Code: MsgAPPENDBytes, Data: data}
+}
+
+//DeSerialize node wire message into append bytes message
+func (am *AppendBytesMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, am)
+}
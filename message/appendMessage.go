@@ -0,0 +1,24 @@
+package message
+
+import "encoding/json"
+
+//AppendMessage adds items to the end of the list under a key
+type AppendMessage struct {
+	Code   uint16   `json:"code"`
+	Key    string   `json:"key"`
+	Items  [][]byte `json:"items"`
+	MaxLen int      `json:"max_len"` //oldest items beyond it are dropped, no limit if not above zero
+	Expiry uint64   `json:"expiry"`  //of the list if it has to be created
+}
+
+//Serialize append message to node wire message
+func (am *AppendMessage) Serialize() *NodeWireMessage {
+	am.Code = MsgAPPEND
+	data, _ := json.Marshal(am)
+	return &NodeWireMessage{Code: MsgAPPEND, Data: data}
+}
+
+//DeSerialize node wire message into append message
+func (am *AppendMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, am)
+}
@@ -0,0 +1,24 @@
+package message
+
+import "encoding/json"
+
+//AttachMessage is the first message sent on an additional connection to an already verified remote node.
+//it tells the remote node which of its peers the connection belongs to, and proves it with the lane token that
+//peer was given in VerifyOK on its verified connection
+type AttachMessage struct {
+	Code  uint16 `json:"code"`
+	Id    string `json:"id"`
+	Token string `json:"token"`
+}
+
+//Serialize attach message to node wire message
+func (am *AttachMessage) Serialize() *NodeWireMessage {
+	am.Code = MsgATTACH
+	data, _ := json.Marshal(am)
+	return &NodeWireMessage{Code: MsgATTACH, Data: data}
+}
+
+//DeSerialize node wire message into attach message
+func (am *AttachMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, am)
+}
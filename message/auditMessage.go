@@ -0,0 +1,44 @@
+package message
+
+import "encoding/json"
+
+//AuditReqMessage asks a remoteNode for the details of its copy of a key
+type AuditReqMessage struct {
+	Code       uint16 `json:"code"`
+	Key        string `json:"key"`
+	PendingKey string `json:"pending_key"`
+}
+
+//Serialize audit request message to node wire message
+func (am *AuditReqMessage) Serialize() *NodeWireMessage {
+	am.Code = MsgAuditReq
+	data, _ := json.Marshal(am)
+	return &NodeWireMessage{Code: MsgAuditReq, Data: data}
+}
+
+//DeSerialize node wire message into audit request message
+func (am *AuditReqMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, am)
+}
+
+//AuditRspMessage carries the details of a remoteNode's copy of a key, the value itself is not sent
+type AuditRspMessage struct {
+	Code       uint16 `json:"code"`
+	PendingKey string `json:"pending_key"`
+	Found      bool   `json:"found"`
+	Expiry     uint64 `json:"expiry"`
+	Size       int    `json:"size"`
+	Checksum   uint32 `json:"checksum"`
+}
+
+//Serialize audit response message to node wire message
+func (am *AuditRspMessage) Serialize() *NodeWireMessage {
+	am.Code = MsgAuditRsp
+	data, _ := json.Marshal(am)
+	return &NodeWireMessage{Code: MsgAuditRsp, Data: data}
+}
+
+//DeSerialize node wire message into audit response message
+func (am *AuditRspMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, am)
+}
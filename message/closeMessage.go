@@ -0,0 +1,25 @@
+package message
+
+import "encoding/json"
+
+//CloseReasonIdle is the reason given when a passive client's connection is closed for being idle
+const CloseReasonIdle = "idle"
+
+//CloseMessage tells a remoteNode its connection is about to be closed and why, so it closes it itself and
+//does not dial again straight away
+type CloseMessage struct {
+	Code   uint16 `json:"code"`
+	Reason string `json:"reason"`
+}
+
+//Serialize close message to node wire message
+func (cm *CloseMessage) Serialize() *NodeWireMessage {
+	cm.Code = MsgCLOSE
+	data, _ := json.Marshal(cm)
+	return &NodeWireMessage{Code: MsgCLOSE, Data: data}
+}
+
+//DeSerialize node wire message into close message
+func (cm *CloseMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, cm)
+}
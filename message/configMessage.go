@@ -0,0 +1,21 @@
+package message
+
+import "encoding/json"
+
+//ConfigMessage propagates settings changed at runtime on a node to the rest of the cluster
+type ConfigMessage struct {
+	Code              uint16 `json:"code"`
+	ReplicationFactor int    `json:"replication_factor"`
+}
+
+//Serialize config message to node wire message
+func (cm *ConfigMessage) Serialize() *NodeWireMessage {
+	cm.Code = MsgCONFIG
+	data, _ := json.Marshal(cm)
+	return &NodeWireMessage{Code: MsgCONFIG, Data: data}
+}
+
+//DeSerialize node wire message into config message
+func (cm *ConfigMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, cm)
+}
@@ -0,0 +1,24 @@
+package message
+
+import "encoding/json"
+
+//CounterMessage carries the counts of the nodes of the grow-only counter under a key, merged into the copy of
+//the receiver keeping the larger count of each node
+type CounterMessage struct {
+	Code   uint16            `json:"code"`
+	Key    string            `json:"key"`
+	Counts map[string]uint64 `json:"counts"` //by id of the node
+	Expiry uint64            `json:"expiry"` //of the counter if it has to be created
+}
+
+//Serialize counter message to node wire message
+func (cm *CounterMessage) Serialize() *NodeWireMessage {
+	cm.Code = MsgCOUNTER
+	data, _ := json.Marshal(cm)
+	return &NodeWireMessage{Code: MsgCOUNTER, Data: data}
+}
+
+//DeSerialize node wire message into counter message
+func (cm *CounterMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, cm)
+}
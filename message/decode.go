@@ -0,0 +1,208 @@
+package message
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+//FrameHeaderSize is the size of the header in front of every message on the wire, a 4 byte length
+//(which includes the message code) followed by the 2 byte message code
+const FrameHeaderSize = 6
+
+//MaxFrameSize is the largest frame accepted from a remote node, so a corrupt or crafted length can not make
+//a node allocate more than that. it has to be raised to replicate values larger than it. nodes configured with
+//a limit of their own check frames against it instead
+var MaxFrameSize uint32 = 64 << 20
+
+//errors returned when a frame or message is not well formed
+var (
+	ErrFrameTooShort    = errors.New("frame is too short")
+	ErrFrameTooLarge    = errors.New("frame is larger than MaxFrameSize")
+	ErrMalformedMessage = errors.New("malformed message")
+)
+
+//ParseFrameHeader reads the message code and the length of the message data out of a frame header
+func ParseFrameHeader(header []byte) (uint16, uint32, error) {
+	return ParseFrameHeaderMax(header, MaxFrameSize)
+}
+
+//ParseFrameHeaderMax is ParseFrameHeader with frames larger than maxSize refused instead of those larger than
+//MaxFrameSize
+func ParseFrameHeaderMax(header []byte, maxSize uint32) (uint16, uint32, error) {
+	if len(header) < FrameHeaderSize {
+		return 0, 0, ErrFrameTooShort
+	}
+
+	length := binary.LittleEndian.Uint32(header)
+	if length < 2 { //the length always includes the message code
+		return 0, 0, ErrFrameTooShort
+	}
+	if length > maxSize {
+		return 0, 0, ErrFrameTooLarge
+	}
+
+	return binary.LittleEndian.Uint16(header[4:]), length - 2, nil
+}
+
+func malformed(msg *NodeWireMessage, reason string) error {
+	return fmt.Errorf("%s %s: %s", ErrMalformedMessage, MsgCodeToString(msg.Code), reason)
+}
+
+//Validate checks the data of msg is well formed for its message code, so deserializing it never reads
+//past the end of the data. codes this build does not know are not checked
+func Validate(msg *NodeWireMessage) error {
+	switch msg.Code {
+	case MsgPING, MsgPONG, MsgVERIFYOK:
+		return nil
+	case MsgPUT, MsgPUTEx, MsgPUTStamped:
+		offset := putKeyOffset(msg)
+		if offset < 0 {
+			return malformed(msg, "request id is longer than the message")
+		}
+		if reason := checkKeyed(msg.Data, offset); reason != "" {
+			return malformed(msg, reason)
+		}
+	case MsgGETRsp:
+		if reason := checkKeyed(msg.Data, 0); reason != "" {
+			return malformed(msg, reason)
+		}
+	case MsgGETRspEx:
+		if reason := checkKeyed(msg.Data, 8); reason != "" {
+			return malformed(msg, reason)
+		}
+	default:
+		if newMessage(msg.Code) != nil && !json.Valid(msg.Data) {
+			return malformed(msg, "invalid json")
+		}
+	}
+
+	return nil
+}
+
+//check the binary layout shared by put and get response messages, a 2 byte key length at offset followed
+//by the key then the value. the reason the data is malformed is returned, empty if it is well formed
+func checkKeyed(data []byte, offset int) string {
+	if len(data) < offset+2 {
+		return "missing key length"
+	}
+	if keyLen := int(binary.LittleEndian.Uint16(data[offset:])); len(data) < offset+2+keyLen {
+		return "key is longer than the message"
+	}
+	return ""
+}
+
+//the empty message for a message code, nil if the code is unknown
+func newMessage(code uint16) NodeMessage {
+	switch code {
+	case MsgVERIFY:
+		return &VerifyMessage{}
+	case MsgVERIFYOK:
+		return &VerifyOKMessage{}
+	case MsgPING:
+		return &PingMessage{}
+	case MsgPONG:
+		return &PongMessage{}
+	case MsgPUT, MsgPUTEx, MsgPUTStamped:
+		return &PutMessage{}
+	case MsgGETReq:
+		return &GetReqMessage{}
+	case MsgGETRsp, MsgGETRspEx:
+		return &GetRspMessage{}
+	case MsgDEL:
+		return &DeleteMessage{}
+	case MsgSyncReq:
+		return &SyncReqMessage{}
+	case MsgSyncRsp:
+		return &SyncRspMessage{}
+	case MsgATTACH:
+		return &AttachMessage{}
+	case MsgPrimeReq:
+		return &PrimeReqMessage{}
+	case MsgAuditReq:
+		return &AuditReqMessage{}
+	case MsgAuditRsp:
+		return &AuditRspMessage{}
+	case MsgGossip:
+		return &GossipMessage{}
+	case MsgLEAVE:
+		return &LeaveMessage{}
+	case MsgLEAVEAck:
+		return &LeaveAckMessage{}
+	case MsgWATCH:
+		return &WatchMessage{}
+	case MsgINVALIDATE:
+		return &InvalidateMessage{}
+	case MsgREFUSED:
+		return &RefusedMessage{}
+	case MsgTTLReq:
+		return &TTLReqMessage{}
+	case MsgTTLRsp:
+		return &TTLRspMessage{}
+	case MsgAPPEND:
+		return &AppendMessage{}
+	case MsgSADD:
+		return &SAddMessage{}
+	case MsgCLOSE:
+		return &CloseMessage{}
+	case MsgAPPENDBytes:
+		return &AppendBytesMessage{}
+	case MsgSETNXReq:
+		return &SetIfAbsentReqMessage{}
+	case MsgSETNXRsp:
+		return &SetIfAbsentRspMessage{}
+	case MsgSTATSSubscribe:
+		return &StatsSubscribeMessage{}
+	case MsgSTATSPush:
+		return &StatsPushMessage{}
+	case MsgPUTAckReq:
+		return &PutAckReqMessage{}
+	case MsgPUTAck:
+		return &PutAckMessage{}
+	case MsgSNAPSHOTReq:
+		return &SnapshotReqMessage{}
+	case MsgSNAPSHOTRsp:
+		return &SnapshotRspMessage{}
+	case MsgCONFIG:
+		return &ConfigMessage{}
+	case MsgEVICT:
+		return &EvictMessage{}
+	case MsgNOTFOUND:
+		return &NotFoundMessage{}
+	case MsgHSET:
+		return &HSetMessage{}
+	case MsgHDEL:
+		return &HDelMessage{}
+	case MsgLIST, MsgLISTReq:
+		return &ListMessage{}
+	case MsgLISTRsp:
+		return &ListRspMessage{}
+	case MsgKEYSReq:
+		return &KeysReqMessage{}
+	case MsgKEYSRsp:
+		return &KeysRspMessage{}
+	case MsgSTATSReq:
+		return &StatsReqMessage{}
+	case MsgSTATSRsp:
+		return &StatsRspMessage{}
+	case MsgCOUNTER:
+		return &CounterMessage{}
+	}
+
+	return nil
+}
+
+//Decode validates msg and deserializes it into the message for its code
+func Decode(msg *NodeWireMessage) (NodeMessage, error) {
+	m := newMessage(msg.Code)
+	if m == nil {
+		return nil, fmt.Errorf("%s: unknown message code %d", ErrMalformedMessage, msg.Code)
+	}
+	if err := Validate(msg); err != nil {
+		return nil, err
+	}
+
+	m.DeSerialize(msg)
+	return m, nil
+}
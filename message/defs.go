@@ -12,8 +12,105 @@ const (
 	MsgDEL
 	MsgSyncReq
 	MsgSyncRsp
+	MsgATTACH
+	MsgPrimeReq
+	MsgAuditReq
+	MsgAuditRsp
+	MsgGossip
+	MsgLEAVE
+	MsgLEAVEAck
+	MsgWATCH
+	MsgINVALIDATE
+	MsgGETRspEx
+	MsgREFUSED
+	MsgTTLReq
+	MsgTTLRsp
+	MsgAPPEND
+	MsgSADD
+	MsgPUTEx
+	MsgCLOSE
+	MsgAPPENDBytes
+	MsgSETNXReq
+	MsgSETNXRsp
+	MsgSTATSSubscribe
+	MsgSTATSPush
+	MsgPUTAckReq
+	MsgPUTAck
+	MsgPUTStamped
+	MsgSNAPSHOTReq
+	MsgSNAPSHOTRsp
+	MsgCONFIG
+	MsgEVICT
+	MsgNOTFOUND
+	MsgHSET
+	MsgHDEL
+	MsgLIST
+	MsgLISTReq
+	MsgLISTRsp
+	MsgKEYSReq
+	MsgKEYSRsp
+	MsgSTATSReq
+	MsgSTATSRsp
+	MsgCOUNTER
 )
 
+//Protocol versions spoken on the wire. A node advertises the highest version it speaks in its VerifyMessage
+//and both sides then use the highest version they have in common
+const (
+	ProtocolVersion1 uint16 = 1
+	ProtocolVersion2 uint16 = 2
+
+	//ProtocolVersion is the highest protocol version this build speaks
+	ProtocolVersion = ProtocolVersion2
+	//MinProtocolVersion is the oldest protocol version this build still accepts
+	MinProtocolVersion = ProtocolVersion1
+)
+
+//msgMinVersion maps message codes to the protocol version that introduced them.
+//codes not listed here are part of ProtocolVersion1
+var msgMinVersion = map[uint16]uint16{
+	MsgATTACH:         ProtocolVersion2,
+	MsgPrimeReq:       ProtocolVersion2,
+	MsgAuditReq:       ProtocolVersion2,
+	MsgAuditRsp:       ProtocolVersion2,
+	MsgGossip:         ProtocolVersion2,
+	MsgLEAVE:          ProtocolVersion2,
+	MsgLEAVEAck:       ProtocolVersion2,
+	MsgWATCH:          ProtocolVersion2,
+	MsgINVALIDATE:     ProtocolVersion2,
+	MsgGETRspEx:       ProtocolVersion2,
+	MsgREFUSED:        ProtocolVersion2,
+	MsgTTLReq:         ProtocolVersion2,
+	MsgTTLRsp:         ProtocolVersion2,
+	MsgAPPEND:         ProtocolVersion2,
+	MsgSADD:           ProtocolVersion2,
+	MsgPUTEx:          ProtocolVersion2,
+	MsgCLOSE:          ProtocolVersion2,
+	MsgAPPENDBytes:    ProtocolVersion2,
+	MsgSETNXReq:       ProtocolVersion2,
+	MsgSETNXRsp:       ProtocolVersion2,
+	MsgSTATSSubscribe: ProtocolVersion2,
+	MsgSTATSPush:      ProtocolVersion2,
+	MsgPUTAckReq:      ProtocolVersion2,
+	MsgPUTAck:         ProtocolVersion2,
+	MsgPUTStamped:     ProtocolVersion2,
+	MsgSNAPSHOTReq:    ProtocolVersion2,
+	MsgSNAPSHOTRsp:    ProtocolVersion2,
+	MsgCONFIG:         ProtocolVersion2,
+	MsgEVICT:          ProtocolVersion2,
+	MsgNOTFOUND:       ProtocolVersion2,
+	MsgHSET:           ProtocolVersion2,
+	MsgHDEL:           ProtocolVersion2,
+	MsgLIST:           ProtocolVersion2,
+	MsgLISTReq:        ProtocolVersion2,
+	MsgLISTRsp:        ProtocolVersion2,
+	MsgKEYSReq:        ProtocolVersion2,
+	MsgKEYSRsp:        ProtocolVersion2,
+	MsgSTATSReq:       ProtocolVersion2,
+	MsgSTATSRsp:       ProtocolVersion2,
+	MsgCOUNTER:        ProtocolVersion2,
+}
+
 //NodeWireMessage defines the struct that carries message on the wire
 type NodeWireMessage struct {
 	Code uint16
@@ -55,7 +152,110 @@ func MsgCodeToString(code uint16) string {
 		return "msgGETReq"
 	case MsgGETRsp:
 		return "msgGETRsp"
+	case MsgATTACH:
+		return "msgAttach"
+	case MsgPrimeReq:
+		return "msgPrimeReq"
+	case MsgAuditReq:
+		return "msgAuditReq"
+	case MsgAuditRsp:
+		return "msgAuditRsp"
+	case MsgGossip:
+		return "msgGossip"
+	case MsgLEAVE:
+		return "msgLeave"
+	case MsgLEAVEAck:
+		return "msgLeaveAck"
+	case MsgWATCH:
+		return "msgWatch"
+	case MsgINVALIDATE:
+		return "msgInvalidate"
+	case MsgGETRspEx:
+		return "msgGETRspEx"
+	case MsgREFUSED:
+		return "msgRefused"
+	case MsgTTLReq:
+		return "msgTTLReq"
+	case MsgTTLRsp:
+		return "msgTTLRsp"
+	case MsgAPPEND:
+		return "msgAppend"
+	case MsgSADD:
+		return "msgSAdd"
+	case MsgPUTEx:
+		return "msgPUTEx"
+	case MsgCLOSE:
+		return "msgClose"
+	case MsgAPPENDBytes:
+		return "msgAppendBytes"
+	case MsgSETNXReq:
+		return "msgSetNXReq"
+	case MsgSETNXRsp:
+		return "msgSetNXRsp"
+	case MsgSTATSSubscribe:
+		return "msgStatsSubscribe"
+	case MsgSTATSPush:
+		return "msgStatsPush"
+	case MsgPUTAckReq:
+		return "msgPUTAckReq"
+	case MsgPUTAck:
+		return "msgPUTAck"
+	case MsgPUTStamped:
+		return "msgPUTStamped"
+	case MsgSNAPSHOTReq:
+		return "msgSnapshotReq"
+	case MsgSNAPSHOTRsp:
+		return "msgSnapshotRsp"
+	case MsgCONFIG:
+		return "msgConfig"
+	case MsgEVICT:
+		return "msgEvict"
+	case MsgNOTFOUND:
+		return "msgNotFound"
+	case MsgHSET:
+		return "msgHSet"
+	case MsgHDEL:
+		return "msgHDel"
+	case MsgLIST:
+		return "msgList"
+	case MsgLISTReq:
+		return "msgListReq"
+	case MsgLISTRsp:
+		return "msgListRsp"
+	case MsgKEYSReq:
+		return "msgKeysReq"
+	case MsgKEYSRsp:
+		return "msgKeysRsp"
+	case MsgSTATSReq:
+		return "msgStatsReq"
+	case MsgSTATSRsp:
+		return "msgStatsRsp"
+	case MsgCOUNTER:
+		return "msgCounter"
 	}
 
 	return "unknown"
 }
+
+//MsgMinVersion returns the protocol version required to send or receive a message code
+func MsgMinVersion(code uint16) uint16 {
+	if v, ok := msgMinVersion[code]; ok {
+		return v
+	}
+
+	return ProtocolVersion1
+}
+
+//NegotiateVersion returns the highest protocol version spoken by both the local and remote node.
+//nodes that predate version negotiation advertise 0 and are treated as ProtocolVersion1
+func NegotiateVersion(local, remote uint16) uint16 {
+	if remote < ProtocolVersion1 {
+		remote = ProtocolVersion1
+	}
+
+	if remote < local {
+		return remote
+	}
+
+	return local
+}
@@ -4,8 +4,9 @@ import "encoding/json"
 
 //DeleteMessage is the struct message that defines which data to delete
 type DeleteMessage struct {
-	Code uint16 `json:"code"`
-	Key  string `json:"key"`
+	Code      uint16 `json:"code"`
+	Key       string `json:"key"`
+	Timestamp uint64 `json:"timestamp,omitempty"` //hybrid logical clock time of the delete, 0 when it is not ordered
 }
 
 //Serialize delete message to node wire message
@@ -0,0 +1,21 @@
+package message
+
+import "encoding/json"
+
+//EvictMessage carries keys a node evicted from its local cache, for the remote nodes to delete their copies
+type EvictMessage struct {
+	Code uint16   `json:"code"`
+	Keys []string `json:"keys"`
+}
+
+//Serialize evict message to node wire message
+func (em *EvictMessage) Serialize() *NodeWireMessage {
+	em.Code = MsgEVICT
+	data, _ := json.Marshal(em)
+	return &NodeWireMessage{Code: MsgEVICT, Data: data}
+}
+
+//DeSerialize node wire message into evict message
+func (em *EvictMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, em)
+}
@@ -0,0 +1,21 @@
+//go:build gofuzz
+// +build gofuzz
+
+package message
+
+//Fuzz is the go-fuzz entry point. data is a frame as it is read off the network
+func Fuzz(data []byte) int {
+	if len(data) < FrameHeaderSize {
+		return 0
+	}
+
+	code, length, err := ParseFrameHeader(data)
+	if err != nil || int(length) != len(data)-FrameHeaderSize {
+		return 0
+	}
+
+	if _, err := Decode(&NodeWireMessage{Code: code, Data: data[FrameHeaderSize:]}); err != nil {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,93 @@
+package message
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func FuzzDecode(f *testing.F) {
+	seeds := []NodeMessage{
+		&PutMessage{Key: "key_1", Expiry: 1500000000, Data: []byte("data_1")},
+		&PutMessage{Key: "key_1", Expiry: 1500000000, Data: []byte("data_1"), RequestId: "req_1"},
+		&PutMessage{Key: "key_1", Expiry: 1500000000, Data: []byte("data_1"), Timestamp: 1 << 40},
+		&GetRspMessage{PendingKey: "key_1abc", Data: []byte("data_1")},
+		&GetRspMessage{PendingKey: "key_1abc", Data: []byte("data_1"), WithExpiry: true, Expiry: 1500000000},
+		&GetReqMessage{Key: "key_1", PendingKey: "key_1abc"},
+		&VerifyMessage{Id: "node_1", ServicePort: "9911", ProtocolVersion: ProtocolVersion},
+		&SyncRspMessage{ReplicationFactor: 1, List: []ProposedPeer{{Id: "node_2", IpAddress: "10.0.0.2:9911"}}},
+		&GossipMessage{Members: []GossipMember{{Id: "node_1", Address: "10.0.0.1:9911", Incarnation: 1}}},
+	}
+	for _, seed := range seeds {
+		msg := seed.Serialize()
+		f.Add(msg.Code, msg.Data)
+	}
+
+	f.Fuzz(func(t *testing.T, code uint16, data []byte) {
+		msg := &NodeWireMessage{Code: code, Data: data}
+		m, err := Decode(msg)
+		if err == nil && m == nil {
+			t.Fatal("decoding without an error ought to return a message")
+		}
+		if newMessage(code) != nil { //deserializing directly must not panic either
+			newMessage(code).DeSerialize(msg)
+		}
+	})
+}
+
+func TestParseFrameHeader(t *testing.T) {
+	header := make([]byte, FrameHeaderSize)
+	binary.LittleEndian.PutUint32(header, 1)
+	if _, _, err := ParseFrameHeader(header); err != ErrFrameTooShort {
+		t.Error("length that does not cover the message code ought to be rejected")
+	}
+
+	binary.LittleEndian.PutUint32(header, MaxFrameSize+1)
+	if _, _, err := ParseFrameHeader(header); err != ErrFrameTooLarge {
+		t.Error("length over MaxFrameSize ought to be rejected")
+	}
+
+	binary.LittleEndian.PutUint32(header, 12)
+	binary.LittleEndian.PutUint16(header[4:], MsgPUT)
+	if code, length, err := ParseFrameHeader(header); err != nil || code != MsgPUT || length != 10 {
+		t.Error("well formed header ought to be parsed")
+	}
+	if _, _, err := ParseFrameHeaderMax(header, 11); err != ErrFrameTooLarge {
+		t.Error("length over the limit given ought to be rejected")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	short := &NodeWireMessage{Code: MsgPUT, Data: []byte{1, 2, 3}}
+	if Validate(short) == nil {
+		t.Error("put message too short for its header ought to be malformed")
+	}
+
+	put := (&PutMessage{Key: "key_1", Data: []byte("data_1")}).Serialize()
+	binary.LittleEndian.PutUint16(put.Data[8:], 200)
+	if Validate(put) == nil {
+		t.Error("put message with a key longer than the message ought to be malformed")
+	}
+	(&PutMessage{}).DeSerialize(put)
+
+	putEx := (&PutMessage{Key: "key_1", Data: []byte("data_1"), RequestId: "req_1"}).Serialize()
+	putEx.Data[8] = 200
+	if Validate(putEx) == nil {
+		t.Error("put message with a request id longer than the message ought to be malformed")
+	}
+	(&PutMessage{}).DeSerialize(putEx)
+
+	stamped := (&PutMessage{Key: "key_1", Data: []byte("data_1"), Timestamp: 1 << 40}).Serialize()
+	if Validate(stamped) != nil {
+		t.Error("put message with a timestamp ought to be well formed")
+	}
+	if Validate(&NodeWireMessage{Code: MsgPUTStamped, Data: stamped.Data[:12]}) == nil {
+		t.Error("put message too short for its timestamp ought to be malformed")
+	}
+
+	if Validate(&NodeWireMessage{Code: MsgSyncRsp, Data: []byte("{\"list\":[")}) == nil {
+		t.Error("truncated json ought to be malformed")
+	}
+	if Validate(&NodeWireMessage{Code: 9999, Data: []byte{1}}) != nil {
+		t.Error("unknown message codes are left to the receiver")
+	}
+}
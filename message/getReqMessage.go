@@ -7,6 +7,8 @@ type GetReqMessage struct {
 	Code       uint16 `json:"code"`
 	Key        string `json:"key"`
 	PendingKey string `json:"pending_key"`
+	WithExpiry bool   `json:"with_expiry,omitempty"` //reply with the expiry of the key as well, needs ProtocolVersion2
+	RequestId  string `json:"request_id,omitempty"`  //correlation id logged by both nodes, ignored by older ones
 }
 
 //Serialize get request message to node wire message
@@ -4,30 +4,52 @@ import (
 	"encoding/binary"
 )
 
-//GetRspMessage is the struct for the response message for a remote get
+//GetRspMessage is the struct for the response message for a remote get. when the request asked for the
+//expiry it is sent as a MsgGETRspEx message, which carries the expiry in front of the key
 type GetRspMessage struct {
 	Code       uint16 `json:"code"`
 	PendingKey string `json:"pending_key"`
 	Data       []byte `json:"data"`
+	WithExpiry bool   `json:"with_expiry"`
+	Expiry     uint64 `json:"expiry"`
 }
 
 //Serialize get response message to node wire message
 func (gm *GetRspMessage) Serialize() *NodeWireMessage {
 	msg := &NodeWireMessage{Code: MsgGETRsp}
+	offset := 0
+	if gm.WithExpiry {
+		msg.Code = MsgGETRspEx
+		offset = 8
+	}
 	bKey := []byte(gm.PendingKey)
 	keyLen := len(bKey)
-	msg.Data = make([]byte, keyLen+len(gm.Data)+2) //2 is needed for the size of the key
-	binary.LittleEndian.PutUint16(msg.Data, uint16(keyLen))
-	copy(msg.Data[2:], bKey)
-	copy(msg.Data[(2+keyLen):], gm.Data)
+	msg.Data = make([]byte, offset+keyLen+len(gm.Data)+2) //2 is needed for the size of the key
+	if gm.WithExpiry {
+		binary.LittleEndian.PutUint64(msg.Data, gm.Expiry)
+	}
+	binary.LittleEndian.PutUint16(msg.Data[offset:], uint16(keyLen))
+	copy(msg.Data[(offset+2):], bKey)
+	copy(msg.Data[(offset+2+keyLen):], gm.Data)
 
 	return msg
 }
 
-//DeSerialize node wire message into get response message
+//DeSerialize node wire message into get response message, a malformed message leaves it empty
 func (gm *GetRspMessage) DeSerialize(msg *NodeWireMessage) {
-	gm.Code = MsgGETRsp
-	keyLen := binary.LittleEndian.Uint16(msg.Data)
-	gm.PendingKey = string(msg.Data[2:(2 + keyLen)])
-	gm.Data = msg.Data[(2 + keyLen):]
+	gm.Code = msg.Code
+	offset := 0
+	if msg.Code == MsgGETRspEx {
+		gm.WithExpiry = true
+		offset = 8
+	}
+	if checkKeyed(msg.Data, offset) != "" {
+		return
+	}
+	if gm.WithExpiry {
+		gm.Expiry = binary.LittleEndian.Uint64(msg.Data)
+	}
+	keyLen := int(binary.LittleEndian.Uint16(msg.Data[offset:]))
+	gm.PendingKey = string(msg.Data[(offset + 2):(offset + 2 + keyLen)])
+	gm.Data = msg.Data[(offset + 2 + keyLen):]
 }
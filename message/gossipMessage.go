@@ -0,0 +1,30 @@
+package message
+
+import "encoding/json"
+
+//GossipMember is a node's entry in a membership digest
+type GossipMember struct {
+	Id          string `json:"id"`
+	Address     string `json:"address"`
+	Mode        byte   `json:"mode"`
+	Incarnation uint64 `json:"incarnation"` //only ever raised by the node itself, to refute suspicions about it
+	State       byte   `json:"state"`
+}
+
+//GossipMessage carries the sender's view of the cluster membership
+type GossipMessage struct {
+	Code    uint16         `json:"code"`
+	Members []GossipMember `json:"members"`
+}
+
+//Serialize gossip message to node wire message
+func (gm *GossipMessage) Serialize() *NodeWireMessage {
+	gm.Code = MsgGossip
+	data, _ := json.Marshal(gm)
+	return &NodeWireMessage{Code: MsgGossip, Data: data}
+}
+
+//DeSerialize node wire message into gossip message
+func (gm *GossipMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, gm)
+}
@@ -0,0 +1,42 @@
+package message
+
+import "encoding/json"
+
+//HSetMessage sets fields of the hash under a key
+type HSetMessage struct {
+	Code   uint16            `json:"code"`
+	Key    string            `json:"key"`
+	Fields map[string][]byte `json:"fields"`
+	Expiry uint64            `json:"expiry"` //of the hash if it has to be created
+}
+
+//Serialize hash set message to node wire message
+func (hm *HSetMessage) Serialize() *NodeWireMessage {
+	hm.Code = MsgHSET
+	data, _ := json.Marshal(hm)
+	return &NodeWireMessage{Code: MsgHSET, Data: data}
+}
+
+//DeSerialize node wire message into hash set message
+func (hm *HSetMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, hm)
+}
+
+//HDelMessage removes fields from the hash under a key
+type HDelMessage struct {
+	Code   uint16   `json:"code"`
+	Key    string   `json:"key"`
+	Fields []string `json:"fields"`
+}
+
+//Serialize hash delete message to node wire message
+func (hm *HDelMessage) Serialize() *NodeWireMessage {
+	hm.Code = MsgHDEL
+	data, _ := json.Marshal(hm)
+	return &NodeWireMessage{Code: MsgHDEL, Data: data}
+}
+
+//DeSerialize node wire message into hash delete message
+func (hm *HDelMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, hm)
+}
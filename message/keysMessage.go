@@ -0,0 +1,47 @@
+package message
+
+import "encoding/json"
+
+//KeysReqMessage asks a remoteNode for the keys of its local cache with a prefix, at most Limit of them in order
+//after the key After, or for the number of them when Count is set. it is answered with a KeysRspMessage
+type KeysReqMessage struct {
+	Code       uint16 `json:"code"`
+	Prefix     string `json:"prefix"`
+	After      string `json:"after,omitempty"`
+	Limit      int    `json:"limit,omitempty"`
+	Count      bool   `json:"count,omitempty"`
+	PendingKey string `json:"pending_key"`
+}
+
+//Serialize keys request message to node wire message
+func (km *KeysReqMessage) Serialize() *NodeWireMessage {
+	km.Code = MsgKEYSReq
+	data, _ := json.Marshal(km)
+	return &NodeWireMessage{Code: MsgKEYSReq, Data: data}
+}
+
+//DeSerialize node wire message into keys request message
+func (km *KeysReqMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, km)
+}
+
+//KeysRspMessage is the keys with a prefix a remoteNode holds, or their number
+type KeysRspMessage struct {
+	Code       uint16   `json:"code"`
+	PendingKey string   `json:"pending_key"`
+	Keys       []string `json:"keys,omitempty"`
+	Count      int      `json:"count,omitempty"`
+	Error      string   `json:"error,omitempty"` //why the request failed, empty when it did not
+}
+
+//Serialize keys response message to node wire message
+func (km *KeysRspMessage) Serialize() *NodeWireMessage {
+	km.Code = MsgKEYSRsp
+	data, _ := json.Marshal(km)
+	return &NodeWireMessage{Code: MsgKEYSRsp, Data: data}
+}
+
+//DeSerialize node wire message into keys response message
+func (km *KeysRspMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, km)
+}
@@ -0,0 +1,49 @@
+package message
+
+import "encoding/json"
+
+//LeaveEntry is a key handed over by a node leaving the cluster
+type LeaveEntry struct {
+	Key    string `json:"key"`
+	Data   []byte `json:"data"`
+	Expiry uint64 `json:"expiry"`
+}
+
+//LeaveMessage hands a batch of keys over to a remoteNode before the sender leaves the cluster. the last
+//batch has Final set, it announces the sender is about to close its connections for good
+type LeaveMessage struct {
+	Code    uint16       `json:"code"`
+	Seq     uint64       `json:"seq"`
+	Entries []LeaveEntry `json:"entries"`
+	Final   bool         `json:"final"`
+}
+
+//Serialize leave message to node wire message
+func (lm *LeaveMessage) Serialize() *NodeWireMessage {
+	lm.Code = MsgLEAVE
+	data, _ := json.Marshal(lm)
+	return &NodeWireMessage{Code: MsgLEAVE, Data: data}
+}
+
+//DeSerialize node wire message into leave message
+func (lm *LeaveMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, lm)
+}
+
+//LeaveAckMessage acknowledges a batch of keys has been stored
+type LeaveAckMessage struct {
+	Code uint16 `json:"code"`
+	Seq  uint64 `json:"seq"`
+}
+
+//Serialize leave ack message to node wire message
+func (lm *LeaveAckMessage) Serialize() *NodeWireMessage {
+	lm.Code = MsgLEAVEAck
+	data, _ := json.Marshal(lm)
+	return &NodeWireMessage{Code: MsgLEAVEAck, Data: data}
+}
+
+//DeSerialize node wire message into leave ack message
+func (lm *LeaveAckMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, lm)
+}
@@ -0,0 +1,57 @@
+package message
+
+import "encoding/json"
+
+//Operations of a ListMessage
+const (
+	ListOpPush byte = iota + 1 //add the items to the head of the list
+	ListOpPop                  //remove the last item of the list
+)
+
+//ListMessage pushes items to or pops an item from the list under a key. when it carries a pending key it is sent
+//as a MsgLISTReq message, asking the remoteNode owning the key to do it and answer with a ListRspMessage,
+//otherwise as a MsgLIST message replicating what the owner did
+type ListMessage struct {
+	Code       uint16   `json:"code"`
+	Key        string   `json:"key"`
+	Op         byte     `json:"op"`
+	Items      [][]byte `json:"items,omitempty"`
+	MaxLen     int      `json:"max_len,omitempty"`
+	Expiry     uint64   `json:"expiry,omitempty"` //of the list if a push has to create it
+	PendingKey string   `json:"pending_key,omitempty"`
+}
+
+//Serialize list message to node wire message
+func (lm *ListMessage) Serialize() *NodeWireMessage {
+	lm.Code = MsgLIST
+	if lm.PendingKey != "" {
+		lm.Code = MsgLISTReq
+	}
+	data, _ := json.Marshal(lm)
+	return &NodeWireMessage{Code: lm.Code, Data: data}
+}
+
+//DeSerialize node wire message into list message
+func (lm *ListMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, lm)
+}
+
+//ListRspMessage is what the remoteNode owning a key did with a list request
+type ListRspMessage struct {
+	Code       uint16 `json:"code"`
+	PendingKey string `json:"pending_key"`
+	Item       []byte `json:"item,omitempty"`  //the item popped
+	Error      string `json:"error,omitempty"` //why the request failed, empty when it did not
+}
+
+//Serialize list response message to node wire message
+func (lm *ListRspMessage) Serialize() *NodeWireMessage {
+	lm.Code = MsgLISTRsp
+	data, _ := json.Marshal(lm)
+	return &NodeWireMessage{Code: MsgLISTRsp, Data: data}
+}
+
+//DeSerialize node wire message into list response message
+func (lm *ListRspMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, lm)
+}
@@ -13,6 +13,23 @@ func TestPutMessage(t *testing.T) {
 	if !reflect.DeepEqual(msg, newMsg) {
 		t.Error("PutMessage serialization and deserialization not working properly")
 	}
+
+	msg = PutMessage{Code: MsgPUTEx, Expiry: uint64(time.Now().Unix()), Key: "key_2", Data: []byte("data_B"), RequestId: "req_1"}
+	newMsg = PutMessage{}
+	newMsg.DeSerialize(msg.Serialize())
+	if !reflect.DeepEqual(msg, newMsg) {
+		t.Error("PutMessage with a request id serialization and deserialization not working properly")
+	}
+
+	for _, requestId := range []string{"", "req_2"} {
+		msg = PutMessage{Code: MsgPUTStamped, Expiry: uint64(time.Now().Unix()), Key: "key_3", Data: []byte("data_C"),
+			RequestId: requestId, Timestamp: uint64(time.Now().UnixNano())}
+		newMsg = PutMessage{}
+		newMsg.DeSerialize(msg.Serialize())
+		if !reflect.DeepEqual(msg, newMsg) {
+			t.Errorf("PutMessage with a timestamp and request id %q serialization and deserialization not working properly", requestId)
+		}
+	}
 }
 
 func TestSyncReqMessage(t *testing.T) {
@@ -41,7 +58,7 @@ func TestSyncRspMessage(t *testing.T) {
 }
 
 func TestVerifyMessage(t *testing.T) {
-	msg := VerifyMessage{Id: "id_node", Version: "1.02", ServicePort: "9090"}
+	msg := VerifyMessage{Id: "id_node", Version: "1.02", ServicePort: "9090", ProtocolVersion: ProtocolVersion, AdvertisedHost: "10.0.0.1", Role: 1}
 	newMsg := VerifyMessage{}
 	newMsg.DeSerialize(msg.Serialize())
 	if !reflect.DeepEqual(msg, newMsg) {
@@ -50,7 +67,7 @@ func TestVerifyMessage(t *testing.T) {
 }
 
 func TestDeleteMessage(t *testing.T) {
-	msg := DeleteMessage{Key: "key_ab"}
+	msg := DeleteMessage{Key: "key_ab", Timestamp: 1 << 20}
 	newMsg := DeleteMessage{}
 	newMsg.DeSerialize(msg.Serialize())
 	if !reflect.DeepEqual(msg, newMsg) {
@@ -78,4 +95,349 @@ func TestGetRspMessage(t *testing.T) {
 	if newMsg.Data == nil {
 		t.Log("data is nil")
 	}
+
+	msg = GetRspMessage{Code: MsgGETRspEx, PendingKey: "pending_key_6", Data: []byte("data_6"), WithExpiry: true, Expiry: 1500000000}
+	newMsg = GetRspMessage{}
+	newMsg.DeSerialize(msg.Serialize())
+	if !reflect.DeepEqual(msg, newMsg) {
+		t.Error("GetRspMessage with expiry serialization and deserialization not working properly")
+	}
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	if NegotiateVersion(ProtocolVersion, 0) != ProtocolVersion1 {
+		t.Error("a peer without a protocol version ought to be treated as version 1")
+	}
+
+	if NegotiateVersion(3, 2) != 2 || NegotiateVersion(2, 3) != 2 {
+		t.Error("the highest common protocol version ought to be chosen")
+	}
+
+	if MsgMinVersion(MsgPUT) != ProtocolVersion1 {
+		t.Error("MsgPUT is part of the first protocol version")
+	}
+}
+
+func TestAttachMessage(t *testing.T) {
+	msg := AttachMessage{Code: MsgATTACH, Id: "node_1", Token: "token_1"}
+	newMsg := AttachMessage{}
+	newMsg.DeSerialize(msg.Serialize())
+	if !reflect.DeepEqual(msg, newMsg) {
+		t.Error("AttachMessage serialization and deserialization not working properly")
+	}
+}
+
+func TestVerifyOKMessage(t *testing.T) {
+	msg := VerifyOKMessage{LaneToken: "token_1"}
+	newMsg := VerifyOKMessage{}
+	newMsg.DeSerialize(msg.Serialize())
+	if !reflect.DeepEqual(msg, newMsg) {
+		t.Error("VerifyOKMessage serialization and deserialization not working properly")
+	}
+	if (&VerifyOKMessage{}).Serialize().Data != nil {
+		t.Error("VerifyOKMessage without a lane token ought to be sent as older nodes send it")
+	}
+}
+
+func TestPrimeReqMessage(t *testing.T) {
+	msg := PrimeReqMessage{Code: MsgPrimeReq, ByteBudget: 4096}
+	newMsg := PrimeReqMessage{}
+	newMsg.DeSerialize(msg.Serialize())
+	if !reflect.DeepEqual(msg, newMsg) {
+		t.Error("PrimeReqMessage serialization and deserialization not working properly")
+	}
+}
+
+func TestAuditMessages(t *testing.T) {
+	req := AuditReqMessage{Code: MsgAuditReq, Key: "key_1", PendingKey: "key_1abc"}
+	newReq := AuditReqMessage{}
+	newReq.DeSerialize(req.Serialize())
+	if !reflect.DeepEqual(req, newReq) {
+		t.Error("AuditReqMessage serialization and deserialization not working properly")
+	}
+
+	rsp := AuditRspMessage{Code: MsgAuditRsp, PendingKey: "key_1abc", Found: true, Expiry: 1500000000, Size: 6, Checksum: 0xCAFE}
+	newRsp := AuditRspMessage{}
+	newRsp.DeSerialize(rsp.Serialize())
+	if !reflect.DeepEqual(rsp, newRsp) {
+		t.Error("AuditRspMessage serialization and deserialization not working properly")
+	}
+}
+
+func TestGossipMessage(t *testing.T) {
+	msg := GossipMessage{Code: MsgGossip, Members: []GossipMember{
+		{Id: "node_1", Address: "10.0.0.1:9911", Incarnation: 3},
+		{Id: "node_2", Address: "10.0.0.2:9911", Mode: 1, Incarnation: 7, State: 2},
+	}}
+	newMsg := GossipMessage{}
+	newMsg.DeSerialize(msg.Serialize())
+	if !reflect.DeepEqual(msg, newMsg) {
+		t.Error("GossipMessage serialization and deserialization not working properly")
+	}
+}
+
+func TestLeaveMessage(t *testing.T) {
+	msg := LeaveMessage{Code: MsgLEAVE, Seq: 2, Final: true, Entries: []LeaveEntry{
+		{Key: "key_1", Data: []byte("data_1"), Expiry: 1500000000},
+		{Key: "key_2", Data: []byte("data_2")},
+	}}
+	newMsg := LeaveMessage{}
+	newMsg.DeSerialize(msg.Serialize())
+	if !reflect.DeepEqual(msg, newMsg) {
+		t.Error("LeaveMessage serialization and deserialization not working properly")
+	}
+
+	ack := LeaveAckMessage{Code: MsgLEAVEAck, Seq: 2}
+	newAck := LeaveAckMessage{}
+	newAck.DeSerialize(ack.Serialize())
+	if !reflect.DeepEqual(ack, newAck) {
+		t.Error("LeaveAckMessage serialization and deserialization not working properly")
+	}
+}
+
+func TestWatchMessage(t *testing.T) {
+	msg := WatchMessage{Code: MsgWATCH, Prefixes: []string{"session:", "user:"}}
+	newMsg := WatchMessage{}
+	newMsg.DeSerialize(msg.Serialize())
+	if !reflect.DeepEqual(msg, newMsg) {
+		t.Error("WatchMessage serialization and deserialization not working properly")
+	}
+
+	inv := InvalidateMessage{Code: MsgINVALIDATE, Key: "session:1", Deleted: true}
+	newInv := InvalidateMessage{}
+	newInv.DeSerialize(inv.Serialize())
+	if !reflect.DeepEqual(inv, newInv) {
+		t.Error("InvalidateMessage serialization and deserialization not working properly")
+	}
+}
+
+func TestCloseMessage(t *testing.T) {
+	msg := CloseMessage{Code: MsgCLOSE, Reason: CloseReasonIdle}
+	newMsg := CloseMessage{}
+	newMsg.DeSerialize(msg.Serialize())
+	if !reflect.DeepEqual(msg, newMsg) {
+		t.Error("CloseMessage serialization and deserialization not working properly")
+	}
+}
+
+func TestRefusedMessage(t *testing.T) {
+	msg := RefusedMessage{Code: MsgREFUSED, MsgCode: MsgPUT, Key: "key_1", Reason: "read only"}
+	newMsg := RefusedMessage{}
+	newMsg.DeSerialize(msg.Serialize())
+	if !reflect.DeepEqual(msg, newMsg) {
+		t.Error("RefusedMessage serialization and deserialization not working properly")
+	}
+}
+
+func TestTTLMessage(t *testing.T) {
+	req := TTLReqMessage{Code: MsgTTLReq, Key: "key_1", PendingKey: "key_1abcdefgh"}
+	newReq := TTLReqMessage{}
+	newReq.DeSerialize(req.Serialize())
+	if !reflect.DeepEqual(req, newReq) {
+		t.Error("TTLReqMessage serialization and deserialization not working properly")
+	}
+
+	rsp := TTLRspMessage{Code: MsgTTLRsp, PendingKey: "key_1abcdefgh", Found: true, TTL: int64(90e9)}
+	newRsp := TTLRspMessage{}
+	newRsp.DeSerialize(rsp.Serialize())
+	if !reflect.DeepEqual(rsp, newRsp) {
+		t.Error("TTLRspMessage serialization and deserialization not working properly")
+	}
+}
+
+func TestSAddMessage(t *testing.T) {
+	msg := SAddMessage{Code: MsgSADD, Key: "key_1", Members: [][]byte{[]byte("a"), []byte("b")}, Expiry: 1234}
+	newMsg := SAddMessage{}
+	newMsg.DeSerialize(msg.Serialize())
+	if !reflect.DeepEqual(msg, newMsg) {
+		t.Error("SAddMessage serialization and deserialization not working properly")
+	}
+}
+
+func TestAppendBytesMessage(t *testing.T) {
+	msg := AppendBytesMessage{Code: MsgAPPENDBytes, Key: "key_1", Data: []byte("data"), Expiry: 1234}
+	newMsg := AppendBytesMessage{}
+	newMsg.DeSerialize(msg.Serialize())
+	if !reflect.DeepEqual(msg, newMsg) {
+		t.Error("AppendBytesMessage serialization and deserialization not working properly")
+	}
+}
+
+func TestSetIfAbsentMessage(t *testing.T) {
+	req := SetIfAbsentReqMessage{Code: MsgSETNXReq, Key: "key_1", Data: []byte("data"), Expiry: 1234, PendingKey: "key_1abcdefgh"}
+	newReq := SetIfAbsentReqMessage{}
+	newReq.DeSerialize(req.Serialize())
+	if !reflect.DeepEqual(req, newReq) {
+		t.Error("SetIfAbsentReqMessage serialization and deserialization not working properly")
+	}
+
+	rsp := SetIfAbsentRspMessage{Code: MsgSETNXRsp, PendingKey: "key_1abcdefgh", Stored: true}
+	newRsp := SetIfAbsentRspMessage{}
+	newRsp.DeSerialize(rsp.Serialize())
+	if !reflect.DeepEqual(rsp, newRsp) {
+		t.Error("SetIfAbsentRspMessage serialization and deserialization not working properly")
+	}
+}
+
+func TestPutAckMessages(t *testing.T) {
+	req := PutAckReqMessage{Code: MsgPUTAckReq, Key: "key_1", Data: []byte("data"), Expiry: 1234, PendingKey: "key_1abcdefgh", RequestId: "abcd",
+		Timestamp: 1 << 40}
+	newReq := PutAckReqMessage{}
+	newReq.DeSerialize(req.Serialize())
+	if !reflect.DeepEqual(req, newReq) {
+		t.Error("PutAckReqMessage serialization and deserialization not working properly")
+	}
+
+	ack := PutAckMessage{Code: MsgPUTAck, PendingKey: "key_1abcdefgh", Error: "read only"}
+	newAck := PutAckMessage{}
+	newAck.DeSerialize(ack.Serialize())
+	if !reflect.DeepEqual(ack, newAck) {
+		t.Error("PutAckMessage serialization and deserialization not working properly")
+	}
+}
+
+func TestStatsMessages(t *testing.T) {
+	sub := StatsSubscribeMessage{Code: MsgSTATSSubscribe, Interval: 1000}
+	newSub := StatsSubscribeMessage{}
+	newSub.DeSerialize(sub.Serialize())
+	if !reflect.DeepEqual(sub, newSub) {
+		t.Error("StatsSubscribeMessage serialization and deserialization not working properly")
+	}
+
+	push := StatsPushMessage{Code: MsgSTATSPush, Stats: []byte(`{"id":"node_1","entries":10}`)}
+	newPush := StatsPushMessage{}
+	newPush.DeSerialize(push.Serialize())
+	if !reflect.DeepEqual(push, newPush) {
+		t.Error("StatsPushMessage serialization and deserialization not working properly")
+	}
+
+	req := StatsReqMessage{Code: MsgSTATSReq, Shards: true, PendingKey: "pending"}
+	newReq := StatsReqMessage{}
+	newReq.DeSerialize(req.Serialize())
+	if !reflect.DeepEqual(req, newReq) {
+		t.Error("StatsReqMessage serialization and deserialization not working properly")
+	}
+
+	rsp := StatsRspMessage{Code: MsgSTATSRsp, PendingKey: "pending", Stats: []byte(`{"id":"node_1"}`), Shards: []byte(`[{"Entries":10}]`)}
+	newRsp := StatsRspMessage{}
+	newRsp.DeSerialize(rsp.Serialize())
+	if !reflect.DeepEqual(rsp, newRsp) {
+		t.Error("StatsRspMessage serialization and deserialization not working properly")
+	}
+}
+
+func TestSnapshotMessages(t *testing.T) {
+	req := SnapshotReqMessage{Code: MsgSNAPSHOTReq, Id: "1234", PendingKey: "1234abcdefgh", Phase: SnapshotPhaseWrite, Dir: "/tmp",
+		Expected: map[string]uint64{"node_1": 10, "node_2": 0}}
+	newReq := SnapshotReqMessage{}
+	newReq.DeSerialize(req.Serialize())
+	if !reflect.DeepEqual(req, newReq) {
+		t.Error("SnapshotReqMessage serialization and deserialization not working properly")
+	}
+
+	rsp := SnapshotRspMessage{Code: MsgSNAPSHOTRsp, PendingKey: "1234abcdefgh", Sent: map[string]uint64{"node_1": 3}, File: "/tmp/1234-node_2.snap",
+		Entries: 42, Error: "timed out"}
+	newRsp := SnapshotRspMessage{}
+	newRsp.DeSerialize(rsp.Serialize())
+	if !reflect.DeepEqual(rsp, newRsp) {
+		t.Error("SnapshotRspMessage serialization and deserialization not working properly")
+	}
+}
+
+func TestConfigMessage(t *testing.T) {
+	msg := ConfigMessage{Code: MsgCONFIG, ReplicationFactor: 3}
+	newMsg := ConfigMessage{}
+	newMsg.DeSerialize(msg.Serialize())
+	if !reflect.DeepEqual(msg, newMsg) {
+		t.Error("ConfigMessage serialization and deserialization not working properly")
+	}
+}
+
+func TestEvictMessage(t *testing.T) {
+	msg := EvictMessage{Code: MsgEVICT, Keys: []string{"key_1", "key_2"}}
+	newMsg := EvictMessage{}
+	newMsg.DeSerialize(msg.Serialize())
+	if !reflect.DeepEqual(msg, newMsg) {
+		t.Error("EvictMessage serialization and deserialization not working properly")
+	}
+}
+
+func TestNotFoundMessage(t *testing.T) {
+	msg := NotFoundMessage{Code: MsgNOTFOUND, Key: "key_1", Expiry: 1234}
+	newMsg := NotFoundMessage{}
+	newMsg.DeSerialize(msg.Serialize())
+	if !reflect.DeepEqual(msg, newMsg) {
+		t.Error("NotFoundMessage serialization and deserialization not working properly")
+	}
+}
+
+func TestHashMessages(t *testing.T) {
+	msg := HSetMessage{Code: MsgHSET, Key: "key_1", Fields: map[string][]byte{"a": []byte("1"), "b": []byte("2")}, Expiry: 1234}
+	newMsg := HSetMessage{}
+	newMsg.DeSerialize(msg.Serialize())
+	if !reflect.DeepEqual(msg, newMsg) {
+		t.Error("HSetMessage serialization and deserialization not working properly")
+	}
+
+	delMsg := HDelMessage{Code: MsgHDEL, Key: "key_1", Fields: []string{"a", "b"}}
+	newDelMsg := HDelMessage{}
+	newDelMsg.DeSerialize(delMsg.Serialize())
+	if !reflect.DeepEqual(delMsg, newDelMsg) {
+		t.Error("HDelMessage serialization and deserialization not working properly")
+	}
+}
+
+func TestCounterMessage(t *testing.T) {
+	msg := CounterMessage{Code: MsgCOUNTER, Key: "key_1", Counts: map[string]uint64{"node_1": 3, "node_2": 5}, Expiry: 1234}
+	newMsg := CounterMessage{}
+	newMsg.DeSerialize(msg.Serialize())
+	if !reflect.DeepEqual(msg, newMsg) {
+		t.Error("CounterMessage serialization and deserialization not working properly")
+	}
+}
+
+func TestListMessages(t *testing.T) {
+	msg := ListMessage{Code: MsgLISTReq, Key: "key_1", Op: ListOpPush, Items: [][]byte{[]byte("a")}, MaxLen: 10, Expiry: 1234,
+		PendingKey: "pending"}
+	newMsg := ListMessage{}
+	newMsg.DeSerialize(msg.Serialize())
+	if !reflect.DeepEqual(msg, newMsg) {
+		t.Error("ListMessage serialization and deserialization not working properly")
+	}
+	if wire := (&ListMessage{Key: "key_1", Op: ListOpPop}).Serialize(); wire.Code != MsgLIST {
+		t.Errorf("expected a list message without pending key sent as %s, got %s", MsgCodeToString(MsgLIST),
+			MsgCodeToString(wire.Code))
+	}
+
+	rspMsg := ListRspMessage{Code: MsgLISTRsp, PendingKey: "pending", Item: []byte("a"), Error: "failed"}
+	newRspMsg := ListRspMessage{}
+	newRspMsg.DeSerialize(rspMsg.Serialize())
+	if !reflect.DeepEqual(rspMsg, newRspMsg) {
+		t.Error("ListRspMessage serialization and deserialization not working properly")
+	}
+}
+
+func TestKeysMessages(t *testing.T) {
+	msg := KeysReqMessage{Code: MsgKEYSReq, Prefix: "user:", After: "user:1", Limit: 10, Count: true, PendingKey: "pending"}
+	newMsg := KeysReqMessage{}
+	newMsg.DeSerialize(msg.Serialize())
+	if !reflect.DeepEqual(msg, newMsg) {
+		t.Error("KeysReqMessage serialization and deserialization not working properly")
+	}
+
+	rspMsg := KeysRspMessage{Code: MsgKEYSRsp, PendingKey: "pending", Keys: []string{"user:2"}, Count: 1, Error: "failed"}
+	newRspMsg := KeysRspMessage{}
+	newRspMsg.DeSerialize(rspMsg.Serialize())
+	if !reflect.DeepEqual(rspMsg, newRspMsg) {
+		t.Error("KeysRspMessage serialization and deserialization not working properly")
+	}
+}
+
+func TestAppendMessage(t *testing.T) {
+	msg := AppendMessage{Code: MsgAPPEND, Key: "key_1", Items: [][]byte{[]byte("a"), []byte("b")}, MaxLen: 10, Expiry: 1234}
+	newMsg := AppendMessage{}
+	newMsg.DeSerialize(msg.Serialize())
+	if !reflect.DeepEqual(msg, newMsg) {
+		t.Error("AppendMessage serialization and deserialization not working properly")
+	}
 }
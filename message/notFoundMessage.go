@@ -0,0 +1,22 @@
+package message
+
+import "encoding/json"
+
+//NotFoundMessage caches on the remote nodes that a key was not found, until the expiry
+type NotFoundMessage struct {
+	Code   uint16 `json:"code"`
+	Key    string `json:"key"`
+	Expiry uint64 `json:"expiry"`
+}
+
+//Serialize not found message to node wire message
+func (nm *NotFoundMessage) Serialize() *NodeWireMessage {
+	nm.Code = MsgNOTFOUND
+	data, _ := json.Marshal(nm)
+	return &NodeWireMessage{Code: MsgNOTFOUND, Data: data}
+}
+
+//DeSerialize node wire message into not found message
+func (nm *NotFoundMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, nm)
+}
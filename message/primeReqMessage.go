@@ -0,0 +1,22 @@
+package message
+
+import "encoding/json"
+
+//PrimeReqMessage asks a remoteNode to send its most read keys, up to ByteBudget bytes of keys and values.
+//the keys are sent back as put messages
+type PrimeReqMessage struct {
+	Code       uint16 `json:"code"`
+	ByteBudget int    `json:"byte_budget"`
+}
+
+//Serialize prime request message to node wire message
+func (pm *PrimeReqMessage) Serialize() *NodeWireMessage {
+	pm.Code = MsgPrimeReq
+	data, _ := json.Marshal(pm)
+	return &NodeWireMessage{Code: MsgPrimeReq, Data: data}
+}
+
+//DeSerialize node wire message into prime request message
+func (pm *PrimeReqMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, pm)
+}
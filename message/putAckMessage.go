@@ -0,0 +1,45 @@
+package message
+
+import "encoding/json"
+
+//PutAckReqMessage is a put the remoteNode is asked to acknowledge once it is applied, or to say why it was not
+type PutAckReqMessage struct {
+	Code       uint16 `json:"code"`
+	Key        string `json:"key"`
+	Data       []byte `json:"data"`
+	Expiry     uint64 `json:"expiry"`
+	PendingKey string `json:"pending_key"`
+	RequestId  string `json:"request_id"` //correlation id logged by both nodes, empty when not logged
+	Timestamp  uint64 `json:"timestamp"`  //hybrid logical clock time of the write, 0 when it is not ordered
+}
+
+//Serialize put acknowledgement request message to node wire message
+func (pm *PutAckReqMessage) Serialize() *NodeWireMessage {
+	pm.Code = MsgPUTAckReq
+	data, _ := json.Marshal(pm)
+	return &NodeWireMessage{Code: MsgPUTAckReq, Data: data}
+}
+
+//DeSerialize node wire message into put acknowledgement request message
+func (pm *PutAckReqMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, pm)
+}
+
+//PutAckMessage acknowledges a put asked to be acknowledged
+type PutAckMessage struct {
+	Code       uint16 `json:"code"`
+	PendingKey string `json:"pending_key"`
+	Error      string `json:"error"` //why the put was not applied, empty when it was
+}
+
+//Serialize put acknowledgement message to node wire message
+func (pm *PutAckMessage) Serialize() *NodeWireMessage {
+	pm.Code = MsgPUTAck
+	data, _ := json.Marshal(pm)
+	return &NodeWireMessage{Code: MsgPUTAck, Data: data}
+}
+
+//DeSerialize node wire message into put acknowledgement message
+func (pm *PutAckMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, pm)
+}
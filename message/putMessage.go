@@ -4,33 +4,91 @@ import (
 	"encoding/binary"
 )
 
-//PutMessage is message struct for sending data across to a remoteNode
+//PutMessage is message struct for sending data across to a remoteNode. when it carries a request id it is
+//sent as a MsgPUTEx message, which carries the request id between the expiry and the key. when it carries a
+//timestamp it is sent as a MsgPUTStamped message, which carries the timestamp right after the expiry followed
+//by the request id, empty or not
 type PutMessage struct {
-	Code   uint16 `json:"code"`
-	Key    string `json:"key"`
-	Expiry uint64 `json:"expiry"`
-	Data   []byte `json:"data"`
+	Code      uint16 `json:"code"`
+	Key       string `json:"key"`
+	Expiry    uint64 `json:"expiry"`
+	Data      []byte `json:"data"`
+	RequestId string `json:"request_id"` //correlation id logged by both nodes, at most 255 bytes
+	Timestamp uint64 `json:"timestamp"`  //hybrid logical clock time of the write, 0 when it is not ordered
 }
 
 //Serialize put message to node wire message
 func (pm *PutMessage) Serialize() *NodeWireMessage {
 	msg := &NodeWireMessage{Code: MsgPUT}
+	offset := 8
+	bId := []byte(pm.RequestId)
+	if len(bId) > 255 {
+		bId = bId[:255]
+	}
+	idOffset := 8
+	if pm.Timestamp > 0 {
+		msg.Code = MsgPUTStamped
+		idOffset += 8
+		offset = idOffset + 1 + len(bId)
+	} else if len(bId) > 0 {
+		msg.Code = MsgPUTEx
+		offset = idOffset + 1 + len(bId)
+	}
 	bKey := []byte(pm.Key)
 	keyLen := len(bKey)
-	msg.Data = make([]byte, keyLen+len(pm.Data)+2+8) //2 is needed for the size of the key while 8 is for expiry
+	msg.Data = make([]byte, offset+keyLen+len(pm.Data)+2) //2 is needed for the size of the key while 8 is for expiry
 	binary.LittleEndian.PutUint64(msg.Data, pm.Expiry)
-	binary.LittleEndian.PutUint16(msg.Data[8:], uint16(keyLen))
-	copy(msg.Data[(8+2):], bKey)
-	copy(msg.Data[(8+2+keyLen):], pm.Data)
+	if pm.Timestamp > 0 {
+		binary.LittleEndian.PutUint64(msg.Data[8:], pm.Timestamp)
+	}
+	if offset > 8 {
+		msg.Data[idOffset] = byte(len(bId))
+		copy(msg.Data[idOffset+1:], bId)
+	}
+	binary.LittleEndian.PutUint16(msg.Data[offset:], uint16(keyLen))
+	copy(msg.Data[(offset+2):], bKey)
+	copy(msg.Data[(offset+2+keyLen):], pm.Data)
 
 	return msg
 }
 
-//DeSerialize node wire message into put message
+//DeSerialize node wire message into put message, a malformed message leaves it empty
 func (pm *PutMessage) DeSerialize(msg *NodeWireMessage) {
-	pm.Code = MsgPUT
+	pm.Code = msg.Code
+	offset := putKeyOffset(msg)
+	if offset < 0 || checkKeyed(msg.Data, offset) != "" {
+		return
+	}
 	pm.Expiry = binary.LittleEndian.Uint64(msg.Data)
-	keyLen := binary.LittleEndian.Uint16(msg.Data[8:])
-	pm.Key = string(msg.Data[(8 + 2):(8 + 2 + keyLen)])
-	pm.Data = msg.Data[(8 + 2 + keyLen):]
+	idOffset := 8
+	if msg.Code == MsgPUTStamped {
+		pm.Timestamp = binary.LittleEndian.Uint64(msg.Data[8:])
+		idOffset += 8
+	}
+	if offset > idOffset+1 {
+		pm.RequestId = string(msg.Data[idOffset+1 : offset])
+	}
+	keyLen := int(binary.LittleEndian.Uint16(msg.Data[offset:]))
+	pm.Key = string(msg.Data[(offset + 2):(offset + 2 + keyLen)])
+	pm.Data = msg.Data[(offset + 2 + keyLen):]
+}
+
+//offset of the key length in the data of a put message, -1 if the request id does not fit in it
+func putKeyOffset(msg *NodeWireMessage) int {
+	idOffset := 8
+	switch msg.Code {
+	case MsgPUTEx:
+	case MsgPUTStamped:
+		idOffset += 8
+	default:
+		return 8
+	}
+	if len(msg.Data) < idOffset+1 {
+		return -1
+	}
+	offset := idOffset + 1 + int(msg.Data[idOffset])
+	if len(msg.Data) < offset {
+		return -1
+	}
+	return offset
 }
@@ -0,0 +1,23 @@
+package message
+
+import "encoding/json"
+
+//RefusedMessage tells a remoteNode a message it sent about a key was not applied, e.g because this node is read only
+type RefusedMessage struct {
+	Code    uint16 `json:"code"`
+	MsgCode uint16 `json:"msg_code"` //code of the message refused
+	Key     string `json:"key"`
+	Reason  string `json:"reason"`
+}
+
+//Serialize refused message to node wire message
+func (rm *RefusedMessage) Serialize() *NodeWireMessage {
+	rm.Code = MsgREFUSED
+	data, _ := json.Marshal(rm)
+	return &NodeWireMessage{Code: MsgREFUSED, Data: data}
+}
+
+//DeSerialize node wire message into refused message
+func (rm *RefusedMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, rm)
+}
@@ -0,0 +1,23 @@
+package message
+
+import "encoding/json"
+
+//SAddMessage adds members to the set under a key
+type SAddMessage struct {
+	Code    uint16   `json:"code"`
+	Key     string   `json:"key"`
+	Members [][]byte `json:"members"`
+	Expiry  uint64   `json:"expiry"` //of the set if it has to be created
+}
+
+//Serialize set add message to node wire message
+func (sm *SAddMessage) Serialize() *NodeWireMessage {
+	sm.Code = MsgSADD
+	data, _ := json.Marshal(sm)
+	return &NodeWireMessage{Code: MsgSADD, Data: data}
+}
+
+//DeSerialize node wire message into set add message
+func (sm *SAddMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, sm)
+}
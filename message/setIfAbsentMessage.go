@@ -0,0 +1,46 @@
+package message
+
+import "encoding/json"
+
+//SetIfAbsentReqMessage asks the remoteNode owning a key to store it unless it holds it already, or with Release
+//to delete it only if it holds Data
+type SetIfAbsentReqMessage struct {
+	Code       uint16 `json:"code"`
+	Key        string `json:"key"`
+	Data       []byte `json:"data"`
+	Expiry     uint64 `json:"expiry"`
+	PendingKey string `json:"pending_key"`
+	Release    bool   `json:"release,omitempty"`
+}
+
+//Serialize set if absent request message to node wire message
+func (sm *SetIfAbsentReqMessage) Serialize() *NodeWireMessage {
+	sm.Code = MsgSETNXReq
+	data, _ := json.Marshal(sm)
+	return &NodeWireMessage{Code: MsgSETNXReq, Data: data}
+}
+
+//DeSerialize node wire message into set if absent request message
+func (sm *SetIfAbsentReqMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, sm)
+}
+
+//SetIfAbsentRspMessage tells whether the remoteNode owning a key stored it, or deleted it when asked to release it
+type SetIfAbsentRspMessage struct {
+	Code       uint16 `json:"code"`
+	PendingKey string `json:"pending_key"`
+	Stored     bool   `json:"stored"`
+	Error      string `json:"error"` //why the key could not be stored, empty when it was or was already held
+}
+
+//Serialize set if absent response message to node wire message
+func (sm *SetIfAbsentRspMessage) Serialize() *NodeWireMessage {
+	sm.Code = MsgSETNXRsp
+	data, _ := json.Marshal(sm)
+	return &NodeWireMessage{Code: MsgSETNXRsp, Data: data}
+}
+
+//DeSerialize node wire message into set if absent response message
+func (sm *SetIfAbsentRspMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, sm)
+}
@@ -0,0 +1,54 @@
+package message
+
+import "encoding/json"
+
+//phases of a cluster snapshot, in the order the node coordinating it asks every node to go through them
+const (
+	SnapshotPhasePause  = "pause"  //stop taking writes and send the writes still queued
+	SnapshotPhaseWrite  = "write"  //apply the writes expected from the other nodes then write the snapshot
+	SnapshotPhaseResume = "resume" //take writes again, not answered
+)
+
+//SnapshotReqMessage asks a remoteNode to go through a phase of a cluster snapshot
+type SnapshotReqMessage struct {
+	Code       uint16            `json:"code"`
+	Id         string            `json:"id"` //of the snapshot, the same on every node
+	PendingKey string            `json:"pending_key"`
+	Phase      string            `json:"phase"`
+	Dir        string            `json:"dir"`      //the snapshot is written to, on the write phase
+	Expected   map[string]uint64 `json:"expected"` //writes sent to the remoteNode by each node, on the write phase
+}
+
+//Serialize snapshot request message to node wire message
+func (sm *SnapshotReqMessage) Serialize() *NodeWireMessage {
+	sm.Code = MsgSNAPSHOTReq
+	data, _ := json.Marshal(sm)
+	return &NodeWireMessage{Code: MsgSNAPSHOTReq, Data: data}
+}
+
+//DeSerialize node wire message into snapshot request message
+func (sm *SnapshotReqMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, sm)
+}
+
+//SnapshotRspMessage answers a phase of a cluster snapshot
+type SnapshotRspMessage struct {
+	Code       uint16            `json:"code"`
+	PendingKey string            `json:"pending_key"`
+	Sent       map[string]uint64 `json:"sent"`    //writes sent to each node, on the pause phase
+	File       string            `json:"file"`    //the snapshot was written to, on the write phase
+	Entries    int               `json:"entries"` //in the snapshot, on the write phase
+	Error      string            `json:"error"`   //why the phase failed, empty when it did not
+}
+
+//Serialize snapshot response message to node wire message
+func (sm *SnapshotRspMessage) Serialize() *NodeWireMessage {
+	sm.Code = MsgSNAPSHOTRsp
+	data, _ := json.Marshal(sm)
+	return &NodeWireMessage{Code: MsgSNAPSHOTRsp, Data: data}
+}
+
+//DeSerialize node wire message into snapshot response message
+func (sm *SnapshotRspMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, sm)
+}
@@ -0,0 +1,80 @@
+package message
+
+import "encoding/json"
+
+//StatsSubscribeMessage asks a remoteNode to push its stats every interval until asked to stop or disconnected.
+//an interval of 0 ends the subscription
+type StatsSubscribeMessage struct {
+	Code     uint16 `json:"code"`
+	Interval int64  `json:"interval"` //milliseconds between two pushes
+}
+
+//Serialize stats subscribe message to node wire message
+func (sm *StatsSubscribeMessage) Serialize() *NodeWireMessage {
+	sm.Code = MsgSTATSSubscribe
+	data, _ := json.Marshal(sm)
+	return &NodeWireMessage{Code: MsgSTATSSubscribe, Data: data}
+}
+
+//DeSerialize node wire message into stats subscribe message
+func (sm *StatsSubscribeMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, sm)
+}
+
+//StatsPushMessage carries the stats of a remoteNode to a node subscribed to them
+type StatsPushMessage struct {
+	Code  uint16          `json:"code"`
+	Stats json.RawMessage `json:"stats"` //as reported by the admin server of the remoteNode
+}
+
+//Serialize stats push message to node wire message
+func (sm *StatsPushMessage) Serialize() *NodeWireMessage {
+	sm.Code = MsgSTATSPush
+	data, _ := json.Marshal(sm)
+	return &NodeWireMessage{Code: MsgSTATSPush, Data: data}
+}
+
+//DeSerialize node wire message into stats push message
+func (sm *StatsPushMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, sm)
+}
+
+//StatsReqMessage asks a remoteNode for its statistics once, along with those of every shard of its local cache
+//when Shards is set. it is answered with a StatsRspMessage
+type StatsReqMessage struct {
+	Code       uint16 `json:"code"`
+	Shards     bool   `json:"shards,omitempty"`
+	PendingKey string `json:"pending_key"`
+}
+
+//Serialize stats request message to node wire message
+func (sm *StatsReqMessage) Serialize() *NodeWireMessage {
+	sm.Code = MsgSTATSReq
+	data, _ := json.Marshal(sm)
+	return &NodeWireMessage{Code: MsgSTATSReq, Data: data}
+}
+
+//DeSerialize node wire message into stats request message
+func (sm *StatsReqMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, sm)
+}
+
+//StatsRspMessage carries the statistics a remoteNode was asked for
+type StatsRspMessage struct {
+	Code       uint16          `json:"code"`
+	PendingKey string          `json:"pending_key"`
+	Stats      json.RawMessage `json:"stats"`
+	Shards     json.RawMessage `json:"shards,omitempty"` //the statistics of every shard, when asked for
+}
+
+//Serialize stats response message to node wire message
+func (sm *StatsRspMessage) Serialize() *NodeWireMessage {
+	sm.Code = MsgSTATSRsp
+	data, _ := json.Marshal(sm)
+	return &NodeWireMessage{Code: MsgSTATSRsp, Data: data}
+}
+
+//DeSerialize node wire message into stats response message
+func (sm *StatsRspMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, sm)
+}
@@ -2,11 +2,17 @@ package message
 
 import "encoding/json"
 
-//SyncRspMessage is the struct that represents the response to a sync message
+//MaxSyncPeers is the most peers sent in a single sync response, longer peer lists are sent over several pages
+const MaxSyncPeers = 64
+
+//SyncRspMessage is the struct that represents the response to a sync message.
+//every page can be acted on as soon as it arrives, Page and Pages only tell the receiver how far along it is
 type SyncRspMessage struct {
 	Code              uint64         `json:"code"`
 	ReplicationFactor int            `json:"replication_factor"`
 	List              []ProposedPeer `json:"list"`
+	Page              int            `json:"page,omitempty"`  //starts at 1
+	Pages             int            `json:"pages,omitempty"` //not set by nodes that send the whole list at once
 }
 
 //Serialize sync response message to node wire message
@@ -0,0 +1,42 @@
+package message
+
+import "encoding/json"
+
+//TTLReqMessage asks a remoteNode how long its copy of a key has left before it expires
+type TTLReqMessage struct {
+	Code       uint16 `json:"code"`
+	Key        string `json:"key"`
+	PendingKey string `json:"pending_key"`
+}
+
+//Serialize ttl request message to node wire message
+func (tm *TTLReqMessage) Serialize() *NodeWireMessage {
+	tm.Code = MsgTTLReq
+	data, _ := json.Marshal(tm)
+	return &NodeWireMessage{Code: MsgTTLReq, Data: data}
+}
+
+//DeSerialize node wire message into ttl request message
+func (tm *TTLReqMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, tm)
+}
+
+//TTLRspMessage carries how long a remoteNode's copy of a key has left before it expires
+type TTLRspMessage struct {
+	Code       uint16 `json:"code"`
+	PendingKey string `json:"pending_key"`
+	Found      bool   `json:"found"`
+	TTL        int64  `json:"ttl"` //nanoseconds left, 0 if the copy never expires
+}
+
+//Serialize ttl response message to node wire message
+func (tm *TTLRspMessage) Serialize() *NodeWireMessage {
+	tm.Code = MsgTTLRsp
+	data, _ := json.Marshal(tm)
+	return &NodeWireMessage{Code: MsgTTLRsp, Data: data}
+}
+
+//DeSerialize node wire message into ttl response message
+func (tm *TTLRspMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, tm)
+}
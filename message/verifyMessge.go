@@ -4,10 +4,15 @@ import "encoding/json"
 
 //VerifyMessage is message struct for node verification
 type VerifyMessage struct {
-	Id          string `json:"id"`
-	Version     string `json:"version"`
-	ServicePort string `json:"service_port"`
-	Mode        byte   `json:"mode"`
+	Id              string `json:"id"`
+	Version         string `json:"version"`
+	ServicePort     string `json:"service_port"`
+	Mode            byte   `json:"mode"`
+	ProtocolVersion uint16 `json:"protocol_version"`
+	AdvertisedHost  string `json:"advertised_host,omitempty"` //host other nodes should connect to, empty if they are to use the connection's address
+	Role            byte   `json:"role,omitempty"`            //role the node asks its peers for, older nodes do not send it and are read write
+	Epoch           string `json:"epoch,omitempty"`           //changes every time the node starts, so its peers tell a restart from a reconnection
+	Weight          int    `json:"weight,omitempty"`          //capacity weight of the node, older nodes do not send it and weigh 1
 }
 
 //Serialize verify message to node wire message
@@ -2,13 +2,19 @@ package message
 
 //VerifyOKMessage is message for verification ok
 type VerifyOKMessage struct {
+	LaneToken string //the extra connections opened to the node that sent it must attach with, older nodes send none
 }
 
 //Serialize verify ok message to node wire message
 func (vm *VerifyOKMessage) Serialize() *NodeWireMessage {
-	return &NodeWireMessage{Code: MsgVERIFYOK, Data: nil}
+	var data []byte
+	if vm.LaneToken != "" {
+		data = []byte(vm.LaneToken)
+	}
+	return &NodeWireMessage{Code: MsgVERIFYOK, Data: data}
 }
 
 //DeSerialize node wire message into verify ok message
 func (vm *VerifyOKMessage) DeSerialize(msg *NodeWireMessage) {
+	vm.LaneToken = string(msg.Data)
 }
@@ -0,0 +1,41 @@
+package message
+
+import "encoding/json"
+
+//WatchMessage subscribes a passive client to the invalidation of keys starting with one of the prefixes.
+//it replaces any earlier subscription, no prefix at all ends the subscription
+type WatchMessage struct {
+	Code     uint16   `json:"code"`
+	Prefixes []string `json:"prefixes"`
+}
+
+//Serialize watch message to node wire message
+func (wm *WatchMessage) Serialize() *NodeWireMessage {
+	wm.Code = MsgWATCH
+	data, _ := json.Marshal(wm)
+	return &NodeWireMessage{Code: MsgWATCH, Data: data}
+}
+
+//DeSerialize node wire message into watch message
+func (wm *WatchMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, wm)
+}
+
+//InvalidateMessage tells a passive client that a key it watches was written or deleted
+type InvalidateMessage struct {
+	Code    uint16 `json:"code"`
+	Key     string `json:"key"`
+	Deleted bool   `json:"deleted"`
+}
+
+//Serialize invalidate message to node wire message
+func (im *InvalidateMessage) Serialize() *NodeWireMessage {
+	im.Code = MsgINVALIDATE
+	data, _ := json.Marshal(im)
+	return &NodeWireMessage{Code: MsgINVALIDATE, Data: data}
+}
+
+//DeSerialize node wire message into invalidate message
+func (im *InvalidateMessage) DeSerialize(msg *NodeWireMessage) {
+	json.Unmarshal(msg.Data, im)
+}
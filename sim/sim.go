@@ -0,0 +1,307 @@
+//Package sim replays access traces against a simulated cluster to project how it would behave before
+//it is deployed. every node is an in-process cache configured like a real one, messages between nodes are
+//only accounted for, never sent
+package sim
+
+import (
+	"container/heap"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+	"github.com/nggenius/ngbigcache/message"
+	"github.com/nggenius/ngbigcache/utils"
+)
+
+//stands in for the random suffix the cluster adds to the keys of pending get requests
+const pendingKeySuffix = "00000000"
+
+//Config describes the cluster a trace is replayed against
+type Config struct {
+	Nodes             int             //active nodes in the cluster
+	ReplicationFactor int             //nodes every key is stored on, 0 stores every key on every node as the cluster does
+	Weights           []int           //capacity weight of every node, keys are spread in proportion to them. nil weighs them all the same
+	TTL               time.Duration   //expiry of the keys written, 0 never expires them
+	Cache             bigcache.Config //cache of every node, bigcache.DefaultConfig() if Shards is not set
+}
+
+//NodeReport is the projected state of a node
+type NodeReport struct {
+	Entries       int   `json:"entries"`
+	LiveBytes     int   `json:"live_bytes"`      //bytes held by the entries stored, headers included
+	PeakLiveBytes int   `json:"peak_live_bytes"` //most bytes held at any point of the trace
+	CapacityBytes int   `json:"capacity_bytes"`  //bytes allocated by the cache
+	NoSpace       int64 `json:"no_space"`        //writes refused because the node was full
+}
+
+//Report is what replaying a trace projects
+type Report struct {
+	Accesses             int64         `json:"accesses"`
+	Gets                 int64         `json:"gets"`
+	Puts                 int64         `json:"puts"`
+	Deletes              int64         `json:"deletes"`
+	LocalHits            int64         `json:"local_hits"`  //reads served by the node they were made on
+	RemoteHits           int64         `json:"remote_hits"` //reads served by asking the other nodes
+	Misses               int64         `json:"misses"`
+	HitRatio             float64       `json:"hit_ratio"`
+	Evictions            int64         `json:"evictions"` //copies of keys removed because they expired
+	NoSpace              int64         `json:"no_space"`
+	ReplicationBytes     int64         `json:"replication_bytes"`     //bytes sent between nodes for puts and deletes
+	ReadBytes            int64         `json:"read_bytes"`            //bytes sent between nodes for reads that missed locally
+	Span                 time.Duration `json:"span"`                  //time between the first and last access replayed
+	ReplicationBandwidth float64       `json:"replication_bandwidth"` //replication bytes per second over the span
+	Nodes                []NodeReport  `json:"nodes"`
+}
+
+type expiring struct {
+	at  time.Time
+	key string
+}
+
+//keys ordered by the time they expire at
+type expiryHeap []expiring
+
+func (h expiryHeap) Len() int            { return len(h) }
+func (h expiryHeap) Less(i, j int) bool  { return h[i].at.Before(h[j].at) }
+func (h expiryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x interface{}) { *h = append(*h, x.(expiring)) }
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+//Simulator replays traces against a simulated cluster. accesses are made on the nodes in turn and keys
+//expire by the time of the trace rather than the wall clock, so a trace of days replays in seconds
+type Simulator struct {
+	config   Config
+	nodes    []*bigcache.BigCache
+	peak     []int
+	expiry   map[string]time.Time
+	expiring expiryHeap
+	next     int
+	buffer   []byte
+	first    time.Time
+	last     time.Time
+	report   Report
+}
+
+//New creates a simulated cluster
+func New(config Config) (*Simulator, error) {
+	if config.Nodes < 1 {
+		return nil, fmt.Errorf("a cluster needs at least one node")
+	}
+	if config.Weights != nil && len(config.Weights) != config.Nodes {
+		return nil, fmt.Errorf("%d weights given for %d nodes", len(config.Weights), config.Nodes)
+	}
+	if config.Cache.Shards == 0 {
+		config.Cache = bigcache.DefaultConfig()
+		config.Cache.Verbose = false
+	}
+
+	s := &Simulator{config: config, peak: make([]int, config.Nodes), expiry: make(map[string]time.Time)}
+	for x := 0; x < config.Nodes; x++ {
+		cache, err := bigcache.NewBigCache(config.Cache)
+		if err != nil {
+			return nil, err
+		}
+		s.nodes = append(s.nodes, cache)
+	}
+	return s, nil
+}
+
+//Run replays a trace against a new simulated cluster and reports the outcome
+func Run(config Config, trace []Access) (*Report, error) {
+	s, err := New(config)
+	if err != nil {
+		return nil, err
+	}
+	s.Replay(trace)
+	return s.Report(), nil
+}
+
+//Replay replays a trace, it can be called again with the rest of a long trace
+func (s *Simulator) Replay(trace []Access) {
+	for _, access := range trace {
+		if s.report.Accesses == 0 || access.Timestamp.Before(s.first) {
+			s.first = access.Timestamp
+		}
+		if access.Timestamp.After(s.last) {
+			s.last = access.Timestamp
+		}
+		s.report.Accesses++
+		s.expire(access.Timestamp)
+
+		origin := s.next
+		s.next = (s.next + 1) % len(s.nodes)
+		switch access.Op {
+		case OP_GET:
+			s.get(origin, access.Key)
+		case OP_PUT:
+			s.put(origin, access)
+		case OP_DEL:
+			s.del(origin, access.Key)
+		}
+	}
+}
+
+//the nodes a key is stored on
+func (s *Simulator) replicas(key string) []int {
+	rf := s.config.ReplicationFactor
+	if rf <= 0 || rf > len(s.nodes) {
+		rf = len(s.nodes)
+	}
+	if s.config.Weights != nil && rf < len(s.nodes) {
+		return s.weightedReplicas(key, rf)
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	start := int(h.Sum32() % uint32(len(s.nodes)))
+	if rf == len(s.nodes) {
+		start = 0
+	}
+
+	replicas := make([]int, rf)
+	for x := range replicas {
+		replicas[x] = (start + x) % len(s.nodes)
+	}
+	return replicas
+}
+
+//the rf nodes with the highest rendezvous scores for key, as the cluster weighs owners of keys
+func (s *Simulator) weightedReplicas(key string, rf int) []int {
+	nodes := make([]int, len(s.nodes))
+	scores := make([]float64, len(s.nodes))
+	for x := range nodes {
+		nodes[x] = x
+		scores[x] = utils.WeightedScore(strconv.Itoa(x), key, s.config.Weights[x])
+	}
+	sort.Slice(nodes, func(i, j int) bool { return scores[nodes[i]] > scores[nodes[j]] })
+	return nodes[:rf]
+}
+
+func (s *Simulator) isReplica(node int, key string) bool {
+	for _, r := range s.replicas(key) {
+		if r == node {
+			return true
+		}
+	}
+	return false
+}
+
+//bytes a message takes on the wire, framed as the cluster frames it
+func frameSize(msg message.NodeMessage) int64 {
+	return int64(6 + len(msg.Serialize().Data))
+}
+
+func (s *Simulator) get(origin int, key string) {
+	s.report.Gets++
+	if s.isReplica(origin, key) {
+		if _, err := s.nodes[origin].Get(key); err == nil {
+			s.report.LocalHits++
+			return
+		}
+	}
+
+	//not held locally so every other node is asked, and every one of them replies
+	found := false
+	for x, node := range s.nodes {
+		if x == origin {
+			continue
+		}
+		data, err := node.Get(key)
+		found = found || err == nil
+		s.report.ReadBytes += frameSize(&message.GetReqMessage{Key: key, PendingKey: key + pendingKeySuffix})
+		s.report.ReadBytes += frameSize(&message.GetRspMessage{PendingKey: key + pendingKeySuffix, Data: data})
+	}
+	if found {
+		s.report.RemoteHits++
+	} else {
+		s.report.Misses++
+	}
+}
+
+func (s *Simulator) put(origin int, access Access) {
+	s.report.Puts++
+	if cap(s.buffer) < access.Size {
+		s.buffer = make([]byte, access.Size)
+	}
+	value := s.buffer[:access.Size]
+
+	expiry := bigcache.NO_EXPIRY
+	if s.config.TTL > 0 {
+		at := access.Timestamp.Add(s.config.TTL)
+		s.expiry[access.Key] = at
+		heap.Push(&s.expiring, expiring{at: at, key: access.Key})
+		expiry = uint64(at.Unix())
+	} else {
+		delete(s.expiry, access.Key)
+	}
+
+	for _, r := range s.replicas(access.Key) {
+		s.nodes[r].Set(access.Key, value, 0) //expiry is kept by the simulator, by the time of the trace
+		if live := s.nodes[r].LiveBytes(); live > s.peak[r] {
+			s.peak[r] = live
+		}
+		if r != origin {
+			s.report.ReplicationBytes += frameSize(&message.PutMessage{Key: access.Key, Data: value, Expiry: expiry})
+		}
+	}
+}
+
+func (s *Simulator) del(origin int, key string) {
+	s.report.Deletes++
+	delete(s.expiry, key)
+	for _, r := range s.replicas(key) {
+		s.nodes[r].Delete(key)
+	}
+
+	//deletes are sent to every other node
+	s.report.ReplicationBytes += int64(len(s.nodes)-1) * frameSize(&message.DeleteMessage{Key: key})
+}
+
+//remove the keys that expired by now
+func (s *Simulator) expire(now time.Time) {
+	for len(s.expiring) > 0 && !s.expiring[0].at.After(now) {
+		e := heap.Pop(&s.expiring).(expiring)
+		if at, ok := s.expiry[e.key]; !ok || !at.Equal(e.at) { //rewritten or deleted since
+			continue
+		}
+		delete(s.expiry, e.key)
+		for _, r := range s.replicas(e.key) {
+			if s.nodes[r].Delete(e.key) == nil {
+				s.report.Evictions++
+			}
+		}
+	}
+}
+
+//Report returns what the accesses replayed so far project
+func (s *Simulator) Report() *Report {
+	report := s.report
+	if reads := report.LocalHits + report.RemoteHits + report.Misses; reads > 0 {
+		report.HitRatio = float64(report.LocalHits+report.RemoteHits) / float64(reads)
+	}
+	report.Span = s.last.Sub(s.first)
+	if report.Span > 0 {
+		report.ReplicationBandwidth = float64(report.ReplicationBytes) / report.Span.Seconds()
+	}
+
+	report.Nodes = make([]NodeReport, len(s.nodes))
+	for x, node := range s.nodes {
+		report.Nodes[x] = NodeReport{
+			Entries:       node.Len(),
+			LiveBytes:     node.LiveBytes(),
+			PeakLiveBytes: s.peak[x],
+			CapacityBytes: node.Capacity(),
+			NoSpace:       node.Stats().NoSpace,
+		}
+		report.NoSpace += report.Nodes[x].NoSpace
+	}
+	return &report
+}
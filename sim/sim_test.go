@@ -0,0 +1,123 @@
+package sim
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nggenius/ngbigcache/bigcache"
+)
+
+func testConfig(nodes, rf int, ttl time.Duration) Config {
+	cache := bigcache.DefaultConfig()
+	cache.Shards = 1
+	cache.MaxEntriesInWindow = 10
+	cache.MaxEntrySize = 256
+	cache.Verbose = false
+	return Config{Nodes: nodes, ReplicationFactor: rf, TTL: ttl, Cache: cache}
+}
+
+func TestReadTrace(t *testing.T) {
+	trace, err := ReadTrace(strings.NewReader("# timestamp,op,key,size\n1000,put,a,100\n1500,GET,a\n2000,del,a,0\n"))
+	if err != nil || len(trace) != 3 {
+		t.Fatalf("expected 3 accesses, got %d: %v", len(trace), err)
+	}
+	if trace[0].Op != OP_PUT || trace[0].Key != "a" || trace[0].Size != 100 || trace[0].Timestamp.Unix() != 1 {
+		t.Errorf("put not read right: %+v", trace[0])
+	}
+	if trace[1].Op != OP_GET || trace[2].Op != OP_DEL {
+		t.Error("operations not read right")
+	}
+
+	for _, bad := range []string{"x,get,a\n", "1000,put,a\n", "1000,scan,a\n", "1000,put,a,-1\n", "1000\n"} {
+		if _, err := ReadTrace(strings.NewReader(bad)); err == nil {
+			t.Errorf("'%s' ought to be refused", strings.TrimSpace(bad))
+		}
+	}
+}
+
+func TestFullReplication(t *testing.T) {
+	start := time.Unix(1000, 0)
+	trace := []Access{
+		{Timestamp: start, Op: OP_PUT, Key: "a", Size: 100},                     //made on node 0
+		{Timestamp: start.Add(time.Second), Op: OP_GET, Key: "a"},               //node 1 holds a replica
+		{Timestamp: start.Add(2 * time.Second), Op: OP_GET, Key: "b"},           //missing everywhere
+		{Timestamp: start.Add(3 * time.Second), Op: OP_DEL, Key: "a"},           //made on node 1
+		{Timestamp: start.Add(4 * time.Second), Op: OP_PUT, Key: "c", Size: 10}, //made on node 0
+	}
+	report, err := Run(testConfig(2, 0, 0), trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.LocalHits != 1 || report.RemoteHits != 0 || report.Misses != 1 || report.HitRatio != 0.5 {
+		t.Errorf("unexpected reads %+v", report)
+	}
+	if report.ReplicationBytes == 0 || report.ReadBytes == 0 || report.Span != 4*time.Second {
+		t.Errorf("unexpected bandwidth %+v", report)
+	}
+	for x, node := range report.Nodes {
+		if node.Entries != 1 || node.LiveBytes == 0 || node.PeakLiveBytes <= node.LiveBytes {
+			t.Errorf("unexpected state of node %d %+v", x, node)
+		}
+	}
+}
+
+func TestShardedReplication(t *testing.T) {
+	start := time.Unix(1000, 0)
+	var trace []Access
+	for x := 0; x < 3; x++ { //every node reads the key, only one holds it
+		trace = append(trace, Access{Timestamp: start, Op: OP_GET, Key: "a"})
+	}
+	s, err := New(testConfig(3, 1, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Replay([]Access{{Timestamp: start, Op: OP_PUT, Key: "a", Size: 10}, {Timestamp: start, Op: OP_GET, Key: "z"}, {Timestamp: start, Op: OP_GET, Key: "z"}})
+	s.Replay(trace)
+	report := s.Report()
+	if report.LocalHits != 1 || report.RemoteHits != 2 {
+		t.Errorf("expected 1 local and 2 remote hits, got %+v", report)
+	}
+
+	s.Replay([]Access{{Timestamp: start.Add(time.Minute), Op: OP_GET, Key: "a"}})
+	report = s.Report()
+	if report.Evictions != 1 || report.Misses != 3 {
+		t.Errorf("the key ought to have expired, got %+v", report)
+	}
+	entries := 0
+	for _, node := range report.Nodes {
+		entries += node.Entries
+	}
+	if entries != 0 {
+		t.Errorf("expected no entries left, got %d", entries)
+	}
+}
+
+func TestWeightedReplication(t *testing.T) {
+	config := testConfig(2, 1, 0)
+	config.Weights = []int{1, 3}
+	start := time.Unix(1000, 0)
+	var trace []Access
+	for x := 0; x < 2000; x++ {
+		trace = append(trace, Access{Timestamp: start, Op: OP_PUT, Key: "key_" + strconv.Itoa(x), Size: 10})
+	}
+	report, err := Run(config, trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	light, heavy := report.Nodes[0].Entries, report.Nodes[1].Entries
+	if light+heavy != 2000 {
+		t.Fatalf("expected every key on one node, got %d and %d", light, heavy)
+	}
+	if ratio := float64(heavy) / float64(light); ratio < 2.5 || ratio > 3.5 {
+		t.Errorf("expected the node weighing 3 to hold about 3 times the keys, got %d and %d", light, heavy)
+	}
+
+	config.Weights = []int{1}
+	if _, err := New(config); err == nil {
+		t.Error("weights not matching the nodes ought to be refused")
+	}
+}
@@ -0,0 +1,84 @@
+package sim
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Operations of an access trace
+const (
+	OP_GET byte = iota
+	OP_PUT
+	OP_DEL
+)
+
+//Access is one entry of an access trace
+type Access struct {
+	Timestamp time.Time
+	Op        byte
+	Key       string
+	Size      int //bytes written by a put, ignored for other operations
+}
+
+//ReadTrace reads an access trace written as CSV, one access per line as "timestamp,op,key,size" where the
+//timestamp is in unix milliseconds, op is get, put or del and size may be left out for gets and deletes.
+//lines starting with # are skipped
+func ReadTrace(r io.Reader) ([]Access, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var trace []Access
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return trace, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		access, err := parseAccess(record)
+		if err != nil {
+			return nil, fmt.Errorf("access %d of the trace: %v", len(trace)+1, err)
+		}
+		trace = append(trace, access)
+	}
+}
+
+func parseAccess(record []string) (Access, error) {
+	if len(record) < 3 || len(record) > 4 {
+		return Access{}, fmt.Errorf("expected 3 or 4 fields, got %d", len(record))
+	}
+
+	millis, err := strconv.ParseInt(record[0], 10, 64)
+	if err != nil {
+		return Access{}, fmt.Errorf("bad timestamp '%s'", record[0])
+	}
+	access := Access{Timestamp: time.Unix(0, millis*int64(time.Millisecond)), Key: record[2]}
+
+	switch strings.ToLower(record[1]) {
+	case "get":
+		access.Op = OP_GET
+	case "put":
+		access.Op = OP_PUT
+	case "del":
+		access.Op = OP_DEL
+	default:
+		return Access{}, fmt.Errorf("unknown operation '%s'", record[1])
+	}
+
+	if len(record) == 4 {
+		if access.Size, err = strconv.Atoi(record[3]); err != nil || access.Size < 0 {
+			return Access{}, fmt.Errorf("bad size '%s'", record[3])
+		}
+	} else if access.Op == OP_PUT {
+		return Access{}, fmt.Errorf("a put needs a size")
+	}
+	return access, nil
+}
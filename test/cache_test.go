@@ -2,6 +2,19 @@ package test
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -57,3 +70,1220 @@ func TestExpiry(t *testing.T) {
 		t.Error("value ought to have expired")
 	}
 }
+
+func TestSetUntil(t *testing.T) {
+	bc, _ := bigcache.NewBigCache(bigcache.DefaultConfig())
+	if _, err := bc.SetUntil("one", []byte("one"), time.Now().Add(-time.Second)); err != bigcache.ErrExpiryInPast {
+		t.Error("setting an entry that expires in the past ought to fail")
+	}
+
+	bc.SetUntil("one", []byte("one"), time.Now().Add(time.Second*2))
+	val, _ := bc.Get("one")
+	if !bytes.Equal(val, []byte("one")) {
+		t.Error("returned value ought to be equal 'one'")
+	}
+	time.Sleep(time.Second * 3)
+	val, _ = bc.Get("one")
+	if nil != val {
+		t.Error("value ought to have expired at the given time")
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	bc, _ := bigcache.NewBigCache(bigcache.DefaultConfig())
+	for x := 0; x < 5000; x++ {
+		bc.Set("key_"+strconv.Itoa(x), []byte("value_"+strconv.Itoa(x)), 0)
+	}
+	bc.Set("expiring", []byte("expiring"), time.Minute)
+
+	for _, codec := range []bigcache.SnapshotCodec{nil, bigcache.ZstdCodec, noCompression{}} {
+		var buf bytes.Buffer
+		written, err := bc.Snapshot(&buf, bigcache.SnapshotOptions{Codec: codec, Workers: 4, ChunkSize: 4096})
+		if err != nil || written != 5001 {
+			t.Fatalf("snapshot ought to hold every entry, wrote %d [%v]", written, err)
+		}
+
+		restored, _ := bigcache.NewBigCache(bigcache.DefaultConfig())
+		count, err := restored.Restore(&buf, bigcache.SnapshotOptions{Workers: 3})
+		if err != nil || count != 5001 || restored.Len() != 5001 {
+			t.Fatalf("every entry ought to be restored, restored %d [%v]", count, err)
+		}
+		for x := 0; x < 5000; x += 499 {
+			val, _ := restored.Get("key_" + strconv.Itoa(x))
+			if string(val) != "value_"+strconv.Itoa(x) {
+				t.Errorf("restored value of key_%d ought to be value_%d, got %q", x, x, val)
+			}
+		}
+	}
+
+	if _, err := bc.Restore(bytes.NewReader([]byte("not a snapshot")), bigcache.SnapshotOptions{}); err == nil {
+		t.Error("restoring garbage ought to fail")
+	}
+
+	//a chunk claiming to decompress to far more than it holds
+	for _, codec := range []bigcache.SnapshotCodec{nil, bigcache.ZstdCodec} {
+		var buf bytes.Buffer
+		bc.Snapshot(&buf, bigcache.SnapshotOptions{Codec: codec})
+		snapshot := buf.Bytes()
+		chunk := 10 + 4 + int(binary.LittleEndian.Uint32(snapshot[10:]))*5*8
+		binary.LittleEndian.PutUint32(snapshot[chunk+4:], math.MaxUint32)
+		restored, _ := bigcache.NewBigCache(bigcache.DefaultConfig())
+		if _, err := restored.Restore(bytes.NewReader(snapshot), bigcache.SnapshotOptions{}); err != bigcache.ErrBadSnapshot {
+			t.Errorf("a chunk with a forged size ought to be refused, got %v", err)
+		}
+	}
+}
+
+// a codec registered from outside the package
+type noCompression struct{}
+
+func (noCompression) ID() byte { return 200 }
+
+func (noCompression) Compress(dst io.Writer, src []byte, level int) error {
+	_, err := dst.Write(src)
+	return err
+}
+
+func (noCompression) Decompress(src []byte, rawSize int) ([]byte, error) { return src, nil }
+
+func init() {
+	bigcache.RegisterSnapshotCodec(noCompression{})
+}
+
+func TestNoSpace(t *testing.T) {
+	config := bigcache.DefaultConfig()
+	config.Shards = 1
+	config.MaxEntriesInWindow = 10
+	config.MaxEntrySize = 256
+	config.HardMaxCacheSize = 1
+	config.Verbose = false
+	bc, _ := bigcache.NewBigCache(config)
+
+	_, err := bc.Set("too_large", make([]byte, 1024*1024*2), 0)
+	if err == nil || bc.Stats().NoSpace != 1 {
+		t.Error("a write larger than the whole cache ought to fail and be counted")
+	}
+
+	if _, err := bc.Set("0", []byte("value"), 0); err != nil {
+		t.Error("the shard ought to still be usable after a failed write")
+	}
+}
+
+func TestHardMaxCacheSize(t *testing.T) {
+	config := bigcache.DefaultConfig()
+	config.Shards = 4
+	config.MaxEntriesInWindow = 10
+	config.MaxEntrySize = 256
+	config.HardMaxCacheSize = 1
+	config.Verbose = false
+	evicted := make(map[string]bigcache.RemoveReason)
+	config.OnRemoveWithReason = func(key string, entry []byte, reason bigcache.RemoveReason) {
+		evicted[key] = reason
+	}
+	bc, _ := bigcache.NewBigCache(config)
+
+	value := make([]byte, 1024*64)
+	for x := 0; x < 64; x++ {
+		if _, err := bc.Set(strconv.Itoa(x), value, 0); err != nil {
+			t.Fatalf("writes to a full cache ought to evict the oldest entries, got %v", err)
+		}
+	}
+
+	if used := bc.Stats().BytesUsed; used > 1024*1024 || used != int64(bc.LiveBytes()) {
+		t.Errorf("the bytes used ought to be within the limit, got %d", used)
+	}
+	if reason, found := evicted["0"]; !found || reason != bigcache.NoSpace {
+		t.Error("the oldest entry ought to be evicted for no space")
+	}
+	if _, err := bc.Get("63"); err != nil {
+		t.Error("the newest entry ought to be kept")
+	}
+	if len(evicted)+bc.Len() != 64 {
+		t.Errorf("every entry ought to be either kept or evicted, %d evicted and %d kept", len(evicted), bc.Len())
+	}
+
+	bc.Delete("63")
+	if evicted["63"] != bigcache.Deleted {
+		t.Error("a deleted entry ought to be removed for being deleted")
+	}
+}
+
+func TestOverwriteNoSpace(t *testing.T) {
+	config := bigcache.DefaultConfig()
+	config.Shards = 1
+	config.MaxEntriesInWindow = 10
+	config.MaxEntrySize = 256
+	config.HardMaxCacheSize = 1
+	config.Verbose = false
+	bc, _ := bigcache.NewBigCache(config)
+
+	bc.Set("key", []byte("value"), 0)
+	_, err := bc.Set("key", make([]byte, 1024*1024*2), 0)
+	if _, ok := err.(*bigcache.EntryLostError); !ok {
+		t.Fatalf("an overwrite that does not fit ought to fail with EntryLostError, got %v", err)
+	}
+	if _, err := bc.Get("key"); err == nil || bc.Len() != 0 {
+		t.Error("the key ought to be left without a value")
+	}
+}
+
+func TestLiveBytes(t *testing.T) {
+	config := bigcache.DefaultConfig()
+	config.Shards = 4
+	config.MaxEntriesInWindow = 100
+	config.MaxEntrySize = 256
+	config.Verbose = false
+	bc, _ := bigcache.NewBigCache(config)
+
+	value := make([]byte, 100)
+	for x := 0; x < 10; x++ {
+		bc.Set(strconv.Itoa(x), value, 0)
+	}
+	full := bc.LiveBytes()
+	if bc.Len() != 10 || full < 10*len(value) {
+		t.Errorf("expected 10 entries holding at least %d bytes, got %d entries holding %d bytes", 10*len(value), bc.Len(), full)
+	}
+
+	bc.Set("0", make([]byte, 50), 0) // overwriting an entry replaces its bytes
+	if bc.Len() != 10 || bc.LiveBytes() != full-50 {
+		t.Errorf("expected 10 entries holding %d bytes, got %d entries holding %d bytes", full-50, bc.Len(), bc.LiveBytes())
+	}
+
+	for x := 0; x < 10; x++ {
+		bc.Delete(strconv.Itoa(x))
+	}
+	if bc.Len() != 0 || bc.LiveBytes() != 0 {
+		t.Errorf("expected an empty cache, got %d entries holding %d bytes", bc.Len(), bc.LiveBytes())
+	}
+	if bc.Capacity() == 0 {
+		t.Error("the allocated capacity ought to be kept after deleting")
+	}
+}
+
+func TestTTL(t *testing.T) {
+	config := bigcache.DefaultConfig()
+	config.Shards = 1
+	config.MaxEntriesInWindow = 10
+	config.MaxEntrySize = 256
+	config.Verbose = false
+	bc, _ := bigcache.NewBigCache(config)
+
+	bc.Set("expiring", []byte("value"), time.Minute)
+	bc.Set("forever", []byte("value"), time.Duration(bigcache.NO_EXPIRY))
+
+	if ttl, err := bc.TTL("expiring"); err != nil || ttl <= 58*time.Second || ttl > time.Minute {
+		t.Errorf("expected about a minute left, got %s: %v", ttl, err)
+	}
+	if ttl, err := bc.TTL("forever"); err != nil || ttl != time.Duration(bigcache.NO_EXPIRY) {
+		t.Errorf("expected no expiry, got %s: %v", ttl, err)
+	}
+	if _, err := bc.TTL("missing"); err == nil {
+		t.Error("a missing key ought not to have a ttl")
+	}
+	if bc.Stats().Hits != 0 {
+		t.Error("inspecting the ttl ought not to count as a read")
+	}
+}
+
+func TestAppendReadRange(t *testing.T) {
+	config := bigcache.DefaultConfig()
+	config.Shards = 1
+	config.MaxEntriesInWindow = 10
+	config.MaxEntrySize = 256
+	config.Verbose = false
+	bc, _ := bigcache.NewBigCache(config)
+
+	bc.Append("events", [][]byte{[]byte("a"), []byte("b")}, 3, time.Minute)
+	bc.Append("events", [][]byte{[]byte("c"), []byte("d")}, 3, time.Duration(bigcache.NO_EXPIRY))
+
+	items, err := bc.ReadRange("events", 0, -1)
+	if err != nil || len(items) != 3 || string(bytes.Join(items, nil)) != "bcd" {
+		t.Errorf("expected the oldest item to be dropped, got %q: %v", items, err)
+	}
+	if items, _ = bc.ReadRange("events", -2, 10); string(bytes.Join(items, nil)) != "cd" {
+		t.Errorf("expected the last two items, got %q", items)
+	}
+	if items, _ = bc.ReadRange("events", 2, 1); len(items) != 0 {
+		t.Errorf("expected an empty range, got %q", items)
+	}
+	if ttl, err := bc.TTL("events"); err != nil || ttl <= 58*time.Second {
+		t.Errorf("expected appending to keep the expiry of the list, got %s: %v", ttl, err)
+	}
+
+	bc.Set("plain", []byte("value"), 0)
+	if _, err := bc.ReadRange("plain", 0, -1); err != bigcache.ErrNotList {
+		t.Errorf("expected a plain entry not to be read as a list, got %v", err)
+	}
+	if _, err := bc.Append("plain", [][]byte{[]byte("a")}, 0, 0); err != bigcache.ErrNotList {
+		t.Errorf("expected a plain entry not to be appended to, got %v", err)
+	}
+}
+
+func TestSetType(t *testing.T) {
+	config := bigcache.DefaultConfig()
+	config.Shards = 1
+	config.MaxEntriesInWindow = 10
+	config.MaxEntrySize = 256
+	config.Verbose = false
+	bc, _ := bigcache.NewBigCache(config)
+
+	if added, err := bc.SAdd("seen", [][]byte{[]byte("id_1"), []byte("id_2"), []byte("id_1")}, time.Minute); err != nil || added != 2 {
+		t.Errorf("expected two members to be added, got %d: %v", added, err)
+	}
+	if added, _ := bc.SAdd("seen", [][]byte{[]byte("id_2"), []byte("id_3")}, 0); added != 1 {
+		t.Errorf("expected only the new member to be added, got %d", added)
+	}
+	for _, id := range []string{"id_1", "id_2", "id_3"} {
+		if found, err := bc.SIsMember("seen", []byte(id)); err != nil || !found {
+			t.Errorf("expected %s to be a member: %v", id, err)
+		}
+	}
+	if found, _ := bc.SIsMember("seen", []byte("id_4")); found {
+		t.Error("id_4 ought not to be a member")
+	}
+	if members, err := bc.SMembers("seen"); err != nil || len(members) != 3 {
+		t.Errorf("expected three members, got %q: %v", members, err)
+	}
+	if ttl, err := bc.TTL("seen"); err != nil || ttl <= 58*time.Second {
+		t.Errorf("expected adding to keep the expiry of the set, got %s: %v", ttl, err)
+	}
+
+	bc.Set("plain", []byte("value"), 0)
+	if _, err := bc.SIsMember("plain", []byte("value")); err != bigcache.ErrNotSet {
+		t.Errorf("expected a plain entry not to be read as a set, got %v", err)
+	}
+}
+
+func BenchmarkSetWithExpiry(b *testing.B) {
+	config := bigcache.DefaultConfig()
+	config.Verbose = false
+	bc, _ := bigcache.NewBigCache(config)
+	value := []byte("value")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		x := 0
+		for pb.Next() { //short lived keys spread over a minute of expiry times
+			bc.Set(strconv.Itoa(x%100000), value, time.Second*time.Duration(1+x%60))
+			x++
+		}
+	})
+}
+
+func BenchmarkReplaceWithExpiry(b *testing.B) {
+	config := bigcache.DefaultConfig()
+	config.Verbose = false
+	bc, _ := bigcache.NewBigCache(config)
+	value := []byte("value")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for x := 0; x < b.N; x++ { //every write takes the key out of the ttl manager before putting it back
+		bc.Set(strconv.Itoa(x%1000), value, time.Minute+time.Second*time.Duration(x%3600))
+	}
+}
+
+func TestCompact(t *testing.T) {
+	config := bigcache.DefaultConfig()
+	config.Shards = 2
+	config.MaxEntriesInWindow = 10
+	config.MaxEntrySize = 256
+	config.Verbose = false
+	bc, _ := bigcache.NewBigCache(config)
+
+	for x := 0; x < 1000; x++ {
+		bc.Set("key_"+strconv.Itoa(x), []byte("value_"+strconv.Itoa(x)), time.Minute)
+	}
+	for x := 0; x < 1000; x += 2 {
+		bc.Delete("key_" + strconv.Itoa(x))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if reclaimed, more := bc.Compact(ctx, time.Second); reclaimed != 0 || !more {
+		t.Errorf("a cancelled compaction ought to leave the holes, reclaimed %d", reclaimed)
+	}
+
+	reclaimed, more := bc.Compact(context.Background(), time.Second*5)
+	if reclaimed <= 0 || more {
+		t.Errorf("expected every hole to be reclaimed, reclaimed %d with more %v", reclaimed, more)
+	}
+	if bc.Stats().Reclaimed != int64(reclaimed) {
+		t.Errorf("the bytes reclaimed ought to be in the stats, got %d", bc.Stats().Reclaimed)
+	}
+	for x := 1; x < 1000; x += 2 {
+		if val, err := bc.Get("key_" + strconv.Itoa(x)); err != nil || string(val) != "value_"+strconv.Itoa(x) {
+			t.Fatalf("key_%d ought to be intact after compacting, got %q: %v", x, val, err)
+		}
+	}
+
+	bc.Set("key_new", []byte("value_new"), time.Minute)
+	if val, _ := bc.Get("key_new"); string(val) != "value_new" {
+		t.Error("writes ought to land in the reclaimed space")
+	}
+}
+
+func TestBackgroundCompact(t *testing.T) {
+	config := bigcache.DefaultConfig()
+	config.Shards = 2
+	config.MaxEntriesInWindow = 10
+	config.MaxEntrySize = 256
+	config.CompactInterval = time.Millisecond * 50
+	config.Verbose = false
+	bc, _ := bigcache.NewBigCache(config)
+
+	for x := 0; x < 1000; x++ {
+		bc.Set("key_"+strconv.Itoa(x), []byte("value_"+strconv.Itoa(x)), time.Minute)
+	}
+	for x := 0; x < 1000; x += 2 {
+		bc.Delete("key_" + strconv.Itoa(x))
+	}
+	time.Sleep(time.Millisecond * 300)
+
+	if bc.Stats().Reclaimed <= 0 {
+		t.Error("the holes ought to be reclaimed in the background")
+	}
+	for x := 1; x < 1000; x += 2 {
+		if val, err := bc.Get("key_" + strconv.Itoa(x)); err != nil || string(val) != "value_"+strconv.Itoa(x) {
+			t.Fatalf("key_%d ought to be intact after compacting, got %q: %v", x, val, err)
+		}
+	}
+
+	//no compaction once closed
+	bc.Close()
+	for x := 1; x < 1000; x += 2 {
+		bc.Delete("key_" + strconv.Itoa(x))
+	}
+	reclaimed := bc.Stats().Reclaimed
+	time.Sleep(time.Millisecond * 200)
+	if bc.Stats().Reclaimed != reclaimed {
+		t.Error("the background compaction ought to stop once the cache is closed")
+	}
+	bc.Close()
+}
+
+func TestMemoryUsage(t *testing.T) {
+	config := bigcache.DefaultConfig()
+	config.MaxEntriesInWindow = 100000
+	config.MaxEntrySize = 64
+	config.Verbose = false
+	value := make([]byte, 32)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	bc, _ := bigcache.NewBigCache(config)
+	for x := 0; x < 200000; x++ { //the keys are kept by the cache to expire them
+		bc.Set("key_"+strconv.Itoa(x), value, time.Hour)
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+
+	measured := float64(after.HeapAlloc - before.HeapAlloc)
+	usage := bc.MemoryUsage()
+	if usage.Index <= 0 || usage.TTL <= 0 || usage.Queues != bc.Capacity() {
+		t.Errorf("every part of the memory ought to be accounted for, got %+v", usage)
+	}
+	if estimated := float64(usage.Total); estimated < measured*0.75 || estimated > measured*1.25 {
+		t.Errorf("estimated %d bytes but the heap grew by %d bytes", usage.Total, int(measured))
+	}
+	runtime.KeepAlive(bc)
+}
+
+func TestMmapQueues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ngbigcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := bigcache.DefaultConfig()
+	config.Shards = 2
+	config.MaxEntriesInWindow = 10
+	config.MaxEntrySize = 256
+	config.Verbose = false
+	config.MmapDir = dir
+	bc, err := bigcache.NewBigCache(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for x := 0; x < 1000; x++ { //more than the initial size of the files so they have to grow
+		bc.Set("key_"+strconv.Itoa(x), []byte("value_"+strconv.Itoa(x)), time.Minute)
+	}
+	for x := 0; x < 1000; x++ {
+		if val, err := bc.Get("key_" + strconv.Itoa(x)); err != nil || string(val) != "value_"+strconv.Itoa(x) {
+			t.Fatalf("key_%d ought to be kept in the mapped files, got %q: %v", x, val, err)
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.queue"))
+	if len(files) != config.Shards {
+		t.Errorf("expected a file per shard, got %v", files)
+	}
+
+	if err := bc.Close(); err != nil {
+		t.Error(err)
+	}
+	if _, err := bc.Set("key_closed", []byte("value"), time.Minute); err == nil {
+		t.Error("a closed cache ought not to store entries")
+	}
+
+	config.MmapDir = filepath.Join(dir, "missing")
+	if _, err := bigcache.NewBigCache(config); err == nil {
+		t.Error("a cache ought not to be created without the directory of its files")
+	}
+}
+
+func TestGetReader(t *testing.T) {
+	config := bigcache.DefaultConfig()
+	config.Shards = 1
+	config.MaxEntriesInWindow = 10
+	config.MaxEntrySize = 256
+	config.Verbose = false
+	bc, _ := bigcache.NewBigCache(config)
+
+	value := bytes.Repeat([]byte("0123456789"), 100)
+	bc.Set("key_0", []byte("value_0"), time.Minute)
+	bc.Set("key_1", value, time.Minute)
+
+	if _, err := bc.GetReader("key_missing"); err == nil {
+		t.Error("expected no reader for a missing key")
+	}
+	reader, err := bc.GetReader("key_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bc.Delete("key_0") //leaves a hole before the pinned entry
+	bc.Delete("key_1")
+	bc.Compact(context.Background(), time.Second)
+	for x := 0; x < 100; x++ { //would be written over the entry were it not pinned
+		bc.Set("key_"+strconv.Itoa(x+2), bytes.Repeat([]byte{'x'}, 50), time.Minute)
+	}
+
+	read, err := ioutil.ReadAll(reader)
+	if err != nil || !bytes.Equal(read, value) {
+		t.Fatalf("expected the pinned entry to be read intact, got %d bytes: %v", len(read), err)
+	}
+	reader.Close()
+	if n, _ := reader.Read(make([]byte, 10)); n != 0 {
+		t.Error("a closed reader ought not to read anything")
+	}
+
+	if _, more := bc.Compact(context.Background(), time.Second); more {
+		t.Error("expected the space of the unpinned entry to be reclaimed")
+	}
+	for x := 0; x < 100; x++ {
+		if val, err := bc.Get("key_" + strconv.Itoa(x+2)); err != nil || len(val) != 50 {
+			t.Fatalf("key_%d ought to be intact, got %q: %v", x+2, val, err)
+		}
+	}
+
+	bc.Set("key_1", value, time.Minute)
+	reader, _ = bc.GetReader("key_1")
+	bc.Reset()
+	bc.Set("key_1", []byte("value_1"), time.Minute)
+	if read, _ := ioutil.ReadAll(reader); !bytes.Equal(read, value) {
+		t.Error("expected a reader open while the cache is reset to read the entry intact")
+	}
+	reader.Close()
+}
+
+func TestAppendBytes(t *testing.T) {
+	config := bigcache.DefaultConfig()
+	config.Shards = 1
+	config.MaxEntriesInWindow = 10
+	config.MaxEntrySize = 256
+	config.Verbose = false
+	bc, _ := bigcache.NewBigCache(config)
+
+	bc.AppendBytes("log", []byte("a"), time.Minute)
+	bc.AppendBytes("log", []byte("bc"), time.Duration(bigcache.NO_EXPIRY))
+	if val, err := bc.Get("log"); err != nil || string(val) != "abc" {
+		t.Errorf("expected the bytes to be appended, got %q: %v", val, err)
+	}
+	if ttl, err := bc.TTL("log"); err != nil || ttl <= 58*time.Second {
+		t.Errorf("expected appending to keep the expiry of the entry, got %s: %v", ttl, err)
+	}
+
+	var wg sync.WaitGroup
+	for x := 0; x < 10; x++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for y := 0; y < 100; y++ {
+				bc.AppendBytes("counter", []byte{1}, 0)
+			}
+		}()
+	}
+	wg.Wait()
+	if val, _ := bc.Get("counter"); len(val) != 1000 {
+		t.Errorf("expected no append to be lost to a concurrent one, got %d bytes", len(val))
+	}
+
+	if _, err := bc.AppendBytesUntil("log", []byte("d"), time.Now().Add(-time.Second)); err != bigcache.ErrExpiryInPast {
+		t.Errorf("expected an expiry in the past to be refused, got %v", err)
+	}
+}
+
+func TestRemovalStats(t *testing.T) {
+	config := bigcache.DefaultConfig()
+	config.Shards = 4
+	config.MaxEntriesInWindow = 10
+	config.MaxEntrySize = 256
+	config.HardMaxCacheSize = 1
+	config.Verbose = false
+	bc, _ := bigcache.NewBigCache(config)
+
+	value := make([]byte, 1024*64)
+	for x := 0; x < 20; x++ {
+		bc.Set(strconv.Itoa(x), value, 0)
+	}
+	bc.Set("19", []byte("newer"), 0)
+	bc.Delete("19")
+	bc.Set("expiring", []byte("expiring"), time.Second)
+	time.Sleep(time.Second * 3)
+
+	stats := bc.Stats()
+	if stats.Evicted == 0 || stats.Overwritten != 1 || stats.Deleted != 1 || stats.Expired != 1 {
+		t.Fatalf("every removal ought to be counted by its reason, got %+v", stats)
+	}
+	var evicted int64
+	for _, shard := range bc.ShardStats() {
+		evicted += shard.Evicted
+	}
+	if evicted != stats.Evicted || len(bc.ShardStats()) != 4 {
+		t.Errorf("the stats of the shards ought to add up to those of the cache, got %d evicted", evicted)
+	}
+
+	live := int64(bc.Len())
+	bc.Reset()
+	if cleared := bc.Stats().Cleared; cleared != live {
+		t.Errorf("the entries dropped by a reset ought to be counted, got %d of %d", cleared, live)
+	}
+
+	var buf bytes.Buffer
+	if _, err := bc.Snapshot(&buf, bigcache.SnapshotOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	restored, _ := bigcache.NewBigCache(config)
+	if _, err := restored.Restore(&buf, bigcache.SnapshotOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.Stats(); got.Evicted != stats.Evicted || got.Expired != 1 || got.Cleared != live {
+		t.Errorf("the removal counts ought to be restored with the snapshot, got %+v", got)
+	}
+}
+
+func TestSetIfAbsent(t *testing.T) {
+	bc, _ := bigcache.NewBigCache(bigcache.DefaultConfig())
+
+	var wg sync.WaitGroup
+	var stored int32
+	for x := 0; x < 50; x++ {
+		wg.Add(1)
+		go func(x int) {
+			defer wg.Done()
+			if ok, err := bc.SetIfAbsent("lock", []byte(strconv.Itoa(x)), time.Minute); err == nil && ok {
+				atomic.AddInt32(&stored, 1)
+			}
+		}(x)
+	}
+	wg.Wait()
+	if stored != 1 {
+		t.Errorf("exactly one caller ought to store the key, %d did", stored)
+	}
+
+	if ok, err := bc.SetIfAbsentUntil("lock", []byte("late"), time.Now().Add(time.Minute)); ok || err != nil {
+		t.Errorf("a key already held ought not to be stored again, got %v: %v", ok, err)
+	}
+	if _, err := bc.SetIfAbsentUntil("other", []byte("late"), time.Now().Add(-time.Second)); err != bigcache.ErrExpiryInPast {
+		t.Errorf("an expiry in the past ought to be refused, got %v", err)
+	}
+
+	bc.Delete("lock")
+	if ok, err := bc.SetIfAbsent("lock", []byte("again"), 0); !ok || err != nil {
+		t.Errorf("a deleted key ought to be stored again, got %v: %v", ok, err)
+	}
+}
+
+func TestMaxValueSize(t *testing.T) {
+	config := bigcache.DefaultConfig()
+	config.MaxValueSize = 8
+	config.Verbose = false
+	bc, _ := bigcache.NewBigCache(config)
+
+	_, err := bc.Set("large", []byte("more than eight"), 0)
+	if tooLarge, ok := err.(*bigcache.ValueTooLargeError); !ok || tooLarge.Size != 15 || tooLarge.Limit != 8 {
+		t.Fatalf("a value over the limit ought to be refused with a ValueTooLargeError, got %v", err)
+	}
+	if _, err := bc.Get("large"); err == nil || bc.Len() != 0 {
+		t.Error("a value over the limit ought not to be stored")
+	}
+	if _, err := bc.SetIfAbsent("large", []byte("more than eight"), 0); err == nil {
+		t.Error("a value over the limit ought to be refused by SetIfAbsent too")
+	}
+
+	bc.Set("log", []byte("1234"), 0)
+	if _, err := bc.AppendBytes("log", []byte("56789"), 0); err == nil {
+		t.Error("an append growing the value over the limit ought to be refused")
+	}
+	if val, _ := bc.Get("log"); string(val) != "1234" {
+		t.Errorf("a refused append ought to leave the value as it was, got %q", val)
+	}
+}
+
+func TestLPushRPop(t *testing.T) {
+	bc, _ := bigcache.NewBigCache(bigcache.DefaultConfig())
+
+	if _, err := bc.LPush("queue", [][]byte{[]byte("a"), []byte("b")}, 0, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	bc.LPush("queue", [][]byte{[]byte("c")}, 0, 0)
+	if items, err := bc.ReadRange("queue", 0, -1); err != nil || len(items) != 3 || string(items[0]) != "c" || string(items[2]) != "a" {
+		t.Errorf("expected the items pushed last first, got %q %v", items, err)
+	}
+	for _, want := range []string{"a", "b", "c"} {
+		if item, err := bc.RPop("queue"); err != nil || string(item) != want {
+			t.Errorf("expected '%s' popped, got '%s' %v", want, item, err)
+		}
+	}
+	if _, err := bc.RPop("queue"); err != bigcache.ErrListEmpty {
+		t.Errorf("expected nothing left to pop, got %v", err)
+	}
+	if _, err := bc.RPop("unknown"); !errors.Is(err, bigcache.ErrEntryNotFound) {
+		t.Errorf("expected no list under a missing key, got %v", err)
+	}
+
+	bc.LPush("recent", [][]byte{[]byte("1"), []byte("2"), []byte("3"), []byte("4")}, 2, 0)
+	if items, err := bc.ReadRange("recent", 0, -1); err != nil || len(items) != 2 || string(items[0]) != "4" || string(items[1]) != "3" {
+		t.Errorf("expected the 2 items pushed last kept, got %q %v", items, err)
+	}
+}
+
+func TestKeysWithPrefix(t *testing.T) {
+	config := bigcache.DefaultConfig()
+	config.IndexKeys = true
+	bc, _ := bigcache.NewBigCache(config)
+
+	for _, key := range []string{"user:3", "user:1", "user:2", "user:10", "order:1", "user"} {
+		bc.Set(key, []byte(key), 0)
+	}
+	bc.SetNotFound("user:missing", time.Minute)
+	if count, err := bc.CountPrefix("user:"); err != nil || count != 4 {
+		t.Errorf("expected 4 keys with the prefix, got %d %v", count, err)
+	}
+	if count, _ := bc.CountPrefix(""); count != 6 {
+		t.Errorf("expected every key with the empty prefix, got %d", count)
+	}
+	if keys, err := bc.KeysWithPrefix("user:", 3); err != nil || !reflect.DeepEqual(keys, []string{"user:1", "user:10", "user:2"}) {
+		t.Errorf("expected the first 3 keys with the prefix in order, got %q %v", keys, err)
+	}
+	if keys, _ := bc.KeysWithPrefixAfter("user:", "user:10", 0); !reflect.DeepEqual(keys, []string{"user:2", "user:3"}) {
+		t.Errorf("expected the keys after the last of the page, got %q", keys)
+	}
+
+	bc.Delete("user:2")
+	bc.Set("user:1", []byte("again"), 0)
+	if keys, _ := bc.KeysWithPrefix("user:", 0); !reflect.DeepEqual(keys, []string{"user:1", "user:10", "user:3"}) {
+		t.Errorf("expected the deleted key gone and the overwritten one listed once, got %q", keys)
+	}
+	if err := bc.Reshard(64, nil); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := bc.CountPrefix("user:"); count != 3 {
+		t.Errorf("expected the keys still indexed after resharding, got %d", count)
+	}
+	bc.Reset()
+	if count, _ := bc.CountPrefix(""); count != 0 {
+		t.Errorf("expected no key indexed after a reset, got %d", count)
+	}
+
+	plain, _ := bigcache.NewBigCache(bigcache.DefaultConfig())
+	if _, err := plain.CountPrefix("user:"); err != bigcache.ErrNotIndexed {
+		t.Errorf("expected keys not indexed by default, got %v", err)
+	}
+}
+
+func TestHash(t *testing.T) {
+	bc, _ := bigcache.NewBigCache(bigcache.DefaultConfig())
+
+	if added, err := bc.HSet("session", map[string][]byte{"user": []byte("u1"), "cart": []byte("c1")}, time.Minute); added != 2 || err != nil {
+		t.Fatalf("expected 2 fields added, got %d: %v", added, err)
+	}
+	if added, err := bc.HSet("session", map[string][]byte{"cart": []byte("c2"), "lang": []byte("en")}, 0); added != 1 || err != nil {
+		t.Errorf("expected 1 field added and 1 replaced, got %d: %v", added, err)
+	}
+	if value, err := bc.HGet("session", "cart"); err != nil || string(value) != "c2" {
+		t.Errorf("expected the replaced field, got '%s' %v", value, err)
+	}
+	if _, err := bc.HGet("session", "missing"); err != bigcache.ErrFieldNotFound {
+		t.Errorf("expected a missing field not found, got %v", err)
+	}
+	if ttl, err := bc.TTL("session"); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("expected the hash to keep its expiry, got %s %v", ttl, err)
+	}
+
+	if removed, err := bc.HDel("session", []string{"user", "missing"}); removed != 1 || err != nil {
+		t.Errorf("expected 1 field removed, got %d: %v", removed, err)
+	}
+	if removed, err := bc.HDel("unknown", []string{"user"}); removed != 0 || err != nil {
+		t.Errorf("expected nothing removed from a missing hash, got %d: %v", removed, err)
+	}
+	fields, err := bc.HGetAll("session")
+	if err != nil || len(fields) != 2 || string(fields["cart"]) != "c2" || string(fields["lang"]) != "en" {
+		t.Errorf("expected the fields left, got %v %v", fields, err)
+	}
+
+	bc.Set("plain", []byte("not a hash"), 0)
+	if _, err := bc.HSet("plain", map[string][]byte{"a": nil}, 0); err != bigcache.ErrNotHash {
+		t.Errorf("expected a value which is not a hash refused, got %v", err)
+	}
+}
+
+func TestCounter(t *testing.T) {
+	bc, _ := bigcache.NewBigCache(bigcache.DefaultConfig())
+
+	if counts, err := bc.CounterAdd("hits", "node_1", 2, time.Minute); err != nil || counts["node_1"] != 2 {
+		t.Fatalf("expected the count of the node added to, got %v %v", counts, err)
+	}
+	bc.CounterAdd("hits", "node_1", 3, 0)
+	if counts, err := bc.CounterMerge("hits", map[string]uint64{"node_1": 1, "node_2": 4}, 0); err != nil ||
+		counts["node_1"] != 5 || counts["node_2"] != 4 {
+		t.Errorf("expected the larger count of each node kept, got %v %v", counts, err)
+	}
+	bc.CounterMerge("hits", map[string]uint64{"node_2": 4}, 0) //merging the same counts again changes nothing
+	if value, err := bc.CounterValue("hits"); err != nil || value != 9 {
+		t.Errorf("expected the sum of the counts, got %d %v", value, err)
+	}
+	if ttl, err := bc.TTL("hits"); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("expected the counter to keep its expiry, got %s %v", ttl, err)
+	}
+
+	if _, err := bc.CounterMergeUntil("new", map[string]uint64{"node_1": 1}, time.Now().Add(-time.Minute)); err != bigcache.ErrExpiryInPast {
+		t.Errorf("expected an expiry in the past refused, got %v", err)
+	}
+	bc.HSet("session", map[string][]byte{"user": []byte("u1")}, 0)
+	if _, err := bc.CounterAdd("session", "node_1", 1, 0); err != bigcache.ErrNotCounter {
+		t.Errorf("expected a value which is not a counter refused, got %v", err)
+	}
+}
+
+func TestDeleteIfEqual(t *testing.T) {
+	bc, _ := bigcache.NewBigCache(bigcache.DefaultConfig())
+	bc.Set("lock", []byte("token"), 0)
+
+	if deleted, err := bc.DeleteIfEqual("lock", []byte("other")); deleted || err != nil {
+		t.Errorf("expected a key holding another entry kept, got %v: %v", deleted, err)
+	}
+	if deleted, err := bc.DeleteIfEqual("lock", []byte("token")); !deleted || err != nil {
+		t.Errorf("expected a key holding the entry deleted, got %v: %v", deleted, err)
+	}
+	if _, err := bc.Get("lock"); err == nil {
+		t.Error("expected the key gone")
+	}
+	if deleted, err := bc.DeleteIfEqual("lock", []byte("token")); deleted || !errors.Is(err, bigcache.ErrEntryNotFound) {
+		t.Errorf("expected a missing key not deleted, got %v: %v", deleted, err)
+	}
+}
+
+func TestSetNotFound(t *testing.T) {
+	bc, _ := bigcache.NewBigCache(bigcache.DefaultConfig())
+
+	if _, err := bc.SetNotFound("missing", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.Get("missing"); !errors.Is(err, bigcache.ErrCachedNotFound) || !errors.Is(err, bigcache.ErrEntryNotFound) {
+		t.Errorf("expected a key cached as not found, got %v", err)
+	}
+	if _, err := bc.Get("unknown"); err == nil || errors.Is(err, bigcache.ErrCachedNotFound) {
+		t.Errorf("expected a missing key not cached as not found, got %v", err)
+	}
+	if _, err := bc.GetReader("missing"); !errors.Is(err, bigcache.ErrCachedNotFound) {
+		t.Errorf("expected no reader of a key cached as not found, got %v", err)
+	}
+	if ok, err := bc.SetIfAbsent("missing", []byte("value"), 0); !ok || err != nil {
+		t.Errorf("expected a key cached as not found to be absent, got %v: %v", ok, err)
+	}
+	if data, err := bc.Get("missing"); err != nil || string(data) != "value" {
+		t.Errorf("expected the value written over the key cached as not found, got '%s' %v", data, err)
+	}
+
+	bc.SetNotFound("missing", time.Minute)
+	var buf bytes.Buffer
+	if _, err := bc.Snapshot(&buf, bigcache.SnapshotOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	restored, _ := bigcache.NewBigCache(bigcache.DefaultConfig())
+	if _, err := restored.Restore(&buf, bigcache.SnapshotOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restored.Get("missing"); !errors.Is(err, bigcache.ErrCachedNotFound) {
+		t.Errorf("expected the key restored as not found, got %v", err)
+	}
+	if err := restored.Reshard(32, nil); err != nil {
+		t.Fatal(err)
+	}
+	it := restored.Iterator()
+	if !it.SetNext() {
+		t.Fatal("expected the key cached as not found iterated")
+	}
+	if entry, err := it.Value(); err != nil || !entry.NotFound() || entry.Key() != "missing" {
+		t.Errorf("expected the key still cached as not found after resharding, got %v %v", entry.NotFound(), err)
+	}
+
+	if _, err := bc.SetNotFound(string(make([]byte, 1<<15)), time.Minute); err != bigcache.ErrKeyTooLong {
+		t.Errorf("expected a key too long for an entry refused, got %v", err)
+	}
+}
+
+func TestErrorsIs(t *testing.T) {
+	config := bigcache.DefaultConfig()
+	config.Shards = 1
+	config.MaxEntriesInWindow = 10
+	config.MaxEntrySize = 256
+	config.HardMaxCacheSize = 1
+	config.Verbose = false
+	bc, _ := bigcache.NewBigCache(config)
+
+	_, err := bc.Get("missing")
+	if !errors.Is(err, bigcache.ErrEntryNotFound) {
+		t.Errorf("a missing key ought to match ErrEntryNotFound, got %v", err)
+	}
+	var notFound *bigcache.EntryNotFoundError
+	if !errors.As(err, &notFound) || notFound.Key != "missing" {
+		t.Errorf("a missing key ought to be an EntryNotFoundError naming it, got %v", err)
+	}
+	if _, err := bc.TTL("missing"); !errors.Is(err, bigcache.ErrEntryNotFound) {
+		t.Errorf("the ttl of a missing key ought to match ErrEntryNotFound, got %v", err)
+	}
+
+	if _, err := bc.Set("too_large", make([]byte, 1024*1024*2), 0); !errors.Is(err, bigcache.ErrShardFull) {
+		t.Errorf("a write larger than the whole cache ought to match ErrShardFull, got %v", err)
+	}
+	bc.Set("key", []byte("value"), 0)
+	_, err = bc.Set("key", make([]byte, 1024*1024*2), 0)
+	var lost *bigcache.EntryLostError
+	if !errors.As(err, &lost) || !errors.Is(err, bigcache.ErrShardFull) {
+		t.Errorf("a lost overwrite ought to be an EntryLostError matching ErrShardFull, got %v", err)
+	}
+}
+
+func TestReshard(t *testing.T) {
+	config := bigcache.DefaultConfig()
+	config.Shards = 4
+	config.MaxEntriesInWindow = 10
+	config.MaxEntrySize = 256
+	config.Verbose = false
+	bc, _ := bigcache.NewBigCache(config)
+
+	for x := 0; x < 2000; x++ {
+		bc.Set(strconv.Itoa(x), []byte("value_"+strconv.Itoa(x)), time.Hour)
+	}
+	bc.Get("0")
+
+	if err := bc.Reshard(6, nil); err == nil {
+		t.Error("a number of shards that is not a power of two ought to be refused")
+	}
+
+	var last bigcache.ReshardProgress
+	done := make(chan struct{})
+	go func() { //reads and writes carry on while the entries are moved
+		defer close(done)
+		for x := 2000; x < 3000; x++ {
+			bc.Set(strconv.Itoa(x), []byte("value_"+strconv.Itoa(x)), 0)
+			if val, err := bc.Get(strconv.Itoa(x - 2000)); err != nil || string(val) != "value_"+strconv.Itoa(x-2000) {
+				t.Errorf("an entry ought to be readable while resharding, got %q: %v", val, err)
+				return
+			}
+		}
+	}()
+	if err := bc.Reshard(16, func(p bigcache.ReshardProgress) { last = p }); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	if bc.Shards() != 16 || last.Remaining != 0 || last.Moved == 0 {
+		t.Errorf("the entries ought to be spread over 16 shards, got %d shards and %+v", bc.Shards(), last)
+	}
+	if bc.Len() != 3000 || len(bc.ShardStats()) != 16 {
+		t.Fatalf("every entry ought to be kept, got %d", bc.Len())
+	}
+	for x := 0; x < 3000; x++ {
+		if val, err := bc.Get(strconv.Itoa(x)); err != nil || string(val) != "value_"+strconv.Itoa(x) {
+			t.Fatalf("entry %d ought to be kept, got %q: %v", x, val, err)
+		}
+	}
+	if ttl, _ := bc.TTL("0"); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("a moved entry ought to keep its expiry, got %s", ttl)
+	}
+	if bc.Stats().Hits < 3001 {
+		t.Errorf("the counts of the former shards ought to be kept, got %d hits", bc.Stats().Hits)
+	}
+
+	if err := bc.Reshard(2, nil); err != nil || bc.Shards() != 2 || bc.Len() != 3000 {
+		t.Errorf("the entries ought to be gathered in fewer shards too, got %d in %d shards: %v", bc.Len(), bc.Shards(), err)
+	}
+	if err := bc.DoubleShards(nil); err != nil || bc.Shards() != 4 || bc.Len() != 3000 {
+		t.Errorf("the entries ought to be spread over twice the shards, got %d in %d shards: %v", bc.Len(), bc.Shards(), err)
+	}
+}
+
+// puts every key in the same shard under the same hash
+type constantHasher struct{}
+
+func (constantHasher) Sum64(string) uint64 {
+	return 5
+}
+
+func TestShardStats(t *testing.T) {
+	config := bigcache.DefaultConfig()
+	config.Shards = 4
+	config.MaxEntriesInWindow = 10
+	config.MaxEntrySize = 256
+	config.Verbose = false
+	bc, _ := bigcache.NewBigCache(config)
+
+	for x := 0; x < 100; x++ {
+		bc.Set(strconv.Itoa(x), []byte("value"), 0)
+	}
+	entries, used, capacity := 0, int64(0), 0
+	for _, shard := range bc.ShardStats() {
+		entries += shard.Entries
+		used += shard.BytesUsed
+		capacity += shard.Capacity
+		if shard.Entries == 0 || shard.Utilization <= 0 || shard.Utilization > 1 {
+			t.Errorf("every shard ought to hold some of the entries, got %+v", shard)
+		}
+	}
+	if entries != bc.Len() || used != int64(bc.LiveBytes()) || capacity != bc.Capacity() {
+		t.Errorf("the stats of the shards ought to add up to those of the cache, got %d entries of %d bytes in %d", entries, used, capacity)
+	}
+
+	config.Hasher = constantHasher{}
+	skewed, _ := bigcache.NewBigCache(config)
+	skewed.Set("a", []byte("value"), 0)
+	skewed.Get("b")
+	stats := skewed.ShardStats()
+	if stats[1].Entries != 1 || stats[1].Collisions != 1 || stats[0].Entries+stats[2].Entries+stats[3].Entries != 0 {
+		t.Errorf("a hasher putting every key in one shard ought to show in its stats, got %+v", stats)
+	}
+}
+
+func TestLockStripes(t *testing.T) {
+	config := bigcache.DefaultConfig()
+	config.Verbose = false
+	config.LockStripes = 6
+	if _, err := bigcache.NewBigCache(config); err == nil {
+		t.Fatal("expected a number of lock stripes that is not a power of two to be refused")
+	}
+
+	config.LockStripes = 8
+	cache, err := bigcache.NewBigCache(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for x := 0; x < 1000; x++ {
+				key := "key_" + strconv.Itoa(x)
+				if w%2 == 0 {
+					cache.Set(key, []byte(key), 0)
+				} else if data, err := cache.Get(key); err == nil && string(data) != key {
+					t.Errorf("expected %s, got %s", key, data)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for x := 0; x < 1000; x++ {
+		key := "key_" + strconv.Itoa(x)
+		if data, err := cache.Get(key); err != nil || string(data) != key {
+			t.Fatalf("expected %s, got %s: %v", key, data, err)
+		}
+	}
+	if err := cache.Reshard(32, nil); err != nil || cache.Len() != 1000 {
+		t.Errorf("expected the 1000 entries to be resharded, got %d: %v", cache.Len(), err)
+	}
+}
+
+func BenchmarkParallelGet(b *testing.B) {
+	for _, stripes := range []int{1, 16} {
+		b.Run("stripes_"+strconv.Itoa(stripes), func(b *testing.B) {
+			config := bigcache.DefaultConfig()
+			config.Verbose = false
+			config.Shards = 1 //every read of the same shard, where a single lock contends the most
+			config.LockStripes = stripes
+			bc, _ := bigcache.NewBigCache(config)
+			for x := 0; x < 1000; x++ {
+				bc.Set(strconv.Itoa(x), []byte("value"), 0)
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				x := 0
+				for pb.Next() {
+					bc.Get(strconv.Itoa(x % 1000))
+					x++
+				}
+			})
+		})
+	}
+}
+
+func TestAppendValue(t *testing.T) {
+	config := bigcache.DefaultConfig()
+	config.Verbose = false
+	cache, _ := bigcache.NewBigCache(config)
+	cache.Set("key", []byte("value"), time.Minute)
+
+	buf := make([]byte, 0, 64)
+	data, expiry, err := cache.AppendValue(append(buf, "prefix:"...), "key")
+	if err != nil || string(data) != "prefix:value" || expiry == bigcache.NO_EXPIRY {
+		t.Fatalf("expected the value appended with its expiry, got %q %d: %v", data, expiry, err)
+	}
+	if &data[0] != &buf[:1][0] {
+		t.Error("expected the value appended in the room of the buffer given")
+	}
+	if _, _, err := cache.AppendValue(buf, "missing"); !errors.Is(err, bigcache.ErrEntryNotFound) {
+		t.Errorf("expected a missing key not to be found, got %v", err)
+	}
+}
+
+func BenchmarkGetAllocations(b *testing.B) {
+	config := bigcache.DefaultConfig()
+	config.Verbose = false
+	bc, _ := bigcache.NewBigCache(config)
+	bc.Set("key", make([]byte, 512), 0)
+
+	b.Run("Get", func(b *testing.B) {
+		b.ReportAllocs()
+		for x := 0; x < b.N; x++ {
+			bc.Get("key")
+		}
+	})
+	b.Run("AppendValue", func(b *testing.B) {
+		buf := make([]byte, 0, 1024)
+		b.ReportAllocs()
+		for x := 0; x < b.N; x++ {
+			bc.AppendValue(buf[:0], "key")
+		}
+	})
+}
+
+func TestSlowLockWait(t *testing.T) {
+	config := bigcache.DefaultConfig()
+	config.Verbose = false
+	config.LockWaitBudget = time.Nanosecond //every wait is over it
+	var slow int32
+	config.OnSlowLock = func(shard, hashedKey uint64, waited time.Duration) {
+		if waited < config.LockWaitBudget {
+			t.Errorf("expected only waits over the budget reported, got %s", waited)
+		}
+		atomic.AddInt32(&slow, 1)
+	}
+	cache, err := bigcache.NewBigCache(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("key_1", []byte("data_1"), 0)
+	cache.Get("key_1")
+	cache.Delete("key_1")
+	if atomic.LoadInt32(&slow) != 3 {
+		t.Errorf("expected the set, get and delete timed, got %d reported", slow)
+	}
+}
+
+func TestManualClock(t *testing.T) {
+	clock := bigcache.NewManualClock(time.Unix(1000000, 0))
+	config := bigcache.DefaultConfig()
+	config.Verbose = false
+	config.Clock = clock
+	cache, err := bigcache.NewBigCache(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("key_1", []byte("data_1"), time.Second*10)
+	clock.Advance(time.Second * 4)
+	if ttl, err := cache.TTL("key_1"); err != nil || ttl != time.Second*6 {
+		t.Errorf("expected the ttl read from the clock, got %s %v", ttl, err)
+	}
+
+	expired := false
+	for i := 0; i < 50 && !expired; i++ { //a second at a time, the wheel moves on once its timer is reset
+		clock.Advance(time.Second)
+		time.Sleep(time.Millisecond * 10)
+		_, err = cache.Get("key_1")
+		expired = errors.Is(err, bigcache.ErrEntryNotFound)
+	}
+	if !expired {
+		t.Error("expected the key expired once the clock moved past its expiry")
+	}
+	if now := clock.Now(); now.Sub(time.Unix(1000000, 0)) > time.Second*20 {
+		t.Errorf("expected the key expired soon after the clock moved past its expiry, at %s", now)
+	}
+
+	fired := make(chan struct{})
+	clock.AfterFunc(time.Minute, func() { close(fired) })
+	ticker := clock.NewTicker(time.Second * 20)
+	clock.Advance(time.Second * 59)
+	select {
+	case <-fired:
+		t.Error("expected the timer not fired before it is due")
+	case <-ticker.C():
+	case <-time.After(time.Second):
+		t.Error("expected the ticker fired")
+	}
+	clock.Advance(time.Second)
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Error("expected the timer fired once due")
+	}
+
+	select {
+	case <-ticker.C():
+	default:
+	}
+	ticker.Reset(time.Second * 5)
+	clock.Advance(time.Second * 4)
+	select {
+	case <-ticker.C():
+		t.Error("expected the ticker not fired before its new interval")
+	default:
+	}
+	clock.Advance(time.Second)
+	select {
+	case <-ticker.C():
+	case <-time.After(time.Second):
+		t.Error("expected the ticker fired at its new interval")
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	config := bigcache.DefaultConfig()
+	if errs := config.Validate(); len(errs) != 0 {
+		t.Errorf("expected the default configuration valid, got %v", errs)
+	}
+
+	config.Shards = 12
+	config.LockStripes = 3
+	config.MaxValueSize = -1
+	config.MmapDir = filepath.Join(os.TempDir(), "ngbigcache-no-such-dir")
+	if errs := config.Validate(); len(errs) != 4 {
+		t.Errorf("expected every problem of the configuration listed, got %v", errs)
+	}
+	_, err := bigcache.NewBigCache(config)
+	if configErr, ok := err.(*bigcache.ConfigError); !ok || len(configErr.Errors) != 4 {
+		t.Errorf("expected the cache refused with the problems of its configuration, got %v", err)
+	}
+}
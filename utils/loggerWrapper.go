@@ -27,3 +27,23 @@ func Critical(logger AppLogger, msg string) {
 		logger.Critical(msg)
 	}
 }
+
+//prefixLogger adds a prefix in front of every message of the logger it wraps
+type prefixLogger struct {
+	logger AppLogger
+	prefix string
+}
+
+//WithPrefix returns logger with prefix added in front of every message, so the messages of several nodes in the
+//same process can be told apart. a nil logger stays nil
+func WithPrefix(logger AppLogger, prefix string) AppLogger {
+	if nil == logger || "" == prefix {
+		return logger
+	}
+	return &prefixLogger{logger: logger, prefix: prefix}
+}
+
+func (l *prefixLogger) Info(msg string)     { l.logger.Info(l.prefix + msg) }
+func (l *prefixLogger) Warn(msg string)     { l.logger.Warn(l.prefix + msg) }
+func (l *prefixLogger) Critical(msg string) { l.logger.Critical(l.prefix + msg) }
+func (l *prefixLogger) Error(msg string)    { l.logger.Error(l.prefix + msg) }
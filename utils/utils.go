@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"hash/fnv"
+	"math"
 	"math/rand"
 	"sync"
 	"time"
@@ -37,3 +39,27 @@ func GenerateNodeId(n int) string {
 
 	return string(b)
 }
+
+//WeightedScore is the rendezvous hashing score of the node id for key. of a set of nodes the one with the highest
+//score owns the key, and every node owns a share of the keys in proportion to its weight. weights below 1 count
+//as 1, and nodes of equal weight are ordered by the hash of their id and the key alone
+func WeightedScore(id, key string, weight int) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	if weight < 1 {
+		weight = 1
+	}
+
+	//fnv alone leaves the hashes of keys differing in their last bytes too alike, mix it as murmur3 finishes
+	sum := h.Sum64()
+	sum ^= sum >> 33
+	sum *= 0xff51afd7ed558ccd
+	sum ^= sum >> 33
+	sum *= 0xc4ceb9fe1a85ec53
+	sum ^= sum >> 33
+
+	u := (float64(sum>>11) + 0.5) / (1 << 53) //uniform in (0, 1)
+	return -float64(weight) / math.Log(u)
+}